@@ -0,0 +1,353 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// workspaceLine is location of line indexed by LeakScanner
+type workspaceLine struct {
+	file   int // index of LeakScanner.files
+	line   int // 1-based line number
+	length int
+	hash   uint64 // hash of whole line (FNV-1a)
+}
+
+// LeakScanner finds verbatim occurrences of workspace lines in text.
+// Each line (leading/trailing spaces are trimmed) not shorter than MinLength is indexed by hash of
+// its first MinLength bytes, so text is scanned by rolling hash of the same window size.
+// Contents of lines are not kept in memory
+type LeakScanner struct {
+	MinLength int
+	files     []string                   // relative path of workspace files
+	lines     map[uint64][]workspaceLine // key is rolling hash of first MinLength bytes
+	pow       uint64                     // rollingBase^(MinLength-1)
+}
+
+const rollingBase = 1099511628211
+
+// skipped directories of version control systems
+var leakScanSkipDirs = []string{".git", ".hg", ".svn"}
+
+func NewLeakScanner(minLength int) *LeakScanner {
+	s := &LeakScanner{MinLength: minLength, lines: map[uint64][]workspaceLine{}, pow: 1}
+	for i := 1; i < minLength; i++ {
+		s.pow *= rollingBase
+	}
+	return s
+}
+
+func rollingHash(data string) uint64 {
+	var h uint64
+	for i := 0; i < len(data); i++ {
+		h = h*rollingBase + uint64(data[i])
+	}
+	return h
+}
+
+func fullHash(data string) uint64 {
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, data)
+	return h.Sum64()
+}
+
+// AddFile indexes lines of file content. Binary file (containing NUL) is ignored
+func (s *LeakScanner) AddFile(name string, content []byte) {
+	if bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0 {
+		return
+	}
+	file := -1
+	for num, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < s.MinLength {
+			continue
+		}
+		if file < 0 {
+			file = len(s.files)
+			s.files = append(s.files, name)
+		}
+		key := rollingHash(line[:s.MinLength])
+		s.lines[key] = append(s.lines[key], workspaceLine{file: file, line: num + 1, length: len(line), hash: fullHash(line)})
+	}
+}
+
+// AddWorkspace indexes all regular files under dir (directories of version control systems are skipped)
+func (s *LeakScanner) AddWorkspace(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			for _, skip := range leakScanSkipDirs {
+				if entry.Name() == skip {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			name = path
+		}
+		s.AddFile(filepath.ToSlash(name), content)
+		return nil
+	})
+}
+
+// Scan calls fn with each workspace line verbatim occurring in text
+func (s *LeakScanner) Scan(text string, fn func(file string, line int)) {
+	if len(text) < s.MinLength || len(s.lines) == 0 {
+		return
+	}
+	h := rollingHash(text[:s.MinLength])
+	for i := 0; ; i++ {
+		for _, l := range s.lines[h] {
+			if i+l.length <= len(text) && fullHash(text[i:i+l.length]) == l.hash {
+				fn(s.files[l.file], l.line)
+			}
+		}
+		if i+s.MinLength >= len(text) {
+			return
+		}
+		h = (h-uint64(text[i])*s.pow)*rollingBase + uint64(text[i+s.MinLength])
+	}
+}
+
+// Leak is workspace lines found in string of payload
+type Leak struct {
+	Seq   int
+	Path  string // JSON path within payload (empty if payload is not JSON)
+	File  string
+	Lines []int
+}
+
+func (l *Leak) String() string {
+	path := l.Path
+	if path == "" {
+		path = "(raw payload)"
+	}
+	var lines []string
+	for i, line := range l.Lines {
+		if i == 10 {
+			lines = append(lines, fmt.Sprintf("... (%d lines)", len(l.Lines)))
+			break
+		}
+		lines = append(lines, strconv.Itoa(line))
+	}
+	return fmt.Sprintf("seq %d at %s: %s lines %s", l.Seq, path, l.File, strings.Join(lines, ", "))
+}
+
+// walkJSONStrings calls fn with each string (and object key) of JSON value and its path like $.params.text
+func walkJSONStrings(v any, path string, fn func(path string, s string)) {
+	switch v := v.(type) {
+	case string:
+		fn(path, v)
+	case []any:
+		for i, e := range v {
+			walkJSONStrings(e, fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			p := path + "." + key
+			fn(p, key) // keys may be text too (e.g. uri of WorkspaceEdit.changes)
+			walkJSONStrings(v[key], p, fn)
+		}
+	}
+}
+
+// FindLeaks scans every payload of log (strings of JSON payload, or whole non-JSON payload)
+// and returns workspace lines found in it
+func (s *LeakScanner) FindLeaks(reader io.Reader) ([]*Leak, error) {
+	var leaks []*Leak
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return leaks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		found := map[string]*Leak{} // key is path and file
+		scan := func(path string, text string) {
+			s.Scan(text, func(file string, line int) {
+				key := path + "\x00" + file
+				leak, ok := found[key]
+				if !ok {
+					leak = &Leak{Seq: d.seq, Path: path, File: file}
+					found[key] = leak
+					leaks = append(leaks, leak)
+				}
+				if !slices.Contains(leak.Lines, line) { // same line may occur several times
+					leak.Lines = append(leak.Lines, line)
+				}
+			})
+		}
+		var v any
+		if d.payloadType == JSON && json.Unmarshal(d.payload, &v) == nil {
+			walkJSONStrings(v, "$", scan)
+		} else {
+			scan("", string(d.payload))
+		}
+	}
+}
+
+// anonymizedRoot is root directory of anonymized paths
+const anonymizedRoot = "/W"
+
+var (
+	// file URI or absolute path having at least two components (not preceded by word character, so that
+	// method like textDocument/hover and rate like 100/s are not matched)
+	anonymizedPathPattern = regexp.MustCompile(`file://(?:/[^\s"'<>()\[\]{},;?#]*)?|(?:^|[^\w$./-])(?:/[\w.@+%~-]+){2,}/?`)
+	drivePattern          = regexp.MustCompile(`^[A-Za-z](?::|%3[Aa])$`)
+)
+
+// URIAnonymizer rewrites file URIs and absolute paths into paths under /W (e.g. file:///W/d1/d2/f1.go).
+// Each path component is mapped consistently within a session, so prefix relation of paths
+// (e.g. rootUri and uri of document) and extensions of files are preserved
+type URIAnonymizer struct {
+	dirs  map[string]string // component of directory -> d<N>
+	files map[string]string // last component having extension -> f<N>.<ext>
+}
+
+func NewURIAnonymizer() *URIAnonymizer {
+	return &URIAnonymizer{dirs: map[string]string{}, files: map[string]string{}}
+}
+
+// anonymizePath maps each component of absolute path (drive letter is kept)
+func (a *URIAnonymizer) anonymizePath(path string) string {
+	if path == "" || path == "/" {
+		return anonymizedRoot + path
+	}
+	components := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	mapped := []string{anonymizedRoot}
+	for i, component := range components {
+		switch ext := filepath.Ext(component); {
+		case component == "" || drivePattern.MatchString(component):
+			mapped = append(mapped, component)
+		case i == len(components)-1 && ext != "":
+			name, ok := a.files[component]
+			if !ok {
+				name = fmt.Sprintf("f%d%s", len(a.files)+1, ext)
+				a.files[component] = name
+			}
+			mapped = append(mapped, name)
+		default:
+			name, ok := a.dirs[component]
+			if !ok {
+				name = fmt.Sprintf("d%d", len(a.dirs)+1)
+				a.dirs[component] = name
+			}
+			mapped = append(mapped, name)
+		}
+	}
+	return strings.Join(mapped, "/")
+}
+
+// AnonymizeText rewrites file URIs and absolute paths in text
+func (a *URIAnonymizer) AnonymizeText(text string) string {
+	return anonymizedPathPattern.ReplaceAllStringFunc(text, func(match string) string {
+		if rest, ok := strings.CutPrefix(match, "file://"); ok {
+			return "file://" + a.anonymizePath(rest)
+		}
+		i := strings.IndexByte(match, '/') // skip preceding character
+		return match[:i] + a.anonymizePath(match[i:])
+	})
+}
+
+// Anonymize rewrites file URIs and absolute paths in payload. In JSON payload, every string (and object key)
+// is rewritten while keeping order of keys and numbers. Returns payload itself if nothing is rewritten
+func (a *URIAnonymizer) Anonymize(payloadType PayloadType, payload []byte) []byte {
+	if payloadType != JSON {
+		if text := a.AnonymizeText(string(payload)); text != string(payload) {
+			return []byte(text)
+		}
+		return payload
+	}
+	if rewritten, err := rewriteJSONStrings(payload, a.AnonymizeText); err == nil {
+		return rewritten
+	}
+	return payload // broken or truncated JSON is kept
+}
+
+// rewriteJSONStrings rewrites every string and object key of JSON by fn. Order of keys and representation
+// of numbers are kept. Returns data itself if nothing is rewritten
+func rewriteJSONStrings(data []byte, fn func(s string) string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	type container struct {
+		object bool
+		count  int // number of tokens in container (keys and values)
+	}
+	var stack []container
+	out := bytes.Buffer{}
+	out.Grow(len(data))
+	changed := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			out.WriteByte(byte(delim))
+			continue
+		}
+		if n := len(stack); n > 0 {
+			top := &stack[n-1]
+			switch {
+			case top.object && top.count%2 == 1:
+				out.WriteByte(':')
+			case top.count > 0:
+				out.WriteByte(',')
+			}
+			top.count++
+		}
+		switch v := token.(type) {
+		case json.Delim:
+			out.WriteByte(byte(v))
+			stack = append(stack, container{object: v == '{'})
+		case string:
+			s := fn(v)
+			changed = changed || s != v
+			encoded, _ := marshalJSON(s)
+			out.Write(encoded)
+		case json.Number:
+			out.WriteString(v.String())
+		default: // bool or nil
+			encoded, _ := json.Marshal(v)
+			out.Write(encoded)
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLeakScannerScan(t *testing.T) {
+	scanner := NewLeakScanner(16)
+	scanner.AddFile("a.go", []byte("package main\n\n\tfunc secretBusinessLogic() {\n}\n\tvar customerAccountNumber = 42\n"))
+	scanner.AddFile("b.bin", []byte("func secretBusinessLogic() {\x00"))
+
+	var found []string
+	scanner.Scan("xx func secretBusinessLogic() {\nvar customerAccountNumber = 42", func(file string, line int) {
+		found = append(found, file+":"+strings.Repeat("I", line))
+	})
+	assert.Equal(t, []string{"a.go:III", "a.go:IIIII"}, found)
+
+	found = nil
+	scanner.Scan("func secretBusinessLogic() ", func(file string, line int) { // prefix only
+		found = append(found, file)
+	})
+	assert.Empty(t, found)
+}
+
+func TestFindLeaks(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "a.go"),
+		[]byte("package main\n\nfunc secretBusinessLogic(customerAccountNumber int) {\n}\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "config"),
+		[]byte("[remote \"origin\"] url = https://example.com/secret/repository.git\n"), 0o644))
+
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"text":"package main\n\nfunc secretBusinessLogic(customerAccountNumber int) {\n}\n"}}}`)},
+		LogData{streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"text":"package main\n\nfunc f0000(v0000 int) {\n}\n"}}}`)},
+		LogData{streamType: STDERR, payloadType: RAW,
+			payload: []byte("error: func secretBusinessLogic(customerAccountNumber int) {")},
+		LogData{streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"changes":{"url = https://example.com/secret/repository.git":[]}}}`)},
+	)
+	scanner := NewLeakScanner(20)
+	require.NoError(t, scanner.AddWorkspace(dir))
+	leaks, err := scanner.FindLeaks(strings.NewReader(log))
+	require.NoError(t, err)
+	require.Len(t, leaks, 2)
+	assert.Equal(t, "seq 1 at $.params.textDocument.text: src/a.go lines 3", leaks[0].String())
+	assert.Equal(t, "seq 3 at (raw payload): src/a.go lines 3", leaks[1].String())
+}
+
+func TestURIAnonymizer(t *testing.T) {
+	a := NewURIAnonymizer()
+	initialize := `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"processId":1234,"rootUri":"file:///home/alice/proj","rootPath":"/home/alice/proj","workspaceFolders":[{"uri":"file:///home/alice/proj","name":"proj"}],"trace":"off","ratio":1.50}}`
+	assert.Equal(t, `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"processId":1234,"rootUri":"file:///W/d1/d2/d3","rootPath":"/W/d1/d2/d3","workspaceFolders":[{"uri":"file:///W/d1/d2/d3","name":"proj"}],"trace":"off","ratio":1.50}}`,
+		string(a.Anonymize(JSON, []byte(initialize))))
+
+	diagnostics := `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///home/alice/proj/main.go","diagnostics":[{"message":"x declared and not used","relatedInformation":[{"location":{"uri":"file:///home/alice/proj/util/main.go"},"message":"see /home/alice/proj/util/main.go:3"}]}]}}`
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///W/d1/d2/d3/f1.go","diagnostics":[{"message":"x declared and not used","relatedInformation":[{"location":{"uri":"file:///W/d1/d2/d3/d4/f1.go"},"message":"see /W/d1/d2/d3/d4/f1.go:3"}]}]}}`,
+		string(a.Anonymize(JSON, []byte(diagnostics))))
+
+	// drive letter is kept
+	assert.Equal(t, `{"uri":"file:///W/c%3A/d5/f2.txt"}`, string(a.Anonymize(JSON, []byte(`{"uri":"file:///c%3A/Users/a.txt"}`))))
+
+	for _, payload := range []string{ // not anonymized
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{"limit":"100/s","url":"https://example.com/a/b"}}`,
+		`{"jsonrpc":"2.0","id":1,"result":{"uri":"file:///home/alice`, // truncated
+	} {
+		assert.Equal(t, payload, string(a.Anonymize(JSON, []byte(payload))))
+	}
+
+	assert.Equal(t, "env: HOME=/W/d1/d2 PATH=/W/d6/d7:/W/d1/d2/d7", a.AnonymizeText("env: HOME=/home/alice PATH=/usr/bin:/home/alice/bin"))
+	assert.Equal(t, "run: /W/d6/d7/f3.sh -v", string(a.Anonymize(RAW, []byte("run: /usr/bin/server.sh -v"))))
+}
+
+func TestRedactLogURIs(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///home/alice/a.go","text":"proprietary"}}}`)},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("cannot open /home/alice/b.go")},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("no path")},
+	)
+	out := bytes.Buffer{}
+	count, err := RedactLog(NewLogReader(strings.NewReader(log)), &out, nil, NewURIAnonymizer(), LogFormatJSON, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.NotContains(t, out.String(), "alice")
+	assert.Contains(t, out.String(), "proprietary") // text is not redacted
+	assert.Contains(t, out.String(), `file:///W/d1/d2/f1.go`)
+	assert.Contains(t, out.String(), `cannot open /W/d1/d2/f2.go`)
+}
@@ -0,0 +1,93 @@
+package recorder
+
+import "bytes"
+
+type ansiState int
+
+const (
+	ansiNormal ansiState = iota
+	ansiEscape
+	ansiEscapeIntermediate
+	ansiCSI
+	ansiString    // OSC, DCS, SOS, PM, APC
+	ansiStringEsc // ESC in string (may be ST)
+)
+
+// AnsiStripper removes CSI/OSC escape sequences from byte stream.
+// Sequences split across multiple Strip calls are handled
+type AnsiStripper struct {
+	state ansiState
+}
+
+func (a *AnsiStripper) isBinary(src []byte, stripped []byte) bool {
+	if bytes.IndexByte(src, 0) >= 0 {
+		return true
+	}
+	return len(src) >= 256 && len(stripped)*10 < len(src) // absurd strip ratio
+}
+
+// Strip returns src without escape sequences.
+// If src seems to be binary data, returns copy of src as is. Returned slice never shares memory with src
+// (src may be reused read buffer)
+func (a *AnsiStripper) Strip(src []byte) []byte {
+	dst := make([]byte, 0, len(src))
+	for _, b := range src {
+		switch a.state {
+		case ansiNormal:
+			if b == 0x1b {
+				a.state = ansiEscape
+			} else {
+				dst = append(dst, b)
+			}
+		case ansiEscape:
+			switch {
+			case b == '[':
+				a.state = ansiCSI
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				a.state = ansiString
+			case b >= 0x20 && b <= 0x2f:
+				a.state = ansiEscapeIntermediate
+			case b >= 0x30 && b <= 0x7e:
+				a.state = ansiNormal
+			default: // not escape sequence
+				a.state = ansiNormal
+				dst = append(dst, 0x1b, b)
+			}
+		case ansiEscapeIntermediate:
+			switch {
+			case b >= 0x20 && b <= 0x2f:
+			case b >= 0x30 && b <= 0x7e:
+				a.state = ansiNormal
+			default:
+				a.state = ansiNormal
+				dst = append(dst, b)
+			}
+		case ansiCSI:
+			switch {
+			case b >= 0x20 && b <= 0x3f: // parameter or intermediate
+			case b >= 0x40 && b <= 0x7e:
+				a.state = ansiNormal
+			default: // broken sequence
+				a.state = ansiNormal
+				dst = append(dst, b)
+			}
+		case ansiString:
+			if b == 0x07 { // BEL
+				a.state = ansiNormal
+			} else if b == 0x1b {
+				a.state = ansiStringEsc
+			}
+		case ansiStringEsc:
+			if b == '\\' { // ST
+				a.state = ansiNormal
+			} else if b != 0x1b {
+				a.state = ansiString
+			}
+		}
+	}
+	if a.isBinary(src, dst) {
+		a.state = ansiNormal
+		return append(dst[:0], src...)
+	}
+	return dst
+}
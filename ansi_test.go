@@ -0,0 +1,44 @@
+package recorder
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAnsiStrip(t *testing.T) {
+	a := AnsiStripper{}
+	assert.Equal(t, "error: hello\n", string(a.Strip([]byte("\x1b[1;31merror\x1b[0m: hello\n"))))
+	assert.Equal(t, "title", string(a.Strip([]byte("\x1b]0;window title\x07title"))))
+	assert.Equal(t, "link", string(a.Strip([]byte("\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\"))))
+	assert.Equal(t, "ab", string(a.Strip([]byte("a\x1b(Bb"))))
+	assert.Equal(t, "\x1b\x01", string(a.Strip([]byte("\x1b\x01"))))
+}
+
+func TestAnsiStripSplit(t *testing.T) {
+	src := "\x1b[38;5;196mERROR\x1b[0m [\x1b]0;t\x07main] \x1b[2Kfailed\n"
+	for i := 0; i <= len(src); i++ {
+		for j := i; j <= len(src); j++ {
+			a := AnsiStripper{}
+			out := string(a.Strip([]byte(src[:i])))
+			out += string(a.Strip([]byte(src[i:j])))
+			out += string(a.Strip([]byte(src[j:])))
+			assert.Equal(t, "ERROR [main] failed\n", out, fmt.Sprintf("split at: %d, %d", i, j))
+		}
+	}
+}
+
+func TestAnsiStripBinary(t *testing.T) {
+	a := AnsiStripper{}
+	src := []byte("\x1b[0m\x00\x01\x02")
+	stripped := a.Strip(src)
+	assert.Equal(t, src, stripped)
+	src[0] = 'x' // e.g. read buffer is reused
+	assert.Equal(t, byte(0x1b), stripped[0])
+
+	src = make([]byte, 0, 512)
+	for len(src) < 512 {
+		src = append(src, "\x1b[1m"...)
+	}
+	assert.Equal(t, src, a.Strip(src))
+}
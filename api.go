@@ -0,0 +1,99 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+)
+
+// Options of Recorder. Embedded RunOptions correspond to flags of record subcommand, and Stdin, Stdout and
+// Stderr (if not nil) take precedence over its ClientIn, ClientOut and ErrOut
+type Options struct {
+	Command string       // Language Server executable (may be empty if Connect or ServerPipe is set)
+	Args    []string     // arguments of Language Server
+	Logger  *slog.Logger // records are written to this (see NewFormatLogger and CreateLogs)
+
+	Stdin  io.Reader // client messages are read from this (stdin if nil)
+	Stdout io.Writer // server messages to client are written to this (stdout if nil)
+	Stderr io.Writer // stderr of server and diagnostics of recorder are written to this (stderr if nil)
+
+	RunOptions
+}
+
+// Recorder runs Language Server and records its traffic in background, for embedding recording into
+// other programs (e.g. test harness). record subcommand is thin wrapper of it
+type Recorder struct {
+	name   string
+	args   []string
+	logger *slog.Logger
+	opts   RunOptions
+
+	done   chan struct{} // closed when session finished
+	status ExitStatus
+	err    error
+}
+
+// New creates Recorder. Session is not started until Start is called
+func New(options Options) *Recorder {
+	opts := options.RunOptions
+	if options.Stdin != nil {
+		opts.ClientIn = options.Stdin
+	}
+	if options.Stdout != nil {
+		opts.ClientOut = options.Stdout
+	}
+	if options.Stderr != nil {
+		opts.ErrOut = options.Stderr
+	}
+	return &Recorder{name: options.Command, args: options.Args, logger: options.Logger, opts: opts}
+}
+
+// Start starts session in background. Failure of starting Language Server is returned by Wait
+func (r *Recorder) Start() error {
+	return r.StartContext(context.Background())
+}
+
+// StartContext is Start whose session is shut down gracefully when ctx is done (see RunContext)
+func (r *Recorder) StartContext(ctx context.Context) error {
+	switch {
+	case r.done != nil:
+		return errors.New("recorder is already started")
+	case r.logger == nil:
+		return errors.New("logger of recorder is required")
+	case r.name == "" && r.opts.Connect == "" && r.opts.ServerPipe == "" && r.opts.WsConnect == "":
+		return errors.New("Language Server executable path or Connect/ServerPipe/WsConnect is required")
+	}
+	if err := r.opts.Validate(r.name); err != nil {
+		return err
+	}
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		r.status, r.err = RunContext(ctx, r.name, r.args, r.logger, r.opts)
+	}()
+	return nil
+}
+
+// Wait waits until session finishes (server exited and remaining records are written to logger).
+// Returns exit status of Language Server or error if it cannot be started
+func (r *Recorder) Wait() (ExitStatus, error) {
+	if r.done == nil {
+		return ExitStatus{}, errors.New("recorder is not started")
+	}
+	<-r.done
+	return r.status, r.err
+}
+
+// Run starts session and waits until it finishes
+func (r *Recorder) Run() (ExitStatus, error) {
+	return r.RunContext(context.Background())
+}
+
+// RunContext is Run whose session is shut down gracefully when ctx is done (see RunContext)
+func (r *Recorder) RunContext(ctx context.Context) (ExitStatus, error) {
+	if err := r.StartContext(ctx); err != nil {
+		return ExitStatus{}, err
+	}
+	return r.Wait()
+}
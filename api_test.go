@@ -0,0 +1,75 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	buf := &syncBuffer{}
+	stdout := &bytes.Buffer{}
+	stderr := &syncBuffer{}
+	r := New(Options{
+		Command:    "sh",
+		Args:       []string{"-c", `cat; echo done >&2`},
+		Logger:     NewLogger(buf),
+		Stdin:      strings.NewReader("Content-Length: 2\r\n\r\n{}"),
+		Stdout:     stdout,
+		Stderr:     stderr,
+		RunOptions: RunOptions{NoEnv: true},
+	})
+	_, err := r.Wait()
+	assert.EqualError(t, err, "recorder is not started")
+	require.NoError(t, r.Start())
+	assert.EqualError(t, r.Start(), "recorder is already started")
+	status, err := r.Wait()
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Code)
+	assert.Equal(t, "Content-Length: 2\r\n\r\n{}", stdout.String())
+	assert.Equal(t, "done\n", stderr.String())
+	assert.Contains(t, buf.String(), `"stream":"stdin","type":"json","size":2,"payload":"{}"`)
+	assert.Contains(t, buf.String(), `"stream":"stdout","type":"json","size":2,"payload":"{}"`)
+	assert.Contains(t, buf.String(), `"stream":"stderr","type":"raw","size":5,"payload":"done\n"`)
+}
+
+func TestRecorderInvalidOptions(t *testing.T) {
+	_, err := New(Options{Command: "sh"}).Run()
+	assert.EqualError(t, err, "logger of recorder is required")
+	_, err = New(Options{Logger: NewLogger(&bytes.Buffer{})}).Run()
+	assert.EqualError(t, err, "Language Server executable path or Connect/ServerPipe/WsConnect is required")
+}
+
+func TestRunOptionsValidate(t *testing.T) {
+	logger := NewLogger(&bytes.Buffer{})
+	for _, tc := range []struct {
+		name string
+		opts RunOptions
+		err  string
+	}{
+		{"raw", RunOptions{Raw: true, MaxPayloadBytes: 10}, "Raw cannot be combined with"},
+		{"raw", RunOptions{Raw: true, Redactor: &Redactor{}}, "Raw cannot be combined with"},
+		{"spill", RunOptions{SpillOver: 10, Anonymizer: NewURIAnonymizer()}, "SpillOver cannot be combined with"},
+		{"restarts", RunOptions{MaxRestarts: -1}, "MaxRestarts must not be negative: -1"},
+		{"backoff", RunOptions{RestartBackoff: -time.Second}, "RestartBackoff must not be negative: -1s"},
+		{"connect", RunOptions{MaxRestarts: 1, Connect: "127.0.0.1:1"}, "MaxRestarts requires"},
+		{"env", RunOptions{NoEnv: true, EnvRecordAll: true}, "NoEnv cannot be combined with"},
+		{"env", RunOptions{ServerEnv: []string{"=1"}}, "environment variable must be KEY=VALUE or KEY"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			errOut := &syncBuffer{}
+			tc.opts.ErrOut = errOut
+			err := New(Options{Command: "sh", Logger: logger, RunOptions: tc.opts}).Start()
+			assert.ErrorContains(t, err, tc.err)
+			_, err = RunContext(context.Background(), "sh", nil, logger, tc.opts)
+			assert.ErrorContains(t, err, tc.err)
+			assert.Equal(t, err.Error()+"\n", errOut.String()) // reported before session starts
+		})
+	}
+	assert.NoError(t, (&RunOptions{MaxRestarts: 1}).Validate("sh"))
+	assert.ErrorContains(t, (&RunOptions{MaxRestarts: 1}).Validate(""), "MaxRestarts requires")
+}
@@ -0,0 +1,81 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// connectFailedCode is error code of response to client request when server cannot be connected
+// (server error of JSON-RPC)
+const connectFailedCode = -32000
+
+// rejectTimeout is how long rejectClient waits for the first message of client
+const rejectTimeout = 5 * time.Second
+
+// readFramedMessage reads a single message framed by Content-Length header
+func readFramedMessage(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid message header: '%s'", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message header has no Content-Length")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// rejectClient answers the first request of stdio client with error response when server cannot be connected,
+// so that client (e.g. editor) does not wait for response forever. The request and the synthesized response are
+// recorded, and both streams are ended
+func rejectClient(clientIn io.Reader, clientOut io.Writer, cause error, ch chan<- LogData) {
+	received := make(chan []byte, 1)
+	go func() { // may block forever if client sends nothing
+		payload, _ := readFramedMessage(bufio.NewReader(clientIn))
+		received <- payload
+	}()
+	var payload []byte
+	select {
+	case payload = <-received:
+	case <-time.After(rejectTimeout):
+	}
+	if payload != nil {
+		d := LogData{timestamp: time.Now(), streamType: STDIN, payloadType: JSON, payload: payload}
+		if !json.Valid(payload) {
+			d.payloadType, d.declaredSize = INVALID, len(payload)
+		}
+		d.size = d.messageSize()
+		ch <- d
+	}
+	e, err := ParseEnvelope(payload)
+	if payload != nil && err == nil && e.IsRequest() {
+		response, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": e.Id, "error": map[string]any{
+			"code": connectFailedCode, "message": "lsp-recorder: cannot connect to Language Server, " + cause.Error()}})
+		if _, err := fmt.Fprintf(clientOut, "Content-Length: %d\r\n\r\n%s", len(response), response); err == nil {
+			ch <- LogData{timestamp: time.Now(), streamType: STDOUT, payloadType: JSON, payload: response,
+				synthetic: true, size: len(response)}
+		}
+	}
+	sendEnd(STDIN, "server is not connected", ch)
+}
@@ -0,0 +1,111 @@
+package recorder
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFramedMessage(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("content-length: 2\r\nContent-Type: application/vscode-jsonrpc\r\n\r\n{}" +
+		"Content-Length: 3\r\n\r\n[]"))
+	payload, err := readFramedMessage(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(payload))
+	_, err = readFramedMessage(reader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = readFramedMessage(bufio.NewReader(strings.NewReader("Content-Type: json\r\n\r\n{}")))
+	assert.EqualError(t, err, "message header has no Content-Length")
+}
+
+// closedAddress returns TCP address nobody listens on
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return addr
+}
+
+func TestRunBridgeConnectFailure(t *testing.T) {
+	request := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`
+	buf := &syncBuffer{}
+	clientOut := &syncBuffer{}
+	_, err := Run("", nil, NewLogger(buf), RunOptions{NoEnv: true, Connect: closedAddress(t),
+		ConnectTimeout: 100 * time.Millisecond, ErrOut: io.Discard,
+		ClientIn: strings.NewReader(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(request), request)), ClientOut: clientOut})
+	require.Error(t, err)
+
+	out := clientOut.String()
+	assert.Regexp(t, `^Content-Length: \d+\r\n\r\n\{"error":\{"code":-32000,"message":"lsp-recorder: cannot connect to Language Server, failed to connect `, out)
+	assert.Contains(t, out, `"id":1,"jsonrpc":"2.0"}`)
+	log := buf.String()
+	assert.Contains(t, log, `"stream":"stdin","type":"json","method":"initialize"`)
+	assert.Regexp(t, `"stream":"stdout","type":"json".*"synthetic":true`, log)
+	assert.Regexp(t, `"stream":"stdin","type":"raw_end",.*"payload":"server is not connected"`, log)
+	assert.Regexp(t, `"stream":"stdout","type":"raw_end",.*"payload":"failed to connect `, log)
+
+	// notification is not answered
+	buf = &syncBuffer{}
+	clientOut = &syncBuffer{}
+	errOut := &syncBuffer{}
+	_, err = Run("", nil, NewLogger(buf), RunOptions{NoEnv: true, Connect: closedAddress(t),
+		ConnectTimeout: 100 * time.Millisecond, ErrOut: errOut,
+		ClientIn: strings.NewReader("Content-Length: 2\r\n\r\n{}"), ClientOut: clientOut})
+	require.Error(t, err)
+	assert.Equal(t, err.Error()+"\n", errOut.String()) // ends with newline since CLI does not report it again
+	assert.Empty(t, clientOut.String())
+	assert.NotContains(t, buf.String(), `"synthetic":true`)
+}
+
+func TestRunBridgeServerDisconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func(listener net.Listener) {
+		_ = listener.Close()
+	}(listener)
+	response := `{"jsonrpc":"2.0","id":1,"result":{}}`
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = readFramedMessage(bufio.NewReader(conn))
+		_, _ = fmt.Fprintf(conn, "Content-Length: %d\r\n\r\n%s", len(response), response)
+		_ = conn.Close() // server goes away in the middle of session
+	}()
+
+	clientIn, clientWriter := io.Pipe() // client keeps stdin open
+	defer func(w *io.PipeWriter) {
+		_ = w.Close()
+	}(clientWriter)
+	go func() {
+		request := `{"jsonrpc":"2.0","id":1,"method":"initialize"}`
+		_, _ = fmt.Fprintf(clientWriter, "Content-Length: %d\r\n\r\n%s", len(request), request)
+	}()
+	buf := &syncBuffer{}
+	clientOut := &syncBuffer{}
+	done := make(chan error)
+	go func() {
+		_, err := Run("", nil, NewLogger(buf), RunOptions{NoEnv: true, Connect: listener.Addr().String(),
+			ConnectTimeout: time.Second, ClientIn: clientIn, ClientOut: clientOut})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("recorder does not exit after server disconnected")
+	}
+	assert.Contains(t, clientOut.String(), response)
+	log := buf.String()
+	assert.Regexp(t, `"stream":"stdout","type":"raw_end",.*"payload":"end of stream"`, log)
+	assert.Regexp(t, `"stream":"stdin","type":"raw_end"`, log)
+}
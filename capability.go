@@ -0,0 +1,277 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CapabilityMethods maps capability path in initialize handshake to methods enabled by it.
+// Side is who advertises the capability ("client" in initialize params, "server" in initialize result)
+type CapabilityMethods struct {
+	Side    string
+	Path    string // dot-separated path in capabilities object
+	Methods []string
+}
+
+var (
+	syncMethods = []string{"textDocument/didOpen", "textDocument/didChange", "textDocument/didClose",
+		"textDocument/willSave", "textDocument/willSaveWaitUntil", "textDocument/didSave"}
+	semanticTokensMethods = []string{"textDocument/semanticTokens/full", "textDocument/semanticTokens/full/delta",
+		"textDocument/semanticTokens/range"}
+	callHierarchyMethods = []string{"textDocument/prepareCallHierarchy", "callHierarchy/incomingCalls",
+		"callHierarchy/outgoingCalls"}
+	typeHierarchyMethods = []string{"textDocument/prepareTypeHierarchy", "typeHierarchy/supertypes",
+		"typeHierarchy/subtypes"}
+)
+
+// CapabilityTable is shared by capability usage report and checks of unadvertised feature usage
+var CapabilityTable = []CapabilityMethods{
+	// server capabilities (features provided by server)
+	{"server", "textDocumentSync", syncMethods},
+	{"server", "completionProvider", []string{"textDocument/completion", "completionItem/resolve"}},
+	{"server", "hoverProvider", []string{"textDocument/hover"}},
+	{"server", "signatureHelpProvider", []string{"textDocument/signatureHelp"}},
+	{"server", "declarationProvider", []string{"textDocument/declaration"}},
+	{"server", "definitionProvider", []string{"textDocument/definition"}},
+	{"server", "typeDefinitionProvider", []string{"textDocument/typeDefinition"}},
+	{"server", "implementationProvider", []string{"textDocument/implementation"}},
+	{"server", "referencesProvider", []string{"textDocument/references"}},
+	{"server", "documentHighlightProvider", []string{"textDocument/documentHighlight"}},
+	{"server", "documentSymbolProvider", []string{"textDocument/documentSymbol"}},
+	{"server", "codeActionProvider", []string{"textDocument/codeAction", "codeAction/resolve"}},
+	{"server", "codeLensProvider", []string{"textDocument/codeLens", "codeLens/resolve"}},
+	{"server", "documentLinkProvider", []string{"textDocument/documentLink", "documentLink/resolve"}},
+	{"server", "colorProvider", []string{"textDocument/documentColor", "textDocument/colorPresentation"}},
+	{"server", "documentFormattingProvider", []string{"textDocument/formatting"}},
+	{"server", "documentRangeFormattingProvider", []string{"textDocument/rangeFormatting"}},
+	{"server", "documentOnTypeFormattingProvider", []string{"textDocument/onTypeFormatting"}},
+	{"server", "renameProvider", []string{"textDocument/rename", "textDocument/prepareRename"}},
+	{"server", "foldingRangeProvider", []string{"textDocument/foldingRange"}},
+	{"server", "executeCommandProvider", []string{"workspace/executeCommand"}},
+	{"server", "selectionRangeProvider", []string{"textDocument/selectionRange"}},
+	{"server", "linkedEditingRangeProvider", []string{"textDocument/linkedEditingRange"}},
+	{"server", "callHierarchyProvider", callHierarchyMethods},
+	{"server", "semanticTokensProvider", semanticTokensMethods},
+	{"server", "monikerProvider", []string{"textDocument/moniker"}},
+	{"server", "typeHierarchyProvider", typeHierarchyMethods},
+	{"server", "inlineValueProvider", []string{"textDocument/inlineValue"}},
+	{"server", "inlayHintProvider", []string{"textDocument/inlayHint", "inlayHint/resolve"}},
+	{"server", "diagnosticProvider", []string{"textDocument/diagnostic", "workspace/diagnostic"}},
+	{"server", "workspaceSymbolProvider", []string{"workspace/symbol", "workspaceSymbol/resolve"}},
+
+	// client capabilities (features supported by client)
+	{"client", "textDocument.synchronization", syncMethods},
+	{"client", "textDocument.completion", []string{"textDocument/completion", "completionItem/resolve"}},
+	{"client", "textDocument.hover", []string{"textDocument/hover"}},
+	{"client", "textDocument.signatureHelp", []string{"textDocument/signatureHelp"}},
+	{"client", "textDocument.declaration", []string{"textDocument/declaration"}},
+	{"client", "textDocument.definition", []string{"textDocument/definition"}},
+	{"client", "textDocument.typeDefinition", []string{"textDocument/typeDefinition"}},
+	{"client", "textDocument.implementation", []string{"textDocument/implementation"}},
+	{"client", "textDocument.references", []string{"textDocument/references"}},
+	{"client", "textDocument.documentHighlight", []string{"textDocument/documentHighlight"}},
+	{"client", "textDocument.documentSymbol", []string{"textDocument/documentSymbol"}},
+	{"client", "textDocument.codeAction", []string{"textDocument/codeAction", "codeAction/resolve"}},
+	{"client", "textDocument.codeLens", []string{"textDocument/codeLens", "codeLens/resolve"}},
+	{"client", "textDocument.documentLink", []string{"textDocument/documentLink", "documentLink/resolve"}},
+	{"client", "textDocument.colorProvider", []string{"textDocument/documentColor", "textDocument/colorPresentation"}},
+	{"client", "textDocument.formatting", []string{"textDocument/formatting"}},
+	{"client", "textDocument.rangeFormatting", []string{"textDocument/rangeFormatting"}},
+	{"client", "textDocument.onTypeFormatting", []string{"textDocument/onTypeFormatting"}},
+	{"client", "textDocument.rename", []string{"textDocument/rename", "textDocument/prepareRename"}},
+	{"client", "textDocument.publishDiagnostics", []string{"textDocument/publishDiagnostics"}},
+	{"client", "textDocument.foldingRange", []string{"textDocument/foldingRange"}},
+	{"client", "textDocument.selectionRange", []string{"textDocument/selectionRange"}},
+	{"client", "textDocument.linkedEditingRange", []string{"textDocument/linkedEditingRange"}},
+	{"client", "textDocument.callHierarchy", callHierarchyMethods},
+	{"client", "textDocument.semanticTokens", semanticTokensMethods},
+	{"client", "textDocument.moniker", []string{"textDocument/moniker"}},
+	{"client", "textDocument.typeHierarchy", typeHierarchyMethods},
+	{"client", "textDocument.inlineValue", []string{"textDocument/inlineValue"}},
+	{"client", "textDocument.inlayHint", []string{"textDocument/inlayHint", "inlayHint/resolve"}},
+	{"client", "textDocument.diagnostic", []string{"textDocument/diagnostic", "workspace/diagnostic"}},
+	{"client", "workspace.applyEdit", []string{"workspace/applyEdit"}},
+	{"client", "workspace.workspaceFolders", []string{"workspace/workspaceFolders"}},
+	{"client", "workspace.configuration", []string{"workspace/configuration"}},
+	{"client", "workspace.didChangeConfiguration", []string{"workspace/didChangeConfiguration"}},
+	{"client", "workspace.didChangeWatchedFiles", []string{"workspace/didChangeWatchedFiles"}},
+	{"client", "workspace.symbol", []string{"workspace/symbol", "workspaceSymbol/resolve"}},
+	{"client", "workspace.executeCommand", []string{"workspace/executeCommand"}},
+	{"client", "workspace.codeLens.refreshSupport", []string{"workspace/codeLens/refresh"}},
+	{"client", "workspace.semanticTokens.refreshSupport", []string{"workspace/semanticTokens/refresh"}},
+	{"client", "workspace.inlayHint.refreshSupport", []string{"workspace/inlayHint/refresh"}},
+	{"client", "workspace.inlineValue.refreshSupport", []string{"workspace/inlineValue/refresh"}},
+	{"client", "workspace.diagnostics.refreshSupport", []string{"workspace/diagnostic/refresh"}},
+	{"client", "window.workDoneProgress", []string{"window/workDoneProgress/create"}},
+	{"client", "window.showMessage", []string{"window/showMessageRequest"}},
+	{"client", "window.showDocument", []string{"window/showDocument"}},
+}
+
+// lookupCapability reports whether capability at dot-separated path is advertised (present and not false/null)
+func lookupCapability(capabilities json.RawMessage, path string) bool {
+	v := capabilities
+	for _, key := range strings.Split(path, ".") {
+		var m map[string]json.RawMessage
+		if json.Unmarshal(v, &m) != nil || m == nil {
+			return false
+		}
+		var ok bool
+		if v, ok = m[key]; !ok {
+			return false
+		}
+	}
+	k := idKey(v)
+	return k != "null" && k != "false"
+}
+
+// CapabilityUsage is usage of a capability in session
+type CapabilityUsage struct {
+	Side       string
+	Path       string
+	Advertised bool           // in initialize handshake (or by dynamic registration for server side)
+	Counts     map[string]int // observed messages of each method
+}
+
+func (u *CapabilityUsage) used() bool {
+	return len(u.Counts) > 0
+}
+
+func (u *CapabilityUsage) formatCounts() string {
+	methods := make([]string, 0, len(u.Counts))
+	for method := range u.Counts {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for i, method := range methods {
+		methods[i] = fmt.Sprintf("%s: %d", method, u.Counts[method])
+	}
+	return strings.Join(methods, ", ")
+}
+
+// CapabilityReport cross-references capabilities of initialize handshake with methods observed in session
+type CapabilityReport struct {
+	Meta      *SessionMeta // metadata of session (nil if log has no META record)
+	Handshake bool         // initialize request and response are found
+	Usages    []*CapabilityUsage
+}
+
+// CollectCapabilityUsage reads log and collects usage of each capability of CapabilityTable.
+// Methods registered by client/registerCapability are regarded as advertised by server
+func CollectCapabilityUsage(reader io.Reader) (*CapabilityReport, error) {
+	var clientCaps, serverCaps json.RawMessage
+	initializeId := ""
+	registered := map[string]bool{}
+	counts := map[string]int{}
+	var meta *SessionMeta
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.payloadType == META {
+			meta = mergeSessionMeta(meta, d.payload)
+		}
+		if d.payloadType != JSON {
+			continue
+		}
+		e, err := d.Envelope()
+		if err != nil {
+			continue
+		}
+		switch {
+		case e.IsRequest() && e.Method == "initialize" && d.streamType == STDIN && initializeId == "":
+			initializeId = idKey(e.Id)
+			var m struct {
+				Params struct {
+					Capabilities json.RawMessage `json:"capabilities"`
+				} `json:"params"`
+			}
+			_ = json.Unmarshal(d.payload, &m)
+			clientCaps = m.Params.Capabilities
+		case e.IsResponse() && d.streamType == STDOUT && idKey(e.Id) == initializeId && serverCaps == nil:
+			var m struct {
+				Result struct {
+					Capabilities json.RawMessage `json:"capabilities"`
+				} `json:"result"`
+			}
+			_ = json.Unmarshal(d.payload, &m)
+			serverCaps = m.Result.Capabilities
+		case e.IsRequest() && e.Method == "client/registerCapability":
+			var m struct {
+				Params struct {
+					Registrations []struct {
+						Method string `json:"method"`
+					} `json:"registrations"`
+				} `json:"params"`
+			}
+			_ = json.Unmarshal(d.payload, &m)
+			for _, registration := range m.Params.Registrations {
+				registered[registration.Method] = true
+			}
+		}
+		if e.Method != "" && e.Method != "client/registerCapability" {
+			counts[e.Method]++
+		}
+	}
+	report := &CapabilityReport{Meta: meta, Handshake: clientCaps != nil && serverCaps != nil}
+	for _, c := range CapabilityTable {
+		usage := &CapabilityUsage{Side: c.Side, Path: c.Path, Counts: map[string]int{}}
+		if c.Side == "client" {
+			usage.Advertised = lookupCapability(clientCaps, c.Path)
+		} else {
+			usage.Advertised = lookupCapability(serverCaps, c.Path)
+		}
+		for _, method := range c.Methods {
+			if counts[method] > 0 {
+				usage.Counts[method] = counts[method]
+			}
+			if c.Side == "server" && registered[method] {
+				usage.Advertised = true
+			}
+		}
+		report.Usages = append(report.Usages, usage)
+	}
+	return report, nil
+}
+
+// Format writes used, unused and used-but-not-advertised capabilities of each side
+func (r *CapabilityReport) Format(writer io.Writer) {
+	formatStatsHeader(writer, r.Meta, nil)
+	if !r.Handshake {
+		_, _ = fmt.Fprintln(writer, "warning: initialize handshake is not found, so no capability is advertised")
+	}
+	for _, side := range []string{"client", "server"} {
+		var used, unused, unadvertised []string
+		for _, u := range r.Usages {
+			if u.Side != side {
+				continue
+			}
+			switch {
+			case u.Advertised && u.used():
+				used = append(used, fmt.Sprintf("%s (%s)", u.Path, u.formatCounts()))
+			case u.Advertised:
+				unused = append(unused, u.Path)
+			case u.used():
+				unadvertised = append(unadvertised, fmt.Sprintf("%s (%s)", u.Path, u.formatCounts()))
+			}
+		}
+		_, _ = fmt.Fprintf(writer, "%s capabilities:\n", side)
+		for _, group := range []struct {
+			title string
+			items []string
+		}{{"used", used}, {"unused", unused}, {"used but not advertised", unadvertised}} {
+			_, _ = fmt.Fprintf(writer, "  %s: %d\n", group.title, len(group.items))
+			for _, item := range group.items {
+				_, _ = fmt.Fprintf(writer, "    %s\n", item)
+			}
+		}
+	}
+}
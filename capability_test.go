@@ -0,0 +1,52 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestLookupCapability(t *testing.T) {
+	caps := []byte(`{"hoverProvider":true,"renameProvider":{"prepareProvider":true},"codeLensProvider":null,
+"definitionProvider":false,"workspace":{"codeLens":{"refreshSupport":true}}}`)
+	assert.True(t, lookupCapability(caps, "hoverProvider"))
+	assert.True(t, lookupCapability(caps, "renameProvider"))
+	assert.True(t, lookupCapability(caps, "workspace.codeLens.refreshSupport"))
+	assert.False(t, lookupCapability(caps, "codeLensProvider"))
+	assert.False(t, lookupCapability(caps, "definitionProvider"))
+	assert.False(t, lookupCapability(caps, "workspace.semanticTokens.refreshSupport"))
+	assert.False(t, lookupCapability(nil, "hoverProvider"))
+}
+
+func TestCapabilityUsage(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{"textDocument":{"hover":{},"codeLens":{}}}}}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{"hoverProvider":true,"renameProvider":true}}}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover"}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"method":"textDocument/hover"}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":4,"method":"textDocument/definition"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":"r","method":"client/registerCapability","params":{"registrations":[{"id":"x","method":"textDocument/formatting"}]}}`)},
+	)
+	report, err := CollectCapabilityUsage(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.True(t, report.Handshake)
+	out := strings.Builder{}
+	report.Format(&out)
+	assert.Equal(t, `client capabilities:
+  used: 1
+    textDocument.hover (textDocument/hover: 2)
+  unused: 1
+    textDocument.codeLens
+  used but not advertised: 1
+    textDocument.definition (textDocument/definition: 1)
+server capabilities:
+  used: 1
+    hoverProvider (textDocument/hover: 2)
+  unused: 2
+    documentFormattingProvider
+    renameProvider
+  used but not advertised: 1
+    definitionProvider (textDocument/definition: 1)
+`, out.String())
+}
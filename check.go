@@ -0,0 +1,157 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kinds of ProtocolViolation
+const (
+	ViolationDuplicateId     = "duplicate-id"     // request reuses id of outstanding request of the same sender
+	ViolationUnknownResponse = "unknown-response" // response whose id matches no outstanding request
+	ViolationUnanswered      = "unanswered"       // request never answered before end of log
+)
+
+// ProtocolViolation is record breaking JSON-RPC rules of request ids
+type ProtocolViolation struct {
+	Kind   string
+	Seq    int // seq of offending record (request for ViolationUnanswered)
+	Time   time.Time
+	From   string // sender of offending record (client or server)
+	Method string // method of request (empty for ViolationUnknownResponse)
+	Id     string
+	Prev   int // seq of outstanding request of ViolationDuplicateId
+}
+
+// String returns description of violation like "#42 2024-05-01T10:00:01Z server request workspace/applyEdit id=3 is never answered"
+func (v *ProtocolViolation) String() string {
+	head := fmt.Sprintf("#%d %s %s", v.Seq, v.Time.Format(time.RFC3339Nano), v.From)
+	switch v.Kind {
+	case ViolationDuplicateId:
+		return fmt.Sprintf("%s request %s id=%s reuses id of outstanding request #%d", head, v.Method, v.Id, v.Prev)
+	case ViolationUnknownResponse:
+		return fmt.Sprintf("%s response id=%s matches no outstanding request", head, v.Id)
+	default:
+		return fmt.Sprintf("%s request %s id=%s is never answered", head, v.Method, v.Id)
+	}
+}
+
+// checkedRequest is outstanding request tracked by protocolChecker
+type checkedRequest struct {
+	seq       int
+	timestamp time.Time
+	stream    StreamType
+	method    string
+	id        string
+}
+
+// protocolChecker tracks id space of each direction and collects violations
+type protocolChecker struct {
+	pending    map[string]*checkedRequest
+	violations []ProtocolViolation
+}
+
+func newProtocolChecker() *protocolChecker {
+	return &protocolChecker{pending: map[string]*checkedRequest{}}
+}
+
+// observe checks JSON-RPC message
+func (c *protocolChecker) observe(d *LogData, e *Envelope) {
+	switch {
+	case e.IsRequest():
+		key := pairKey(d, d.streamType, e.Id)
+		req := &checkedRequest{seq: d.seq, timestamp: d.timestamp, stream: d.streamType, method: e.Method, id: idKey(e.Id)}
+		if prev, ok := c.pending[key]; ok {
+			c.violations = append(c.violations, ProtocolViolation{Kind: ViolationDuplicateId, Seq: d.seq,
+				Time: d.timestamp, From: senderOf(d.streamType), Method: e.Method, Id: req.id, Prev: prev.seq})
+		}
+		c.pending[key] = req
+	case e.IsResponse():
+		key := pairKey(d, peerStream(d.streamType), e.Id)
+		if _, ok := c.pending[key]; !ok {
+			c.violations = append(c.violations, ProtocolViolation{Kind: ViolationUnknownResponse, Seq: d.seq,
+				Time: d.timestamp, From: senderOf(d.streamType), Id: idKey(e.Id)})
+			return
+		}
+		delete(c.pending, key)
+	}
+}
+
+// finish reports requests outstanding at end of log and returns all violations in order of seq
+func (c *protocolChecker) finish() []ProtocolViolation {
+	for _, req := range c.pending {
+		c.violations = append(c.violations, ProtocolViolation{Kind: ViolationUnanswered, Seq: req.seq,
+			Time: req.timestamp, From: senderOf(req.stream), Method: req.method, Id: req.id})
+	}
+	clear(c.pending)
+	sort.SliceStable(c.violations, func(i, j int) bool {
+		return c.violations[i].Seq < c.violations[j].Seq
+	})
+	return c.violations
+}
+
+// countViolations returns summary of violations like "1 duplicate-id, 2 unanswered" (in order of kind)
+func countViolations(violations []ProtocolViolation) string {
+	counts := map[string]int{}
+	for _, v := range violations {
+		counts[v.Kind]++
+	}
+	var s []string
+	for _, kind := range []string{ViolationDuplicateId, ViolationUnknownResponse, ViolationUnanswered} {
+		if counts[kind] > 0 {
+			s = append(s, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// ProtocolCheck is result of CheckProtocol
+type ProtocolCheck struct {
+	Messages   int // number of checked JSON-RPC messages
+	Violations []ProtocolViolation
+}
+
+// CheckProtocol reads log and reports duplicate outstanding request ids, responses without request and
+// requests never answered (of both client and server) in order of seq
+func CheckProtocol(reader io.Reader) (*ProtocolCheck, error) {
+	check := &ProtocolCheck{}
+	checker := newProtocolChecker()
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.payloadType != JSON {
+			continue
+		}
+		for _, d := range expandBatch(d) {
+			if e, err := d.Envelope(); err == nil {
+				check.Messages++
+				checker.observe(d, e)
+			}
+		}
+	}
+	check.Violations = checker.finish()
+	return check, nil
+}
+
+// Format writes violations one per line (seq can be passed to print --seq)
+func (c *ProtocolCheck) Format(writer io.Writer) {
+	for i := range c.Violations {
+		_, _ = fmt.Fprintln(writer, c.Violations[i].String())
+	}
+	if len(c.Violations) == 0 {
+		_, _ = fmt.Fprintf(writer, "no protocol violations in %d messages\n", c.Messages)
+		return
+	}
+	_, _ = fmt.Fprintf(writer, "\n%d protocol violations in %d messages (%s)\n", len(c.Violations), c.Messages,
+		countViolations(c.Violations))
+}
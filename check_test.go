@@ -0,0 +1,56 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+var checkTestLog = newTestLog(
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{}}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"workspace/applyEdit","params":{}}`)}, // id space of server is separate
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/definition","params":{}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"result":null}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":7,"result":null}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"exit"}`)},
+)
+
+func TestCheckProtocol(t *testing.T) {
+	check, err := CheckProtocol(strings.NewReader(checkTestLog))
+	require.NoError(t, err)
+	assert.Equal(t, 8, check.Messages)
+	require.Len(t, check.Violations, 3)
+	assert.Equal(t, ProtocolViolation{Kind: ViolationDuplicateId, Seq: 5, Time: check.Violations[1].Time, From: "client",
+		Method: "textDocument/definition", Id: "2", Prev: 3}, check.Violations[1])
+
+	out := bytes.Buffer{}
+	check.Format(&out)
+	assert.Equal(t, `#4 2024-05-01T10:00:03Z server request workspace/applyEdit id=2 is never answered
+#5 2024-05-01T10:00:04Z client request textDocument/definition id=2 reuses id of outstanding request #3
+#7 2024-05-01T10:00:06Z server response id=7 matches no outstanding request
+
+3 protocol violations in 8 messages (1 duplicate-id, 1 unknown-response, 1 unanswered)
+`, out.String())
+
+	check, err = CheckProtocol(strings.NewReader(newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"shutdown"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)},
+	)))
+	require.NoError(t, err)
+	out.Reset()
+	check.Format(&out)
+	assert.Equal(t, "no protocol violations in 2 messages\n", out.String())
+}
+
+func TestMessageStatsViolations(t *testing.T) {
+	stats, err := CollectMessageStats(strings.NewReader(checkTestLog))
+	require.NoError(t, err)
+	assert.Len(t, stats.Violations, 3)
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.Contains(t, out.String(), "\nwarning: 3 protocol violations (1 duplicate-id, 1 unknown-response, 1 unanswered), see check subcommand\n")
+}
@@ -0,0 +1,52 @@
+package recorder
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"log/slog"
+)
+
+// DigestAlgorithm is algorithm of LogDigest: SHA-256 over CRC-32C checksums of records in order
+const DigestAlgorithm = "sha256-crc32c"
+
+// checksumTable is CRC-32C (Castagnoli) table, which is hardware accelerated on amd64 and arm64
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// payloadChecksum returns CRC-32C of payload as 8 hex digits (recorded as crc32c attribute)
+func payloadChecksum(payload []byte) string {
+	return hex.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.Checksum(payload, checksumTable)))
+}
+
+// LogDigest is rolling digest over checksums of records of session before trailer, so that lost, duplicated
+// or reordered records are detected by verify even if each remaining record is intact
+type LogDigest struct {
+	Algorithm string `json:"algorithm"`
+	Records   int    `json:"records"` // number of digested records
+	Value     string `json:"value"`
+}
+
+// logDigest computes LogDigest. Debug records are not digested, since they are dropped by conversion at info level
+type logDigest struct {
+	hash    hash.Hash
+	records int
+}
+
+func newLogDigest() *logDigest {
+	return &logDigest{hash: sha256.New()}
+}
+
+// observe digests checksum of record (ignored if record has no checksum)
+func (g *logDigest) observe(d *LogData) {
+	if d.checksum == "" || d.level < slog.LevelInfo {
+		return
+	}
+	g.records++
+	_, _ = g.hash.Write([]byte(d.checksum))
+}
+
+func (g *logDigest) digest() *LogDigest {
+	return &LogDigest{Algorithm: DigestAlgorithm, Records: g.records, Value: hex.EncodeToString(g.hash.Sum(nil))}
+}
@@ -0,0 +1,80 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayloadChecksum(t *testing.T) {
+	assert.Equal(t, "00000000", payloadChecksum(nil))
+	assert.Equal(t, "e3069283", payloadChecksum([]byte("123456789"))) // check value of CRC-32C
+}
+
+// recordChecksumTestLog records a session with checksums
+func recordChecksumTestLog(t *testing.T) string {
+	ch := make(chan LogData, 8)
+	buf := &syncBuffer{}
+	ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: SESSION_START, payload: []byte(`{"pid":1}`)}
+	ch <- LogData{timestamp: time.Now(), streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)}
+	ch <- LogData{timestamp: time.Now(), streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)}
+	ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: RAW, payload: []byte{0xff, 0xfe}}
+	ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: TRAILER}
+	close(ch)
+	record(context.Background(), ch, NewLogger(buf), NewSession(nil), &RunOptions{Checksum: true})
+	log := buf.String()
+	require.Equal(t, 5, strings.Count(log, `"crc32c":`))
+	return log
+}
+
+func TestVerifyLogChecksum(t *testing.T) {
+	log := recordChecksumTestLog(t)
+	trailer, err := ReadTrailer(strings.NewReader(log))
+	require.NoError(t, err)
+	require.NotNil(t, trailer.Digest)
+	assert.Equal(t, DigestAlgorithm, trailer.Digest.Algorithm)
+	assert.Equal(t, 4, trailer.Digest.Records)
+
+	report := VerifyLog(strings.NewReader(log))
+	assert.Equal(t, "clean", report.Verdict)
+	assert.Empty(t, report.Findings)
+	assert.Equal(t, 5, report.Checksums)
+	assert.Equal(t, 1, report.Digests)
+	out := bytes.Buffer{}
+	report.Format(&out)
+	assert.Equal(t, "clean: 5 records, 1 sessions, 5 checksums, 1 digests, 0 findings\n", out.String())
+
+	// checksum is kept in text format
+	converted := bytes.Buffer{}
+	require.NoError(t, ConvertLog(NewLogReader(strings.NewReader(log)), &converted, LogFormatText, 0))
+	report = VerifyLog(strings.NewReader(converted.String()))
+	assert.Equal(t, "clean", report.Verdict)
+	assert.Equal(t, 1, report.Digests)
+}
+
+func TestVerifyLogBadChecksum(t *testing.T) {
+	log := strings.Replace(recordChecksumTestLog(t), `\"result\":{}`, `\"result\":[]`, 1)
+	report := VerifyLog(strings.NewReader(log))
+	assert.Equal(t, SeverityCorrupt, report.Verdict)
+	require.Len(t, report.Findings, 1) // digest is over recorded checksums
+	assert.Equal(t, FindingBadChecksum, report.Findings[0].Kind)
+	assert.Equal(t, 3, report.Findings[0].Line)
+}
+
+func TestVerifyLogBadDigest(t *testing.T) {
+	lines := strings.SplitAfter(recordChecksumTestLog(t), "\n")
+	report := VerifyLog(strings.NewReader(strings.Join(append(lines[:2:2], lines[3:]...), ""))) // lost record
+	assert.Equal(t, SeverityCorrupt, report.Verdict)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, "line 4: corrupt: bad-digest: session has 3 records, but recorded 4", report.Findings[0].String())
+
+	lines[1], lines[2] = lines[2], lines[1] // reordered records
+	report = VerifyLog(strings.NewReader(strings.Join(lines, "")))
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, FindingBadDigest, report.Findings[0].Kind)
+}
@@ -0,0 +1,1353 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alecthomas/kong"
+	recorder "github.com/sekiguchi-nagisa/lsp-recorder"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+)
+
+type CLIRecord struct {
+	Log                []string      `sep:"none" default:"./lsp-recorder.log" help:"Log file path. Repeatable to write several logs at once (e.g. --log a.log.gz --format json-gzip --log /tmp/a.log --format text). Placeholders %Y, %m, %d, %H, %M, %S (start time), %p (pid of recorder) and %b (name of Language Server) are expanded (e.g. /tmp/lsp-%Y%m%d-%H%M%S-%p.log), and missing directories are created. '-' writes log to stderr (text or json), and fd:N to inherited file descriptor N"`
+	Format             []string      `sep:"none" enum:"text,json,json-gzip,json-zstd" default:"json" help:"Log format (text, json, json-gzip, json-zstd). Repeatable, paired with --log in order (single format applies to all logs)"`
+	CompressionLevel   int           `help:"Compression level of json-gzip (1-9) or json-zstd (1-19). Default of format if 0"`
+	MaxSize            string        `placeholder:"SIZE" help:"Rotate log when its size exceeds SIZE (e.g. 100M, 10GB). Old logs are renamed to <log>.1, <log>.2, ... No rotation if empty"`
+	MaxFiles           int           `default:"5" help:"Number of rotated old logs to be kept"`
+	RotateEvery        string        `placeholder:"INTERVAL" help:"Start new log every INTERVAL (e.g. 1h) or at local midnight (midnight), independent of size. Old logs are renamed to <log>.<start time> (e.g. <log>.20240501T100000), and each begins with copy of session metadata. No time-based rotation if empty"`
+	Append             bool          `help:"Append to existing log instead of truncating it (compressed log gets new gzip member or zstd frame)"`
+	FlushInterval      time.Duration `default:"2s" placeholder:"DURATION" help:"Flush json-gzip log within DURATION after record is written, so that log is readable even if recorder is killed (compression ratio is slightly lower). Not flushed until end if 0"`
+	LogLevel           string        `enum:"debug,info" default:"info" help:"Level of records (debug, info). Debug level additionally records low-level events (bytes read/written, header parser states and occupancy of record buffer) as stderr records"`
+	Debug              bool          `help:"Same as --log-level debug"`
+	KillTimeout        time.Duration `default:"5s" help:"Grace period before killing process group of Language Server after client closed or signal was forwarded (wait forever if 0)"`
+	Duration           time.Duration `placeholder:"DURATION" help:"Cut session short after DURATION: Language Server is shut down like SIGTERM, and recorder exits with 124. Unlimited if 0"`
+	AutoShutdown       bool          `default:"true" negatable:"" help:"Send shutdown and exit to Language Server (recorded as synthesized) when client disconnects without exit"`
+	ShutdownTimeout    time.Duration `default:"5s" help:"Grace period before killing process group of Language Server after auto shutdown (wait forever if 0)"`
+	RestartOnExit      bool          `help:"Respawn Language Server which exited without exit notification of client, and replay initialize handshake and open documents to it. Requests in flight are answered with error. Records of each lifetime are tagged with server session. Requires stdio of Language Server (not --raw)"`
+	MaxRestarts        int           `default:"5" placeholder:"N" help:"Give up respawning after N restarts of --restart-on-exit"`
+	RestartBackoff     time.Duration `default:"1s" placeholder:"DURATION" help:"Delay before the first respawn of --restart-on-exit, doubled for each respawn (up to 1m)"`
+	StripAnsi          bool          `default:"true" negatable:"" help:"Strip ANSI escape sequences from recorded stderr"`
+	StderrLines        bool          `default:"true" negatable:"" help:"Record stderr line by line (partial line is recorded after short idle, long line is split at 64KB). --no-stderr-lines records chunks as read (e.g. binary stderr). Pass-through is never delayed"`
+	Env                []string      `sep:"none" placeholder:"KEY=VALUE" help:"Set environment variable of Language Server (unset if only KEY is given). Repeatable"`
+	Cwd                string        `type:"existingdir" placeholder:"DIR" help:"Working directory of Language Server"`
+	NoEnv              bool          `xor:"env" help:"Do not record environment variables (they may contain credentials)"`
+	EnvAllowlist       []string      `xor:"env" aliases:"env-record-allow" placeholder:"GLOB" help:"Record only environment variables whose names match comma-separated globs (e.g. 'PATH,LANG,XDG_*'). Name without * matches only itself. --env-record-allow is alias"`
+	EnvRecordDeny      []string      `placeholder:"GLOB" help:"Record values of environment variables whose names match comma-separated globs (case-insensitive) as <redacted>, so that only their presence is visible. Default is '*TOKEN*,*SECRET*,*KEY*,*PASSWORD*,*PASSWD*,*CREDENTIAL*'"`
+	EnvRecordAll       bool          `help:"Record values of all environment variables, including secret-looking ones denied by default"`
+	Listen             string        `xor:"client" transport:"tcp" help:"Accept client on TCP address (e.g. :2087) instead of stdio"`
+	Connect            string        `xor:"server" transport:"tcp" help:"Connect to Language Server on TCP address (e.g. localhost:2087) instead of stdio. Without executable, recorder bridges stdio client to it, and answers the first request with error if connection fails"`
+	Pipe               string        `xor:"client" transport:"pipe" help:"Accept client on unix domain socket path (named pipe on Windows) instead of stdio"`
+	ServerPipe         string        `xor:"server" transport:"pipe" help:"Connect to Language Server on unix domain socket path (named pipe on Windows) instead of stdio"`
+	WsConnect          string        `xor:"server" transport:"websocket" placeholder:"URL" help:"Connect to Language Server on WebSocket URL (e.g. ws://localhost:3000/lsp) instead of stdio. Each text frame is a message without Content-Length header. Disconnection ends session (no reconnect)"`
+	ConnectTimeout     time.Duration `default:"10s" help:"Timeout for connecting to Language Server (or waiting for its socket)"`
+	RedactText         bool          `help:"Replace document text of didOpen/didChange in log with placeholder having its length and hash"`
+	RedactField        []string      `sep:"none" placeholder:"METHOD=PATH" help:"Also redact string field of method (e.g. my/sync=params.items[].content). Repeatable, implies --redact-text"`
+	AnonymizeUris      bool          `help:"Rewrite file URIs and absolute paths in log into consistent ones like file:///W/d1/f1.go"`
+	Buffer             int           `default:"32" placeholder:"N" help:"Number of records buffered between traffic and log writer. Its peak occupancy and time traffic was blocked by full buffer are recorded in trailer"`
+	DropOnFull         bool          `help:"Drop records instead of delaying traffic when buffer is full (e.g. slow disk). Dropped records are counted in trailer"`
+	MaxPayloadBytes    int           `placeholder:"N" help:"Truncate payload longer than N bytes in log (original size is recorded). Unlimited if 0"`
+	Checksum           bool          `help:"Record CRC-32C of each payload and digest of all records in trailer, so that verify detects corruption of copied log"`
+	MaxContentLength   string        `default:"256M" placeholder:"SIZE" help:"Record message whose Content-Length exceeds SIZE as invalid and pass it through as is until next header. Unlimited if 0"`
+	LenientFraming     bool          `help:"Accept message headers whose lines end with bare \\n instead of \\r\\n, with one-time warning record of the deviation. Stream is passed through as is"`
+	SpillOver          string        `placeholder:"SIZE" help:"Write payload longer than SIZE to its own file instead of holding it in memory. Log has its prefix and the file path. Not redacted, so cannot be combined with --redact-text/--anonymize-uris"`
+	SpillDir           string        `type:"existingdir" placeholder:"DIR" help:"Directory of files of --spill-over (system temp directory if empty)"`
+	Mirror             bool          `help:"Print each record to stderr (prefixed with [lsp-recorder]) in the same format as print while recording"`
+	MirrorFilter       []string      `sep:"none" placeholder:"KEY=VALUE" help:"Mirror only records matching filter (method=GLOB, exclude-method=GLOB or stream=STREAM). Repeatable, implies --mirror"`
+	SuppressToClient   []string      `sep:"none" placeholder:"METHOD[>N/UNIT]" help:"Drop server notifications matching method glob (e.g. window/logMessage, window/logMessage>100/s) from pass-through to client (still recorded). '*' also matches '/' (window/* matches window/workDoneProgress/create), and character classes are not supported. Repeatable"`
+	SampleResources    time.Duration `placeholder:"INTERVAL" help:"Record RSS, CPU time and open fds of Language Server every INTERVAL (e.g. 5s). Not sampled if 0"`
+	HttpSink           string        `placeholder:"URL" help:"Also POST records to collector URL as gzipped NDJSON batches (retried, batches dropped on failure are counted in trailer). File log is disabled by --log ''"`
+	HttpSinkTokenEnv   string        `default:"LSP_RECORDER_SINK_TOKEN" placeholder:"NAME" help:"Environment variable of bearer token of --http-sink"`
+	StreamSocket       string        `placeholder:"PATH" help:"Listen on unix domain socket PATH (TCP address on Windows) and write each record to connected viewers as JSON line (the same as json log) while recording. Slow viewers are disconnected. File log is disabled by --log ''"`
+	SlowRequestWarning time.Duration `default:"30s" placeholder:"DURATION" help:"Record warning (also mirrored) about client request without response for DURATION, and another when the response arrives. Disabled if 0"`
+	Raw                bool          `help:"Record stdin/stdout as read (raw chunks with offsets, including headers) instead of messages, even if stream is broken. Messages are shown by print --reassemble. Disables --auto-shutdown"`
+	Bin                string        `arg:"" optional:"" help:"Language Server executable path (may be omitted if --connect/--server-pipe/--ws-connect is specified)"`
+	Args               []string      `arg:"" optional:"" help:"Additional options/arguments of Language Server"`
+}
+
+// ExitCodeError indicates that recorder should exit with the code (e.g. Language Server exited abnormally)
+type ExitCodeError struct {
+	Code int
+	Err  error // reported before exit (nil if already reported)
+}
+
+func (e *ExitCodeError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("exit with: %d", e.Code)
+}
+
+// exitCutShort is exit code of session cut short by --duration (like timeout command)
+const exitCutShort = 124
+
+// lookPathError checks Language Server executable before log is created (or truncated),
+// so that typo of its path does not wipe previous log. Relative path is resolved in dir like exec.Cmd
+func lookPathError(bin string, dir string) error {
+	path := bin
+	if dir != "" && !filepath.IsAbs(bin) && filepath.Base(bin) != bin {
+		path = filepath.Join(dir, bin)
+	}
+	if _, err := exec.LookPath(path); err != nil {
+		return &ExitCodeError{Code: recorder.NewStartError(bin, err).ExitCode(),
+			Err: fmt.Errorf("cannot run Language Server: %s, caused by %v", bin, err)}
+	}
+	return nil
+}
+
+// runError converts error of Run (already reported) to exit code
+func runError(err error) error {
+	var startErr *recorder.StartError
+	if errors.As(err, &startErr) {
+		return &ExitCodeError{Code: startErr.ExitCode()}
+	}
+	return &ExitCodeError{Code: 1}
+}
+
+func (r *CLIRecord) Run() error {
+	if r.Bin == "" && r.Connect == "" && r.ServerPipe == "" && r.WsConnect == "" {
+		return errors.New("Language Server executable path or --connect/--server-pipe/--ws-connect is required")
+	}
+	if r.Buffer < 1 {
+		return fmt.Errorf("buffer must be positive: %d", r.Buffer)
+	}
+	if r.Duration < 0 {
+		return fmt.Errorf("--duration must not be negative: %s", r.Duration)
+	}
+	if r.Bin != "" {
+		if err := lookPathError(r.Bin, r.Cwd); err != nil {
+			return err
+		}
+	}
+	var filter *recorder.ClientFilter
+	if len(r.SuppressToClient) > 0 {
+		f, err := recorder.NewClientFilter(r.SuppressToClient)
+		if err != nil {
+			return err
+		}
+		filter = f
+	}
+	var redactor *recorder.Redactor
+	if r.RedactText || len(r.RedactField) > 0 {
+		rd, err := recorder.NewRedactor(r.RedactField)
+		if err != nil {
+			return err
+		}
+		redactor = rd
+	}
+	var anonymizer *recorder.URIAnonymizer
+	if r.AnonymizeUris {
+		anonymizer = recorder.NewURIAnonymizer()
+	}
+	var mirror *recorder.Mirror
+	if r.Mirror || len(r.MirrorFilter) > 0 {
+		f, err := recorder.ParseMirrorFilter(r.MirrorFilter)
+		if err != nil {
+			return err
+		}
+		mirror = recorder.NewMirror(recorder.StderrWriter, f)
+	}
+	options := recorder.LogOptions{CompressionLevel: r.CompressionLevel, MaxFiles: r.MaxFiles, Append: r.Append,
+		FlushInterval: r.FlushInterval}
+	if options.FlushInterval == 0 {
+		options.FlushInterval = -1 // disabled
+	}
+	level := slog.LevelInfo
+	if r.Debug || r.LogLevel == "debug" {
+		level = slog.LevelDebug
+	}
+	options.Handler = &slog.HandlerOptions{Level: level}
+	if r.MaxSize != "" {
+		size, err := recorder.ParseByteSize(r.MaxSize)
+		if err != nil {
+			return err
+		}
+		options.MaxSize = size
+	}
+	if r.RotateEvery != "" {
+		every, daily, err := recorder.ParseRotateEvery(r.RotateEvery)
+		if err != nil {
+			return err
+		}
+		options.RotateEvery, options.RotateDaily = every, daily
+	}
+	maxContentLength, err := recorder.ParseByteSize(r.MaxContentLength)
+	if err != nil {
+		return err
+	}
+	var spillOver int64
+	if r.SpillOver != "" {
+		if spillOver, err = recorder.ParseByteSize(r.SpillOver); err != nil {
+			return err
+		}
+	}
+	var maxRestarts int
+	if r.RestartOnExit {
+		maxRestarts = r.MaxRestarts
+	}
+	if options.MaxFiles < 0 {
+		return fmt.Errorf("--max-files must not be negative: %d", options.MaxFiles)
+	}
+	runOptions := recorder.RunOptions{
+		KillTimeout:   r.KillTimeout,
+		HandleSignals: true,
+		StripAnsi:     r.StripAnsi,
+		StderrChunks:  !r.StderrLines,
+		BufferSize:    r.Buffer,
+		DropOnFull:    r.DropOnFull,
+
+		AutoShutdown:        r.AutoShutdown,
+		AutoShutdownTimeout: r.ShutdownTimeout,
+
+		MaxRestarts:    maxRestarts,
+		RestartBackoff: r.RestartBackoff,
+
+		Listen:         r.Listen,
+		Connect:        r.Connect,
+		Pipe:           r.Pipe,
+		ServerPipe:     r.ServerPipe,
+		WsConnect:      r.WsConnect,
+		ConnectTimeout: r.ConnectTimeout,
+		ClientFilter:   filter,
+		Append:         r.Append,
+		NoEnv:          r.NoEnv,
+		ServerEnv:      r.Env,
+		ServerDir:      r.Cwd,
+		EnvAllowlist:   r.EnvAllowlist,
+		EnvDenylist:    r.EnvRecordDeny,
+		EnvRecordAll:   r.EnvRecordAll,
+		Mirror:         mirror,
+
+		MaxPayloadBytes:    r.MaxPayloadBytes,
+		Checksum:           r.Checksum,
+		MaxContentLength:   maxContentLength,
+		LenientFraming:     r.LenientFraming,
+		SpillOver:          spillOver,
+		SpillDir:           r.SpillDir,
+		Redactor:           redactor,
+		Anonymizer:         anonymizer,
+		Raw:                r.Raw,
+		SampleResources:    r.SampleResources,
+		SlowRequestWarning: r.SlowRequestWarning,
+	}
+	if err := runOptions.Validate(r.Bin); err != nil { // before logs are created (or truncated)
+		return err
+	}
+	destinations, err := r.destinations(options)
+	if err != nil {
+		return err
+	}
+	if len(destinations) == 0 && r.HttpSink == "" && r.StreamSocket == "" {
+		return errors.New("--log must not be empty unless --http-sink or --stream-socket is given")
+	}
+	var logPaths []string
+	for _, dest := range destinations {
+		if !slices.Contains(r.Log, dest.Path) { // expanded from template
+			if err := os.MkdirAll(filepath.Dir(dest.Path), 0o755); err != nil {
+				return fmt.Errorf("cannot create directory of log file: %s, caused by %s", dest.Path, err.Error())
+			}
+			_, _ = fmt.Fprintf(recorder.StderrWriter, "[lsp-recorder] log: %s\n", dest.Path)
+		}
+		path := dest.Path
+		if !recorder.IsLogStream(path) {
+			path, _ = filepath.Abs(path)
+		}
+		logPaths = append(logPaths, path)
+	}
+	var sink *recorder.HTTPSink
+	if r.HttpSink != "" {
+		if sink, err = recorder.NewHTTPSink(recorder.HTTPSinkOptions{URL: r.HttpSink, Token: os.Getenv(r.HttpSinkTokenEnv)}); err != nil {
+			return err
+		}
+		destinations = append(destinations, recorder.LogDestination{Path: sink.Name(),
+			Options: recorder.LogOptions{Format: recorder.LogFormatJSON, Handler: options.Handler}, Writer: sink})
+		logPaths = append(logPaths, sink.Name())
+	}
+	if r.StreamSocket != "" {
+		stream, err := recorder.ListenLiveStream(r.StreamSocket)
+		if err != nil {
+			if sink != nil {
+				_ = sink.Close()
+			}
+			return err
+		}
+		_, _ = fmt.Fprintf(recorder.StderrWriter, "[lsp-recorder] stream: %s\n", stream.Addr())
+		destinations = append(destinations, recorder.LogDestination{Path: r.StreamSocket,
+			Options: recorder.LogOptions{Format: recorder.LogFormatJSON, Handler: options.Handler}, Writer: stream})
+	}
+	logger, closer, err := recorder.CreateLogs(destinations)
+	if err != nil {
+		for _, dest := range destinations {
+			if dest.Writer != nil {
+				_ = dest.Writer.Close()
+			}
+		}
+		return err
+	}
+	defer func(closer io.Closer) {
+		if err := closer.Close(); err != nil { // e.g. failure of one of logs
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		}
+	}(closer)
+
+	ctx := context.Background()
+	if r.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, r.Duration, fmt.Errorf("duration %s elapsed", r.Duration))
+		defer cancel()
+	}
+	runOptions.LogPaths, runOptions.Sink = logPaths, sink
+	status, err := recorder.New(recorder.Options{Command: r.Bin, Args: r.Args, Logger: logger, RunOptions: runOptions}).RunContext(ctx)
+	if err != nil {
+		return runError(err) // already reported
+	}
+	if status.CutShort { // regardless of exit code of Language Server terminated by shutdown
+		return &ExitCodeError{Code: exitCutShort}
+	}
+	if status.Code != 0 {
+		return &ExitCodeError{Code: status.Code}
+	}
+	return nil
+}
+
+// destinations pairs --log and --format
+func (r *CLIRecord) destinations(options recorder.LogOptions) ([]recorder.LogDestination, error) {
+	if len(r.Format) != 1 && len(r.Format) != len(r.Log) {
+		return nil, fmt.Errorf("--format must be given once or for each --log (%d logs, %d formats)", len(r.Log), len(r.Format))
+	}
+	var destinations []recorder.LogDestination
+	paths := map[string]bool{}
+	now := time.Now()
+	for i, path := range r.Log {
+		if path == "" { // file log is disabled (e.g. only --http-sink)
+			continue
+		}
+		path, err := recorder.ExpandLogPath(path, now, os.Getpid(), r.Bin)
+		if err != nil {
+			return nil, err
+		}
+		if paths[filepath.Clean(path)] {
+			return nil, fmt.Errorf("same log file is given more than once: %s", path)
+		}
+		paths[filepath.Clean(path)] = true
+		options.Format = r.Format[min(i, len(r.Format)-1)]
+		destinations = append(destinations, recorder.LogDestination{Path: path, Options: options})
+	}
+	return destinations, nil
+}
+
+type CLIUpgrade struct {
+	Input  string `arg:"" type:"existingfile" help:"Old log file path"`
+	Output string `arg:"" help:"Upgraded log file path"`
+}
+
+func (u *CLIUpgrade) Run() error {
+	if filepath.Clean(u.Input) == filepath.Clean(u.Output) {
+		return errors.New("input and output must be different files")
+	}
+	input, err := recorder.OpenLog(u.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	output, err := os.Create(u.Output)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %s, caused by %s", u.Output, err.Error())
+	}
+	defer func(output *os.File) {
+		_ = output.Close()
+	}(output)
+
+	report, err := recorder.Upgrade(input, recorder.NewLogger(output))
+	if err != nil {
+		return err
+	}
+	fmt.Print(report.String())
+	return nil
+}
+
+type CLIPrint struct {
+	Input string   `arg:"" help:"Log file path or glob of rotated logs (e.g. 'session.log*'). - means stdin"`
+	Type  []string `placeholder:"STREAM" help:"Print only records of comma-separated stream types (stdin, stdout, stderr)"`
+
+	Method         []string `sep:"none" placeholder:"GLOB" help:"Print only messages whose method matches glob (e.g. textDocument/*, where '*' also matches '/'). Repeatable"`
+	ExcludeMethod  []string `sep:"none" placeholder:"GLOB" help:"Do not print messages whose method matches glob. Repeatable"`
+	MatchResponses bool     `default:"true" negatable:"" help:"Match responses (and partial results) to method filter by method of the corresponding request"`
+	Since          string   `placeholder:"TIME" help:"Print only records at or after RFC3339 time or offset from start of log (e.g. +5m)"`
+	Until          string   `placeholder:"TIME" help:"Print only records at or before RFC3339 time or offset from start of log (e.g. +1h30s)"`
+	Seq            []int    `help:"Print only records of comma-separated sequence numbers"`
+	Id             []string `sep:"none" help:"Print only request of JSON-RPC id (e.g. 42, abc) with $/cancelRequest targeting it and its response. Ids are unique per direction, so client:42 or server:7 selects request of client or server (both if unqualified). Repeatable"`
+	Related        bool     `help:"With --id, also print requests and notifications of peer while request is pending (with responses to them), and $/progress of its work done and partial result tokens (including tokens created by window/workDoneProgress/create)"`
+	Env            bool     `default:"true" negatable:"" help:"Print environment variables recorded at start of session"`
+
+	CollapsePartialResults bool   `help:"Print partial results ($/progress notifications linked by partialResultToken) without payload"`
+	Color                  string `enum:"auto,always,never" default:"auto" help:"Colorize output (auto: only if stdout is terminal and NO_COLOR is not set)"`
+	Follow                 bool   `short:"f" help:"Keep printing records appended to log like tail -f until interrupted (json-gzip and json-zstd are not supported)"`
+	Timestamps             string `enum:"absolute,relative,delta" default:"absolute" help:"Timestamps of records (relative: offset from first record, delta: offset from previous printed record)"`
+	Output                 string `enum:"pretty,json,compact" default:"pretty" help:"Output mode (pretty: human-readable, json: one JSON object per record, compact: one line per record)"`
+	Query                  string `placeholder:"EXPR" help:"Print only JSON messages whose payload matches expression like '.method == \"textDocument/completion\" && .params.context.triggerKind == 2' (field access, array index, == != < <= > >=, && || !)"`
+	Head                   int    `xor:"range" placeholder:"N" help:"Print only the first N matched records"`
+	Tail                   int    `xor:"range" placeholder:"N" help:"Print only the last N matched records"`
+	Skip                   int    `placeholder:"N" help:"Skip the first N matched records"`
+	Limit                  int    `xor:"range" placeholder:"N" help:"Print at most N matched records after --skip"`
+	ShowIndex              bool   `help:"Print index of each record (its seq) like #42, which can be passed to --seq"`
+	SkipErrors             bool   `help:"Skip broken lines (e.g. truncated last line of interrupted recording) with warning instead of aborting"`
+	Reassemble             bool   `help:"Print messages reconstructed from raw chunks of stdin/stdout recorded by record --raw (broken headers are shown as invalid)"`
+	ServerLogsOnly         bool   `help:"Print only server logs (window/logMessage and $/logTrace notifications) interleaved with stderr"`
+	Timeline               bool   `help:"Print one line per exchange (elapsed time, direction, kind, method, id, size and latency), folding responses onto rows of their requests. Only envelopes of payloads are parsed"`
+	TimelineFormat         string `enum:"text,tsv" default:"text" help:"Format of --timeline (text: aligned columns, tsv: tab-separated values with header for spreadsheets)"`
+	ErrorsOnly             bool   `help:"Print only problems labeled with category: invalid records, error responses, window/showMessage and window/logMessage of type Error, and stderr of server (not messages of recorder). Counts of categories are printed at end"`
+	StripAnsi              bool   `help:"Strip ANSI escape sequences from stderr at display time (for logs recorded with --no-strip-ansi or by old versions)"`
+}
+
+func (p *CLIPrint) Run() error {
+	if p.Follow && p.Input == "-" {
+		return errors.New("--follow does not support stdin")
+	}
+	if p.Follow && p.Tail > 0 {
+		return errors.New("--tail cannot be combined with --follow")
+	}
+	if p.Head < 0 || p.Tail < 0 || p.Skip < 0 || p.Limit < 0 {
+		return errors.New("--head, --tail, --skip and --limit must not be negative")
+	}
+	if p.Related && len(p.Id) == 0 {
+		return errors.New("--related requires --id")
+	}
+	for _, pattern := range append(slices.Clone(p.Method), p.ExcludeMethod...) {
+		if err := recorder.ValidateGlob(pattern); err != nil {
+			return err
+		}
+	}
+	streams, err := recorder.ParseStreamTypes(p.Type)
+	if err != nil {
+		return err
+	}
+	filter := &recorder.PrintFilter{
+		Streams:        streams,
+		Methods:        p.Method,
+		ExcludeMethods: p.ExcludeMethod,
+		MatchResponses: p.MatchResponses,
+		Seqs:           p.Seq,
+		Related:        p.Related,
+		HideEnv:        !p.Env,
+
+		CollapsePartials: p.CollapsePartialResults,
+		Color:            recorder.UseColor(p.Color, os.Stdout),
+		Output:           p.Output,
+		Timestamps:       p.Timestamps,
+		Head:             p.Head,
+		Tail:             p.Tail,
+		Skip:             p.Skip,
+		Limit:            p.Limit,
+		ShowIndex:        p.ShowIndex,
+		Reassemble:       p.Reassemble,
+		ServerLogsOnly:   p.ServerLogsOnly,
+		ErrorsOnly:       p.ErrorsOnly,
+		StripAnsi:        p.StripAnsi,
+	}
+	if p.Query != "" {
+		if filter.Query, err = recorder.ParseQuery(p.Query); err != nil {
+			return err
+		}
+	}
+	for _, id := range p.Id {
+		filter.Ids = append(filter.Ids, recorder.ParsePrintId(id))
+	}
+	if p.Since != "" {
+		if filter.Since, err = recorder.ParseTimeBound(p.Since); err != nil {
+			return err
+		}
+	}
+	if p.Until != "" {
+		if filter.Until, err = recorder.ParseTimeBound(p.Until); err != nil {
+			return err
+		}
+	}
+	if p.SkipErrors {
+		skipped := 0
+		filter.SkipErrors = func(err error) {
+			skipped++
+			_, _ = fmt.Fprintf(os.Stderr, "warning: %v (skipped)\n", err)
+		}
+		defer func() {
+			if skipped > 0 {
+				_, _ = fmt.Fprintf(os.Stderr, "%d broken lines skipped\n", skipped)
+			}
+		}()
+	}
+	writer := bufio.NewWriter(os.Stdout)
+	defer func(writer *bufio.Writer) {
+		_ = writer.Flush()
+	}(writer)
+	print := func(input io.Reader) error {
+		if p.Timeline {
+			return recorder.PrintTimeline(input, writer, filter, p.TimelineFormat)
+		}
+		return recorder.Print(input, writer, filter)
+	}
+	if p.Follow {
+		ctx, stop := signal.NotifyContext(context.Background(), recorder.ShutdownSignals...)
+		defer stop()
+		input, err := recorder.FollowLog(ctx, p.Input, func() { _ = writer.Flush() })
+		if err != nil {
+			return err
+		}
+		defer func(input io.ReadCloser) {
+			_ = input.Close()
+		}(input)
+		return print(input) // exit normally when interrupted
+	}
+
+	input, err := openPrintInput(p.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	if filter.Seekable() && !p.Timeline { // payloads of only matched records are needed
+		index, err := recorder.ReadLogIndex(input, filter.SkipErrors) // written by index command
+		if err == nil && index == nil && filter.Selective() {
+			index, err = recorder.BuildLogIndex(input, filter.SkipErrors)
+		}
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			return recorder.PrintIndex(index, writer, filter)
+		}
+	}
+	return print(input)
+}
+
+// openPrintInput opens log file (or rotated logs matched by glob) or stdin if input is -
+func openPrintInput(input string) (io.ReadCloser, error) {
+	if input == "-" {
+		return recorder.OpenLogStream(os.Stdin)
+	}
+	return recorder.OpenLogs(input)
+}
+
+type CLIGrep struct {
+	Pattern string `arg:"" help:"Regular expression (RE2 syntax) matched against raw payload of each record"`
+	Input   string `arg:"" help:"Log file path or glob of rotated logs (e.g. 'session.log*'). - means stdin"`
+
+	IgnoreCase bool   `short:"i" help:"Match case-insensitively"`
+	Count      bool   `short:"c" help:"Print only number of matched records"`
+	Context    int    `short:"C" placeholder:"N" help:"Print N records before and after each matched record"`
+	Query      string `placeholder:"EXPR" help:"Match only JSON messages whose payload also matches expression (see --query of print)"`
+	Color      string `enum:"auto,always,never" default:"auto" help:"Highlight matches (auto: only if stdout is terminal and NO_COLOR is not set)"`
+	SkipErrors bool   `help:"Skip broken lines with warning instead of aborting"`
+}
+
+func (g *CLIGrep) Run() error {
+	if g.Context < 0 {
+		return errors.New("--context must not be negative")
+	}
+	expr := g.Pattern
+	if g.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	filter := &recorder.PrintFilter{Color: recorder.UseColor(g.Color, os.Stdout)}
+	if g.Query != "" {
+		if filter.Query, err = recorder.ParseQuery(g.Query); err != nil {
+			return err
+		}
+	}
+	if g.SkipErrors {
+		filter.SkipErrors = func(err error) {
+			_, _ = fmt.Fprintf(os.Stderr, "warning: %v (skipped)\n", err)
+		}
+	}
+	input, err := openPrintInput(g.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	writer := bufio.NewWriter(os.Stdout)
+	matched, err := recorder.Grep(input, writer, pattern, g.Context, g.Count, filter)
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return err
+	}
+	if matched == 0 {
+		return &ExitCodeError{Code: 1} // like grep
+	}
+	return nil
+}
+
+type CLIExtract struct {
+	Input  string   `arg:"" help:"Log file path or glob of rotated logs (e.g. 'session.log*'). - means stdin"`
+	OutDir string   `required:"" placeholder:"DIR" help:"Directory where payloads are written as <seq>-<method>.json (created if missing)"`
+	Method []string `sep:"none" placeholder:"GLOB" help:"Extract only messages whose method matches glob (response matches method of its request). Repeatable"`
+	Id     []string `sep:"none" help:"Extract only request of JSON-RPC id (e.g. 42, abc, client:42, server:7) with $/cancelRequest targeting it and its response. Repeatable"`
+	Stream []string `placeholder:"STREAM" help:"Extract only messages of comma-separated stream types (stdin, stdout)"`
+	Last   bool     `help:"Extract only the final matched message"`
+}
+
+func (x *CLIExtract) Run() error {
+	streams, err := recorder.ParseStreamTypes(x.Stream)
+	if err != nil {
+		return err
+	}
+	filter := &recorder.PrintFilter{Streams: streams, Methods: x.Method, MatchResponses: true}
+	for _, id := range x.Id {
+		filter.Ids = append(filter.Ids, recorder.ParsePrintId(id))
+	}
+	if err := os.MkdirAll(x.OutDir, 0o755); err != nil {
+		return err
+	}
+	input, err := openPrintInput(x.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	files, err := recorder.Extract(input, x.OutDir, filter, x.Last)
+	for _, file := range files {
+		fmt.Println(file)
+	}
+	fmt.Printf("%d files written\n", len(files))
+	return err
+}
+
+type CLISplit struct {
+	Input            string `arg:"" help:"Log file path or glob of rotated logs (e.g. 'session.log*'). - means stdin"`
+	By               string `enum:"stream,method" required:"" help:"Split into <stream>.log (stream) or <method>.log (method, response goes with its request, unattributed records go into @other.log)"`
+	OutDir           string `required:"" placeholder:"DIR" help:"Directory where logs are written (created if missing)"`
+	To               string `enum:"text,json,json-gzip,json-zstd" default:"json" help:"Format of output logs (text, json, json-gzip, json-zstd)"`
+	CompressionLevel int    `help:"Compression level of json-gzip (1-9) or json-zstd (1-19). Default of format if 0"`
+}
+
+func (c *CLISplit) Run() error {
+	if err := recorder.CheckLogFormat(c.To, c.CompressionLevel); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.OutDir, 0o755); err != nil {
+		return err
+	}
+	input, err := openPrintInput(c.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	files, err := recorder.Split(input, c.OutDir, c.By, c.To, c.CompressionLevel)
+	for _, file := range files {
+		fmt.Println(file)
+	}
+	return err
+}
+
+type CLIDiff struct {
+	Old     string `arg:"" type:"existingfile" help:"Log of old session"`
+	New     string `arg:"" type:"existingfile" help:"Log of new session"`
+	Payload bool   `help:"Also compare response bodies of matched requests (ignoring id, jsonrpc, resultId and timestamp fields)"`
+	Output  string `enum:"text,json" default:"text" help:"Output format (text, json)"`
+}
+
+func (c *CLIDiff) Run() error {
+	old, err := recorder.OpenLog(c.Old)
+	if err != nil {
+		return err
+	}
+	defer func(old io.ReadCloser) {
+		_ = old.Close()
+	}(old)
+	n, err := recorder.OpenLog(c.New)
+	if err != nil {
+		return err
+	}
+	defer func(n io.ReadCloser) {
+		_ = n.Close()
+	}(n)
+	report, err := recorder.DiffLogs(old, n, c.Payload)
+	if err != nil {
+		return err
+	}
+	if c.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	report.Format(os.Stdout)
+	return nil
+}
+
+type CLICapabilities struct {
+	Input string `arg:"" help:"Log file path or glob of rotated logs (e.g. 'session.log*'). - means stdin"`
+}
+
+func (c *CLICapabilities) Run() error {
+	input, err := openPrintInput(c.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	summary, err := recorder.CollectHandshake(input)
+	if err != nil {
+		return err
+	}
+	summary.Format(os.Stdout)
+	return nil
+}
+
+type CLICheck struct {
+	Input string `arg:"" help:"Log file path or glob of rotated logs (e.g. 'session.log*'). - means stdin"`
+}
+
+func (c *CLICheck) Run() error {
+	input, err := openPrintInput(c.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	check, err := recorder.CheckProtocol(input)
+	if err != nil {
+		return err
+	}
+	check.Format(os.Stdout)
+	if len(check.Violations) > 0 {
+		return &ExitCodeError{Code: 1} // for CI
+	}
+	return nil
+}
+
+type CLIVerify struct {
+	Input  string `arg:"" type:"existingfile" help:"Log file path"`
+	Output string `enum:"text,json" default:"text" help:"Output format (text, json)"`
+}
+
+func (v *CLIVerify) Run() error {
+	input, err := recorder.OpenLog(v.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	report := recorder.VerifyLog(input)
+	if v.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		report.Format(os.Stdout)
+	}
+	if code := report.ExitCode(); code != 0 {
+		return &ExitCodeError{Code: code} // for scripts
+	}
+	return nil
+}
+
+type CLIIndex struct {
+	Input      string `arg:"" type:"existingfile" help:"Log file path (json or text)"`
+	SkipErrors bool   `help:"Skip broken lines with warning instead of aborting"`
+}
+
+// Help is detailed help of index subcommand
+func (x *CLIIndex) Help() string {
+	return "Index maps sequence numbers, methods and ids of records to offsets, so that print with --seq, --id, " +
+		"--method, --since, --until, --tail or --skip seeks matched records instead of scanning. Records appended " +
+		"later are scanned, and stale index (e.g. of rotated log) is ignored. Compressed logs are not indexed, " +
+		"since they cannot be read at offset"
+}
+
+func (x *CLIIndex) Run() error {
+	var skip func(err error)
+	if x.SkipErrors {
+		skip = func(err error) {
+			_, _ = fmt.Fprintf(os.Stderr, "warning: %v (skipped)\n", err)
+		}
+	}
+	n, err := recorder.WriteLogIndex(x.Input, skip)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Printf("indexed %d records into %s\n", n, x.Input+recorder.LogIndexSuffix)
+	return nil
+}
+
+type CLIStats struct {
+	Input    string `arg:"" type:"existingfile" help:"Log file path"`
+	Pipeline bool   `xor:"report" help:"Report timing of recorder pipeline (channel residency and log write)"`
+	Shutdown bool   `xor:"report" help:"Report shutdown/exit sequence (re-derived if log has no trailer)"`
+	Totals   bool   `xor:"report" help:"Report totals of session (duration, messages, bytes and exit status) from trailer (re-derived if log has no trailer)"`
+	Worst    int    `default:"10" help:"Number of worst records (or largest messages of per-method statistics) to show"`
+
+	CapabilityUsage bool `xor:"report" help:"Report used, unused and used-but-not-advertised capabilities of initialize handshake"`
+	Shape           bool `xor:"report" help:"Report JSON shape of the most structurally extreme messages of each method (node count, max depth, largest array and ratio of string bytes to structure bytes). Limited by --worst"`
+
+	Output string `enum:"text,json" default:"text" help:"Output format of per-method statistics (text: tables, json: one document having session (metadata), exit, requests and notifications (method, from, count, errors, pending, bytes, chunks, latency {min_ns, p50_ns, p90_ns, p99_ns, max_ns}, size and response_size {min, p50, p99, max, total}), directions (client and server: messages, bytes), unanswered (method, from, id, timestamp), unmatched and largest (seq, from, method, kind, size). Durations are nanoseconds and sizes are bytes)"`
+}
+
+func (s *CLIStats) Run() error {
+	input, err := recorder.OpenLog(s.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+
+	if s.Output == "json" && (s.Pipeline || s.Shutdown || s.Totals || s.CapabilityUsage || s.Shape) {
+		return errors.New("--output json is supported only by per-method statistics")
+	}
+	if s.Shutdown {
+		trailer, err := recorder.ReadTrailer(input)
+		if err != nil {
+			return err
+		}
+		if trailer.Shutdown == nil {
+			return errors.New("trailer has no shutdown assessment")
+		}
+		trailer.Shutdown.Format(os.Stdout)
+		return nil
+	}
+	if s.Totals {
+		trailer, err := recorder.ReadTrailer(input)
+		if err != nil {
+			return err
+		}
+		trailer.Summary.Format(os.Stdout, "")
+		return nil
+	}
+	if s.CapabilityUsage {
+		report, err := recorder.CollectCapabilityUsage(input)
+		if err != nil {
+			return err
+		}
+		report.Format(os.Stdout)
+		return nil
+	}
+	if s.Shape {
+		stats, err := recorder.CollectShapeStats(input, s.Worst)
+		if err != nil {
+			return err
+		}
+		stats.Format(os.Stdout)
+		return nil
+	}
+	if s.Pipeline {
+		stats, err := recorder.CollectPipelineStats(input)
+		if err != nil {
+			return err
+		}
+		stats.Format(os.Stdout, s.Worst)
+		return nil
+	}
+	stats, err := recorder.CollectMessageStats(input)
+	if err != nil {
+		return err
+	}
+	stats.Top = s.Worst
+	if s.Output == "json" {
+		return stats.FormatJSON(os.Stdout)
+	}
+	stats.Format(os.Stdout)
+	return nil
+}
+
+type CLIImport struct {
+	Input     string `arg:"" type:"existingfile" help:"VS Code log file path (LSP trace of output channels)"`
+	Format    string `enum:"vscode-trace" default:"vscode-trace" help:"Input format (vscode-trace)"`
+	OutputDir string `default:"." type:"existingdir" help:"Directory to write logs of each session (<channel>.log)"`
+	Date      string `placeholder:"YYYY-MM-DD" help:"Date of trace having only time of day (default: modification date of input)"`
+}
+
+func (i *CLIImport) Run() error {
+	input, err := os.Open(i.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input *os.File) {
+		_ = input.Close()
+	}(input)
+
+	date := time.Now()
+	if info, err := input.Stat(); err == nil {
+		date = info.ModTime()
+	}
+	if i.Date != "" {
+		if date, err = time.ParseInLocation("2006-01-02", i.Date, time.Local); err != nil {
+			return fmt.Errorf("invalid date: %s", i.Date)
+		}
+	}
+	y, m, d := date.Date()
+	sessions, err := recorder.NewVscodeTraceReader(time.Date(y, m, d, 0, 0, 0, 0, time.Local)).Read(input)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		path := filepath.Join(i.OutputDir, session.FileName())
+		output, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("cannot open log file: %s, caused by %s", path, err.Error())
+		}
+		session.WriteTo(recorder.NewLogger(output))
+		if err := output.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d records -> %s\n", session.Channel, len(session.Records), path)
+	}
+	return nil
+}
+
+type CLIReplay struct {
+	Log         string        `default:"./lsp-recorder.replay.log" help:"Log file path of replayed session"`
+	Timing      string        `enum:"asap,original" default:"asap" help:"Send messages as soon as possible or with original inter-message timing (asap, original). Responses to server requests are sent when the server asks in either mode"`
+	Speed       string        `default:"1" placeholder:"FACTOR" help:"Speed factor of --timing original (e.g. 0.25, 2 halves delays between messages, max sends without delay)"`
+	Step        bool          `help:"Pause before each message until Enter is pressed on stdin (or count of messages to advance is input)"`
+	WaitTimeout time.Duration `default:"10s" help:"Max duration of waiting for response (or server request) the original client waited on"`
+	KillTimeout time.Duration `default:"5s" help:"Grace period before killing process group of Language Server after client closed or signal was forwarded (wait forever if 0)"`
+	StripAnsi   bool          `default:"true" negatable:"" help:"Strip ANSI escape sequences from recorded stderr"`
+	Compare     bool          `help:"Compare responses of client requests and last diagnostics of each document (as sets) with recorded ones, and exit with 1 on any difference"`
+	IgnorePath  []string      `placeholder:"GLOB" help:"JSON pointer glob of values not compared by --compare (e.g. '*/data/*', '/result/items/*/id'). Repeatable"`
+	Input       string        `arg:"" type:"existingfile" help:"Recorded log file path"`
+	Bin         string        `arg:"" help:"Language Server executable path"`
+	Args        []string      `arg:"" optional:"" help:"Additional options/arguments of Language Server"`
+}
+
+func (r *CLIReplay) Run() error {
+	if filepath.Clean(r.Input) == filepath.Clean(r.Log) {
+		return errors.New("input and log must be different files")
+	}
+	input, err := recorder.OpenLog(r.Input)
+	if err != nil {
+		return err
+	}
+	replayer, err := recorder.NewReplayer(input, recorder.StderrWriter)
+	_ = input.Close()
+	if err != nil {
+		return err
+	}
+	replayer.WaitTimeout = r.WaitTimeout
+	speed, err := recorder.ParseReplaySpeed(r.Speed)
+	if err != nil {
+		return err
+	}
+	if r.Timing == "original" {
+		replayer.Speed = speed
+	} else if speed != 1 {
+		return errors.New("--speed requires --timing original")
+	}
+	if r.Step {
+		replayer.Stepper = recorder.NewStepper(os.Stdin, recorder.StderrWriter)
+	}
+	if len(r.IgnorePath) > 0 && !r.Compare {
+		return errors.New("--ignore-path requires --compare")
+	}
+	replayer.Compare = r.Compare
+	if err := lookPathError(r.Bin, ""); err != nil {
+		return err
+	}
+	logFile, err := os.Create(r.Log)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %s, caused by %s", r.Log, err.Error())
+	}
+	defer func(logFile *os.File) {
+		_ = logFile.Close()
+	}(logFile)
+
+	clientIn, replayOut := io.Pipe() // replayer -> server
+	replayIn, clientOut := io.Pipe() // server -> replayer
+	defer func() {
+		_ = clientIn.Close()
+		_ = clientOut.Close()
+	}()
+	received := make(chan error, 1)
+	go func() {
+		err := replayer.Receive(replayIn)
+		if err != nil {
+			_, _ = io.Copy(io.Discard, replayIn) // server must not be blocked on full pipe
+		}
+		received <- err
+	}()
+	go func() {
+		if err := replayer.Replay(replayOut); err != nil {
+			_, _ = fmt.Fprintf(recorder.StderrWriter, "replay: %v\n", err)
+		}
+		_ = replayOut.Close() // client disconnection
+	}()
+	status, err := recorder.Run(r.Bin, r.Args, recorder.NewLogger(logFile), recorder.RunOptions{
+		KillTimeout:   r.KillTimeout,
+		HandleSignals: true,
+		StripAnsi:     r.StripAnsi,
+		ClientIn:      clientIn,
+		ClientOut:     clientOut,
+	})
+	if err != nil {
+		return runError(err) // already reported
+	}
+	_ = clientOut.Close()
+	if err := <-received; err != nil {
+		return fmt.Errorf("cannot receive messages of server: %w", err)
+	}
+	replayer.FormatLatencies(os.Stdout)
+	if status.Code != 0 {
+		return &ExitCodeError{Code: status.Code}
+	}
+	if r.Compare {
+		comparison := replayer.CompareRecording(r.IgnorePath)
+		_, _ = fmt.Fprintln(os.Stdout)
+		comparison.Format(os.Stdout)
+		if len(comparison.Mismatches) > 0 {
+			return &ExitCodeError{Code: 1} // for CI
+		}
+	}
+	return nil
+}
+
+type CLIExport struct {
+	Input  string `arg:"" type:"existingfile" help:"Log file path"`
+	Format string `enum:"trace,html,sqlite" required:"" help:"Output format (trace: Chrome trace-event JSON for Perfetto/chrome://tracing, html: self-contained report, sqlite: SQLite database having messages table and requests view, which requires -o)"`
+	Output string `short:"o" default:"-" help:"Output file path (- means stdout). Existing SQLite database is replaced"`
+}
+
+func (e *CLIExport) Run() error {
+	input, err := recorder.OpenLog(e.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+
+	if e.Format == "sqlite" { // database is written by driver
+		if e.Output == "-" {
+			return errors.New("sqlite format requires output file (-o out.db)")
+		}
+		return recorder.ExportSQLite(input, e.Output)
+	}
+	output := os.Stdout
+	if e.Output != "-" {
+		if output, err = os.Create(e.Output); err != nil {
+			return fmt.Errorf("cannot open output file: %s, caused by %s", e.Output, err.Error())
+		}
+	}
+	writer := bufio.NewWriter(output)
+	switch e.Format {
+	case "html":
+		err = recorder.ExportHTML(input, writer)
+	default:
+		err = recorder.ExportTrace(input, writer)
+	}
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if output != os.Stdout {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+type CLIServe struct {
+	Input string `arg:"" type:"existingfile" help:"Recorded log file path"`
+}
+
+func (s *CLIServe) Run() error {
+	input, err := recorder.OpenLog(s.Input)
+	if err != nil {
+		return err
+	}
+	server, err := recorder.NewMockServer(input, recorder.StderrWriter)
+	_ = input.Close()
+	if err != nil {
+		return err
+	}
+	return server.Serve(os.Stdin, recorder.NewSyncWriter(os.Stdout))
+}
+
+type CLIState struct {
+	Input       string `arg:"" type:"existingfile" help:"Log file path"`
+	At          string `required:"" placeholder:"SEQ|TIME" help:"Moment of snapshot: sequence number, RFC3339 time or offset from start of log (e.g. +5m)"`
+	StderrLines int    `default:"5" help:"Number of last stderr lines to show"`
+}
+
+func (s *CLIState) Run() error {
+	cursor, err := recorder.ParseStateCursor(s.At)
+	if err != nil {
+		return err
+	}
+	input, err := recorder.OpenLog(s.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+
+	state, err := recorder.ReadSessionState(input, cursor, s.StderrLines)
+	if err != nil {
+		return err
+	}
+	state.Format(os.Stdout)
+	return nil
+}
+
+type CLIConvert struct {
+	To   string `enum:"text,json,json-gzip,json-zstd" required:"" help:"Format of output log (text, json, json-gzip, json-zstd)"`
+	From string `enum:"auto,text,json,json-gzip,json-zstd" default:"auto" help:"Format of input log (auto: detected by content)"`
+
+	CompressionLevel int    `help:"Compression level of json-gzip (1-9) or json-zstd (1-19). Default of format if 0"`
+	Input            string `arg:"" type:"existingfile" help:"Input log file path"`
+	Output           string `arg:"" help:"Output log file path (- means stdout)"`
+}
+
+func (c *CLIConvert) Run() error {
+	input, err := os.Open(c.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input *os.File) {
+		_ = input.Close()
+	}(input)
+	buffered := bufio.NewReader(input)
+	format := c.From
+	if format == "auto" {
+		format = recorder.DetectLogFormat(buffered)
+	}
+	reader, err := recorder.NewFormatLogReader(buffered, format)
+	if err != nil {
+		return err
+	}
+
+	output := os.Stdout
+	if c.Output != "-" {
+		if output, err = os.Create(c.Output); err != nil {
+			return fmt.Errorf("cannot open output file: %s, caused by %s", c.Output, err.Error())
+		}
+	}
+	writer := bufio.NewWriter(output)
+	err = recorder.ConvertLog(reader, writer, c.To, c.CompressionLevel)
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if output != os.Stdout {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+type CLIMerge struct {
+	Inputs []string `arg:"" type:"existingfile" help:"Log file paths (formats are detected by content)"`
+	Output string   `short:"o" required:"" help:"Output log file path (- means stdout)"`
+	To     string   `enum:"text,json,json-gzip,json-zstd" default:"json" help:"Format of output log (text, json, json-gzip, json-zstd)"`
+
+	CompressionLevel int `help:"Compression level of json-gzip (1-9) or json-zstd (1-19). Default of format if 0"`
+}
+
+func (c *CLIMerge) Run() error {
+	if err := recorder.CheckLogFormat(c.To, c.CompressionLevel); err != nil {
+		return err
+	}
+	inputs := make([]recorder.MergeInput, 0, len(c.Inputs))
+	for _, name := range c.Inputs {
+		reader, err := recorder.OpenLogReader(name)
+		if err != nil {
+			return err
+		}
+		defer func(reader *recorder.LogReader) {
+			_ = reader.Close()
+		}(reader)
+		inputs = append(inputs, recorder.MergeInput{Name: name, Reader: reader})
+	}
+
+	output := os.Stdout
+	if c.Output != "-" {
+		var err error
+		if output, err = os.Create(c.Output); err != nil {
+			return fmt.Errorf("cannot open output file: %s, caused by %s", c.Output, err.Error())
+		}
+	}
+	writer := bufio.NewWriter(output)
+	err := recorder.MergeLogs(inputs, writer, c.To, c.CompressionLevel, func(err error) {
+		_, _ = fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	})
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if output != os.Stdout {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+type CLIAnonymize struct {
+	Input         string `arg:"" type:"existingfile" help:"Anonymized log file path"`
+	VerifyAgainst string `type:"existingdir" required:"" placeholder:"WORKSPACE" help:"Verify that no payload contains verbatim line of files under workspace directory"`
+	MinLineLength int    `default:"40" help:"Minimum length of workspace lines to be checked (leading/trailing spaces are trimmed)"`
+}
+
+func (a *CLIAnonymize) Run() error {
+	if a.MinLineLength <= 0 {
+		return errors.New("--min-line-length must be positive")
+	}
+	scanner := recorder.NewLeakScanner(a.MinLineLength)
+	if err := scanner.AddWorkspace(a.VerifyAgainst); err != nil {
+		return err
+	}
+	input, err := recorder.OpenLog(a.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+
+	leaks, err := scanner.FindLeaks(input)
+	if err != nil {
+		return err
+	}
+	for _, leak := range leaks {
+		_, _ = fmt.Fprintln(os.Stdout, leak.String())
+	}
+	if len(leaks) > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "%d leaks of workspace text found\n", len(leaks))
+		return &ExitCodeError{Code: 1}
+	}
+	return nil
+}
+
+type CLIRedact struct {
+	Text             bool     `default:"true" negatable:"" help:"Redact document text of didOpen/didChange"`
+	Field            []string `sep:"none" placeholder:"METHOD=PATH" help:"Also redact string field of method (e.g. my/sync=params.items[].content). Repeatable"`
+	Uris             bool     `help:"Rewrite file URIs and absolute paths into consistent ones like file:///W/d1/f1.go"`
+	CompressionLevel int      `help:"Compression level of json-gzip (1-9) or json-zstd (1-19). Default of format if 0"`
+	Input            string   `arg:"" type:"existingfile" help:"Input log file path"`
+	Output           string   `arg:"" help:"Output log file path (- means stdout). Format is the same as input"`
+}
+
+func (r *CLIRedact) Run() error {
+	if !r.Text && !r.Uris {
+		return errors.New("nothing to redact (--no-text without --uris)")
+	}
+	var redactor *recorder.Redactor
+	if r.Text {
+		rd, err := recorder.NewRedactor(r.Field)
+		if err != nil {
+			return err
+		}
+		redactor = rd
+	}
+	var anonymizer *recorder.URIAnonymizer
+	if r.Uris {
+		anonymizer = recorder.NewURIAnonymizer()
+	}
+	if filepath.Clean(r.Input) == filepath.Clean(r.Output) {
+		return errors.New("output must be different from input")
+	}
+	input, err := os.Open(r.Input)
+	if err != nil {
+		return err
+	}
+	defer func(input *os.File) {
+		_ = input.Close()
+	}(input)
+	buffered := bufio.NewReader(input)
+	format := recorder.DetectLogFormat(buffered)
+	reader, err := recorder.NewFormatLogReader(buffered, format)
+	if err != nil {
+		return err
+	}
+
+	output := os.Stdout
+	if r.Output != "-" {
+		if output, err = os.Create(r.Output); err != nil {
+			return fmt.Errorf("cannot open output file: %s, caused by %s", r.Output, err.Error())
+		}
+	}
+	writer := bufio.NewWriter(output)
+	count, err := recorder.RedactLog(reader, writer, redactor, anonymizer, format, r.CompressionLevel)
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if output != os.Stdout {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%d records redacted\n", count)
+	}
+	return err
+}
+
+type CLIIntrospect struct {
+	Format string `enum:"json" default:"json" help:"Output format (json)"`
+}
+
+func (i *CLIIntrospect) Run(ctx *kong.Context) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(NewCLIModel(ctx.Model, recorder.GetVersion()))
+}
+
+type CLIDoctor struct{}
+
+func (d *CLIDoctor) Run(ctx *kong.Context) error {
+	NewCLIModel(ctx.Model, recorder.GetVersion()).FormatSummary(os.Stdout)
+	return nil
+}
+
+var CLI struct {
+	Version      bool            `short:"v" help:"Show version info"`
+	Record       CLIRecord       `cmd:"" default:"withargs" help:"Run Language Server and record its traffic (default command)"`
+	Print        CLIPrint        `cmd:"" help:"Print log in human-readable format"`
+	Grep         CLIGrep         `cmd:"" help:"Print records whose payload matches regular expression"`
+	Extract      CLIExtract      `cmd:"" help:"Write payloads of selected messages to files (e.g. to attach to bug report)"`
+	Diff         CLIDiff         `cmd:"" help:"Compare requests, errors and latencies of two logs (e.g. before and after upgrading Language Server)"`
+	Capabilities CLICapabilities `cmd:"" help:"Summarize initialize handshake (client/server info, workspace folders, negotiated capabilities)"`
+	Check        CLICheck        `cmd:"" help:"Report protocol violations (duplicate outstanding ids, responses without request, unanswered requests). Exit with 1 if found"`
+	Verify       CLIVerify       `cmd:"" help:"Validate integrity of log (broken lines, unknown types, timestamps, payloads, session markers, truncation). Exit with 0 if clean, 1 if warnings, 2 if corrupt"`
+	Index        CLIIndex        `cmd:"" help:"Write index of log (<log>.idx) for fast print seeks"`
+	Stats        CLIStats        `cmd:"" help:"Show per-method message counts and latencies of log (or other reports)"`
+	Upgrade      CLIUpgrade      `cmd:"" help:"Convert old log into current log format with derived fields"`
+	Import       CLIImport       `cmd:"" help:"Import traces of other tools as logs (one log per session)"`
+	Replay       CLIReplay       `cmd:"" help:"Re-send client traffic of log to newly started Language Server and record the session"`
+	Export       CLIExport       `cmd:"" help:"Convert log into other formats for external viewers"`
+	Serve        CLIServe        `cmd:"" help:"Act as mock Language Server answering client over stdio with responses recorded in log"`
+	State        CLIState        `cmd:"" help:"Show session state (open documents, outstanding requests, progress, diagnostics) at a moment of log"`
+	Convert      CLIConvert      `cmd:"" help:"Convert log between formats (text, json, json-gzip, json-zstd)"`
+	Merge        CLIMerge        `cmd:"" help:"Interleave records of multiple logs (e.g. both legs of proxied session) by timestamp"`
+	Split        CLISplit        `cmd:"" help:"Split log into logs of each stream or each method"`
+	Anonymize    CLIAnonymize    `cmd:"" help:"Check anonymized log (only verification against workspace is supported)"`
+	Redact       CLIRedact       `cmd:"" help:"Replace document text of didOpen/didChange (and optionally file URIs) in log"`
+
+	Introspect CLIIntrospect `cmd:"" help:"Print CLI model (subcommands, flags, capabilities) for tooling"`
+	Doctor     CLIDoctor     `cmd:"" help:"Show summary of version, platform and supported features"`
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/alecthomas/kong"
+	recorder "github.com/sekiguchi-nagisa/lsp-recorder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRecordMissingBinary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "previous.log")
+	require.NoError(t, os.WriteFile(path, []byte("previous session\n"), 0o666))
+	r := &CLIRecord{Log: []string{path}, Format: []string{recorder.LogFormatJSON}, Buffer: recorder.DefaultBufferSize,
+		MaxContentLength: "0", Bin: "./no-such-server"}
+	err := r.Run()
+	var exitCodeError *ExitCodeError
+	require.ErrorAs(t, err, &exitCodeError)
+	assert.Equal(t, 127, exitCodeError.Code)
+	assert.Contains(t, err.Error(), "cannot run Language Server: ./no-such-server")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "previous session\n", string(content)) // not truncated
+}
+
+func TestRecordServerExitedImmediately(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.log")
+	r := &CLIRecord{Log: []string{path}, Format: []string{recorder.LogFormatJSON}, Buffer: recorder.DefaultBufferSize,
+		MaxContentLength: "0", Bin: "sh", Args: []string{"-c", "exit 2"}, NoEnv: true}
+	err := r.Run()
+	var exitCodeError *ExitCodeError
+	require.ErrorAs(t, err, &exitCodeError)
+	assert.Equal(t, 2, exitCodeError.Code)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "failed to start command: sh exited with 2 immediately after start")
+	_, err = recorder.ReadTrailer(strings.NewReader(string(content)))
+	assert.NoError(t, err)
+}
+
+func TestRecordBinaryNotExecutable(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "server")
+	require.NoError(t, os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o644))
+	r := &CLIRecord{Log: []string{filepath.Join(t.TempDir(), "session.log")}, Format: []string{recorder.LogFormatJSON},
+		Buffer: recorder.DefaultBufferSize, MaxContentLength: "0", Bin: bin}
+	var exitCodeError *ExitCodeError
+	require.ErrorAs(t, r.Run(), &exitCodeError)
+	assert.Equal(t, 126, exitCodeError.Code)
+}
+
+func TestRecordEnvAllowlistAlias(t *testing.T) {
+	for _, name := range []string{"--env-allowlist", "--env-record-allow"} {
+		var cli struct {
+			Record CLIRecord `cmd:""`
+		}
+		parser, err := kong.New(&cli)
+		require.NoError(t, err)
+		_, err = parser.Parse([]string{"record", name, "PATH,XDG_*", "server"})
+		require.NoError(t, err, name)
+		assert.Equal(t, []string{"PATH", "XDG_*"}, cli.Record.EnvAllowlist, name)
+	}
+}
+
+func TestRecordDestinations(t *testing.T) {
+	r := &CLIRecord{Log: []string{"a.log.gz", "b.log"}, Format: []string{"json-gzip", "text"}}
+	destinations, err := r.destinations(recorder.LogOptions{MaxFiles: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []recorder.LogDestination{
+		{Path: "a.log.gz", Options: recorder.LogOptions{Format: recorder.LogFormatJSONGzip, MaxFiles: 3}},
+		{Path: "b.log", Options: recorder.LogOptions{Format: recorder.LogFormatText, MaxFiles: 3}},
+	}, destinations)
+
+	r = &CLIRecord{Log: []string{"a.log", "b.log"}, Format: []string{"json"}}
+	destinations, err = r.destinations(recorder.LogOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, recorder.LogFormatJSON, destinations[1].Options.Format)
+
+	r = &CLIRecord{Log: []string{"a.log", "b.log", "c.log"}, Format: []string{"json", "text"}}
+	_, err = r.destinations(recorder.LogOptions{})
+	assert.EqualError(t, err, "--format must be given once or for each --log (3 logs, 2 formats)")
+	r = &CLIRecord{Log: []string{"a.log", "./a.log"}, Format: []string{"json"}}
+	_, err = r.destinations(recorder.LogOptions{})
+	assert.EqualError(t, err, "same log file is given more than once: ./a.log")
+}
+
+func TestRecordTemplatedDestinations(t *testing.T) {
+	dir := t.TempDir()
+	r := &CLIRecord{Log: []string{filepath.Join(dir, "%b", "%p.log")}, Format: []string{"json"}, Bin: "gopls"}
+	destinations, err := r.destinations(recorder.LogOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "gopls", strconv.Itoa(os.Getpid())+".log"), destinations[0].Path)
+
+	r = &CLIRecord{Log: []string{"%p.log", "%p.log"}, Format: []string{"json"}}
+	_, err = r.destinations(recorder.LogOptions{})
+	assert.EqualError(t, err, "same log file is given more than once: "+strconv.Itoa(os.Getpid())+".log")
+}
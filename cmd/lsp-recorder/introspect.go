@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alecthomas/kong"
+	"io"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// IntrospectSchemaVersion is incremented when CLIModel is changed incompatibly
+const IntrospectSchemaVersion = 1
+
+// CLIModel is machine-readable description of CLI for wrapper scripts and editor plugins.
+// Commands and flags may have "since" and "deprecated" annotations taken from struct tags of the grammar
+type CLIModel struct {
+	SchemaVersion int            `json:"schema_version"`
+	Version       string         `json:"version"`
+	Flags         []FlagModel    `json:"flags"`
+	Commands      []CommandModel `json:"commands"`
+	Capabilities  Capabilities   `json:"capabilities"`
+}
+
+type CommandModel struct {
+	Name       string      `json:"name"`
+	Help       string      `json:"help"`
+	Default    bool        `json:"default,omitempty"`
+	Since      string      `json:"since,omitempty"`
+	Deprecated string      `json:"deprecated,omitempty"`
+	Flags      []FlagModel `json:"flags"`
+	Args       []ArgModel  `json:"args"`
+}
+
+type FlagModel struct {
+	Name       string   `json:"name"`
+	Short      string   `json:"short,omitempty"`
+	Aliases    []string `json:"aliases,omitempty"`
+	Type       string   `json:"type"`
+	Help       string   `json:"help"`
+	Default    *string  `json:"default,omitempty"`
+	Enum       []string `json:"enum,omitempty"`
+	Repeatable bool     `json:"repeatable,omitempty"`
+	Negatable  bool     `json:"negatable,omitempty"`
+	Xor        []string `json:"xor,omitempty"`
+	Since      string   `json:"since,omitempty"`
+	Deprecated string   `json:"deprecated,omitempty"`
+}
+
+type ArgModel struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Help       string `json:"help"`
+	Required   bool   `json:"required"`
+	Repeatable bool   `json:"repeatable,omitempty"`
+}
+
+// Capabilities lists major features supported by this build
+type Capabilities struct {
+	Transports    []string `json:"transports"`     // how to talk to client and server
+	ImportFormats []string `json:"import_formats"` // formats accepted by import subcommand
+	LogFormat     string   `json:"log_format"`     // default format of recorded log
+	LogFormats    []string `json:"log_formats"`    // formats accepted by record --format (and convert)
+	Features      []string `json:"features"`
+}
+
+func valueType(v *kong.Value) string {
+	if v.Tag.Type != "" {
+		return v.Tag.Type // like existingfile
+	}
+	t := v.Target.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return "duration"
+	}
+	return t.Kind().String()
+}
+
+func toFlagModel(f *kong.Flag) FlagModel {
+	m := FlagModel{
+		Name:       f.Name,
+		Aliases:    f.Aliases,
+		Type:       valueType(f.Value),
+		Help:       f.Help,
+		Repeatable: f.IsSlice(),
+		Negatable:  f.Tag.Negatable != "",
+		Xor:        f.Xor,
+		Since:      f.Tag.Get("since"),
+		Deprecated: f.Tag.Get("deprecated"),
+	}
+	if f.Short != 0 {
+		m.Short = string(f.Short)
+	}
+	if f.HasDefault {
+		m.Default = &f.Default
+	}
+	if f.Enum != "" {
+		for _, e := range strings.Split(f.Enum, ",") {
+			m.Enum = append(m.Enum, strings.TrimSpace(e))
+		}
+	}
+	return m
+}
+
+func toFlagModels(flags []*kong.Flag) []FlagModel {
+	models := make([]FlagModel, 0, len(flags))
+	for _, f := range flags {
+		if !f.Hidden {
+			models = append(models, toFlagModel(f))
+		}
+	}
+	return models
+}
+
+// NewCLIModel builds CLIModel from kong grammar
+func NewCLIModel(app *kong.Application, version string) *CLIModel {
+	model := &CLIModel{
+		SchemaVersion: IntrospectSchemaVersion,
+		Version:       version,
+		Flags:         toFlagModels(app.Flags),
+		Capabilities: Capabilities{
+			Transports: []string{"stdio"}, // and transports of flags (see transport tag of CLIRecord)
+			Features:   []string{"checksum", "pipeline-timing", "replay", "replay-compare", "restart-on-exit", "shutdown-assessment", "suppress-to-client", "time-rotation", "trailer"},
+		},
+	}
+	for _, child := range app.Children {
+		if child.Hidden || child.Type != kong.CommandNode {
+			continue
+		}
+		cmd := CommandModel{
+			Name:       child.Name,
+			Help:       child.Help,
+			Default:    app.DefaultCmd == child,
+			Since:      child.Tag.Get("since"),
+			Deprecated: child.Tag.Get("deprecated"),
+			Flags:      toFlagModels(child.Flags),
+			Args:       []ArgModel{},
+		}
+		for _, arg := range child.Positional {
+			cmd.Args = append(cmd.Args, ArgModel{
+				Name:       arg.Name,
+				Type:       valueType(arg),
+				Help:       arg.Help,
+				Required:   arg.Required,
+				Repeatable: arg.IsSlice(),
+			})
+		}
+		for _, f := range cmd.Flags {
+			if f.Name != "format" {
+				continue
+			}
+			switch child.Name {
+			case "import":
+				model.Capabilities.ImportFormats = f.Enum
+			case "record":
+				if f.Default != nil {
+					model.Capabilities.LogFormat = *f.Default
+				}
+				model.Capabilities.LogFormats = f.Enum
+			}
+		}
+		if child.Name == "record" {
+			for _, f := range child.Flags {
+				if t := f.Tag.Get("transport"); t != "" && !slices.Contains(model.Capabilities.Transports, t) {
+					model.Capabilities.Transports = append(model.Capabilities.Transports, t)
+				}
+			}
+		}
+		model.Commands = append(model.Commands, cmd)
+	}
+	return model
+}
+
+// FormatSummary writes human-readable summary of model (used by doctor)
+func (m *CLIModel) FormatSummary(writer io.Writer) {
+	_, _ = fmt.Fprintf(writer, "%-16s%s\n", "version:", m.Version)
+	_, _ = fmt.Fprintf(writer, "%-16s%s/%s (%s)\n", "platform:", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	_, _ = fmt.Fprintf(writer, "%-16s%s\n", "transports:", strings.Join(m.Capabilities.Transports, ", "))
+	_, _ = fmt.Fprintf(writer, "%-16s%s\n", "import formats:", strings.Join(m.Capabilities.ImportFormats, ", "))
+	_, _ = fmt.Fprintf(writer, "%-16s%s\n", "log format:", m.Capabilities.LogFormat)
+	_, _ = fmt.Fprintf(writer, "%-16s%s\n", "log formats:", strings.Join(m.Capabilities.LogFormats, ", "))
+	_, _ = fmt.Fprintf(writer, "%-16s%s\n", "features:", strings.Join(m.Capabilities.Features, ", "))
+	_, _ = fmt.Fprintln(writer, "commands:")
+	var deprecated []string
+	for _, cmd := range m.Commands {
+		mark := ""
+		if cmd.Default {
+			mark = " (default)"
+		}
+		_, _ = fmt.Fprintf(writer, "  %-12s%d flags%s\n", cmd.Name, len(cmd.Flags), mark)
+		if cmd.Deprecated != "" {
+			deprecated = append(deprecated, fmt.Sprintf("%s: %s", cmd.Name, cmd.Deprecated))
+		}
+		for _, f := range cmd.Flags {
+			if f.Deprecated != "" {
+				deprecated = append(deprecated, fmt.Sprintf("%s --%s: %s", cmd.Name, f.Name, f.Deprecated))
+			}
+		}
+	}
+	if len(deprecated) == 0 {
+		_, _ = fmt.Fprintln(writer, "no deprecated command/flag")
+		return
+	}
+	_, _ = fmt.Fprintln(writer, "deprecated:")
+	for _, d := range deprecated {
+		_, _ = fmt.Fprintf(writer, "  %s\n", d)
+	}
+}
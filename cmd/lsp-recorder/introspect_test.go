@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func testCLIModel(t *testing.T) *CLIModel {
+	parser, err := kong.New(&CLI)
+	require.NoError(t, err)
+	return NewCLIModel(parser.Model, "test")
+}
+
+// TestIntrospectGolden detects changes of CLI model. Run with -update if the change is intended
+// (and increment IntrospectSchemaVersion if the change is incompatible)
+func TestIntrospectGolden(t *testing.T) {
+	actual, err := json.MarshalIndent(testCLIModel(t), "", "  ")
+	require.NoError(t, err)
+	actual = append(actual, '\n')
+	const path = "testdata/introspect.json"
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(path, actual, 0644))
+	}
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+}
+
+func TestIntrospectModel(t *testing.T) {
+	model := testCLIModel(t)
+	assert.Equal(t, IntrospectSchemaVersion, model.SchemaVersion)
+	assert.Equal(t, []string{"vscode-trace"}, model.Capabilities.ImportFormats)
+	var names []string
+	for _, cmd := range model.Commands {
+		names = append(names, cmd.Name)
+		if cmd.Name == "record" {
+			assert.True(t, cmd.Default)
+		}
+	}
+	assert.Contains(t, names, "replay")
+
+	out := strings.Builder{}
+	model.FormatSummary(&out)
+	assert.Contains(t, out.String(), "version:        test\n")
+	assert.Contains(t, out.String(), "  record      51 flags (default)\n")
+	assert.Contains(t, out.String(), "no deprecated command/flag\n")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alecthomas/kong"
+	recorder "github.com/sekiguchi-nagisa/lsp-recorder"
+	"os"
+)
+
+var version = "" // for version embedding (specified like "-X main.version=v0.1.0")
+
+func main() {
+	recorder.Version = version
+	ctx := kong.Parse(&CLI, kong.UsageOnError())
+	if CLI.Version {
+		fmt.Println(recorder.GetVersion())
+		os.Exit(0)
+	}
+
+	err := ctx.Run()
+	var exitCodeError *ExitCodeError
+	if errors.As(err, &exitCodeError) {
+		if exitCodeError.Err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, exitCodeError.Err.Error())
+		}
+		os.Exit(exitCodeError.Code)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestBuildCLI builds binary and checks that its CLI is the same as CLI model
+// (testdata/introspect.json, see TestIntrospectGolden)
+func TestBuildCLI(t *testing.T) {
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command is not found")
+	}
+	bin := filepath.Join(t.TempDir(), "lsp-recorder")
+	out, err := exec.Command(goCmd, "build", "-ldflags", "-X main.version=test", "-o", bin, ".").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	out, err = exec.Command(bin, "introspect").Output()
+	require.NoError(t, err)
+	var actual CLIModel
+	require.NoError(t, json.Unmarshal(out, &actual))
+	assert.Regexp(t, `^test \(`, actual.Version) // followed by VCS revision
+
+	golden, err := os.ReadFile("testdata/introspect.json")
+	require.NoError(t, err)
+	var expected CLIModel
+	require.NoError(t, json.Unmarshal(golden, &expected))
+	actual.Version = expected.Version
+	assert.Equal(t, expected, actual)
+}
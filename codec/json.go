@@ -0,0 +1,222 @@
+// Package codec has codecs of payloads shared by recorder and analysis of logs
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+type JsonTokenKind int
+
+const (
+	BEGIN_OBJECT JsonTokenKind = iota
+	END_OBJECT
+	BEGIN_ARRAY
+	END_ARRAY
+	KEY
+	STRING
+	NUMBER
+	LITERAL // true, false, null
+)
+
+type JsonToken struct {
+	Kind  JsonTokenKind
+	Start int // start offset of token (inclusive)
+	End   int // end offset of token (exclusive)
+}
+
+// JsonScanner splits JSON text into tokens without unmarshalling.
+// Only performs lightweight validation (string/number/literal syntax)
+type JsonScanner struct {
+	data []byte
+	pos  int
+}
+
+func NewJsonScanner(data []byte) *JsonScanner {
+	return &JsonScanner{data: data}
+}
+
+func (s *JsonScanner) skip() {
+	for ; s.pos < len(s.data); s.pos++ {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+		default:
+			return
+		}
+	}
+}
+
+func (s *JsonScanner) scanString() error {
+	for s.pos++; s.pos < len(s.data); s.pos++ {
+		switch s.data[s.pos] {
+		case '\\':
+			s.pos++
+		case '"':
+			s.pos++
+			return nil
+		}
+	}
+	return errors.New("unterminated string")
+}
+
+// Next returns next token. Returns io.EOF at end of data
+func (s *JsonScanner) Next() (JsonToken, error) {
+	s.skip()
+	if s.pos == len(s.data) {
+		return JsonToken{}, io.EOF
+	}
+	start := s.pos
+	token := func(kind JsonTokenKind) (JsonToken, error) {
+		return JsonToken{Kind: kind, Start: start, End: s.pos}, nil
+	}
+	switch b := s.data[s.pos]; b {
+	case '{':
+		s.pos++
+		return token(BEGIN_OBJECT)
+	case '}':
+		s.pos++
+		return token(END_OBJECT)
+	case '[':
+		s.pos++
+		return token(BEGIN_ARRAY)
+	case ']':
+		s.pos++
+		return token(END_ARRAY)
+	case '"':
+		if err := s.scanString(); err != nil {
+			return JsonToken{}, err
+		}
+		end := s.pos
+		for ; s.pos < len(s.data); s.pos++ { // lookahead ':'
+			if c := s.data[s.pos]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+				break
+			}
+		}
+		kind := STRING
+		if s.pos < len(s.data) && s.data[s.pos] == ':' {
+			kind = KEY
+		}
+		return JsonToken{Kind: kind, Start: start, End: end}, nil
+	case 't', 'f', 'n':
+		for _, lit := range []string{"true", "false", "null"} {
+			if len(s.data)-s.pos >= len(lit) && string(s.data[s.pos:s.pos+len(lit)]) == lit {
+				s.pos += len(lit)
+				return token(LITERAL)
+			}
+		}
+	default:
+		if b == '-' || (b >= '0' && b <= '9') {
+			for s.pos++; s.pos < len(s.data); s.pos++ {
+				c := s.data[s.pos]
+				if !(c >= '0' && c <= '9') && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+					break
+				}
+			}
+			return token(NUMBER)
+		}
+	}
+	return JsonToken{}, fmt.Errorf("invalid character '%c' at %d", s.data[s.pos], s.pos)
+}
+
+// envelopeKeys are members of JSON-RPC message kept by Truncate
+var envelopeKeys = []string{`"jsonrpc"`, `"id"`, `"method"`}
+
+// Cut returns prefix of data not longer than max bytes, cut at UTF-8 character boundary
+func Cut(data []byte, max int) []byte {
+	if len(data) <= max {
+		return data
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(data[n]) {
+		n--
+	}
+	return data[:n]
+}
+
+// member is key and value of top-level object
+type member struct {
+	key   string
+	start int // start offset of key
+	end   int // end offset of value
+}
+
+// objectMembers returns members of top-level JSON object by scanning tokens (see JsonScanner)
+func objectMembers(data []byte) ([]member, error) {
+	scanner := NewJsonScanner(data)
+	if token, err := scanner.Next(); err != nil || token.Kind != BEGIN_OBJECT {
+		return nil, errors.New("not JSON object")
+	}
+	var members []member
+	for {
+		key, err := scanner.Next()
+		if err != nil {
+			return nil, err
+		}
+		if key.Kind == END_OBJECT {
+			return members, nil
+		}
+		if key.Kind != KEY {
+			return nil, fmt.Errorf("key is expected at %d", key.Start)
+		}
+		depth := 0
+		for {
+			token, err := scanner.Next()
+			if err != nil {
+				return nil, err
+			}
+			switch token.Kind {
+			case BEGIN_OBJECT, BEGIN_ARRAY:
+				depth++
+			case END_OBJECT, END_ARRAY:
+				depth--
+			default:
+			}
+			if depth <= 0 {
+				members = append(members, member{key: string(data[key.Start:key.End]), start: key.Start, end: token.End})
+				break
+			}
+		}
+	}
+}
+
+// Truncate returns prefix of payload not longer than max bytes, cut at UTF-8 character boundary (payload as is if
+// not longer). JSON-RPC message is truncated preserving its structure: jsonrpc, id and method are moved to the
+// head, so that they remain with the start of params (or result) however members are ordered
+func Truncate(payload []byte, max int) []byte {
+	if len(payload) <= max {
+		return payload
+	}
+	members, err := objectMembers(payload)
+	if err != nil {
+		return Cut(payload, max)
+	}
+	head := make([]byte, 0, len(payload))
+	head = append(head, '{')
+	var rest []member
+	for _, m := range members {
+		keep := false
+		for _, key := range envelopeKeys {
+			keep = keep || m.key == key
+		}
+		if !keep {
+			rest = append(rest, m)
+			continue
+		}
+		if len(head) > 1 {
+			head = append(head, ',')
+		}
+		head = append(head, payload[m.start:m.end]...)
+	}
+	if len(head) > max { // envelope itself is too long
+		return Cut(payload, max)
+	}
+	for _, m := range rest {
+		if len(head) > 1 {
+			head = append(head, ',')
+		}
+		head = append(head, payload[m.start:m.end]...)
+	}
+	return Cut(append(head, '}'), max)
+}
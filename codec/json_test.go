@@ -0,0 +1,55 @@
+package codec
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestJsonScanner(t *testing.T) {
+	src := `{"id": 1, "a\"b" :[true, "s\\", -1.5e3, null]}`
+	scanner := NewJsonScanner([]byte(src))
+	var kinds []JsonTokenKind
+	var texts []string
+	for {
+		token, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		kinds = append(kinds, token.Kind)
+		texts = append(texts, src[token.Start:token.End])
+	}
+	assert.Equal(t, []JsonTokenKind{BEGIN_OBJECT, KEY, NUMBER, KEY, BEGIN_ARRAY, LITERAL, STRING, NUMBER, LITERAL, END_ARRAY, END_OBJECT}, kinds)
+	assert.Equal(t, []string{"{", `"id"`, "1", `"a\"b"`, "[", "true", `"s\\"`, "-1.5e3", "null", "]", "}"}, texts)
+
+	_, err := NewJsonScanner([]byte(`"abc`)).Next()
+	assert.Error(t, err)
+	_, err = NewJsonScanner([]byte(`nil`)).Next()
+	assert.Error(t, err)
+}
+
+func TestTruncate(t *testing.T) {
+	payload := `{"params":{"textDocument":{"text":"あいう"}}, "method":"textDocument/didOpen","jsonrpc":"2.0","id":7}`
+	assert.Equal(t, payload, string(Truncate([]byte(payload), len(payload)))) // not truncated
+
+	cut := len(`{"method":"textDocument/didOpen","jsonrpc":"2.0","id":7,"params":{"textDocument":{"text":"あ`) + 1
+	assert.Equal(t, `{"method":"textDocument/didOpen","jsonrpc":"2.0","id":7,"params":{"textDocument":{"text":"あ`,
+		string(Truncate([]byte(payload), cut))) // envelope precedes params, and character is not split
+
+	// rebuilt message without whitespace fits
+	assert.Equal(t, `{"method":"textDocument/didOpen","jsonrpc":"2.0","id":7,"params":{"textDocument":{"text":"あいう"}}}`,
+		string(Truncate([]byte(payload), len(payload)-1)))
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(Truncate([]byte(payload), len(payload)-1), &m))
+
+	// envelope too long, batch and broken message are cut as is
+	assert.Equal(t, payload[:10], string(Truncate([]byte(payload), 10)))
+	batch := `[{"jsonrpc":"2.0","method":"a"},{"jsonrpc":"2.0","method":"b"}]`
+	assert.Equal(t, batch[:20], string(Truncate([]byte(batch), 20)))
+	broken := `{"result":[1,2,3],"id":1`
+	assert.Equal(t, broken[:12], string(Truncate([]byte(broken), 12)))
+	assert.Equal(t, "ab", string(Cut([]byte("abい"), 4)))
+}
@@ -0,0 +1,169 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ANSI escape sequences of print output
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+)
+
+// color modes of print output
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// UseColor reports whether output to file is colorized in mode.
+// In auto mode, output is colorized only if file is terminal and NO_COLOR is not set
+func UseColor(mode string, file *os.File) bool {
+	switch mode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func streamColor(t StreamType) string {
+	switch t {
+	case STDIN:
+		return ansiGreen
+	case STDOUT:
+		return ansiCyan
+	default:
+		return ansiYellow
+	}
+}
+
+// formatColorHeader writes timestamp (stamp), stream and note of record with color
+func formatColorHeader(writer io.Writer, d *LogData, stamp string, note string) {
+	_, _ = fmt.Fprintf(writer, "%s%s%s %s%s%s", ansiDim, stamp, ansiReset,
+		streamColor(d.streamType), toString(d.streamType), ansiReset)
+	if note = withRecordNote(d, note); note != "" {
+		_, _ = fmt.Fprintf(writer, " (%s)", note)
+	}
+}
+
+// formatColorLogData writes LogData like formatLogData, but with color.
+// JSON payload is syntax highlighted and invalid message is red
+func formatColorLogData(writer io.Writer, d *LogData, stamp string, note string) {
+	if lineNote, line, ok := recordLine(d); ok {
+		formatColorHeader(writer, d, stamp, lineNote)
+		_, _ = fmt.Fprintf(writer, " %s%s%s\n", ansiDim, line, ansiReset)
+		return
+	}
+	if blockNote, block := recordBlock(d); block != nil {
+		formatColorHeader(writer, d, stamp, blockNote)
+		_, _ = writer.Write([]byte("\n"))
+		block(writer)
+		return
+	}
+	formatColorHeader(writer, d, stamp, note)
+	if d.payloadType != JSON {
+		if d.payloadType == INVALID || d.payloadType == INCOMPLETE {
+			_, _ = fmt.Fprintf(writer, " %s%s%s\n", ansiRed, d.payload, ansiReset)
+		} else {
+			_, _ = fmt.Fprintf(writer, " %s\n", d.payload)
+		}
+		return
+	}
+	if d.originalSize > 0 { // truncated JSON cannot be indented
+		_, _ = fmt.Fprintf(writer, "\n%s\n", d.payload)
+		return
+	}
+	err := indentPayload(d.payload, func(indented []byte) {
+		_, _ = writer.Write([]byte("\n"))
+		_, _ = writer.Write(highlightJSON(indented))
+		_, _ = writer.Write([]byte("\n"))
+	})
+	if err != nil {
+		_, _ = fmt.Fprintf(writer, " %s(unformatted: %v)%s\n", ansiRed, err, ansiReset)
+		_, _ = writer.Write(d.payload)
+		_, _ = writer.Write([]byte("\n"))
+	}
+}
+
+// highlightJSON colorizes valid (indented) JSON. Keys are blue, strings green, numbers cyan,
+// literals magenta and value of "method" is bold
+func highlightJSON(data []byte) []byte {
+	out := bytes.Buffer{}
+	out.Grow(len(data) * 2)
+	method := false // last key is "method"
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for ; end < len(data) && data[end] != '"'; end++ {
+				if data[end] == '\\' {
+					end++
+				}
+			}
+			end++ // closing quote
+			s := data[i:end]
+			next := end
+			for next < len(data) && (data[next] == ' ' || data[next] == '\n') {
+				next++
+			}
+			switch {
+			case next < len(data) && data[next] == ':':
+				out.WriteString(ansiBlue)
+				method = string(s) == `"method"`
+			case method:
+				out.WriteString(ansiBold + ansiGreen)
+				method = false
+			default:
+				out.WriteString(ansiGreen)
+			}
+			out.Write(s)
+			out.WriteString(ansiReset)
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i
+			for end < len(data) && bytes.IndexByte([]byte("+-.0123456789eE"), data[end]) >= 0 {
+				end++
+			}
+			out.WriteString(ansiCyan)
+			out.Write(data[i:end])
+			out.WriteString(ansiReset)
+			method = false
+			i = end
+		case c == 't' || c == 'f' || c == 'n':
+			end := i
+			for end < len(data) && data[end] >= 'a' && data[end] <= 'z' {
+				end++
+			}
+			out.WriteString(ansiMagenta)
+			out.Write(data[i:end])
+			out.WriteString(ansiReset)
+			method = false
+			i = end
+		default:
+			if c == '{' || c == '[' {
+				method = false
+			}
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,65 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestHighlightJSON(t *testing.T) {
+	data := []byte(`{
+  "id": -1.5e3,
+  "method": "textDocument/hover",
+  "params": {
+    "text": "a \"quoted\" {",
+    "ok": true,
+    "none": null,
+    "list": [1, false]
+  }
+}`)
+	highlighted := highlightJSON(data)
+	assert.Equal(t, string(data), ansiPattern.ReplaceAllString(string(highlighted), ""))
+	assert.Contains(t, string(highlighted), ansiBlue+`"id"`+ansiReset+": "+ansiCyan+"-1.5e3"+ansiReset)
+	assert.Contains(t, string(highlighted), ansiBold+ansiGreen+`"textDocument/hover"`+ansiReset)
+	assert.Contains(t, string(highlighted), ansiGreen+`"a \"quoted\" {"`+ansiReset)
+	assert.Contains(t, string(highlighted), ansiMagenta+"true"+ansiReset)
+	assert.Contains(t, string(highlighted), ansiMagenta+"null"+ansiReset)
+	assert.Contains(t, string(highlighted), ansiMagenta+"false"+ansiReset)
+}
+
+func TestPrintColor(t *testing.T) {
+	plain := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(printTestLog), &plain, &PrintFilter{}))
+	colored := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(printTestLog), &colored, &PrintFilter{Color: true}))
+	assert.NotContains(t, plain.String(), "\x1b")
+	assert.Equal(t, plain.String(), ansiPattern.ReplaceAllString(colored.String(), ""))
+	assert.Contains(t, colored.String(), ansiDim+"2024-05-01T10:00:00Z"+ansiReset+" "+ansiYellow+"<stderr>"+ansiReset)
+	assert.Contains(t, colored.String(), ansiRed+"invalid message header: 'hoge'"+ansiReset)
+
+	collapsed := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(partialResultTestLog), &collapsed, &PrintFilter{CollapsePartials: true}))
+	colored.Reset()
+	require.NoError(t, Print(strings.NewReader(partialResultTestLog), &colored, &PrintFilter{CollapsePartials: true, Color: true}))
+	assert.Equal(t, collapsed.String(), ansiPattern.ReplaceAllString(colored.String(), ""))
+}
+
+func TestUseColor(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	defer func() {
+		_ = file.Close()
+	}()
+	assert.True(t, UseColor(ColorAlways, file))
+	assert.False(t, UseColor(ColorNever, file))
+	assert.False(t, UseColor(ColorAuto, file)) // not terminal
+	t.Setenv("NO_COLOR", "1")
+	assert.True(t, UseColor(ColorAlways, file))
+}
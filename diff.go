@@ -0,0 +1,425 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// diffOp is edit of sequence: '=' (a[A] is kept as b[B]), '-' (a[A] is removed) or '+' (b[B] is added)
+type diffOp struct {
+	Kind byte
+	A, B int
+}
+
+// diffSequences returns shortest edit script from a to b (Myers' algorithm).
+// Returns false if more than maxEdits insertions and deletions are needed
+func diffSequences(a, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	offset := limit + 1
+	v := make([]int, 2*limit+3) // furthest x of each diagonal k (index offset+k)
+	var trace [][]int           // trace[d][k+d] is furthest x of diagonal k after d edits
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // insertion
+			} else {
+				x = v[offset+k-1] + 1 // deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(trace, n, m), true
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+	return nil, false
+}
+
+// backtrackDiff reconstructs edit script from trace of diffSequences (len(trace) is number of edits)
+func backtrackDiff(trace [][]int, n, m int) []diffOp {
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace); d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		prevK := k - 1 // deletion
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1 // insertion
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		midX := prevX
+		if prevK == k-1 {
+			midX++
+		}
+		for x > midX {
+			x--
+			y--
+			ops = append(ops, diffOp{Kind: '=', A: x, B: y})
+		}
+		if prevK == k+1 {
+			ops = append(ops, diffOp{Kind: '+', A: prevX, B: prevY})
+		} else {
+			ops = append(ops, diffOp{Kind: '-', A: prevX, B: prevY})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{Kind: '=', A: x, B: y})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// maxAlignEdits is max number of added and removed requests aligned by diffSequences.
+// Sessions differing more are aligned by occurrence of each method
+const maxAlignEdits = 2000
+
+// maxPayloadDiffLines is max number of differing lines shown for response body of changed exchange
+const maxPayloadDiffLines = 20
+
+// diffExchange is request of session with its response
+type diffExchange struct {
+	Seq      int
+	From     StreamType
+	Method   string
+	Status   string          // ok, error or pending (no response)
+	Latency  time.Duration   // 0 if pending
+	response json.RawMessage // response payload (kept only for payload comparison)
+}
+
+// key is identity of exchange in alignment (ids differ between sessions, so method and sender are used)
+func (x *diffExchange) key() string {
+	return senderOf(x.From) + " " + x.Method
+}
+
+// diffSession is requests and message counts of a log
+type diffSession struct {
+	exchanges []*diffExchange
+	counts    map[string]int // number of requests and notifications of each method
+}
+
+// collectDiffSession reads requests (with responses) and message counts of log.
+// Response payloads are kept if payload is set
+func collectDiffSession(reader io.Reader, payload bool) (*diffSession, error) {
+	s := &diffSession{counts: map[string]int{}}
+	pending := map[string]*diffExchange{} // key is requestKey of request
+	err := scanRecords(reader, &PrintFilter{}, func(d *LogData, e *Envelope, p pairing) bool {
+		switch {
+		case e == nil || p.partial:
+		case e.IsRequest():
+			x := &diffExchange{Seq: d.seq, From: d.streamType, Method: e.Method, Status: "pending"}
+			s.exchanges = append(s.exchanges, x)
+			s.counts[e.Method]++
+			pending[pairKey(d, d.streamType, e.Id)] = x
+		case e.IsNotification():
+			s.counts[e.Method]++
+		case p.request != nil:
+			key := pairKey(d, p.request.stream, p.request.id)
+			x, ok := pending[key]
+			if !ok {
+				break
+			}
+			delete(pending, key)
+			x.Status = "ok"
+			if !isNullOrEmpty(e.Error) {
+				x.Status = "error"
+			}
+			x.Latency = d.recordTime().Sub(p.request.at)
+			if payload {
+				x.response = d.payload
+			}
+		}
+		return false
+	})
+	return s, err
+}
+
+// alignExchanges aligns requests of sessions by sequence of methods (ids differ between sessions).
+// If sessions differ too much, k-th request of each method is aligned with k-th one of the other session
+func alignExchanges(old, new []*diffExchange) []diffOp {
+	a, b := make([]string, len(old)), make([]string, len(new))
+	for i, x := range old {
+		a[i] = x.key()
+	}
+	for i, x := range new {
+		b[i] = x.key()
+	}
+	if ops, ok := diffSequences(a, b, maxAlignEdits); ok {
+		return ops
+	}
+	var ops []diffOp
+	occurrences := map[string][]int{} // indices of new requests of each key not aligned yet
+	for i, key := range b {
+		occurrences[key] = append(occurrences[key], i)
+	}
+	aligned := make([]bool, len(b))
+	for i, key := range a {
+		if indices := occurrences[key]; len(indices) > 0 {
+			ops = append(ops, diffOp{Kind: '=', A: i, B: indices[0]})
+			occurrences[key] = indices[1:]
+			aligned[indices[0]] = true
+		} else {
+			ops = append(ops, diffOp{Kind: '-', A: i})
+		}
+	}
+	for i := range b {
+		if !aligned[i] {
+			ops = append(ops, diffOp{Kind: '+', B: i})
+		}
+	}
+	return ops
+}
+
+// DiffMethod is number of messages (requests and notifications) of method in each session
+type DiffMethod struct {
+	Method string `json:"method"`
+	Old    int    `json:"old"`
+	New    int    `json:"new"`
+}
+
+// DiffExchange is request found in only one session
+type DiffExchange struct {
+	Seq    int    `json:"seq"`
+	From   string `json:"from"` // client or server
+	Method string `json:"method"`
+	Status string `json:"status"` // ok, error or pending
+}
+
+// DiffChange is aligned request whose result differs between sessions
+type DiffChange struct {
+	OldSeq      int      `json:"old_seq"`
+	NewSeq      int      `json:"new_seq"`
+	From        string   `json:"from"`
+	Method      string   `json:"method"`
+	OldStatus   string   `json:"old_status"`
+	NewStatus   string   `json:"new_status"`
+	PayloadDiff []string `json:"payload_diff,omitempty"` // differing lines of response bodies ("- ..." or "+ ...")
+}
+
+// DiffLatency is latency distribution of method in each session (milliseconds)
+type DiffLatency struct {
+	Method   string  `json:"method"`
+	OldCount int     `json:"old_count"`
+	NewCount int     `json:"new_count"`
+	OldP50   float64 `json:"old_p50_ms"`
+	NewP50   float64 `json:"new_p50_ms"`
+	OldP95   float64 `json:"old_p95_ms"`
+	NewP95   float64 `json:"new_p95_ms"`
+}
+
+// DiffReport is difference between two sessions
+type DiffReport struct {
+	Methods   []DiffMethod   `json:"methods"`
+	Matched   int            `json:"matched"` // number of aligned requests
+	Added     []DiffExchange `json:"added"`
+	Removed   []DiffExchange `json:"removed"`
+	Changed   []DiffChange   `json:"changed"`
+	Latencies []DiffLatency  `json:"latencies"`
+}
+
+// DiffLogs compares sessions of old and new logs. Requests are aligned by sequence of methods, and aligned ones
+// whose status (ok, error or pending) differ are reported as changed. If payload is set, response bodies
+// of aligned requests are also compared ignoring volatile fields (see normalizeResponse)
+func DiffLogs(old, new io.Reader, payload bool) (*DiffReport, error) {
+	oldSession, err := collectDiffSession(old, payload)
+	if err != nil {
+		return nil, fmt.Errorf("old log: %v", err)
+	}
+	newSession, err := collectDiffSession(new, payload)
+	if err != nil {
+		return nil, fmt.Errorf("new log: %v", err)
+	}
+	report := &DiffReport{Added: []DiffExchange{}, Removed: []DiffExchange{}, Changed: []DiffChange{}}
+	toExchange := func(x *diffExchange) DiffExchange {
+		return DiffExchange{Seq: x.Seq, From: senderOf(x.From), Method: x.Method, Status: x.Status}
+	}
+	for _, op := range alignExchanges(oldSession.exchanges, newSession.exchanges) {
+		switch op.Kind {
+		case '-':
+			report.Removed = append(report.Removed, toExchange(oldSession.exchanges[op.A]))
+		case '+':
+			report.Added = append(report.Added, toExchange(newSession.exchanges[op.B]))
+		default:
+			report.Matched++
+			o, n := oldSession.exchanges[op.A], newSession.exchanges[op.B]
+			var lines []string
+			if payload && o.Status != "pending" && n.Status != "pending" {
+				lines = diffResponses(o.response, n.response)
+			}
+			if o.Status != n.Status || len(lines) > 0 {
+				report.Changed = append(report.Changed, DiffChange{OldSeq: o.Seq, NewSeq: n.Seq, From: senderOf(o.From),
+					Method: o.Method, OldStatus: o.Status, NewStatus: n.Status, PayloadDiff: lines})
+			}
+		}
+	}
+	methods := map[string]bool{}
+	for method := range oldSession.counts {
+		methods[method] = true
+	}
+	for method := range newSession.counts {
+		methods[method] = true
+	}
+	for method := range methods {
+		report.Methods = append(report.Methods,
+			DiffMethod{Method: method, Old: oldSession.counts[method], New: newSession.counts[method]})
+	}
+	sort.Slice(report.Methods, func(i, j int) bool { return report.Methods[i].Method < report.Methods[j].Method })
+	report.Latencies = diffLatencies(oldSession, newSession)
+	return report, nil
+}
+
+// diffLatencies returns latency distribution of answered requests of each method
+func diffLatencies(old, new *diffSession) []DiffLatency {
+	collect := func(s *diffSession) map[string][]time.Duration {
+		latencies := map[string][]time.Duration{}
+		for _, x := range s.exchanges {
+			if x.Status != "pending" {
+				latencies[x.Method] = append(latencies[x.Method], x.Latency)
+			}
+		}
+		return latencies
+	}
+	oldLatencies, newLatencies := collect(old), collect(new)
+	methods := map[string]bool{}
+	for method := range oldLatencies {
+		methods[method] = true
+	}
+	for method := range newLatencies {
+		methods[method] = true
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	var ret []DiffLatency
+	for method := range methods {
+		o, n := sortDurations(oldLatencies[method]), sortDurations(newLatencies[method])
+		ret = append(ret, DiffLatency{Method: method, OldCount: len(o), NewCount: len(n),
+			OldP50: ms(percentile(o, 50)), NewP50: ms(percentile(n, 50)),
+			OldP95: ms(percentile(o, 95)), NewP95: ms(percentile(n, 95))})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Method < ret[j].Method })
+	return ret
+}
+
+// normalizeResponse returns indented lines of response without fields which differ between sessions
+// regardless of server behavior (id and jsonrpc of envelope, resultId and timestamp at any depth)
+func normalizeResponse(payload []byte) []string {
+	var v any
+	if json.Unmarshal(payload, &v) != nil {
+		return strings.Split(string(payload), "\n") // compared as is
+	}
+	if m, ok := v.(map[string]any); ok {
+		delete(m, "id")
+		delete(m, "jsonrpc")
+	}
+	var strip func(v any)
+	strip = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			delete(v, "resultId")
+			delete(v, "timestamp")
+			for _, e := range v {
+				strip(e)
+			}
+		case []any:
+			for _, e := range v {
+				strip(e)
+			}
+		}
+	}
+	strip(v)
+	data, _ := json.MarshalIndent(v, "", "  ")
+	return strings.Split(string(data), "\n")
+}
+
+// diffResponses returns differing lines of normalized responses (empty if they are the same).
+// At most maxPayloadDiffLines lines are returned
+func diffResponses(old, new []byte) []string {
+	a, b := normalizeResponse(old), normalizeResponse(new)
+	ops, ok := diffSequences(a, b, maxAlignEdits)
+	if !ok {
+		return []string{"(response bodies differ too much to show)"}
+	}
+	var lines []string
+	changed := 0
+	for _, op := range ops {
+		var line string
+		switch op.Kind {
+		case '-':
+			line = "- " + strings.TrimSpace(a[op.A])
+		case '+':
+			line = "+ " + strings.TrimSpace(b[op.B])
+		default:
+			continue
+		}
+		if changed++; changed <= maxPayloadDiffLines {
+			lines = append(lines, line)
+		}
+	}
+	if changed > maxPayloadDiffLines {
+		lines = append(lines, fmt.Sprintf("... %d more lines", changed-maxPayloadDiffLines))
+	}
+	return lines
+}
+
+// Format writes report in text
+func (r *DiffReport) Format(writer io.Writer) {
+	_, _ = fmt.Fprintln(writer, "methods (old -> new messages, + appeared, - disappeared):")
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	for _, m := range r.Methods {
+		mark := " "
+		switch {
+		case m.Old == 0:
+			mark = "+"
+		case m.New == 0:
+			mark = "-"
+		}
+		_, _ = fmt.Fprintf(tw, "  %s %s\t%d -> %d\n", mark, m.Method, m.Old, m.New)
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintf(writer, "\nrequests: %d matched, %d added, %d removed, %d changed\n",
+		r.Matched, len(r.Added), len(r.Removed), len(r.Changed))
+	for _, x := range r.Added {
+		_, _ = fmt.Fprintf(writer, "  + #%d %s %s (%s)\n", x.Seq, x.From, x.Method, x.Status)
+	}
+	for _, x := range r.Removed {
+		_, _ = fmt.Fprintf(writer, "  - #%d %s %s (%s)\n", x.Seq, x.From, x.Method, x.Status)
+	}
+	for _, c := range r.Changed {
+		_, _ = fmt.Fprintf(writer, "  ~ #%d -> #%d %s %s: %s -> %s\n", c.OldSeq, c.NewSeq, c.From, c.Method,
+			c.OldStatus, c.NewStatus)
+		for _, line := range c.PayloadDiff {
+			_, _ = fmt.Fprintf(writer, "      %s\n", line)
+		}
+	}
+
+	_, _ = fmt.Fprintln(writer, "\nlatency (ms):")
+	tw = tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "method\told count\tnew count\told p50\tnew p50\told p95\tnew p95\tp50 change")
+	for _, l := range r.Latencies {
+		change := "-"
+		if l.OldCount > 0 && l.NewCount > 0 && l.OldP50 > 0 {
+			change = fmt.Sprintf("%+.0f%%", (l.NewP50-l.OldP50)/l.OldP50*100)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%s\n", l.Method, l.OldCount, l.NewCount,
+			l.OldP50, l.NewP50, l.OldP95, l.NewP95, change)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,153 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// applyDiff rebuilds b from a by edit script (and checks script is consistent)
+func applyDiff(t *testing.T, a, b []string, ops []diffOp) []string {
+	out := []string{}
+	ai, bi := 0, 0
+	for _, op := range ops {
+		switch op.Kind {
+		case '=':
+			require.Equal(t, ai, op.A)
+			require.Equal(t, bi, op.B)
+			require.Equal(t, a[op.A], b[op.B])
+			out = append(out, a[op.A])
+			ai++
+			bi++
+		case '-':
+			require.Equal(t, ai, op.A)
+			ai++
+		case '+':
+			require.Equal(t, bi, op.B)
+			out = append(out, b[op.B])
+			bi++
+		}
+	}
+	require.Equal(t, len(a), ai)
+	return out
+}
+
+func TestDiffSequences(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"abc", "abc", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"abcabba", "cbabac", 5},
+		{"xaby", "ab", 2},
+		{"ab", "xaby", 2},
+	}
+	for _, c := range cases {
+		a, b := strings.Split(c.a, ""), strings.Split(c.b, "")
+		ops, ok := diffSequences(a, b, 100)
+		require.True(t, ok, c)
+		assert.Equal(t, b, applyDiff(t, a, b, ops), c)
+		edits := 0
+		for _, op := range ops {
+			if op.Kind != '=' {
+				edits++
+			}
+		}
+		assert.Equal(t, c.edits, edits, c)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		a, b := make([]string, r.Intn(20)), make([]string, r.Intn(20))
+		for j := range a {
+			a[j] = string(rune('a' + r.Intn(3)))
+		}
+		for j := range b {
+			b[j] = string(rune('a' + r.Intn(3)))
+		}
+		ops, ok := diffSequences(a, b, 100)
+		require.True(t, ok)
+		assert.Equal(t, b, applyDiff(t, a, b, ops), "%v %v", a, b)
+	}
+	_, ok := diffSequences(strings.Split("abcdef", ""), strings.Split("uvwxyz", ""), 5)
+	assert.False(t, ok)
+}
+
+func newDiffTestLog(latency time.Duration, exchanges ...[2]string) string {
+	var data []LogData
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i, x := range exchanges {
+		start := base.Add(time.Duration(i) * time.Second)
+		data = append(data, LogData{streamType: STDIN, payloadType: JSON, payload: []byte(x[0]), timestamp: start})
+		if x[1] != "" {
+			data = append(data, LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(x[1]), timestamp: start.Add(latency)})
+		}
+	}
+	return newTestLog(data...)
+}
+
+func TestDiffLogs(t *testing.T) {
+	old := newDiffTestLog(10*time.Millisecond,
+		[2]string{`{"jsonrpc":"2.0","id":1,"method":"initialize"}`, `{"jsonrpc":"2.0","id":1,"result":{"capabilities":{}}}`},
+		[2]string{`{"jsonrpc":"2.0","method":"initialized"}`, ""},
+		[2]string{`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover"}`, `{"jsonrpc":"2.0","id":2,"result":{"contents":"a"}}`},
+		[2]string{`{"jsonrpc":"2.0","id":3,"method":"textDocument/codeLens"}`, `{"jsonrpc":"2.0","id":3,"result":[]}`},
+		[2]string{`{"jsonrpc":"2.0","id":4,"method":"textDocument/semanticTokens/full"}`, `{"jsonrpc":"2.0","id":4,"result":{"resultId":"1","data":[1]}}`},
+	)
+	new := newDiffTestLog(20*time.Millisecond,
+		[2]string{`{"jsonrpc":"2.0","id":10,"method":"initialize"}`, `{"jsonrpc":"2.0","id":10,"result":{"capabilities":{}}}`},
+		[2]string{`{"jsonrpc":"2.0","method":"initialized"}`, ""},
+		[2]string{`{"jsonrpc":"2.0","id":11,"method":"workspace/diagnostic"}`, `{"jsonrpc":"2.0","id":11,"result":{}}`},
+		[2]string{`{"jsonrpc":"2.0","id":12,"method":"textDocument/hover"}`, `{"jsonrpc":"2.0","id":12,"error":{"code":-32603,"message":"x"}}`},
+		[2]string{`{"jsonrpc":"2.0","id":13,"method":"textDocument/semanticTokens/full"}`, `{"jsonrpc":"2.0","id":13,"result":{"resultId":"2","data":[2]}}`},
+	)
+	report, err := DiffLogs(strings.NewReader(old), strings.NewReader(new), false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Matched)
+	assert.Equal(t, []DiffExchange{{Seq: 4, From: "client", Method: "workspace/diagnostic", Status: "ok"}}, report.Added)
+	assert.Equal(t, []DiffExchange{{Seq: 6, From: "client", Method: "textDocument/codeLens", Status: "ok"}}, report.Removed)
+	assert.Equal(t, []DiffChange{{OldSeq: 4, NewSeq: 6, From: "client", Method: "textDocument/hover", OldStatus: "ok", NewStatus: "error"}},
+		report.Changed)
+	assert.Contains(t, report.Methods, DiffMethod{Method: "textDocument/codeLens", Old: 1, New: 0})
+	assert.Contains(t, report.Methods, DiffMethod{Method: "initialized", Old: 1, New: 1})
+	assert.Contains(t, report.Latencies, DiffLatency{Method: "initialize", OldCount: 1, NewCount: 1, OldP50: 10, NewP50: 20, OldP95: 10, NewP95: 20})
+
+	report, err = DiffLogs(strings.NewReader(old), strings.NewReader(new), true) // resultId is ignored
+	require.NoError(t, err)
+	require.Len(t, report.Changed, 2)
+	assert.Equal(t, "textDocument/semanticTokens/full", report.Changed[1].Method)
+	assert.Equal(t, []string{"- 1", "+ 2"}, report.Changed[1].PayloadDiff)
+	assert.Equal(t, []string{"- \"result\": {", "- \"contents\": \"a\"", "+ \"error\": {", "+ \"code\": -32603,", "+ \"message\": \"x\""},
+		report.Changed[0].PayloadDiff)
+
+	out := bytes.Buffer{}
+	report.Format(&out)
+	assert.Contains(t, out.String(), "  + workspace/diagnostic              0 -> 1\n")
+	assert.Contains(t, out.String(), "requests: 3 matched, 1 added, 1 removed, 2 changed\n")
+	assert.Contains(t, out.String(), "  ~ #4 -> #6 client textDocument/hover: ok -> error\n")
+	assert.Contains(t, out.String(), "      + 2\n")
+	assert.Contains(t, out.String(), "+100%")
+}
+
+func TestAlignExchangesByOccurrence(t *testing.T) {
+	var old, new []*diffExchange
+	for i := 0; i < maxAlignEdits+10; i++ {
+		old = append(old, &diffExchange{Seq: i, Method: fmt.Sprintf("a%d", i)})
+		new = append(new, &diffExchange{Seq: i, Method: fmt.Sprintf("b%d", i)})
+	}
+	old = append(old, &diffExchange{Method: "same"}, &diffExchange{Method: "same"})
+	new = append(new, &diffExchange{Method: "same"})
+	ops := alignExchanges(old, new)
+	counts := map[byte]int{}
+	for _, op := range ops {
+		counts[op.Kind]++
+	}
+	assert.Equal(t, map[byte]int{'=': 1, '-': maxAlignEdits + 11, '+': maxAlignEdits + 10}, counts)
+}
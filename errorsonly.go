@@ -0,0 +1,64 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// categories of records printed by PrintFilter.ErrorsOnly (in order of summary)
+const (
+	problemInvalid       = "invalid"        // INVALID record (broken header or non-JSON payload)
+	problemErrorResponse = "error response" // JSON-RPC response having error member
+	problemErrorMessage  = "error message"  // window/showMessage or window/logMessage of type Error
+	problemStderr        = "stderr"         // stderr output of server
+)
+
+var problemCategories = []string{problemInvalid, problemErrorResponse, problemErrorMessage, problemStderr}
+
+const showMessageMethod = "window/showMessage"
+
+// messageTypeError is MessageType of error of window/showMessage and window/logMessage
+const messageTypeError = 1
+
+// problemOf returns category of record selected by --errors-only (empty if record is not problem).
+// e is nil if record is not JSON-RPC message, and payload is inspected only if it is
+func problemOf(d *LogData, e *Envelope) string {
+	switch {
+	case d.payloadType == INVALID:
+		return problemInvalid
+	case d.streamType == STDERR:
+		if d.payloadType == RAW && d.level >= slog.LevelInfo && !d.recorder { // not message of recorder
+			return problemStderr
+		}
+	case e == nil:
+	case e.IsResponse():
+		if !isNullOrEmpty(e.Error) {
+			return problemErrorResponse
+		}
+	case d.streamType == STDOUT && e.IsNotification() && (e.Method == showMessageMethod || e.Method == logMessageMethod):
+		var m struct {
+			Params *struct {
+				Type int `json:"type"`
+			} `json:"params"`
+		}
+		if json.Unmarshal(d.payload, &m) == nil && m.Params != nil && m.Params.Type == messageTypeError {
+			return problemErrorMessage
+		}
+	}
+	return ""
+}
+
+// problemCounts counts printed records of each category of --errors-only
+type problemCounts map[string]int
+
+// Format writes summary like "problems: 1 invalid, 2 error response, 0 error message, 3 stderr"
+func (c problemCounts) Format(writer io.Writer) {
+	var counts []string
+	for _, category := range problemCategories {
+		counts = append(counts, fmt.Sprintf("%d %s", c[category], category))
+	}
+	_, _ = fmt.Fprintf(writer, "problems: %s\n", strings.Join(counts, ", "))
+}
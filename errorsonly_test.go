@@ -0,0 +1,82 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+var errorsTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("panic: nil map")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"boom"}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/showMessage","params":{"type":1,"message":"failed"}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"type":3,"message":"info"}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":"broken"}`)},
+	LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte("invalid message header: 'hoge'")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"result":null,"error":null}`)},
+	LogData{streamType: STDOUT, payloadType: RAW_END, payload: []byte("end of stream")},
+)
+
+func TestProblemOf(t *testing.T) {
+	r := NewLogReader(strings.NewReader(errorsTestLog))
+	var problems []string
+	for {
+		d, err := r.Next()
+		if err != nil {
+			break
+		}
+		e, _ := ParseEnvelope(d.payload)
+		if d.payloadType != JSON {
+			e = nil
+		}
+		problems = append(problems, problemOf(d, e))
+	}
+	assert.Equal(t, []string{problemStderr, "", problemErrorResponse, problemErrorMessage, "", "", problemInvalid, "", "",
+		""}, problems)
+	assert.Empty(t, problemOf(&LogData{streamType: STDERR, payloadType: RAW, payload: []byte("debug: stdin: read 2 bytes"),
+		level: slog.LevelDebug}, nil))
+
+	// messages of recorder itself are not stderr of server
+	records, err := NewLogReader(strings.NewReader(newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []"), recorder: true},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("command exited with: 0"), recorder: true},
+	))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	for _, d := range records {
+		assert.True(t, d.recorder)
+		assert.Empty(t, problemOf(d, nil))
+	}
+}
+
+func TestPrintErrorsOnly(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(errorsTestLog), &out, &PrintFilter{ErrorsOnly: true, Output: PrintOutputCompact}))
+	assert.Equal(t, `[stderr] 2024-05-01T10:00:00Z ERR raw 14B
+[error response] 2024-05-01T10:00:02Z <-- response initialize id=1 65B 1s
+[error message] 2024-05-01T10:00:03Z <-- notification window/showMessage 86B
+[invalid] 2024-05-01T10:00:06Z <-- invalid 30B
+problems: 1 invalid, 1 error response, 1 error message, 1 stderr
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(errorsTestLog), &out, &PrintFilter{ErrorsOnly: true, Output: PrintOutputJSON,
+		Streams: []StreamType{STDOUT}}))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], `"problem":"error response"`)
+	assert.Equal(t, `{"problems":{"error message":1,"error response":1,"invalid":1,"stderr":0}}`, lines[3])
+
+	index, err := BuildLogIndex(strings.NewReader(errorsTestLog), nil)
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, PrintIndex(index, &out, &PrintFilter{ErrorsOnly: true, Output: PrintOutputCompact, Seqs: []int{3, 9}}))
+	assert.Equal(t, `[error response] 2024-05-01T10:00:02Z <-- response initialize id=1 65B 1s
+problems: 0 invalid, 1 error response, 0 error message, 0 stderr
+`, out.String())
+}
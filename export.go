@@ -0,0 +1,201 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// traceEvent is event of Chrome trace-event format (loadable by Perfetto and chrome://tracing)
+type traceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   float64        `json:"ts"` // microseconds from start of log
+	Dur  *float64       `json:"dur,omitempty"`
+	Pid  int            `json:"pid"`
+	Tid  int            `json:"tid"`
+	S    string         `json:"s,omitempty"` // scope of instant event
+	Args map[string]any `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+	OtherData       *SessionMeta `json:"otherData,omitempty"` // shown as metadata of trace
+}
+
+// tracks of trace (requests of each sender are laid out in lanes, since complete events must not overlap)
+const (
+	traceTidClientNotifications = 1
+	traceTidServerNotifications = 2
+	traceTidStderr              = 3
+	traceTidClientRequests      = 1000
+	traceTidServerRequests      = 2000
+)
+
+const tracePid = 1
+
+func toMicroseconds(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1000
+}
+
+// traceRequest is request waiting for response
+type traceRequest struct {
+	event *traceEvent
+	start time.Time
+}
+
+// ExportTrace converts log into Chrome trace-event JSON.
+// Request/response pairs become complete events, notifications and stderr output become instant events
+func ExportTrace(reader io.Reader, writer io.Writer) error {
+	var events []*traceEvent
+	var start time.Time
+	var meta *SessionMeta
+	pending := map[string]*traceRequest{} // key is stream of request and id
+	r := NewLogReader(reader)
+	for first := true; ; first = false {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			start = d.timestamp
+		}
+		if d.payloadType == META {
+			meta = mergeSessionMeta(meta, d.payload)
+		}
+		ts := toMicroseconds(d.timestamp.Sub(start))
+		if d.streamType == STDERR {
+			if d.payloadType == RAW {
+				text := string(d.payload)
+				name, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+				if len(name) > 80 {
+					name = name[:80] + "..."
+				}
+				events = append(events, &traceEvent{Name: name, Cat: "stderr", Ph: "i", Ts: ts, Pid: tracePid,
+					Tid: traceTidStderr, S: "t", Args: map[string]any{"text": text}})
+			}
+			continue
+		}
+		if d.payloadType != JSON {
+			continue
+		}
+		e, err := d.Envelope()
+		if err != nil {
+			continue
+		}
+		sender := senderOf(d.streamType)
+		switch {
+		case e.IsRequest():
+			event := &traceEvent{Name: e.Method, Cat: sender + " request", Ph: "X", Ts: ts, Pid: tracePid,
+				Args: map[string]any{"id": e.Id}}
+			events = append(events, event)
+			pending[pairKey(d, d.streamType, e.Id)] = &traceRequest{event: event, start: d.timestamp}
+		case e.IsResponse():
+			key := pairKey(d, peerStream(d.streamType), e.Id)
+			if req, ok := pending[key]; ok {
+				delete(pending, key)
+				dur := toMicroseconds(d.timestamp.Sub(req.start))
+				req.event.Dur = &dur
+				req.event.Args["error"] = hasError(d.payload)
+			}
+		default:
+			tid := traceTidClientNotifications
+			if d.streamType == STDOUT {
+				tid = traceTidServerNotifications
+			}
+			events = append(events, &traceEvent{Name: e.Method, Cat: sender + " notification", Ph: "i", Ts: ts,
+				Pid: tracePid, Tid: tid, S: "t"})
+		}
+	}
+	for _, req := range pending { // not answered, so shown as instant event
+		req.event.Ph = "i"
+		req.event.S = "t"
+		req.event.Args["pending"] = true
+	}
+	return writeTrace(writer, events, meta)
+}
+
+func hasError(payload []byte) bool {
+	var m struct {
+		Error json.RawMessage `json:"error"`
+	}
+	return json.Unmarshal(payload, &m) == nil && !isNullOrEmpty(m.Error)
+}
+
+// assignLanes assigns track to each request so that complete events of the same track never overlap
+func assignLanes(events []*traceEvent) map[int]int {
+	lanes := map[int]int{}      // number of lanes of each base tid
+	ends := map[int][]float64{} // end of last event of each lane (key is base tid)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Ts < events[j].Ts })
+	for _, event := range events {
+		if event.Tid != 0 {
+			continue
+		}
+		base := traceTidClientRequests
+		if strings.HasPrefix(event.Cat, "server") {
+			base = traceTidServerRequests
+		}
+		end := event.Ts
+		if event.Dur != nil {
+			end += *event.Dur
+		}
+		lane := 0
+		for ; lane < len(ends[base]); lane++ {
+			if ends[base][lane] <= event.Ts {
+				break
+			}
+		}
+		if lane == len(ends[base]) {
+			ends[base] = append(ends[base], 0)
+		}
+		ends[base][lane] = end
+		event.Tid = base + lane
+		lanes[base] = max(lanes[base], lane+1)
+	}
+	return lanes
+}
+
+func writeTrace(writer io.Writer, events []*traceEvent, meta *SessionMeta) error {
+	lanes := assignLanes(events)
+	threadName := func(tid int, name string) traceEvent {
+		return traceEvent{Name: "thread_name", Ph: "M", Pid: tracePid, Tid: tid, Args: map[string]any{"name": name}}
+	}
+	processName := "lsp-recorder"
+	if meta != nil && meta.Bin != "" {
+		processName = fmt.Sprintf("%s (%s)", processName, filepath.Base(meta.Bin))
+	}
+	file := traceFile{DisplayTimeUnit: "ms", OtherData: meta, TraceEvents: []traceEvent{
+		{Name: "process_name", Ph: "M", Pid: tracePid, Args: map[string]any{"name": processName}},
+		threadName(traceTidClientNotifications, "client notifications"),
+		threadName(traceTidServerNotifications, "server notifications"),
+		threadName(traceTidStderr, "stderr"),
+	}}
+	for _, base := range []int{traceTidClientRequests, traceTidServerRequests} {
+		sender := "client"
+		if base == traceTidServerRequests {
+			sender = "server"
+		}
+		for lane := 0; lane < lanes[base]; lane++ {
+			name := sender + " requests"
+			if lane > 0 {
+				name = fmt.Sprintf("%s (%d)", name, lane+1)
+			}
+			file.TraceEvents = append(file.TraceEvents, threadName(base+lane, name))
+		}
+	}
+	for _, event := range events {
+		file.TraceEvents = append(file.TraceEvents, *event)
+	}
+	encoder := json.NewEncoder(writer)
+	return encoder.Encode(&file)
+}
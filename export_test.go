@@ -0,0 +1,59 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportTrace(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	log := newTestLog(
+		LogData{timestamp: base, streamType: STDERR, payloadType: RAW, payload: []byte("run: server []\nmore")},
+		LogData{timestamp: base.Add(time.Millisecond), streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`)},
+		LogData{timestamp: base.Add(2 * time.Millisecond), streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/definition"}`)},
+		LogData{timestamp: base.Add(3 * time.Millisecond), streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage"}`)},
+		LogData{timestamp: base.Add(4500 * time.Microsecond), streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)},
+		LogData{timestamp: base.Add(5 * time.Millisecond), streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"error":{"code":-32603,"message":"x"}}`)},
+		LogData{timestamp: base.Add(6 * time.Millisecond), streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`)},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, ExportTrace(strings.NewReader(log), &out))
+
+	var file struct {
+		TraceEvents []struct {
+			Name string         `json:"name"`
+			Ph   string         `json:"ph"`
+			Ts   float64        `json:"ts"`
+			Dur  float64        `json:"dur"`
+			Tid  int            `json:"tid"`
+			Args map[string]any `json:"args"`
+		} `json:"traceEvents"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &file))
+	var threads, events []string
+	for _, e := range file.TraceEvents {
+		switch {
+		case e.Ph == "M" && e.Name == "thread_name":
+			threads = append(threads, e.Args["name"].(string))
+		case e.Ph == "X":
+			events = append(events, fmt.Sprintf("X %s ts=%g dur=%g tid=%d error=%v", e.Name, e.Ts, e.Dur, e.Tid, e.Args["error"]))
+		case e.Ph == "i":
+			events = append(events, fmt.Sprintf("i %s ts=%g tid=%d", e.Name, e.Ts, e.Tid))
+		}
+	}
+	assert.Equal(t, []string{"client notifications", "server notifications", "stderr",
+		"client requests", "client requests (2)"}, threads)
+	assert.Equal(t, []string{
+		"i run: server [] ts=0 tid=3",
+		"X textDocument/hover ts=1000 dur=3500 tid=1000 error=false",
+		"X textDocument/definition ts=2000 dur=3000 tid=1001 error=true",
+		"i window/logMessage ts=3000 tid=2",
+		"i shutdown ts=6000 tid=1000",
+	}, events)
+}
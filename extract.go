@@ -0,0 +1,61 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+// unsafeFileNameChars are replaced in method part of extracted file name
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// extractFileName returns file name of extracted payload like "000042-textDocument_semanticTokens_full.json"
+func extractFileName(d *LogData, method string) string {
+	if method == "" {
+		method = "response" // to unknown request
+	}
+	return fmt.Sprintf("%06d-%s.json", d.seq, unsafeFileNameChars.ReplaceAllString(method, "_"))
+}
+
+// Extract writes payload of each JSON message matched by filter to its own file in dir, pretty-printed
+// (payload which cannot be indented is written as is). Only the final match is written if last is set.
+// Returns paths of written files
+func Extract(reader io.Reader, dir string, filter *PrintFilter, last bool) ([]string, error) {
+	var files []string
+	write := func(d *LogData, method string) error {
+		name := filepath.Join(dir, extractFileName(d, method))
+		data := d.payload
+		_ = indentPayload(d.payload, func(indented []byte) {
+			data = slices.Concat(indented, []byte("\n"))
+		})
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			return err
+		}
+		files = append(files, name)
+		return nil
+	}
+	var final *LogData
+	finalMethod := ""
+	var err error
+	scanErr := scanRecords(reader, filter, func(d *LogData, e *Envelope, p pairing) bool {
+		if e == nil || !filter.match(d, e, p.method) {
+			return false
+		}
+		if last {
+			final, finalMethod = d, p.method
+			return false
+		}
+		err = write(d, p.method)
+		return err != nil
+	})
+	if scanErr != nil {
+		return files, scanErr
+	}
+	if err == nil && final != nil {
+		err = write(final, finalMethod)
+	}
+	return files, err
+}
@@ -0,0 +1,49 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var extractTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/semanticTokens/full"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"data":[0,1]}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/semanticTokens/full"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"result":{"data":[`)}, // broken
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":9,"result":null}`)},
+)
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	filter := &PrintFilter{Methods: []string{"textDocument/semanticTokens/*"}, MatchResponses: true, Streams: []StreamType{STDOUT}}
+	files, err := Extract(strings.NewReader(extractTestLog), dir, filter, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "000003-textDocument_semanticTokens_full.json"),
+		filepath.Join(dir, "000005-textDocument_semanticTokens_full.json"),
+	}, files)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"jsonrpc\": \"2.0\",\n  \"id\": 1,\n  \"result\": {\n    \"data\": [\n      0,\n      1\n    ]\n  }\n}\n", string(data))
+	data, err = os.ReadFile(files[1]) // written as is
+	require.NoError(t, err)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":2,"result":{"data":[`, string(data))
+
+	dir = t.TempDir()
+	files, err = Extract(strings.NewReader(extractTestLog), dir, &PrintFilter{Methods: []string{"textDocument/semanticTokens/full"}}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "000004-textDocument_semanticTokens_full.json")}, files)
+
+	files, err = Extract(strings.NewReader(extractTestLog), dir, &PrintFilter{Ids: []PrintId{ParsePrintId("9")}}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "000006-response.json")}, files)
+
+	files, err = Extract(strings.NewReader(extractTestLog), filepath.Join(dir, "none"), &PrintFilter{}, false)
+	assert.Error(t, err)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,118 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// followInterval is polling interval of growing log
+const followInterval = 200 * time.Millisecond
+
+// followReader reads log still being written like tail -f. Only complete lines are returned,
+// so partially written last line is kept until the rest is written.
+// If log is rotated (path is replaced by new file) or truncated, new content is read from the beginning
+type followReader struct {
+	ctx      context.Context
+	path     string
+	interval time.Duration
+	idle     func() // called before waiting for new data (e.g. flush of output)
+
+	file     *os.File
+	offset   int64
+	pending  []byte // read data not returned yet (may end with partial line)
+	draining bool   // path is replaced, so read rest of current file before switching
+	buf      []byte
+}
+
+// FollowLog opens log for following until ctx is done (then io.EOF is returned).
+// Compressed log cannot be followed since its last frame is not readable until finished
+func FollowLog(ctx context.Context, path string, idle func()) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(zstdMagic))
+	n, _ := file.ReadAt(head, 0)
+	if format := compressedFormat(head[:n]); format != "" {
+		_ = file.Close()
+		return nil, fmt.Errorf("cannot follow %s log: %s (use text or json format for logs to be followed)", format, path)
+	}
+	if idle == nil {
+		idle = func() {}
+	}
+	return &followReader{ctx: ctx, path: path, interval: followInterval, idle: idle, file: file,
+		buf: make([]byte, 32*1024)}, nil
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		if i := bytes.LastIndexByte(r.pending, '\n'); i >= 0 {
+			n := copy(p, r.pending[:i+1])
+			r.pending = r.pending[n:]
+			return n, nil
+		}
+		n, err := r.file.Read(r.buf)
+		r.offset += int64(n)
+		r.pending = append(r.pending, r.buf[:n]...)
+		if n > 0 {
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if switched, err := r.reopen(); err != nil {
+			return 0, err
+		} else if switched {
+			continue
+		}
+		r.idle()
+		select {
+		case <-r.ctx.Done():
+			return 0, io.EOF // partial line is dropped
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// reopen switches to new file if path is replaced (after reading rest of current one) or truncated
+func (r *followReader) reopen() (bool, error) {
+	if r.draining {
+		file, err := os.Open(r.path)
+		if err != nil {
+			return false, nil // not created yet
+		}
+		_ = r.file.Close()
+		r.file, r.offset, r.pending, r.draining = file, 0, nil, false
+		return true, nil
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		r.draining = errors.Is(err, os.ErrNotExist) // renamed, but new one is not created yet
+		return false, nil
+	}
+	current, err := r.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(info, current) {
+		r.draining = true
+		return true, nil // read rest of current file once more
+	}
+	if current.Size() < r.offset { // truncated by new session without --append
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		r.offset, r.pending = 0, nil
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *followReader) Close() error {
+	return r.file.Close()
+}
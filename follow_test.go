@@ -0,0 +1,98 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is bytes.Buffer accessed by printing goroutine and test
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func followTestRecord(seq int, note string) string {
+	return newTestLog(LogData{timestamp: time.Date(2024, 5, 1, 10, 0, seq, 0, time.UTC), streamType: STDERR,
+		payloadType: RAW, payload: []byte(fmt.Sprintf("record %d%s", seq, note))})
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
+
+func TestFollowLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.log")
+	appendFile(t, path, followTestRecord(1, ""))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, err := FollowLog(ctx, path, nil)
+	require.NoError(t, err)
+	reader.(*followReader).interval = 10 * time.Millisecond
+	out := &lockedBuffer{}
+	done := make(chan error)
+	go func() {
+		done <- Print(reader, out, &PrintFilter{})
+	}()
+	printed := func(n int) func() bool {
+		return func() bool { return strings.Count(out.String(), "<stderr> record") == n }
+	}
+	assert.Eventually(t, printed(1), time.Second, 10*time.Millisecond)
+
+	// partially written line is waited for
+	record := followTestRecord(2, "")
+	appendFile(t, path, record[:10])
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, record[10:])
+	assert.Eventually(t, printed(2), time.Second, 10*time.Millisecond)
+
+	// rotated
+	appendFile(t, path, followTestRecord(3, ""))
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendFile(t, path, followTestRecord(4, " after rotation"))
+	assert.Eventually(t, printed(4), time.Second, 10*time.Millisecond)
+
+	// truncated (and shorter than before)
+	require.NoError(t, os.WriteFile(path, []byte(followTestRecord(5, "")), 0o644))
+	assert.Eventually(t, printed(5), time.Second, 10*time.Millisecond)
+
+	appendFile(t, path, followTestRecord(6, "")[:10]) // partial line is dropped at exit
+	cancel()
+	require.NoError(t, <-done)
+	_ = reader.Close()
+	assert.Equal(t, 5, strings.Count(out.String(), "<stderr> record"))
+	for i := 1; i <= 5; i++ {
+		assert.Contains(t, out.String(), fmt.Sprintf("record %d", i))
+	}
+}
+
+func TestFollowLogCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.log")
+	require.NoError(t, os.WriteFile(path, []byte(convertLog(t, printTestLog, LogFormatJSON, LogFormatJSONGzip)), 0o644))
+	_, err := FollowLog(context.Background(), path, nil)
+	assert.ErrorContains(t, err, "cannot follow json-gzip log")
+}
@@ -0,0 +1,69 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// grepSeparator separates groups of non-adjacent records printed with context (like grep)
+const grepSeparator = "--\n"
+
+// Grep writes records whose raw payload matches pattern (and filter.Query if set) in the same format as Print,
+// with context records around them (context is number of records before and after match).
+// Records are not written if count is set.
+// Returns number of matched records. Match spans are highlighted if filter.Color is set
+func Grep(reader io.Reader, writer io.Writer, pattern *regexp.Regexp, context int, count bool,
+	filter *PrintFilter) (int, error) {
+	matched := 0
+	var before []tailRecord // the last context records not written
+	after := 0              // number of context records to be written after match
+	last := 0               // seq of last written record
+	write := func(d *LogData, e *Envelope, p pairing) {
+		if context > 0 && last > 0 && d.seq > last+1 {
+			_, _ = io.WriteString(writer, grepSeparator)
+		}
+		last = d.seq
+		if !filter.Color {
+			filter.format(writer, d, e, p)
+			return
+		}
+		buf := bytes.Buffer{} // highlight match spans instead of syntax
+		filter.Color = false
+		filter.format(&buf, d, e, p)
+		filter.Color = true
+		_, _ = writer.Write(pattern.ReplaceAll(buf.Bytes(), []byte(ansiBold+ansiRed+"$0"+ansiReset)))
+	}
+	err := scanRecords(reader, filter, func(d *LogData, e *Envelope, p pairing) bool {
+		if !pattern.Match(d.payload) || (filter.Query != nil && (d.payloadType != JSON || !filter.Query.Match(d.payload))) {
+			if count {
+				return false
+			}
+			if after > 0 {
+				after--
+				write(d, e, p)
+			} else if context > 0 {
+				if len(before) == context {
+					before = before[1:]
+				}
+				before = append(before, tailRecord{d: d, e: e, p: p})
+			}
+			return false
+		}
+		matched++
+		if !count {
+			for _, r := range before {
+				write(r.d, r.e, r.p)
+			}
+			before = before[:0]
+			after = context
+			write(d, e, p)
+		}
+		return false
+	})
+	if err == nil && count {
+		_, _ = fmt.Fprintf(writer, "%d\n", matched)
+	}
+	return matched, err
+}
@@ -0,0 +1,84 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var grepTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("loading /src/main.go")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("a")},
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("b")},
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("c")},
+	LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte("invalid message header: '/SRC/MAIN.GO'")},
+)
+
+func TestGrep(t *testing.T) {
+	out := bytes.Buffer{}
+	matched, err := Grep(strings.NewReader(grepTestLog), &out, regexp.MustCompile(`main\.go`), 0, false, &PrintFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, matched)
+	assert.Equal(t, "2024-05-01T10:00:00Z <stderr> loading /src/main.go\n", out.String())
+
+	out.Reset() // RAW and INVALID records are also matched
+	matched, err = Grep(strings.NewReader(grepTestLog), &out, regexp.MustCompile(`(?i)main\.go`), 0, true, &PrintFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, matched)
+	assert.Equal(t, "2\n", out.String())
+
+	out.Reset() // response is annotated like print
+	_, err = Grep(strings.NewReader(grepTestLog), &out, regexp.MustCompile(`"result"`), 0, false, &PrintFilter{})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out.String(), "2024-05-01T10:00:02Z <stdout> (response to initialize id=1, 1s)\n{"))
+
+	matched, err = Grep(strings.NewReader(grepTestLog), &out, regexp.MustCompile(`none`), 0, false, &PrintFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, matched)
+}
+
+func TestGrepContext(t *testing.T) {
+	grep := func(pattern string, context int) string {
+		out := bytes.Buffer{}
+		_, err := Grep(strings.NewReader(grepTestLog), &out, regexp.MustCompile(pattern), context, false,
+			&PrintFilter{Output: PrintOutputCompact, ShowIndex: true})
+		require.NoError(t, err)
+		return out.String()
+	}
+	assert.Equal(t, `#1 2024-05-01T10:00:00Z ERR raw 20B
+#2 2024-05-01T10:00:01Z --> request initialize id=1 46B
+--
+#4 2024-05-01T10:00:03Z ERR raw 1B
+#5 2024-05-01T10:00:04Z ERR raw 1B
+#6 2024-05-01T10:00:05Z ERR raw 1B
+`, grep(`loading|^b$`, 1))
+	assert.Equal(t, `#3 2024-05-01T10:00:02Z <-- response initialize id=1 36B 1s
+#4 2024-05-01T10:00:03Z ERR raw 1B
+#5 2024-05-01T10:00:04Z ERR raw 1B
+#6 2024-05-01T10:00:05Z ERR raw 1B
+#7 2024-05-01T10:00:06Z <-- invalid 38B
+`, grep(`^[ac]$`, 1))
+}
+
+func TestGrepHighlight(t *testing.T) {
+	out := bytes.Buffer{}
+	_, err := Grep(strings.NewReader(grepTestLog), &out, regexp.MustCompile(`main\.go`), 0, false, &PrintFilter{Color: true})
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("2024-05-01T10:00:00Z <stderr> loading /src/%smain.go%s\n", ansiBold+ansiRed, ansiReset),
+		out.String())
+}
+
+func TestGrepQuery(t *testing.T) {
+	query, err := ParseQuery(`.result != null`)
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	matched, err := Grep(strings.NewReader(grepTestLog), &out, regexp.MustCompile(`"id":1`), 0, true, &PrintFilter{Query: query})
+	require.NoError(t, err)
+	assert.Equal(t, 1, matched)
+}
@@ -0,0 +1,256 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// textDocumentSyncKinds are names of TextDocumentSyncKind
+var textDocumentSyncKinds = map[string]string{"0": "none", "1": "full", "2": "incremental"}
+
+// maxInlineCapabilityWidth is max width of array printed in one line in capability tree
+const maxInlineCapabilityWidth = 60
+
+// HandshakeSummary is readable summary of initialize handshake (the first initialize request and its response)
+type HandshakeSummary struct {
+	Request  bool   // initialize request is found
+	Response bool   // response to initialize request is found
+	Error    string // error message of response (empty if succeeded)
+
+	ClientName       string
+	ClientVersion    string
+	ServerName       string
+	ServerVersion    string
+	RootUri          string
+	WorkspaceFolders []string // URIs of workspace folders
+
+	ClientCapabilities json.RawMessage
+	ServerCapabilities json.RawMessage
+
+	Exit *ServerExit // exit of server of the last session (nil if not recorded in trailer)
+}
+
+// CollectHandshake reads log until response to the first initialize request is found
+func CollectHandshake(reader io.Reader) (*HandshakeSummary, error) {
+	summary := &HandshakeSummary{}
+	initializeId := ""
+	err := scanRecords(reader, &PrintFilter{}, func(d *LogData, e *Envelope, p pairing) bool {
+		switch {
+		case e == nil:
+			if exit := parseServerExit(d); exit != nil {
+				summary.Exit = exit
+			}
+		case e.IsRequest() && e.Method == "initialize" && d.streamType == STDIN && !summary.Request:
+			summary.Request = true
+			initializeId = idKey(e.Id)
+			var m struct {
+				Params struct {
+					ClientInfo struct {
+						Name    string `json:"name"`
+						Version string `json:"version"`
+					} `json:"clientInfo"`
+					RootUri          string `json:"rootUri"`
+					WorkspaceFolders []struct {
+						Uri string `json:"uri"`
+					} `json:"workspaceFolders"`
+					Capabilities json.RawMessage `json:"capabilities"`
+				} `json:"params"`
+			}
+			_ = json.Unmarshal(d.payload, &m)
+			summary.ClientName, summary.ClientVersion = m.Params.ClientInfo.Name, m.Params.ClientInfo.Version
+			summary.RootUri = m.Params.RootUri
+			for _, folder := range m.Params.WorkspaceFolders {
+				summary.WorkspaceFolders = append(summary.WorkspaceFolders, folder.Uri)
+			}
+			summary.ClientCapabilities = m.Params.Capabilities
+		case e.IsResponse() && d.streamType == STDOUT && summary.Request && idKey(e.Id) == initializeId:
+			summary.Response = true
+			var m struct {
+				Result struct {
+					ServerInfo struct {
+						Name    string `json:"name"`
+						Version string `json:"version"`
+					} `json:"serverInfo"`
+					Capabilities json.RawMessage `json:"capabilities"`
+				} `json:"result"`
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			_ = json.Unmarshal(d.payload, &m)
+			summary.ServerName, summary.ServerVersion = m.Result.ServerInfo.Name, m.Result.ServerInfo.Version
+			summary.ServerCapabilities = m.Result.Capabilities
+			if e.Error != nil && !isNullOrEmpty(e.Error) {
+				summary.Error = m.Error.Message
+			}
+			return true
+		}
+		return false
+	})
+	return summary, err
+}
+
+// decodeCapabilities decodes capabilities object keeping numbers as is (nil if not object)
+func decodeCapabilities(capabilities json.RawMessage) map[string]any {
+	decoder := json.NewDecoder(bytes.NewReader(capabilities))
+	decoder.UseNumber()
+	var m map[string]any
+	if decoder.Decode(&m) != nil {
+		return nil
+	}
+	return m
+}
+
+// capabilityAt returns value at dot-separated path (nil if missing)
+func capabilityAt(m map[string]any, path string) any {
+	var v any = m
+	for _, key := range strings.Split(path, ".") {
+		o, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = o[key]
+	}
+	return v
+}
+
+// formatCapabilityValue returns JSON of value in one line (trigger characters like '<' are not escaped)
+func formatCapabilityValue(v any) string {
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// notableCapabilities returns lines of capabilities frequently checked first
+func (s *HandshakeSummary) notableCapabilities() []string {
+	client := decodeCapabilities(s.ClientCapabilities)
+	server := decodeCapabilities(s.ServerCapabilities)
+	var lines []string
+
+	encoding := "utf-16 (default)"
+	if v, ok := capabilityAt(server, "positionEncoding").(string); ok {
+		encoding = v
+	}
+	if v := capabilityAt(client, "general.positionEncodings"); v != nil {
+		encoding += ", client supports " + formatCapabilityValue(v)
+	}
+	lines = append(lines, "positionEncoding: "+encoding)
+
+	switch v := capabilityAt(server, "textDocumentSync").(type) {
+	case json.Number:
+		lines = append(lines, "textDocumentSync: "+textDocumentSyncKinds[v.String()])
+	case map[string]any:
+		kind := "none"
+		if change, ok := v["change"].(json.Number); ok {
+			kind = textDocumentSyncKinds[change.String()]
+		}
+		var options []string
+		for _, key := range []string{"openClose", "save", "willSave", "willSaveWaitUntil"} {
+			if option, ok := v[key]; ok && option != false {
+				options = append(options, key)
+			}
+		}
+		if len(options) > 0 {
+			kind += " (" + strings.Join(options, ", ") + ")"
+		}
+		lines = append(lines, "textDocumentSync: "+kind)
+	default:
+		lines = append(lines, "textDocumentSync: none")
+	}
+
+	if completion, ok := capabilityAt(server, "completionProvider").(map[string]any); ok {
+		line := "completionProvider: yes"
+		if triggers, ok := completion["triggerCharacters"].([]any); ok && len(triggers) > 0 {
+			line += ", trigger characters " + formatCapabilityValue(triggers)
+		}
+		if completion["resolveProvider"] == true {
+			line += ", resolve"
+		}
+		lines = append(lines, line)
+	} else {
+		lines = append(lines, "completionProvider: no")
+	}
+	for _, c := range []struct{ title, path string }{
+		{"pull diagnostics (diagnosticProvider)", "diagnosticProvider"},
+		{"semantic tokens (semanticTokensProvider)", "semanticTokensProvider"},
+		{"inlay hints (inlayHintProvider)", "inlayHintProvider"},
+	} {
+		advertised := "no"
+		if lookupCapability(s.ServerCapabilities, c.path) {
+			advertised = "yes"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", c.title, advertised))
+	}
+	return lines
+}
+
+// formatCapabilityTree writes object as tree, one key per line in order of key. Array is written in one line
+// if short, otherwise its length only
+func formatCapabilityTree(writer io.Writer, m map[string]any, indent string) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch v := m[key].(type) {
+		case map[string]any:
+			_, _ = fmt.Fprintf(writer, "%s%s\n", indent, key)
+			formatCapabilityTree(writer, v, indent+"  ")
+		case []any:
+			if s := formatCapabilityValue(v); len(s) <= maxInlineCapabilityWidth {
+				_, _ = fmt.Fprintf(writer, "%s%s: %s\n", indent, key, s)
+			} else {
+				_, _ = fmt.Fprintf(writer, "%s%s: [%d items]\n", indent, key, len(v))
+			}
+		default:
+			_, _ = fmt.Fprintf(writer, "%s%s: %s\n", indent, key, formatCapabilityValue(v))
+		}
+	}
+}
+
+// formatNameVersion returns "name version" (or "unknown" if name is empty)
+func formatNameVersion(name, version string) string {
+	if name == "" {
+		return "unknown"
+	}
+	return strings.TrimSpace(name + " " + version)
+}
+
+// Format writes client/server info, workspace folders, notable capabilities and tree of server capabilities
+func (s *HandshakeSummary) Format(writer io.Writer) {
+	formatStatsHeader(writer, nil, s.Exit)
+	if !s.Request {
+		_, _ = fmt.Fprintln(writer, "initialize request is not found")
+		return
+	}
+	_, _ = fmt.Fprintf(writer, "client: %s\n", formatNameVersion(s.ClientName, s.ClientVersion))
+	if s.RootUri != "" {
+		_, _ = fmt.Fprintf(writer, "root: %s\n", s.RootUri)
+	}
+	_, _ = fmt.Fprintf(writer, "workspace folders: %d\n", len(s.WorkspaceFolders))
+	for _, folder := range s.WorkspaceFolders {
+		_, _ = fmt.Fprintf(writer, "  %s\n", folder)
+	}
+	switch {
+	case !s.Response:
+		_, _ = fmt.Fprintln(writer, "response to initialize is not found (incomplete handshake)")
+		return
+	case s.Error != "":
+		_, _ = fmt.Fprintf(writer, "initialize failed: %s\n", s.Error)
+		return
+	}
+	_, _ = fmt.Fprintf(writer, "server: %s\n", formatNameVersion(s.ServerName, s.ServerVersion))
+	_, _ = fmt.Fprintln(writer, "\nnotable:")
+	for _, line := range s.notableCapabilities() {
+		_, _ = fmt.Fprintf(writer, "  %s\n", line)
+	}
+	_, _ = fmt.Fprintln(writer, "\nserver capabilities:")
+	formatCapabilityTree(writer, decodeCapabilities(s.ServerCapabilities), "  ")
+}
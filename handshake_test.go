@@ -0,0 +1,100 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectHandshake(t *testing.T) {
+	log := newDiffTestLog(10*time.Millisecond,
+		[2]string{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"clientInfo":{"name":"vscode","version":"1.90"},` +
+			`"rootUri":"file:///work","workspaceFolders":[{"uri":"file:///work","name":"work"},{"uri":"file:///lib","name":"lib"}],` +
+			`"capabilities":{"general":{"positionEncodings":["utf-8","utf-16"]}}}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"serverInfo":{"name":"clangd","version":"18.1"},"capabilities":{` +
+				`"positionEncoding":"utf-8","textDocumentSync":{"openClose":true,"change":2,"save":{"includeText":false}},` +
+				`"completionProvider":{"triggerCharacters":[".","<",">"],"resolveProvider":true},"hoverProvider":true,` +
+				`"inlayHintProvider":{}}}}`},
+		[2]string{`{"jsonrpc":"2.0","method":"initialized","params":{}}`, ""},
+	)
+	summary, err := CollectHandshake(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.True(t, summary.Response)
+	assert.Equal(t, "vscode", summary.ClientName)
+	assert.Equal(t, "18.1", summary.ServerVersion)
+	assert.Equal(t, []string{"file:///work", "file:///lib"}, summary.WorkspaceFolders)
+
+	out := bytes.Buffer{}
+	summary.Format(&out)
+	assert.Equal(t, `client: vscode 1.90
+root: file:///work
+workspace folders: 2
+  file:///work
+  file:///lib
+server: clangd 18.1
+
+notable:
+  positionEncoding: utf-8, client supports ["utf-8","utf-16"]
+  textDocumentSync: incremental (openClose, save)
+  completionProvider: yes, trigger characters [".","<",">"], resolve
+  pull diagnostics (diagnosticProvider): no
+  semantic tokens (semanticTokensProvider): no
+  inlay hints (inlayHintProvider): yes
+
+server capabilities:
+  completionProvider
+    resolveProvider: true
+    triggerCharacters: [".","<",">"]
+  hoverProvider: true
+  inlayHintProvider
+  positionEncoding: "utf-8"
+  textDocumentSync
+    change: 2
+    openClose: true
+    save
+      includeText: false
+`, out.String())
+}
+
+func TestCollectIncompleteHandshake(t *testing.T) {
+	log := newDiffTestLog(10*time.Millisecond,
+		[2]string{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}`, ""})
+	summary, err := CollectHandshake(strings.NewReader(log))
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	summary.Format(&out)
+	assert.Equal(t, "client: unknown\nworkspace folders: 0\nresponse to initialize is not found (incomplete handshake)\n",
+		out.String())
+
+	log = newDiffTestLog(10*time.Millisecond,
+		[2]string{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"no compile_commands.json"}}`})
+	summary, err = CollectHandshake(strings.NewReader(log))
+	require.NoError(t, err)
+	out.Reset()
+	summary.Format(&out)
+	assert.Contains(t, out.String(), "initialize failed: no compile_commands.json\n")
+
+	summary, err = CollectHandshake(strings.NewReader(newDiffTestLog(0,
+		[2]string{`{"jsonrpc":"2.0","method":"initialized"}`, ""})))
+	require.NoError(t, err)
+	out.Reset()
+	summary.Format(&out)
+	assert.Equal(t, "initialize request is not found\n", out.String())
+}
+
+func TestCollectHandshakeServerExit(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}`)},
+		LogData{streamType: STDERR, payloadType: TRAILER, payload: []byte(`{"summary":{"exit":{"code":1,"uptime_ns":1000000000}}}`)},
+	)
+	summary, err := CollectHandshake(strings.NewReader(log))
+	require.NoError(t, err)
+	require.NotNil(t, summary.Exit)
+	out := bytes.Buffer{}
+	summary.Format(&out)
+	assert.True(t, strings.HasPrefix(out.String(), "session:\n  exit:     1 after 1s\n\nclient: unknown\n"), out.String())
+}
@@ -1,4 +1,4 @@
-package main
+package recorder
 
 import (
 	"bytes"
@@ -26,3 +26,74 @@ func TestParserSuccess(t *testing.T) {
 		}
 	}
 }
+
+func TestParserWhitespace(t *testing.T) {
+	for _, header := range []string{
+		"Content-Length:12\r\n\r\n",
+		"Content-Length:  12\r\n\r\n",
+		"Content-Length:\t12\t \r\n\r\n",
+		"Content-Length: 12 \r\n\r\n",
+	} {
+		parser := NewContentHeaderParser()
+		buf := bytes.Buffer{}
+		for i := 0; i < len(header)-1; i++ {
+			buf.WriteByte(header[i])
+			n, e := parser.Parse(&buf)
+			assert.Equal(t, -1, n, header)
+			assert.ErrorIs(t, e, io.EOF, header)
+		}
+		buf.WriteByte(header[len(header)-1])
+		n, e := parser.Parse(&buf)
+		assert.NoError(t, e, header)
+		assert.Equal(t, 12, n, header)
+	}
+	for _, header := range []string{"Content-Length:\r\n\r\n", "Content-Length: 1 2\r\n\r\n", "Content-Length : 12\r\n\r\n"} {
+		_, e := NewContentHeaderParser().Parse(bytes.NewBufferString(header))
+		assert.Error(t, e, header)
+		assert.NotErrorIs(t, e, io.EOF, header)
+	}
+}
+
+func TestParserLenient(t *testing.T) {
+	for _, tc := range []struct {
+		header    string
+		deviation string
+	}{
+		{"Content-Length: 12\r\n\r\n", ""},
+		{"Content-Length: 12\n\n", "header line ends with \\n instead of \\r\\n"},
+		{"Content-Length: 12\r\n\n", "header line ends with \\n instead of \\r\\n"},
+		{"Content-Length: 12\n\r\n", "header line ends with \\n instead of \\r\\n"},
+		{"Content-Length:12 \n\n", "header line ends with \\n instead of \\r\\n"},
+	} {
+		parser := NewLenientContentHeaderParser()
+		buf := bytes.Buffer{}
+		for i := 0; i < len(tc.header)-1; i++ { // byte by byte
+			buf.WriteByte(tc.header[i])
+			n, e := parser.Parse(&buf)
+			assert.Equal(t, -1, n, tc.header)
+			assert.ErrorIs(t, e, io.EOF, tc.header)
+		}
+		buf.WriteByte(tc.header[len(tc.header)-1])
+		n, e := parser.Parse(&buf)
+		assert.NoError(t, e, tc.header)
+		assert.Equal(t, 12, n, tc.header)
+		assert.Equal(t, tc.deviation, parser.Deviation(), tc.header)
+
+		if tc.deviation != "" { // not accepted by strict parser
+			n, e = NewContentHeaderParser().Parse(bytes.NewBufferString(tc.header))
+			assert.Equal(t, -1, n, tc.header)
+			assert.Error(t, e, tc.header)
+		}
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	n, err := parseLength([]byte("1234"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, n)
+	for _, s := range []string{"", "12a", "99999999999999999999999"} {
+		_, err := parseLength([]byte(s))
+		assert.Error(t, err, s)
+	}
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() { _, _ = parseLength([]byte("4096")) }))
+}
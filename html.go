@@ -0,0 +1,240 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// htmlRecord is record embedded in HTML report (payload is rendered only when expanded)
+type htmlRecord struct {
+	Seq     int     `json:"seq"`
+	Offset  float64 `json:"t"` // milliseconds from start of log
+	Stream  string  `json:"stream"`
+	Type    string  `json:"type"`
+	Method  string  `json:"method,omitempty"`
+	Id      string  `json:"id,omitempty"`
+	Size    int     `json:"size"`
+	Note    string  `json:"note,omitempty"` // pairing of response (see RequestTracker)
+	Payload string  `json:"payload"`
+}
+
+type htmlSummary struct {
+	Command  string
+	Host     string
+	Start    string
+	Duration time.Duration
+	Counts   []htmlCount
+}
+
+type htmlCount struct {
+	Name  string
+	Count int
+}
+
+// ExportHTML converts log into single self-contained HTML report (no network access is required)
+func ExportHTML(reader io.Reader, writer io.Writer) error {
+	var records []htmlRecord
+	var start, end time.Time
+	summary := htmlSummary{}
+	counts := map[string]int{}
+	tracker := NewRequestTracker()
+	r := NewLogReader(reader)
+	for first := true; ; first = false {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			start = d.timestamp
+		}
+		end = d.timestamp
+		record := htmlRecord{
+			Seq:     d.seq,
+			Offset:  float64(d.timestamp.Sub(start).Microseconds()) / 1000,
+			Stream:  d.streamType.String(),
+			Type:    d.payloadType.String(),
+			Size:    d.messageSize(),
+			Payload: string(d.payload),
+		}
+		if d.payloadType == JSON {
+			if e, err := d.Envelope(); err == nil {
+				record.Method, record.Note = tracker.Observe(d, e)
+				if e.Id != nil {
+					record.Id = idKey(e.Id)
+				}
+			}
+		}
+		if d.payloadType == META && summary.Host == "" {
+			if meta, err := ParseSessionMeta(d.payload); err == nil {
+				summary.Command = meta.Command()
+				summary.Host = fmt.Sprintf("%s (%s/%s)", meta.Hostname, meta.Os, meta.Arch)
+			}
+		}
+		if d.streamType == STDERR && d.payloadType == RAW && summary.Command == "" { // log without META record
+			if v, ok := strings.CutPrefix(string(d.payload), "run: "); ok {
+				summary.Command = v
+			}
+		}
+		counts[d.streamType.String()+" "+d.payloadType.String()]++
+		records = append(records, record)
+	}
+	if !start.IsZero() {
+		summary.Start = start.Format(time.RFC3339Nano)
+		summary.Duration = end.Sub(start)
+	}
+	for _, t := range []StreamType{STDIN, STDOUT, STDERR} {
+		for _, p := range []PayloadType{JSON, INVALID, INCOMPLETE, RAW, RAW_END, TRAILER, SESSION_START, ENV, META, RESOURCES} {
+			if n := counts[t.String()+" "+p.String()]; n > 0 {
+				summary.Counts = append(summary.Counts, htmlCount{Name: t.String() + " " + p.String(), Count: n})
+			}
+		}
+	}
+	data, err := json.Marshal(records) // '<', '>' and '&' are escaped, so safe in script element
+	if err != nil {
+		return err
+	}
+	return htmlReportTemplate.Execute(writer, map[string]any{
+		"Summary": summary,
+		"Records": template.JS(data),
+	})
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>lsp-recorder report</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 1em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+#records th { cursor: pointer; background: #eee; user-select: none; }
+#records tr.stderr td { color: #666; background: #f7f7f0; }
+#records tr.invalid td { color: #a00; background: #fee; }
+#records tr.payload td { background: #fafafa; }
+#records td.method { cursor: pointer; color: #04c; }
+pre { margin: 0; white-space: pre-wrap; word-break: break-all; }
+.controls { margin: 1em 0; }
+.note { color: #777; }
+</style>
+</head>
+<body>
+<h1>lsp-recorder report</h1>
+<table>
+<tr><th>command</th><td>{{.Summary.Command}}</td></tr>
+{{if .Summary.Host}}<tr><th>host</th><td>{{.Summary.Host}}</td></tr>
+{{end}}<tr><th>start</th><td>{{.Summary.Start}}</td></tr>
+<tr><th>duration</th><td>{{.Summary.Duration}}</td></tr>
+{{range .Summary.Counts}}<tr><th>{{.Name}}</th><td>{{.Count}}</td></tr>
+{{end}}</table>
+<div class="controls">
+<input id="filter" placeholder="filter by method, id or payload" size="40">
+<label><input type="checkbox" class="stream" value="stdin" checked>stdin</label>
+<label><input type="checkbox" class="stream" value="stdout" checked>stdout</label>
+<label><input type="checkbox" class="stream" value="stderr" checked>stderr</label>
+<span id="count"></span>
+</div>
+<table id="records">
+<thead><tr>
+<th data-key="seq">seq</th><th data-key="t">time (ms)</th><th data-key="stream">stream</th><th data-key="type">type</th>
+<th data-key="method">method</th><th data-key="id">id</th><th data-key="size">size</th><th data-key="note">note</th>
+</tr></thead>
+<tbody></tbody>
+</table>
+<button id="more">show more</button>
+<script>
+"use strict";
+const records = {{.Records}};
+const pageSize = 1000;
+const tbody = document.querySelector("#records tbody");
+let rows = records, shown = 0, sortKey = "seq", sortAsc = true;
+
+function cell(tr, text, className) {
+  const td = document.createElement("td");
+  td.textContent = text === undefined ? "" : text;
+  if (className) td.className = className;
+  tr.appendChild(td);
+  return td;
+}
+
+function toggle(tr, r) {
+  const next = tr.nextSibling;
+  if (next && next.classList && next.classList.contains("payload")) {
+    next.remove();
+    return;
+  }
+  let text = r.payload; // rendered lazily, since payloads may be large
+  if (r.type === "json") {
+    try { text = JSON.stringify(JSON.parse(r.payload), null, 2); } catch (e) {}
+  }
+  const row = document.createElement("tr");
+  row.className = "payload";
+  const td = document.createElement("td");
+  td.colSpan = 8;
+  const pre = document.createElement("pre");
+  pre.textContent = text;
+  td.appendChild(pre);
+  row.appendChild(td);
+  tr.after(row);
+}
+
+function render(reset) {
+  if (reset) {
+    tbody.textContent = "";
+    shown = 0;
+  }
+  const end = Math.min(rows.length, shown + pageSize);
+  for (; shown < end; shown++) {
+    const r = rows[shown];
+    const tr = document.createElement("tr");
+    if (r.stream === "stderr") tr.className = "stderr";
+    if (r.type === "invalid") tr.className = "invalid";
+    cell(tr, r.seq);
+    cell(tr, r.t.toFixed(3));
+    cell(tr, r.stream);
+    cell(tr, r.type);
+    const label = r.method || (r.type === "json" ? "(response)" : r.payload.slice(0, 80));
+    cell(tr, label, "method").onclick = () => toggle(tr, r);
+    cell(tr, r.id);
+    cell(tr, r.size);
+    cell(tr, r.note, "note");
+    tbody.appendChild(tr);
+  }
+  document.getElementById("count").textContent = rows.length + " / " + records.length + " records";
+  document.getElementById("more").hidden = shown >= rows.length;
+}
+
+function update() {
+  const query = document.getElementById("filter").value.toLowerCase();
+  const streams = new Set([...document.querySelectorAll(".stream:checked")].map(e => e.value));
+  rows = records.filter(r => streams.has(r.stream) && (query === "" ||
+    (r.method || "").toLowerCase().includes(query) || (r.id || "").toLowerCase().includes(query) ||
+    r.payload.toLowerCase().includes(query)));
+  rows.sort((a, b) => {
+    const x = a[sortKey] === undefined ? "" : a[sortKey], y = b[sortKey] === undefined ? "" : b[sortKey];
+    return (x < y ? -1 : x > y ? 1 : a.seq - b.seq) * (sortAsc ? 1 : -1);
+  });
+  render(true);
+}
+
+document.getElementById("filter").oninput = update;
+document.querySelectorAll(".stream").forEach(e => e.onchange = update);
+document.querySelectorAll("#records th").forEach(th => th.onclick = () => {
+  sortAsc = sortKey === th.dataset.key ? !sortAsc : true;
+  sortKey = th.dataset.key;
+  update();
+});
+document.getElementById("more").onclick = () => render(false);
+update();
+</script>
+</body>
+</html>
+`))
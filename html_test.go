@@ -0,0 +1,40 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExportHTML(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server [--stdio]")},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"x":"</script><b>"}}`)},
+		LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte("invalid message header: 'hoge'")},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, ExportHTML(strings.NewReader(log), &out))
+	html := out.String()
+	assert.Contains(t, html, "<tr><th>command</th><td>server [--stdio]</td></tr>")
+	assert.Contains(t, html, "<tr><th>duration</th><td>3s</td></tr>")
+	assert.Contains(t, html, "<tr><th>stdout invalid</th><td>1</td></tr>")
+	assert.Equal(t, 1, strings.Count(html, "</script>")) // payload never closes script element
+	assert.NotContains(t, html, "http")                  // no external resource
+
+	m := regexp.MustCompile(`(?m)^const records = (.*);$`).FindStringSubmatch(html)
+	require.NotNil(t, m)
+	var records []htmlRecord
+	require.NoError(t, json.Unmarshal([]byte(m[1]), &records))
+	require.Len(t, records, 4)
+	assert.Equal(t, "initialize", records[1].Method)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"x":"</script><b>"}}`, records[1].Payload)
+	assert.Equal(t, "invalid", records[2].Type)
+	assert.Equal(t, "initialize", records[3].Method)
+	assert.Equal(t, "response to initialize id=1, 2s", records[3].Note)
+	assert.Equal(t, 3000.0, records[3].Offset)
+}
@@ -0,0 +1,242 @@
+package recorder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaults of HTTPSinkOptions
+const (
+	DefaultSinkBatchSize     = 500
+	DefaultSinkBatchInterval = time.Second
+	DefaultSinkQueueSize     = 16
+	DefaultSinkRetries       = 3
+	DefaultSinkTimeout       = 10 * time.Second
+	DefaultSinkFlushTimeout  = 5 * time.Second
+)
+
+// HTTPSinkOptions is how records are sent to collector (zero fields are defaults)
+type HTTPSinkOptions struct {
+	URL           string
+	Token         string        // sent as bearer token (not sent if empty)
+	BatchSize     int           // max records of batch
+	BatchInterval time.Duration // max delay before pending records are sent
+	QueueSize     int           // max batches waiting to be sent (newer batch is dropped if full)
+	Retries       int           // retries of failed batch before it is dropped (no retry if negative)
+	Timeout       time.Duration // timeout of each request
+	FlushTimeout  time.Duration // max time of sending remaining batches at close
+}
+
+// sinkBatch is NDJSON lines of records
+type sinkBatch struct {
+	body    []byte
+	records int
+}
+
+// HTTPSink sends written records (JSON lines) to collector as gzipped NDJSON batches by POST.
+// Write never blocks on network, so failing (or slow) collector does not delay traffic. Batches which
+// cannot be queued or sent after retries are dropped and counted (see Dropped)
+type HTTPSink struct {
+	options HTTPSinkOptions
+	name    string // URL without password
+	client  *http.Client
+	mutex   sync.Mutex
+	pending sinkBatch // accumulated by Write (guarded by mutex)
+	closed  bool      // guarded by mutex
+	queue   chan sinkBatch
+	done    chan struct{}
+	ctx     context.Context // cancelled when final flush times out
+	cancel  context.CancelFunc
+
+	droppedBatches atomic.Int64
+	droppedRecords atomic.Int64
+	lastErr        atomic.Value // message of error of last dropped batch (string)
+}
+
+// NewHTTPSink creates sink and starts sending in background. Close must be called to send remaining records
+func NewHTTPSink(options HTTPSinkOptions) (*HTTPSink, error) {
+	u, err := url.Parse(options.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("http sink must be http or https URL: %s", options.URL)
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultSinkBatchSize
+	}
+	if options.BatchInterval <= 0 {
+		options.BatchInterval = DefaultSinkBatchInterval
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultSinkQueueSize
+	}
+	if options.Retries < 0 {
+		options.Retries = 0
+	} else if options.Retries == 0 {
+		options.Retries = DefaultSinkRetries
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultSinkTimeout
+	}
+	if options.FlushTimeout <= 0 {
+		options.FlushTimeout = DefaultSinkFlushTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &HTTPSink{options: options, name: u.Redacted(), client: &http.Client{Timeout: options.Timeout},
+		queue: make(chan sinkBatch, options.QueueSize), done: make(chan struct{}), ctx: ctx, cancel: cancel}
+	go s.run()
+	return s, nil
+}
+
+// Name returns URL of collector without password (shown as log path)
+func (s *HTTPSink) Name() string {
+	return s.name
+}
+
+// Write adds record (single JSON line) to pending batch. Batch is queued when it has BatchSize records
+func (s *HTTPSink) Write(buf []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return 0, errors.New("http sink is already closed")
+	}
+	s.pending.body = append(s.pending.body, buf...)
+	s.pending.records++
+	if s.pending.records >= s.options.BatchSize {
+		s.enqueue(s.takePending())
+	}
+	return len(buf), nil
+}
+
+// takePending returns pending batch and resets it (mutex must be held)
+func (s *HTTPSink) takePending() sinkBatch {
+	batch := s.pending
+	s.pending = sinkBatch{}
+	return batch
+}
+
+// enqueue queues batch without blocking (dropped if queue is full)
+func (s *HTTPSink) enqueue(batch sinkBatch) {
+	select {
+	case s.queue <- batch:
+	default:
+		s.drop(batch, errors.New("queue is full"))
+	}
+}
+
+func (s *HTTPSink) drop(batch sinkBatch, err error) {
+	s.droppedBatches.Add(1)
+	s.droppedRecords.Add(int64(batch.records))
+	s.lastErr.Store(err.Error())
+}
+
+// Dropped returns number of dropped batches and records so far
+func (s *HTTPSink) Dropped() (batches int64, records int64) {
+	return s.droppedBatches.Load(), s.droppedRecords.Load()
+}
+
+// run sends queued batches, and pending batch every BatchInterval, until queue is closed
+func (s *HTTPSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.options.BatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch, ok := <-s.queue:
+			if !ok {
+				return
+			}
+			s.send(batch)
+		case <-ticker.C:
+			s.mutex.Lock()
+			batch := s.takePending()
+			s.mutex.Unlock()
+			if batch.records > 0 {
+				s.send(batch)
+			}
+		}
+	}
+}
+
+// send posts batch, and retries with backoff on network error, 408, 429 and 5xx. Batch is dropped if all fail
+func (s *HTTPSink) send(batch sinkBatch) {
+	body := bytes.Buffer{}
+	gz := gzip.NewWriter(&body)
+	_, _ = gz.Write(batch.body)
+	_ = gz.Close()
+	backoff := 500 * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= s.options.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-s.ctx.Done():
+				s.drop(batch, fmt.Errorf("final flush timed out after %s", s.options.FlushTimeout))
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		var retryable bool
+		if retryable, err = s.post(body.Bytes()); err == nil {
+			return
+		} else if !retryable {
+			break
+		}
+	}
+	s.drop(batch, err)
+}
+
+// post sends gzipped NDJSON body and reports whether failure is worth retrying
+func (s *HTTPSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	if s.options.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.options.Token)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return s.ctx.Err() == nil, err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode/100 == 2 {
+		return true, nil
+	}
+	retryable := res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusTooManyRequests ||
+		res.StatusCode/100 == 5
+	return retryable, fmt.Errorf("collector responded %s", res.Status)
+}
+
+// Close sends remaining records within FlushTimeout and reports dropped batches
+func (s *HTTPSink) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	batch := s.takePending()
+	s.mutex.Unlock()
+	timer := time.AfterFunc(s.options.FlushTimeout, s.cancel)
+	if batch.records > 0 {
+		s.queue <- batch // not dropped, since queue is drained until timeout
+	}
+	close(s.queue)
+	<-s.done
+	timer.Stop()
+	s.cancel()
+	if batches, records := s.Dropped(); batches > 0 {
+		return fmt.Errorf("http sink %s: %d batches (%d records) dropped, caused by %v", s.name, batches,
+			records, s.lastErr.Load())
+	}
+	return nil
+}
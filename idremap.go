@@ -0,0 +1,204 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// IdRemapper rewrites JSON-RPC ids (and progress tokens) of messages sent on behalf of one party
+// so that they never collide with ids generated by the live opposite party.
+// Responses to rewritten requests are mapped back to the original ids.
+type IdRemapper struct {
+	next     int64
+	ids      map[string]json.RawMessage // original request id => rewritten id
+	revIds   map[string]json.RawMessage // rewritten request id => original id
+	tokens   map[string]json.RawMessage // original progress token => rewritten token
+	revToken map[string]json.RawMessage // rewritten progress token => original token
+	used     map[int64]struct{}         // numbers already used (or observed) as number or string
+}
+
+func NewIdRemapper() *IdRemapper {
+	return &IdRemapper{
+		next:     1,
+		ids:      map[string]json.RawMessage{},
+		revIds:   map[string]json.RawMessage{},
+		tokens:   map[string]json.RawMessage{},
+		revToken: map[string]json.RawMessage{},
+		used:     map[int64]struct{}{},
+	}
+}
+
+func idKey(id json.RawMessage) string {
+	return string(bytes.TrimSpace(id))
+}
+
+func isNullOrEmpty(v json.RawMessage) bool {
+	k := idKey(v)
+	return k == "" || k == "null"
+}
+
+// reserve marks number (or numeric string) id as used, so generated ids never collide with it
+func (r *IdRemapper) reserve(id json.RawMessage) {
+	var s string
+	if json.Unmarshal(id, &s) == nil {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			r.used[n] = struct{}{}
+		}
+		return
+	}
+	if n, err := strconv.ParseInt(idKey(id), 10, 64); err == nil {
+		r.used[n] = struct{}{}
+	}
+}
+
+// generate creates a fresh id that has the same JSON type (number or string) as orig
+func (r *IdRemapper) generate(orig json.RawMessage) (json.RawMessage, error) {
+	for {
+		n := r.next
+		r.next++
+		if _, ok := r.used[n]; ok {
+			continue
+		}
+		r.used[n] = struct{}{}
+		switch k := idKey(orig); {
+		case len(k) > 0 && k[0] == '"':
+			return json.RawMessage(strconv.Quote(strconv.FormatInt(n, 10))), nil
+		case len(k) > 0 && (k[0] == '-' || (k[0] >= '0' && k[0] <= '9')):
+			return json.RawMessage(strconv.FormatInt(n, 10)), nil
+		default:
+			return nil, fmt.Errorf("id must be number or string: %s", k)
+		}
+	}
+}
+
+// release forgets request id rewritten by Outgoing whose response is not waited for (e.g. receiver exited).
+// The rewritten id is still never generated again
+func (r *IdRemapper) release(newId json.RawMessage) {
+	if orig, found := r.revIds[idKey(newId)]; found {
+		delete(r.revIds, idKey(newId))
+		delete(r.ids, idKey(orig))
+	}
+}
+
+func (r *IdRemapper) mapToken(token json.RawMessage) (json.RawMessage, error) {
+	if v, ok := r.tokens[idKey(token)]; ok {
+		return v, nil
+	}
+	v, err := r.generate(token)
+	if err != nil {
+		return nil, err
+	}
+	r.tokens[idKey(token)] = v
+	r.revToken[idKey(v)] = token
+	return v, nil
+}
+
+func decodeMessage(msg []byte) (map[string]json.RawMessage, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("message must be JSON object")
+	}
+	return m, nil
+}
+
+// Outgoing rewrites request ids, cancel targets and progress tokens of message sent to the live party
+func (r *IdRemapper) Outgoing(msg []byte) ([]byte, error) {
+	m, err := decodeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	_, hasMethod := m["method"]
+	if id, ok := m["id"]; ok && hasMethod && !isNullOrEmpty(id) { // request
+		if _, found := r.ids[idKey(id)]; found {
+			return nil, fmt.Errorf("duplicated request id: %s", idKey(id))
+		}
+		newId, err := r.generate(id)
+		if err != nil {
+			return nil, err
+		}
+		r.ids[idKey(id)] = newId
+		r.revIds[idKey(newId)] = id
+		m["id"] = newId
+	}
+	if params, ok := m["params"]; ok && hasMethod {
+		var p map[string]json.RawMessage
+		if json.Unmarshal(params, &p) == nil && p != nil {
+			changed := false
+			var method string
+			_ = json.Unmarshal(m["method"], &method)
+			if target, ok := p["id"]; ok && method == "$/cancelRequest" {
+				if v, found := r.ids[idKey(target)]; found {
+					p["id"] = v
+					changed = true
+				}
+			}
+			for _, name := range []string{"workDoneToken", "partialResultToken"} {
+				if token, ok := p[name]; ok && !isNullOrEmpty(token) {
+					if p[name], err = r.mapToken(token); err != nil {
+						return nil, err
+					}
+					changed = true
+				}
+			}
+			if changed {
+				if m["params"], err = json.Marshal(p); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return json.Marshal(m)
+}
+
+// Incoming restores original ids of responses (and progress tokens) received from the live party.
+// Ids of requests sent by the live party are remembered for collision avoidance
+func (r *IdRemapper) Incoming(msg []byte) ([]byte, error) {
+	m, err := decodeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	id, hasId := m["id"]
+	_, hasMethod := m["method"]
+	if hasId && !isNullOrEmpty(id) {
+		if hasMethod { // request from the live party (may create progress token)
+			r.reserve(id)
+			var p struct {
+				Token json.RawMessage `json:"token"`
+			}
+			if json.Unmarshal(m["params"], &p) == nil && !isNullOrEmpty(p.Token) {
+				r.reserve(p.Token)
+			}
+			return msg, nil
+		}
+		orig, found := r.revIds[idKey(id)]
+		if !found {
+			return msg, nil // unknown response
+		}
+		delete(r.revIds, idKey(id))
+		delete(r.ids, idKey(orig))
+		m["id"] = orig
+		return json.Marshal(m)
+	}
+	if params, ok := m["params"]; ok && hasMethod {
+		var p map[string]json.RawMessage
+		if json.Unmarshal(params, &p) == nil && p != nil {
+			if token, ok := p["token"]; ok && !isNullOrEmpty(token) {
+				if orig, found := r.revToken[idKey(token)]; found {
+					p["token"] = orig
+					if m["params"], err = json.Marshal(p); err != nil {
+						return nil, err
+					}
+					return json.Marshal(m)
+				}
+				r.reserve(token) // token created by the live party
+			}
+		}
+	}
+	return msg, nil
+}
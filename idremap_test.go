@@ -0,0 +1,88 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestIdRemapperKeepType(t *testing.T) {
+	r := NewIdRemapper()
+	out, err := r.Outgoing([]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`, string(out))
+
+	out, err = r.Outgoing([]byte(`{"jsonrpc":"2.0","id":"1","method":"shutdown"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"2","method":"shutdown"}`, string(out))
+
+	// responses are mapped back to the original ids without changing type
+	out, err = r.Incoming([]byte(`{"jsonrpc":"2.0","id":"2","result":null}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"1","result":null}`, string(out))
+	out, err = r.Incoming([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{}}`, string(out))
+}
+
+func TestIdRemapperAvoidCollision(t *testing.T) {
+	r := NewIdRemapper()
+	// ids and tokens generated by the live party
+	msg := `{"jsonrpc":"2.0","id":1,"method":"workspace/configuration","params":{}}`
+	out, err := r.Incoming([]byte(msg))
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(out))
+	_, err = r.Incoming([]byte(`{"jsonrpc":"2.0","id":"2","method":"window/workDoneProgress/create","params":{"token":"3"}}`))
+	require.NoError(t, err)
+	_, err = r.Incoming([]byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":4,"value":{}}}`))
+	require.NoError(t, err)
+
+	out, err = r.Outgoing([]byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":5,"method":"textDocument/hover","params":{}}`, string(out))
+
+	// response to request from the live party is passed through
+	out, err = r.Outgoing([]byte(`{"jsonrpc":"2.0","id":1,"result":[]}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":[]}`, string(out))
+}
+
+func TestIdRemapperCancelAndProgress(t *testing.T) {
+	r := NewIdRemapper()
+	_, err := r.Incoming([]byte(`{"jsonrpc":"2.0","id":1,"method":"client/registerCapability","params":{}}`))
+	require.NoError(t, err)
+
+	out, err := r.Outgoing([]byte(
+		`{"jsonrpc":"2.0","id":"a","method":"workspace/symbol","params":{"query":"","workDoneToken":1,"partialResultToken":"p"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t,
+		`{"jsonrpc":"2.0","id":"2","method":"workspace/symbol","params":{"query":"","workDoneToken":3,"partialResultToken":"4"}}`,
+		string(out))
+
+	out, err = r.Outgoing([]byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"a"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"2"}}`, string(out))
+
+	out, err = r.Incoming([]byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"4","value":[]}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","method":"$/progress","params":{"token":"p","value":[]}}`, string(out))
+	out, err = r.Incoming([]byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":3,"value":{"kind":"end"}}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","method":"$/progress","params":{"token":1,"value":{"kind":"end"}}}`, string(out))
+
+	out, err = r.Incoming([]byte(`{"jsonrpc":"2.0","id":"2","error":{"code":-32800,"message":"cancelled"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"a","error":{"code":-32800,"message":"cancelled"}}`, string(out))
+}
+
+func TestIdRemapperError(t *testing.T) {
+	r := NewIdRemapper()
+	_, err := r.Outgoing([]byte(`[]`))
+	assert.Error(t, err)
+	_, err = r.Outgoing([]byte(`{"id":true,"method":"initialize"}`))
+	assert.Error(t, err)
+	_, err = r.Outgoing([]byte(`{"id":1,"method":"initialize"}`))
+	assert.NoError(t, err)
+	_, err = r.Outgoing([]byte(`{"id":1,"method":"initialize"}`))
+	assert.Error(t, err) // still outstanding
+}
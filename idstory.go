@@ -0,0 +1,144 @@
+package recorder
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// PrintId is JSON-RPC id of request selected by PrintFilter.Ids. Ids are unique only per direction,
+// so id may be qualified by sender of request (see ParsePrintId)
+type PrintId struct {
+	Key     string       // normalized id like 42 or "abc" (see idKey)
+	Streams []StreamType // streams of request (STDIN if sent by client, STDOUT if sent by server)
+}
+
+// qualifiers of PrintId
+const (
+	printIdClient = "client:"
+	printIdServer = "server:"
+)
+
+// ParsePrintId parses JSON-RPC id like 42 or "abc" (quotes of string id may be omitted). Id qualified like
+// client:42 or server:7 selects request of client or server, and unqualified one selects requests of both
+func ParsePrintId(s string) PrintId {
+	streams := []StreamType{STDIN, STDOUT}
+	if rest, ok := strings.CutPrefix(s, printIdClient); ok {
+		s, streams = rest, []StreamType{STDIN}
+	} else if rest, ok := strings.CutPrefix(s, printIdServer); ok {
+		s, streams = rest, []StreamType{STDOUT}
+	}
+	var v any
+	if json.Unmarshal([]byte(s), &v) == nil {
+		switch v.(type) {
+		case float64, string:
+			return PrintId{Key: s, Streams: streams}
+		}
+	}
+	data, _ := json.Marshal(s)
+	return PrintId{Key: string(data), Streams: streams}
+}
+
+// selects reports whether id selects request of stream
+func (id PrintId) selects(t StreamType, key string) bool {
+	return id.Key == key && slices.Contains(id.Streams, t)
+}
+
+// workDoneProgressCreate is request of server creating work done progress token
+const workDoneProgressCreate = "window/workDoneProgress/create"
+
+// idStory follows requests selected by PrintFilter.Ids in log order: the request, $/cancelRequest targeting it
+// and its response. If related is set, messages of peer sent while the request is pending (and responses to
+// peer requests among them) are also followed, as well as $/progress of tokens linked to the request
+// (workDoneToken, partialResultToken and tokens created by window/workDoneProgress/create while pending)
+type idStory struct {
+	ids     []PrintId
+	related bool
+	pending map[string]StreamType // key is stream and id of selected request waiting for response (see requestKey)
+	peers   map[string]bool       // key is stream and id of peer request sent while selected request is pending
+	tokens  map[string]bool       // progress tokens linked to selected requests (see idKey)
+}
+
+func newIdStory(ids []PrintId, related bool) *idStory {
+	return &idStory{ids: ids, related: related, pending: map[string]StreamType{}, peers: map[string]bool{},
+		tokens: map[string]bool{}}
+}
+
+// selected reports whether request of stream and id is selected
+func (s *idStory) selected(t StreamType, id json.RawMessage) bool {
+	key := idKey(id)
+	for _, printId := range s.ids {
+		if printId.selects(t, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingFor reports whether selected request sent by peer of stream is waiting for response
+func (s *idStory) pendingFor(t StreamType) bool {
+	for _, sender := range s.pending {
+		if sender == peerStream(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// follow tracks message (e is nil if record is not JSON-RPC message) and reports whether it is part of story.
+// Every record must be passed in order, even if it is not printed
+func (s *idStory) follow(d *LogData, e *Envelope) bool {
+	if e == nil || d.streamType == STDERR {
+		return false
+	}
+	switch {
+	case e.IsRequest():
+		if s.selected(d.streamType, e.Id) {
+			s.pending[pairKey(d, d.streamType, e.Id)] = d.streamType
+			if s.related {
+				s.linkTokens(d.payload, "workDoneToken", "partialResultToken")
+			}
+			return true
+		}
+		if s.related && s.pendingFor(d.streamType) {
+			s.peers[pairKey(d, d.streamType, e.Id)] = true
+			if e.Method == workDoneProgressCreate {
+				s.linkTokens(d.payload, "token")
+			}
+			return true
+		}
+		return false
+	case e.IsResponse():
+		key := pairKey(d, peerStream(d.streamType), e.Id)
+		if s.peers[key] {
+			delete(s.peers, key)
+			return true
+		}
+		delete(s.pending, key)
+		return s.selected(peerStream(d.streamType), e.Id) // even if request is missing (e.g. tail of log)
+	case e.Method == "$/cancelRequest":
+		if id := cancelTarget(d.payload); id != nil && s.selected(d.streamType, id) {
+			return true
+		}
+	case e.Method == "$/progress" && s.related:
+		if token := progressToken(d.payload, true); token != nil && s.tokens[idKey(token)] {
+			return true
+		}
+	}
+	return s.related && s.pendingFor(d.streamType)
+}
+
+// linkTokens links progress tokens of params of request to story
+func (s *idStory) linkTokens(payload []byte, names ...string) {
+	var m struct {
+		Params map[string]json.RawMessage `json:"params"`
+	}
+	if json.Unmarshal(payload, &m) != nil {
+		return
+	}
+	for _, name := range names {
+		if token, ok := m.Params[name]; ok && !isNullOrEmpty(token) {
+			s.tokens[idKey(token)] = true
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// idStoryTestLog has request of client id=5 reporting work done progress, and request of server with the same id
+var idStoryTestLog = newTestLog(
+	LogData{streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":5,"method":"textDocument/references","params":{"workDoneToken":"wd"}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":5,"method":"window/workDoneProgress/create","params":{"token":"srv"}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":5,"result":null}`)},
+	LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"wd","value":{"kind":"begin"}}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"type":3,"message":"searching"}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":5}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":6,"method":"textDocument/hover"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":5,"result":[]}`)},
+	LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"srv","value":{"kind":"end"}}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":6,"result":null}`)},
+	LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{}}`)},
+)
+
+var printedSeqPattern = regexp.MustCompile(`(?m)^#(\d+) `)
+
+// printedSeqs prints log by Print and PrintIndex, and returns sequence numbers of printed records
+func printedSeqs(t *testing.T, log string, filter PrintFilter) []int {
+	filter.ShowIndex = true
+	out := bytes.Buffer{}
+	f := filter
+	require.NoError(t, Print(strings.NewReader(log), &out, &f))
+	index, err := BuildLogIndex(strings.NewReader(log), nil)
+	require.NoError(t, err)
+	indexed := bytes.Buffer{}
+	f = filter
+	require.NoError(t, PrintIndex(index, &indexed, &f))
+	assert.Equal(t, out.String(), indexed.String())
+
+	var seqs []int
+	for _, m := range printedSeqPattern.FindAllStringSubmatch(out.String(), -1) {
+		seq, _ := strconv.Atoi(m[1])
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func TestPrintIdStory(t *testing.T) {
+	cases := []struct {
+		id       string
+		related  bool
+		expected []int
+	}{
+		{id: "client:5", expected: []int{1, 6, 8}},
+		{id: "server:5", expected: []int{2, 3}},
+		{id: "5", expected: []int{1, 2, 3, 6, 8}},
+		{id: "client:5", related: true, expected: []int{1, 2, 3, 4, 5, 6, 8, 9}},
+		{id: "client:6", related: true, expected: []int{7, 9, 10}}, // response to other request is not related
+		{id: "client:7", related: true},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s related=%v", c.id, c.related), func(t *testing.T) {
+			seqs := printedSeqs(t, idStoryTestLog, PrintFilter{Ids: []PrintId{ParsePrintId(c.id)}, Related: c.related})
+			assert.Equal(t, c.expected, seqs)
+		})
+	}
+}
+
+func TestPrintIdStoryWithOtherFilter(t *testing.T) {
+	// response ends story even if it is not printed
+	seqs := printedSeqs(t, idStoryTestLog, PrintFilter{Ids: []PrintId{ParsePrintId("client:5")}, Related: true,
+		Streams: []StreamType{STDIN}})
+	assert.Equal(t, []int{1, 3, 6}, seqs)
+}
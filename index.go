@@ -0,0 +1,177 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// LogEntry is metadata of a record and position of its line in log
+type LogEntry struct {
+	Seq    int
+	Time   time.Time
+	Stream StreamType
+	Type   PayloadType
+	Method string
+	Id     json.RawMessage // nil if not JSON-RPC request/response
+	Size   int             // size of message at capture (see LogData.messageSize)
+	Server int             // server session (see LogData.serverSession)
+	Offset int64           // offset of line in log
+	Length int             // length of line
+}
+
+// envelope returns JSON-RPC envelope reconstructed from metadata (nil if record is not JSON-RPC message)
+func (e *LogEntry) envelope() *Envelope {
+	if e.Type != JSON || (e.Method == "" && e.Id == nil) {
+		return nil
+	}
+	return &Envelope{Method: e.Method, Id: e.Id}
+}
+
+// LogIndex is metadata of all records. Payloads are loaded on demand by offset,
+// so commands needing payloads of only a few records do not decode the whole log
+type LogIndex struct {
+	Entries []LogEntry
+	reader  io.ReaderAt
+	format  string // detected format (empty until the first record)
+	size    int64  // size of indexed part of log (up to the last complete line)
+	lines   int    // number of lines in indexed part
+}
+
+var payloadKey = []byte(`,"payload":`)
+
+// decodeLogEntry decodes metadata of line. Since payload follows metadata in log written by writeLogData,
+// only the part before payload is decoded (whole line is decoded if payload is not found)
+func decodeLogEntry(line []byte) (LogEntry, error) {
+	var rec jsonLogRecord
+	head := line
+	if i := bytes.Index(line, payloadKey); i >= 0 { // never appears in JSON string since '"' is escaped
+		head = append(line[:i:i], '}')
+	}
+	if err := json.Unmarshal(head, &rec); err != nil {
+		return LogEntry{}, err
+	}
+	if rec.Time.IsZero() {
+		return LogEntry{}, errors.New("missing time")
+	}
+	streamType, err := parseStreamType(rec.Stream)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	payloadType, err := parsePayloadType(rec.Type)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	return LogEntry{
+		Seq:    rec.Seq,
+		Time:   rec.Time,
+		Stream: streamType,
+		Type:   payloadType,
+		Method: rec.Method,
+		Id:     rec.Id,
+		Size:   max(rec.Size, rec.OriginalSize), // size of old log is after truncation
+		Server: rec.ServerSession,
+		Length: len(line),
+	}, nil
+}
+
+// decodeTextLogEntry decodes metadata of line of text format. Whole line is decoded,
+// since payload is not always the last attribute
+func decodeTextLogEntry(line []byte) (LogEntry, error) {
+	d, err := decodeTextLogData(line)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	entry := LogEntry{Seq: d.seq, Time: d.timestamp, Stream: d.streamType, Type: d.payloadType,
+		Size: d.messageSize(), Server: d.serverSession, Length: len(line)}
+	if d.payloadType == JSON {
+		if e, err := d.Envelope(); err == nil {
+			entry.Method = e.Method
+			entry.Id = e.Id
+		}
+	}
+	return entry, nil
+}
+
+// entryDecoders are decoders of metadata of record line of each format
+var entryDecoders = map[string]func(line []byte) (LogEntry, error){
+	LogFormatJSON: decodeLogEntry,
+	LogFormatText: decodeTextLogEntry,
+}
+
+// BuildLogIndex reads metadata of all records (JSON lines or text format). If reader is not io.ReaderAt (e.g. pipe),
+// whole log is kept in memory for later payload retrieval. If skip is not nil, broken lines are reported to it
+// and skipped (see LogReader.SkipErrors)
+func BuildLogIndex(reader io.Reader, skip func(err error)) (*LogIndex, error) {
+	readerAt, ok := reader.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		readerAt = bytes.NewReader(data)
+		reader = bytes.NewReader(data)
+	}
+	index := &LogIndex{reader: readerAt}
+	if err := index.scan(reader, skip); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// scan appends entries of lines read from reader, which starts at index.size of log. index.size and index.lines
+// are advanced to the end of the last line terminated by newline
+func (x *LogIndex) scan(reader io.Reader, skip func(err error)) error {
+	br := bufio.NewReader(reader)
+	offset := x.size
+	for lineNum := x.lines + 1; ; lineNum++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if !(len(line) == 1 && line[0] == '\n') { // skip empty line
+			if x.format == "" {
+				format, err := detectLineFormat(line)
+				switch {
+				case err == nil:
+					x.format = format
+				case skip != nil:
+					skip(brokenLineError(lineNum, line, err)) // detect format by next line
+				default:
+					return brokenLineError(lineNum, line, err)
+				}
+			}
+			if x.format != "" {
+				entry, err := entryDecoders[x.format](line)
+				switch {
+				case err == nil:
+					entry.Offset = offset
+					x.Entries = append(x.Entries, entry)
+				case skip != nil:
+					skip(brokenLineError(lineNum, line, err))
+				default:
+					return brokenLineError(lineNum, line, err)
+				}
+			}
+		}
+		offset += int64(len(line))
+		if line[len(line)-1] == '\n' {
+			x.size, x.lines = offset, lineNum
+		}
+	}
+}
+
+// Load reads and decodes whole record of entry
+func (x *LogIndex) Load(entry *LogEntry) (*LogData, error) {
+	line := make([]byte, entry.Length)
+	if n, err := x.reader.ReadAt(line, entry.Offset); n < len(line) {
+		return nil, err
+	}
+	return lineDecoders[x.format](line)
+}
@@ -0,0 +1,145 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBuildLogIndex(t *testing.T) {
+	index, err := BuildLogIndex(strings.NewReader(printTestLog), nil)
+	require.NoError(t, err)
+	require.Len(t, index.Entries, 4)
+	e := index.Entries[1]
+	assert.Equal(t, 2, e.Seq)
+	assert.Equal(t, STDIN, e.Stream)
+	assert.Equal(t, JSON, e.Type)
+	assert.Equal(t, "initialize", e.Method)
+	assert.Equal(t, "1", string(e.Id))
+	assert.True(t, e.envelope().IsRequest())
+	assert.True(t, index.Entries[3].envelope().IsResponse())
+	assert.Nil(t, index.Entries[2].envelope())
+
+	for i := range index.Entries {
+		d, err := index.Load(&index.Entries[i])
+		require.NoError(t, err)
+		assert.Equal(t, index.Entries[i].Seq, d.seq)
+		assert.Equal(t, index.Entries[i].Size, len(d.payload))
+	}
+
+	// not io.ReaderAt
+	index, err = BuildLogIndex(io.MultiReader(strings.NewReader(printTestLog)), nil)
+	require.NoError(t, err)
+	d, err := index.Load(&index.Entries[3])
+	require.NoError(t, err)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":{}}`, string(d.payload))
+}
+
+func TestBuildLogIndexText(t *testing.T) {
+	expected, err := BuildLogIndex(strings.NewReader(formatTestLog), nil)
+	require.NoError(t, err)
+	index, err := BuildLogIndex(strings.NewReader(convertLog(t, formatTestLog, LogFormatJSON, LogFormatText)), nil)
+	require.NoError(t, err)
+	require.Len(t, index.Entries, len(expected.Entries))
+	for i := range index.Entries {
+		e := index.Entries[i]
+		assert.Equal(t, expected.Entries[i].Method, e.Method)
+		assert.Equal(t, string(expected.Entries[i].Id), string(e.Id))
+		assert.Equal(t, expected.Entries[i].Size, e.Size)
+		d, err := index.Load(&e)
+		require.NoError(t, err)
+		assert.Equal(t, expected.Entries[i].Time, d.timestamp)
+		assert.Equal(t, e.Size, len(d.payload))
+	}
+}
+
+func TestDecodeLogEntryWithoutPayload(t *testing.T) {
+	line := `{"time":"2024-05-01T10:00:00Z","seq":3,"stream":"stdout","type":"raw_end","size":0}`
+	e, err := decodeLogEntry([]byte(line))
+	require.NoError(t, err)
+	assert.Equal(t, 3, e.Seq)
+	assert.Equal(t, RAW_END, e.Type)
+
+	_, err = decodeLogEntry([]byte(`{"seq":3,"payload":"x"}`))
+	assert.Error(t, err)
+}
+
+func TestPrintIndex(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":"a","method":"textDocument/hover"}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"a"}}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":"a","result":null}`)},
+	)
+	for _, filter := range []PrintFilter{
+		{Ids: []PrintId{ParsePrintId("a")}},
+		{Ids: []PrintId{ParsePrintId("1")}},
+		{Seqs: []int{2, 5}},
+		{Seqs: []int{1, 4}, Ids: []PrintId{ParsePrintId("a")}},
+	} {
+		expected := bytes.Buffer{}
+		f := filter
+		require.NoError(t, Print(strings.NewReader(log), &expected, &f))
+		index, err := BuildLogIndex(strings.NewReader(log), nil)
+		require.NoError(t, err)
+		actual := bytes.Buffer{}
+		f = filter
+		require.NoError(t, PrintIndex(index, &actual, &f))
+		assert.Equal(t, expected.String(), actual.String(), fmt.Sprintf("%+v", filter))
+	}
+
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Ids: []PrintId{ParsePrintId("a")}}))
+	assert.Contains(t, out.String(), "(response to cancelled textDocument/hover id=\"a\", 3s)")
+	assert.Equal(t, 3, strings.Count(out.String(), "2024-")) // request, $/cancelRequest and response
+}
+
+func TestParsePrintId(t *testing.T) {
+	both := []StreamType{STDIN, STDOUT}
+	assert.Equal(t, PrintId{Key: "42", Streams: both}, ParsePrintId("42"))
+	assert.Equal(t, PrintId{Key: `"abc"`, Streams: both}, ParsePrintId(`"abc"`))
+	assert.Equal(t, PrintId{Key: `"abc"`, Streams: both}, ParsePrintId("abc"))
+	assert.Equal(t, PrintId{Key: `"[1]"`, Streams: both}, ParsePrintId("[1]"))
+	assert.Equal(t, PrintId{Key: "1042", Streams: []StreamType{STDIN}}, ParsePrintId("client:1042"))
+	assert.Equal(t, PrintId{Key: `"a"`, Streams: []StreamType{STDOUT}}, ParsePrintId("server:a"))
+	assert.Equal(t, PrintId{Key: `"client:1"`, Streams: both}, ParsePrintId(`"client:1"`))
+}
+
+// largeTestLog returns log having count didChange notifications of size bytes
+func largeTestLog(count int, size int) []byte {
+	payload := fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"text":"%s"}}`,
+		strings.Repeat("x", size))
+	data := make([]LogData, count)
+	for i := range data {
+		data[i] = LogData{streamType: STDIN, payloadType: JSON, payload: []byte(payload)}
+	}
+	return []byte(newTestLog(data...))
+}
+
+func BenchmarkLogReader(b *testing.B) {
+	log := largeTestLog(1000, 16*1024)
+	b.SetBytes(int64(len(log)))
+	for i := 0; i < b.N; i++ {
+		r := NewLogReader(bytes.NewReader(log))
+		for {
+			if _, err := r.Next(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkBuildLogIndex(b *testing.B) {
+	log := largeTestLog(1000, 16*1024)
+	b.SetBytes(int64(len(log)))
+	for i := 0; i < b.N; i++ {
+		if _, err := BuildLogIndex(bytes.NewReader(log), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
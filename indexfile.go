@@ -0,0 +1,181 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// LogIndexSuffix is suffix of persisted index of log (e.g. session.log.idx, see WriteLogIndex)
+const LogIndexSuffix = ".idx"
+
+// logIndexVersion is version of persisted index. Index of other version is ignored
+const logIndexVersion = 1
+
+// logIndexCheckBytes is size of head and tail of indexed part of log, whose checksums tell whether index
+// still matches log (e.g. log is not rotated or overwritten)
+const logIndexCheckBytes = 4096
+
+// logIndexHeader is the first line of persisted index, followed by JSON lines of logIndexEntry
+type logIndexHeader struct {
+	Version int    `json:"version"`
+	Format  string `json:"format"` // format of log (json or text)
+	Size    int64  `json:"size"`   // size of indexed part of log (up to the last complete line)
+	Lines   int    `json:"lines"`  // number of lines in indexed part
+	Head    string `json:"head"`   // CRC-32C of the first logIndexCheckBytes of indexed part
+	Tail    string `json:"tail"`   // CRC-32C of the last logIndexCheckBytes of indexed part
+	Entries int    `json:"entries"`
+}
+
+// logIndexEntry is LogEntry in persisted index
+type logIndexEntry struct {
+	Seq    int             `json:"seq"`
+	Time   time.Time       `json:"time"`
+	Stream string          `json:"stream"`
+	Type   string          `json:"type"`
+	Method string          `json:"method,omitempty"`
+	Id     json.RawMessage `json:"id,omitempty"`
+	Size   int             `json:"size"`
+	Server int             `json:"server_session,omitempty"`
+	Offset int64           `json:"offset"`
+	Length int             `json:"length"`
+}
+
+// logIndexCheck returns checksums of head and tail of the first size bytes of log
+func logIndexCheck(reader io.ReaderAt, size int64) (string, string, error) {
+	n := min(size, logIndexCheckBytes)
+	head := make([]byte, n)
+	tail := make([]byte, n)
+	if _, err := reader.ReadAt(head, 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", "", err
+	}
+	if _, err := reader.ReadAt(tail, size-n); err != nil && !errors.Is(err, io.EOF) {
+		return "", "", err
+	}
+	return payloadChecksum(head), payloadChecksum(tail), nil
+}
+
+// WriteLogIndex writes index of log to <name>.idx, so that print seeks matched records instead of scanning
+// (see ReadLogIndex). Only uncompressed log (json or text) is indexed, since json-gzip and json-zstd logs cannot
+// be read at offset. If skip is not nil, broken lines are reported to it and skipped. Returns number of entries
+func WriteLogIndex(name string, skip func(err error)) (int, error) {
+	input, err := OpenLog(name)
+	if err != nil {
+		return 0, err
+	}
+	defer func(input io.ReadCloser) {
+		_ = input.Close()
+	}(input)
+	file, ok := input.(*os.File)
+	if !ok {
+		return 0, fmt.Errorf("compressed log cannot be indexed: %s (convert it into json or text)", name)
+	}
+	index, err := BuildLogIndex(file, skip)
+	if err != nil {
+		return 0, err
+	}
+	head, tail, err := logIndexCheck(file, index.size)
+	if err != nil {
+		return 0, err
+	}
+	entries := index.Entries
+	for len(entries) > 0 && entries[len(entries)-1].Offset >= index.size { // incomplete last line
+		entries = entries[:len(entries)-1]
+	}
+
+	tmp := name + LogIndexSuffix + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(out)
+	encoder := json.NewEncoder(writer)
+	err = encoder.Encode(&logIndexHeader{Version: logIndexVersion, Format: index.format, Size: index.size,
+		Lines: index.lines, Head: head, Tail: tail, Entries: len(entries)})
+	for i := 0; err == nil && i < len(entries); i++ {
+		e := &entries[i]
+		err = encoder.Encode(&logIndexEntry{Seq: e.Seq, Time: e.Time, Stream: e.Stream.String(), Type: e.Type.String(),
+			Method: e.Method, Id: e.Id, Size: e.Size, Server: e.Server, Offset: e.Offset, Length: e.Length})
+	}
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+LogIndexSuffix)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// ReadLogIndex reads index of log written by WriteLogIndex, and scans records appended after indexing.
+// Returns nil (without error) if reader is not uncompressed log file opened by OpenLog, or its index is
+// missing, broken or does not match log (e.g. rotated or overwritten log), so that caller falls back to scanning
+func ReadLogIndex(reader io.Reader, skip func(err error)) (*LogIndex, error) {
+	file, ok := reader.(*os.File)
+	if !ok {
+		return nil, nil
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	index := loadLogIndex(file, info.Size())
+	if index == nil {
+		return nil, nil
+	}
+	if info.Size() > index.size {
+		if err := index.scan(io.NewSectionReader(file, index.size, info.Size()-index.size), skip); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// loadLogIndex loads index of log file of size (nil if it cannot be used)
+func loadLogIndex(file *os.File, size int64) *LogIndex {
+	data, err := os.Open(file.Name() + LogIndexSuffix)
+	if err != nil {
+		return nil
+	}
+	defer func(data *os.File) {
+		_ = data.Close()
+	}(data)
+	decoder := json.NewDecoder(bufio.NewReader(data))
+	var header logIndexHeader
+	if decoder.Decode(&header) != nil || header.Version != logIndexVersion || entryDecoders[header.Format] == nil ||
+		header.Size > size {
+		return nil
+	}
+	if head, tail, err := logIndexCheck(file, header.Size); err != nil || head != header.Head || tail != header.Tail {
+		return nil
+	}
+	index := &LogIndex{Entries: make([]LogEntry, 0, header.Entries), reader: file, format: header.Format,
+		size: header.Size, lines: header.Lines}
+	for i := 0; i < header.Entries; i++ {
+		var e logIndexEntry
+		if decoder.Decode(&e) != nil {
+			return nil
+		}
+		streamType, err := parseStreamType(e.Stream)
+		if err != nil {
+			return nil
+		}
+		payloadType, err := parsePayloadType(e.Type)
+		if err != nil {
+			return nil
+		}
+		index.Entries = append(index.Entries, LogEntry{Seq: e.Seq, Time: e.Time, Stream: streamType, Type: payloadType,
+			Method: e.Method, Id: e.Id, Size: e.Size, Server: e.Server, Offset: e.Offset, Length: e.Length})
+	}
+	return index
+}
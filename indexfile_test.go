@@ -0,0 +1,99 @@
+package recorder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readTestLogIndex(t *testing.T, name string) *LogIndex {
+	input, err := OpenLog(name)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = input.Close()
+	})
+	index, err := ReadLogIndex(input, func(err error) {}) // incomplete last line is skipped
+	require.NoError(t, err)
+	return index
+}
+
+func TestWriteLogIndex(t *testing.T) {
+	for _, format := range []string{LogFormatJSON, LogFormatText} {
+		t.Run(format, func(t *testing.T) {
+			log := convertLog(t, printTestLog, LogFormatJSON, format)
+			name := filepath.Join(t.TempDir(), "test.log")
+			require.NoError(t, os.WriteFile(name, []byte(log+log[:10]), 0644)) // last line is being written
+			assert.Nil(t, readTestLogIndex(t, name))                           // not indexed yet
+
+			var skipped []error
+			n, err := WriteLogIndex(name, func(err error) {
+				skipped = append(skipped, err)
+			})
+			require.NoError(t, err)
+			assert.Equal(t, 4, n)
+			assert.Len(t, skipped, 1)
+			expected, err := BuildLogIndex(strings.NewReader(log), nil)
+			require.NoError(t, err)
+			index := readTestLogIndex(t, name)
+			require.NotNil(t, index)
+			require.Len(t, index.Entries, 4)
+			for i := range index.Entries {
+				assert.Equal(t, expected.Entries[i].Seq, index.Entries[i].Seq)
+				assert.True(t, expected.Entries[i].Time.Equal(index.Entries[i].Time))
+				assert.Equal(t, expected.Entries[i].Method, index.Entries[i].Method)
+				assert.Equal(t, string(expected.Entries[i].Id), string(index.Entries[i].Id))
+				assert.Equal(t, expected.Entries[i].Offset, index.Entries[i].Offset)
+				d, err := index.Load(&index.Entries[i])
+				require.NoError(t, err)
+				assert.Equal(t, expected.Entries[i].Size, len(d.payload))
+			}
+
+			// records appended after indexing are scanned
+			require.NoError(t, os.WriteFile(name, []byte(log+log), 0644))
+			index = readTestLogIndex(t, name)
+			require.NotNil(t, index)
+			require.Len(t, index.Entries, 8)
+			assert.Equal(t, int64(len(log)), index.Entries[4].Offset)
+			d, err := index.Load(&index.Entries[7])
+			require.NoError(t, err)
+			assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":{}}`, string(d.payload))
+
+			// stale index of overwritten log is ignored
+			require.NoError(t, os.WriteFile(name, []byte(strings.Replace(log, "initialize", "initialized", 1)), 0644))
+			assert.Nil(t, readTestLogIndex(t, name))
+		})
+	}
+}
+
+func TestWriteLogIndexCompressed(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.log.gz")
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte(printTestLog))
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.WriteFile(name, buf.Bytes(), 0644))
+	_, err := WriteLogIndex(name, nil)
+	assert.ErrorContains(t, err, "compressed log cannot be indexed")
+	assert.NoFileExists(t, name+LogIndexSuffix)
+}
+
+func TestPrintIndexTailSkip(t *testing.T) {
+	log := printTestLog + printTestLog
+	for _, filter := range []PrintFilter{{Tail: 3}, {Skip: 5}, {Skip: 1, Tail: 2, Methods: []string{"initialize"}}} {
+		expected := bytes.Buffer{}
+		f := filter
+		require.NoError(t, Print(strings.NewReader(log), &expected, &f))
+		index, err := BuildLogIndex(strings.NewReader(log), nil)
+		require.NoError(t, err)
+		actual := bytes.Buffer{}
+		f = filter
+		require.NoError(t, PrintIndex(index, &actual, &f))
+		assert.Equal(t, expected.String(), actual.String())
+		assert.NotEmpty(t, actual.String())
+	}
+}
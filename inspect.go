@@ -0,0 +1,400 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Envelope is common fields of JSON-RPC message
+type Envelope struct {
+	Method string          `json:"method"`
+	Id     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func (e *Envelope) IsRequest() bool {
+	return e.Method != "" && e.Id != nil
+}
+
+func (e *Envelope) IsNotification() bool {
+	return e.Method != "" && e.Id == nil
+}
+
+func (e *Envelope) IsResponse() bool {
+	return e.Method == "" && e.Id != nil
+}
+
+// responseErrorCode returns code of error response (0 if response is not error)
+func responseErrorCode(e *Envelope) int {
+	var rpcError struct {
+		Code int `json:"code"`
+	}
+	if isNullOrEmpty(e.Error) || json.Unmarshal(e.Error, &rpcError) != nil {
+		return 0
+	}
+	return rpcError.Code
+}
+
+// ParseEnvelope parses JSON-RPC message. Truncated message (see truncatePayload) is also parsed
+// if its envelope fields appear before the truncated point
+func ParseEnvelope(payload []byte) (*Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(payload, &e); err != nil {
+		var syntaxErr *json.SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Offset != int64(len(payload)) {
+			return nil, err
+		}
+		e = parseEnvelopePrefix(payload)
+	}
+	if e.Method == "" && e.Id == nil {
+		return nil, errors.New("not JSON-RPC message")
+	}
+	return &e, nil
+}
+
+// isBatch reports whether payload is JSON-RPC batch (top-level array)
+func isBatch(payload []byte) bool {
+	trimmed := bytes.TrimLeft(payload, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// isEmptyBatch reports whether payload is empty array, which is invalid as JSON-RPC batch
+func isEmptyBatch(payload []byte) bool {
+	var elements []json.RawMessage
+	return isBatch(payload) && json.Unmarshal(payload, &elements) == nil && len(elements) == 0
+}
+
+// expandBatch returns elements of batch record as records of logical messages sharing seq and timestamp
+// (batch and batchLen are set). Non-batch record, empty batch and broken batch (e.g. truncated) are
+// returned as is
+func expandBatch(d *LogData) []*LogData {
+	if d.payloadType != JSON || !isBatch(d.payload) {
+		return []*LogData{d}
+	}
+	var elements []json.RawMessage
+	if json.Unmarshal(d.payload, &elements) != nil || len(elements) == 0 {
+		return []*LogData{d}
+	}
+	records := make([]*LogData, len(elements))
+	for i, element := range elements {
+		v := *d
+		v.payload, v.size, v.originalSize = element, len(element), 0
+		v.batch, v.batchLen = i+1, len(elements)
+		records[i] = &v
+	}
+	return records
+}
+
+// parseEnvelopePrefix parses top-level fields of truncated JSON object until the truncated point
+func parseEnvelopePrefix(payload []byte) Envelope {
+	var e Envelope
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return e
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return e
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil { // truncated
+			switch token {
+			case "result":
+				e.Result = json.RawMessage("{}") // present, but incomplete
+			case "error":
+				e.Error = json.RawMessage("{}")
+			}
+			return e
+		}
+		switch token {
+		case "method":
+			_ = json.Unmarshal(value, &e.Method)
+		case "id":
+			e.Id = value
+		case "result":
+			e.Result = value
+		case "error":
+			e.Error = value
+		}
+	}
+	return e
+}
+
+// peerStream returns stream of the other party (STDIN <-> STDOUT).
+// Response is sent on the peer stream of the corresponding request
+func peerStream(t StreamType) StreamType {
+	if t == STDIN {
+		return STDOUT
+	}
+	return STDIN
+}
+
+// recordTime is time of record by wall clock and by monotonic elapsed time (see LogData.elapsed)
+type recordTime struct {
+	wall    time.Time
+	elapsed time.Duration // 0 if not recorded (e.g. old log)
+}
+
+func (d *LogData) recordTime() recordTime {
+	return recordTime{wall: d.timestamp, elapsed: d.elapsed}
+}
+
+// Sub returns t-u. Monotonic elapsed time is preferred, since wall clock may jump (e.g. adjusted by NTP)
+// during session. Wall clock is used if either is not recorded
+func (t recordTime) Sub(u recordTime) time.Duration {
+	if t.elapsed > 0 && u.elapsed > 0 {
+		return t.elapsed - u.elapsed
+	}
+	return t.wall.Sub(u.wall)
+}
+
+func (t recordTime) After(u recordTime) bool {
+	return t.Sub(u) > 0
+}
+
+type pendingRequest struct {
+	method    string
+	stream    StreamType
+	id        json.RawMessage
+	timestamp time.Time
+	at        recordTime // time of request (timestamp with elapsed time) for latency
+	cancelled bool
+	cancelAt  recordTime // time of first $/cancelRequest of request
+	token     string     // key of partial result token (empty if request does not stream partial results)
+	partials  PartialResults
+}
+
+// PartialResults is partial results of request, which are sent by $/progress notifications
+// with partialResultToken of the request
+type PartialResults struct {
+	Chunks int
+	Bytes  int        // total payload size of $/progress notifications
+	First  recordTime // time of first chunk
+	Last   recordTime // time of last chunk
+}
+
+// RequestTracker pairs responses with outstanding requests in log order.
+// $/progress notifications of partial results are linked with request by partialResultToken
+type RequestTracker struct {
+	pending  map[string]*pendingRequest // key is source, stream of request and id (see pairKey)
+	partials map[string]*pendingRequest // key is source, stream of request and partial result token
+}
+
+func NewRequestTracker() *RequestTracker {
+	return &RequestTracker{pending: map[string]*pendingRequest{}, partials: map[string]*pendingRequest{}}
+}
+
+func requestKey(t StreamType, id json.RawMessage) string {
+	return fmt.Sprintf("%s:%s", t, idKey(id))
+}
+
+// pairKey is requestKey qualified by source of record merged from several logs (see MergeLogs), so that request
+// and response of different sources are never paired
+func pairKey(d *LogData, t StreamType, id json.RawMessage) string {
+	if d.source == "" {
+		return requestKey(t, id)
+	}
+	return d.source + "|" + requestKey(t, id)
+}
+
+func formatLatency(d time.Duration) string {
+	if d >= time.Millisecond {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+// formatSize formats byte size like 512B, 1.5KB, 4.2MB
+func formatSize(n int) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// progressToken returns params.partialResultToken (or params.token if progress is true)
+func progressToken(payload []byte, progress bool) json.RawMessage {
+	var m struct {
+		Params struct {
+			Token              json.RawMessage `json:"token"`
+			PartialResultToken json.RawMessage `json:"partialResultToken"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(payload, &m) != nil {
+		return nil
+	}
+	token := m.Params.PartialResultToken
+	if progress {
+		token = m.Params.Token
+	}
+	if isNullOrEmpty(token) {
+		return nil
+	}
+	return token
+}
+
+// cancelTarget returns params.id of $/cancelRequest (number or string), or nil if missing
+func cancelTarget(payload []byte) json.RawMessage {
+	var cancel struct {
+		Params struct {
+			Id json.RawMessage `json:"id"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(payload, &cancel) != nil || isNullOrEmpty(cancel.Params.Id) {
+		return nil
+	}
+	return cancel.Params.Id
+}
+
+// pairing is result of RequestTracker.pair
+type pairing struct {
+	method  string          // method of message (method of the corresponding request if response or partial result)
+	note    string          // see RequestTracker.Observe
+	request *pendingRequest // corresponding request of response or partial result (nil if not found)
+	partial bool            // message is partial result of request
+}
+
+// Observe tracks message and returns its method (method of the corresponding request if response
+// or partial result) and note about pairing (like "response to textDocument/hover id=42, 38ms").
+// Anomalies such as duplicate ids or unknown responses are also reported in note
+func (r *RequestTracker) Observe(d *LogData, e *Envelope) (string, string) {
+	p := r.pair(d, e)
+	return p.method, p.note
+}
+
+func (r *RequestTracker) pair(d *LogData, e *Envelope) pairing {
+	switch {
+	case e.IsRequest():
+		key := pairKey(d, d.streamType, e.Id)
+		note := ""
+		if prev, ok := r.pending[key]; ok {
+			note = fmt.Sprintf("duplicate request id=%s (previous %s is not answered)", idKey(e.Id), prev.method)
+			r.forget(prev)
+		}
+		req := &pendingRequest{method: e.Method, stream: d.streamType, id: e.Id, timestamp: d.timestamp,
+			at: d.recordTime()}
+		if token := progressToken(d.payload, false); token != nil {
+			req.token = pairKey(d, d.streamType, token)
+			r.partials[req.token] = req
+		}
+		r.pending[key] = req
+		return pairing{method: e.Method, note: note}
+	case e.IsResponse():
+		key := pairKey(d, peerStream(d.streamType), e.Id)
+		req, ok := r.pending[key]
+		if !ok {
+			return pairing{note: fmt.Sprintf("response to unknown request id=%s", idKey(e.Id))}
+		}
+		delete(r.pending, key)
+		r.forget(req)
+		kind := "response to"
+		if req.cancelled {
+			kind = "response to cancelled"
+		}
+		note := fmt.Sprintf("%s %s id=%s, %s", kind, req.method, idKey(e.Id), formatLatency(d.recordTime().Sub(req.at)))
+		if p := req.partials; p.Chunks > 0 {
+			complete := d.recordTime()
+			if p.Last.After(complete) {
+				complete = p.Last
+			}
+			note = fmt.Sprintf("%s %s id=%s, first result after %s, complete after %s, %s across %d chunks",
+				kind, req.method, idKey(e.Id), formatLatency(p.First.Sub(req.at)),
+				formatLatency(complete.Sub(req.at)), formatSize(p.Bytes), p.Chunks)
+		}
+		return pairing{method: req.method, note: note, request: req}
+	case e.Method == "$/cancelRequest":
+		id := cancelTarget(d.payload)
+		if id == nil {
+			return pairing{method: e.Method, note: "cancel request without id"}
+		}
+		if req, ok := r.pending[pairKey(d, d.streamType, id)]; ok {
+			if !req.cancelled {
+				req.cancelled, req.cancelAt = true, d.recordTime()
+			}
+			return pairing{method: e.Method, note: fmt.Sprintf("cancel %s id=%s", req.method, idKey(id))}
+		}
+		return pairing{method: e.Method, note: fmt.Sprintf("cancel unknown request id=%s", idKey(id))}
+	case e.Method == "$/progress" && len(r.partials) > 0:
+		token := progressToken(d.payload, true)
+		if token == nil {
+			return pairing{method: e.Method}
+		}
+		req, ok := r.partials[pairKey(d, peerStream(d.streamType), token)]
+		if !ok { // work done progress or unknown token
+			return pairing{method: e.Method}
+		}
+		p := &req.partials
+		if p.Chunks == 0 {
+			p.First = d.recordTime()
+		}
+		p.Chunks++
+		p.Bytes += len(d.payload)
+		p.Last = d.recordTime()
+		return pairing{method: req.method, request: req, partial: true,
+			note: fmt.Sprintf("partial result %d of %s id=%s, %s", p.Chunks, req.method, idKey(req.id), formatLatency(d.recordTime().Sub(req.at)))}
+	default:
+		return pairing{method: e.Method}
+	}
+}
+
+// forget unlinks partial result token of request
+func (r *RequestTracker) forget(req *pendingRequest) {
+	if req.token != "" && r.partials[req.token] == req {
+		delete(r.partials, req.token)
+	}
+}
+
+// MatchMethod reports whether method matches any of glob patterns.
+// In pattern, '*' matches any sequence of characters (including '/') and '?' matches any single character
+func MatchMethod(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateGlob checks pattern of MatchMethod. Character classes and escapes of path.Match (formerly used by
+// --suppress-to-client) are rejected instead of being matched literally
+func ValidateGlob(pattern string) error {
+	if i := strings.IndexAny(pattern, "[]\\"); i >= 0 {
+		return fmt.Errorf("unsupported character '%c' in method pattern (only * and ? are special): '%s'",
+			pattern[i], pattern)
+	}
+	return nil
+}
+
+func matchGlob(pattern string, s string) bool {
+	px, sx := 0, 0
+	starPx, starSx := -1, 0 // position of last '*' for backtracking
+	for sx < len(s) {
+		switch {
+		case px < len(pattern) && pattern[px] == '*':
+			starPx, starSx = px, sx
+			px++
+		case px < len(pattern) && (pattern[px] == '?' || pattern[px] == s[sx]):
+			px++
+			sx++
+		case starPx >= 0:
+			starSx++
+			px, sx = starPx+1, starSx
+		default:
+			return false
+		}
+	}
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+	return px == len(pattern)
+}
@@ -0,0 +1,111 @@
+package recorder
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// stderrLineIdle is how long partial line of stderr is held before it is recorded
+const stderrLineIdle = 100 * time.Millisecond
+
+// stderrLineMax is max size of line of stderr record (longer line is recorded in pieces of this size)
+const stderrLineMax = 64 * 1024
+
+// lineSplitter splits chunks of stderr into lines, so that each record has a whole line (including '\n').
+// Partial line is emitted after idle timeout, at size cap or at Close. emit may be called by timer goroutine
+type lineSplitter struct {
+	idle time.Duration
+	max  int
+	emit func(line []byte)
+
+	mutex  sync.Mutex
+	buf    []byte      // partial line (guarded by mutex)
+	timer  *time.Timer // flushes partial line after idle (guarded by mutex)
+	closed bool        // guarded by mutex
+}
+
+func newLineSplitter(idle time.Duration, max int, emit func(line []byte)) *lineSplitter {
+	return &lineSplitter{idle: idle, max: max, emit: emit}
+}
+
+// Write emits complete lines of data, and holds the rest until newline, idle timeout or size cap
+func (s *lineSplitter) Write(data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		if len(s.buf) == 0 {
+			s.emitLine(data[:i+1])
+		} else {
+			s.buf = append(s.buf, data[:i+1]...)
+			s.flush()
+		}
+		data = data[i+1:]
+	}
+	s.buf = append(s.buf, data...)
+	if n := len(s.buf) - len(s.buf)%s.max; n > 0 {
+		s.emitLine(s.buf[:n])
+		s.buf = append(s.buf[:0], s.buf[n:]...)
+	}
+	if len(s.buf) == 0 {
+		return
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.idle, s.flushIdle)
+	} else {
+		s.timer.Reset(s.idle)
+	}
+}
+
+// emitLine emits copy of line in pieces of max size (mutex must be held)
+func (s *lineSplitter) emitLine(line []byte) {
+	for len(line) > s.max {
+		s.emit(bytes.Clone(line[:s.max]))
+		line = line[s.max:]
+	}
+	s.emit(bytes.Clone(line))
+}
+
+// flush emits partial line (mutex must be held)
+func (s *lineSplitter) flush() {
+	if len(s.buf) > 0 {
+		s.emitLine(s.buf)
+		if cap(s.buf) > s.max {
+			s.buf = nil // do not keep buffer of huge line
+		} else {
+			s.buf = s.buf[:0]
+		}
+	}
+}
+
+func (s *lineSplitter) flushIdle() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.closed {
+		s.flush()
+	}
+}
+
+// Close emits partial line (e.g. at end of stream). emit is never called after Close
+func (s *lineSplitter) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.flush()
+	s.closed = true
+}
@@ -0,0 +1,56 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineCollector collects lines emitted by lineSplitter
+type lineCollector struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (c *lineCollector) emit(line []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lines = append(c.lines, string(line))
+}
+
+func (c *lineCollector) get() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+func TestLineSplitter(t *testing.T) {
+	c := &lineCollector{}
+	s := newLineSplitter(time.Hour, 12, c.emit)
+	data := []byte("first li")
+	s.Write(data)
+	copy(data, "XXXXXXXX") // reused by caller
+	s.Write([]byte("ne\nsecond\nthi"))
+	assert.Equal(t, []string{"first line\n", "second\n"}, c.get())
+	s.Write([]byte("rd line is long\n\n"))
+	assert.Equal(t, []string{"first line\n", "second\n", "third line i", "s long\n", "\n"}, c.get())
+	s.Write([]byte("very long line without newline"))
+	assert.Equal(t, []string{"very long li", "ne without n"}, c.get()[5:])
+	s.Close()
+	s.Write([]byte("ignored"))
+	s.Close()
+	assert.Equal(t, []string{"very long li", "ne without n", "ewline"}, c.get()[5:])
+}
+
+func TestLineSplitterIdle(t *testing.T) {
+	c := &lineCollector{}
+	s := newLineSplitter(20*time.Millisecond, 1024, c.emit)
+	s.Write([]byte("prompt> "))
+	assert.Empty(t, c.get())
+	assert.Eventually(t, func() bool { return len(c.get()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"prompt> "}, c.get())
+	s.Write([]byte("answer\n"))
+	s.Close()
+	assert.Equal(t, []string{"prompt> ", "answer\n"}, c.get())
+}
@@ -0,0 +1,129 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// liveQueueSize is number of records buffered for each viewer of LiveStream
+const liveQueueSize = 256
+
+// liveWriteTimeout is max time of writing record to viewer before it is disconnected
+const liveWriteTimeout = 5 * time.Second
+
+// liveViewer is connected viewer of LiveStream
+type liveViewer struct {
+	conn  net.Conn
+	queue chan []byte
+}
+
+// run writes queued records until queue is closed or write fails (e.g. viewer disconnected)
+func (v *liveViewer) run(s *LiveStream) {
+	defer s.wg.Done()
+	defer func() {
+		_ = v.conn.Close()
+	}()
+	for line := range v.queue {
+		_ = v.conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+		if _, err := v.conn.Write(line); err != nil {
+			s.remove(v)
+			return
+		}
+	}
+}
+
+// LiveStream writes each record (JSON line, the same as json log) to all viewers connected to socket
+// while recording. Viewers may connect and disconnect at any time, and receive records written after connect.
+// Viewer whose queue is full (slow or stuck) is disconnected, so viewers never delay recording
+type LiveStream struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	viewers  map[*liveViewer]struct{} // guarded by mutex
+	closed   bool                     // guarded by mutex
+	wg       sync.WaitGroup           // accept loop and viewers
+}
+
+// ListenLiveStream listens on unix domain socket path (TCP address on Windows) for viewers
+func ListenLiveStream(address string) (*LiveStream, error) {
+	listener, err := listenStream(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen %s: %v", address, err)
+	}
+	s := &LiveStream{listener: listener, viewers: map[*liveViewer]struct{}{}}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns address of listening socket
+func (s *LiveStream) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *LiveStream) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue // e.g. viewer disconnected before accept
+		}
+		v := &liveViewer{conn: conn, queue: make(chan []byte, liveQueueSize)}
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.viewers[v] = struct{}{}
+		s.wg.Add(1)
+		s.mutex.Unlock()
+		go v.run(s)
+	}
+}
+
+// remove disconnects viewer (nop if already removed)
+func (s *LiveStream) remove(v *liveViewer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.viewers[v]; ok {
+		delete(s.viewers, v)
+		close(v.queue)
+	}
+}
+
+// Write sends record (single JSON line) to all viewers without blocking
+func (s *LiveStream) Write(buf []byte) (int, error) {
+	line := append([]byte(nil), buf...) // shared by viewers (read only)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for v := range s.viewers {
+		select {
+		case v.queue <- line:
+		default: // too slow
+			delete(s.viewers, v)
+			close(v.queue)
+			_ = v.conn.Close() // abort pending write
+		}
+	}
+	return len(buf), nil
+}
+
+// Close stops listening (socket file is removed), and disconnects viewers after queued records are written
+func (s *LiveStream) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	for v := range s.viewers {
+		delete(s.viewers, v)
+		close(v.queue)
+	}
+	s.mutex.Unlock()
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
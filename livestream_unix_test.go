@@ -0,0 +1,84 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLiveStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.sock")
+	stream, err := ListenLiveStream(path)
+	require.NoError(t, err)
+	logger := newLogger(stream, LogFormatJSON)
+	writeLogData(logger, &LogData{seq: 1, timestamp: teeTestTime, streamType: STDERR, payloadType: RAW,
+		payload: []byte("before connect")})
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	assert.Eventually(t, func() bool {
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		return len(stream.viewers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// disconnected viewer does not affect others
+	gone, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	_ = gone.Close()
+
+	for i := 2; i <= 3; i++ {
+		writeLogData(logger, &LogData{seq: i, timestamp: teeTestTime, streamType: STDERR, payloadType: RAW,
+			payload: []byte("line")})
+	}
+	require.NoError(t, stream.Close())
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	var seqs []int
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		d, err := decodeLogData(scanner.Bytes())
+		require.NoError(t, err)
+		seqs = append(seqs, d.seq)
+	}
+	assert.Equal(t, []int{2, 3}, seqs)
+}
+
+func TestLiveStreamSlowViewer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.sock")
+	stream, err := ListenLiveStream(path)
+	require.NoError(t, err)
+	conn, err := net.Dial("unix", path) // never reads
+	require.NoError(t, err)
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	assert.Eventually(t, func() bool {
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		return len(stream.viewers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	line := append(make([]byte, 64*1024), '\n')
+	start := time.Now()
+	for i := 0; i < 2*liveQueueSize; i++ {
+		_, err := stream.Write(line)
+		require.NoError(t, err)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+	stream.mutex.Lock()
+	assert.Empty(t, stream.viewers)
+	stream.mutex.Unlock()
+	require.NoError(t, stream.Close())
+}
@@ -0,0 +1,337 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sekiguchi-nagisa/lsp-recorder/codec"
+	"io"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+)
+
+// jsonLogRecord is schema of each line of log
+type jsonLogRecord struct {
+	Time   time.Time       `json:"time"`
+	Level  string          `json:"level"`
+	Seq    int             `json:"seq"`
+	Stream string          `json:"stream"`
+	Type   string          `json:"type"`
+	Method string          `json:"method,omitempty"`
+	Id     json.RawMessage `json:"id,omitempty"`
+	Size   int             `json:"size"` // size of message at capture (payload may be redacted or truncated)
+
+	Truncated    bool   `json:"truncated,omitempty"`
+	OriginalSize int    `json:"original_size,omitempty"` // size of payload before truncation
+	Synthetic    bool   `json:"synthetic,omitempty"`     // generated by recorder, not by client or server
+	Recorder     bool   `json:"recorder,omitempty"`      // message of recorder itself, not stderr of server
+	DeclaredSize int    `json:"declared_size,omitempty"` // Content-Length of incomplete (or non-JSON) message
+	Spill        string `json:"spill,omitempty"`         // path of file having whole payload
+	Source       string `json:"source,omitempty"`        // log file which record is merged from
+	Offset       int64  `json:"offset,omitempty"`        // offset of raw chunk in stream
+
+	ServerSession int  `json:"server_session,omitempty"` // lifetime of server restarted by recorder (see RunOptions.MaxRestarts)
+	Rotated       bool `json:"rotated,omitempty"`        // copy of META record repeated at top of log file (see LogOptions.RotateEvery)
+
+	Encoding string `json:"encoding,omitempty"` // encoding of payload which is not valid UTF-8 (see payloadEncodingBase64)
+	Payload  string `json:"payload"`
+
+	ElapsedNs   int64 `json:"elapsed_ns,omitempty"` // monotonic time since start of session
+	QueueNs     int64 `json:"queue_ns,omitempty"`
+	PrevWriteNs int64 `json:"prev_write_ns,omitempty"`
+
+	Checksum string `json:"crc32c,omitempty"` // CRC-32C of payload (see payloadChecksum)
+}
+
+func NewLogger(writer io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(writer, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.MessageKey {
+				return slog.Attr{} // always empty
+			}
+			return a
+		},
+	}))
+}
+
+// truncatePayload truncates payload longer than max bytes (at UTF-8 character boundary) and keeps its original size.
+// jsonrpc, id and method of JSON message remain with the start of params or result (see codec.Truncate), so that
+// truncated message is still parsed by ParseEnvelope
+func truncatePayload(d *LogData, max int) {
+	if max <= 0 || len(d.payload) <= max {
+		return
+	}
+	if d.originalSize == 0 { // already truncated if spilled
+		d.originalSize = len(d.payload)
+	}
+	if d.payloadType == JSON {
+		d.payload = codec.Truncate(d.payload, max)
+	} else {
+		d.payload = codec.Cut(d.payload, max)
+	}
+}
+
+// payloadEncodingBase64 is encoding of payload which is not valid UTF-8 (e.g. binary stderr). Such payload is
+// base64 encoded, since it cannot be stored in JSON (or text) log as is. Valid UTF-8 payload has no encoding
+const payloadEncodingBase64 = "base64"
+
+// decodePayload decodes payload of log by its encoding attribute (empty if not encoded)
+func decodePayload(payload string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(payload), nil
+	case payloadEncodingBase64:
+		return base64.StdEncoding.DecodeString(payload)
+	default:
+		return nil, fmt.Errorf("unknown payload encoding: %s", encoding)
+	}
+}
+
+// writeLogData writes LogData as record of its level (nothing if logger is not enabled at the level)
+func writeLogData(logger *slog.Logger, d *LogData) {
+	if !logger.Handler().Enabled(context.Background(), d.level) {
+		return
+	}
+	// collect attributes and add them at once to avoid growing attribute buffer of record
+	var buf [20]slog.Attr
+	attrs := append(buf[:0],
+		slog.Int("seq", d.seq),
+		slog.String("stream", d.streamType.String()),
+		slog.String("type", d.payloadType.String()),
+	)
+	if d.payloadType == JSON {
+		if e, err := ParseEnvelope(d.payload); err == nil {
+			if e.Method != "" {
+				attrs = append(attrs, slog.String("method", e.Method))
+			}
+			if e.Id != nil {
+				attrs = append(attrs, slog.Any("id", e.Id))
+			}
+		}
+	}
+	attrs = append(attrs, slog.Int("size", d.messageSize()))
+	if d.originalSize > 0 { // before payload, so that LogIndex reads them
+		attrs = append(attrs, slog.Bool("truncated", true), slog.Int("original_size", d.originalSize))
+	}
+	if d.synthetic {
+		attrs = append(attrs, slog.Bool("synthetic", true))
+	}
+	if d.recorder {
+		attrs = append(attrs, slog.Bool("recorder", true))
+	}
+	if d.declaredSize > 0 {
+		attrs = append(attrs, slog.Int("declared_size", d.declaredSize))
+	}
+	if d.spill != "" {
+		attrs = append(attrs, slog.String("spill", d.spill))
+	}
+	if d.source != "" {
+		attrs = append(attrs, slog.String("source", d.source))
+	}
+	if d.offset > 0 {
+		attrs = append(attrs, slog.Int64("offset", d.offset))
+	}
+	if d.serverSession > 0 { // before payload, so that LogIndex reads it
+		attrs = append(attrs, slog.Int("server_session", d.serverSession))
+	}
+	if d.rotated {
+		attrs = append(attrs, slog.Bool("rotated", true))
+	}
+	if utf8.Valid(d.payload) {
+		attrs = append(attrs, slog.String("payload", string(d.payload)))
+	} else { // exact bytes are restored by decodePayload
+		attrs = append(attrs, slog.String("encoding", payloadEncodingBase64),
+			slog.String("payload", base64.StdEncoding.EncodeToString(d.payload)))
+	}
+	if d.elapsed > 0 {
+		attrs = append(attrs, slog.Int64("elapsed_ns", int64(d.elapsed)))
+	}
+	if d.queueTime > 0 {
+		attrs = append(attrs, slog.Int64("queue_ns", int64(d.queueTime)))
+	}
+	if d.prevWriteTime > 0 {
+		attrs = append(attrs, slog.Int64("prev_write_ns", int64(d.prevWriteTime)))
+	}
+	if d.checksum != "" {
+		attrs = append(attrs, slog.String("crc32c", d.checksum))
+	}
+	r := slog.NewRecord(d.timestamp, d.level, "", d.pc)
+	r.AddAttrs(attrs...)
+	_ = logger.Handler().Handle(context.Background(), r)
+}
+
+// LogReader reads LogData from log
+type LogReader struct {
+	reader  *bufio.Reader
+	line    int
+	decode  func(line []byte) (*LogData, error) // nil until format is detected by first record
+	skip    func(err error)                     // if not nil, broken lines are reported to it and skipped
+	sniffed bool                                // compression of log is already detected
+	closer  io.Closer                           // log file opened by OpenLogReader
+}
+
+// NewLogReader creates LogReader. Compressed log (gzip or zstd) is detected by magic bytes and decompressed,
+// and format of log (JSON lines or text) is detected by first record
+func NewLogReader(reader io.Reader) *LogReader {
+	return &LogReader{reader: bufio.NewReader(reader)}
+}
+
+// sniff detects compression of log by its first bytes. Detection is deferred until log has any byte
+// (e.g. empty log file followed by print --follow)
+func (r *LogReader) sniff() error {
+	head, _ := r.reader.Peek(len(zstdMagic))
+	if len(head) == 0 {
+		return nil
+	}
+	r.sniffed = true
+	format := compressedFormat(head)
+	if format == "" {
+		return nil
+	}
+	decompressed, err := decompressLog(r.reader, format)
+	if err != nil {
+		return fmt.Errorf("cannot read %s log, caused by %s", format, err.Error())
+	}
+	r.reader = bufio.NewReader(decompressed)
+	return nil
+}
+
+// SkipErrors makes Next skip broken lines (e.g. truncated last line of interrupted recording) instead of
+// returning error. Error of each broken line is reported to warn
+func (r *LogReader) SkipErrors(warn func(err error)) {
+	r.skip = warn
+}
+
+// brokenLineSnippetBytes is max length of snippet of broken line in error message
+const brokenLineSnippetBytes = 64
+
+// brokenLineError returns error of broken line with its line number and snippet
+func brokenLineError(lineNum int, line []byte, err error) error {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) > brokenLineSnippetBytes {
+		return fmt.Errorf("broken log at line %d: %w: %q...", lineNum, err, line[:brokenLineSnippetBytes])
+	}
+	return fmt.Errorf("broken log at line %d: %w: %q", lineNum, err, line)
+}
+
+// detectLineFormat returns format of log (LogFormatJSON or LogFormatText) by its record line
+func detectLineFormat(line []byte) (string, error) {
+	line = bytes.TrimLeft(line, " \t\r")
+	switch {
+	case bytes.HasPrefix(line, []byte("{")):
+		return LogFormatJSON, nil
+	case bytes.HasPrefix(line, []byte(slog.TimeKey+"=")):
+		return LogFormatText, nil
+	default:
+		return "", errors.New("neither JSON lines nor text log record")
+	}
+}
+
+// lineDecoders are decoders of record line of each format
+var lineDecoders = map[string]func(line []byte) (*LogData, error){
+	LogFormatJSON: decodeLogData,
+	LogFormatText: decodeTextLogData,
+}
+
+// Next returns next LogData. Returns io.EOF at end of log. Last line without trailing newline is also decoded
+func (r *LogReader) Next() (*LogData, error) {
+	if !r.sniffed {
+		if err := r.sniff(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			if r.skip != nil && errors.Is(err, io.ErrUnexpectedEOF) { // e.g. gzip stream of killed recorder
+				r.skip(fmt.Errorf("log is truncated after line %d: %w", r.line, err))
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		r.line++
+		if len(line) == 1 && line[0] == '\n' {
+			continue // skip empty line
+		}
+		if r.decode == nil {
+			format, e := detectLineFormat(line)
+			if e != nil {
+				if r.skip != nil {
+					r.skip(brokenLineError(r.line, line, e))
+					continue // detect format by next line
+				}
+				return nil, brokenLineError(r.line, line, e)
+			}
+			r.decode = lineDecoders[format]
+		}
+		d, e := r.decode(line)
+		if e != nil {
+			if r.skip != nil {
+				r.skip(brokenLineError(r.line, line, e))
+				continue
+			}
+			return nil, brokenLineError(r.line, line, e)
+		}
+		return d, nil
+	}
+}
+
+// parseLogLevel parses level of record. Unknown level (e.g. written by other tools) is treated as info
+// instead of error, since level does not change meaning of record
+func parseLogLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+func decodeLogData(line []byte) (*LogData, error) {
+	var rec jsonLogRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil, err
+	}
+	if rec.Time.IsZero() {
+		return nil, errors.New("missing time")
+	}
+	streamType, err := parseStreamType(rec.Stream)
+	if err != nil {
+		return nil, err
+	}
+	payloadType, err := parsePayloadType(rec.Type)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decodePayload(rec.Payload, rec.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &LogData{
+		seq:         rec.Seq,
+		timestamp:   rec.Time,
+		streamType:  streamType,
+		payloadType: payloadType,
+		payload:     payload,
+
+		originalSize:  rec.OriginalSize,
+		synthetic:     rec.Synthetic,
+		recorder:      rec.Recorder,
+		declaredSize:  rec.DeclaredSize,
+		spill:         rec.Spill,
+		source:        rec.Source,
+		offset:        rec.Offset,
+		serverSession: rec.ServerSession,
+		rotated:       rec.Rotated,
+		size:          max(rec.Size, rec.OriginalSize), // size of old log is after truncation
+		elapsed:       time.Duration(rec.ElapsedNs),
+		queueTime:     time.Duration(rec.QueueNs),
+		prevWriteTime: time.Duration(rec.PrevWriteNs),
+		checksum:      rec.Checksum,
+		level:         parseLogLevel(rec.Level),
+	}, nil
+}
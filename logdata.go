@@ -0,0 +1,138 @@
+package recorder
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Accessors of LogData read from log (see LogReader), for analysis tools built on this package
+
+// Seq returns sequence number of record in log
+func (d *LogData) Seq() int {
+	return d.seq
+}
+
+// Time returns when message was fully parsed (or read) by recorder
+func (d *LogData) Time() time.Time {
+	return d.timestamp
+}
+
+// Elapsed returns monotonic time since start of session (0 if not recorded)
+func (d *LogData) Elapsed() time.Duration {
+	return d.elapsed
+}
+
+// Stream returns STDIN (sent by client), STDOUT (sent by server) or STDERR (stderr of server and records of
+// recorder itself)
+func (d *LogData) Stream() StreamType {
+	return d.streamType
+}
+
+// Type returns type of payload
+func (d *LogData) Type() PayloadType {
+	return d.payloadType
+}
+
+// Level returns level of record (info except debug records of recorder)
+func (d *LogData) Level() slog.Level {
+	return d.level
+}
+
+// Payload returns payload of record. It may be truncated (see Truncated) or redacted. Must not be modified
+func (d *LogData) Payload() []byte {
+	return d.payload
+}
+
+// Size returns size of message at capture. Falls back to size of payload for record of old log
+func (d *LogData) Size() int {
+	return d.messageSize()
+}
+
+// Truncated reports whether payload is truncated (whole payload may be in spill file)
+func (d *LogData) Truncated() bool {
+	return d.originalSize > 0
+}
+
+// Synthetic reports whether message is generated by recorder instead of client or server
+func (d *LogData) Synthetic() bool {
+	return d.synthetic
+}
+
+// ServerSession returns lifetime (1-based) of server restarted on exit (0 if not restarted)
+func (d *LogData) ServerSession() int {
+	return d.serverSession
+}
+
+// parsedEnvelope is result of ParseEnvelope cached by LogData.Envelope
+type parsedEnvelope struct {
+	envelope *Envelope
+	err      error
+}
+
+// Envelope returns JSON-RPC envelope of payload. It is parsed on first call and cached, so consumers of the same
+// record do not parse payload again. Returned envelope is shared and must not be modified
+func (d *LogData) Envelope() (*Envelope, error) {
+	if d.envelope == nil {
+		e, err := ParseEnvelope(d.payload)
+		d.envelope = &parsedEnvelope{envelope: e, err: err}
+	}
+	return d.envelope.envelope, d.envelope.err
+}
+
+// Method returns method of request or notification (empty if record is not JSON-RPC message or is response)
+func (d *LogData) Method() string {
+	if d.payloadType != JSON {
+		return ""
+	}
+	if e, err := d.Envelope(); err == nil {
+		return e.Method
+	}
+	return ""
+}
+
+// Id returns id of request or response (nil if record is not JSON-RPC message or is notification)
+func (d *LogData) Id() json.RawMessage {
+	if d.payloadType != JSON {
+		return nil
+	}
+	if e, err := d.Envelope(); err == nil {
+		return e.Id
+	}
+	return nil
+}
+
+// OpenLogReader opens log file (see OpenLog) and creates LogReader of it. LogReader must be closed
+func OpenLogReader(name string) (*LogReader, error) {
+	file, err := OpenLog(name)
+	if err != nil {
+		return nil, err
+	}
+	r := NewLogReader(file)
+	r.closer = file
+	return r, nil
+}
+
+// Close closes log file opened by OpenLogReader (nothing if LogReader is created by NewLogReader)
+func (r *LogReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// ReadAll reads all remaining records
+func (r *LogReader) ReadAll() ([]*LogData, error) {
+	var records []*LogData
+	for {
+		d, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, d)
+	}
+}
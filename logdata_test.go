@@ -0,0 +1,82 @@
+package recorder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var logDataTestLog = newTestLog(
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`), size: 1024},
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("server log")},
+)
+
+func TestLogDataAccessors(t *testing.T) {
+	records, err := NewLogReader(strings.NewReader(logDataTestLog)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	request := records[0]
+	assert.Equal(t, 1, request.Seq())
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), request.Time().UTC())
+	assert.Equal(t, STDIN, request.Stream())
+	assert.Equal(t, JSON, request.Type())
+	assert.Equal(t, "initialize", request.Method())
+	assert.Equal(t, json.RawMessage("1"), request.Id())
+	e, err := request.Envelope()
+	require.NoError(t, err)
+	cached, _ := request.Envelope()
+	assert.Same(t, e, cached) // parsed once
+
+	response := records[1]
+	assert.Empty(t, response.Method())
+	assert.Equal(t, json.RawMessage("1"), response.Id())
+	assert.Equal(t, 1024, response.Size())
+
+	raw := records[2]
+	assert.Equal(t, "server log", string(raw.Payload()))
+	assert.Equal(t, len("server log"), raw.Size())
+	assert.Empty(t, raw.Method())
+	assert.Nil(t, raw.Id())
+	_, err = raw.Envelope()
+	assert.Error(t, err)
+}
+
+func gzipLog(t *testing.T, log string) []byte {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(log))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestLogReaderCompressed(t *testing.T) {
+	records, err := NewLogReader(bytes.NewReader(gzipLog(t, logDataTestLog))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "initialize", records[0].Method())
+
+	path := filepath.Join(t.TempDir(), "session.log.gz")
+	require.NoError(t, os.WriteFile(path, gzipLog(t, logDataTestLog), 0o666))
+	reader, err := OpenLogReader(path)
+	require.NoError(t, err)
+	records, err = reader.ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.NoError(t, reader.Close())
+
+	records, err = NewLogReader(strings.NewReader("")).ReadAll()
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+	_, err = NewLogReader(bytes.NewReader([]byte{0x1f, 0x8b, 0})).Next()
+	assert.ErrorContains(t, err, "cannot read json-gzip log")
+}
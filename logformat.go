@@ -0,0 +1,427 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// formats of log
+const (
+	LogFormatText     = "text"      // output of slog.TextHandler (key=value pairs)
+	LogFormatJSON     = "json"      // output of slog.JSONHandler (JSON lines)
+	LogFormatJSONGzip = "json-gzip" // gzip compressed JSON lines
+	LogFormatJSONZstd = "json-zstd" // zstd compressed JSON lines
+)
+
+// replaceLogAttr drops empty message of records. In text format, time keeps nanoseconds
+// (slog.TextHandler formats time in milliseconds) and id is written as JSON
+func replaceLogAttr(text bool) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) > 0 {
+			return a
+		}
+		switch {
+		case a.Key == slog.MessageKey:
+			return slog.Attr{} // always empty
+		case text && a.Key == slog.TimeKey:
+			return slog.String(a.Key, a.Value.Time().Format(time.RFC3339Nano))
+		case text && a.Key == "id":
+			if id, ok := a.Value.Any().(json.RawMessage); ok {
+				return slog.String(a.Key, string(id))
+			}
+		}
+		return a
+	}
+}
+
+// DefaultFlushInterval is default of LogOptions.FlushInterval
+const DefaultFlushInterval = 2 * time.Second
+
+// gzipLogWriter serializes writes, flushes and close of gzip stream. If interval is set, written records are
+// flushed within interval, so that file is decodable up to the last flush even if stream is never closed
+// (e.g. recorder is killed)
+type gzipLogWriter struct {
+	mutex    sync.Mutex
+	writer   *gzip.Writer
+	interval time.Duration // max delay of flush after write (not flushed until close if 0)
+	timer    *time.Timer   // nil if no flush is scheduled
+}
+
+func (w *gzipLogWriter) Write(buf []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	n, err := w.writer.Write(buf)
+	if err == nil && w.interval > 0 && w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.flush)
+	}
+	return n, err
+}
+
+// flush writes pending compressed data. Error is kept by gzip.Writer and returned by next write or close
+func (w *gzipLogWriter) flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.timer = nil
+	_ = w.writer.Flush() // nop after close
+}
+
+func (w *gzipLogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	return w.writer.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// compressLog returns writer compressing log if format is compressed one (see NewFormatLogger for level).
+// Close of returned writer finishes compressed stream, but does not close underlying writer
+func compressLog(writer io.Writer, format string, level int) (io.WriteCloser, error) {
+	switch format {
+	case LogFormatText, LogFormatJSON:
+		return nopWriteCloser{writer}, nil
+	case LogFormatJSONGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gz, err := gzip.NewWriterLevel(writer, level)
+		if err != nil {
+			return nil, fmt.Errorf("compression level of gzip must be 1-9: %d", level)
+		}
+		return &gzipLogWriter{writer: gz}, nil
+	case LogFormatJSONZstd:
+		return newZstdWriter(writer, level)
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+}
+
+// CheckLogFormat checks format and its compression level, so that invalid one is reported before log file
+// is created (or truncated)
+func CheckLogFormat(format string, level int) error {
+	w, err := compressLog(io.Discard, format, level)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// newLogger creates logger writing records in format to writer (records are not compressed)
+func newLogger(writer io.Writer, format string) *slog.Logger {
+	return newOptionsLogger(writer, format, nil)
+}
+
+// newOptionsLogger is newLogger whose handler has level, AddSource and ReplaceAttr of options (may be nil).
+// ReplaceAttr of options is applied after that of recorder, so that records are still readable by LogReader
+// unless it breaks them
+func newOptionsLogger(writer io.Writer, format string, options *slog.HandlerOptions) *slog.Logger {
+	replace := replaceLogAttr(format == LogFormatText)
+	handlerOptions := &slog.HandlerOptions{ReplaceAttr: replace}
+	if options != nil {
+		handlerOptions.Level, handlerOptions.AddSource = options.Level, options.AddSource
+		if custom := options.ReplaceAttr; custom != nil {
+			handlerOptions.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+				if a = replace(groups, a); a.Key == "" {
+					return a // dropped
+				}
+				return custom(groups, a)
+			}
+		}
+	}
+	if format == LogFormatText {
+		return slog.New(slog.NewTextHandler(writer, handlerOptions))
+	}
+	return slog.New(slog.NewJSONHandler(writer, handlerOptions))
+}
+
+// NewFormatLogger creates logger writing records in format. level is compression level of
+// json-gzip (1-9) or json-zstd (1-19), 0 means default of format. Returned closer must be called after
+// the last record is written (compressed stream is finished). Underlying writer is not closed
+func NewFormatLogger(writer io.Writer, format string, level int) (*slog.Logger, io.Closer, error) {
+	w, err := compressLog(writer, format, level)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newLogger(w, format), w, nil
+}
+
+// decompressLog returns reader of decompressed log if format is compressed one
+func decompressLog(reader io.Reader, format string) (io.Reader, error) {
+	switch format {
+	case LogFormatJSONGzip:
+		return gzip.NewReader(reader)
+	case LogFormatJSONZstd:
+		return newZstdReader(reader)
+	default:
+		return reader, nil
+	}
+}
+
+type logFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (f *logFile) Close() error {
+	if closer, ok := f.Reader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	return f.file.Close()
+}
+
+// OpenLog opens log file. Compressed log (gzip or zstd) is detected by magic bytes and decompressed.
+// Uncompressed log is returned as *os.File, so it is also io.ReaderAt
+func OpenLog(name string) (io.ReadCloser, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(zstdMagic))
+	n, _ := file.ReadAt(head, 0) // offset of file is not changed
+	format := compressedFormat(head[:n])
+	if format == "" {
+		return file, nil
+	}
+	reader, err := decompressLog(file, format)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("cannot read %s log: %s, caused by %s", format, name, err.Error())
+	}
+	return &logFile{Reader: reader, file: file}, nil
+}
+
+// logStream is log read from stream (see OpenLogStream)
+type logStream struct {
+	io.Reader
+}
+
+func (s *logStream) Close() error {
+	if closer, ok := s.Reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// OpenLogStream opens log read from stream like stdin. Since there is no file name to inspect,
+// compressed log (gzip or zstd) is detected by magic bytes and decompressed. Underlying reader is not closed
+func OpenLogStream(reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	head, _ := buffered.Peek(len(zstdMagic))
+	format := compressedFormat(head)
+	if format == "" {
+		return &logStream{Reader: buffered}, nil
+	}
+	decompressed, err := decompressLog(buffered, format)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s log, caused by %s", format, err.Error())
+	}
+	return &logStream{Reader: decompressed}, nil
+}
+
+// NewFormatLogReader creates LogReader reading log of format
+func NewFormatLogReader(reader io.Reader, format string) (*LogReader, error) {
+	switch format {
+	case LogFormatText:
+		r := NewLogReader(reader)
+		r.decode = decodeTextLogData
+		return r, nil
+	case LogFormatJSON:
+		r := NewLogReader(reader)
+		r.decode = decodeLogData
+		return r, nil
+	case LogFormatJSONGzip, LogFormatJSONZstd:
+		decompressed, err := decompressLog(reader, format)
+		if err != nil {
+			return nil, err
+		}
+		r := NewLogReader(decompressed)
+		r.decode = decodeLogData
+		return r, nil
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressedFormat returns json-gzip or json-zstd if head of file starts with magic bytes of them
+// (empty string if not compressed)
+func compressedFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return LogFormatJSONGzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return LogFormatJSONZstd
+	default:
+		return ""
+	}
+}
+
+// DetectLogFormat guesses format of log by its first bytes regardless of file name
+// (magic bytes of gzip or zstd, otherwise json if first non-space byte is '{', or text)
+func DetectLogFormat(reader *bufio.Reader) string {
+	head, _ := reader.Peek(len(zstdMagic))
+	if format := compressedFormat(head); format != "" {
+		return format
+	}
+	for i := 1; ; i++ {
+		head, _ := reader.Peek(i)
+		if len(head) < i {
+			return LogFormatJSON // empty
+		}
+		switch head[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return LogFormatJSON
+		default:
+			return LogFormatText
+		}
+	}
+}
+
+// parseTextLogLine parses key=value pairs of line written by slog.TextHandler
+func parseTextLogLine(line string) (map[string]string, error) {
+	attrs := map[string]string{}
+	line = strings.TrimRight(line, "\r\n")
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \"") {
+			return nil, fmt.Errorf("invalid attribute: '%s'", line)
+		}
+		value := rest
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value of %s", key)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+			rest = " " + rest
+		}
+		attrs[key] = value
+		if rest != "" && rest[0] != ' ' {
+			return nil, fmt.Errorf("missing space after value of %s", key)
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+	return attrs, nil
+}
+
+func decodeTextLogData(line []byte) (*LogData, error) {
+	attrs, err := parseTextLogLine(string(line))
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, attrs[slog.TimeKey])
+	if err != nil {
+		return nil, errors.New("missing time")
+	}
+	streamType, err := parseStreamType(attrs["stream"])
+	if err != nil {
+		return nil, err
+	}
+	payloadType, err := parsePayloadType(attrs["type"])
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decodePayload(attrs["payload"], attrs["encoding"])
+	if err != nil {
+		return nil, err
+	}
+	d := &LogData{timestamp: timestamp, streamType: streamType, payloadType: payloadType, payload: payload,
+		level: parseLogLevel(attrs[slog.LevelKey])}
+	if v, ok := attrs["seq"]; ok {
+		if d.seq, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid seq: %s", v)
+		}
+	}
+	if v, ok := attrs["original_size"]; ok {
+		if d.originalSize, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid original_size: %s", v)
+		}
+	}
+	d.synthetic = attrs["synthetic"] == "true"
+	d.recorder = attrs["recorder"] == "true"
+	d.rotated = attrs["rotated"] == "true"
+	d.spill = attrs["spill"]
+	d.source = attrs["source"]
+	d.checksum = attrs["crc32c"]
+	if v, ok := attrs["size"]; ok {
+		if d.size, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid size: %s", v)
+		}
+	}
+	d.size = max(d.size, d.originalSize) // size of old log is after truncation
+	if v, ok := attrs["offset"]; ok {
+		if d.offset, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid offset: %s", v)
+		}
+	}
+	if v, ok := attrs["server_session"]; ok {
+		if d.serverSession, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid server_session: %s", v)
+		}
+	}
+	if v, ok := attrs["declared_size"]; ok {
+		if d.declaredSize, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid declared_size: %s", v)
+		}
+	}
+	for key, dst := range map[string]*time.Duration{"elapsed_ns": &d.elapsed, "queue_ns": &d.queueTime,
+		"prev_write_ns": &d.prevWriteTime} {
+		if v, ok := attrs[key]; ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", key, v)
+			}
+			*dst = time.Duration(n)
+		}
+	}
+	return d, nil
+}
+
+// ConvertLog reads all records and writes them in format (see NewFormatLogger for level)
+func ConvertLog(reader *LogReader, writer io.Writer, format string, level int) error {
+	logger, closer, err := NewFormatLogger(writer, format, level)
+	if err != nil {
+		return err
+	}
+	for {
+		d, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			_ = closer.Close()
+			return err
+		}
+		writeLogData(logger, d)
+	}
+	return closer.Close()
+}
@@ -0,0 +1,223 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var formatTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server [--opt \"a b\"]\nx=y")},
+	LogData{timestamp: time.Date(2024, 5, 1, 10, 0, 1, 123456789, time.UTC), streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":"a b","method":"initialize","params":{"text":"\"q\"\nあ"}}`), queueTime: 1500},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`), prevWriteTime: 42,
+		elapsed: 2 * time.Second},
+	LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte("")},
+	LogData{streamType: STDOUT, payloadType: RAW_END, payload: []byte("end of stream")},
+)
+
+func convertLog(t *testing.T, log string, from string, to string) string {
+	reader, err := NewFormatLogReader(strings.NewReader(log), from)
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	require.NoError(t, ConvertLog(reader, &out, to, 0))
+	return out.String()
+}
+
+func TestConvertLogRoundTrip(t *testing.T) {
+	text := convertLog(t, formatTestLog, LogFormatJSON, LogFormatText)
+	assert.Contains(t, text, `time=2024-05-01T10:00:01.123456789Z level=INFO seq=2 stream=stdin type=json method=initialize id="\"a b\""`)
+	assert.Equal(t, formatTestLog, convertLog(t, text, LogFormatText, LogFormatJSON))
+
+	gz := convertLog(t, text, LogFormatText, LogFormatJSONGzip)
+	assert.Equal(t, formatTestLog, convertLog(t, gz, LogFormatJSONGzip, LogFormatJSON))
+	assert.Equal(t, text, convertLog(t, gz, LogFormatJSONGzip, LogFormatText))
+}
+
+func TestLogBinaryPayload(t *testing.T) {
+	binary := []byte("\xff\xfe\x00text \xe3\x81") // invalid UTF-8 (last character is cut)
+	json := []byte("{\"jsonrpc\":\"2.0\",\"method\":\"x\",\"params\":{\"text\":\"\xc0\"}}")
+	for _, format := range []string{LogFormatJSON, LogFormatText} {
+		buf := bytes.Buffer{}
+		logger := newLogger(&buf, format)
+		now := time.Now()
+		for _, d := range []LogData{
+			{timestamp: now, streamType: STDERR, payloadType: RAW, payload: binary},
+			{timestamp: now, streamType: STDOUT, payloadType: JSON, payload: json},
+			{timestamp: now, streamType: STDOUT, payloadType: JSON, payload: []byte(`{"text":"あ"}`)},
+		} {
+			writeLogData(logger, &d)
+		}
+		lines := strings.Split(buf.String(), "\n")
+		assert.Contains(t, lines[0], "encoding", format)
+		assert.NotContains(t, lines[2], "encoding", format) // valid UTF-8 is stored as is
+		assert.Contains(t, lines[2], "あ", format)
+
+		reader, err := NewFormatLogReader(&buf, format)
+		require.NoError(t, err)
+		for _, expected := range [][]byte{binary, json, []byte(`{"text":"あ"}`)} {
+			d, err := reader.Next()
+			require.NoError(t, err, format)
+			assert.Equal(t, expected, d.payload, format)
+		}
+	}
+	_, err := decodeLogData([]byte(`{"time":"2024-05-01T10:00:00Z","stream":"stdout","type":"raw","encoding":"hex","payload":"00"}`))
+	assert.EqualError(t, err, "unknown payload encoding: hex")
+	_, err = decodeLogData([]byte(`{"time":"2024-05-01T10:00:00Z","stream":"stdout","type":"raw","encoding":"base64","payload":"!"}`))
+	assert.Error(t, err)
+}
+
+func TestLogLevel(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	debug := LogData{timestamp: now, streamType: STDERR, payloadType: RAW, payload: []byte("debug: stdin: read 2 bytes"),
+		level: slog.LevelDebug}
+	info := LogData{timestamp: now, streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")}
+	for _, format := range []string{LogFormatJSON, LogFormatText} {
+		buf := bytes.Buffer{}
+		writeLogData(newLogger(&buf, format), &debug) // info level by default
+		assert.Empty(t, buf.String(), format)
+
+		logger := newOptionsLogger(&buf, format, &slog.HandlerOptions{Level: slog.LevelDebug,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == "seq" {
+					return slog.Attr{}
+				}
+				return a
+			}})
+		writeLogData(logger, &debug)
+		writeLogData(logger, &info)
+		assert.NotContains(t, buf.String(), "seq", format)
+		assert.NotContains(t, buf.String(), "msg", format) // dropped by recorder before custom ReplaceAttr
+
+		reader, err := NewFormatLogReader(&buf, format)
+		require.NoError(t, err)
+		d, err := reader.Next()
+		require.NoError(t, err, format)
+		assert.Equal(t, slog.LevelDebug, d.level, format)
+		d, err = reader.Next()
+		require.NoError(t, err, format)
+		assert.Equal(t, slog.LevelInfo, d.level, format)
+	}
+
+	// unknown level is not error
+	d, err := decodeLogData([]byte(`{"time":"2024-05-01T10:00:00Z","level":"TRACE","stream":"stderr","type":"raw","payload":"a"}`))
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelInfo, d.level)
+	d, err = decodeTextLogData([]byte(`time=2024-05-01T10:00:00Z level=DEBUG-4 stream=stderr type=raw payload=a`))
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelDebug-4, d.level)
+}
+
+func TestLogAddSource(t *testing.T) {
+	buf := bytes.Buffer{}
+	ch := make(chan LogData, 1)
+	sendDebug(ch, "header parser %s -> %s", INITIAL, IN_HEADER)
+	d := <-ch
+	writeLogData(newOptionsLogger(&buf, LogFormatJSON, &slog.HandlerOptions{Level: slog.LevelDebug, AddSource: true}), &d)
+	assert.Contains(t, buf.String(), `"payload":"debug: header parser INITIAL -> IN_HEADER"`)
+	assert.Contains(t, buf.String(), "logformat_test.go") // location of sendDebug
+}
+
+func TestDecodeTextLogData(t *testing.T) {
+	d, err := decodeTextLogData([]byte(`time=2024-05-01T10:00:00Z level=INFO seq=3 stream=stderr type=raw size=5 payload="a\x00b\n" queue_ns=7` + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, d.seq)
+	assert.Equal(t, STDERR, d.streamType)
+	assert.Equal(t, RAW, d.payloadType)
+	assert.Equal(t, "a\x00b\n", string(d.payload))
+	assert.Equal(t, time.Duration(7), d.queueTime)
+
+	for _, line := range []string{
+		`level=INFO stream=stdin type=raw payload=x`,
+		`time=2024-05-01T10:00:00Z stream=stdin type=raw payload="x`,
+		`time=2024-05-01T10:00:00Z stream=stdin type=raw payload="x"y`,
+		`time=2024-05-01T10:00:00Z stream=stdin type=raw seq=x`,
+		`time=2024-05-01T10:00:00Z stream=hoge type=raw`,
+		`{"time":"2024-05-01T10:00:00Z"}`,
+	} {
+		_, err := decodeTextLogData([]byte(line))
+		assert.Error(t, err, line)
+	}
+}
+
+func TestDetectLogFormat(t *testing.T) {
+	detect := func(content string) string {
+		return DetectLogFormat(bufio.NewReader(strings.NewReader(content)))
+	}
+	assert.Equal(t, LogFormatJSON, detect("\n{\"time\":\"\"}"))
+	assert.Equal(t, LogFormatText, detect("time=2024-05-01T10:00:00Z"))
+	assert.Equal(t, LogFormatJSON, detect(""))
+	assert.Equal(t, LogFormatText, detect("\x1f"))
+	assert.Equal(t, LogFormatJSONGzip, detect(convertLog(t, formatTestLog, LogFormatJSON, LogFormatJSONGzip)))
+	assert.Equal(t, LogFormatJSONZstd, detect("\x28\xb5\x2f\xfd"))
+}
+
+func TestOpenLog(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "a.log")
+	require.NoError(t, os.WriteFile(plain, []byte(formatTestLog), 0o644))
+	gz := filepath.Join(dir, "a.log.gz")
+	require.NoError(t, os.WriteFile(gz, []byte(convertLog(t, formatTestLog, LogFormatJSON, LogFormatJSONGzip)), 0o644))
+
+	for _, name := range []string{plain, gz} {
+		reader, err := OpenLog(name)
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, formatTestLog, string(data))
+		require.NoError(t, reader.Close())
+	}
+	reader, err := OpenLog(plain)
+	require.NoError(t, err)
+	assert.Implements(t, (*io.ReaderAt)(nil), reader) // index reads payload by offset
+	_ = reader.Close()
+
+	_, err = OpenLog(filepath.Join(dir, "none.log"))
+	assert.Error(t, err)
+	broken := filepath.Join(dir, "b.log")
+	require.NoError(t, os.WriteFile(broken, []byte{0x1f, 0x8b, 0}, 0o644))
+	_, err = OpenLog(broken)
+	assert.ErrorContains(t, err, "cannot read json-gzip log")
+}
+
+func TestOpenLogStream(t *testing.T) {
+	for _, log := range []string{formatTestLog, convertLog(t, formatTestLog, LogFormatJSON, LogFormatJSONGzip), ""} {
+		reader, err := OpenLogStream(io.MultiReader(strings.NewReader(log))) // not io.ReaderAt like pipe
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		if log == "" {
+			assert.Empty(t, data)
+		} else {
+			assert.Equal(t, formatTestLog, string(data))
+		}
+		require.NoError(t, reader.Close())
+	}
+	_, err := OpenLogStream(bytes.NewReader([]byte{0x1f, 0x8b, 0}))
+	assert.ErrorContains(t, err, "cannot read json-gzip log")
+}
+
+func TestOpenLogMisnamed(t *testing.T) {
+	dir := t.TempDir()
+	gz := filepath.Join(dir, "session.log") // gzip without .gz suffix
+	require.NoError(t, os.WriteFile(gz, []byte(convertLog(t, formatTestLog, LogFormatJSON, LogFormatJSONGzip)), 0o644))
+	plain := filepath.Join(dir, "renamed.log.gz") // plain log with .gz suffix
+	require.NoError(t, os.WriteFile(plain, []byte(formatTestLog), 0o644))
+
+	for _, name := range []string{gz, plain} {
+		reader, err := OpenLog(name)
+		require.NoError(t, err)
+		out := bytes.Buffer{}
+		require.NoError(t, Print(reader, &out, &PrintFilter{}), name)
+		assert.Contains(t, out.String(), "<stdin>", name)
+		_ = reader.Close()
+	}
+}
@@ -0,0 +1,57 @@
+package recorder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExpandLogPath expands placeholders of log path like /tmp/lsp-%Y%m%d-%H%M%S-%p.log:
+// %Y, %m, %d, %H, %M and %S (local time t), %p (pid of recorder), %b (base name of Language Server
+// executable without extension, "server" if bin is empty) and %% (% itself)
+func ExpandLogPath(path string, t time.Time, pid int, bin string) (string, error) {
+	if !strings.Contains(path, "%") {
+		return path, nil
+	}
+	sb := strings.Builder{}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' {
+			sb.WriteByte(path[i])
+			continue
+		}
+		if i+1 == len(path) {
+			return "", fmt.Errorf("incomplete placeholder at end of log path: %s", path)
+		}
+		i++
+		switch c := path[i]; c {
+		case 'Y':
+			sb.WriteString(fmt.Sprintf("%04d", t.Year()))
+		case 'm':
+			sb.WriteString(fmt.Sprintf("%02d", int(t.Month())))
+		case 'd':
+			sb.WriteString(fmt.Sprintf("%02d", t.Day()))
+		case 'H':
+			sb.WriteString(fmt.Sprintf("%02d", t.Hour()))
+		case 'M':
+			sb.WriteString(fmt.Sprintf("%02d", t.Minute()))
+		case 'S':
+			sb.WriteString(fmt.Sprintf("%02d", t.Second()))
+		case 'p':
+			sb.WriteString(strconv.Itoa(pid))
+		case 'b':
+			name := "server"
+			if bin != "" {
+				base := filepath.Base(bin)
+				name = unsafeFileNameChars.ReplaceAllString(strings.TrimSuffix(base, filepath.Ext(base)), "_")
+			}
+			sb.WriteString(name)
+		case '%':
+			sb.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unknown placeholder %%%c in log path (%%Y, %%m, %%d, %%H, %%M, %%S, %%p, %%b or %%%%): %s", c, path)
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,31 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestExpandLogPath(t *testing.T) {
+	now := time.Date(2024, 5, 1, 9, 8, 7, 0, time.Local)
+	path, err := ExpandLogPath("/tmp/lsp-%Y%m%d-%H%M%S-%p.log", now, 4321, "/usr/bin/gopls")
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/lsp-20240501-090807-4321.log", path)
+
+	path, err = ExpandLogPath("logs/%b/100%%.log", now, 1, "/opt/bin/clangd.exe")
+	require.NoError(t, err)
+	assert.Equal(t, "logs/clangd/100%.log", path)
+	path, err = ExpandLogPath("%b.log", now, 1, "")
+	require.NoError(t, err)
+	assert.Equal(t, "server.log", path)
+
+	path, err = ExpandLogPath("./lsp-recorder.log", now, 1, "gopls")
+	require.NoError(t, err)
+	assert.Equal(t, "./lsp-recorder.log", path)
+
+	_, err = ExpandLogPath("lsp-%x.log", now, 1, "gopls")
+	assert.EqualError(t, err, "unknown placeholder %x in log path (%Y, %m, %d, %H, %M, %S, %p, %b or %%): lsp-%x.log")
+	_, err = ExpandLogPath("lsp-%", now, 1, "gopls")
+	assert.EqualError(t, err, "incomplete placeholder at end of log path: lsp-%")
+}
@@ -0,0 +1,58 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// log paths of streams instead of files
+const (
+	stderrLogPath = "-"   // stderr of recorder
+	fdLogPrefix   = "fd:" // inherited file descriptor like fd:3 (handle on Windows)
+)
+
+// IsLogStream reports whether log path is stderr ("-") or inherited file descriptor ("fd:3")
+func IsLogStream(path string) bool {
+	return path == stderrLogPath || strings.HasPrefix(path, fdLogPrefix)
+}
+
+// stderrLogWriter writes records to stderr shared with stderr pass-through of Language Server.
+// Each record is written by single write at start of line, so that recorded lines are still valid
+// standalone lines even if interleaved with partial lines of server
+type stderrLogWriter struct {
+	writer io.Writer
+}
+
+func (w stderrLogWriter) Write(buf []byte) (int, error) {
+	if s, ok := w.writer.(*syncWriter); ok {
+		return s.WriteLine(buf)
+	}
+	return w.writer.Write(buf)
+}
+
+// openLogStream returns writer and closer of "-" or "fd:N". Stderr is not closed, but inherited
+// descriptor is closed by closer (reader gets EOF). Compressed log cannot be written to stderr, since
+// its stream would be broken by stderr of Language Server
+func openLogStream(path string, format string) (io.Writer, io.Closer, error) {
+	if path == stderrLogPath || path == fdLogPrefix+"2" {
+		if format != LogFormatText && format != LogFormatJSON {
+			return nil, nil, fmt.Errorf("%s log cannot be written to stderr, use fd:N instead", format)
+		}
+		return stderrLogWriter{writer: StderrWriter}, nopWriteCloser{}, nil
+	}
+	fd, err := strconv.Atoi(strings.TrimPrefix(path, fdLogPrefix))
+	if err != nil || fd < 0 {
+		return nil, nil, fmt.Errorf("invalid file descriptor of log: %s", path)
+	}
+	if fd < 2 {
+		return nil, nil, fmt.Errorf("log cannot be written to %s, since it is used by stdio", path)
+	}
+	file := os.NewFile(uintptr(fd), path)
+	if _, err := file.Stat(); err != nil {
+		return nil, nil, fmt.Errorf("cannot open log file: %s, caused by %s", path, err.Error())
+	}
+	return file, file, nil
+}
@@ -0,0 +1,59 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSyncWriterWriteLine(t *testing.T) {
+	out := bytes.Buffer{}
+	w := newSyncWriter(&out)
+	_, _ = w.Write([]byte("server: partial"))
+	n, err := w.WriteLine([]byte("record1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 8, n)
+	_, _ = w.WriteLine([]byte("record2\n"))
+	_, _ = w.Write([]byte(" line\n"))
+	_, _ = w.WriteLine([]byte("record3\n"))
+	assert.Equal(t, "server: partial\nrecord1\nrecord2\n line\nrecord3\n", out.String())
+}
+
+func TestLogToStderr(t *testing.T) {
+	out := bytes.Buffer{}
+	defer func(w io.Writer) {
+		StderrWriter = w
+	}(StderrWriter)
+	StderrWriter = newSyncWriter(&out)
+
+	logger, closer, err := CreateLog("-", LogOptions{Format: LogFormatJSON})
+	require.NoError(t, err)
+	_, _ = StderrWriter.Write([]byte("server log without newline"))
+	writeLogData(logger, &LogData{seq: 1, timestamp: teeTestTime, streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"initialized"}`)})
+	require.NoError(t, closer.Close())
+	lines := strings.Split(out.String(), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "server log without newline", lines[0])
+	d, err := decodeLogData([]byte(lines[1]))
+	require.NoError(t, err)
+	assert.Equal(t, 1, d.seq)
+	_, err = StderrWriter.Write([]byte("after close\n")) // stderr is not closed
+	assert.NoError(t, err)
+}
+
+func TestLogStreamErrors(t *testing.T) {
+	_, _, err := CreateLog("-", LogOptions{Format: LogFormatJSONGzip})
+	assert.EqualError(t, err, "json-gzip log cannot be written to stderr, use fd:N instead")
+	_, _, err = CreateLog("-", LogOptions{Format: LogFormatJSON, MaxSize: 1024})
+	assert.EqualError(t, err, "log to - cannot be rotated")
+	_, _, err = CreateLog("fd:x", LogOptions{Format: LogFormatJSON})
+	assert.EqualError(t, err, "invalid file descriptor of log: fd:x")
+	_, _, err = CreateLog("fd:1", LogOptions{Format: LogFormatJSON})
+	assert.EqualError(t, err, "log cannot be written to fd:1, since it is used by stdio")
+	_, _, err = CreateLog("fd:1000", LogOptions{Format: LogFormatJSON})
+	assert.ErrorContains(t, err, "cannot open log file: fd:1000")
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestLogToFd(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer func(r *os.File) {
+		_ = r.Close()
+	}(r)
+	fd, err := syscall.Dup(int(w.Fd())) // inherited descriptor is closed by log
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	logger, closer, err := CreateLog("fd:"+strconv.Itoa(fd), LogOptions{Format: LogFormatJSONGzip})
+	require.NoError(t, err)
+	done := make(chan []byte)
+	go func() {
+		content, _ := io.ReadAll(r) // until descriptor is closed
+		done <- content
+	}()
+	for i := 0; i < 3; i++ {
+		writeLogData(logger, &LogData{seq: i + 1, timestamp: teeTestTime, streamType: STDERR, payloadType: RAW,
+			payload: []byte("line")})
+	}
+	require.NoError(t, closer.Close())
+	reader, err := OpenLogStream(bytes.NewReader(<-done))
+	require.NoError(t, err)
+	records := readAllLogData(t, reader)
+	require.Len(t, records, 3)
+	assert.Equal(t, 3, records[2].seq)
+}
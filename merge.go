@@ -0,0 +1,161 @@
+package recorder
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MergeInput is log merged by MergeLogs. Name is recorded as source of its records
+type MergeInput struct {
+	Name   string
+	Reader *LogReader
+}
+
+// mergeCursor is input being merged and its next record
+type mergeCursor struct {
+	MergeInput
+	order int      // position in inputs (breaks ties)
+	next  *LogData // nil at end of input
+
+	count          int
+	first, last    time.Time
+	minSeq, maxSeq int
+}
+
+func (c *mergeCursor) advance() error {
+	d, err := c.Reader.Next()
+	if errors.Is(err, io.EOF) {
+		c.next = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Name, err)
+	}
+	if d.source == "" { // keep source of already merged log
+		d.source = c.Name
+	}
+	if c.count == 0 || d.timestamp.Before(c.first) {
+		c.first = d.timestamp
+	}
+	if c.count == 0 || d.timestamp.After(c.last) {
+		c.last = d.timestamp
+	}
+	if d.seq > 0 {
+		if c.minSeq == 0 || d.seq < c.minSeq {
+			c.minSeq = d.seq
+		}
+		c.maxSeq = max(c.maxSeq, d.seq)
+	}
+	c.count++
+	c.next = d
+	return nil
+}
+
+// mergeHeap orders cursors by timestamp of next record, then by seq, then by position in inputs
+type mergeHeap []*mergeCursor
+
+func (h mergeHeap) Len() int {
+	return len(h)
+}
+
+func (h mergeHeap) Less(i, j int) bool {
+	a, b := h[i].next, h[j].next
+	if !a.timestamp.Equal(b.timestamp) {
+		return a.timestamp.Before(b.timestamp)
+	}
+	if a.seq > 0 && b.seq > 0 && a.seq != b.seq {
+		return a.seq < b.seq
+	}
+	return h[i].order < h[j].order
+}
+
+func (h mergeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *mergeHeap) Push(x any) {
+	*h = append(*h, x.(*mergeCursor))
+}
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// MergeLogs interleaves records of inputs in order of timestamp and writes them in format
+// (see NewFormatLogger for level). Inputs are streamed, so each of them must be in chronological order
+// like recorded log. Records are tagged with name of input and renumbered from 1, so that merged log is
+// readable by print and stats. Possible clock skew between inputs is reported to warn (if not nil)
+func MergeLogs(inputs []MergeInput, writer io.Writer, format string, level int, warn func(err error)) error {
+	logger, closer, err := NewFormatLogger(writer, format, level)
+	if err != nil {
+		return err
+	}
+	cursors := make([]*mergeCursor, len(inputs))
+	h := make(mergeHeap, 0, len(inputs))
+	for i, input := range inputs {
+		cursors[i] = &mergeCursor{MergeInput: input, order: i}
+		if err := cursors[i].advance(); err != nil {
+			_ = closer.Close()
+			return err
+		}
+		if cursors[i].next != nil {
+			h = append(h, cursors[i])
+		}
+	}
+	heap.Init(&h)
+	seq := 0
+	for h.Len() > 0 {
+		c := h[0]
+		seq++
+		c.next.seq = seq
+		c.next.checksum = "" // digest in trailer does not match interleaved records
+		writeLogData(logger, c.next)
+		if err := c.advance(); err != nil {
+			_ = closer.Close()
+			return err
+		}
+		if c.next == nil {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	if err := closer.Close(); err != nil {
+		return err
+	}
+	if warn != nil {
+		for _, err := range detectClockSkew(cursors) {
+			warn(err)
+		}
+	}
+	return nil
+}
+
+// detectClockSkew reports pairs of inputs whose records are wholly before those of the other although
+// their seqs overlap (e.g. two recorders of the same session on machines with different clocks)
+func detectClockSkew(cursors []*mergeCursor) []error {
+	var errs []error
+	for i, a := range cursors {
+		for _, b := range cursors[i+1:] {
+			if a.count == 0 || b.count == 0 || a.minSeq == 0 || b.minSeq == 0 ||
+				a.maxSeq < b.minSeq || b.maxSeq < a.minSeq {
+				continue
+			}
+			before, after := a, b
+			if b.last.Before(a.first) {
+				before, after = b, a
+			} else if !a.last.Before(b.first) {
+				continue // overlapping
+			}
+			errs = append(errs, fmt.Errorf("possible clock skew: all records of %s are %v before those of %s "+
+				"although their seqs overlap", before.Name, after.first.Sub(before.last), after.Name))
+		}
+	}
+	return errs
+}
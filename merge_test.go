@@ -0,0 +1,85 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeLogs(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	client := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`), timestamp: base},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`), timestamp: base.Add(3 * time.Second)},
+	)
+	server := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`), timestamp: base.Add(time.Second)},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("loading"), timestamp: base.Add(2 * time.Second)},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("ready"), timestamp: base.Add(3 * time.Second)},
+	)
+	textServer := bytes.Buffer{}
+	require.NoError(t, ConvertLog(NewLogReader(strings.NewReader(server)), &textServer, LogFormatText, 0))
+
+	out := bytes.Buffer{}
+	var warnings []error
+	require.NoError(t, MergeLogs([]MergeInput{
+		{Name: "client.log", Reader: NewLogReader(strings.NewReader(client))},
+		{Name: "server.log", Reader: NewLogReader(&textServer)},
+	}, &out, LogFormatJSON, 0, func(err error) {
+		warnings = append(warnings, err)
+	}))
+	assert.Empty(t, warnings)
+
+	reader := NewLogReader(strings.NewReader(out.String()))
+	var merged []string
+	for {
+		d, err := reader.Next()
+		if err != nil {
+			break
+		}
+		merged = append(merged, fmt.Sprintf("%d %s %s %s", d.seq, d.timestamp.Format(time.TimeOnly), d.source, d.streamType))
+	}
+	assert.Equal(t, []string{
+		"1 10:00:00 client.log stdin",
+		"2 10:00:01 server.log stdin",
+		"3 10:00:02 server.log stderr",
+		"4 10:00:03 client.log stdout", // seq 2 of client.log precedes seq 3 of server.log at the same time
+		"5 10:00:03 server.log stderr",
+	}, merged)
+
+	printed := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(out.String()), &printed, &PrintFilter{Streams: []StreamType{STDERR}}))
+	assert.Equal(t, "2024-05-01T10:00:02Z <stderr> (from server.log) loading\n"+
+		"2024-05-01T10:00:03Z <stderr> (from server.log) ready\n", printed.String())
+
+	// requests of different sources having the same id are neither duplicated nor paired with each other
+	printed.Reset()
+	require.NoError(t, Print(strings.NewReader(out.String()), &printed, &PrintFilter{Output: PrintOutputCompact,
+		Streams: []StreamType{STDIN, STDOUT}}))
+	assert.Equal(t, "2024-05-01T10:00:00Z --> request initialize id=1 46B\n"+
+		"2024-05-01T10:00:01Z --> request initialize id=1 46B\n"+
+		"2024-05-01T10:00:03Z <-- response initialize id=1 36B 3s\n", printed.String()) // paired in client.log
+}
+
+func TestMergeLogsClockSkew(t *testing.T) {
+	early := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("a")},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("b")},
+	)
+	late := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("c"), timestamp: time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+	)
+	var warnings []string
+	require.NoError(t, MergeLogs([]MergeInput{
+		{Name: "late.log", Reader: NewLogReader(strings.NewReader(late))},
+		{Name: "early.log", Reader: NewLogReader(strings.NewReader(early))},
+	}, &bytes.Buffer{}, LogFormatJSON, 0, func(err error) {
+		warnings = append(warnings, err.Error())
+	}))
+	assert.Equal(t, []string{"possible clock skew: all records of early.log are 59m59s before those of late.log " +
+		"although their seqs overlap"}, warnings)
+}
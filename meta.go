@@ -0,0 +1,217 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionMeta is metadata of recording session (payload of META record).
+// It is recorded after Language Server is started, so that tools need not parse free-text records
+type SessionMeta struct {
+	Version  string    `json:"version"`        // version of recorder
+	Bin      string    `json:"bin,omitempty"`  // resolved path of Language Server executable (empty if not spawned)
+	Args     []string  `json:"args,omitempty"` // arguments of Language Server
+	Cwd      string    `json:"cwd,omitempty"`  // working directory of Language Server
+	Pid      int       `json:"pid,omitempty"`  // pid of Language Server
+	Hostname string    `json:"hostname,omitempty"`
+	Os       string    `json:"os"`
+	Arch     string    `json:"arch"`
+	Start    time.Time `json:"start"`          // start time of recording
+	Logs     []string  `json:"logs,omitempty"` // absolute paths of logs written by recorder
+
+	Client     *PeerInfo `json:"client,omitempty"`     // clientInfo of initialize request
+	Server     *PeerInfo `json:"server,omitempty"`     // serverInfo of initialize response
+	Supplement bool      `json:"supplement,omitempty"` // supplemental record having only Client and Server
+}
+
+// PeerInfo is name and version of client or server exchanged by initialize handshake
+type PeerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+func (p *PeerInfo) String() string {
+	if p == nil {
+		return "?"
+	}
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + " " + p.Version
+}
+
+// newSessionMeta creates SessionMeta of this host. Server fields are filled by caller
+func newSessionMeta(start time.Time) *SessionMeta {
+	hostname, _ := os.Hostname()
+	return &SessionMeta{Version: GetVersion(), Hostname: hostname, Os: runtime.GOOS, Arch: runtime.GOARCH,
+		Start: start}
+}
+
+func ParseSessionMeta(payload []byte) (*SessionMeta, error) {
+	m := &SessionMeta{}
+	if err := json.Unmarshal(payload, m); err != nil {
+		return nil, fmt.Errorf("broken session metadata: %v", err)
+	}
+	return m, nil
+}
+
+// mergeSessionMeta merges payload of META record into meta and returns it. Record of the first session is taken if meta
+// is nil, and peers of supplemental record replace ones of meta (initialize after restart of server wins)
+func mergeSessionMeta(meta *SessionMeta, payload []byte) *SessionMeta {
+	m, err := ParseSessionMeta(payload)
+	if err != nil {
+		return meta
+	}
+	if meta == nil {
+		return m
+	}
+	if m.Supplement {
+		if m.Client != nil {
+			meta.Client = m.Client
+		}
+		if m.Server != nil {
+			meta.Server = m.Server
+		}
+	}
+	return meta
+}
+
+// Peers returns client and server like "VS Code 1.89 ↔ gopls v0.15.2" (empty if neither is known)
+func (m *SessionMeta) Peers() string {
+	if m.Client == nil && m.Server == nil {
+		return ""
+	}
+	return m.Client.String() + " ↔ " + m.Server.String()
+}
+
+// Command returns command line of Language Server (arguments having space are quoted)
+func (m *SessionMeta) Command() string {
+	if m.Bin == "" {
+		return ""
+	}
+	words := []string{m.Bin}
+	for _, arg := range m.Args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			arg = strconv.Quote(arg)
+		}
+		words = append(words, arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// Format writes metadata as header block (each line is prefixed with indent)
+func (m *SessionMeta) Format(writer io.Writer, indent string) {
+	field := func(name string, value string) {
+		if value != "" {
+			_, _ = fmt.Fprintf(writer, "%s%-9s %s\n", indent, name+":", value)
+		}
+	}
+	field("recorder", m.Version)
+	field("command", m.Command())
+	field("cwd", m.Cwd)
+	if m.Pid > 0 {
+		field("pid", strconv.Itoa(m.Pid))
+	}
+	if m.Os != "" { // not supplemental record
+		field("host", fmt.Sprintf("%s (%s/%s)", m.Hostname, m.Os, m.Arch))
+	}
+	if !m.Start.IsZero() {
+		field("start", m.Start.Format(time.RFC3339Nano))
+	}
+	field("logs", strings.Join(m.Logs, ", "))
+	field("peers", m.Peers())
+}
+
+// peerSniffer picks clientInfo and serverInfo from initialize handshake passing through recorder
+type peerSniffer struct {
+	initializeId string    // id of initialize request waiting for response (empty if none)
+	client       *PeerInfo // clientInfo of the pending initialize request
+}
+
+// observe returns supplemental META record when response to initialize request arrives (nil if neither clientInfo nor
+// serverInfo is found). Another initialize (e.g. after restart of server) replaces the pending one
+func (s *peerSniffer) observe(d *LogData) *LogData {
+	if d.payloadType != JSON {
+		return nil
+	}
+	for _, d := range expandBatch(d) {
+		e, err := d.Envelope()
+		if err != nil {
+			continue
+		}
+		switch {
+		case e.IsRequest() && e.Method == "initialize" && d.streamType == STDIN:
+			var m struct {
+				Params struct {
+					ClientInfo *PeerInfo `json:"clientInfo"`
+				} `json:"params"`
+			}
+			_ = json.Unmarshal(d.payload, &m)
+			s.initializeId, s.client = idKey(e.Id), m.Params.ClientInfo
+		case e.IsResponse() && d.streamType == STDOUT && s.initializeId != "" && idKey(e.Id) == s.initializeId:
+			var m struct {
+				Result struct {
+					ServerInfo *PeerInfo `json:"serverInfo"`
+				} `json:"result"`
+			}
+			_ = json.Unmarshal(d.payload, &m)
+			s.initializeId = ""
+			return s.supplement(d.timestamp, m.Result.ServerInfo)
+		}
+	}
+	return nil
+}
+
+// end returns supplemental META record having only clientInfo if initialize is not completed until end of session
+func (s *peerSniffer) end(at time.Time) *LogData {
+	if s.initializeId == "" {
+		return nil
+	}
+	s.initializeId = ""
+	return s.supplement(at, nil)
+}
+
+func (s *peerSniffer) supplement(at time.Time, server *PeerInfo) *LogData {
+	client := s.client
+	s.client = nil
+	if client == nil && server == nil {
+		return nil
+	}
+	payload, _ := json.Marshal(struct { // without fields of host
+		Client     *PeerInfo `json:"client,omitempty"`
+		Server     *PeerInfo `json:"server,omitempty"`
+		Supplement bool      `json:"supplement"`
+	}{client, server, true})
+	return &LogData{timestamp: at, streamType: STDERR, payloadType: META, payload: payload}
+}
+
+// Version is version of binary, set by main package before parsing CLI (see GetVersion)
+var Version = ""
+
+// GetVersion returns version of binary (module version or Version) and its VCS revision
+func GetVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		rev := "unknown"
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				rev = setting.Value
+				break
+			}
+		}
+		var v = info.Main.Version
+		if Version != "" {
+			v = Version
+		}
+		return fmt.Sprintf("%s (%s)", v, rev)
+	} else {
+		return "(unknown)"
+	}
+}
@@ -0,0 +1,173 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var metaTestPayload = []byte(`{"version":"v1.0.0","bin":"/usr/bin/gopls","args":["serve","-rpc.trace","a b"],"cwd":"/home/u/proj","pid":4321,"hostname":"devbox","os":"linux","arch":"amd64","start":"2024-05-01T10:00:00Z"}`)
+
+func TestSessionMetaFormat(t *testing.T) {
+	meta, err := ParseSessionMeta(metaTestPayload)
+	require.NoError(t, err)
+	assert.Equal(t, `/usr/bin/gopls serve -rpc.trace "a b"`, meta.Command())
+	out := bytes.Buffer{}
+	meta.Format(&out, "  ")
+	assert.Equal(t, `  recorder: v1.0.0
+  command:  /usr/bin/gopls serve -rpc.trace "a b"
+  cwd:      /home/u/proj
+  pid:      4321
+  host:     devbox (linux/amd64)
+  start:    2024-05-01T10:00:00Z
+`, out.String())
+
+	// not spawned (e.g. --connect)
+	meta = &SessionMeta{Version: "v1.0.0", Hostname: "devbox", Os: "linux", Arch: "amd64",
+		Logs: []string{"/tmp/a.log", "/tmp/b.log"}}
+	assert.Equal(t, "", meta.Command())
+	out.Reset()
+	meta.Format(&out, "")
+	assert.Equal(t, "recorder: v1.0.0\nhost:     devbox (linux/amd64)\nlogs:     /tmp/a.log, /tmp/b.log\n", out.String())
+
+	_, err = ParseSessionMeta([]byte(`{"version":`))
+	assert.Error(t, err)
+}
+
+var metaTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: gopls [serve]")},
+	LogData{streamType: STDERR, payloadType: META, payload: metaTestPayload},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+)
+
+func TestPrintSessionMeta(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(metaTestLog), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(), `2024-05-01T10:00:01Z <stderr> (session metadata)
+  recorder: v1.0.0
+  command:  /usr/bin/gopls serve -rpc.trace "a b"
+`)
+
+	// broken metadata is printed as is
+	log := newTestLog(LogData{streamType: STDERR, payloadType: META, payload: []byte(`{"version":`)})
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Equal(t, "2024-05-01T10:00:00Z <stderr> {\"version\":\n", out.String())
+}
+
+func TestStatsSessionMeta(t *testing.T) {
+	stats, err := CollectMessageStats(strings.NewReader(metaTestLog))
+	require.NoError(t, err)
+	require.NotNil(t, stats.Meta)
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.True(t, strings.HasPrefix(out.String(), "session:\n  recorder: v1.0.0\n"), out.String())
+
+	stats, err = CollectMessageStats(strings.NewReader(printTestLog)) // log without metadata
+	require.NoError(t, err)
+	out.Reset()
+	stats.Format(&out)
+	assert.True(t, strings.HasPrefix(out.String(), "requests:\n"), out.String())
+}
+
+func TestExportSessionMeta(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, ExportTrace(strings.NewReader(metaTestLog), &out))
+	var file struct {
+		OtherData *SessionMeta `json:"otherData"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &file))
+	require.NotNil(t, file.OtherData)
+	assert.Equal(t, 4321, file.OtherData.Pid)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), file.OtherData.Start)
+	assert.Contains(t, out.String(), `"name":"lsp-recorder (gopls)"`)
+
+	out.Reset()
+	require.NoError(t, ExportHTML(strings.NewReader(metaTestLog), &out))
+	assert.Contains(t, out.String(), `<td>/usr/bin/gopls serve -rpc.trace &#34;a b&#34;</td>`)
+	assert.Contains(t, out.String(), `<tr><th>host</th><td>devbox (linux/amd64)</td></tr>`)
+}
+
+func TestPeerSniffer(t *testing.T) {
+	initialize := func(id int, params string) *LogData {
+		return &LogData{streamType: STDIN, payloadType: JSON,
+			payload: []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"initialize","params":%s}`, id, params))}
+	}
+	response := func(id int, result string) *LogData {
+		return &LogData{streamType: STDOUT, payloadType: JSON,
+			payload: []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":%s}`, id, result))}
+	}
+	parse := func(d *LogData) *SessionMeta {
+		require.NotNil(t, d)
+		assert.Equal(t, META, d.payloadType)
+		meta, err := ParseSessionMeta(d.payload)
+		require.NoError(t, err)
+		assert.True(t, meta.Supplement)
+		return meta
+	}
+
+	s := &peerSniffer{}
+	assert.Nil(t, s.observe(initialize(1, `{"clientInfo":{"name":"VS Code","version":"1.89"}}`)))
+	assert.Nil(t, s.observe(response(2, `{"serverInfo":{"name":"other"}}`))) // not response to initialize
+	meta := parse(s.observe(response(1, `{"serverInfo":{"name":"gopls","version":"v0.15.2"}}`)))
+	assert.Equal(t, "VS Code 1.89 ↔ gopls v0.15.2", meta.Peers())
+	assert.Nil(t, s.end(time.Now()))
+
+	// fields are absent
+	assert.Nil(t, s.observe(initialize(1, `{}`)))
+	assert.Nil(t, s.observe(response(1, `{}`)))
+	assert.Nil(t, s.observe(initialize(2, `{}`)))
+	assert.Equal(t, "? ↔ gopls", parse(s.observe(response(2, `{"serverInfo":{"name":"gopls"}}`))).Peers())
+
+	// second initialize after restart of server replaces pending one, and the latest one is not completed
+	assert.Nil(t, s.observe(initialize(1, `{"clientInfo":{"name":"vim"}}`)))
+	assert.Nil(t, s.observe(initialize(7, `{"clientInfo":{"name":"neovim","version":"0.10"}}`)))
+	assert.Nil(t, s.observe(response(1, `{"serverInfo":{"name":"gopls"}}`)))
+	assert.Equal(t, "neovim 0.10 ↔ ?", parse(s.end(time.Now())).Peers())
+	assert.Nil(t, s.end(time.Now()))
+}
+
+func TestMergeSessionMeta(t *testing.T) {
+	meta := mergeSessionMeta(nil, metaTestPayload)
+	require.NotNil(t, meta)
+	assert.Equal(t, meta, mergeSessionMeta(meta, []byte(`{"version":`)))
+	meta = mergeSessionMeta(meta, []byte(`{"client":{"name":"vim"},"server":{"name":"gopls","version":"v0.15.2"},"supplement":true}`))
+	meta = mergeSessionMeta(meta, []byte(`{"client":{"name":"neovim"},"supplement":true}`))
+	meta = mergeSessionMeta(meta, []byte(`{"version":"v2.0.0","os":"linux","arch":"amd64"}`)) // appended session
+	assert.Equal(t, "v1.0.0", meta.Version)
+	assert.Equal(t, "neovim ↔ gopls v0.15.2", meta.Peers())
+
+	out := bytes.Buffer{}
+	(&SessionMeta{Client: &PeerInfo{Name: "neovim"}, Supplement: true}).Format(&out, "")
+	assert.Equal(t, "peers:    neovim ↔ ?\n", out.String())
+
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: META, payload: metaTestPayload},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+		LogData{streamType: STDERR, payloadType: META,
+			payload: []byte(`{"client":{"name":"VS Code","version":"1.89"},"server":{"name":"gopls","version":"v0.15.2"},"supplement":true}`)},
+	)
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	out.Reset()
+	stats.Format(&out)
+	assert.Contains(t, out.String(), "  peers:    VS Code 1.89 ↔ gopls v0.15.2\n")
+
+	report, err := CollectCapabilityUsage(strings.NewReader(log))
+	require.NoError(t, err)
+	out.Reset()
+	report.Format(&out)
+	assert.True(t, strings.HasPrefix(out.String(), "session:\n  recorder: v1.0.0\n"), out.String())
+	assert.Contains(t, out.String(), "  peers:    VS Code 1.89 ↔ gopls v0.15.2\n\nwarning:")
+
+	out.Reset()
+	require.NoError(t, ExportTrace(strings.NewReader(log), &out))
+	assert.Contains(t, out.String(), `"client":{"name":"VS Code","version":"1.89"}`)
+}
@@ -0,0 +1,86 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// mirrorPrefix is prefix of each mirrored line, so they are told apart from stderr of Language Server
+const mirrorPrefix = "[lsp-recorder] "
+
+// Mirror prints recorded records in the same format as print subcommand while recording
+type Mirror struct {
+	writer  io.Writer
+	filter  *PrintFilter
+	tracker *RequestTracker
+}
+
+// NewMirror creates Mirror writing matched records to writer. Each record is written by single write,
+// so writer shared with stderr pass-through (like StderrWriter) does not interleave them
+func NewMirror(writer io.Writer, filter *PrintFilter) *Mirror {
+	return &Mirror{writer: writer, filter: filter, tracker: NewRequestTracker()}
+}
+
+// ParseMirrorFilter parses filters like method=textDocument/*, exclude-method=$/progress or stream=stdin,stdout.
+// Each filter is combined by AND, and the same key may be repeated
+func ParseMirrorFilter(values []string) (*PrintFilter, error) {
+	filter := &PrintFilter{MatchResponses: true}
+	for _, value := range values {
+		key, v, ok := strings.Cut(value, "=")
+		if !ok || v == "" {
+			return nil, fmt.Errorf("mirror filter must be KEY=VALUE: '%s'", value)
+		}
+		switch key {
+		case "method":
+			filter.Methods = append(filter.Methods, v)
+		case "exclude-method":
+			filter.ExcludeMethods = append(filter.ExcludeMethods, v)
+		case "stream":
+			streams, err := ParseStreamTypes([]string{v})
+			if err != nil {
+				return nil, err
+			}
+			filter.Streams = append(filter.Streams, streams...)
+		default:
+			return nil, fmt.Errorf("unknown mirror filter: %s (must be method, exclude-method or stream)", key)
+		}
+	}
+	return filter, nil
+}
+
+// Write prints record if it matches filter. Elements of JSON-RPC batch are printed as separate messages
+func (m *Mirror) Write(d *LogData) {
+	for _, d := range expandBatch(d) {
+		m.write(d)
+	}
+}
+
+func (m *Mirror) write(d *LogData) {
+	var e *Envelope
+	var p pairing
+	switch d.payloadType {
+	case JSON:
+		if parsed, err := ParseEnvelope(d.payload); err == nil {
+			e = parsed
+			p = m.tracker.pair(d, e)
+		}
+	case SESSION_START:
+		p.note = sessionStartNote
+	}
+	if !m.filter.match(d, e, p.method) {
+		return
+	}
+	buf := bytes.Buffer{}
+	formatLogData(&buf, d, d.timestamp.Format(time.RFC3339Nano), p.note)
+	out := bytes.Buffer{}
+	out.Grow(buf.Len() + 64)
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		out.WriteString(mirrorPrefix)
+		out.WriteString(line)
+	}
+	out.WriteByte('\n')
+	_, _ = m.writer.Write(out.Bytes())
+}
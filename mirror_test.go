@@ -0,0 +1,65 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	out := bytes.Buffer{}
+	mirror := NewMirror(&out, &PrintFilter{})
+	mirror.Write(&LogData{timestamp: base, streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)})
+	mirror.Write(&LogData{timestamp: base.Add(5 * time.Millisecond), streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)})
+	mirror.Write(&LogData{timestamp: base, streamType: STDERR, payloadType: RAW, payload: []byte("hello\nworld")})
+	assert.Equal(t, `[lsp-recorder] 2024-05-01T10:00:00Z <stdin>
+[lsp-recorder] {
+[lsp-recorder]   "jsonrpc": "2.0",
+[lsp-recorder]   "id": 1,
+[lsp-recorder]   "method": "initialize"
+[lsp-recorder] }
+[lsp-recorder] 2024-05-01T10:00:00.005Z <stdout> (response to initialize id=1, 5ms)
+[lsp-recorder] {
+[lsp-recorder]   "jsonrpc": "2.0",
+[lsp-recorder]   "id": 1,
+[lsp-recorder]   "result": {}
+[lsp-recorder] }
+[lsp-recorder] 2024-05-01T10:00:00Z <stderr> hello
+[lsp-recorder] world
+`, out.String())
+}
+
+func TestMirrorFilter(t *testing.T) {
+	filter, err := ParseMirrorFilter([]string{"method=textDocument/*", "exclude-method=textDocument/didChange", "stream=stdin,stdout"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"textDocument/*"}, filter.Methods)
+	assert.Equal(t, []string{"textDocument/didChange"}, filter.ExcludeMethods)
+	assert.Equal(t, []StreamType{STDIN, STDOUT}, filter.Streams)
+
+	out := bytes.Buffer{}
+	mirror := NewMirror(&out, filter)
+	for _, payload := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didChange"}`,
+		`{"jsonrpc":"2.0","method":"initialized"}`,
+	} {
+		mirror.Write(&LogData{timestamp: time.Now(), streamType: STDIN, payloadType: JSON, payload: []byte(payload)})
+	}
+	mirror.Write(&LogData{timestamp: time.Now(), streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)}) // matched by method of request
+	mirror.Write(&LogData{timestamp: time.Now(), streamType: STDERR, payloadType: RAW, payload: []byte("log")})
+	assert.Equal(t, 2, strings.Count(out.String(), "<std"))
+	assert.Contains(t, out.String(), "textDocument/hover")
+	assert.Contains(t, out.String(), "(response to textDocument/hover id=1")
+
+	for _, value := range []string{"method", "method=", "id=1", "stream=foo"} {
+		_, err := ParseMirrorFilter([]string{value})
+		assert.Error(t, err, value)
+	}
+}
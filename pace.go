@@ -0,0 +1,69 @@
+package recorder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReplaySpeed is scale factor of original inter-message delay (0 means as fast as possible)
+type ReplaySpeed float64
+
+func ParseReplaySpeed(s string) (ReplaySpeed, error) {
+	if s == "max" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("speed must be positive number or 'max': %s", s)
+	}
+	return ReplaySpeed(v), nil
+}
+
+// Scale returns delay adjusted by speed (e.g. speed 2 halves delay)
+func (s ReplaySpeed) Scale(d time.Duration) time.Duration {
+	if s == 0 || d <= 0 {
+		return 0
+	}
+	return time.Duration(float64(d) / float64(s))
+}
+
+// Stepper pauses before each message until user presses Enter (or inputs count of messages to advance)
+type Stepper struct {
+	reader *bufio.Reader
+	writer io.Writer
+	remain int // number of messages to be advanced without pause
+}
+
+func NewStepper(reader io.Reader, writer io.Writer) *Stepper {
+	return &Stepper{reader: bufio.NewReader(reader), writer: writer}
+}
+
+// Wait prints summary of the next message and waits for user input.
+// Returns io.EOF if input is closed
+func (s *Stepper) Wait(summary string) error {
+	if s.remain > 0 {
+		s.remain--
+		return nil
+	}
+	for {
+		_, _ = fmt.Fprintf(s.writer, "%s\n[Enter/count] > ", summary)
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(line)
+		if err == nil && n > 0 {
+			s.remain = n - 1
+			return nil
+		}
+		_, _ = fmt.Fprintf(s.writer, "invalid count: %s\n", line)
+	}
+}
@@ -0,0 +1,42 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplaySpeed(t *testing.T) {
+	s, err := ParseReplaySpeed("0.25")
+	assert.NoError(t, err)
+	assert.Equal(t, 4*time.Second, s.Scale(time.Second))
+
+	s, err = ParseReplaySpeed("2")
+	assert.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, s.Scale(time.Second))
+
+	s, err = ParseReplaySpeed("max")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), s.Scale(time.Second))
+
+	_, err = ParseReplaySpeed("0")
+	assert.Error(t, err)
+	_, err = ParseReplaySpeed("fast")
+	assert.Error(t, err)
+}
+
+func TestStepper(t *testing.T) {
+	out := bytes.Buffer{}
+	s := NewStepper(strings.NewReader("\nhoge\n3\n"), &out)
+	assert.NoError(t, s.Wait("msg1"))
+	assert.NoError(t, s.Wait("msg2")) // skip invalid count
+	assert.NoError(t, s.Wait("msg3"))
+	assert.NoError(t, s.Wait("msg4"))
+	assert.ErrorIs(t, s.Wait("msg5"), io.EOF)
+	assert.Contains(t, out.String(), "invalid count: hoge")
+	assert.NotContains(t, out.String(), "msg3")
+	assert.NotContains(t, out.String(), "msg4")
+}
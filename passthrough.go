@@ -0,0 +1,154 @@
+package recorder
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// writeFull writes whole buf unless hard error occurs. Interrupted (EINTR) and short writes are retried,
+// since partially delivered message permanently desynchronizes the peer
+func writeFull(writer io.Writer, buf []byte) (int, error) {
+	written := 0
+	for written < len(buf) {
+		n, err := writer.Write(buf[written:])
+		written += n
+		switch {
+		case err == nil && n == 0:
+			return written, io.ErrShortWrite // no progress
+		case err == nil, errors.Is(err, syscall.EINTR), errors.Is(err, io.ErrShortWrite) && n > 0:
+			continue
+		default:
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// syncWriter serializes writes to the same destination so that messages from concurrent goroutines
+// are never interleaved. Each write is done by writeFull
+type syncWriter struct {
+	mutex   sync.Mutex
+	writer  io.Writer
+	partial bool // last write did not end with newline
+}
+
+func newSyncWriter(writer io.Writer) *syncWriter {
+	return &syncWriter{writer: writer}
+}
+
+// NewSyncWriter creates writer serializing concurrent writes to writer (e.g. stdout of mock server)
+func NewSyncWriter(writer io.Writer) io.Writer {
+	return newSyncWriter(writer)
+}
+
+func (w *syncWriter) Write(buf []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	n, err := writeFull(w.writer, buf)
+	if n > 0 {
+		w.partial = buf[n-1] != '\n'
+	}
+	return n, err
+}
+
+// WriteLine writes buf at start of line. If the last write ended in the middle of line (e.g. server wrote
+// partial line to stderr), newline is inserted before buf in the same write
+func (w *syncWriter) WriteLine(buf []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.partial {
+		n, err := writeFull(w.writer, buf)
+		if n > 0 {
+			w.partial = buf[n-1] != '\n'
+		}
+		return n, err
+	}
+	n, err := writeFull(w.writer, append([]byte{'\n'}, buf...))
+	if n > 0 {
+		w.partial = n > 1 && buf[n-2] != '\n'
+	}
+	return max(n-1, 0), err
+}
+
+// StderrWriter is shared by stderr pass-through and diagnostics of recorder. Messages of CLI are also written to it,
+// so that they are not interleaved with stderr of Language Server
+var StderrWriter io.Writer = newSyncWriter(os.Stderr)
+
+// stoppableReader reads underlying reader in background, so that Read can be stopped by Stop even if
+// underlying Read blocks (e.g. stdin kept open by client). Read returns io.EOF after Stop
+type stoppableReader struct {
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	source io.Reader
+	done   chan struct{} // closed when background goroutine finished
+}
+
+func newStoppableReader(reader io.Reader) *stoppableReader {
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := io.Copy(w, reader)
+		_ = w.CloseWithError(err) // io.EOF if err is nil
+	}()
+	return &stoppableReader{reader: r, writer: w, source: reader, done: done}
+}
+
+func (r *stoppableReader) Read(buf []byte) (int, error) {
+	n, err := r.reader.Read(buf)
+	if errors.Is(err, io.ErrClosedPipe) { // stopped
+		err = io.EOF
+	}
+	return n, err
+}
+
+// interrupt unblocks Read of underlying reader by read deadline of file (file is owned by caller, like stdin),
+// or by closing it. Returns false if it cannot be interrupted (e.g. stdin of terminal not supporting deadline)
+func (r *stoppableReader) interrupt() bool {
+	switch source := r.source.(type) {
+	case *os.File:
+		return source.SetReadDeadline(time.Now()) == nil
+	case io.Closer:
+		_ = source.Close()
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop makes pending and later Read return io.EOF (data not read yet is discarded), and waits for background
+// goroutine if underlying reader can be interrupted. Otherwise, the goroutine remains blocked until next data or
+// end of underlying reader
+func (r *stoppableReader) Stop() {
+	_ = r.writer.Close()
+	_ = r.reader.Close()
+	if r.interrupt() {
+		<-r.done
+		if file, ok := r.source.(*os.File); ok {
+			_ = file.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+// detachableWriter discards data after Detach (e.g. client disconnected), so that pass-through to
+// detached destination neither fails nor blocks
+type detachableWriter struct {
+	writer   io.Writer
+	detached atomic.Bool
+}
+
+func (w *detachableWriter) Write(buf []byte) (int, error) {
+	if w.detached.Load() {
+		return len(buf), nil
+	}
+	return w.writer.Write(buf)
+}
+
+func (w *detachableWriter) Detach() {
+	w.detached.Store(true)
+}
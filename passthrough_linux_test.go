@@ -0,0 +1,62 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const fSetPipeSize = 1031 // F_SETPIPE_SZ
+
+// TestInterceptTinyPipe passes messages through a pipe having the smallest buffer to a slow reader
+// and checks that the reader never sees a broken frame
+func TestInterceptTinyPipe(t *testing.T) {
+	pipeReader, pipeWriter, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() {
+		_ = pipeReader.Close()
+	}()
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, pipeWriter.Fd(), fSetPipeSize, 4096)
+	require.Zero(t, errno)
+
+	const count = 50
+	source, sink := io.Pipe()
+	go func() {
+		for i := 0; i < count; i++ {
+			_, _ = sink.Write([]byte(fakeMessage(1000 + i*37)))
+		}
+		_ = sink.Close()
+	}()
+	ch := make(chan LogData, count+1)
+	go func() {
+		_ = intercept(context.Background(), STDOUT, source, pipeWriter, ch, RunOptions{})
+		_ = pipeWriter.Close()
+	}()
+
+	slow := readerFunc(func(p []byte) (int, error) {
+		time.Sleep(100 * time.Microsecond)
+		return pipeReader.Read(p[:min(len(p), 97)])
+	})
+	received := 0
+	require.NoError(t, readFrames(slow, func(payload []byte) error {
+		e, err := ParseEnvelope(payload)
+		if assert.NoError(t, err, fmt.Sprintf("message %d", received)) {
+			assert.Equal(t, "textDocument/didChange", e.Method)
+		}
+		received++
+		return nil
+	}, func(err error) { assert.NoError(t, err) }))
+	assert.Equal(t, count, received)
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,136 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// flakyWriter accepts at most limit bytes per Write and fails with errs in order
+type flakyWriter struct {
+	bytes.Buffer
+	limit int
+	errs  []error
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if len(w.errs) > 0 {
+		err := w.errs[0]
+		w.errs = w.errs[1:]
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > w.limit {
+		p = p[:w.limit]
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestWriteFull(t *testing.T) {
+	w := &flakyWriter{limit: 3, errs: []error{nil, syscall.EINTR, nil, syscall.EINTR}}
+	n, err := writeFull(w, []byte("Content-Length: 2\r\n\r\n{}"))
+	require.NoError(t, err)
+	assert.Equal(t, 23, n)
+	assert.Equal(t, "Content-Length: 2\r\n\r\n{}", w.String())
+
+	w = &flakyWriter{limit: 4, errs: []error{nil, syscall.EPIPE}}
+	n, err = writeFull(w, []byte("0123456789"))
+	assert.ErrorIs(t, err, syscall.EPIPE)
+	assert.Equal(t, 4, n)
+
+	w = &flakyWriter{limit: 0}
+	_, err = writeFull(w, []byte("0123456789"))
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+}
+
+func TestSyncWriterNoInterleave(t *testing.T) {
+	w := newSyncWriter(&flakyWriter{limit: 1})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _ = w.Write([]byte(fmt.Sprintf("<%d%s>", i, strings.Repeat("x", 64))))
+		}(i)
+	}
+	wg.Wait()
+	out := w.writer.(*flakyWriter).String()
+	for _, msg := range strings.SplitAfter(out, ">") {
+		if msg != "" {
+			assert.Regexp(t, "^<[0-7]x{64}>$", msg)
+		}
+	}
+}
+
+func TestInterceptWriteError(t *testing.T) {
+	ch := make(chan LogData, 32)
+	reader := &chunkReader{chunks: []string{frame(`{"jsonrpc":"2.0","method":"a"}`), frame(`{"jsonrpc":"2.0","method":"b"}`)}}
+	writer := &flakyWriter{limit: 1024, errs: []error{syscall.EPIPE}}
+	err := intercept(context.Background(), STDOUT, reader, writer, ch, RunOptions{})
+	assert.ErrorIs(t, err, syscall.EPIPE)
+	assert.Empty(t, writer.String())
+	d := <-ch
+	assert.Equal(t, JSON, d.payloadType)
+	d = <-ch
+	assert.Equal(t, RAW_END, d.payloadType)
+	assert.Equal(t, "write error: broken pipe", string(d.payload))
+	assert.Empty(t, ch) // stop reading after write error
+}
+
+func TestInterceptStderrWriteError(t *testing.T) {
+	ch := make(chan LogData, 32)
+	reader := &chunkReader{chunks: []string{"hello\n", "world\n"}}
+	writer := &flakyWriter{limit: 1024, errs: []error{errors.New("closed")}}
+	require.NoError(t, intercept(context.Background(), STDERR, reader, writer, ch, RunOptions{}))
+	var payloads []string
+	for len(ch) > 0 {
+		d := <-ch
+		payloads = append(payloads, string(d.payload))
+	}
+	assert.Equal(t, []string{
+		"stop pass-through of stderr, caused by write error: closed",
+		"hello\n",
+		"world\n",
+		"end of stream",
+	}, payloads)
+}
+
+func TestStoppableReaderStop(t *testing.T) {
+	pipeIn, pipeOut := io.Pipe()
+	fileIn, fileOut, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() {
+		_ = fileIn.Close()
+		_ = fileOut.Close()
+	}()
+	sources := []io.Reader{pipeIn, fileIn}
+	writers := []io.Writer{pipeOut, fileOut}
+	for i, source := range sources {
+		r := newStoppableReader(source) // writer is kept open
+		go func() {
+			_, _ = writers[i].Write([]byte("x"))
+		}()
+		buf := make([]byte, 1)
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		r.Stop()
+		select {
+		case <-r.done: // background goroutine is joined
+		default:
+			t.Fatal("background goroutine is still running")
+		}
+		_, err = r.Read(buf)
+		assert.Equal(t, io.EOF, err)
+	}
+}
@@ -0,0 +1,390 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// perfBudget is performance budget of the standard profile (10k msg/min)
+type perfBudget struct {
+	addedLatencyP99 time.Duration // p99 of latency added by recorder (see addedLatencies)
+	cpuRatio        float64       // cpu time / wall time of process
+	allocsPerMsg    float64       // allocations added by recorder per message
+}
+
+// strictBudget is checked on dedicated machine (LSP_RECORDER_PERF_BUDGET=1). Timing of loaded CI runner
+// is not reliable, so it is checked against defaultBudget, which only catches regression by order of magnitude
+var (
+	strictBudget  = perfBudget{addedLatencyP99: time.Millisecond, cpuRatio: 0.05, allocsPerMsg: 64}
+	defaultBudget = perfBudget{addedLatencyP99: 20 * time.Millisecond, cpuRatio: 0.5, allocsPerMsg: 256}
+)
+
+type perfProfile struct {
+	name string
+	size int           // payload size of each message
+	rate time.Duration // interval between messages (0 means as fast as possible)
+}
+
+var standardProfile = perfProfile{name: "standard", size: 512, rate: time.Minute / 10000}
+
+var benchProfiles = []perfProfile{
+	{name: "small", size: 128},
+	{name: "medium", size: 4 * 1024},
+	{name: "large", size: 256 * 1024},
+}
+
+// fakeMessage returns framed didChange notification whose payload size is at least size
+func fakeMessage(size int) []byte {
+	const prefix = `{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.txt","version":1},"contentChanges":[{"text":"`
+	const suffix = `"}]}}`
+	padding := size - len(prefix) - len(suffix)
+	if padding < 0 {
+		padding = 0
+	}
+	payload := prefix + strings.Repeat("x", padding) + suffix
+	return []byte(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(payload), payload))
+}
+
+// startEchoServer starts in-process TCP server echoing each connection, and returns its address
+func startEchoServer(tb testing.TB) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	tb.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echoPipeline is client <-> echo server connection optionally recorded
+type echoPipeline struct {
+	clientOut io.WriteCloser // client writes request here
+	clientIn  io.Reader      // client reads echo from here
+	stop      func() error   // disconnects client and waits until log is closed
+}
+
+// startEchoPipeline connects client to in-process echo server. If logPath is not empty, traffic goes through
+// RunContext (connected to server like --connect) and is recorded to log file of logPath
+func startEchoPipeline(tb testing.TB, logPath string) *echoPipeline {
+	addr := startEchoServer(tb)
+	if logPath == "" {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(tb, err)
+		return &echoPipeline{clientOut: conn, clientIn: conn, stop: conn.Close}
+	}
+	logger, closer, err := CreateLog(logPath, LogOptions{Format: LogFormatJSON})
+	require.NoError(tb, err)
+	clientReader, clientWriter := io.Pipe() // client -> recorder
+	resultReader, resultWriter := io.Pipe() // recorder -> client
+	done := make(chan error, 1)
+	go func() {
+		_, err := RunContext(context.Background(), "", nil, logger, RunOptions{Connect: addr, NoEnv: true,
+			ClientIn: clientReader, ClientOut: resultWriter, ErrOut: io.Discard})
+		_ = resultWriter.Close()
+		done <- errors.Join(err, closer.Close())
+	}()
+	return &echoPipeline{
+		clientOut: clientWriter,
+		clientIn:  resultReader,
+		stop: func() error {
+			_ = clientWriter.Close()
+			go func() { _, _ = io.Copy(io.Discard, resultReader) }() // echo in flight must not block recorder
+			return <-done
+		},
+	}
+}
+
+// roundTrip sends message and waits for its echo, and returns time of sending.
+// Message is written concurrently since pipes are not buffered
+func (p *echoPipeline) roundTrip(msg []byte, buf []byte) (time.Time, error) {
+	sent := time.Now()
+	written := make(chan error, 1)
+	go func() {
+		_, err := p.clientOut.Write(msg)
+		written <- err
+	}()
+	if _, err := io.ReadFull(p.clientIn, buf[:len(msg)]); err != nil {
+		return sent, err
+	}
+	return sent, <-written
+}
+
+// requestTimestamps returns timestamps of client messages recorded in log
+func requestTimestamps(logPath string) ([]time.Time, error) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var requests []time.Time
+	reader := NewLogReader(file)
+	for {
+		d, err := reader.Next()
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.streamType == STDIN && d.payloadType == JSON {
+			requests = append(requests, d.timestamp)
+		}
+	}
+}
+
+// addedLatencies returns time from client writing each message until recorder recorded it. Recorder forwards
+// message as read and records it after parsing, so this includes all of work of recorder for the message.
+// Echo is not measured in the same way, since client may receive it before it is recorded
+func addedLatencies(sent, requests []time.Time) ([]time.Duration, error) {
+	if len(requests) != len(sent) {
+		return nil, fmt.Errorf("%d messages are sent, but %d are recorded", len(sent), len(requests))
+	}
+	latencies := make([]time.Duration, len(sent))
+	for i := range sent {
+		latencies[i] = requests[i].Sub(sent[i])
+	}
+	return latencies, nil
+}
+
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+type perfResult struct {
+	addedLatencyP99 time.Duration // p99 of addedLatencies (0 if not recorded)
+	cpuRatio        float64       // cpu time / wall time
+	allocsPerMsg    float64       // allocations of process per message
+}
+
+func runPerfProfile(t testing.TB, profile perfProfile, count int, recorded bool) perfResult {
+	var logPath string
+	if recorded {
+		logPath = filepath.Join(t.TempDir(), "perf.log")
+	}
+	p := startEchoPipeline(t, logPath)
+	msg := fakeMessage(profile.size)
+	buf := make([]byte, len(msg))
+	sent := make([]time.Time, 0, count)
+	var memStart, memEnd runtime.MemStats
+	runtime.ReadMemStats(&memStart)
+	wallStart, cpuStart := time.Now(), cpuTime()
+	next := wallStart
+	for i := 0; i < count; i++ {
+		if profile.rate > 0 {
+			time.Sleep(time.Until(next))
+			next = next.Add(profile.rate)
+		}
+		s, err := p.roundTrip(msg, buf)
+		require.NoError(t, err)
+		sent = append(sent, s)
+	}
+	wall, cpu := time.Since(wallStart), cpuTime()-cpuStart
+	runtime.ReadMemStats(&memEnd)
+	require.NoError(t, p.stop())
+	result := perfResult{
+		cpuRatio:     float64(cpu) / float64(wall),
+		allocsPerMsg: float64(memEnd.Mallocs-memStart.Mallocs) / float64(count),
+	}
+	if recorded {
+		requests, err := requestTimestamps(logPath)
+		require.NoError(t, err)
+		latencies, err := addedLatencies(sent, requests)
+		require.NoError(t, err)
+		result.addedLatencyP99 = percentile(sortDurations(latencies), 99)
+	}
+	return result
+}
+
+// TestPerformanceBudget checks strictBudget if LSP_RECORDER_PERF_BUDGET is set, otherwise defaultBudget
+func TestPerformanceBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("performance budget takes a few seconds")
+	}
+	budget := defaultBudget
+	if os.Getenv("LSP_RECORDER_PERF_BUDGET") != "" {
+		budget = strictBudget
+	}
+	const count = 300 // about 2 seconds in standard profile
+	direct := runPerfProfile(t, standardProfile, count, false)
+	recorded := runPerfProfile(t, standardProfile, count, true)
+	allocs := recorded.allocsPerMsg - direct.allocsPerMsg
+	t.Logf("%s: p99 added latency %s, cpu %.2f%% (direct %.2f%%), added allocs %.1f/msg", standardProfile.name,
+		recorded.addedLatencyP99, recorded.cpuRatio*100, direct.cpuRatio*100, allocs)
+	if recorded.addedLatencyP99 > budget.addedLatencyP99 {
+		t.Errorf("p99 added latency %s exceeds budget %s", recorded.addedLatencyP99, budget.addedLatencyP99)
+	}
+	if recorded.cpuRatio > budget.cpuRatio {
+		t.Errorf("cpu usage %.2f%% exceeds budget %.2f%%", recorded.cpuRatio*100, budget.cpuRatio*100)
+	}
+	if allocs > budget.allocsPerMsg {
+		t.Errorf("added allocs %.1f/msg exceeds budget %.1f/msg", allocs, budget.allocsPerMsg)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	for _, profile := range benchProfiles {
+		for _, recorded := range []bool{false, true} {
+			mode := "direct"
+			if recorded {
+				mode = "recorded"
+			}
+			b.Run(fmt.Sprintf("%s/%s", profile.name, mode), func(b *testing.B) {
+				var logPath string
+				if recorded {
+					logPath = filepath.Join(b.TempDir(), "perf.log")
+				}
+				p := startEchoPipeline(b, logPath)
+				msg := fakeMessage(profile.size)
+				buf := make([]byte, len(msg))
+				sent := make([]time.Time, 0, b.N)
+				b.SetBytes(int64(len(msg)))
+				b.ReportAllocs() // allocs/op is allocs/msg of client, recorder and echo server
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					s, err := p.roundTrip(msg, buf)
+					if err != nil {
+						b.Fatal(err)
+					}
+					sent = append(sent, s)
+				}
+				b.StopTimer()
+				if err := p.stop(); err != nil {
+					b.Fatal(err)
+				}
+				if !recorded {
+					return
+				}
+				requests, err := requestTimestamps(logPath)
+				if err != nil {
+					b.Fatal(err)
+				}
+				latencies, err := addedLatencies(sent, requests)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(float64(percentile(sortDurations(latencies), 99).Nanoseconds()), "p99-added-ns/msg")
+			})
+		}
+	}
+}
+
+// BenchmarkIntercept pumps small framed messages (like didChange of typing) through intercept
+func BenchmarkIntercept(b *testing.B) {
+	msg := fakeMessage(512)
+	stream := bytes.Repeat(msg, b.N)
+	ch := make(chan LogData, 32)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	_ = intercept(context.Background(), STDIN, bytes.NewReader(stream), io.Discard, ch, RunOptions{})
+	close(ch)
+	<-done
+}
+
+// firstWriteWriter discards data and keeps time of the first write
+type firstWriteWriter struct {
+	first time.Time
+}
+
+func (w *firstWriteWriter) Write(p []byte) (int, error) {
+	if w.first.IsZero() {
+		w.first = time.Now()
+	}
+	return len(p), nil
+}
+
+// sampleHeap samples heap in use until stop is closed and returns its peak
+func sampleHeap(stop <-chan struct{}) <-chan uint64 {
+	peak := make(chan uint64, 1)
+	go func() {
+		var peakHeap uint64
+		var stats runtime.MemStats
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&stats)
+			peakHeap = max(peakHeap, stats.HeapInuse)
+			select {
+			case <-stop:
+				peak <- peakHeap
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return peak
+}
+
+// BenchmarkInterceptHugeMessage measures peak heap and forwarding latency (until the first byte reaches
+// destination) of a multi-megabyte message held in memory or spilled to file
+func BenchmarkInterceptHugeMessage(b *testing.B) {
+	msg := fakeMessage(32 * 1024 * 1024)
+	for _, spillOver := range []int64{0, 1024 * 1024} {
+		mode := "memory"
+		if spillOver > 0 {
+			mode = "spill"
+		}
+		b.Run(mode, func(b *testing.B) {
+			dir := b.TempDir()
+			var peak uint64
+			var latency time.Duration
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				stop := make(chan struct{})
+				heap := sampleHeap(stop)
+				ch := make(chan LogData, 32)
+				done := make(chan struct{})
+				go func() {
+					record(context.Background(), ch, NewLogger(io.Discard), NewSession(nil), &RunOptions{})
+					close(done)
+				}()
+				writer := &firstWriteWriter{}
+				start := time.Now()
+				_ = intercept(context.Background(), STDOUT, bytes.NewReader(msg), writer, ch,
+					RunOptions{SpillOver: spillOver, SpillDir: dir})
+				close(ch)
+				<-done
+				close(stop)
+				peak = max(peak, <-heap)
+				latency += writer.first.Sub(start)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(peak)/(1024*1024), "peak-heap-MB")
+			b.ReportMetric(float64(latency.Nanoseconds())/float64(b.N), "first-byte-ns/op")
+		})
+	}
+}
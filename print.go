@@ -0,0 +1,641 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrintFilter selects records to be printed. Each condition is combined by AND
+type PrintFilter struct {
+	Streams          []StreamType // print only these streams (all streams if empty)
+	Methods          []string     // print only messages whose method matches these globs (all if empty)
+	ExcludeMethods   []string     // do not print messages whose method matches these globs
+	MatchResponses   bool         // treat response as having method of the corresponding request
+	Since            *TimeBound   // print only records at or after this time (nil if unbounded)
+	Until            *TimeBound   // print only records at or before this time (nil if unbounded)
+	Seqs             []int        // print only records of these sequence numbers (all if empty)
+	Ids              []PrintId    // print only requests of these JSON-RPC ids with their cancellations and responses
+	Related          bool         // with Ids, also print messages of peer while request is pending and its progress (see idStory)
+	HideEnv          bool         // do not print environment variables (ENV record)
+	CollapsePartials bool         // print only header of partial results (payload is omitted)
+	Color            bool         // colorize output with ANSI escape sequences (see formatColorLogData)
+	Output           string       // output mode (PrintOutputPretty if empty, PrintOutputJSON or PrintOutputCompact)
+	Timestamps       string       // timestamp mode (PrintTimestampAbsolute if empty, PrintTimestampRelative or PrintTimestampDelta)
+	Head             int          // print only the first N matched records (all if 0)
+	Tail             int          // print only the last N matched records (all if 0). Exclusive with Head
+	Skip             int          // do not print the first N matched records
+	Limit            int          // print at most N matched records after Skip (all if 0)
+	ShowIndex        bool         // print index of record (seq, or order in log if record has no seq) like "#42"
+	Query            *Query       // print only JSON messages whose payload matches query (nil if not filtered)
+	Reassemble       bool         // print messages reconstructed from raw chunks of stdin/stdout (see RunOptions.Raw)
+	ServerLogsOnly   bool         // print only server logs (window/logMessage and $/logTrace) and stderr
+	ErrorsOnly       bool         // print only problems labeled with category (see problemOf) and their counts at end
+	StripAnsi        bool         // strip ANSI escape sequences from stderr at display time (see AnsiStripper)
+	SkipErrors       func(error)  // if not nil, broken lines are reported to it and skipped (see LogReader.SkipErrors)
+
+	since time.Time // resolved Since
+	until time.Time // resolved Until
+	start time.Time // timestamp of first record
+	prev  time.Time // timestamp of previous printed record
+
+	printed  int           // number of printed records (for Head and Limit)
+	skipped  int           // number of skipped records (for Skip)
+	tail     []tailRecord  // ring buffer of the last Tail matched records
+	tailNext int           // index of tail overwritten by next record
+	problems problemCounts // printed records of each category (for ErrorsOnly)
+	story    *idStory      // story of requests of Ids (nil until first record)
+	ansi     AnsiStripper  // stripper of stderr (for StripAnsi), so that sequence split across chunks is stripped
+}
+
+// TimeBound is absolute time or relative offset from start of log
+type TimeBound struct {
+	Time     time.Time
+	Offset   time.Duration
+	Relative bool
+}
+
+// ParseTimeBound parses RFC3339 timestamp or relative offset from start of log (like "+5m", "+1h30s")
+func ParseTimeBound(s string) (*TimeBound, error) {
+	if strings.HasPrefix(s, "+") {
+		offset, err := time.ParseDuration(s[1:])
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid time offset: '%s'", s)
+		}
+		return &TimeBound{Offset: offset, Relative: true}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, fmt.Errorf("time must be RFC3339 timestamp or offset like +5m: '%s'", s)
+	}
+	return &TimeBound{Time: t}, nil
+}
+
+func (b *TimeBound) resolve(start time.Time) time.Time {
+	if b.Relative {
+		return start.Add(b.Offset)
+	}
+	return b.Time
+}
+
+func (b *TimeBound) String() string {
+	if b.Relative {
+		return "+" + b.Offset.String()
+	}
+	return b.Time.Format(time.RFC3339Nano)
+}
+
+// begin resolves time range by session start (timestamp of first record)
+func (f *PrintFilter) begin(start time.Time) error {
+	f.start = start
+	if f.Since != nil {
+		f.since = f.Since.resolve(start)
+	}
+	if f.Until != nil {
+		f.until = f.Until.resolve(start)
+	}
+	if f.Since != nil && f.Until != nil && f.since.After(f.until) {
+		return fmt.Errorf("--since (%s) must not be after --until (%s)", f.Since, f.Until)
+	}
+	return nil
+}
+
+func (f *PrintFilter) hasMethodFilter() bool {
+	return len(f.Methods) > 0 || len(f.ExcludeMethods) > 0
+}
+
+// match reports whether record is printed. e is nil if record is not JSON-RPC message.
+// resolved is method of e (method of the corresponding request if e is response)
+func (f *PrintFilter) match(d *LogData, e *Envelope, resolved string) bool {
+	if d.payloadType == SESSION_START { // stderr of appended session starts from scratch
+		f.ansi = AnsiStripper{}
+	}
+	inStory := true
+	if len(f.Ids) > 0 { // every record is followed, even if it is filtered out by others
+		if f.story == nil {
+			f.story = newIdStory(f.Ids, f.Related)
+		}
+		inStory = f.story.follow(d, e)
+	}
+	if f.HideEnv && d.payloadType == ENV {
+		return false
+	}
+	if f.ServerLogsOnly && d.streamType != STDERR && !isServerLog(d, e) {
+		return false
+	}
+	if f.ErrorsOnly && problemOf(d, e) == "" {
+		return false
+	}
+	method := ""
+	if f.hasMethodFilter() {
+		if e == nil {
+			return false
+		}
+		method = e.Method
+		if f.MatchResponses {
+			method = resolved
+		}
+	}
+	if len(f.Streams) > 0 {
+		found := false
+		for _, t := range f.Streams {
+			if t == d.streamType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Methods) > 0 && (method == "" || !MatchMethod(f.Methods, method)) {
+		return false
+	}
+	if len(f.ExcludeMethods) > 0 && method != "" && MatchMethod(f.ExcludeMethods, method) {
+		return false
+	}
+	if len(f.Seqs) > 0 && !slices.Contains(f.Seqs, d.seq) {
+		return false
+	}
+	if !inStory {
+		return false
+	}
+	if f.Since != nil && d.timestamp.Before(f.since) {
+		return false
+	}
+	if f.Until != nil && d.timestamp.After(f.until) {
+		return false
+	}
+	if f.Query != nil && (d.payloadType != JSON || !f.Query.Match(d.payload)) {
+		return false
+	}
+	return true
+}
+
+// Selective reports whether filter selects only a few records by sequence number or id
+func (f *PrintFilter) Selective() bool {
+	return (len(f.Seqs) > 0 || len(f.Ids) > 0) && !f.Reassemble // messages of raw capture are not indexed
+}
+
+// Seekable reports whether filter skips many records by metadata (sequence number, id, method, time or position),
+// so that PrintIndex by persisted index (see ReadLogIndex) is faster than scanning log
+func (f *PrintFilter) Seekable() bool {
+	return f.Selective() || (!f.Reassemble && (len(f.Methods) > 0 || f.Since != nil || f.Until != nil || f.Tail > 0 ||
+		f.Skip > 0))
+}
+
+// ParseStreamTypes parses comma-separated stream types like "stdin,stdout"
+func ParseStreamTypes(values []string) ([]StreamType, error) {
+	var types []StreamType
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			t, err := parseStreamType(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			types = append(types, t)
+		}
+	}
+	return types, nil
+}
+
+// withRecordNote appends notes of synthetic record (see shutdownServer), incomplete or non-JSON message and
+// truncated (see truncatePayload) or spilled (see payloadBuffer) payload to note
+func withRecordNote(d *LogData, note string) string {
+	var notes []string
+	if note != "" {
+		notes = append(notes, note)
+	}
+	if d.source != "" {
+		notes = append(notes, "from "+d.source)
+	}
+	if d.synthetic {
+		notes = append(notes, "synthesized by recorder")
+	}
+	if d.batchLen > 0 {
+		notes = append(notes, fmt.Sprintf("batch %d/%d", d.batch, d.batchLen))
+	} else if d.payloadType == JSON && isEmptyBatch(d.payload) {
+		notes = append(notes, "warning: empty batch")
+	}
+	switch {
+	case d.payloadType == INCOMPLETE:
+		received := len(d.payload)
+		if d.originalSize > 0 {
+			received = d.originalSize
+		}
+		notes = append(notes, fmt.Sprintf("incomplete message, received %d of %d bytes", received, d.declaredSize))
+	case d.payloadType == INVALID && d.declaredSize > 0:
+		notes = append(notes, fmt.Sprintf("warning: payload is not valid JSON, Content-Length %d", d.declaredSize))
+	}
+	if d.originalSize > 0 {
+		notes = append(notes, fmt.Sprintf("truncated, original %d bytes", d.originalSize))
+	} else if d.size > len(d.payload) && hasJSONPayload(d) {
+		notes = append(notes, fmt.Sprintf("redacted, original %d bytes", d.size))
+	}
+	if d.spill != "" {
+		notes = append(notes, "whole payload in "+d.spill)
+	}
+	return strings.Join(notes, ", ")
+}
+
+// formatLogData writes LogData in human-readable format (JSON payload is indented).
+// stamp is timestamp of record (see PrintFilter.stamp). If note is not empty, it is shown after stream
+func formatLogData(writer io.Writer, d *LogData, stamp string, note string) {
+	if lineNote, line, ok := recordLine(d); ok {
+		_, _ = fmt.Fprintf(writer, "%s %s (%s) %s\n", stamp, toString(d.streamType), withRecordNote(d, lineNote), line)
+		return
+	}
+	blockNote, block := recordBlock(d)
+	if block != nil {
+		note = blockNote
+	}
+	_, _ = fmt.Fprintf(writer, "%s %s", stamp, toString(d.streamType))
+	if note = withRecordNote(d, note); note != "" {
+		_, _ = fmt.Fprintf(writer, " (%s)", note)
+	}
+	if block != nil {
+		_, _ = writer.Write([]byte("\n"))
+		block(writer)
+		return
+	}
+	if d.payloadType == JSON && d.originalSize > 0 { // truncated JSON cannot be indented
+		_, _ = writer.Write([]byte("\n"))
+		_, _ = writer.Write(d.payload)
+		_, _ = writer.Write([]byte("\n"))
+		return
+	}
+	if d.payloadType != JSON {
+		_, _ = writer.Write([]byte(" "))
+		_, _ = writer.Write(d.payload)
+		_, _ = writer.Write([]byte("\n"))
+		return
+	}
+	err := indentPayload(d.payload, func(indented []byte) {
+		_, _ = writer.Write([]byte("\n"))
+		_, _ = writer.Write(indented)
+		_, _ = writer.Write([]byte("\n"))
+	})
+	if err != nil { // mislabeled as JSON, print as is so that record never vanishes
+		_, _ = fmt.Fprintf(writer, " (unformatted: %v)\n", err)
+		_, _ = writer.Write(d.payload)
+		_, _ = writer.Write([]byte("\n"))
+	}
+}
+
+// maxPooledIndentBytes is max capacity of buffer returned to indentBuffers (buffer of huge payload is dropped)
+const maxPooledIndentBytes = 64 * 1024
+
+// indentBuffers are buffers reused by indentPayload
+var indentBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// indentPayload calls fn with indented JSON payload, which is valid only during call.
+// Returns error without calling fn if payload cannot be indented
+func indentPayload(payload []byte, fn func(indented []byte)) error {
+	buf := indentBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledIndentBytes {
+			buf.Reset()
+			indentBuffers.Put(buf)
+		}
+	}()
+	buf.Reset()
+	if err := json.Indent(buf, payload, "", "  "); err != nil {
+		return err
+	}
+	fn(buf.Bytes())
+	return nil
+}
+
+// notes of records rendered as block
+const (
+	sessionMetaNote    = "session metadata"
+	sessionSummaryNote = "session summary"
+)
+
+// recordBlock returns note and writer of block if record is rendered as block (META record as header block,
+// TRAILER record as summary block). Returns nil writer if record is printed as is (e.g. broken or old trailer)
+func recordBlock(d *LogData) (string, func(writer io.Writer)) {
+	if d.originalSize > 0 {
+		return "", nil
+	}
+	switch d.payloadType {
+	case META:
+		if meta, err := ParseSessionMeta(d.payload); err == nil {
+			return sessionMetaNote, func(writer io.Writer) { meta.Format(writer, "  ") }
+		}
+	case TRAILER:
+		t := &Trailer{}
+		if json.Unmarshal(d.payload, t) == nil && t.Summary != nil {
+			return sessionSummaryNote, func(writer io.Writer) { t.Format(writer, "  ") }
+		}
+	}
+	return "", nil
+}
+
+// recordLine returns note and summary of record rendered in one line (RESOURCES record as its sample).
+// Returns false if record is printed as is (e.g. broken sample)
+func recordLine(d *LogData) (string, string, bool) {
+	if d.payloadType != RESOURCES || d.originalSize > 0 {
+		return "", "", false
+	}
+	sample, err := ParseResourceSample(d.payload)
+	if err != nil {
+		return "", "", false
+	}
+	return resourceNote, sample.String(), true
+}
+
+// sessionStartNote is note of SESSION_START record, which separates sessions appended to the same log
+const sessionStartNote = "session start"
+
+// serverSessionNote returns note of the first record of respawned server (see RunOptions.MaxRestarts), which
+// separates server sessions. Empty if record continues server session of previous record (server)
+func serverSessionNote(d *LogData, server int) string {
+	if server == 0 || d.serverSession <= server {
+		return ""
+	}
+	return fmt.Sprintf("server session %d", d.serverSession)
+}
+
+// Print reads log and writes matched records in human-readable format.
+// Responses and partial results are annotated with the corresponding request and latency
+func Print(reader io.Reader, writer io.Writer, filter *PrintFilter) error {
+	err := scanRecords(reader, filter, func(d *LogData, e *Envelope, p pairing) bool {
+		return filter.match(d, e, p.method) && filter.emit(writer, d, e, p) // --head is reached
+	})
+	if err == nil {
+		filter.end(writer)
+	}
+	return err
+}
+
+// scanRecords calls fn with each record of log, its envelope (nil if not JSON-RPC message) and pairing
+// until fn returns true
+func scanRecords(reader io.Reader, filter *PrintFilter, fn func(d *LogData, e *Envelope, p pairing) bool) error {
+	r := NewLogReader(reader)
+	r.SkipErrors(filter.SkipErrors)
+	tracker := NewRequestTracker()
+	reassembler := rawReassembler{}
+	server := 0 // server session of previous record
+	for n := 1; ; n++ {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 1 {
+			if err := filter.begin(d.timestamp); err != nil {
+				return err
+			}
+		}
+		if d.seq == 0 { // record without seq is numbered in order
+			d.seq = n
+		}
+		records := []*LogData{d}
+		if filter.Reassemble {
+			records = reassembler.records(d)
+		}
+		var messages []*LogData
+		for _, d := range records {
+			messages = append(messages, expandBatch(d)...)
+		}
+		for _, d := range messages {
+			var e *Envelope
+			var p pairing
+			if note := serverSessionNote(d, server); note != "" { // ids of respawned server are independent
+				tracker = NewRequestTracker()
+				p.note = note
+			}
+			server = d.serverSession
+			switch d.payloadType {
+			case JSON:
+				if e, err = d.Envelope(); err == nil {
+					p = tracker.pair(d, e)
+				} else {
+					e = nil
+				}
+			case SESSION_START: // ids of appended session are independent of previous one
+				tracker = NewRequestTracker()
+				p.note = sessionStartNote
+			}
+			if fn(d, e, p) {
+				return nil
+			}
+		}
+	}
+}
+
+// PrintIndex writes matched records like Print, but loads payloads of only matched records
+// (and requests, $/cancelRequest and $/progress needed for annotation). Suitable for selective filter
+func PrintIndex(index *LogIndex, writer io.Writer, filter *PrintFilter) error {
+	tracker := NewRequestTracker()
+	server := 0 // server session of previous record
+	for i := range index.Entries {
+		entry := &index.Entries[i]
+		if i == 0 {
+			if err := filter.begin(entry.Time); err != nil {
+				return err
+			}
+		}
+		if entry.Seq == 0 {
+			entry.Seq = i + 1
+		}
+		d := &LogData{seq: entry.Seq, timestamp: entry.Time, streamType: entry.Stream, payloadType: entry.Type,
+			serverSession: entry.Server}
+		e := entry.envelope()
+		var p pairing
+		if note := serverSessionNote(d, server); note != "" {
+			tracker = NewRequestTracker()
+			p.note = note
+		}
+		server = d.serverSession
+		if e != nil {
+			if e.IsRequest() || e.Method == "$/cancelRequest" || e.Method == "$/progress" || filter.Query != nil ||
+				filter.ErrorsOnly {
+				loaded, err := index.Load(entry)
+				if err != nil {
+					return err
+				}
+				d = loaded
+				if filter.ErrorsOnly { // error member is not indexed
+					if parsed, err := d.Envelope(); err == nil {
+						e = parsed
+					}
+				}
+			}
+			p = tracker.pair(d, e)
+		} else if d.payloadType == SESSION_START {
+			tracker = NewRequestTracker()
+			p.note = sessionStartNote
+		}
+		if !filter.match(d, e, p.method) {
+			continue
+		}
+		if d.payload == nil {
+			if filter.skipped < filter.Skip { // payload is not needed
+				filter.skipped++
+				continue
+			}
+			if filter.Tail > 0 { // loaded at end, if it remains in the last Tail records
+				filter.keep(tailRecord{d: d, e: e, p: p, entry: entry})
+				continue
+			}
+			loaded, err := index.Load(entry)
+			if err != nil {
+				return err
+			}
+			d = loaded
+		}
+		if filter.emit(writer, d, e, p) {
+			return nil
+		}
+	}
+	for i := range filter.tail {
+		if r := &filter.tail[i]; r.entry != nil {
+			loaded, err := index.Load(r.entry)
+			if err != nil {
+				return err
+			}
+			r.d, r.entry = loaded, nil
+		}
+	}
+	filter.end(writer)
+	return nil
+}
+
+// tailRecord is matched record kept for PrintFilter.Tail
+type tailRecord struct {
+	d     *LogData
+	e     *Envelope
+	p     pairing
+	entry *LogEntry // entry whose payload is not loaded yet (nil if d has payload, see PrintIndex)
+}
+
+// keep keeps record in ring buffer of the last Tail records
+func (f *PrintFilter) keep(r tailRecord) {
+	if len(f.tail) < f.Tail {
+		f.tail = append(f.tail, r)
+	} else {
+		f.tail[f.tailNext] = r
+	}
+	f.tailNext = (f.tailNext + 1) % f.Tail
+}
+
+// emit writes matched record unless it is skipped, or keeps it in ring buffer of the last Tail records until end.
+// Returns true if Head or Limit records have been written (rest of log is not needed)
+func (f *PrintFilter) emit(writer io.Writer, d *LogData, e *Envelope, p pairing) bool {
+	if f.skipped < f.Skip {
+		f.skipped++
+		return false
+	}
+	if f.Tail > 0 {
+		f.keep(tailRecord{d: d, e: e, p: p})
+		return false
+	}
+	f.format(writer, d, e, p)
+	f.printed++
+	return (f.Head > 0 && f.printed >= f.Head) || (f.Limit > 0 && f.printed >= f.Limit)
+}
+
+// end writes records kept for Tail in order, and counts of problems if ErrorsOnly
+func (f *PrintFilter) end(writer io.Writer) {
+	for i := range f.tail {
+		r := &f.tail[(f.tailNext+i)%len(f.tail)]
+		f.format(writer, r.d, r.e, r.p)
+	}
+	f.tail = nil
+	if !f.ErrorsOnly {
+		return
+	}
+	if f.Output == PrintOutputJSON {
+		counts := map[string]int{}
+		for _, category := range problemCategories { // including zero
+			counts[category] = f.problems[category]
+		}
+		_ = json.NewEncoder(writer).Encode(map[string]map[string]int{"problems": counts})
+	} else {
+		f.problems.Format(writer)
+	}
+}
+
+// format writes record with pairing note in output mode. Payload of partial result is omitted
+// if CollapsePartials is set, and server log is written in one line (see formatServerLog)
+func (f *PrintFilter) format(writer io.Writer, d *LogData, e *Envelope, p pairing) {
+	problem := ""
+	if f.ErrorsOnly {
+		problem = problemOf(d, e)
+		if f.problems == nil {
+			f.problems = problemCounts{}
+		}
+		f.problems[problem]++
+	}
+	if f.Output == PrintOutputJSON { // time field is always absolute
+		formatJSONRecord(writer, d, e, p, f.CollapsePartials && p.partial, problem)
+		return
+	}
+	if f.StripAnsi && d.streamType == STDERR && d.payloadType == RAW {
+		stripped := *d // record may be kept in tail or index, so it is not modified
+		stripped.payload = f.ansi.Strip(d.payload)
+		d = &stripped
+	}
+	stamp := f.stamp(d)
+	if f.ShowIndex {
+		_, _ = fmt.Fprintf(writer, "#%d ", d.seq)
+	}
+	if problem != "" {
+		_, _ = fmt.Fprintf(writer, "[%s] ", problem)
+	}
+	var log *serverLog
+	if f.Output != PrintOutputCompact {
+		log = parseServerLog(d, e)
+	}
+	switch {
+	case f.Output == PrintOutputCompact:
+		formatCompactRecord(writer, d, stamp, e, p)
+	case log != nil:
+		formatServerLog(writer, d, stamp, log, f.Color)
+	case f.CollapsePartials && p.partial && f.Color:
+		formatColorHeader(writer, d, stamp, p.note)
+		_, _ = fmt.Fprintf(writer, " %s\n", formatSize(len(d.payload)))
+	case f.CollapsePartials && p.partial:
+		_, _ = fmt.Fprintf(writer, "%s %s (%s) %s\n", stamp, toString(d.streamType),
+			withRecordNote(d, p.note), formatSize(len(d.payload)))
+	case f.Color:
+		formatColorLogData(writer, d, stamp, p.note)
+	default:
+		formatLogData(writer, d, stamp, p.note)
+	}
+}
+
+// timestamp modes of print
+const (
+	PrintTimestampAbsolute = "absolute" // RFC3339 timestamp
+	PrintTimestampRelative = "relative" // offset from first record of log
+	PrintTimestampDelta    = "delta"    // offset from previous printed record
+)
+
+// stamp returns timestamp of printed record in Timestamps mode. Offsets are padded to the same width
+// (like "   +12.456789s"), so that columns line up
+func (f *PrintFilter) stamp(d *LogData) string {
+	var offset time.Duration
+	switch f.Timestamps {
+	case PrintTimestampRelative:
+		offset = d.timestamp.Sub(f.start)
+	case PrintTimestampDelta:
+		if !f.prev.IsZero() {
+			offset = d.timestamp.Sub(f.prev)
+		}
+		f.prev = d.timestamp
+	default:
+		return d.timestamp.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%14s", fmt.Sprintf("%+.6fs", offset.Seconds()))
+}
@@ -0,0 +1,570 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLog writes LogData as log (seq and timestamp are assigned in order)
+func newTestLog(data ...LogData) string {
+	buf := bytes.Buffer{}
+	logger := NewLogger(&buf)
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := range data {
+		data[i].seq = i + 1
+		if data[i].timestamp.IsZero() {
+			data[i].timestamp = base.Add(time.Duration(i) * time.Second)
+		}
+		writeLogData(logger, &data[i])
+	}
+	return buf.String()
+}
+
+var printTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte("invalid message header: 'hoge'")},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+)
+
+func TestPrint(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(printTestLog), &out, &PrintFilter{}))
+	assert.Equal(t, `2024-05-01T10:00:00Z <stderr> run: server []
+2024-05-01T10:00:01Z <stdin>
+{
+  "jsonrpc": "2.0",
+  "id": 1,
+  "method": "initialize"
+}
+2024-05-01T10:00:02Z <stdout> invalid message header: 'hoge'
+2024-05-01T10:00:03Z <stdout> (response to initialize id=1, 2s)
+{
+  "jsonrpc": "2.0",
+  "id": 1,
+  "result": {}
+}
+`, out.String())
+}
+
+func TestPrintHideEnv(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+		LogData{streamType: STDERR, payloadType: ENV, payload: []byte("PATH=/usr/bin\nLANG=C")},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("hello")},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(), "<stderr> PATH=/usr/bin\nLANG=C\n")
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{HideEnv: true}))
+	assert.Equal(t, "2024-05-01T10:00:00Z <stderr> run: server []\n2024-05-01T10:00:02Z <stderr> hello\n", out.String())
+}
+
+func TestPrintStreamFilter(t *testing.T) {
+	streams, err := ParseStreamTypes([]string{"stdout"})
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(printTestLog), &out, &PrintFilter{Streams: streams}))
+	assert.Equal(t, 2, strings.Count(out.String(), "<stdout>"))
+	assert.NotContains(t, out.String(), "<stdin>")
+	assert.NotContains(t, out.String(), "<stderr>")
+	assert.Contains(t, out.String(), "invalid message header") // invalid record on selected stream
+
+	streams, err = ParseStreamTypes([]string{"stdin,stderr"})
+	require.NoError(t, err)
+	assert.Equal(t, []StreamType{STDIN, STDERR}, streams)
+	_, err = ParseStreamTypes([]string{"stdin,hoge"})
+	assert.ErrorContains(t, err, "hoge")
+}
+
+var methodTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"window/workDoneProgress/create"}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"contents":"x"}}`)},
+	LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte(`{"id":`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"result":null}`)},
+)
+
+func printSeqs(t *testing.T, filter *PrintFilter) []string {
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(methodTestLog), &out, filter))
+	var lines []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "2024-") {
+			lines = append(lines, line[len("2024-05-01T10:00:0"):len("2024-05-01T10:00:0")+1])
+		}
+	}
+	return lines
+}
+
+func TestPrintMethodFilter(t *testing.T) {
+	// response is matched to request in opposite direction with the same id
+	assert.Equal(t, []string{"1", "5"},
+		printSeqs(t, &PrintFilter{Methods: []string{"textDocument/hover"}, MatchResponses: true}))
+	assert.Equal(t, []string{"3", "4"},
+		printSeqs(t, &PrintFilter{Methods: []string{"window/*"}, MatchResponses: true}))
+	assert.Equal(t, []string{"1", "2"},
+		printSeqs(t, &PrintFilter{Methods: []string{"textDocument/*"}, MatchResponses: false}))
+
+	// combined with stream filter
+	assert.Equal(t, []string{"5"},
+		printSeqs(t, &PrintFilter{Streams: []StreamType{STDOUT}, Methods: []string{"textDocument/hover"}, MatchResponses: true}))
+}
+
+func TestPrintExcludeMethod(t *testing.T) {
+	// non-JSON records and responses without request are kept unless excluded by method
+	assert.Equal(t, []string{"1", "3", "4", "5", "7"},
+		printSeqs(t, &PrintFilter{ExcludeMethods: []string{"textDocument/publishDiagnostics"}, MatchResponses: true}))
+	assert.Equal(t, []string{"2", "3", "4", "7"},
+		printSeqs(t, &PrintFilter{ExcludeMethods: []string{"textDocument/hover"}, MatchResponses: true}))
+	assert.Equal(t, []string{"2", "3", "4"},
+		printSeqs(t, &PrintFilter{Methods: []string{"*/*"}, ExcludeMethods: []string{"textDocument/hover"},
+			MatchResponses: true}))
+}
+
+func TestMatchMethod(t *testing.T) {
+	assert.True(t, MatchMethod([]string{"textDocument/*"}, "textDocument/hover"))
+	assert.True(t, MatchMethod([]string{"window/*"}, "window/workDoneProgress/create"))
+	assert.True(t, MatchMethod([]string{"$/progress", "*Diagnostics"}, "textDocument/publishDiagnostics"))
+	assert.True(t, MatchMethod([]string{"initialize?"}, "initialized"))
+	assert.False(t, MatchMethod([]string{"initialize?"}, "initialize"))
+	assert.False(t, MatchMethod([]string{"textDocument/*"}, "workspace/symbol"))
+	assert.False(t, MatchMethod(nil, "exit"))
+
+	assert.NoError(t, ValidateGlob("$/*?"))
+	assert.EqualError(t, ValidateGlob("window/[lo]*"),
+		"unsupported character '[' in method pattern (only * and ? are special): 'window/[lo]*'")
+}
+
+func TestParseTimeBound(t *testing.T) {
+	b, err := ParseTimeBound("+1h30s")
+	require.NoError(t, err)
+	assert.Equal(t, &TimeBound{Offset: time.Hour + 30*time.Second, Relative: true}, b)
+	b, err = ParseTimeBound("2024-05-01T10:00:02Z")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 2, 0, time.UTC), b.Time)
+	for _, s := range []string{"", "+", "+-5m", "5m", "2024-05-01"} {
+		_, err = ParseTimeBound(s)
+		assert.Error(t, err, "'%s'", s)
+	}
+}
+
+func TestPrintTimeRange(t *testing.T) {
+	bound := func(s string) *TimeBound {
+		b, err := ParseTimeBound(s)
+		require.NoError(t, err)
+		return b
+	}
+	assert.Equal(t, []string{"2", "3", "4", "5", "6", "7"}, printSeqs(t, &PrintFilter{Since: bound("+2s")}))
+	assert.Equal(t, []string{"0", "1", "2"}, printSeqs(t, &PrintFilter{Until: bound("+2s")}))
+	assert.Equal(t, []string{"3", "4"},
+		printSeqs(t, &PrintFilter{Since: bound("2024-05-01T10:00:03Z"), Until: bound("+4s")}))
+
+	// combined with other filters
+	assert.Equal(t, []string{"5"}, printSeqs(t, &PrintFilter{Since: bound("+2s"),
+		Methods: []string{"textDocument/hover"}, MatchResponses: true}))
+
+	err := Print(strings.NewReader(methodTestLog), io.Discard,
+		&PrintFilter{Since: bound("+5s"), Until: bound("2024-05-01T10:00:01Z")})
+	assert.ErrorContains(t, err, "must not be after")
+}
+
+func TestPrintResponseAnnotation(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	log := newTestLog(
+		LogData{timestamp: base, streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":42,"method":"textDocument/hover"}`)},
+		LogData{timestamp: base.Add(time.Millisecond), streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":42,"method":"textDocument/definition"}`)},
+		LogData{timestamp: base.Add(2 * time.Millisecond), streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":42}}`)},
+		LogData{timestamp: base.Add(3 * time.Millisecond), streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":7}}`)},
+		LogData{timestamp: base.Add(39 * time.Millisecond), streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":42,"error":{"code":-32800,"message":"cancelled"}}`)},
+		LogData{timestamp: base.Add(40 * time.Millisecond), streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":42,"result":null}`)},
+		LogData{timestamp: base.Add(41 * time.Millisecond), streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":"a","method":"window/workDoneProgress/create"}`)},
+		LogData{timestamp: base.Add(41*time.Millisecond + 250*time.Microsecond), streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":"a","result":null}`)},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	var headers []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "2024-") {
+			_, header, _ := strings.Cut(line, " ")
+			headers = append(headers, header)
+		}
+	}
+	assert.Equal(t, []string{
+		"<stdin>",
+		"<stdin> (duplicate request id=42 (previous textDocument/hover is not answered))",
+		"<stdin> (cancel textDocument/definition id=42)",
+		"<stdin> (cancel unknown request id=7)",
+		"<stdout> (response to cancelled textDocument/definition id=42, 38ms)",
+		"<stdout> (response to unknown request id=42)",
+		"<stdout>",
+		"<stdin> (response to window/workDoneProgress/create id=\"a\", 250µs)",
+	}, headers)
+}
+
+var partialResultTestLog = func() string {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	return newTestLog(
+		LogData{timestamp: base, streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":5,"method":"workspace/symbol","params":{"query":"","workDoneToken":"w","partialResultToken":"p"}}`)},
+		LogData{timestamp: base.Add(10 * time.Millisecond), streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"w","value":{"kind":"begin","title":"x"}}}`)},
+		LogData{timestamp: base.Add(80 * time.Millisecond), streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"p","value":[{"name":"a"}]}}`)},
+		LogData{timestamp: base.Add(1900 * time.Millisecond), streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"p","value":[{"name":"b"}]}}`)},
+		LogData{timestamp: base.Add(2 * time.Second), streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":5,"result":[]}`)},
+		LogData{timestamp: base.Add(3 * time.Second), streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"p","value":[]}}`)},
+	)
+}()
+
+func TestPrintPartialResults(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(partialResultTestLog), &out, &PrintFilter{CollapsePartials: true}))
+	lines := strings.Split(out.String(), "\n")
+	var headers []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "2024-") {
+			_, header, _ := strings.Cut(line, " ")
+			headers = append(headers, header)
+		}
+	}
+	assert.Equal(t, []string{
+		"<stdin>",
+		"<stdout>", // work done progress
+		"<stdout> (partial result 1 of workspace/symbol id=5, 80ms) 85B",
+		"<stdout> (partial result 2 of workspace/symbol id=5, 1.9s) 85B",
+		"<stdout> (response to workspace/symbol id=5, first result after 80ms, complete after 2s, 170B across 2 chunks)",
+		"<stdout>", // after response
+	}, headers)
+	assert.NotContains(t, out.String(), `"name"`)
+
+	// partial results are matched with method of request
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(partialResultTestLog), &out,
+		&PrintFilter{Methods: []string{"workspace/symbol"}, MatchResponses: true}))
+	assert.Equal(t, 4, strings.Count(out.String(), "2024-"))
+	assert.Contains(t, out.String(), `"name": "b"`)
+}
+
+func TestPrintStripAnsi(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("\x1b[31merror\x1b[0m: \x1b]0;title\x07failed")},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("binary\x00\x1b[31m")},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"\u001b[1m"}`)},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(), "<stderr> \x1b[31merror")
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{StripAnsi: true, Tail: 3}))
+	assert.Contains(t, out.String(), "<stderr> error: failed\n")
+	assert.Contains(t, out.String(), "<stderr> binary\x00\x1b[31m\n") // binary data is left alone
+	assert.Contains(t, out.String(), `"method": "\u001b[1m"`)         // only stderr is stripped
+}
+
+func TestPrintStripAnsiSplit(t *testing.T) {
+	// stderr of old log is recorded in chunks as read
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("warn \x1b[3")},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("1mred\x1b[0m \x1b[1")},
+		LogData{streamType: STDERR, payloadType: SESSION_START, payload: []byte(`{"pid":1}`)},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("2mnext")},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{StripAnsi: true}))
+	assert.Contains(t, out.String(), "<stderr> warn \n")
+	assert.Contains(t, out.String(), "<stderr> red \n")
+	assert.Contains(t, out.String(), "<stderr> 2mnext\n") // sequence is not continued in appended session
+}
+
+func TestPrintTextLog(t *testing.T) {
+	text := convertLog(t, printTestLog, LogFormatJSON, LogFormatText)
+	expected := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(printTestLog), &expected, &PrintFilter{}))
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(text), &out, &PrintFilter{}))
+	assert.Equal(t, expected.String(), out.String())
+
+	out.Reset()
+	err := Print(strings.NewReader("\nhello world\n"), &out, &PrintFilter{})
+	assert.EqualError(t, err, "broken log at line 2: neither JSON lines nor text log record: \"hello world\"")
+	err = Print(strings.NewReader(text+"time=2024-05-01T10:00:00Z stream=stdin payload=\"x\n"), &out, &PrintFilter{})
+	assert.EqualError(t, err, "broken log at line 5: invalid quoted value of payload: \"time=2024-05-01T10:00:00Z stream=stdin payload=\\\"x\"")
+}
+
+func TestPrintSkipErrors(t *testing.T) {
+	lines := strings.SplitAfter(printTestLog, "\n")
+	broken := lines[0] + "garbage\n" + strings.Join(lines[1:], "") + lines[1][:len(lines[1])/2] // truncated without newline
+	expected := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(printTestLog), &expected, &PrintFilter{}))
+
+	out := bytes.Buffer{}
+	err := Print(strings.NewReader(broken), &out, &PrintFilter{})
+	assert.ErrorContains(t, err, "broken log at line 2: ")
+	assert.ErrorContains(t, err, `"garbage"`)
+
+	var skipped []string
+	filter := &PrintFilter{SkipErrors: func(err error) { skipped = append(skipped, err.Error()) }}
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(broken), &out, filter))
+	assert.Equal(t, expected.String(), out.String())
+	require.Len(t, skipped, 2)
+	assert.True(t, strings.HasPrefix(skipped[0], "broken log at line 2: "))
+	assert.True(t, strings.HasPrefix(skipped[1], fmt.Sprintf("broken log at line %d: ", len(lines)+1)))
+	assert.True(t, strings.HasSuffix(skipped[1], `"...`), skipped[1])
+
+	skipped = nil
+	index, err := BuildLogIndex(strings.NewReader("garbage\n"+broken), filter.SkipErrors)
+	require.NoError(t, err)
+	assert.Len(t, index.Entries, strings.Count(printTestLog, "\n"))
+	assert.Len(t, skipped, 3)
+}
+
+func TestPrintHugeRecord(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes record larger than 64MiB")
+	}
+	text := strings.Repeat("a", 65<<20) // longer than max token size of bufio.Scanner
+	payload := `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"text":"` + text + `"}}}`
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(payload)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"initialized"}`)},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(), "initialized")
+	assert.Greater(t, out.Len(), len(text))
+
+	index, err := BuildLogIndex(strings.NewReader(log), nil)
+	require.NoError(t, err)
+	require.Len(t, index.Entries, 2)
+	assert.Equal(t, len(payload), index.Entries[0].Size)
+	assert.Equal(t, "initialized", index.Entries[1].Method)
+}
+
+func TestPrintAppendedSessions(t *testing.T) {
+	start := LogData{streamType: STDERR, payloadType: SESSION_START, payload: []byte(`{"version":"test","pid":1}`)}
+	log := newTestLog( // first session crashed before response
+		start,
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	) + newTestLog(
+		start,
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	var headers []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "2024-") {
+			_, header, _ := strings.Cut(line, " ")
+			headers = append(headers, header)
+		}
+	}
+	assert.Equal(t, []string{
+		`<stderr> (session start) {"version":"test","pid":1}`,
+		"<stdin>",
+		`<stderr> (session start) {"version":"test","pid":1}`,
+		"<stdin>", // not duplicate of request of previous session
+		"<stdout> (response to initialize id=1, 1s)",
+	}, headers)
+
+	index, err := BuildLogIndex(strings.NewReader(log), nil)
+	require.NoError(t, err)
+	selected := bytes.Buffer{}
+	require.NoError(t, PrintIndex(index, &selected, &PrintFilter{Ids: []PrintId{ParsePrintId("1")}}))
+	assert.Equal(t, 3, strings.Count(selected.String(), "2024-"))
+	assert.NotContains(t, selected.String(), "duplicate")
+}
+
+func TestTruncatePayload(t *testing.T) {
+	payload := `{"jsonrpc":"2.0","id":7,"method":"textDocument/didOpen","params":{"text":"あいう"}}`
+	d := LogData{streamType: STDIN, payloadType: JSON, payload: []byte(payload)}
+	truncatePayload(&d, len(payload)) // not truncated
+	assert.Equal(t, payload, string(d.payload))
+	assert.Zero(t, d.originalSize)
+
+	cut := strings.Index(payload, "い") + 1 // in the middle of character
+	truncatePayload(&d, cut)
+	assert.Equal(t, payload[:cut-1], string(d.payload))
+	assert.Equal(t, len(payload), d.originalSize)
+
+	e, err := ParseEnvelope(d.payload)
+	require.NoError(t, err)
+	assert.Equal(t, "textDocument/didOpen", e.Method)
+	assert.Equal(t, "7", string(e.Id))
+
+	// envelope after params is kept
+	d = LogData{streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"params":{"text":"` + strings.Repeat("x", 100) + `"},"id":8,"method":"textDocument/didOpen"}`)}
+	truncatePayload(&d, 60)
+	e, err = ParseEnvelope(d.payload)
+	require.NoError(t, err)
+	assert.Equal(t, "textDocument/didOpen", e.Method)
+	assert.Equal(t, "8", string(e.Id))
+
+	e, err = ParseEnvelope([]byte(`{"jsonrpc":"2.0","id":"x","result":[{"la`))
+	require.NoError(t, err)
+	assert.True(t, e.IsResponse())
+	assert.NotNil(t, e.Result)
+	_, err = ParseEnvelope([]byte(`{"jsonrpc":"2.0","me`))
+	assert.Error(t, err)
+	_, err = ParseEnvelope([]byte(`{"jsonrpc":"2.0",]`))
+	assert.Error(t, err) // broken, not truncated
+}
+
+func TestPrintTruncated(t *testing.T) {
+	request := LogData{streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"workspace/symbol","params":{"query":""}}`)}
+	response := LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"result":[{"name":"a"},{"name":"b"}]}`)}
+	truncatePayload(&response, 40)
+	log := newTestLog(request, response)
+	assert.Contains(t, log, `"size":61,"truncated":true,"original_size":61,"payload":`) // size of whole message
+	assert.Contains(t, log, `"id":1`)
+
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(), `<stdout> (response to workspace/symbol id=1, 1s, truncated, original 61 bytes)
+{"jsonrpc":"2.0","id":1,"result":[{"name
+`)
+
+	// text format and index keep original size
+	text := convertLog(t, log, LogFormatJSON, LogFormatText)
+	assert.Contains(t, text, "truncated=true original_size=61")
+	index, err := BuildLogIndex(strings.NewReader(log), nil)
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, PrintIndex(index, &out, &PrintFilter{Seqs: []int{2}}))
+	assert.Contains(t, out.String(), "truncated, original 61 bytes")
+}
+
+func TestPrintSynthetic(t *testing.T) {
+	log := newTestLog(LogData{streamType: STDIN, payloadType: JSON, payload: []byte(autoShutdownMessages[1]),
+		synthetic: true})
+	assert.Contains(t, log, `"synthetic":true,"payload":`)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.True(t, strings.HasPrefix(out.String(), "2024-05-01T10:00:00Z <stdin> (synthesized by recorder)\n"), out.String())
+
+	text := convertLog(t, log, LogFormatJSON, LogFormatText)
+	assert.Contains(t, text, "synthetic=true")
+	assert.Equal(t, log, convertLog(t, text, LogFormatText, LogFormatJSON))
+}
+
+func TestPrintIncomplete(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDOUT, payloadType: INCOMPLETE, payload: []byte(`{"jsonrpc":`), declaredSize: 100},
+		LogData{streamType: STDOUT, payloadType: RAW_END, payload: []byte("end of stream (89 of 100 bytes of payload missing)")},
+	)
+	assert.Contains(t, log, `"type":"incomplete","size":11,"declared_size":100,"payload":`)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.True(t, strings.HasPrefix(out.String(),
+		"2024-05-01T10:00:00Z <stdout> (incomplete message, received 11 of 100 bytes) {\"jsonrpc\":\n"), out.String())
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Color: true}))
+	assert.Contains(t, out.String(), ansiRed+`{"jsonrpc":`+ansiReset)
+
+	text := convertLog(t, log, LogFormatJSON, LogFormatText)
+	assert.Equal(t, log, convertLog(t, text, LogFormatText, LogFormatJSON))
+
+	trailer, err := ReadTrailer(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, 1, trailer.Summary.Incomplete)
+	out.Reset()
+	trailer.Summary.Format(&out, "")
+	assert.Contains(t, out.String(), "payloads: 0 json, 0 invalid, 1 incomplete\n")
+}
+
+func TestPrintInvalidJSON(t *testing.T) {
+	log := newTestLog(LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte(`{"ok":tr`), declaredSize: 8})
+	assert.Contains(t, log, `"type":"invalid","size":8,"declared_size":8,"payload":`)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Equal(t, "2024-05-01T10:00:00Z <stdout> (warning: payload is not valid JSON, Content-Length 8) {\"ok\":tr\n",
+		out.String())
+}
+
+func TestPrintUnformatted(t *testing.T) {
+	log := newTestLog( // mislabeled as JSON (e.g. log written by old version or edited by hand)
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"id":`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte("{\"text\":\"\xff\"}")},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Equal(t, "2024-05-01T10:00:00Z <stdout> (unformatted: unexpected end of JSON input)\n{\"id\":\n"+
+		"2024-05-01T10:00:01Z <stdout>\n{\n  \"text\": \"\xff\"\n}\n", out.String()) // invalid UTF-8 is restored from log
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Color: true}))
+	assert.Contains(t, out.String(), ansiRed+"(unformatted: unexpected end of JSON input)"+ansiReset+"\n{\"id\":\n")
+	assert.Contains(t, out.String(), "\xff")
+
+	out.Reset()
+	formatLogData(&out, &LogData{streamType: STDOUT, payloadType: JSON, payload: []byte("{\"text\":\"\xff\"}")}, "", "")
+	assert.Contains(t, out.String(), "\n{\n  \"text\": \"\xff\"\n}\n")
+}
+
+func TestPrintRedactedSize(t *testing.T) {
+	log := newTestLog(LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"initialized"}`),
+		size: 1000})
+	assert.Contains(t, log, `"size":1000,"payload":`)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(), "<stdin> (redacted, original 1000 bytes)\n")
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Output: PrintOutputCompact}))
+	assert.Equal(t, "2024-05-01T10:00:00Z --> notification initialized 1000B\n", out.String())
+}
+
+func TestPrintBatch(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`[{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"},` +
+			`{"jsonrpc":"2.0","method":"textDocument/didSave"}]`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`[{"jsonrpc":"2.0","id":1,"result":null}]`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`[]`)},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	var headers []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "2024-") {
+			_, header, _ := strings.Cut(line, " ")
+			headers = append(headers, header)
+		}
+	}
+	assert.Equal(t, []string{
+		"<stdin> (batch 1/2)",
+		"<stdin> (batch 2/2)",
+		"<stdout> (response to textDocument/hover id=1, 1s, batch 1/1)",
+		"<stdin> (warning: empty batch)",
+	}, headers)
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Methods: []string{"textDocument/didSave"}}))
+	assert.Equal(t, "2024-05-01T10:00:00Z <stdin> (batch 2/2)\n{\n  \"jsonrpc\": \"2.0\",\n  \"method\": \"textDocument/didSave\"\n}\n", out.String())
+}
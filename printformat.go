@@ -0,0 +1,138 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// output modes of print
+const (
+	PrintOutputPretty  = "pretty"  // human-readable records (JSON payload is indented)
+	PrintOutputJSON    = "json"    // one JSON object per record (see printRecord)
+	PrintOutputCompact = "compact" // one line per record (timestamp, direction, kind, method, id and size)
+)
+
+// printRecord is record of print --output json, written as one JSON object per line.
+// Fields are stable so that output can be processed by tools like jq
+type printRecord struct {
+	Seq       int             `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Stream    string          `json:"stream"`                   // stdin (client to server), stdout (server to client) or stderr
+	Type      string          `json:"type"`                     // payload type (json, raw, invalid, ...)
+	Kind      string          `json:"kind,omitempty"`           // request, response, notification or partial (see messageKind)
+	Method    string          `json:"method,omitempty"`         // method (method of the corresponding request if response or partial result)
+	Id        json.RawMessage `json:"id,omitempty"`             // id of request or response (id of the corresponding request if partial result)
+	LatencyMs *float64        `json:"latency_ms,omitempty"`     // time since the corresponding request (response or partial result)
+	Size      int             `json:"size"`                     // size of message at capture (whole payload)
+	Truncated bool            `json:"truncated,omitempty"`      // payload is prefix of whole payload
+	Note      string          `json:"note,omitempty"`           // note shown in pretty output (like "response to initialize id=1, 2s")
+	Problem   string          `json:"problem,omitempty"`        // category of record printed by --errors-only (see problemOf)
+	Server    int             `json:"server_session,omitempty"` // server session of server restarted on exit
+	Payload   json.RawMessage `json:"payload,omitempty"`        // JSON value if payload is whole JSON, otherwise string (omitted if collapsed)
+}
+
+// messageKind returns kind of JSON-RPC message (empty if record is not JSON-RPC message)
+func messageKind(e *Envelope, p pairing) string {
+	switch {
+	case e == nil:
+		return ""
+	case p.partial:
+		return "partial"
+	case e.IsRequest():
+		return "request"
+	case e.IsNotification():
+		return "notification"
+	default:
+		return "response"
+	}
+}
+
+// messageId returns id of message (id of the corresponding request if partial result)
+func messageId(e *Envelope, p pairing) json.RawMessage {
+	if p.partial && p.request != nil {
+		return p.request.id
+	}
+	if e == nil || isNullOrEmpty(e.Id) {
+		return nil
+	}
+	return e.Id
+}
+
+// hasJSONPayload reports whether payload of record is JSON value
+func hasJSONPayload(d *LogData) bool {
+	switch d.payloadType {
+	case JSON, SESSION_START, META, TRAILER:
+		return d.originalSize == 0 && json.Valid(d.payload)
+	default:
+		return false
+	}
+}
+
+// formatJSONRecord writes record as printRecord. Payload is omitted if collapse is set.
+// problem is category of --errors-only (empty if not printed by it)
+func formatJSONRecord(writer io.Writer, d *LogData, e *Envelope, p pairing, collapse bool, problem string) {
+	r := printRecord{
+		Seq:       d.seq,
+		Time:      d.timestamp,
+		Stream:    d.streamType.String(),
+		Type:      d.payloadType.String(),
+		Kind:      messageKind(e, p),
+		Id:        messageId(e, p),
+		Size:      d.messageSize(),
+		Truncated: d.originalSize > 0,
+		Note:      withRecordNote(d, p.note),
+		Problem:   problem,
+		Server:    d.serverSession,
+	}
+	if e != nil {
+		r.Method = p.method
+	}
+	if p.request != nil {
+		latency := float64(d.recordTime().Sub(p.request.at)) / float64(time.Millisecond)
+		r.LatencyMs = &latency
+	}
+	if !collapse {
+		if hasJSONPayload(d) {
+			r.Payload = d.payload
+		} else {
+			r.Payload, _ = json.Marshal(string(d.payload))
+		}
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(&r)
+}
+
+// directionArrows are directions of streams in compact output
+var directionArrows = map[StreamType]string{
+	STDIN:  "-->",
+	STDOUT: "<--",
+	STDERR: "ERR",
+}
+
+// formatCompactRecord writes record in single line like "2024-05-01T10:00:01Z --> request initialize id=1 46B"
+// (stamp is timestamp of record). Latency is appended to response and partial result.
+// Kind of non-JSON-RPC record is its payload type
+func formatCompactRecord(writer io.Writer, d *LogData, stamp string, e *Envelope, p pairing) {
+	size := d.messageSize()
+	_, _ = fmt.Fprintf(writer, "%s %s ", stamp, directionArrows[d.streamType])
+	if kind := messageKind(e, p); kind == "" {
+		_, _ = fmt.Fprintf(writer, "%s", d.payloadType)
+	} else {
+		method := p.method
+		if method == "" {
+			method = "-" // response to unknown request
+		}
+		_, _ = fmt.Fprintf(writer, "%s %s", kind, method)
+	}
+	if id := messageId(e, p); id != nil {
+		_, _ = fmt.Fprintf(writer, " id=%s", idKey(id))
+	}
+	_, _ = fmt.Fprintf(writer, " %s", formatSize(size))
+	if p.request != nil {
+		_, _ = fmt.Fprintf(writer, " %s", formatLatency(d.recordTime().Sub(p.request.at)))
+	}
+	_, _ = writer.Write([]byte("\n"))
+}
@@ -0,0 +1,197 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintJSONOutput(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(printTestLog), &out, &PrintFilter{Output: PrintOutputJSON}))
+	assert.Equal(t, `{"seq":1,"time":"2024-05-01T10:00:00Z","stream":"stderr","type":"raw","size":14,"payload":"run: server []"}
+{"seq":2,"time":"2024-05-01T10:00:01Z","stream":"stdin","type":"json","kind":"request","method":"initialize","id":1,"size":46,"payload":{"jsonrpc":"2.0","id":1,"method":"initialize"}}
+{"seq":3,"time":"2024-05-01T10:00:02Z","stream":"stdout","type":"invalid","size":30,"payload":"invalid message header: 'hoge'"}
+{"seq":4,"time":"2024-05-01T10:00:03Z","stream":"stdout","type":"json","kind":"response","method":"initialize","id":1,"latency_ms":2000,"size":36,"note":"response to initialize id=1, 2s","payload":{"jsonrpc":"2.0","id":1,"result":{}}}
+`, out.String())
+
+	out.Reset() // filters compose with output mode
+	filter := &PrintFilter{Output: PrintOutputJSON, Methods: []string{"initialize"}, MatchResponses: true}
+	require.NoError(t, Print(strings.NewReader(printTestLog), &out, filter))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		var r printRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &r))
+		assert.Equal(t, "initialize", r.Method)
+	}
+}
+
+func TestPrintJSONOutputPartial(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":"a","method":"workspace/symbol","params":{"partialResultToken":"t"}}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"t","value":[]}}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":"a","result":[]`), originalSize: 100},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Output: PrintOutputJSON, CollapsePartials: true}))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[1], `"kind":"partial","method":"workspace/symbol","id":"a","latency_ms":1000,`)
+	assert.NotContains(t, lines[1], `"payload"`)
+	assert.Contains(t, lines[2], `"size":100,"truncated":true,`)
+	assert.Contains(t, lines[2], `"payload":"{\"jsonrpc\":\"2.0\",\"id\":\"a\",\"result\":[]"}`) // truncated JSON is string
+}
+
+func TestPrintCompactOutput(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"initialized"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":9,"result":null}`)},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("server log")},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Output: PrintOutputCompact}))
+	assert.Equal(t, `2024-05-01T10:00:00Z --> request initialize id=1 46B
+2024-05-01T10:00:01Z <-- response initialize id=1 36B 1s
+2024-05-01T10:00:02Z --> notification initialized 40B
+2024-05-01T10:00:03Z <-- response - id=9 38B
+2024-05-01T10:00:04Z ERR raw 10B
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Output: PrintOutputCompact, Streams: []StreamType{STDIN}}))
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+
+	index, err := BuildLogIndex(strings.NewReader(log), nil)
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, PrintIndex(index, &out, &PrintFilter{Output: PrintOutputCompact, Seqs: []int{2}}))
+	assert.Equal(t, "2024-05-01T10:00:01Z <-- response initialize id=1 36B 1s\n", out.String())
+}
+
+func TestPrintLatencyElapsed(t *testing.T) {
+	// clock is set back by 10s between request and response, but elapsed time is monotonic
+	log := newTestLog(
+		LogData{timestamp: time.Date(2024, 5, 1, 10, 0, 10, 0, time.UTC), elapsed: time.Second, streamType: STDIN,
+			payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{timestamp: time.Date(2024, 5, 1, 10, 0, 0, 500_000_000, time.UTC), elapsed: 1500 * time.Millisecond,
+			streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+	)
+	assert.Contains(t, log, `"elapsed_ns":1500000000`)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Output: PrintOutputCompact}))
+	assert.Contains(t, out.String(), "<-- response initialize id=1 36B 500ms\n")
+
+	// log without elapsed time falls back to wall clock
+	log = newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`), elapsed: time.Hour},
+	)
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Output: PrintOutputCompact}))
+	assert.Contains(t, out.String(), "<-- response initialize id=1 36B 1s\n")
+}
+
+func TestPrintTimestamps(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("a"), timestamp: base},
+		LogData{streamType: STDIN, payloadType: RAW, payload: []byte("b"), timestamp: base.Add(3 * time.Millisecond)},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("c"), timestamp: base.Add(12456789 * time.Microsecond)},
+		LogData{streamType: STDIN, payloadType: RAW, payload: []byte("d"), timestamp: base.Add(20 * time.Second)},
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Timestamps: PrintTimestampRelative}))
+	assert.Equal(t, `    +0.000000s <stderr> a
+    +0.003000s <stdin> b
+   +12.456789s <stderr> c
+   +20.000000s <stdin> d
+`, out.String())
+
+	out.Reset() // delta from previous printed record
+	filter := &PrintFilter{Timestamps: PrintTimestampDelta, Streams: []StreamType{STDIN}}
+	require.NoError(t, Print(strings.NewReader(log), &out, filter))
+	assert.Equal(t, `    +0.000000s <stdin> b
+   +19.997000s <stdin> d
+`, out.String())
+
+	out.Reset()
+	filter = &PrintFilter{Timestamps: PrintTimestampRelative, Output: PrintOutputCompact, Seqs: []int{3}}
+	require.NoError(t, Print(strings.NewReader(log), &out, filter))
+	assert.Equal(t, "   +12.456789s ERR raw 1B\n", out.String())
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Timestamps: PrintTimestampAbsolute, Seqs: []int{1}}))
+	assert.Equal(t, "2024-05-01T10:00:00Z <stderr> a\n", out.String())
+}
+
+func TestPrintHeadTail(t *testing.T) {
+	var data []LogData
+	for i := 0; i < 10; i++ {
+		stream := STDIN
+		if i%2 == 1 {
+			stream = STDERR
+		}
+		data = append(data, LogData{streamType: stream, payloadType: RAW, payload: []byte(fmt.Sprintf("r%d", i))})
+	}
+	log := newTestLog(data...)
+	print := func(filter *PrintFilter) string {
+		out := bytes.Buffer{}
+		require.NoError(t, Print(strings.NewReader(log), &out, filter))
+		var payloads []string
+		for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+			payloads = append(payloads, line[strings.LastIndex(line, " ")+1:])
+		}
+		return strings.Join(payloads, ",")
+	}
+	assert.Equal(t, "r0,r1,r2", print(&PrintFilter{Head: 3}))
+	assert.Equal(t, "r7,r8,r9", print(&PrintFilter{Tail: 3}))
+	assert.Equal(t, "r0,r1,r2,r3,r4,r5,r6,r7,r8,r9", print(&PrintFilter{Tail: 20}))
+	stdin := []StreamType{STDIN} // head/tail of filtered records
+	assert.Equal(t, "r0,r2", print(&PrintFilter{Head: 2, Streams: stdin}))
+	assert.Equal(t, "r4,r6,r8", print(&PrintFilter{Tail: 3, Streams: stdin}))
+
+	index, err := BuildLogIndex(strings.NewReader(log), nil)
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	require.NoError(t, PrintIndex(index, &out, &PrintFilter{Tail: 1, Seqs: []int{2, 3, 4}}))
+	assert.Equal(t, "2024-05-01T10:00:03Z <stderr> r3\n", out.String())
+}
+
+func TestPrintSkipLimit(t *testing.T) {
+	var data []LogData
+	for i := 0; i < 10; i++ {
+		stream := STDIN
+		if i%2 == 1 {
+			stream = STDERR
+		}
+		data = append(data, LogData{streamType: stream, payloadType: RAW, payload: []byte(fmt.Sprintf("r%d", i))})
+	}
+	log := newTestLog(data...)
+	print := func(filter *PrintFilter) string {
+		out := bytes.Buffer{}
+		require.NoError(t, Print(strings.NewReader(log), &out, filter))
+		return out.String()
+	}
+	filter := &PrintFilter{Skip: 2, Limit: 2, Streams: []StreamType{STDIN}, ShowIndex: true, Output: PrintOutputCompact}
+	assert.Equal(t, "#5 2024-05-01T10:00:04Z --> raw 2B\n#7 2024-05-01T10:00:06Z --> raw 2B\n", print(filter))
+	assert.Equal(t, "#10 2024-05-01T10:00:09Z <stderr> r9\n", print(&PrintFilter{Skip: 9, ShowIndex: true}))
+	assert.Equal(t, "2024-05-01T10:00:08Z <stdin> r8\n", print(&PrintFilter{Skip: 3, Tail: 1, Streams: []StreamType{STDIN}}))
+	assert.Empty(t, print(&PrintFilter{Skip: 10}))
+	assert.Empty(t, print(&PrintFilter{Skip: 100, Limit: 5}))
+
+	// record without seq is numbered in order
+	legacy := `{"time":"2024-05-01T10:00:00Z","stream":"stderr","type":"raw","payload":"a"}
+{"time":"2024-05-01T10:00:01Z","stream":"stderr","type":"raw","payload":"b"}
+`
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(legacy), &out, &PrintFilter{ShowIndex: true, Skip: 1}))
+	assert.Equal(t, "#2 2024-05-01T10:00:01Z <stderr> b\n", out.String())
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// ShutdownSignals are signals which shut down session (forwarded to Language Server)
+var ShutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// setProcessGroup makes command leader of new process group, so that helpers forked by it are killed together
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// trackProcessTree returns nil, since process group is killed by killProcessGroup, and pipes kept open by
+// remaining descendants can be closed to unblock readers
+func trackProcessTree(*os.Process) io.Closer {
+	return nil
+}
+
+// signalProcess delivers signal to the process
+func signalProcess(process *os.Process, sig os.Signal) error {
+	return process.Signal(sig)
+}
+
+// killProcessGroup kills process group led by the process (only the process if it is not group leader)
+func killProcessGroup(process *os.Process) error {
+	if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err == nil {
+		return nil
+	}
+	return process.Kill()
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunContextLeavesSignals(t *testing.T) {
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, syscall.SIGINT) // embedding program handles its own signals
+	defer signal.Stop(caught)
+	buf := &syncBuffer{}
+	clientIn, _ := io.Pipe() // client keeps connection open
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(100 * time.Millisecond) // session is started
+		_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+		<-caught
+		time.Sleep(100 * time.Millisecond) // not forwarded to server meanwhile
+		cancel()
+	}()
+	status, err := RunContext(ctx, "sh", []string{"-c", "exec sleep 10"}, NewLogger(buf), RunOptions{NoEnv: true,
+		ClientIn: clientIn, ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.True(t, status.CutShort)
+	assert.Equal(t, syscall.SIGTERM, status.Signal)
+	assert.NotContains(t, buf.String(), "forward signal: interrupt")
+	assert.NotContains(t, buf.String(), signalEndPrefix)
+}
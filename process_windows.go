@@ -0,0 +1,128 @@
+//go:build windows
+
+package recorder
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// ShutdownSignals are signals which shut down session. Ctrl+C and Ctrl+Break are delivered as os.Interrupt,
+// and close, logoff and shutdown events of console are delivered as SIGTERM
+var ShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+var (
+	procCreateJobObjectW         = modkernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = modkernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = modkernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = modkernel32.NewProc("TerminateJobObject")
+)
+
+const (
+	jobObjectExtendedLimitInformation = 9          // JobObjectExtendedLimitInformation class
+	jobObjectLimitKillOnJobClose      = 0x00002000 // JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	processSetQuota                   = 0x0100     // PROCESS_SET_QUOTA
+	processTerminate                  = 0x0001     // PROCESS_TERMINATE
+)
+
+// jobObjectExtendedLimit is JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+type jobObjectExtendedLimit struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+	IoInfo                  [6]uint64 // IO_COUNTERS
+	ProcessMemoryLimit      uintptr
+	JobMemoryLimit          uintptr
+	PeakProcessMemoryUsed   uintptr
+	PeakJobMemoryUsed       uintptr
+}
+
+// processJobs are job objects of started processes by pid (see trackProcessTree)
+var processJobs sync.Map
+
+// processJob is job object having started process and its descendants
+type processJob struct {
+	pid    int
+	handle syscall.Handle
+	once   sync.Once
+}
+
+// Close kills processes remaining in job, so that pipes kept open by them are closed. Pending read of pipe
+// cannot be canceled on Windows, so readers of output would never end otherwise
+func (j *processJob) Close() error {
+	var err error
+	j.once.Do(func() {
+		processJobs.Delete(j.pid)
+		err = syscall.CloseHandle(j.handle) // processes are killed by JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	})
+	return err
+}
+
+// setProcessGroup does nothing, since new process group on Windows stops delivery of Ctrl+C
+func setProcessGroup(*exec.Cmd) {
+}
+
+// trackProcessTree puts started process into job object, so that its descendants (created after this) are killed
+// together by killProcessGroup. Returned closer kills descendants remaining after its exit (nil if job object is
+// not available, e.g. nested job is not allowed)
+func trackProcessTree(process *os.Process) io.Closer {
+	h, _, _ := procCreateJobObjectW.Call(0, 0)
+	if h == 0 {
+		return nil
+	}
+	job := &processJob{pid: process.Pid, handle: syscall.Handle(h)}
+	limit := jobObjectExtendedLimit{LimitFlags: jobObjectLimitKillOnJobClose}
+	if r, _, _ := procSetInformationJobObject.Call(h, jobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&limit)), unsafe.Sizeof(limit)); r == 0 {
+		_ = syscall.CloseHandle(job.handle)
+		return nil
+	}
+	p, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(process.Pid))
+	if err != nil {
+		_ = syscall.CloseHandle(job.handle)
+		return nil
+	}
+	defer func(p syscall.Handle) {
+		_ = syscall.CloseHandle(p)
+	}(p)
+	if r, _, _ := procAssignProcessToJobObject.Call(h, uintptr(p)); r == 0 {
+		_ = syscall.CloseHandle(job.handle)
+		return nil
+	}
+	processJobs.Store(process.Pid, job)
+	return job
+}
+
+// errSignalUnsupported is returned by signalProcess for signal other than os.Kill
+var errSignalUnsupported = errors.New("signal cannot be delivered to process on Windows")
+
+// signalProcess delivers signal to the process. Only os.Kill is supported on Windows, so caller shuts down
+// server via stdin instead (see forwardSignal)
+func signalProcess(process *os.Process, sig os.Signal) error {
+	if sig == os.Kill {
+		return process.Kill()
+	}
+	return errSignalUnsupported
+}
+
+// killProcessGroup kills the process and its descendants in job object (only the process if not tracked)
+func killProcessGroup(process *os.Process) error {
+	if v, ok := processJobs.Load(process.Pid); ok {
+		job := v.(*processJob)
+		if r, _, _ := procTerminateJobObject.Call(uintptr(job.handle), 1); r != 0 {
+			return nil
+		}
+	}
+	return process.Kill()
+}
@@ -0,0 +1,328 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Query selects records by parsed JSON payload. Supported subset of JSONPath/jq-like expression:
+//
+//	.                          whole payload
+//	.params.context            field access (also .["key"] for key which is not identifier like "$/progress")
+//	.params.items[0]           array index
+//	"text", 42, true, null     literals (JSON)
+//	== != < <= > >=            comparison (ordering is defined only between numbers or between strings)
+//	&& || ! ( )                logical operators and grouping
+//
+// Missing field is null. Expression matches if it evaluates to value other than null and false,
+// so bare path like .error means "has error". Examples:
+//
+//	.method == "textDocument/completion" && .params.context.triggerKind == 2
+//	.id != null && .method == null && .result == null
+type Query struct {
+	source string
+	root   queryNode
+}
+
+type queryNode interface {
+	eval(v any) any
+}
+
+// queryPath is path of field names (string) and array indices (int)
+type queryPath []any
+
+func (p queryPath) eval(v any) any {
+	for _, seg := range p {
+		switch seg := seg.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil
+			}
+			v = m[seg]
+		case int:
+			a, ok := v.([]any)
+			if !ok || seg >= len(a) {
+				return nil
+			}
+			v = a[seg]
+		}
+	}
+	return v
+}
+
+type queryLiteral struct {
+	value any
+}
+
+func (l *queryLiteral) eval(any) any {
+	return l.value
+}
+
+type queryBinary struct {
+	op          string
+	left, right queryNode
+}
+
+func (b *queryBinary) eval(v any) any {
+	switch b.op {
+	case "&&":
+		return truthy(b.left.eval(v)) && truthy(b.right.eval(v))
+	case "||":
+		return truthy(b.left.eval(v)) || truthy(b.right.eval(v))
+	}
+	l, r := b.left.eval(v), b.right.eval(v)
+	switch b.op {
+	case "==":
+		return reflect.DeepEqual(l, r)
+	case "!=":
+		return !reflect.DeepEqual(l, r)
+	}
+	c, ok := compareValues(l, r)
+	if !ok {
+		return false
+	}
+	switch b.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default: // >=
+		return c >= 0
+	}
+}
+
+type queryNot struct {
+	operand queryNode
+}
+
+func (n *queryNot) eval(v any) any {
+	return !truthy(n.operand.eval(v))
+}
+
+func truthy(v any) bool {
+	return v != nil && v != false
+}
+
+// compareValues compares numbers or strings. Returns false if they are not comparable
+func compareValues(l, r any) (int, bool) {
+	switch l := l.(type) {
+	case float64:
+		if r, ok := r.(float64); ok {
+			switch {
+			case l < r:
+				return -1, true
+			case l > r:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	case string:
+		if r, ok := r.(string); ok {
+			return strings.Compare(l, r), true
+		}
+	}
+	return 0, false
+}
+
+// ParseQuery parses query expression (see Query for supported subset)
+func ParseQuery(s string) (*Query, error) {
+	p := &queryParser{src: s}
+	p.next()
+	root := p.parseOr()
+	if p.err == nil && p.tok != "" {
+		p.fail("unexpected '%s'", p.tok)
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("invalid query '%s': %v", s, p.err)
+	}
+	return &Query{source: s, root: root}, nil
+}
+
+// Match reports whether JSON payload matches query. Payload which is not valid JSON never matches
+func (q *Query) Match(payload []byte) bool {
+	var v any
+	if json.Unmarshal(payload, &v) != nil {
+		return false
+	}
+	return truthy(q.root.eval(v))
+}
+
+func (q *Query) String() string {
+	return q.source
+}
+
+// queryParser is recursive descent parser of query. Token is operator, punctuation, identifier,
+// number or JSON string literal (empty at end of input)
+type queryParser struct {
+	src string
+	pos int    // position after current token
+	tok string // current token
+	at  int    // position of current token
+	err error  // first error
+}
+
+const queryOperatorChars = "=!<>&|"
+
+func (p *queryParser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf("at %d: %s", p.at+1, fmt.Sprintf(format, args...))
+	}
+}
+
+func isQueryIdentChar(c byte, first bool) bool {
+	return c == '_' || c == '$' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || (!first && '0' <= c && c <= '9')
+}
+
+// next reads next token
+func (p *queryParser) next() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	p.at = p.pos
+	if p.pos == len(p.src) {
+		p.tok = ""
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.IndexByte(".[]()", c) >= 0:
+		p.pos++
+	case strings.IndexByte(queryOperatorChars, c) >= 0:
+		for p.pos < len(p.src) && strings.IndexByte(queryOperatorChars, p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+	case c == '"':
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] != '"'; p.pos++ {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		if p.pos >= len(p.src) {
+			p.fail("unterminated string")
+			p.pos = len(p.src)
+		} else {
+			p.pos++
+		}
+	case c == '-' || ('0' <= c && c <= '9'):
+		for p.pos++; p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0; p.pos++ {
+		}
+	case isQueryIdentChar(c, true):
+		for p.pos++; p.pos < len(p.src) && isQueryIdentChar(p.src[p.pos], false); p.pos++ {
+		}
+	default:
+		p.fail("unexpected character '%c'", c)
+		p.pos = len(p.src)
+	}
+	p.tok = p.src[p.at:p.pos]
+}
+
+func (p *queryParser) parseOr() queryNode {
+	left := p.parseAnd()
+	for p.err == nil && p.tok == "||" {
+		p.next()
+		left = &queryBinary{op: "||", left: left, right: p.parseAnd()}
+	}
+	return left
+}
+
+func (p *queryParser) parseAnd() queryNode {
+	left := p.parseComparison()
+	for p.err == nil && p.tok == "&&" {
+		p.next()
+		left = &queryBinary{op: "&&", left: left, right: p.parseComparison()}
+	}
+	return left
+}
+
+func (p *queryParser) parseComparison() queryNode {
+	left := p.parseUnary()
+	switch p.tok {
+	case "==", "!=", "<", "<=", ">", ">=":
+		op := p.tok
+		p.next()
+		return &queryBinary{op: op, left: left, right: p.parseUnary()}
+	}
+	return left
+}
+
+func (p *queryParser) parseUnary() queryNode {
+	switch {
+	case p.err != nil:
+		return nil
+	case p.tok == "!":
+		p.next()
+		return &queryNot{operand: p.parseUnary()}
+	case p.tok == "(":
+		p.next()
+		node := p.parseOr()
+		if p.err == nil && p.tok != ")" {
+			p.fail("')' is expected")
+		}
+		p.next()
+		return node
+	case p.tok == ".":
+		return p.parsePath()
+	case p.tok == "":
+		p.fail("unexpected end of query")
+		return nil
+	}
+	var value any
+	if json.Unmarshal([]byte(p.tok), &value) != nil {
+		p.fail("unknown literal '%s' (path must start with '.')", p.tok)
+		return nil
+	}
+	p.next()
+	return &queryLiteral{value: value}
+}
+
+func (p *queryParser) parsePath() queryNode {
+	var path queryPath
+	p.next() // skip leading '.'
+	if p.tok != "" && isQueryIdentChar(p.tok[0], true) {
+		path = append(path, p.tok)
+		p.next()
+	}
+	for p.err == nil {
+		switch p.tok {
+		case ".":
+			p.next()
+			if p.tok == "" || !isQueryIdentChar(p.tok[0], true) {
+				p.fail("field name is expected after '.'")
+				return nil
+			}
+			path = append(path, p.tok)
+			p.next()
+		case "[":
+			p.next()
+			if strings.HasPrefix(p.tok, "\"") {
+				var key string
+				if json.Unmarshal([]byte(p.tok), &key) != nil {
+					p.fail("invalid string %s", p.tok)
+					return nil
+				}
+				path = append(path, key)
+			} else if index, err := strconv.Atoi(p.tok); err == nil && index >= 0 {
+				path = append(path, index)
+			} else {
+				p.fail("array index or string key is expected in '[]'")
+				return nil
+			}
+			p.next()
+			if p.tok != "]" {
+				p.fail("']' is expected")
+				return nil
+			}
+			p.next()
+		default:
+			return path
+		}
+	}
+	return nil
+}
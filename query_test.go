@@ -0,0 +1,85 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	completion := `{"jsonrpc":"2.0","id":3,"method":"textDocument/completion","params":{"context":{"triggerKind":2,"triggerCharacter":"."},"items":[{"label":"a"}]}}`
+	progress := `{"jsonrpc":"2.0","method":"$/progress","params":{"token":"t","value":{"kind":"end"}}}`
+	nullResult := `{"jsonrpc":"2.0","id":3,"result":null}`
+	cases := []struct {
+		query    string
+		payload  string
+		expected bool
+	}{
+		{`.method == "textDocument/completion" && .params.context.triggerKind == 2`, completion, true},
+		{`.method == "textDocument/completion" && .params.context.triggerKind == 1`, completion, false},
+		{`.params.context.triggerKind >= 2 && .params.context.triggerKind < 3`, completion, true},
+		{`.params.items[0].label == "a"`, completion, true},
+		{`.params.items[1].label == "a"`, completion, false},
+		{`.params.context.triggerCharacter > "-"`, completion, true},
+		{`.params.context.triggerCharacter > 1`, completion, false}, // not comparable
+		{`.["method"] == "$/progress" && .params.value.kind == "end"`, progress, true},
+		{`.id != null && .method == null && .result == null`, nullResult, true},
+		{`.id != null && .method == null && .result == null`, completion, false},
+		{`.params`, completion, true},
+		{`.error`, nullResult, false},
+		{`!.error`, nullResult, true},
+		{`.id == 3 && (.method == "x" || .result == null)`, nullResult, true},
+		{`. != null`, nullResult, true},
+		{`.id == 3`, `{"id":`, false}, // unparseable payload never matches
+	}
+	for _, c := range cases {
+		q, err := ParseQuery(c.query)
+		require.NoError(t, err, c.query)
+		assert.Equal(t, c.expected, q.Match([]byte(c.payload)), "%s on %s", c.query, c.payload)
+	}
+}
+
+func TestParseQueryError(t *testing.T) {
+	cases := map[string]string{
+		``:                      "invalid query '': at 1: unexpected end of query",
+		`.method ==`:            "invalid query '.method ==': at 11: unexpected end of query",
+		`method == "a"`:         "invalid query 'method == \"a\"': at 1: unknown literal 'method' (path must start with '.')",
+		`.a = 1`:                "invalid query '.a = 1': at 4: unexpected '='",
+		`.a[x]`:                 "invalid query '.a[x]': at 4: array index or string key is expected in '[]'",
+		`.a. == 1`:              "invalid query '.a. == 1': at 5: field name is expected after '.'",
+		`(.a == 1`:              "invalid query '(.a == 1': at 9: ')' is expected",
+		`.a == "x`:              "invalid query '.a == \"x': at 7: unterminated string",
+		`.a == 1 .b`:            "invalid query '.a == 1 .b': at 9: unexpected '.'",
+		`.a ~ 1`:                "invalid query '.a ~ 1': at 4: unexpected character '~'",
+		`.a == [1]`:             "invalid query '.a == [1]': at 7: unknown literal '[' (path must start with '.')", // only scalar literals
+		`.a == 1 && .b == 2 ||`: "invalid query '.a == 1 && .b == 2 ||': at 22: unexpected end of query",
+	}
+	for query, expected := range cases {
+		_, err := ParseQuery(query)
+		assert.EqualError(t, err, expected, query)
+	}
+}
+
+func TestPrintQuery(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte(`{"id":1}`)}, // not JSON message
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)},
+	)
+	query, err := ParseQuery(`.id == 1 && .result == null`)
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Query: query, Output: PrintOutputCompact}))
+	assert.Equal(t, "2024-05-01T10:00:01Z --> request initialize id=1 46B\n"+
+		"2024-05-01T10:00:02Z <-- response initialize id=1 38B 1s\n", out.String())
+
+	query, err = ParseQuery(`.result == null && .method == null`)
+	require.NoError(t, err)
+	index, err := BuildLogIndex(strings.NewReader(log), nil)
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, PrintIndex(index, &out, &PrintFilter{Query: query, Ids: []PrintId{ParsePrintId("1")}, Output: PrintOutputCompact}))
+	assert.Equal(t, "2024-05-01T10:00:02Z <-- response initialize id=1 38B 1s\n", out.String())
+}
@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// isRawChunk reports whether record is chunk of stdin/stdout captured by RunOptions.Raw
+func isRawChunk(d *LogData) bool {
+	return d.payloadType == RAW && d.streamType != STDERR
+}
+
+// rawAssembler reconstructs messages of a stream from its raw chunks, like intercept does while recording
+type rawAssembler struct {
+	parser *ContentHeaderParser
+	buf    bytes.Buffer
+	length int   // Content-Length of current message (-1 while parsing header)
+	next   int64 // expected offset of next chunk
+	resync bool  // skipping data until next header
+}
+
+func newRawAssembler() *rawAssembler {
+	return &rawAssembler{parser: NewContentHeaderParser(), length: -1}
+}
+
+// message returns record of reconstructed message at time of chunk d
+func (a *rawAssembler) message(d *LogData, payloadType PayloadType, payload []byte) *LogData {
+	return &LogData{seq: d.seq, timestamp: d.timestamp, streamType: d.streamType, payloadType: payloadType,
+		payload: payload, source: d.source}
+}
+
+// feed appends chunk and returns messages completed by it. Broken header, payload which is not JSON and
+// lost chunks (e.g. dropped records) are returned as INVALID records, and then next header is searched
+func (a *rawAssembler) feed(d *LogData) []*LogData {
+	var messages []*LogData
+	if d.offset != a.next {
+		messages = append(messages, a.message(d, INVALID,
+			[]byte(fmt.Sprintf("raw chunks of %d bytes are missing before offset %d", d.offset-a.next, d.offset))))
+		a.buf.Reset()
+		a.parser.reset()
+		a.length = -1
+		a.resync = true
+	}
+	a.next = d.offset + int64(len(d.payload))
+	a.buf.Write(d.payload)
+	for {
+		if a.resync {
+			if !skipToHeader(&a.buf) {
+				return messages
+			}
+			a.resync = false
+		}
+		if a.length < 0 {
+			n, err := a.parser.Parse(&a.buf)
+			if errors.Is(err, io.EOF) {
+				return messages
+			}
+			if err != nil {
+				messages = append(messages, a.message(d, INVALID, []byte(err.Error())))
+				a.resync = true
+				continue
+			}
+			a.length = n
+		}
+		if a.buf.Len() < a.length {
+			return messages
+		}
+		payload := bytes.Clone(a.buf.Next(a.length))
+		m := a.message(d, JSON, payload)
+		if !json.Valid(payload) { // e.g. miscounted Content-Length
+			m.payloadType, m.declaredSize = INVALID, a.length
+		}
+		messages = append(messages, m)
+		a.length = -1
+	}
+}
+
+// finish returns INCOMPLETE record of message interrupted by end of stream (nil if not interrupted)
+func (a *rawAssembler) finish(d *LogData) *LogData {
+	if a.length < 0 {
+		return nil
+	}
+	m := a.message(d, INCOMPLETE, bytes.Clone(a.buf.Bytes()))
+	m.declaredSize = a.length
+	return m
+}
+
+// rawReassembler replaces raw chunks of stdin/stdout with messages reconstructed from them
+type rawReassembler struct {
+	streams map[StreamType]*rawAssembler
+}
+
+// records returns records replacing d (d itself if it is not raw chunk)
+func (r *rawReassembler) records(d *LogData) []*LogData {
+	if r.streams == nil {
+		r.streams = map[StreamType]*rawAssembler{}
+	}
+	switch {
+	case isRawChunk(d):
+		a := r.streams[d.streamType]
+		if a == nil {
+			a = newRawAssembler()
+			r.streams[d.streamType] = a
+		}
+		return a.feed(d)
+	case d.payloadType == RAW_END && r.streams[d.streamType] != nil:
+		a := r.streams[d.streamType]
+		delete(r.streams, d.streamType)
+		if m := a.finish(d); m != nil {
+			return []*LogData{m, d}
+		}
+	case d.payloadType == SESSION_START: // streams of appended session start from offset 0
+		clear(r.streams)
+	}
+	return []*LogData{d}
+}
@@ -0,0 +1,56 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestRawReassembler(t *testing.T) {
+	chunk := func(offset int64, payload string) *LogData {
+		return &LogData{streamType: STDOUT, payloadType: RAW, payload: []byte(payload), offset: offset}
+	}
+	reassemble := func(r *rawReassembler, d *LogData) []string {
+		var out []string
+		for _, m := range r.records(d) {
+			out = append(out, m.payloadType.String()+" "+string(m.payload))
+		}
+		return out
+	}
+	r := rawReassembler{}
+	assert.Empty(t, reassemble(&r, chunk(0, "Content-Len")))
+	assert.Equal(t, []string{"json {}"}, reassemble(&r, chunk(11, "gth: 2\r\n\r\n{}Content-Length: 3\r\n\r\n[1")))
+	assert.Equal(t, []string{"json [1]", "invalid invalid message header: 'ontent-length: 2\r\n\r\n{}Content-Length: 2\r\n\r\nab'",
+		"invalid ab"}, reassemble(&r, chunk(46, "]content-length: 2\r\n\r\n{}Content-Length: 2\r\n\r\nab")))
+	assert.Equal(t, []string{"invalid raw chunks of 12 bytes are missing before offset 105"},
+		reassemble(&r, chunk(105, "}Content-Length: 5\r\n\r\n[")))
+	assert.Equal(t, []string{"incomplete [", "raw_end end of stream"},
+		reassemble(&r, &LogData{streamType: STDOUT, payloadType: RAW_END, payload: []byte(endOfStream)}))
+
+	stdin := chunk(0, "Content-Length: 2\r\n\r\n{}")
+	stdin.streamType = STDIN
+	assert.Equal(t, []string{"json {}"}, reassemble(&r, stdin)) // streams are independent
+	stderr := &LogData{streamType: STDERR, payloadType: RAW, payload: []byte("Content-Length: 2\r\n\r\n{}")}
+	assert.Equal(t, []string{"raw Content-Length: 2\r\n\r\n{}"}, reassemble(&r, stderr))
+}
+
+func TestRunRaw(t *testing.T) {
+	buf := &syncBuffer{}
+	stdout := &bytes.Buffer{}
+	// header with wrong case and miscounted Content-Length
+	script := `cat > /dev/null; printf 'content-length: 2\r\n\r\n{}Content-Length: 3\r\n\r\n{}'`
+	status, err := Run("sh", []string{"-c", script}, NewLogger(buf), RunOptions{NoEnv: true, Raw: true,
+		AutoShutdown: true, ClientIn: strings.NewReader("Content-Length: 2\r\n\r\n{}"), ClientOut: stdout})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Code)
+	assert.Equal(t, "content-length: 2\r\n\r\n{}Content-Length: 3\r\n\r\n{}", stdout.String()) // verbatim
+	assert.Contains(t, buf.String(), `"stream":"stdin","type":"raw","size":23,"payload":"Content-Length: 2\r\n\r\n{}"`)
+	assert.NotContains(t, buf.String(), `"synthetic":true`) // auto shutdown is disabled
+
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(buf.String()), &out,
+		&PrintFilter{Streams: []StreamType{STDOUT}, Reassemble: true, Output: PrintOutputCompact}))
+	assert.Regexp(t, `^\S+ <-- invalid 71B\n\S+ <-- incomplete 2B\n\S+ <-- raw_end 13B\n$`, out.String())
+}
@@ -1,16 +1,26 @@
-package main
+package recorder
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -35,45 +45,250 @@ func toString(t StreamType) string {
 	}
 }
 
+func (t StreamType) String() string {
+	switch t {
+	case STDIN:
+		return "stdin"
+	case STDOUT:
+		return "stdout"
+	case STDERR:
+		return "stderr"
+	default:
+		return ""
+	}
+}
+
+func parseStreamType(s string) (StreamType, error) {
+	for _, t := range []StreamType{STDIN, STDOUT, STDERR} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return STDIN, &unknownTypeError{kind: "stream", name: s}
+}
+
+// unknownTypeError is error of unknown stream or payload type of record (e.g. written by newer version)
+type unknownTypeError struct {
+	kind string // stream or payload
+	name string
+}
+
+func (e *unknownTypeError) Error() string {
+	return fmt.Sprintf("unknown %s type: %s", e.kind, e.name)
+}
+
 type PayloadType int
 
 const (
 	INVALID PayloadType = iota // for invalid LSP message
 	JSON
 	RAW
+	RAW_END       // for end of stream
+	TRAILER       // for summary of session (payload is JSON of Trailer)
+	SESSION_START // for start of recording session (payload is JSON of SessionStart)
+	ENV           // for environment variables of recorder (payload is NAME=VALUE lines)
+	META          // for metadata of recording session (payload is JSON of SessionMeta)
+	INCOMPLETE    // for received part of message whose stream ended before its whole payload
+	RESOURCES     // for resource usage of Language Server process (payload is JSON of ResourceSample)
 )
 
+func (t PayloadType) String() string {
+	switch t {
+	case INVALID:
+		return "invalid"
+	case JSON:
+		return "json"
+	case RAW:
+		return "raw"
+	case RAW_END:
+		return "raw_end"
+	case TRAILER:
+		return "trailer"
+	case SESSION_START:
+		return "session_start"
+	case ENV:
+		return "env"
+	case META:
+		return "meta"
+	case INCOMPLETE:
+		return "incomplete"
+	case RESOURCES:
+		return "resources"
+	default:
+		return ""
+	}
+}
+
+func parsePayloadType(s string) (PayloadType, error) {
+	for _, t := range []PayloadType{INVALID, JSON, RAW, RAW_END, TRAILER, SESSION_START, ENV, META, INCOMPLETE, RESOURCES} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return INVALID, &unknownTypeError{kind: "payload", name: s}
+}
+
 type LogData struct {
-	timestamp   time.Time
-	streamType  StreamType
-	payloadType PayloadType
-	payload     []byte
+	seq          int       // sequence number in log (assigned at logging)
+	timestamp    time.Time // when message is fully parsed (or read)
+	streamType   StreamType
+	payloadType  PayloadType
+	payload      []byte
+	originalSize int    // size of payload before truncation (0 if not truncated)
+	synthetic    bool   // generated by recorder instead of client or server (see shutdownServer and rejectClient)
+	recorder     bool   // message of recorder itself instead of stderr of server (see sendMessage)
+	batch        int    // position (1-based) of element of JSON-RPC batch (0 if not element, see expandBatch)
+	batchLen     int    // number of elements of batch
+	declaredSize int    // Content-Length of INCOMPLETE record (or INVALID record of framed non-JSON payload)
+	spill        string // path of file having whole payload (payload is its prefix, see payloadBuffer)
+	source       string // log file which record is merged from (see MergeLogs)
+	offset       int64  // offset of chunk in stream (RAW record of stdin/stdout captured by RunOptions.Raw)
+	size         int    // size of message at capture, before redaction or truncation (0 if unknown, see messageSize)
+
+	serverSession int  // lifetime (1-based) of server restarted on exit (0 if not restarted, see RunOptions.MaxRestarts)
+	rotated       bool // copy of META record repeated at top of log file started by time-based rotation
+
+	elapsed       time.Duration // monotonic time since start of session, immune to clock adjustment (0 if unknown)
+	queueTime     time.Duration // residency in channel between parse and logging
+	prevWriteTime time.Duration // time for writing previous record to log (own write time is not known at writing)
+	checksum      string        // CRC-32C of payload as written (empty if not recorded, see RunOptions.Checksum)
+
+	level slog.Level // level of record (info except debug records, see sendDebug)
+	pc    uintptr    // location of event of debug record (0 if unknown, see slog.HandlerOptions.AddSource)
+
+	envelope *parsedEnvelope // cache of Envelope (nil until parsed)
 }
 
-func record(ctx context.Context, ch <-chan LogData, writer io.Writer) {
+// messageSize returns size of message at capture. Falls back to size of payload (before truncation)
+// for record of old log
+func (d *LogData) messageSize() int {
+	if d.size > 0 {
+		return d.size
+	}
+	return max(len(d.payload), d.originalSize)
+}
+
+// debugOccupancyInterval is interval of debug records of occupancy of channel of records
+const debugOccupancyInterval = time.Second
+
+// record writes LogData to log (and mirror of opts if not nil) until ch is closed and drained.
+// Trailer (LogData having TRAILER type and empty payload) is filled by session and occupancy of ch. Warnings about slow requests
+// (see RunOptions.SlowRequestWarning), occupancy of ch (if debug) and supplemental metadata having clientInfo and
+// serverInfo of initialize handshake are also written. If server is respawned (see RunOptions.MaxRestarts), records
+// are tagged with server session started by the last record having it
+func record(ctx context.Context, ch <-chan LogData, logger *slog.Logger, session *Session, opts *RunOptions) {
+	seq := 0
+	var writeTime time.Duration
+	var digest *logDigest
+	if opts.Checksum {
+		digest = newLogDigest()
+	}
+	server := 0 // current server session (see LogData.serverSession)
+	if opts.MaxRestarts > 0 {
+		server = 1
+	}
+	peak := 0 // high-water mark of occupancy of ch
+	write := func(v *LogData) {
+		dequeued := time.Now()
+		seq++
+		v.seq = seq
+		if v.serverSession > 0 { // the first record of respawned server
+			server = v.serverSession
+		} else {
+			v.serverSession = server
+		}
+		v.queueTime = dequeued.Sub(v.timestamp)
+		if !opts.start.IsZero() {
+			v.elapsed = max(v.timestamp.Sub(opts.start), 1) // by monotonic clock, since both are read by time.Now
+		}
+		v.prevWriteTime = writeTime
+		if v.payloadType == TRAILER && v.payload == nil {
+			trailer := session.Trailer()
+			if opts.dropped != nil {
+				trailer.Summary.Dropped = opts.dropped.Load()
+			}
+			if opts.blocked != nil {
+				trailer.Summary.Blocked = time.Duration(opts.blocked.Load())
+			}
+			trailer.Summary.BufferSize, trailer.Summary.BufferPeak = cap(ch), peak
+			if opts.Sink != nil {
+				trailer.Summary.SinkDropped, _ = opts.Sink.Dropped()
+			}
+			trailer.Summary.MaxContentLength = opts.MaxContentLength
+			if digest != nil {
+				trailer.Digest = digest.digest()
+			}
+			v.payload, _ = json.Marshal(trailer)
+		} else {
+			session.Observe(v)
+		}
+		if opts.Redactor != nil && v.payloadType == JSON {
+			v.payload = opts.Redactor.Redact(v.payload)
+		}
+		if opts.Anonymizer != nil {
+			v.payload = opts.Anonymizer.Anonymize(v.payloadType, v.payload)
+		}
+		truncatePayload(v, opts.MaxPayloadBytes)
+		if digest != nil && logger.Handler().Enabled(context.Background(), v.level) { // records not written are not digested
+			v.checksum = payloadChecksum(v.payload)
+			digest.observe(v)
+		}
+		writeLogData(logger, v)
+		writeTime = time.Since(dequeued)
+		if opts.Mirror != nil {
+			opts.Mirror.Write(v)
+		}
+	}
+	var occupancy <-chan time.Time
+	if opts.debug {
+		ticker := time.NewTicker(debugOccupancyInterval)
+		defer ticker.Stop()
+		occupancy = ticker.C
+	}
+	peers := &peerSniffer{}
+	var watch *slowRequestWatch
+	var tick <-chan time.Time
+	if opts.SlowRequestWarning > 0 {
+		watch = newSlowRequestWatch(opts.SlowRequestWarning)
+		ticker := time.NewTicker(watch.interval())
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case v := <-ch:
-			_, _ = fmt.Fprintf(writer, "%s %s", v.timestamp.Format(time.RFC3339Nano), toString(v.streamType))
-			if v.payloadType != JSON {
-				_, _ = writer.Write([]byte(" "))
-				_, _ = writer.Write(v.payload)
-				_, _ = writer.Write([]byte("\n"))
-			} else {
-				buf := bytes.Buffer{}
-				buf.Grow(len(v.payload) * 2)
-				if json.Indent(&buf, v.payload, "", "  ") != nil {
-					_, _ = fmt.Fprintf(writer, "invalid json payload\n")
-					_, _ = writer.Write(v.payload)
-					_, _ = writer.Write([]byte("\n"))
-				} else {
-					_, _ = writer.Write([]byte("\n"))
-					_, _ = writer.Write(buf.Bytes())
-					_, _ = writer.Write([]byte("\n"))
+		case now := <-tick:
+			for _, w := range watch.overdue(now) {
+				write(w)
+			}
+		case now := <-occupancy:
+			write(&LogData{timestamp: now, streamType: STDERR, payloadType: RAW, level: slog.LevelDebug,
+				payload: []byte(fmt.Sprintf("%schannel occupancy %d/%d", debugPrefix, len(ch), cap(ch)))})
+		case v, ok := <-ch:
+			if !ok {
+				return
+			}
+			peak = max(peak, len(ch)+1) // including v
+			var late *LogData
+			if watch != nil {
+				late = watch.observe(&v) // before redaction
+			}
+			meta := peers.observe(&v)
+			if v.payloadType == TRAILER && v.payload == nil {
+				meta = peers.end(v.timestamp) // written before trailer
+				if meta != nil {
+					write(meta)
+					meta = nil
 				}
 			}
+			write(&v)
+			if late != nil {
+				write(late)
+			}
+			if meta != nil {
+				write(meta)
+			}
 		}
 	}
 }
@@ -84,13 +299,60 @@ func sendMessage(t StreamType, value string, ch chan<- LogData) {
 		streamType:  t,
 		payloadType: RAW,
 		payload:     []byte(value),
+		recorder:    true,
+	}
+}
+
+// sendData sends record of traffic. If opts.dropped is not nil (drop-on-full policy), record is dropped and counted
+// instead of blocking when ch is full, so that slow logging does not delay traffic. Otherwise, time blocked by
+// full ch is counted in opts.blocked (if not nil)
+func sendData(d LogData, ch chan<- LogData, opts *RunOptions) {
+	d.size = d.messageSize()
+	select {
+	case ch <- d:
+		return
+	default:
+	}
+	if opts.dropped != nil {
+		opts.dropped.Add(1)
+		return
+	}
+	start := time.Now()
+	ch <- d
+	if opts.blocked != nil {
+		opts.blocked.Add(int64(time.Since(start)))
 	}
 }
 
-func logError(err error, ch chan<- LogData) {
+// debugPrefix is prefix of payload of debug record
+const debugPrefix = "debug: "
+
+// sendDebug records low-level event (e.g. byte count of read) as stderr record at debug level.
+// Debug records are dropped instead of blocking when ch is full, and location of caller is kept as its source
+func sendDebug(ch chan<- LogData, format string, args ...any) {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	d := LogData{timestamp: time.Now(), streamType: STDERR, payloadType: RAW, level: slog.LevelDebug, pc: pcs[0],
+		payload: []byte(debugPrefix + fmt.Sprintf(format, args...))}
+	select {
+	case ch <- d:
+	default:
+	}
+}
+
+func sendEnd(t StreamType, reason string, ch chan<- LogData) {
+	ch <- LogData{
+		timestamp:   time.Now(),
+		streamType:  t,
+		payloadType: RAW_END,
+		payload:     []byte(reason),
+	}
+}
+
+func logError(err error, writer io.Writer, ch chan<- LogData) {
 	value := err.Error()
 	sendMessage(STDERR, value, ch)
-	_, _ = os.Stderr.WriteString(value)
+	_, _ = io.WriteString(writer, value+"\n")
 }
 
 type ContentHeaderParserState int
@@ -102,10 +364,72 @@ const (
 	IN_NEWLINES
 )
 
+func (s ContentHeaderParserState) String() string {
+	switch s {
+	case INITIAL:
+		return "INITIAL"
+	case IN_HEADER:
+		return "IN_HEADER"
+	case IN_LENGTH:
+		return "IN_LENGTH"
+	case IN_NEWLINES:
+		return "IN_NEWLINES"
+	default:
+		return fmt.Sprintf("ContentHeaderParserState(%d)", int(s))
+	}
+}
+
+// parseLength parses decimal value of Content-Length without allocation (except for error)
+func parseLength(s []byte) (int, error) {
+	if len(s) == 0 || len(s) > 18 { // not to overflow
+		return strconv.Atoi(string(s))
+	}
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return strconv.Atoi(string(s))
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, nil
+}
+
+// contentLengthHeader is start of message header written by recorder
+const contentLengthHeader = "Content-Length: "
+
+// contentLengthName is start of message header accepted by ContentHeaderParser, which is followed by
+// value with optional whitespace around it (e.g. "Content-Length:1234" is also valid header field)
+const contentLengthName = "Content-Length:"
+
 type ContentHeaderParser struct {
-	state ContentHeaderParserState
-	pos   int
-	sb    strings.Builder
+	state  ContentHeaderParserState
+	pos    int
+	length []byte // value of Content-Length (reused, so that parsing header does not allocate)
+
+	lenient   bool   // accept \n line endings
+	deviation string // first deviation from strict framing accepted by lenient parser (empty if none)
+
+	trace func(from, to ContentHeaderParserState) // called at each state transition if not nil (see --debug)
+}
+
+// NewLenientContentHeaderParser creates parser which also accepts header lines ending with bare \n
+// (see Deviation)
+func NewLenientContentHeaderParser() *ContentHeaderParser {
+	c := NewContentHeaderParser()
+	c.lenient = true
+	return c
+}
+
+// Deviation returns description of the first deviation from strict framing accepted by lenient parser
+// (empty if header has been strict so far)
+func (p *ContentHeaderParser) Deviation() string {
+	return p.deviation
+}
+
+func (p *ContentHeaderParser) deviate(deviation string) {
+	if p.deviation == "" {
+		p.deviation = deviation
+	}
 }
 
 func NewContentHeaderParser() *ContentHeaderParser {
@@ -114,21 +438,33 @@ func NewContentHeaderParser() *ContentHeaderParser {
 	return &c
 }
 
+// transit changes state (and reports transition to trace)
+func (p *ContentHeaderParser) transit(state ContentHeaderParserState) {
+	if p.trace != nil && p.state != state {
+		p.trace(p.state, state)
+	}
+	p.state = state
+}
+
 func (p *ContentHeaderParser) reset() {
-	p.state = INITIAL
+	p.transit(INITIAL)
 	p.pos = 0
-	p.sb.Reset()
+	p.length = p.length[:0]
+}
+
+// partial reports whether header is partially parsed
+func (p *ContentHeaderParser) partial() bool {
+	return p.state == IN_LENGTH || p.state == IN_NEWLINES || (p.state == IN_HEADER && p.pos > 0)
 }
 
 func (p *ContentHeaderParser) Parse(buffer *bytes.Buffer) (int, error) {
 START:
 	switch p.state {
 	case INITIAL, IN_HEADER:
-		p.state = IN_HEADER
-		header := []byte("Content-Length: ")
+		p.transit(IN_HEADER)
+		header := []byte(contentLengthName)
 		for ; p.pos < len(header); p.pos++ {
 			r, e := buffer.ReadByte()
-			p.sb.WriteByte(r)
 			if e != nil && errors.Is(e, io.EOF) {
 				return -1, e // suspend
 			}
@@ -137,9 +473,9 @@ START:
 				return -1, fmt.Errorf("invalid message header: '%s'", buffer.String())
 			}
 		}
-		p.state = IN_LENGTH
+		p.transit(IN_LENGTH)
 		p.pos = 0
-		p.sb.Reset()
+		p.length = p.length[:0]
 		goto START
 	case IN_LENGTH:
 		for {
@@ -154,15 +490,24 @@ START:
 			if r == '\r' {
 				break
 			}
-			p.sb.WriteByte(r)
+			if r == '\n' && p.lenient { // line of Content-Length is already terminated
+				p.deviate("header line ends with \\n instead of \\r\\n")
+				p.pos = 1
+				break
+			}
+			p.length = append(p.length, r)
 		}
-		p.state = IN_NEWLINES
-		p.pos = 0
+		p.transit(IN_NEWLINES) // pos is 1 if line of Content-Length is already terminated
 		goto START
 	case IN_NEWLINES:
 		newlines := []byte("\n\r\n")
 		for ; p.pos < len(newlines); p.pos++ {
-			if r, e := buffer.ReadByte(); e != nil || r != newlines[p.pos] {
+			r, e := buffer.ReadByte()
+			if e == nil && r == '\n' && p.pos == 1 && p.lenient { // empty line without \r
+				p.deviate("header line ends with \\n instead of \\r\\n")
+				break
+			}
+			if e != nil || r != newlines[p.pos] {
 				if e != nil && errors.Is(e, io.EOF) {
 					return -1, e // suspend
 				}
@@ -170,7 +515,8 @@ START:
 				return -1, errors.New("content length must be end with \\r\\n\\r\\n")
 			}
 		}
-		n, e := strconv.Atoi(p.sb.String())
+		n, e := parseLength(bytes.Trim(p.length, " \t")) // optional whitespace of header field
+
 		p.reset()
 		if e != nil {
 			return -1, e
@@ -184,73 +530,421 @@ START:
 	return -1, io.EOF
 }
 
-func intercept(ctx context.Context, t StreamType, reader io.Reader, writer io.Writer, ch chan<- LogData) {
+// skipToHeader discards data before next plausible Content-Length header (see plausibleHeader), so that
+// stream is resynchronized at message boundary instead of start of next read. Returns false if header is not
+// found (tail which may be start of header is kept)
+func skipToHeader(buf *bytes.Buffer) bool {
+	data := buf.Bytes()
+	for start := 0; ; {
+		i := bytes.Index(data[start:], []byte(contentLengthName))
+		if i < 0 {
+			buf.Next(max(start, len(data)-(len(contentLengthName)-1)))
+			return false
+		}
+		i += start
+		switch plausibleHeader(data[i+len(contentLengthName):]) {
+		case 1:
+			buf.Next(i)
+			return true
+		case 0: // decided by following data
+			buf.Next(i)
+			return false
+		}
+		start = i + 1
+	}
+}
+
+// plausibleHeader reports whether value following Content-Length name looks like length terminated by line end
+// (1), does not (-1), or it is not known until more data arrives (0)
+func plausibleHeader(value []byte) int {
+	digits := 0
+	trailing := false // whitespace after digits
+	for _, c := range value {
+		switch {
+		case c >= '0' && c <= '9' && !trailing && digits < 18: // see parseLength
+			digits++
+		case c == ' ' || c == '\t':
+			trailing = digits > 0
+		case (c == '\r' || c == '\n') && digits > 0:
+			return 1
+		default:
+			return -1
+		}
+	}
+	return 0
+}
+
+// framingPrefix is prefix of stderr message about data of stdin/stdout passed through outside valid framing,
+// like "framing: stdout resynchronized after 12 bytes outside framing" (see Session)
+const framingPrefix = "framing: "
+
+// framing events of framingPrefix message
+const (
+	framingResynchronized = "resynchronized" // next header is found
+	framingEnded          = "ended"          // stream ended before next header
+)
+
+// sendFraming records that n bytes of stream were passed through outside valid framing until event
+func sendFraming(t StreamType, event string, n int, ch chan<- LogData) {
+	sendMessage(STDERR, fmt.Sprintf("%s%s %s after %d bytes outside framing", framingPrefix, t, event, n), ch)
+}
+
+// parseFraming parses payload of framingPrefix message
+func parseFraming(payload string) (stream string, event string, n int, ok bool) {
+	v, ok := strings.CutPrefix(payload, framingPrefix)
+	if !ok {
+		return "", "", 0, false
+	}
+	if _, err := fmt.Sscanf(v, "%s %s after %d bytes", &stream, &event, &n); err != nil {
+		return "", "", 0, false
+	}
+	return stream, event, n, true
+}
+
+// contentLengthLimitPrefix is prefix of INVALID record of message whose Content-Length exceeds limit
+const contentLengthLimitPrefix = "content length exceeds limit: "
+
+// endOfStream is payload of RAW_END record of stream closed normally
+const endOfStream = "end of stream"
+
+func endOfStreamReason(err error) string {
+	if errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) {
+		return endOfStream
+	}
+	return fmt.Sprintf("read error: %v", err)
+}
+
+// size of each read of intercept grows from minReadSize up to maxReadSize while reads fill it
+// (e.g. large diagnostics), so that chatty stream of small messages does not keep large buffer
+const (
+	minReadSize = 4 * 1024
+	maxReadSize = 64 * 1024
+)
+
+// intercept records messages read from reader and passes them through to writer.
+// Hard write error stops the stream (and is returned) except for stderr, whose pass-through is just stopped
+func intercept(ctx context.Context, t StreamType, reader io.Reader, writer io.Writer, ch chan<- LogData,
+	opts RunOptions) error {
 	chParser := NewContentHeaderParser()
+	if opts.LenientFraming {
+		chParser = NewLenientContentHeaderParser()
+	}
+	warnedFraming := false // whether deviation from strict framing has been recorded
+	if opts.debug {
+		chParser.trace = func(from, to ContentHeaderParserState) {
+			sendDebug(ch, "%s: header parser %s -> %s", t, from, to)
+		}
+	}
+	var stripper *AnsiStripper
+	if t == STDERR && opts.StripAnsi {
+		stripper = &AnsiStripper{}
+	}
+	var lines *lineSplitter
+	if t == STDERR && !opts.StderrChunks {
+		lines = newLineSplitter(stderrLineIdle, stderrLineMax, func(line []byte) {
+			sendData(LogData{timestamp: time.Now(), streamType: t, payloadType: RAW, payload: line}, ch, &opts)
+		})
+	}
+	var filter *ClientFilter
+	if t == STDOUT && !opts.Raw {
+		filter = opts.ClientFilter
+	}
+
+	// if filter is specified, pass-through is deferred until message boundary is found
+	pending := bytes.Buffer{} // received but not passed-through data
+	fed := 0                  // total size of received data
+	sent := 0                 // total size of passed-through (or dropped) data
+	frameStart := 0           // offset of current message header
+	var writeErr error        // error of pass-through
+	write := func(data []byte) {
+		if writeErr == nil {
+			n, err := writeFull(writer, data)
+			if opts.debug && len(data) > 0 {
+				sendDebug(ch, "%s: wrote %d bytes", t, n)
+			}
+			if err != nil {
+				writeErr = fmt.Errorf("write error: %w", err)
+				if t == STDERR { // keep recording, since server may block if stderr is not drained
+					sendMessage(STDERR, fmt.Sprintf("stop pass-through of stderr, caused by %v", writeErr), ch)
+				}
+			}
+		}
+	}
+	passThrough := func(end int) {
+		if end > sent {
+			write(pending.Next(end - sent))
+			sent = end
+		}
+	}
+
 	buf := bytes.Buffer{}
 	buf.Grow(2048)
 	requiredPayloadLen := -1
-	for {
+	var body payloadBuffer // payload of current message (valid while requiredPayloadLen >= 0)
+	resync := false        // skipping body of message exceeding MaxContentLength (or data after framing failure)
+	unframedStart := -1    // offset where framing failed (-1 unless resynchronizing after failure)
+	readBuf := make([]byte, minReadSize)
+	var readErr error
+	for readErr == nil {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
 		}
-		tmp := make([]byte, 1024)
-		n, _ := reader.Read(tmp) //FIXME: read error handling
+		if writeErr != nil && t != STDERR {
+			sendEnd(t, writeErr.Error(), ch)
+			return writeErr
+		}
+		var n int
+		n, readErr = reader.Read(readBuf)
 		if n == 0 {
-			continue // skip empty data
+			continue // skip empty data (also stop reading at error)
+		}
+		data := readBuf[:n] // valid until next read
+		if opts.debug {
+			sendDebug(ch, "%s: read %d bytes", t, n)
+		}
+		if n == len(readBuf) && n < maxReadSize {
+			readBuf = make([]byte, 2*n)
+		}
+		if filter == nil {
+			write(data)
+		} else {
+			pending.Write(data)
 		}
-		n, _ = writer.Write(tmp[:n]) //FIXME: write error handling
 
 		if t == STDERR {
-			ch <- LogData{
+			payload := data
+			if stripper != nil { // stripped payload is not shared with readBuf
+				if payload = stripper.Strip(data); len(payload) == 0 {
+					continue
+				}
+			}
+			if lines != nil { // lines are copied
+				lines.Write(payload)
+				continue
+			}
+			if stripper == nil {
+				payload = bytes.Clone(data) // readBuf is reused by next read
+			}
+			sendData(LogData{
 				timestamp:   time.Now(),
 				streamType:  t,
 				payloadType: RAW,
-				payload:     tmp[:n],
-			}
+				payload:     payload,
+			}, ch, &opts)
+			continue
+		}
+		if opts.Raw { // messages are reconstructed by print --reassemble
+			sendData(LogData{
+				timestamp:   time.Now(),
+				streamType:  t,
+				payloadType: RAW,
+				payload:     bytes.Clone(data),
+				offset:      int64(fed),
+			}, ch, &opts)
+			fed += n
 			continue
 		}
 
-		// extract message payload
-		buf.Write(tmp[:n])
-		if requiredPayloadLen < 0 {
-			num, err := chParser.Parse(&buf)
-			if err != nil {
-				if err != io.EOF {
-					ch <- LogData{
+		// extract message payloads
+		buf.Write(data)
+		fed += n
+		for {
+			if resync { // pass through as is until next header
+				found := skipToHeader(&buf)
+				passThrough(fed - buf.Len())
+				if !found {
+					break
+				}
+				resync = false
+				if unframedStart >= 0 {
+					sendFraming(t, framingResynchronized, fed-buf.Len()-unframedStart, ch)
+					unframedStart = -1
+				}
+			}
+			if requiredPayloadLen < 0 {
+				if chParser.state == INITIAL {
+					frameStart = fed - buf.Len()
+				}
+				num, err := chParser.Parse(&buf)
+				if err != nil {
+					if err != io.EOF {
+						sendData(LogData{
+							timestamp:   time.Now(),
+							streamType:  t,
+							payloadType: INVALID,
+							payload:     []byte(err.Error()),
+						}, ch, &opts)
+						unframedStart = frameStart // broken stream is passed as is until next header
+						resync = true
+						continue
+					}
+					break
+				}
+				if deviation := chParser.Deviation(); deviation != "" && !warnedFraming {
+					warnedFraming = true
+					sendMessage(STDERR, fmt.Sprintf("warning: %s framing is not strict (%s), accepted by lenient framing",
+						t, deviation), ch)
+				}
+				if opts.MaxContentLength > 0 && int64(num) > opts.MaxContentLength {
+					sendData(LogData{
 						timestamp:   time.Now(),
 						streamType:  t,
 						payloadType: INVALID,
-						payload:     []byte(err.Error()),
+						payload:     []byte(fmt.Sprintf("%s%d > %d", contentLengthLimitPrefix, num, opts.MaxContentLength)),
+					}, ch, &opts)
+					resync = true
+					continue
+				}
+				requiredPayloadLen = num
+				if body, err = newPayloadBuffer(num, opts.SpillOver, opts.SpillDir); err != nil {
+					sendMessage(STDERR, err.Error(), ch) // payload is held in memory instead
+				}
+			}
+
+			// move payload out of buf as it arrives, so that buf does not grow with huge message
+			body.Write(buf.Next(requiredPayloadLen - body.Len()))
+			if body.Len() < requiredPayloadLen {
+				break
+			}
+
+			requiredPayloadLen = -1
+			now := time.Now()
+			d := LogData{
+				timestamp:   now,
+				streamType:  t,
+				payloadType: JSON,
+			}
+			if err := body.finish(&d); err != nil {
+				sendMessage(STDERR, err.Error(), ch) // prefix is recorded as truncated payload
+			}
+			body = payloadBuffer{}
+			payload := d.payload
+			if d.originalSize == 0 && !json.Valid(payload) { // e.g. miscounted Content-Length
+				d.payloadType, d.declaredSize = INVALID, len(payload) // keep raw bytes and declared length
+			}
+			sendData(d, ch, &opts)
+			if t == STDIN && opts.clientExit != nil && !opts.clientExit.Load() {
+				if e, err := ParseEnvelope(payload); err == nil {
+					if e.IsNotification() && e.Method == "exit" {
+						opts.clientExit.Store(true)
+					}
+					if err := opts.clientState.observe(payload, e); err != nil {
+						sendMessage(STDERR, "warning: "+err.Error(), ch)
 					}
 				}
+			}
+			if t == STDOUT && opts.clientState != nil {
+				if e, err := ParseEnvelope(payload); err == nil && e.IsResponse() {
+					opts.clientState.answered(payload, e)
+				}
+			}
+			if filter == nil {
 				continue
 			}
-			requiredPayloadLen = num
+			end := fed - buf.Len()
+			if frameStart >= sent && filter.Suppress(payload, now) {
+				passThrough(frameStart)
+				pending.Next(end - sent) // drop whole message
+				sent = end
+				if msg, ok := filter.Report(now, time.Second); ok {
+					sendMessage(STDERR, msg, ch)
+					_, _ = io.WriteString(opts.errWriter(), msg+"\n")
+				}
+			} else {
+				passThrough(end)
+			}
 		}
-
-		if buf.Len() < requiredPayloadLen {
-			continue
+	}
+	passThrough(fed)
+	if lines != nil {
+		lines.Close()
+	}
+	if unframedStart >= 0 {
+		sendFraming(t, framingEnded, fed-unframedStart, ch)
+	}
+	if writeErr != nil && t != STDERR {
+		sendEnd(t, writeErr.Error(), ch)
+		return writeErr
+	}
+	reason := endOfStreamReason(readErr)
+	if requiredPayloadLen >= 0 { // received part is the most interesting data if server crashed
+		d := LogData{timestamp: time.Now(), streamType: t, payloadType: INCOMPLETE, declaredSize: requiredPayloadLen}
+		if err := body.finish(&d); err != nil {
+			sendMessage(STDERR, err.Error(), ch)
 		}
+		d.size = d.messageSize()
+		ch <- d
+		reason += fmt.Sprintf(" (%d of %d bytes of payload missing)", requiredPayloadLen-body.Len(), requiredPayloadLen)
+	} else if chParser.partial() {
+		reason += " (in the middle of header)"
+	}
+	sendEnd(t, reason, ch)
+	return nil
+}
+
+// DefaultEnvDenylist are patterns of secret-looking names of environment variables, whose values are redacted
+// unless RunOptions.EnvDenylist or RunOptions.EnvRecordAll is given
+var DefaultEnvDenylist = []string{"*TOKEN*", "*SECRET*", "*KEY*", "*PASSWORD*", "*PASSWD*", "*CREDENTIAL*"}
+
+// redactedEnvValue is recorded value of environment variable denied by envFilter
+const redactedEnvValue = "<redacted>"
 
-		payload := make([]byte, requiredPayloadLen)
-		_, _ = buf.Read(payload)
-		requiredPayloadLen = -1
-		ch <- LogData{
-			timestamp:   time.Now(),
-			streamType:  t,
-			payloadType: JSON,
-			payload:     payload,
+// envFilter selects recorded environment variables by glob patterns of their names (see MatchMethod)
+type envFilter struct {
+	allow []string // record only variables matching these (all if nil)
+	deny  []string // redact values of variables matching these (case-insensitive), so that only their presence is recorded
+}
+
+// envFilter returns filter of recorded environment variables
+func (o *RunOptions) envFilter() envFilter {
+	f := envFilter{allow: o.EnvAllowlist, deny: o.EnvDenylist}
+	if f.deny == nil {
+		f.deny = DefaultEnvDenylist
+	}
+	if o.EnvRecordAll {
+		f.deny = nil
+	}
+	return f
+}
+
+// apply returns NAME=VALUE (or NAME of unset variable) to be recorded. Returns false if variable is not recorded
+func (f envFilter) apply(env string) (string, bool) {
+	name, _, set := strings.Cut(env, "=")
+	if f.allow != nil && !MatchMethod(f.allow, name) {
+		return "", false
+	}
+	if set && slices.ContainsFunc(f.deny, func(pattern string) bool {
+		return matchGlob(strings.ToUpper(pattern), strings.ToUpper(name))
+	}) {
+		return name + "=" + redactedEnvValue, true
+	}
+	return env, true
+}
+
+// filterEnv returns recorded variables of environ (nil if none)
+func (f envFilter) filterEnv(environ []string) []string {
+	var recorded []string
+	for _, env := range environ {
+		if env, ok := f.apply(env); ok {
+			recorded = append(recorded, env)
 		}
 	}
+	return recorded
 }
 
-func formatEnv() string {
+// formatEnv returns NAME=VALUE lines of environment variables recorded by filter
+func formatEnv(environ []string, filter envFilter) string {
 	sb := strings.Builder{}
 	sb.Grow(1024)
-	for i, env := range os.Environ() {
-		if i > 0 {
+	for _, env := range environ {
+		env, ok := filter.apply(env)
+		if !ok {
+			continue
+		}
+		if sb.Len() > 0 {
 			sb.WriteRune('\n')
 		}
 		sb.WriteString(env)
@@ -258,50 +952,824 @@ func formatEnv() string {
 	return sb.String()
 }
 
-func Run(name string, args []string, logWriter io.Writer) {
-	ch := make(chan LogData, 32)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer func() {
-		time.Sleep(100 * time.Millisecond)
+// ParseEnvOverride parses KEY=VALUE (set variable) or KEY (unset variable)
+func ParseEnvOverride(s string) (string, string, bool, error) {
+	key, value, set := strings.Cut(s, "=")
+	if key == "" {
+		return "", "", false, fmt.Errorf("environment variable must be KEY=VALUE or KEY: '%s'", s)
+	}
+	return key, value, set, nil
+}
+
+// applyEnv returns environ whose variables are set or unset by overrides (see ParseEnvOverride).
+// Later override wins
+func applyEnv(environ []string, overrides []string) []string {
+	env := slices.Clone(environ)
+	for _, override := range overrides {
+		key, _, set, err := ParseEnvOverride(override)
+		if err != nil {
+			continue
+		}
+		env = slices.DeleteFunc(env, func(e string) bool {
+			name, _, _ := strings.Cut(e, "=")
+			if runtime.GOOS == "windows" { // names are case-insensitive
+				return strings.EqualFold(name, key)
+			}
+			return name == key
+		})
+		if set {
+			env = append(env, override)
+		}
+	}
+	return env
+}
+
+// DefaultBufferSize is default capacity of channel of records between traffic and log writer
+const DefaultBufferSize = 32
+
+type RunOptions struct {
+	KillTimeout   time.Duration // grace period between forwarded signal (or client close) and SIGKILL (forever if 0)
+	HandleSignals bool          // catch ShutdownSignals of process and forward them to server (left to caller if false, see RunContext)
+	StripAnsi     bool          // strip escape sequences from recorded stderr (not from pass-through)
+	StderrChunks  bool          // record stderr in chunks as read instead of lines (e.g. binary stderr)
+	BufferSize    int           // capacity of channel of records (DefaultBufferSize if 0)
+	DropOnFull    bool          // drop records of traffic instead of blocking when channel is full
+
+	AutoShutdown        bool          // send shutdown/exit to spawned server if client disconnected without exit (ignored if Raw)
+	AutoShutdownTimeout time.Duration // grace period between auto shutdown and SIGKILL (forever if 0)
+
+	MaxRestarts    int           // respawn server exited without exit notification up to this many times (never if 0). Requires stdio of spawned server
+	RestartBackoff time.Duration // delay before the first respawn, doubled for each respawn (see restartBackoff)
+
+	dropped     *atomic.Int64 // number of dropped records (set by Run if DropOnFull)
+	blocked     *atomic.Int64 // total nanoseconds producers were blocked by full channel (set by Run unless DropOnFull)
+	clientExit  *atomic.Bool  // whether client sent exit notification (set by Run if AutoShutdown or MaxRestarts)
+	clientState *clientState  // state of client replayed to respawned server (set by Run if MaxRestarts)
+	debug       bool          // record low-level events (set by Run if logger is enabled at debug level)
+	start       time.Time     // start of session, base of LogData.elapsed (set by Run)
+
+	Listen         string        // accept client on TCP address instead of stdio
+	Connect        string        // connect to server on TCP address instead of stdio of spawned process
+	Pipe           string        // accept client on unix domain socket (named pipe on Windows) instead of stdio
+	ServerPipe     string        // connect to server on unix domain socket (named pipe on Windows) instead of stdio
+	WsConnect      string        // connect to server on WebSocket URL (one message per text frame) instead of stdio
+	ConnectTimeout time.Duration // timeout for connecting to server (or waiting for server socket)
+
+	ClientFilter *ClientFilter // drop server notifications from pass-through to client (nil if not filtered)
+	Append       bool          // log is appended to existing one (recorded in session start)
+	NoEnv        bool          // do not record environment variables
+	EnvAllowlist []string      // record only environment variables matching these glob patterns (all if nil)
+	EnvDenylist  []string      // record values of environment variables matching these glob patterns as <redacted> (DefaultEnvDenylist if nil)
+	EnvRecordAll bool          // record values of all environment variables (cannot be combined with EnvDenylist)
+	ServerEnv    []string      // KEY=VALUE added to (or KEY removed from) environment of Language Server
+	ServerDir    string        // working directory of Language Server (current directory if empty)
+	Mirror       *Mirror       // print records while recording (nil if not mirrored)
+
+	MaxPayloadBytes    int            // truncate payload longer than this in log (unlimited if 0)
+	Checksum           bool           // record checksum of each payload and digest of records in trailer (see VerifyLog)
+	MaxContentLength   int64          // larger message is invalid and passed through as is until next header (unlimited if 0)
+	LenientFraming     bool           // accept header lines ending with bare \n (warned once per stream, see ContentHeaderParser.Deviation)
+	SpillOver          int64          // write payload longer than this to file in SpillDir, and log its prefix (not if 0)
+	SpillDir           string         // directory of spill files (system temp directory if empty)
+	Redactor           *Redactor      // redact document contents in log (nil if not redacted)
+	Anonymizer         *URIAnonymizer // anonymize file URIs and paths in log (nil if not anonymized)
+	Raw                bool           // record chunks of stdin/stdout as read (RAW records with offset) instead of messages
+	SampleResources    time.Duration  // interval of recording resource usage of spawned server (not sampled if 0)
+	SlowRequestWarning time.Duration  // warn about client request without response for this duration (not warned if 0)
+	LogPaths           []string       // paths of logs recorded in session metadata
+	Sink               *HTTPSink      // HTTP sink among logs, whose dropped batches are counted in trailer (nil if none)
+
+	ClientIn  io.Reader // read client messages from this instead of stdin (e.g. replay). Closed at end if io.Closer
+	ClientOut io.Writer // write server messages to this instead of stdout
+	ErrOut    io.Writer // write stderr of server and diagnostics of recorder to this instead of stderr
+
+	stderr io.Writer // synchronized ErrOut (set by Run)
+}
+
+// errWriter returns writer of stderr pass-through and diagnostics (StderrWriter unless set by Run)
+func (o *RunOptions) errWriter() io.Writer {
+	if o.stderr != nil {
+		return o.stderr
+	}
+	return StderrWriter
+}
+
+// Validate checks combination of options for Language Server executable name (empty if connected to server),
+// so that invalid one is reported before log is created. Run (and Recorder) also validates options
+func (o *RunOptions) Validate(name string) error {
+	if o.BufferSize < 0 {
+		return fmt.Errorf("BufferSize must not be negative: %d", o.BufferSize)
+	}
+	if o.MaxPayloadBytes < 0 {
+		return fmt.Errorf("MaxPayloadBytes must not be negative: %d", o.MaxPayloadBytes)
+	}
+	if o.Raw && (o.ClientFilter != nil || o.Redactor != nil || o.Anonymizer != nil || o.MaxPayloadBytes > 0 ||
+		o.SpillOver > 0 || o.LenientFraming) {
+		return errors.New("Raw cannot be combined with ClientFilter, Redactor, Anonymizer, MaxPayloadBytes, SpillOver or LenientFraming, since raw chunks are recorded as is")
+	}
+	if o.SpillOver > 0 && (o.Redactor != nil || o.Anonymizer != nil) {
+		return errors.New("SpillOver cannot be combined with Redactor or Anonymizer, since spilled payloads are not redacted")
+	}
+	if o.MaxRestarts < 0 {
+		return fmt.Errorf("MaxRestarts must not be negative: %d", o.MaxRestarts)
+	}
+	if o.RestartBackoff < 0 {
+		return fmt.Errorf("RestartBackoff must not be negative: %s", o.RestartBackoff)
+	}
+	if _, addr := o.serverEndpoint(); o.MaxRestarts > 0 && (o.Raw || name == "" || addr != "") {
+		return errors.New("MaxRestarts requires Language Server executable talking over stdio, and cannot be combined with Raw")
+	}
+	if o.NoEnv && (len(o.EnvDenylist) > 0 || o.EnvRecordAll) {
+		return errors.New("NoEnv cannot be combined with EnvDenylist or EnvRecordAll")
+	}
+	if o.EnvRecordAll && len(o.EnvDenylist) > 0 {
+		return errors.New("EnvRecordAll cannot be combined with EnvDenylist")
+	}
+	for _, env := range o.ServerEnv {
+		if _, _, _, err := ParseEnvOverride(env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clientEndpoint returns network and address for accepting client (empty if stdio)
+func (o *RunOptions) clientEndpoint() (string, string) {
+	switch {
+	case o.Listen != "":
+		return "tcp", o.Listen
+	case o.Pipe != "":
+		return "pipe", o.Pipe
+	default:
+		return "", ""
+	}
+}
+
+// serverEndpoint returns network and address for connecting to server (empty if stdio)
+func (o *RunOptions) serverEndpoint() (string, string) {
+	switch {
+	case o.Connect != "":
+		return "tcp", o.Connect
+	case o.ServerPipe != "":
+		return "pipe", o.ServerPipe
+	case o.WsConnect != "":
+		return "ws", o.WsConnect
+	default:
+		return "", ""
+	}
+}
+
+// forwardSignal forwards SIGINT/SIGTERM to the process and kills it if not exited within killTimeout.
+// If signal cannot be delivered (e.g. on Windows), graceful is called to shut down the process instead.
+// The forwarded signal (or nil) is sent to caught after the process exited
+func forwardSignal(sigCh <-chan os.Signal, exited <-chan struct{}, process *os.Process, graceful func(),
+	killTimeout time.Duration, caught chan<- os.Signal, ch chan<- LogData) {
+	select {
+	case <-exited:
+		caught <- nil
+	case sig := <-sigCh:
+		reason := "signal was forwarded"
+		if err := signalProcess(process, sig); err != nil && graceful != nil {
+			sendMessage(STDERR, fmt.Sprintf("shut down server on signal: %s (%v)", sig, err), ch)
+			graceful()
+			reason = "server was shut down"
+		} else {
+			sendMessage(STDERR, fmt.Sprintf("forward signal: %s", sig), ch)
+		}
+		waitOrKill(exited, process, killTimeout, reason, ch)
+		caught <- sig
+	}
+}
+
+// waitOrKill waits until the process exited. Its process group is killed if not exited within timeout after
+// the event of reason (wait forever if timeout is 0), so that recording is finished even if server hangs
+func waitOrKill(exited <-chan struct{}, process *os.Process, timeout time.Duration, reason string,
+	ch chan<- LogData) {
+	if timeout <= 0 {
+		<-exited
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-exited:
+	case <-timer.C:
+		sendMessage(STDERR, fmt.Sprintf("%s%s since %s", killPrefix, timeout, reason), ch)
+		_ = killProcessGroup(process)
+		<-exited
+	}
+}
+
+// autoShutdownMessages are shutdown request and exit notification synthesized by recorder
+var autoShutdownMessages = []string{
+	`{"jsonrpc":"2.0","id":"lsp-recorder/shutdown","method":"shutdown"}`,
+	`{"jsonrpc":"2.0","method":"exit"}`,
+}
+
+// shutdownServer sends shutdown request and exit notification to server on behalf of disconnected client.
+// They are recorded as synthetic records, so that they are not mistaken for client traffic
+func shutdownServer(writer io.Writer, ch chan<- LogData) {
+	sendMessage(STDERR, "client disconnected without exit, send shutdown and exit to server", ch)
+	for _, payload := range autoShutdownMessages {
+		if _, err := fmt.Fprintf(writer, "Content-Length: %d\r\n\r\n%s", len(payload), payload); err != nil {
+			sendMessage(STDERR, fmt.Sprintf("failed to send auto shutdown: %v", err), ch)
+			return
+		}
+		ch <- LogData{timestamp: time.Now(), streamType: STDIN, payloadType: JSON, payload: []byte(payload),
+			synthetic: true}
+	}
+}
+
+// ExitStatus is exit status of Language Server
+type ExitStatus struct {
+	Code       int       // exit code (128 + signal number if killed by signal)
+	Signal     os.Signal // terminating signal (nil if exited normally)
+	CoreDumped bool      // core is dumped by terminating signal
+	CutShort   bool      // session was cut short by cancellation of context (e.g. --duration)
+}
+
+func toExitStatus(state *os.ProcessState) ExitStatus {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ExitStatus{Code: 128 + int(ws.Signal()), Signal: ws.Signal(), CoreDumped: ws.CoreDump()}
+	}
+	return ExitStatus{Code: state.ExitCode()}
+}
+
+// signalNames are names of signals which usually terminate crashed (or killed) Language Server
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// terminationMessage returns message of terminating signal like "SIGSEGV (segmentation fault, core dumped)"
+func (s ExitStatus) terminationMessage() string {
+	name := s.Signal.String()
+	if sig, ok := s.Signal.(syscall.Signal); ok && signalNames[sig] != "" {
+		name = signalNames[sig] + " (" + name
+		if s.CoreDumped {
+			name += ", core dumped"
+		}
+		return name + ")"
+	}
+	if s.CoreDumped {
+		name += " (core dumped)"
+	}
+	return name
+}
+
+// outputDrainTimeout is how long remaining output of exited server is read. Pipes may be kept open
+// by descendants of server
+const outputDrainTimeout = time.Second
+
+// waitCommand waits for exit of started command in background. Result of Wait is sent to returned channel
+func waitCommand(cmd *exec.Cmd) chan error {
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+	return waited
+}
+
+// checkImmediateExit waits for immediateExitWindow before client is attached, and returns StartError if server
+// exited with failure meanwhile (e.g. missing shared library or wrong arguments)
+func checkImmediateExit(name string, cmd *exec.Cmd, waited chan error, ch chan<- LogData) (ExitStatus, error) {
+	timer := time.NewTimer(immediateExitWindow)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return ExitStatus{}, nil
+	case err := <-waited:
+		if cmd.ProcessState == nil {
+			return ExitStatus{}, NewStartError(name, err)
+		}
+		status := toExitStatus(cmd.ProcessState)
+		if status.Code == 0 { // e.g. server printing only version, which is not failure
+			waited <- err // leave result to lifecycle loop
+			return status, nil
+		}
+		if status.Signal != nil {
+			sendMessage(STDERR, terminatedPrefix+status.terminationMessage(), ch)
+		}
+		return status, &StartError{Kind: StartExitedImmediately, Name: name, Status: status}
+	}
+}
+
+// drainOutput waits for readers of server output to reach end of pipes. Pipes are closed after outputDrainTimeout
+func drainOutput(readers *sync.WaitGroup, pipes []io.Closer) {
+	done := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(outputDrainTimeout):
+		for _, pipe := range pipes {
+			_ = pipe.Close()
+		}
+		<-done
+	}
+}
+
+// Run runs Language Server and records its traffic.
+// Returns exit status of Language Server or error if it cannot be started
+func Run(name string, args []string, logger *slog.Logger, opts RunOptions) (ExitStatus, error) {
+	return RunContext(context.Background(), name, args, logger, opts)
+}
+
+// cutShort shuts down session like SIGTERM when ctx is done. Returned stop function stops watching ctx,
+// and returns cause of cancellation if session was cut short (nil otherwise)
+func cutShort(ctx context.Context, sigCh chan<- os.Signal) (stop func() error) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	var cause error
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			cause = context.Cause(ctx)
+			select {
+			case sigCh <- syscall.SIGTERM:
+			default: // signal is already pending
+			}
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() error {
+		once.Do(func() { close(done) })
+		<-stopped
+		return cause
+	}
+}
+
+// RunContext is Run which shuts down session gracefully (the same as SIGINT/SIGTERM) when ctx is done.
+// Then log ends with cause of cancellation, and ExitStatus.CutShort is set. Signals of process are caught
+// only if RunOptions.HandleSignals is set, so embedding program shuts down session by cancelling ctx
+func RunContext(ctx context.Context, name string, args []string, logger *slog.Logger, opts RunOptions) (ExitStatus, error) {
+	opts.stderr = StderrWriter
+	if opts.ErrOut != nil {
+		opts.stderr = newSyncWriter(opts.ErrOut)
+	}
+	if err := opts.Validate(name); err != nil {
+		_, _ = io.WriteString(opts.stderr, err.Error()+"\n")
+		return ExitStatus{}, err
+	}
+	opts.start = time.Now()
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	ch := make(chan LogData, bufferSize)
+	if opts.DropOnFull {
+		opts.dropped = &atomic.Int64{}
+	} else {
+		opts.blocked = &atomic.Int64{}
+	}
+	if opts.Raw {
+		opts.AutoShutdown = false // exit notification is not parsed
+	}
+	if opts.AutoShutdown || opts.MaxRestarts > 0 {
+		opts.clientExit = &atomic.Bool{}
+	}
+	if opts.MaxRestarts > 0 {
+		opts.clientState = newClientState()
+	}
+	opts.debug = logger.Enabled(context.Background(), slog.LevelDebug)
+	recordCtx, cancel := context.WithCancel(context.Background()) // not canceled by ctx, so that tail is recorded
+	recorded := make(chan struct{})
+	var producers sync.WaitGroup // goroutines sending records to ch
+	produce := func(f func()) {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			f()
+		}()
+	}
+	var output sync.WaitGroup // producers reading stdout/stderr pipes of server
+	produceOutput := func(f func()) {
+		output.Add(1)
+		produce(func() {
+			defer output.Done()
+			f()
+		})
+	}
+	var outputPipes []io.Closer
+	var client *stoppableReader
+	defer func() { // drain records, so that tail of session is written before logger is closed by caller
+		if client != nil {
+			client.Stop() // client may keep stdin open after server exited
+		}
+		producers.Wait()
+		if opts.dropped != nil {
+			if n := opts.dropped.Load(); n > 0 {
+				msg := fmt.Sprintf("warning: %d records dropped since log buffer was full", n)
+				sendMessage(STDERR, msg, ch)
+				_, _ = io.WriteString(opts.stderr, msg+"\n")
+			}
+		}
+		ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: TRAILER}
+		close(ch)
+		<-recorded
 		cancel()
 	}()
-	go record(ctx, ch, logWriter)
+	go func() {
+		record(recordCtx, ch, logger, NewSession(opts.ClientFilter), &opts)
+		close(recorded)
+	}()
 
+	sigCh := make(chan os.Signal, 1) // also fed by cutShort
+	if opts.HandleSignals {
+		signal.Notify(sigCh, ShutdownSignals...)
+		defer signal.Stop(sigCh)
+	}
+	stopCut := cutShort(ctx, sigCh)
+	defer stopCut()
+	// ends stderr with cause of cancellation or caught signal (if any). Returns whether session was cut short
+	endSession := func(sig os.Signal) bool {
+		if err := stopCut(); err != nil {
+			sendEnd(STDERR, cutShortPrefix+err.Error(), ch)
+			return true
+		}
+		if sig != nil {
+			sendEnd(STDERR, signalEndPrefix+sig.String(), ch)
+		}
+		return false
+	}
+
+	sessionStart := &SessionStart{Version: GetVersion(), Pid: os.Getpid(), Append: opts.Append}
+	if name != "" {
+		sessionStart.Cwd, _ = filepath.Abs(opts.ServerDir) // current directory if empty
+		if !opts.NoEnv {
+			sessionStart.Env = opts.envFilter().filterEnv(opts.ServerEnv)
+		}
+	}
+	start, _ := json.Marshal(sessionStart)
+	startTime := time.Now()
+	ch <- LogData{timestamp: startTime, streamType: STDERR, payloadType: SESSION_START, payload: start}
 	sendMessage(STDERR, fmt.Sprintf("run: %s %s", name, args), ch)
-	sendMessage(STDERR, formatEnv(), ch)
+	if !opts.NoEnv {
+		ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: ENV,
+			payload: []byte(formatEnv(os.Environ(), opts.envFilter()))}
+	}
 
-	cmd := exec.Command(name, args...)
-	stdinPipe, err := cmd.StdinPipe()
-	if err != nil {
-		logError(fmt.Errorf("failed to open stdin pipe: %v", err), ch)
-		return
+	clientNetwork, clientAddr := opts.clientEndpoint()
+	serverNetwork, serverAddr := opts.serverEndpoint()
+	var cmd *exec.Cmd
+	var serverIn io.Writer
+	var serverOut io.Reader
+	var pipes []io.Closer
+	defer func() {
+		for _, pipe := range pipes {
+			_ = pipe.Close()
+		}
+	}()
+	// spawn starts server, whose stderr (and stdout if server talks over socket) is intercepted.
+	// Returns its stdin and stdout if server talks over stdio
+	spawn := func() (*exec.Cmd, io.WriteCloser, io.Reader, error) {
+		cmd := exec.Command(name, args...)
+		cmd.Dir = opts.ServerDir
+		setProcessGroup(cmd)
+		if len(opts.ServerEnv) > 0 {
+			cmd.Env = applyEnv(os.Environ(), opts.ServerEnv)
+		}
+		var stdin io.WriteCloser
+		var stdout io.Reader
+		if serverNetwork == "" {
+			stdinPipe, err := cmd.StdinPipe()
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to open stdin pipe: %v", err)
+			}
+			pipes = append(pipes, stdinPipe)
+			stdin = stdinPipe
+		}
+		// use own pipes instead of StdoutPipe/StderrPipe, since cmd.Wait closes them before remaining output is read
+		stdoutPipe, stdoutEnd, err := os.Pipe()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to open stdout pipe: %v", err)
+		}
+		pipes = append(pipes, stdoutPipe, stdoutEnd)
+		outputPipes = append(outputPipes, stdoutPipe)
+		cmd.Stdout = stdoutEnd
+		if serverNetwork == "" {
+			stdout = stdoutPipe
+		} else { // server talks over socket, so treat stdout like stderr
+			produceOutput(func() { _ = intercept(recordCtx, STDERR, stdoutPipe, opts.stderr, ch, opts) })
+		}
+		stderrPipe, stderrEnd, err := os.Pipe()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to open stderr pipe: %v", err)
+		}
+		pipes = append(pipes, stderrPipe, stderrEnd)
+		outputPipes = append(outputPipes, stderrPipe)
+		cmd.Stderr = stderrEnd
+		produceOutput(func() { _ = intercept(recordCtx, STDERR, stderrPipe, opts.stderr, ch, opts) })
+		err = cmd.Start()
+		_ = stdoutEnd.Close() // write ends are owned by server, so readers get EOF when server (and its descendants) exit
+		_ = stderrEnd.Close()
+		if err != nil {
+			return nil, nil, nil, NewStartError(name, err)
+		}
+		if tree := trackProcessTree(cmd.Process); tree != nil { // descendants are killed before pipes are closed
+			pipes = append(pipes, tree)
+			outputPipes = append([]io.Closer{tree}, outputPipes...)
+		}
+		return cmd, stdin, stdout, nil
 	}
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		logError(fmt.Errorf("failed to open stdout pipe: %v", err), ch)
-		return
+	// server is started before client is read, so that input of client is not consumed if it cannot be started
+	var stdin io.WriteCloser // stdin of the first server (nil if server talks over socket)
+	var spawnErr error
+	if name != "" {
+		if cmd, stdin, serverOut, spawnErr = spawn(); spawnErr == nil && stdin != nil {
+			serverIn = stdin
+		}
 	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		logError(fmt.Errorf("failed to open stderr pipe: %v", err), ch)
-		return
+	meta := newSessionMeta(startTime)
+	if cmd != nil {
+		meta.Bin, meta.Args, meta.Cwd, meta.Pid = cmd.Path, args, sessionStart.Cwd, cmd.Process.Pid
+	} else if name != "" { // log of failed start still tells what was run
+		meta.Bin, meta.Args, meta.Cwd = name, args, sessionStart.Cwd
+	}
+	meta.Logs = opts.LogPaths
+	metaPayload, _ := json.Marshal(meta)
+	ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: META, payload: metaPayload}
+	if spawnErr != nil {
+		logError(spawnErr, opts.stderr, ch)
+		return ExitStatus{}, spawnErr
+	}
+	var waited chan error // result of Wait of current server
+	if cmd != nil {
+		waited = waitCommand(cmd)
+		if status, err := checkImmediateExit(name, cmd, waited, ch); err != nil {
+			drainOutput(&output, outputPipes) // stderr of server usually tells cause
+			sendMessage(STDERR, fmt.Sprintf("%s%d", exitedPrefix, status.Code), ch)
+			logError(err, opts.stderr, ch)
+			status.CutShort = endSession(nil)
+			return status, err
+		}
+	}
+
+	abort := func(t StreamType, err error) (ExitStatus, error) {
+		sendEnd(t, err.Error(), ch)
+		_, _ = io.WriteString(opts.stderr, err.Error()+"\n")
+		if cmd != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}
+		return ExitStatus{}, err
+	}
+	var clientIn io.Reader = os.Stdin
+	var clientOut io.Writer = os.Stdout
+	if opts.ClientIn != nil {
+		clientIn = opts.ClientIn
+	}
+	if opts.ClientOut != nil {
+		clientOut = opts.ClientOut
 	}
+	var conns []io.Closer
 	defer func() {
-		_ = stdinPipe.Close()
-		_ = stdoutPipe.Close()
-		_ = stderrPipe.Close()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
 	}()
-	go intercept(ctx, STDIN, os.Stdin, stdinPipe, ch)
-	go intercept(ctx, STDOUT, stdoutPipe, os.Stdout, ch)
-	go intercept(ctx, STDERR, stderrPipe, os.Stderr, ch)
-	err = cmd.Start()
-	if err != nil {
-		logError(fmt.Errorf("failed to start command: %v", err), ch)
-		return
+	if serverNetwork != "" {
+		conn, err := dialServer(serverNetwork, serverAddr, opts.ConnectTimeout, ch)
+		if err != nil {
+			if clientNetwork == "" { // bridge from stdio
+				rejectClient(clientIn, clientOut, err, ch)
+			}
+			return abort(STDOUT, err)
+		}
+		conns = append(conns, conn)
+		serverIn, serverOut = conn, conn
 	}
-	if err := cmd.Wait(); err != nil {
-		logError(fmt.Errorf("failed to wait command: %v", err), ch)
-		return
+	if clientNetwork != "" {
+		conn, err := acceptClient(clientNetwork, clientAddr, ch)
+		if err != nil {
+			return abort(STDIN, err)
+		}
+		conns = append(conns, conn)
+		clientIn, clientOut = conn, conn
+	}
+	client = newStoppableReader(clientIn)
+	toClient := &detachableWriter{writer: newSyncWriter(clientOut)}
+	serverEnd := make(chan struct{})
+	exited := make(chan struct{}) // closed when (current) server process exited
+	var serverMutex sync.Mutex    // guards cmd and exited replaced by respawned server
+	current := func() (*exec.Cmd, chan struct{}) {
+		serverMutex.Lock()
+		defer serverMutex.Unlock()
+		return cmd, exited
+	}
+	broken := make(chan struct{}) // closed if pass-through of either direction failed
+	var brokenOnce sync.Once
+	breakSession := func() { // session is unusable once one direction is broken, so terminate server
+		brokenOnce.Do(func() { close(broken) })
+		if cmd, _ := current(); cmd != nil {
+			select {
+			case sigCh <- syscall.SIGTERM:
+			default:
+			}
+		}
+	}
+	var gracefulStop atomic.Bool
+	graceful := func() { // shuts down server via stdin, when signal cannot be delivered to it
+		gracefulStop.Store(true)
+		client.Stop()
+	}
+	sample := func() {
+		if cmd != nil && opts.SampleResources > 0 {
+			pid, exited := cmd.Process.Pid, exited
+			produce(func() { sampleResources(pid, opts.SampleResources, exited, ch) })
+		}
+	}
+	sample()
+	var switcher *serverSwitch // stdin of current server (nil if server is not respawned)
+	if opts.MaxRestarts > 0 {
+		switcher = newServerSwitch(stdin, ch)
+		serverIn = switcher
+	}
+	toServer := newSyncWriter(serverIn)
+	produce(func() {
+		err := intercept(recordCtx, STDIN, client, toServer, ch, opts)
+		if err != nil {
+			breakSession() // server cannot receive messages anymore
+		}
+		cmd, exited := current()
+		autoShutdown := err == nil && cmd != nil && (opts.AutoShutdown || gracefulStop.Load()) && !opts.clientExit.Load()
+		if autoShutdown {
+			select {
+			case <-exited:
+				autoShutdown = false // nothing to shut down
+			case <-broken:
+				autoShutdown = false
+			default:
+			}
+		}
+		if autoShutdown {
+			toClient.Detach() // response to synthesized shutdown is not for client (and client may be gone)
+			shutdownServer(toServer, ch)
+		}
+		switch w := serverIn.(type) { // notify client disconnection
+		case interface{ CloseWrite() error }:
+			if err := w.CloseWrite(); errors.Is(err, errors.ErrUnsupported) {
+				// e.g. named pipe. Rest of server output is lost, but server can see disconnection
+				sendMessage(STDERR, fmt.Sprintf("close connection to server: %v", err), ch)
+				if c, ok := w.(io.Closer); ok {
+					_ = c.Close()
+				}
+			}
+		case io.Closer:
+			_ = w.Close()
+		}
+		if err == nil && cmd != nil {
+			if autoShutdown {
+				waitOrKill(exited, cmd.Process, opts.AutoShutdownTimeout, "auto shutdown", ch)
+			} else {
+				waitOrKill(exited, cmd.Process, opts.KillTimeout, "client closed", ch)
+			}
+		}
+	})
+	readServer := produce
+	if cmd != nil && serverNetwork == "" {
+		readServer = produceOutput
+	}
+	interceptServer := func(reader io.Reader) {
+		if intercept(recordCtx, STDOUT, reader, toClient, ch, opts) != nil {
+			breakSession() // client cannot receive messages anymore
+		}
+	}
+	readServer(func() {
+		interceptServer(serverOut)
+		close(serverEnd)
+	})
+
+	if cmd == nil { // only connect to server
+		var sig os.Signal
+		select {
+		case <-serverEnd:
+		case <-broken:
+		case sig = <-sigCh:
+		}
+		return ExitStatus{CutShort: endSession(sig)}, nil
+	}
+
+	// respawn waits for backoff and respawns exited server as n-th server session, whose stdout is read after
+	// initialize handshake of client is replayed. Returns false (and caught signal if any) if server is not respawned.
+	// Server not completing handshake is killed, and signal caught meanwhile is kept in interrupted
+	var interrupted os.Signal
+	respawn := func(n int) (bool, os.Signal) {
+		switcher.detach() // messages of client wait for new server
+		answerPending(opts.clientState, toClient, ch)
+		if opts.clientExit.Load() {
+			return false, nil // exit is requested by client
+		}
+		if n > opts.MaxRestarts+1 {
+			msg := fmt.Sprintf("warning: server is not respawned since it exited %d times", n-1)
+			sendMessage(STDERR, msg, ch)
+			_, _ = io.WriteString(opts.stderr, msg+"\n")
+			return false, nil
+		}
+		backoff := restartBackoff(opts.RestartBackoff, n-1)
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-switcher.done:
+			return false, nil
+		case <-broken:
+			return false, nil
+		case sig := <-sigCh:
+			return false, sig
+		}
+		ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: RAW, serverSession: n, recorder: true,
+			payload: []byte(fmt.Sprintf("%sserver session %d after %s", restartPrefix, n, backoff))}
+		newCmd, newIn, newOut, err := spawn()
+		if err != nil {
+			logError(err, opts.stderr, ch)
+			return false, nil
+		}
+		serverMutex.Lock()
+		cmd, exited = newCmd, make(chan struct{})
+		serverMutex.Unlock()
+		waited = waitCommand(newCmd)
+		sample()
+		out := bufio.NewReader(newOut)
+		replayed := make(chan error, 1)
+		go func() {
+			replayed <- replayHandshake(opts.clientState, newIn, out, toClient, ch)
+		}()
+		handshakeCtx, cancel := context.WithTimeout(context.Background(), restartHandshakeTimeout)
+		defer cancel()
+		select {
+		case err := <-replayed:
+			if err != nil {
+				sendMessage(STDERR, fmt.Sprintf("failed to replay initialize handshake: %v", err), ch)
+			}
+			switcher.attach(newIn) // closed if client disconnected meanwhile, so that server exits
+			produceOutput(func() { interceptServer(out) })
+			return true, nil
+		case <-handshakeCtx.Done():
+			sendMessage(STDERR, fmt.Sprintf("failed to replay initialize handshake: no response within %s",
+				restartHandshakeTimeout), ch)
+		case interrupted = <-sigCh:
+		}
+		// server is unusable, so kill it and stop reading its stdout. Its exit is handled like that of other server
+		_ = killProcessGroup(newCmd.Process)
+		if c, ok := newOut.(io.Closer); ok {
+			_ = c.Close()
+		}
+		<-replayed
+		_ = newIn.Close()
+		return true, nil
+	}
+
+	caught := make(chan os.Signal, 1)
+	var sig os.Signal
+	for n := 2; ; n++ {
+		go forwardSignal(sigCh, exited, cmd.Process, graceful, opts.KillTimeout, caught, ch)
+		err := <-waited
+		close(exited)
+		drainOutput(&output, outputPipes)
+		sig = <-caught
+		if sig == nil {
+			sig, interrupted = interrupted, nil
+		}
+		if cmd.ProcessState == nil {
+			logError(fmt.Errorf("failed to wait command: %v", err), opts.stderr, ch)
+			break
+		}
+		status := toExitStatus(cmd.ProcessState)
+		if status.Signal != nil { // before exit code, which completes exit of server (see Session)
+			sendMessage(STDERR, terminatedPrefix+status.terminationMessage(), ch)
+		}
+		sendMessage(STDERR, fmt.Sprintf("%s%d", exitedPrefix, status.Code), ch)
+		if switcher == nil || sig != nil {
+			break
+		}
+		respawned, caughtSig := respawn(n)
+		if !respawned {
+			sig = caughtSig
+			_ = switcher.Close() // client cannot wait for server anymore
+			break
+		}
+	}
+	cut := endSession(sig)
+	if cmd.ProcessState == nil {
+		return ExitStatus{CutShort: cut}, nil
 	}
-	sendMessage(STDERR, fmt.Sprintf("command exited with: %d", cmd.ProcessState.ExitCode()), ch)
+	status := toExitStatus(cmd.ProcessState)
+	status.CutShort = cut
+	return status, nil
 }
@@ -0,0 +1,865 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterceptEOF(t *testing.T) {
+	ch := make(chan LogData, 32)
+	reader := strings.NewReader("Content-Length: 2\r\n\r\n{}")
+	writer := bytes.Buffer{}
+
+	done := make(chan struct{})
+	go func() {
+		intercept(context.Background(), STDOUT, reader, &writer, ch, RunOptions{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("intercept does not terminate at end of stream")
+	}
+
+	assert.Equal(t, "Content-Length: 2\r\n\r\n{}", writer.String())
+	d := <-ch
+	assert.Equal(t, JSON, d.payloadType)
+	assert.Equal(t, "{}", string(d.payload))
+	d = <-ch
+	assert.Equal(t, RAW_END, d.payloadType)
+	assert.Equal(t, STDOUT, d.streamType)
+	assert.Equal(t, "end of stream", string(d.payload))
+}
+
+func TestInterceptDebug(t *testing.T) {
+	ch := make(chan LogData, 32)
+	input := "Content-Length: 2\r\n\r\n{}"
+	require.NoError(t, intercept(context.Background(), STDIN, strings.NewReader(input), io.Discard, ch,
+		RunOptions{debug: true}))
+	close(ch)
+	var debug []string
+	for d := range ch {
+		if d.level == slog.LevelDebug {
+			assert.Equal(t, STDERR, d.streamType)
+			debug = append(debug, string(d.payload))
+		}
+	}
+	assert.Equal(t, []string{
+		"debug: stdin: read 23 bytes",
+		"debug: stdin: wrote 23 bytes",
+		"debug: stdin: header parser INITIAL -> IN_HEADER",
+		"debug: stdin: header parser IN_HEADER -> IN_LENGTH",
+		"debug: stdin: header parser IN_LENGTH -> IN_NEWLINES",
+		"debug: stdin: header parser IN_NEWLINES -> INITIAL",
+		"debug: stdin: header parser INITIAL -> IN_HEADER", // waiting for next header
+	}, debug)
+
+	ch = make(chan LogData, 32)
+	require.NoError(t, intercept(context.Background(), STDIN, strings.NewReader(input), io.Discard, ch, RunOptions{}))
+	close(ch)
+	for d := range ch {
+		assert.Equal(t, slog.LevelInfo, d.level) // no debug records unless debug
+	}
+}
+
+func TestInterceptEndReason(t *testing.T) {
+	for input, reason := range map[string]string{
+		"Content-Length: 10\r\n\r\n{}":           "end of stream (8 of 10 bytes of payload missing)",
+		"Content-Length: 2\r\n\r\n{}Content-Len": "end of stream (in the middle of header)",
+		"Content-Length: 2\r\n\r\n{}":            "end of stream",
+	} {
+		ch := make(chan LogData, 32)
+		require.NoError(t, intercept(context.Background(), STDOUT, strings.NewReader(input), io.Discard, ch, RunOptions{}))
+		close(ch)
+		var last LogData
+		for d := range ch {
+			last = d
+		}
+		assert.Equal(t, RAW_END, last.payloadType)
+		assert.Equal(t, reason, string(last.payload), input)
+	}
+}
+
+func TestInterceptIncomplete(t *testing.T) {
+	ch := make(chan LogData, 32)
+	input := "Content-Length: 2\r\n\r\n{}Content-Length: 100\r\n\r\n{\"jsonrpc\":"
+	require.NoError(t, intercept(context.Background(), STDOUT, strings.NewReader(input), io.Discard, ch, RunOptions{}))
+	close(ch)
+	var records []LogData
+	for d := range ch {
+		records = append(records, d)
+	}
+	require.Len(t, records, 3)
+	assert.Equal(t, JSON, records[0].payloadType)
+	assert.Equal(t, INCOMPLETE, records[1].payloadType)
+	assert.Equal(t, `{"jsonrpc":`, string(records[1].payload))
+	assert.Equal(t, 100, records[1].declaredSize)
+	assert.Equal(t, "end of stream (89 of 100 bytes of payload missing)", string(records[2].payload))
+}
+
+func TestInterceptHeaderWithoutSpace(t *testing.T) {
+	payload := `{"jsonrpc":"2.0","id":1,"result":null}`
+	input := fmt.Sprintf("Content-Length:%d\r\n\r\n%s", len(payload), payload) + "junk" +
+		fmt.Sprintf("Content-Length:\t%d \r\n\r\n%s", len(payload), payload)
+	ch := make(chan LogData, 32)
+	require.NoError(t, intercept(context.Background(), STDOUT, strings.NewReader(input), io.Discard, ch,
+		RunOptions{MaxContentLength: 10}))
+	close(ch)
+	var records []LogData
+	for d := range ch {
+		records = append(records, d)
+	}
+	require.Len(t, records, 3) // resync finds header without space
+	assert.Equal(t, INVALID, records[0].payloadType)
+	assert.Equal(t, INVALID, records[1].payloadType)
+	assert.Equal(t, endOfStream, string(records[2].payload))
+
+	ch = make(chan LogData, 32)
+	require.NoError(t, intercept(context.Background(), STDOUT, strings.NewReader(input), io.Discard, ch, RunOptions{}))
+	close(ch)
+	d := <-ch
+	assert.Equal(t, JSON, d.payloadType)
+	assert.Equal(t, payload, string(d.payload))
+}
+
+func TestInterceptStderrLines(t *testing.T) {
+	chunks := []string{"first line\nsec", "ond line\nthird", " line\nprompt> "}
+	for _, byChunks := range []bool{false, true} {
+		ch := make(chan LogData, 32)
+		writer := bytes.Buffer{}
+		require.NoError(t, intercept(context.Background(), STDERR, &chunkReader{chunks: chunks}, &writer, ch,
+			RunOptions{StderrChunks: byChunks}))
+		close(ch)
+		var payloads []string
+		for d := range ch {
+			if d.payloadType == RAW {
+				payloads = append(payloads, string(d.payload))
+			}
+		}
+		assert.Equal(t, strings.Join(chunks, ""), writer.String()) // passed through as is
+		if byChunks {
+			assert.Equal(t, chunks, payloads)
+		} else {
+			assert.Equal(t, []string{"first line\n", "second line\n", "third line\n", "prompt> "}, payloads)
+		}
+	}
+}
+
+func TestInterceptLenientFraming(t *testing.T) {
+	m1 := `{"jsonrpc":"2.0","id":1,"result":null}`
+	m2 := `{"jsonrpc":"2.0","method":"initialized"}`
+	input := fmt.Sprintf("Content-Length: %d\n\n%s", len(m1), m1) + frame(m2) +
+		fmt.Sprintf("Content-Length:  %d \r\n\n%s", len(m2), m2)
+	for _, lenient := range []bool{false, true} {
+		ch := make(chan LogData, 32)
+		writer := bytes.Buffer{}
+		require.NoError(t, intercept(context.Background(), STDOUT, strings.NewReader(input), &writer, ch,
+			RunOptions{LenientFraming: lenient}))
+		close(ch)
+		var records []LogData
+		for d := range ch {
+			records = append(records, d)
+		}
+		assert.Equal(t, input, writer.String()) // passed through as is
+		if !lenient {
+			assert.Equal(t, INVALID, records[0].payloadType)
+			continue
+		}
+		require.Len(t, records, 5)
+		assert.Equal(t, RAW, records[0].payloadType)
+		assert.Equal(t, STDERR, records[0].streamType)
+		assert.Equal(t, "warning: stdout framing is not strict (header line ends with \\n instead of \\r\\n), accepted by lenient framing",
+			string(records[0].payload))
+		assert.Equal(t, m1, string(records[1].payload))
+		assert.Equal(t, m2, string(records[2].payload))
+		assert.Equal(t, m2, string(records[3].payload)) // warned only once
+		assert.Equal(t, endOfStream, string(records[4].payload))
+	}
+}
+
+func TestInterceptInvalidJSON(t *testing.T) {
+	for _, payload := range []string{
+		`{"text":"あ"}`[:11], // declared length splits UTF-8 sequence
+		`{"ok":true}`[:8],   // declared length splits JSON token
+	} {
+		valid := `{"jsonrpc":"2.0","id":1,"result":null}`
+		input := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(payload), payload) + frame(valid)
+		ch := make(chan LogData, 32)
+		writer := bytes.Buffer{}
+		require.NoError(t, intercept(context.Background(), STDOUT, strings.NewReader(input), &writer, ch, RunOptions{}))
+		close(ch)
+		var records []LogData
+		for d := range ch {
+			records = append(records, d)
+		}
+		assert.Equal(t, input, writer.String())
+		require.Len(t, records, 3)
+		assert.Equal(t, INVALID, records[0].payloadType)
+		assert.Equal(t, payload, string(records[0].payload)) // raw bytes are kept
+		assert.Equal(t, len(payload), records[0].declaredSize)
+		assert.Equal(t, JSON, records[1].payloadType)
+		assert.Equal(t, valid, string(records[1].payload))
+	}
+}
+
+func TestInterceptDropOnFull(t *testing.T) {
+	ch := make(chan LogData, 3) // log writer is stuck
+	input := strings.Repeat("Content-Length: 2\r\n\r\n{}", 10)
+	writer := &syncBuffer{}
+	opts := RunOptions{dropped: &atomic.Int64{}}
+	done := make(chan struct{})
+	go func() {
+		intercept(context.Background(), STDOUT, strings.NewReader(input), writer, ch, opts)
+		close(done)
+	}()
+	// traffic is not delayed
+	require.Eventually(t, func() bool { return writer.String() == input }, time.Second, 10*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, JSON, (<-ch).payloadType)
+	}
+	assert.Equal(t, RAW_END, (<-ch).payloadType) // end of stream is never dropped
+	<-done
+	assert.Equal(t, int64(7), opts.dropped.Load())
+}
+
+func TestInterceptBlockedOnFull(t *testing.T) {
+	ch := make(chan LogData, 1)
+	opts := RunOptions{blocked: &atomic.Int64{}}
+	done := make(chan struct{})
+	go func() {
+		intercept(context.Background(), STDOUT, strings.NewReader(frame("{}")+frame("[]")), io.Discard, ch, opts)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond) // log writer is stuck
+	var records []LogData
+	for d := range ch {
+		records = append(records, d)
+		if d.payloadType == RAW_END {
+			break
+		}
+	}
+	<-done
+	assert.Len(t, records, 3) // nothing is dropped
+	assert.GreaterOrEqual(t, time.Duration(opts.blocked.Load()), 50*time.Millisecond)
+}
+
+func TestRecordBufferOccupancy(t *testing.T) {
+	ch := make(chan LogData, 4)
+	for i := 0; i < 3; i++ {
+		ch <- LogData{timestamp: time.Now(), streamType: STDIN, payloadType: JSON, payload: []byte(`{}`)}
+	}
+	ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: TRAILER}
+	close(ch)
+	blocked := &atomic.Int64{}
+	blocked.Store(int64(time.Second))
+	buf := &syncBuffer{}
+	record(context.Background(), ch, NewLogger(buf), NewSession(nil), &RunOptions{blocked: blocked})
+	trailer, err := ReadTrailer(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	assert.Equal(t, 4, trailer.Summary.BufferSize)
+	assert.Equal(t, 4, trailer.Summary.BufferPeak)
+	out := bytes.Buffer{}
+	trailer.Summary.Format(&out, "")
+	assert.Contains(t, out.String(), "buffer:   peak 4 of 4 records, blocked 1s\n")
+}
+
+func TestInterceptClosedPipe(t *testing.T) {
+	ch := make(chan LogData, 32)
+	reader, pipeWriter := io.Pipe()
+	_ = pipeWriter.Close()
+
+	done := make(chan struct{})
+	go func() {
+		intercept(context.Background(), STDIN, reader, io.Discard, ch, RunOptions{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("intercept does not terminate at closed pipe")
+	}
+	d := <-ch
+	assert.Equal(t, RAW_END, d.payloadType)
+	assert.Equal(t, STDIN, d.streamType)
+}
+
+// chunkReader returns each chunk by one Read
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	if n < len(r.chunks[0]) {
+		r.chunks[0] = r.chunks[0][n:]
+	} else {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+func frame(payload string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(payload), payload)
+}
+
+func runInterceptWithFilter(t *testing.T, chunks []string, specs ...string) (string, []LogData) {
+	filter, err := NewClientFilter(specs)
+	require.NoError(t, err)
+	ch := make(chan LogData, 64)
+	writer := bytes.Buffer{}
+	intercept(context.Background(), STDOUT, &chunkReader{chunks: chunks}, &writer, ch, RunOptions{ClientFilter: filter})
+	close(ch)
+	var data []LogData
+	for d := range ch {
+		data = append(data, d)
+	}
+	return writer.String(), data
+}
+
+func TestInterceptSuppress(t *testing.T) {
+	logMessage := `{"jsonrpc":"2.0","method":"window/logMessage","params":{"message":"hello"}}`
+	response := `{"jsonrpc":"2.0","id":1,"result":null}`
+	request := `{"jsonrpc":"2.0","id":"r","method":"window/logMessage","params":{}}`
+	stream := frame(logMessage) + frame(response) + frame(logMessage) + frame(request) + frame(logMessage)
+
+	// split at every position (including message boundary and middle of header)
+	for i := 1; i < len(stream); i += 7 {
+		out, data := runInterceptWithFilter(t, []string{stream[:i], stream[i:]}, "window/logMessage")
+		assert.Equal(t, frame(response)+frame(request), out, "split at %d", i)
+		var payloads []string
+		for _, d := range data {
+			if d.streamType == STDOUT && d.payloadType == JSON {
+				payloads = append(payloads, string(d.payload))
+			}
+		}
+		assert.Equal(t, []string{logMessage, response, logMessage, request, logMessage}, payloads, "split at %d", i)
+	}
+
+	// all messages in single chunk
+	out, data := runInterceptWithFilter(t, []string{stream}, "window/logMessage")
+	assert.Equal(t, frame(response)+frame(request), out)
+	assert.Equal(t, STDERR, data[1].streamType)
+	assert.Equal(t, "suppressed to client: window/logMessage=1", string(data[1].payload)) // live report
+}
+
+func TestInterceptSuppressPassThrough(t *testing.T) {
+	logMessage := `{"jsonrpc":"2.0","method":"window/logMessage","params":{}}`
+
+	// not matched
+	out, _ := runInterceptWithFilter(t, []string{frame(logMessage)}, "$/progress")
+	assert.Equal(t, frame(logMessage), out)
+
+	// broken header is passed as is
+	out, data := runInterceptWithFilter(t, []string{"hello\r\n", frame(logMessage)}, "window/logMessage")
+	assert.Contains(t, out, "hello\r\n")
+	assert.Equal(t, INVALID, data[0].payloadType)
+
+	// incomplete message is passed at end of stream
+	partial := frame(logMessage)[:30]
+	out, data = runInterceptWithFilter(t, []string{partial}, "window/logMessage")
+	assert.Equal(t, partial, out)
+	assert.Equal(t, RAW_END, data[len(data)-1].payloadType)
+}
+
+func TestInterceptMaxContentLength(t *testing.T) {
+	response := `{"jsonrpc":"2.0","id":1,"result":null}`
+	stream := "Content-Length: 1000\r\n\r\n" + strings.Repeat("x", 100) + frame(response)
+	filter, err := NewClientFilter([]string{"window/logMessage"})
+	require.NoError(t, err)
+	for _, f := range []*ClientFilter{nil, filter} {
+		for i := 1; i < len(stream); i += 5 {
+			ch := make(chan LogData, 64)
+			writer := bytes.Buffer{}
+			require.NoError(t, intercept(context.Background(), STDOUT, &chunkReader{chunks: []string{stream[:i], stream[i:]}},
+				&writer, ch, RunOptions{MaxContentLength: 50, ClientFilter: f}))
+			close(ch)
+			var data []LogData
+			for d := range ch {
+				data = append(data, d)
+			}
+			assert.Equal(t, stream, writer.String(), "split at %d", i) // passed through as is
+			require.Len(t, data, 3, "split at %d", i)
+			assert.Equal(t, INVALID, data[0].payloadType)
+			assert.Equal(t, contentLengthLimitPrefix+"1000 > 50", string(data[0].payload))
+			assert.Equal(t, response, string(data[1].payload), "split at %d", i) // resynchronized
+			assert.Equal(t, endOfStream, string(data[2].payload))
+		}
+	}
+
+	session := NewSession(nil)
+	session.Observe(&LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte(contentLengthLimitPrefix + "1000 > 50")})
+	summary := session.Trailer().Summary
+	summary.MaxContentLength = 50
+	assert.Equal(t, 1, summary.Oversized)
+	assert.Equal(t, 0, summary.Invalid)
+	out := bytes.Buffer{}
+	summary.Format(&out, "")
+	assert.Contains(t, out.String(), "oversize: 1 messages over 50B\n")
+}
+
+func TestSkipToHeader(t *testing.T) {
+	for input, rest := range map[string]string{
+		"garbage Content-Length: 12\r\n":           "Content-Length: 12\r\n",
+		"Content-Length: x Content-Length:\t3 \n":  "Content-Length:\t3 \n", // implausible header is skipped
+		"garbage Content-Length: 12":               "Content-Length: 12",    // decided by next read
+		"garbage Content-Len":                      "ge Content-Len",        // tail shorter than name is kept
+		"Content-Length: 1 2\r\nContent-Length: 1": "Content-Length: 1",
+	} {
+		buf := bytes.NewBufferString(input)
+		found := skipToHeader(buf)
+		assert.Equal(t, rest, buf.String(), input)
+		assert.Equal(t, strings.HasSuffix(rest, "\n"), found, input)
+	}
+}
+
+func TestInterceptGarbageBetweenMessages(t *testing.T) {
+	m1 := `{"jsonrpc":"2.0","id":1,"result":null}`
+	m2 := `{"jsonrpc":"2.0","method":"initialized"}`
+	garbage := "panic: oops\nContent-Length: bogus\ngoroutine 1 [running]:\n"
+	input := frame(m1) + garbage + frame(m2) + "trailing garbage"
+	for _, chunks := range [][]string{{input}, strings.SplitAfter(input, "\n")} { // resync does not depend on reads
+		ch := make(chan LogData, 32)
+		writer := bytes.Buffer{}
+		require.NoError(t, intercept(context.Background(), STDOUT, &chunkReader{chunks: chunks}, &writer, ch, RunOptions{}))
+		close(ch)
+		var records []LogData
+		for d := range ch {
+			records = append(records, d)
+		}
+		assert.Equal(t, input, writer.String()) // passed through as is
+		require.Len(t, records, 7)
+		assert.Equal(t, m1, string(records[0].payload))
+		assert.Equal(t, INVALID, records[1].payloadType) // one record for whole garbage
+		assert.Equal(t, fmt.Sprintf("%sstdout resynchronized after %d bytes outside framing", framingPrefix, len(garbage)),
+			string(records[2].payload))
+		assert.Equal(t, m2, string(records[3].payload))
+		assert.Equal(t, INVALID, records[4].payloadType)
+		assert.Equal(t, framingPrefix+"stdout ended after 16 bytes outside framing", string(records[5].payload))
+		assert.Equal(t, endOfStream, string(records[6].payload))
+
+		session := NewSession(nil)
+		for i := range records {
+			session.Observe(&records[i])
+		}
+		summary := session.Trailer().Summary
+		assert.Equal(t, int64(len(garbage)+16), summary.Unframed["stdout"])
+		assert.Equal(t, 1, summary.Resyncs["stdout"])
+		out := bytes.Buffer{}
+		summary.Format(&out, "")
+		assert.Contains(t, out.String(), fmt.Sprintf("unframed: stdout %dB, 1 resyncs\n", len(garbage)+16))
+	}
+}
+
+func runForwardSignal(t *testing.T, cmd *exec.Cmd, killTimeout time.Duration) (os.Signal, *os.ProcessState) {
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() { // server is left running if signal is not forwarded
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	ch := make(chan LogData, 32)
+	sigCh := make(chan os.Signal, 1)
+	exited := make(chan struct{})
+	caught := make(chan os.Signal, 1)
+	go forwardSignal(sigCh, exited, cmd.Process, nil, killTimeout, caught, ch)
+	time.Sleep(100 * time.Millisecond) // wait for trap setup
+	sigCh <- syscall.SIGTERM
+	_ = cmd.Wait()
+	close(exited)
+	return <-caught, cmd.ProcessState
+}
+
+func TestForwardSignal(t *testing.T) {
+	sig, state := runForwardSignal(t, exec.Command("sleep", "10"), 5*time.Second)
+	assert.Equal(t, syscall.SIGTERM, sig)
+	assert.Equal(t, syscall.SIGTERM, state.Sys().(syscall.WaitStatus).Signal())
+}
+
+func TestForwardSignalKill(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "trap '' TERM; exec sleep 10") // ignored TERM is inherited by sleep
+	sig, state := runForwardSignal(t, cmd, 100*time.Millisecond)
+	assert.Equal(t, syscall.SIGTERM, sig)
+	assert.Equal(t, syscall.SIGKILL, state.Sys().(syscall.WaitStatus).Signal())
+}
+
+func TestForwardSignalGraceful(t *testing.T) {
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run()) // signal cannot be delivered to reaped process
+	ch := make(chan LogData, 32)
+	sigCh := make(chan os.Signal, 1)
+	exited := make(chan struct{})
+	caught := make(chan os.Signal, 1)
+	graceful := func() { close(exited) } // server exits on shutdown
+	go forwardSignal(sigCh, exited, cmd.Process, graceful, 5*time.Second, caught, ch)
+	sigCh <- syscall.SIGTERM
+	assert.Equal(t, syscall.SIGTERM, <-caught)
+	d := <-ch
+	assert.Contains(t, string(d.payload), "shut down server on signal: terminated")
+}
+
+func TestExitStatus(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 3")
+	_ = cmd.Run()
+	assert.Equal(t, ExitStatus{Code: 3}, toExitStatus(cmd.ProcessState))
+
+	cmd = exec.Command("sh", "-c", "kill -SEGV $$")
+	_ = cmd.Run()
+	status := toExitStatus(cmd.ProcessState)
+	assert.Equal(t, 128+int(syscall.SIGSEGV), status.Code)
+	assert.Equal(t, syscall.SIGSEGV, status.Signal)
+	assert.Equal(t, "SIGSEGV (segmentation fault)", ExitStatus{Signal: syscall.SIGSEGV}.terminationMessage())
+	assert.Equal(t, "SIGSEGV (segmentation fault, core dumped)",
+		ExitStatus{Signal: syscall.SIGSEGV, CoreDumped: true}.terminationMessage())
+}
+
+func TestFormatEnv(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "GITHUB_TOKEN=secret", "LANG=C.UTF-8", "EMPTY="}
+	assert.Equal(t, "PATH=/usr/bin\nGITHUB_TOKEN=secret\nLANG=C.UTF-8\nEMPTY=", formatEnv(environ, envFilter{}))
+	assert.Equal(t, "PATH=/usr/bin\nLANG=C.UTF-8", formatEnv(environ, envFilter{allow: []string{"LANG", "PATH", "HOME"}}))
+	assert.Equal(t, "", formatEnv(environ, envFilter{allow: []string{"PATH=/usr/bin"}}))
+
+	// secret-looking variables are redacted by default
+	defaults := (&RunOptions{}).envFilter()
+	assert.Equal(t, "PATH=/usr/bin\nGITHUB_TOKEN=<redacted>\nLANG=C.UTF-8\nEMPTY=", formatEnv(environ, defaults))
+	assert.Equal(t, "GITHUB_TOKEN=secret", formatEnv(environ, (&RunOptions{EnvAllowlist: []string{"*_TOKEN"},
+		EnvRecordAll: true}).envFilter()))
+	filter := (&RunOptions{EnvAllowlist: []string{"PATH", "L*", "GITHUB_*"}, EnvDenylist: []string{"lang"}}).envFilter()
+	assert.Equal(t, "PATH=/usr/bin\nGITHUB_TOKEN=secret\nLANG=<redacted>", formatEnv(environ, filter))
+
+	// overrides of environment of Language Server
+	assert.Equal(t, []string{"API_KEY=<redacted>", "API_KEY", "RUST_LOG=debug"},
+		defaults.filterEnv([]string{"API_KEY=abc", "API_KEY", "RUST_LOG=debug"}))
+}
+
+func TestRunEnvRedacted(t *testing.T) {
+	t.Setenv("LSP_RECORDER_TEST_TOKEN", "secret-value")
+	buf := &syncBuffer{}
+	_, err := Run("sh", []string{"-c", "exit 0"}, NewLogger(buf), RunOptions{ServerEnv: []string{"API_KEY=abc"},
+		ClientIn: strings.NewReader(""), ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "secret-value")
+	reader := NewLogReader(strings.NewReader(buf.String()))
+	for {
+		d, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		switch d.payloadType {
+		case SESSION_START:
+			var start SessionStart
+			require.NoError(t, json.Unmarshal(d.payload, &start))
+			assert.Equal(t, []string{"API_KEY=<redacted>"}, start.Env)
+		case ENV:
+			assert.Contains(t, strings.Split(string(d.payload), "\n"), "LSP_RECORDER_TEST_TOKEN=<redacted>")
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "RUST_LOG=info", "TOKEN=secret"}
+	env := applyEnv(environ, []string{"RUST_LOG=debug", "TOKEN", "NEW=a=b", "MISSING"})
+	assert.Equal(t, []string{"PATH=/usr/bin", "RUST_LOG=debug", "NEW=a=b"}, env)
+	assert.Equal(t, []string{"PATH=/usr/bin", "RUST_LOG=info", "TOKEN=secret"}, environ) // not modified
+
+	_, _, _, err := ParseEnvOverride("=value")
+	assert.Error(t, err)
+	key, value, set, err := ParseEnvOverride("EMPTY=")
+	require.NoError(t, err)
+	assert.Equal(t, "EMPTY", key)
+	assert.Equal(t, "", value)
+	assert.True(t, set)
+}
+
+func TestRecordDrain(t *testing.T) {
+	ch := make(chan LogData, 8)
+	buf := &syncBuffer{}
+	done := make(chan struct{})
+	go func() {
+		record(context.Background(), ch, NewLogger(buf), NewSession(nil), &RunOptions{})
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: RAW, payload: []byte(fmt.Sprintf("line %d", i))}
+	}
+	close(ch)
+	<-done // returns after all records are written
+	assert.Equal(t, 100, strings.Count(buf.String(), `"type":"raw"`))
+}
+
+func TestRecordPeers(t *testing.T) {
+	ch := make(chan LogData, 8)
+	buf := &syncBuffer{}
+	ch <- LogData{timestamp: time.Now(), streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"clientInfo":{"name":"vim"}}}`)}
+	ch <- LogData{timestamp: time.Now(), streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"serverInfo":{"name":"gopls","version":"v0.15.2"}}}`)}
+	ch <- LogData{timestamp: time.Now(), streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"initialize","params":{"clientInfo":{"name":"vim"}}}`)}
+	ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: TRAILER}
+	close(ch)
+	record(context.Background(), ch, NewLogger(buf), NewSession(nil), &RunOptions{})
+
+	var metas []string
+	r := NewLogReader(strings.NewReader(buf.String()))
+	for {
+		d, err := r.Next()
+		if err != nil {
+			break
+		}
+		if d.payloadType == META {
+			metas = append(metas, fmt.Sprintf("%d %s", d.seq, d.payload))
+		}
+	}
+	assert.Equal(t, []string{
+		`3 {"client":{"name":"vim"},"server":{"name":"gopls","version":"v0.15.2"},"supplement":true}`,
+		`5 {"client":{"name":"vim"},"supplement":true}`, // before trailer
+	}, metas)
+}
+
+func TestRunDrainBurstBeforeExit(t *testing.T) {
+	clientIn, clientWriter := io.Pipe() // client keeps stdin open
+	defer func() {
+		_ = clientWriter.Close()
+	}()
+	defer func(w io.Writer) {
+		StderrWriter = w
+	}(StderrWriter)
+	StderrWriter = io.Discard
+	buf := &syncBuffer{}
+	script := `i=0; while [ $i -lt 500 ]; do printf 'Content-Length: 2\r\n\r\n{}'; echo "line $i" >&2; i=$((i+1)); done`
+	status, err := Run("sh", []string{"-c", script}, NewLogger(buf), RunOptions{KillTimeout: time.Second, NoEnv: true,
+		ClientIn: clientIn, ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Code)
+
+	reader := NewLogReader(strings.NewReader(buf.String()))
+	stderr := strings.Builder{}
+	messages := 0
+	var last PayloadType
+	for {
+		d, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if d.streamType == STDOUT && d.payloadType == JSON {
+			messages++
+		}
+		if d.streamType == STDERR && d.payloadType == RAW {
+			stderr.Write(d.payload)
+		}
+		last = d.payloadType
+	}
+	assert.Equal(t, 500, messages)
+	for i := 0; i < 500; i++ {
+		assert.Contains(t, stderr.String(), fmt.Sprintf("line %d\n", i))
+	}
+	assert.Equal(t, TRAILER, last)
+}
+
+func TestRunServerStdinBroken(t *testing.T) {
+	clientIn, clientWriter := io.Pipe()
+	defer func() {
+		_ = clientWriter.Close()
+	}()
+	go func() {
+		time.Sleep(300 * time.Millisecond) // after server closed stdin
+		_, _ = clientWriter.Write([]byte("Content-Length: 2\r\n\r\n{}"))
+	}()
+	buf := &syncBuffer{}
+	start := time.Now()
+	status, err := Run("sh", []string{"-c", "exec 0<&-; exec sleep 10"}, NewLogger(buf),
+		RunOptions{KillTimeout: time.Second, NoEnv: true, ClientIn: clientIn, ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second, "server is not terminated")
+	assert.Equal(t, syscall.SIGTERM, status.Signal)
+	assert.Regexp(t, `"stream":"stdin","type":"raw_end",.*"payload":"write error: `, buf.String())
+}
+
+func TestRunAutoShutdown(t *testing.T) {
+	// server exits only by exit notification (client disconnects without shutdown/exit)
+	buf := &syncBuffer{}
+	clientOut := &syncBuffer{}
+	status, err := Run("sh", []string{"-c", `grep -q '"method":"exit"' && exit 0; exec sleep 10`}, NewLogger(buf),
+		RunOptions{KillTimeout: time.Second, NoEnv: true, AutoShutdown: true, AutoShutdownTimeout: 5 * time.Second,
+			ClientIn: strings.NewReader("Content-Length: 2\r\n\r\n{}"), ClientOut: clientOut})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Code)
+	assert.Empty(t, clientOut.String())
+
+	var synthetic []string
+	var trailer *Trailer
+	reader := NewLogReader(strings.NewReader(buf.String()))
+	for {
+		d, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if d.synthetic {
+			assert.Equal(t, STDIN, d.streamType)
+			synthetic = append(synthetic, string(d.payload))
+		}
+		if d.payloadType == TRAILER {
+			trailer = &Trailer{}
+			require.NoError(t, json.Unmarshal(d.payload, trailer))
+		}
+	}
+	assert.Equal(t, autoShutdownMessages, synthetic)
+	require.NotNil(t, trailer)
+	assert.True(t, trailer.Shutdown.AutoShutdown)
+	assert.False(t, trailer.Shutdown.ShutdownSent) // not client traffic
+	assert.Empty(t, trailer.Shutdown.Violations)
+	assert.Equal(t, "server exited with 0 after auto shutdown on client disconnection", trailer.Summary.Outcome)
+
+	// client sent exit by itself
+	buf = &syncBuffer{}
+	exit := `{"jsonrpc":"2.0","method":"exit"}`
+	_, err = Run("sh", []string{"-c", "cat > /dev/null"}, NewLogger(buf), RunOptions{NoEnv: true, AutoShutdown: true,
+		ClientIn: strings.NewReader(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(exit), exit)), ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), `"synthetic":true`)
+}
+
+func TestRunAutoShutdownKill(t *testing.T) {
+	buf := &syncBuffer{}
+	start := time.Now()
+	status, err := Run("sh", []string{"-c", "exec sleep 10"}, NewLogger(buf), RunOptions{NoEnv: true,
+		AutoShutdown: true, AutoShutdownTimeout: 100 * time.Millisecond, ClientIn: strings.NewReader(""),
+		ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second, "server is not killed")
+	assert.Equal(t, syscall.SIGKILL, status.Signal)
+	assert.Contains(t, buf.String(), killPrefix+"100ms since auto shutdown")
+}
+
+func TestRunKillTimeout(t *testing.T) {
+	// server hangs after client closed, and its helper keeps output pipes open
+	buf := &syncBuffer{}
+	start := time.Now()
+	status, err := Run("sh", []string{"-c", "sleep 10 & exec sleep 10"}, NewLogger(buf), RunOptions{NoEnv: true,
+		KillTimeout: 100 * time.Millisecond, ClientIn: strings.NewReader(""), ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), outputDrainTimeout, "helper is not killed")
+	assert.Equal(t, syscall.SIGKILL, status.Signal)
+	assert.Contains(t, buf.String(), killPrefix+"100ms since client closed")
+	assert.Contains(t, buf.String(), `\"exit_code\":137`) // trailer is written after kill
+}
+
+func TestRunContextCutShort(t *testing.T) {
+	buf := &syncBuffer{}
+	clientIn, _ := io.Pipe() // client keeps connection open
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 200*time.Millisecond, errors.New("duration 200ms elapsed"))
+	defer cancel()
+	start := time.Now()
+	status, err := RunContext(ctx, "sh", []string{"-c", "exec sleep 10"}, NewLogger(buf), RunOptions{NoEnv: true,
+		ClientIn: clientIn, ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second, "server is not shut down")
+	assert.True(t, status.CutShort)
+	assert.Equal(t, syscall.SIGTERM, status.Signal)
+	assert.Contains(t, buf.String(), "forward signal: terminated")
+	assert.Contains(t, buf.String(), cutShortPrefix+"duration 200ms elapsed")
+	assert.NotContains(t, buf.String(), signalEndPrefix)
+	assert.Contains(t, buf.String(), `"elapsed_ns":`)
+
+	trailer, err := ReadTrailer(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "duration 200ms elapsed", trailer.Summary.CutShort)
+	assert.Equal(t, cutShortPrefix+"duration 200ms elapsed", trailer.Summary.Outcome)
+}
+
+func TestRunContextNotCutShort(t *testing.T) {
+	// server exits after client, so it is not failure of start
+	status, err := RunContext(context.Background(), "sh", []string{"-c", "cat > /dev/null; exit 3"},
+		NewLogger(io.Discard), RunOptions{NoEnv: true, ClientIn: strings.NewReader(""), ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.False(t, status.CutShort)
+	assert.Equal(t, 3, status.Code)
+}
+
+var benchPayload = []byte(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.txt","diagnostics":[]}}`)
+
+func TestPipelineTimingAllocs(t *testing.T) {
+	logger := NewLogger(io.Discard)
+	d := LogData{seq: 1, timestamp: time.Now(), streamType: STDOUT, payloadType: JSON, payload: benchPayload}
+	base := testing.AllocsPerRun(100, func() { writeLogData(logger, &d) })
+	d.queueTime = 1234
+	d.prevWriteTime = 5678
+	timed := testing.AllocsPerRun(100, func() { writeLogData(logger, &d) })
+	assert.Equal(t, base, timed, "pipeline timing must not allocate")
+}
+
+func BenchmarkRecord(b *testing.B) {
+	ch := make(chan LogData, 32)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go record(ctx, ch, NewLogger(io.Discard), NewSession(nil), &RunOptions{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ch <- LogData{timestamp: time.Now(), streamType: STDOUT, payloadType: JSON, payload: benchPayload}
+	}
+}
+
+func TestInterceptSpill(t *testing.T) {
+	payload := `{"jsonrpc":"2.0","id":1,"result":"` + strings.Repeat("x", 10000) + `"}`
+	small := `{"jsonrpc":"2.0","id":2,"result":null}`
+	input := frame(payload) + frame(small) + "Content-Length: 9000\r\n\r\n" + payload[:5000]
+	dir := t.TempDir()
+	ch := make(chan LogData, 32)
+	writer := bytes.Buffer{}
+	require.NoError(t, intercept(context.Background(), STDOUT, strings.NewReader(input), &writer, ch,
+		RunOptions{SpillOver: 1000, SpillDir: dir}))
+	close(ch)
+	var records []LogData
+	for d := range ch {
+		records = append(records, d)
+	}
+	assert.Equal(t, input, writer.String()) // framing is kept
+	require.Len(t, records, 4)
+
+	assert.Equal(t, JSON, records[0].payloadType)
+	assert.Equal(t, payload[:1000], string(records[0].payload))
+	assert.Equal(t, len(payload), records[0].originalSize)
+	spilled, err := os.ReadFile(records[0].spill)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(spilled))
+
+	assert.Equal(t, small, string(records[1].payload)) // not spilled
+	assert.Empty(t, records[1].spill)
+
+	assert.Equal(t, INCOMPLETE, records[2].payloadType) // received part of incomplete message is also spilled
+	assert.Equal(t, 5000, records[2].originalSize)
+	spilled, err = os.ReadFile(records[2].spill)
+	require.NoError(t, err)
+	assert.Equal(t, payload[:5000], string(spilled))
+
+	// log has prefix and path of spill file
+	log := newTestLog(records[0])
+	assert.Contains(t, log, fmt.Sprintf(`"truncated":true,"original_size":%d,"spill":`, len(payload)))
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(),
+		fmt.Sprintf("truncated, original %d bytes, whole payload in %s)", len(payload), records[0].spill))
+	d, err := NewLogReader(strings.NewReader(log)).Next()
+	require.NoError(t, err)
+	assert.Equal(t, records[0].spill, d.spill)
+}
@@ -0,0 +1,183 @@
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultRedactFields are fields of document contents redacted by default (METHOD=PATH, see ParseRedactField)
+var DefaultRedactFields = []string{
+	"textDocument/didOpen=params.textDocument.text",
+	"textDocument/didChange=params.contentChanges[].text",
+}
+
+// redactPath is path of string field like params.contentChanges[].text ("[]" means each element of array)
+type redactPath []string
+
+// Redactor replaces string fields of messages with placeholder having only their length and hash
+type Redactor struct {
+	fields map[string][]redactPath // key is method
+}
+
+// ParseRedactField parses METHOD=PATH (path is dot-separated keys, and key followed by [] is array)
+func ParseRedactField(s string) (string, redactPath, error) {
+	method, path, ok := strings.Cut(s, "=")
+	if !ok || method == "" || path == "" {
+		return "", nil, fmt.Errorf("redacted field must be METHOD=PATH (e.g. textDocument/didOpen=params.textDocument.text): '%s'", s)
+	}
+	var p redactPath
+	for _, key := range strings.Split(path, ".") {
+		key, array := strings.CutSuffix(key, "[]")
+		if key == "" {
+			return "", nil, fmt.Errorf("empty key in path of redacted field: '%s'", s)
+		}
+		p = append(p, key)
+		if array {
+			p = append(p, "[]")
+		}
+	}
+	return method, p, nil
+}
+
+// NewRedactor creates Redactor of DefaultRedactFields and additional fields (METHOD=PATH)
+func NewRedactor(fields []string) (*Redactor, error) {
+	r := &Redactor{fields: map[string][]redactPath{}}
+	for _, field := range append(append([]string{}, DefaultRedactFields...), fields...) {
+		method, path, err := ParseRedactField(field)
+		if err != nil {
+			return nil, err
+		}
+		r.fields[method] = append(r.fields[method], path)
+	}
+	return r, nil
+}
+
+// redactPlaceholder returns placeholder of redacted text
+func redactPlaceholder(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return fmt.Sprintf("<redacted %d bytes sha256:%s>", len(text), hex.EncodeToString(sum[:8]))
+}
+
+// marshalJSON is json.Marshal without escaping of '<', '>' and '&'
+func marshalJSON(v any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// redactValue replaces string at path of value. Returns false if nothing is replaced
+func redactValue(value json.RawMessage, path redactPath) (json.RawMessage, bool) {
+	if len(path) == 0 {
+		var text string
+		if json.Unmarshal(value, &text) != nil {
+			return value, false // not string
+		}
+		data, _ := marshalJSON(redactPlaceholder(text))
+		return data, true
+	}
+	if path[0] == "[]" {
+		var elements []json.RawMessage
+		if json.Unmarshal(value, &elements) != nil {
+			return value, false
+		}
+		redacted := false
+		for i := range elements {
+			var ok bool
+			if elements[i], ok = redactValue(elements[i], path[1:]); ok {
+				redacted = true
+			}
+		}
+		if !redacted {
+			return value, false
+		}
+		data, _ := marshalJSON(elements)
+		return data, true
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(value, &object) != nil {
+		return value, false
+	}
+	child, ok := object[path[0]]
+	if !ok {
+		return value, false
+	}
+	if object[path[0]], ok = redactValue(child, path[1:]); !ok {
+		return value, false
+	}
+	data, _ := marshalJSON(object)
+	return data, true
+}
+
+// Redact returns payload whose fields of its method are redacted (payload itself if nothing is redacted).
+// Each element of JSON-RPC batch is redacted by its method
+func (r *Redactor) Redact(payload []byte) []byte {
+	var elements []json.RawMessage
+	if isBatch(payload) && json.Unmarshal(payload, &elements) == nil {
+		redacted := false
+		for i, element := range elements {
+			if data := r.Redact(element); !bytes.Equal(data, element) {
+				elements[i], redacted = data, true
+			}
+		}
+		if !redacted {
+			return payload
+		}
+		data, _ := marshalJSON(elements)
+		return data
+	}
+	e, err := ParseEnvelope(payload)
+	if err != nil || e.Method == "" {
+		return payload
+	}
+	for _, path := range r.fields[e.Method] {
+		if data, ok := redactValue(payload, path); ok {
+			payload = data
+		}
+	}
+	return payload
+}
+
+// RedactLog reads all records, redacts JSON payloads by redactor and anonymizes payloads by anonymizer
+// (each may be nil), and writes them in format (see NewFormatLogger for level). Returns number of rewritten records
+func RedactLog(reader *LogReader, writer io.Writer, redactor *Redactor, anonymizer *URIAnonymizer, format string,
+	level int) (int, error) {
+	logger, closer, err := NewFormatLogger(writer, format, level)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for {
+		d, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			_ = closer.Close()
+			return count, err
+		}
+		payload := d.payload
+		if redactor != nil && d.payloadType == JSON {
+			payload = redactor.Redact(payload)
+		}
+		if anonymizer != nil {
+			payload = anonymizer.Anonymize(d.payloadType, payload)
+		}
+		if !bytes.Equal(payload, d.payload) {
+			d.payload = payload
+			d.checksum = "" // digest in trailer is no longer verifiable
+			count++
+		}
+		writeLogData(logger, d)
+	}
+	return count, closer.Close()
+}
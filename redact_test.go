@@ -0,0 +1,78 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	redactor, err := NewRedactor(nil)
+	require.NoError(t, err)
+	didOpen := `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.go","languageId":"go","version":1,"text":"package a\n"}}}`
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"languageId":"go","text":"<redacted 10 bytes sha256:7b39baa38a2ec2b8>","uri":"file:///a.go","version":1}}}`,
+		string(redactor.Redact([]byte(didOpen))))
+
+	didChange := `{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.go","version":2},"contentChanges":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}},"text":"x < y"},{"text":"package a\n"}]}}`
+	redacted := string(redactor.Redact([]byte(didChange)))
+	assert.Contains(t, redacted, `"contentChanges":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}},"text":"<redacted 5 bytes sha256:`)
+	assert.Contains(t, redacted, `{"text":"<redacted 10 bytes sha256:7b39baa38a2ec2b8>"}]`)
+	assert.Contains(t, redacted, `"textDocument":{"uri":"file:///a.go","version":2}`)
+
+	for _, payload := range []string{ // not redacted
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{"text":"x"}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"text":1}}}`,
+		`{"jsonrpc":"2.0","id":1,"result":{"text":"x"}}`,
+	} {
+		assert.Equal(t, payload, string(redactor.Redact([]byte(payload))))
+	}
+}
+
+func TestRedactField(t *testing.T) {
+	redactor, err := NewRedactor([]string{"my/sync=params.items[].content"})
+	require.NoError(t, err)
+	payload := `{"jsonrpc":"2.0","method":"my/sync","params":{"items":[{"content":"secret","name":"a"},{"name":"b"}]}}`
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"my/sync","params":{"items":[{"content":"<redacted 6 bytes sha256:2bb80d537b1da3e3>","name":"a"},{"name":"b"}]}}`,
+		string(redactor.Redact([]byte(payload))))
+
+	for _, field := range []string{"my/sync", "=params.x", "my/sync=", "my/sync=params..x", "my/sync=[]"} {
+		_, err := NewRedactor([]string{field})
+		assert.Error(t, err, field)
+	}
+}
+
+func TestRedactLog(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.go","text":"proprietary"}}}`)},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("proprietary stderr is kept")},
+	)
+	redactor, err := NewRedactor(nil)
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	count, err := RedactLog(NewLogReader(strings.NewReader(log)), &out, redactor, nil, LogFormatJSON, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 1, strings.Count(out.String(), "proprietary"))
+	assert.Contains(t, out.String(), `"method":"textDocument/didOpen"`)
+
+	// still valid log
+	printed := bytes.Buffer{}
+	require.NoError(t, Print(&out, &printed, &PrintFilter{}))
+	assert.Contains(t, printed.String(), `"text": "<redacted 11 bytes sha256:`)
+}
+
+func TestRedactBatch(t *testing.T) {
+	redactor, err := NewRedactor(nil)
+	require.NoError(t, err)
+	batch := `[{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.go","text":"package a\n"}}},` +
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}]`
+	assert.Equal(t, `[{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"text":"<redacted 10 bytes sha256:7b39baa38a2ec2b8>","uri":"file:///a.go"}}},`+
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}]`, string(redactor.Redact([]byte(batch))))
+
+	for _, payload := range []string{`[]`, `[{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}]`, `[1`} {
+		assert.Equal(t, payload, string(redactor.Redact([]byte(payload))))
+	}
+}
@@ -0,0 +1,410 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// replayStep is a client message to be re-sent and what the original client waited on before sending it
+type replayStep struct {
+	payload []byte
+	gap     time.Duration     // delay from previous client message in original session
+	waits   []json.RawMessage // original ids of client requests answered before this message
+	method  string            // method of server request if this message is response to it
+	nth     int               // index of the server request among requests having the same method
+	request string            // original id (see idKey) if this message is client request
+}
+
+// replayLatency is latency of client request in original and replayed sessions (negative if not answered)
+type replayLatency struct {
+	method     string
+	recorded   time.Duration
+	replayed   time.Duration
+	recordedAt time.Time // when request was sent in original session
+	sentAt     time.Time // when request was replayed (zero if not sent)
+}
+
+// Replayer re-sends client traffic (STDIN records) of recorded log to a live server.
+// Ids of client requests are rewritten by IdRemapper, and responses to server requests
+// are sent with ids of the corresponding live server requests (matched by method and order)
+type Replayer struct {
+	Speed       ReplaySpeed   // scale of original inter-message delay (0 means as fast as possible)
+	WaitTimeout time.Duration // max duration of waiting for response (or server request)
+	Compare     bool          // keep responses and diagnostics of live server for CompareRecording
+	Stepper     *Stepper      // pause before each message until user advances (nil if not stepping)
+
+	steps    []*replayStep
+	diag     io.Writer
+	remapper *IdRemapper
+
+	mutex          sync.Mutex
+	answered       map[string]bool              // original ids of answered client requests
+	latencies      map[string]*replayLatency    // latency of client requests (key is original id)
+	serverRequests map[string][]json.RawMessage // live ids of server requests of each method
+	changed        chan struct{}                // closed when above state is changed
+
+	recorded            map[string][]byte          // recorded responses to client requests (key is original id)
+	replayed            map[string][]byte          // live responses to client requests (key is original id)
+	recordedDiagnostics map[string]json.RawMessage // last recorded diagnostics of each uri
+	replayedDiagnostics map[string]json.RawMessage // last live diagnostics of each uri
+}
+
+// NewReplayer reads log and extracts client messages. Diagnostics (e.g. wait timeout) are written to diag
+func NewReplayer(reader io.Reader, diag io.Writer) (*Replayer, error) {
+	r := &Replayer{
+		WaitTimeout:    10 * time.Second,
+		diag:           diag,
+		remapper:       NewIdRemapper(),
+		answered:       map[string]bool{},
+		latencies:      map[string]*replayLatency{},
+		serverRequests: map[string][]json.RawMessage{},
+		changed:        make(chan struct{}),
+
+		recorded:            map[string][]byte{},
+		replayed:            map[string][]byte{},
+		recordedDiagnostics: map[string]json.RawMessage{},
+		replayedDiagnostics: map[string]json.RawMessage{},
+	}
+	pending := map[string]json.RawMessage{} // outstanding client requests
+	var answered []json.RawMessage          // answered but not yet waited on
+	serverRequests := map[string]string{}   // method of outstanding server requests
+	serverRequestNth := map[string]int{}    // index of server request (key is id)
+	methodCount := map[string]int{}
+	var last time.Time
+	logReader := NewLogReader(reader)
+	for {
+		d, err := logReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.payloadType != JSON || d.streamType == STDERR {
+			continue
+		}
+		e, err := d.Envelope()
+		if err != nil {
+			continue
+		}
+		if d.streamType == STDOUT {
+			switch {
+			case e.IsResponse():
+				if id, ok := pending[idKey(e.Id)]; ok {
+					delete(pending, idKey(e.Id))
+					answered = append(answered, id)
+					l := r.latencies[idKey(e.Id)]
+					l.recorded = d.timestamp.Sub(l.recordedAt)
+					r.recorded[idKey(e.Id)] = d.payload
+				}
+			case e.IsRequest():
+				serverRequests[idKey(e.Id)] = e.Method
+				serverRequestNth[idKey(e.Id)] = methodCount[e.Method]
+				methodCount[e.Method]++
+			case e.Method == publishDiagnostics:
+				if uri, diagnostics, ok := parseDiagnostics(d.payload); ok {
+					r.recordedDiagnostics[uri] = diagnostics
+				}
+			}
+			continue
+		}
+		step := &replayStep{payload: d.payload, waits: answered}
+		answered = nil
+		if !last.IsZero() {
+			step.gap = d.timestamp.Sub(last)
+		}
+		last = d.timestamp
+		switch {
+		case e.IsRequest():
+			pending[idKey(e.Id)] = e.Id
+			step.request = idKey(e.Id)
+			r.latencies[step.request] = &replayLatency{method: e.Method, recorded: -1, replayed: -1,
+				recordedAt: d.timestamp}
+		case e.IsResponse():
+			if method, ok := serverRequests[idKey(e.Id)]; ok {
+				delete(serverRequests, idKey(e.Id))
+				step.method = method
+				step.nth = serverRequestNth[idKey(e.Id)]
+			}
+		}
+		r.steps = append(r.steps, step)
+	}
+	return r, nil
+}
+
+// Len returns the number of client messages to be replayed
+func (r *Replayer) Len() int {
+	return len(r.steps)
+}
+
+func (r *Replayer) update(f func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	f()
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// waitFor waits until cond is satisfied. Returns false if deadline is exceeded
+func (r *Replayer) waitFor(deadline time.Time, cond func() bool) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		r.mutex.Lock()
+		ok := cond()
+		changed := r.changed
+		r.mutex.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+func (r *Replayer) report(format string, args ...any) {
+	_, _ = fmt.Fprintf(r.diag, "replay: "+format+"\n", args...)
+}
+
+// prepare waits for dependencies of step and returns payload to be sent (nil if not sent)
+func (r *Replayer) prepare(step *replayStep) []byte {
+	deadline := time.Now().Add(r.WaitTimeout)
+	for _, id := range step.waits {
+		if !r.waitFor(deadline, func() bool { return r.answered[idKey(id)] }) {
+			r.report("timeout waiting for response of request id=%s", idKey(id))
+			break
+		}
+	}
+	payload := step.payload
+	if step.method != "" {
+		var liveId json.RawMessage
+		if !r.waitFor(deadline, func() bool {
+			if ids := r.serverRequests[step.method]; step.nth < len(ids) {
+				liveId = ids[step.nth]
+				return true
+			}
+			return false
+		}) {
+			r.report("skip response to %s since server does not send the request", step.method)
+			return nil
+		}
+		if m, err := decodeMessage(payload); err == nil {
+			m["id"] = liveId
+			if v, err := json.Marshal(m); err == nil {
+				payload = v
+			}
+		}
+	}
+	r.mutex.Lock()
+	v, err := r.remapper.Outgoing(payload)
+	r.mutex.Unlock()
+	if err == nil {
+		payload = v
+	} else {
+		r.report("send message as is: %v", err)
+	}
+	return payload
+}
+
+// stepSummary returns summary of message to be sent shown by Stepper (like "[2/4] request hover id=3")
+func (r *Replayer) stepSummary(i int, payload []byte) string {
+	summary := "message"
+	if e, err := ParseEnvelope(payload); err == nil {
+		switch {
+		case e.IsRequest():
+			summary = fmt.Sprintf("request %s id=%s", e.Method, e.Id)
+		case e.IsNotification():
+			summary = "notification " + e.Method
+		case e.IsResponse():
+			summary = fmt.Sprintf("response id=%s", e.Id)
+		}
+	}
+	return fmt.Sprintf("[%d/%d] %s", i+1, len(r.steps), summary)
+}
+
+// Replay writes client messages to writer (stdin of server) in original order.
+// If Stepper is set, replay stops when its input is closed
+func (r *Replayer) Replay(writer io.Writer) error {
+	var lastSent time.Time
+	for i, step := range r.steps {
+		payload := r.prepare(step)
+		if payload == nil {
+			continue
+		}
+		if r.Stepper != nil {
+			if err := r.Stepper.Wait(r.stepSummary(i, payload)); err == io.EOF {
+				r.report("stopped at %d of %d messages", i, len(r.steps))
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if !lastSent.IsZero() {
+			time.Sleep(time.Until(lastSent.Add(r.Speed.Scale(step.gap))))
+		}
+		lastSent = time.Now()
+		if step.request != "" { // before write, since response may be received before write returns
+			r.mutex.Lock()
+			r.latencies[step.request].sentAt = lastSent
+			r.mutex.Unlock()
+		}
+		if _, err := fmt.Fprintf(writer, "Content-Length: %d\r\n\r\n%s", len(payload), payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Receive reads server messages from reader (stdout of server) until end of stream
+func (r *Replayer) Receive(reader io.Reader) error {
+	return readFrames(reader, func(payload []byte) error {
+		e, err := ParseEnvelope(payload)
+		if err != nil {
+			return nil
+		}
+		r.mutex.Lock()
+		msg, err := r.remapper.Incoming(payload) // remapper is also used by Replay
+		r.mutex.Unlock()
+		if err != nil {
+			return nil
+		}
+		switch {
+		case e.IsRequest():
+			r.update(func() { r.serverRequests[e.Method] = append(r.serverRequests[e.Method], e.Id) })
+		case e.IsResponse():
+			if orig, err := ParseEnvelope(msg); err == nil {
+				now := time.Now()
+				r.update(func() {
+					r.answered[idKey(orig.Id)] = true
+					if l, ok := r.latencies[idKey(orig.Id)]; ok && !l.sentAt.IsZero() && l.replayed < 0 {
+						l.replayed = now.Sub(l.sentAt)
+						if r.Compare {
+							r.replayed[idKey(orig.Id)] = msg
+						}
+					}
+				})
+			}
+		case e.Method == publishDiagnostics && r.Compare:
+			if uri, diagnostics, ok := parseDiagnostics(payload); ok {
+				r.update(func() { r.replayedDiagnostics[uri] = diagnostics })
+			}
+		}
+		return nil
+	}, func(err error) {
+		r.report("skip broken message of server: %v", err)
+	})
+}
+
+// FormatLatencies writes per-method summary of latency of replayed client requests compared with
+// original session. Call after Replay and Receive are finished
+func (r *Replayer) FormatLatencies(writer io.Writer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	type methodLatencies struct {
+		count, unanswered  int
+		recorded, replayed []time.Duration
+	}
+	methods := map[string]*methodLatencies{}
+	for _, l := range r.latencies {
+		if l.sentAt.IsZero() {
+			continue // not replayed
+		}
+		m, ok := methods[l.method]
+		if !ok {
+			m = &methodLatencies{}
+			methods[l.method] = m
+		}
+		m.count++
+		if l.recorded >= 0 {
+			m.recorded = append(m.recorded, l.recorded)
+		}
+		if l.replayed >= 0 {
+			m.replayed = append(m.replayed, l.replayed)
+		} else {
+			m.unanswered++
+		}
+	}
+	format := func(sorted []time.Duration, p float64) string {
+		if len(sorted) == 0 {
+			return "-"
+		}
+		return formatLatency(percentile(sorted, p))
+	}
+	_, _ = fmt.Fprintln(writer, "latency of requests (recorded -> replayed):")
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "method\tcount\tunanswered\tp50\tmax")
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		m := methods[name]
+		recorded, replayed := sortDurations(m.recorded), sortDurations(m.replayed)
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%s -> %s\t%s -> %s\n", name, m.count, m.unanswered,
+			format(recorded, 50), format(replayed, 50), format(recorded, 100), format(replayed, 100))
+	}
+	_ = tw.Flush()
+}
+
+// readFrames reads messages framed by Content-Length header and calls fn with each payload.
+// Broken header is reported to invalid (if not nil) and skipped until next header (see skipToHeader),
+// so that peer is not blocked on full pipe. Stops reading if fn returns error
+func readFrames(reader io.Reader, fn func(payload []byte) error, invalid func(err error)) error {
+	parser := NewContentHeaderParser()
+	buf := bytes.Buffer{}
+	requiredPayloadLen := -1
+	resync := false
+	tmp := make([]byte, 4096)
+	for {
+		n, readErr := reader.Read(tmp)
+		buf.Write(tmp[:n])
+		for {
+			if resync {
+				if !skipToHeader(&buf) {
+					break
+				}
+				resync = false
+			}
+			if requiredPayloadLen < 0 {
+				num, err := parser.Parse(&buf)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					if invalid != nil {
+						invalid(err)
+					}
+					resync = true
+					continue
+				}
+				requiredPayloadLen = num
+			}
+			if buf.Len() < requiredPayloadLen {
+				break
+			}
+			payload := make([]byte, requiredPayloadLen)
+			_, _ = buf.Read(payload)
+			requiredPayloadLen = -1
+			if err := fn(payload); err != nil {
+				return err
+			}
+		}
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrClosedPipe) {
+				return nil
+			}
+			return readErr
+		}
+	}
+}
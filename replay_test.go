@@ -0,0 +1,183 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var replayTestLog = newTestLog(
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"initialized"}`)},
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("server log")},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":7,"method":"client/registerCapability"}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":7,"result":null}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"exit"}`)},
+)
+
+func TestNewReplayer(t *testing.T) {
+	r, err := NewReplayer(strings.NewReader(replayTestLog), io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, 4, r.Len())
+	assert.Empty(t, r.steps[0].waits)
+	assert.Equal(t, []json.RawMessage{json.RawMessage("1")}, r.steps[1].waits)
+	assert.Equal(t, 2*time.Second, r.steps[1].gap)
+	assert.Equal(t, "client/registerCapability", r.steps[2].method)
+	assert.Equal(t, 0, r.steps[2].nth)
+	assert.Empty(t, r.steps[3].method)
+}
+
+// fakeServer answers initialize after delay and sends registerCapability request with id 100.
+// Returns received messages (with whether initialize response was already sent) after exit
+func fakeServer(t *testing.T, in io.Reader, out io.WriteCloser, delay time.Duration) <-chan []string {
+	done := make(chan []string, 1)
+	go func() {
+		defer func() {
+			_ = out.Close()
+		}()
+		var received []string
+		answered := false
+		send := func(msg string) {
+			_, _ = fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+		}
+		_ = readFrames(in, func(payload []byte) error {
+			received = append(received, fmt.Sprintf("%s (answered=%v)", payload, answered))
+			e, err := ParseEnvelope(payload)
+			if !assert.NoError(t, err) {
+				return err
+			}
+			switch e.Method {
+			case "initialize":
+				time.Sleep(delay)
+				answered = true
+				send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{}}`, e.Id))
+			case "initialized":
+				send(`{"jsonrpc":"2.0","id":100,"method":"client/registerCapability"}`)
+			}
+			return nil
+		}, func(err error) { assert.NoError(t, err) })
+		done <- received
+	}()
+	return done
+}
+
+func TestReplay(t *testing.T) {
+	r, err := NewReplayer(strings.NewReader(replayTestLog), io.Discard)
+	require.NoError(t, err)
+	r.WaitTimeout = 5 * time.Second
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	done := fakeServer(t, serverIn, serverOut, 50*time.Millisecond)
+	received := make(chan struct{})
+	go func() {
+		_ = r.Receive(clientIn)
+		close(received)
+	}()
+	require.NoError(t, r.Replay(clientOut))
+	require.NoError(t, clientOut.Close())
+	assert.Equal(t, []string{
+		`{"id":1,"jsonrpc":"2.0","method":"initialize"} (answered=false)`,
+		`{"jsonrpc":"2.0","method":"initialized"} (answered=true)`,
+		`{"id":100,"jsonrpc":"2.0","result":null} (answered=true)`,
+		`{"jsonrpc":"2.0","method":"exit"} (answered=true)`,
+	}, <-done)
+
+	<-received
+	out := strings.Builder{}
+	r.FormatLatencies(&out)
+	assert.Regexp(t, `^latency of requests \(recorded -> replayed\):\n`+
+		`method +count +unanswered +p50 +max\n`+
+		`initialize +1 +0 +1s -> [0-9.]+ms +1s -> [0-9.]+ms\n$`, out.String())
+}
+
+func TestReplayOriginalTiming(t *testing.T) {
+	r, err := NewReplayer(strings.NewReader(replayTestLog), io.Discard)
+	require.NoError(t, err)
+	r.Speed = 60 // original gaps are 2s, 3s and 1s
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	done := fakeServer(t, serverIn, serverOut, 0)
+	go func() {
+		_ = r.Receive(clientIn)
+	}()
+	start := time.Now()
+	require.NoError(t, r.Replay(clientOut))
+	elapsed := time.Since(start)
+	require.NoError(t, clientOut.Close())
+	assert.Len(t, <-done, 4)
+	assert.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestReplayTimeout(t *testing.T) {
+	r, err := NewReplayer(strings.NewReader(replayTestLog), io.Discard)
+	require.NoError(t, err)
+	r.WaitTimeout = 10 * time.Millisecond
+	diag := strings.Builder{}
+	r.diag = &diag
+	serverIn, clientOut := io.Pipe()
+	go func() {
+		_ = readFrames(serverIn, func([]byte) error { return nil }, nil) // never answer
+	}()
+	require.NoError(t, r.Replay(clientOut))
+	assert.Equal(t, "replay: timeout waiting for response of request id=1\n"+
+		"replay: skip response to client/registerCapability since server does not send the request\n", diag.String())
+}
+
+func TestReplayStep(t *testing.T) {
+	r, err := NewReplayer(strings.NewReader(replayTestLog), io.Discard)
+	require.NoError(t, err)
+	r.WaitTimeout = 5 * time.Second
+	diag := strings.Builder{}
+	r.diag = &diag
+	prompt := strings.Builder{}
+	r.Stepper = NewStepper(strings.NewReader("\n2\n"), &prompt) // input is closed before exit
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	done := fakeServer(t, serverIn, serverOut, 0)
+	go func() {
+		_ = r.Receive(clientIn)
+	}()
+	require.NoError(t, r.Replay(clientOut))
+	require.NoError(t, clientOut.Close())
+	assert.Len(t, <-done, 3)
+	assert.Equal(t, "[1/4] request initialize id=1\n[Enter/count] > "+
+		"[2/4] notification initialized\n[Enter/count] > "+
+		"[4/4] notification exit\n[Enter/count] > ", prompt.String())
+	assert.Equal(t, "replay: stopped at 3 of 4 messages\n", diag.String())
+}
+
+func TestReadFramesResync(t *testing.T) {
+	stream := "Content-Length: 5\r\nhello" + // broken header
+		"Content-Length: 2\r\n\r\n{}" +
+		"Content-Type: x\r\n\r\n" + // broken header
+		"Content-Length: 4\r\n\r\nnull"
+	var payloads []string
+	var errs []string
+	require.NoError(t, readFrames(strings.NewReader(stream), func(payload []byte) error {
+		payloads = append(payloads, string(payload))
+		return nil
+	}, func(err error) {
+		errs = append(errs, err.Error())
+	}))
+	assert.Equal(t, []string{"{}", "null"}, payloads)
+	assert.Len(t, errs, 2)
+}
+
+func TestReplayReceiveBrokenHeader(t *testing.T) {
+	r, err := NewReplayer(strings.NewReader(replayTestLog), io.Discard)
+	require.NoError(t, err)
+	diag := strings.Builder{}
+	r.diag = &diag
+	msg := `{"jsonrpc":"2.0","id":100,"method":"client/registerCapability"}`
+	stream := fmt.Sprintf("Content-Lenght: 3\r\n\r\nabcContent-Length: %d\r\n\r\n%s", len(msg), msg)
+	require.NoError(t, r.Receive(strings.NewReader(stream)))
+	assert.Len(t, r.serverRequests["client/registerCapability"], 1) // received after broken header
+	assert.Contains(t, diag.String(), "replay: skip broken message of server: invalid message header")
+}
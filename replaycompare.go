@@ -0,0 +1,235 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// publishDiagnostics is notification of server compared per uri by Replayer.CompareRecording
+const publishDiagnostics = "textDocument/publishDiagnostics"
+
+// parseDiagnostics returns uri and diagnostics of publishDiagnostics notification
+func parseDiagnostics(payload []byte) (string, json.RawMessage, bool) {
+	var m struct {
+		Params struct {
+			URI         string          `json:"uri"`
+			Diagnostics json.RawMessage `json:"diagnostics"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(payload, &m) != nil || m.Params.URI == "" {
+		return "", nil, false
+	}
+	return m.Params.URI, m.Params.Diagnostics, true
+}
+
+// ReplayMismatch is difference between recorded and replayed response of client request, or between last
+// diagnostics of document published in recorded and replayed sessions
+type ReplayMismatch struct {
+	Method     string
+	Id         string   // original id of request (see idKey), or uri of diagnostics
+	Paths      []string // JSON pointers of differing values of response
+	Unanswered bool     // request is answered in recorded session, but not in replayed one
+	Missing    int      // recorded diagnostics not published in replayed session
+	Unexpected int      // replayed diagnostics not published in recorded session
+}
+
+// ReplayComparison is result of Replayer.CompareRecording
+type ReplayComparison struct {
+	Requests   int // requests answered in recorded session and replayed
+	Documents  int // documents having diagnostics in either session
+	Mismatches []ReplayMismatch
+}
+
+// jsonPointerEscaper escapes reference token of JSON pointer (RFC 6901)
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// stripPaths removes values whose JSON pointers match any of ignore globs (see MatchMethod)
+func stripPaths(v any, path string, ignore []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, e := range v {
+			p := path + "/" + jsonPointerEscaper.Replace(key)
+			if MatchMethod(ignore, p) {
+				delete(v, key)
+			} else {
+				v[key] = stripPaths(e, p, ignore)
+			}
+		}
+	case []any:
+		kept := v[:0]
+		for i, e := range v {
+			p := path + "/" + strconv.Itoa(i)
+			if !MatchMethod(ignore, p) {
+				kept = append(kept, stripPaths(e, p, ignore))
+			}
+		}
+		return kept
+	}
+	return v
+}
+
+// diffPaths returns JSON pointers of values differing between a and b (in order of keys and indices)
+func diffPaths(a, b any, path string) []string {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for key := range a {
+			keys = append(keys, key)
+		}
+		for key := range b {
+			if _, ok := a[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		var paths []string
+		for _, key := range keys {
+			p := path + "/" + jsonPointerEscaper.Replace(key)
+			x, inA := a[key]
+			y, inB := b[key]
+			if inA != inB {
+				paths = append(paths, p)
+			} else {
+				paths = append(paths, diffPaths(x, y, p)...)
+			}
+		}
+		return paths
+	case []any:
+		b, ok := b.([]any)
+		if !ok {
+			break
+		}
+		var paths []string
+		for i := 0; i < max(len(a), len(b)); i++ {
+			p := path + "/" + strconv.Itoa(i)
+			if i >= len(a) || i >= len(b) {
+				paths = append(paths, p)
+			} else {
+				paths = append(paths, diffPaths(a[i], b[i], p)...)
+			}
+		}
+		return paths
+	}
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []string{path}
+}
+
+// decodeCompared decodes message (or diagnostics) and removes ignored values
+func decodeCompared(data []byte, ignore []string) any {
+	var v any
+	if json.Unmarshal(data, &v) != nil {
+		return string(data) // compared as is
+	}
+	return stripPaths(v, "", ignore)
+}
+
+// compareResponses returns JSON pointers differing between recorded and replayed responses.
+// Id and jsonrpc of envelope are not compared
+func compareResponses(recorded, replayed []byte, ignore []string) []string {
+	ignore = append([]string{"/id", "/jsonrpc"}, ignore...)
+	paths := diffPaths(decodeCompared(recorded, ignore), decodeCompared(replayed, ignore), "")
+	if len(paths) > maxPayloadDiffLines {
+		paths = append(paths[:maxPayloadDiffLines], fmt.Sprintf("... %d more paths", len(paths)-maxPayloadDiffLines))
+	}
+	return paths
+}
+
+// compareDiagnostics compares diagnostics as sets and returns number of recorded ones missing in replayed ones and
+// replayed ones not recorded. Ignore globs are matched with JSON pointer in notification like
+// /params/diagnostics/0/data
+func compareDiagnostics(recorded, replayed json.RawMessage, ignore []string) (missing int, unexpected int) {
+	canonicalize := func(diagnostics json.RawMessage) map[string]int {
+		set := map[string]int{}
+		v, _ := decodeCompared(diagnostics, nil).([]any)
+		for i, e := range v {
+			e = stripPaths(e, "/params/diagnostics/"+strconv.Itoa(i), ignore)
+			data, _ := json.Marshal(e) // keys of object are sorted
+			set[string(data)]++
+		}
+		return set
+	}
+	a, b := canonicalize(recorded), canonicalize(replayed)
+	for key, n := range a {
+		missing += max(n-b[key], 0)
+	}
+	for key, n := range b {
+		unexpected += max(n-a[key], 0)
+	}
+	return missing, unexpected
+}
+
+// CompareRecording compares responses of replayed client requests and last diagnostics of each document with
+// recorded ones. Values whose JSON pointers match ignore globs (e.g. */data/*) are not compared.
+// Call after Replay and Receive are finished with Compare set
+func (r *Replayer) CompareRecording(ignore []string) *ReplayComparison {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c := &ReplayComparison{}
+	for _, step := range r.steps {
+		recorded, ok := r.recorded[step.request]
+		if step.request == "" || !ok || r.latencies[step.request].sentAt.IsZero() {
+			continue // not answered in recorded session, or not replayed
+		}
+		c.Requests++
+		method := r.latencies[step.request].method
+		replayed, ok := r.replayed[step.request]
+		if !ok {
+			c.Mismatches = append(c.Mismatches, ReplayMismatch{Method: method, Id: step.request, Unanswered: true})
+		} else if paths := compareResponses(recorded, replayed, ignore); len(paths) > 0 {
+			c.Mismatches = append(c.Mismatches, ReplayMismatch{Method: method, Id: step.request, Paths: paths})
+		}
+	}
+	uris := make([]string, 0, len(r.recordedDiagnostics)+len(r.replayedDiagnostics))
+	for uri := range r.recordedDiagnostics {
+		uris = append(uris, uri)
+	}
+	for uri := range r.replayedDiagnostics {
+		if _, ok := r.recordedDiagnostics[uri]; !ok {
+			uris = append(uris, uri)
+		}
+	}
+	slices.Sort(uris)
+	for _, uri := range uris {
+		c.Documents++
+		missing, unexpected := compareDiagnostics(r.recordedDiagnostics[uri], r.replayedDiagnostics[uri], ignore)
+		if missing > 0 || unexpected > 0 {
+			c.Mismatches = append(c.Mismatches, ReplayMismatch{Method: publishDiagnostics, Id: uri,
+				Missing: missing, Unexpected: unexpected})
+		}
+	}
+	return c
+}
+
+// Format writes mismatches of comparison
+func (c *ReplayComparison) Format(writer io.Writer) {
+	_, _ = fmt.Fprintf(writer, "comparison with recording: %d requests, %d documents, %d mismatches\n", c.Requests,
+		c.Documents, len(c.Mismatches))
+	for _, m := range c.Mismatches {
+		switch {
+		case m.Unanswered:
+			_, _ = fmt.Fprintf(writer, "  %s id=%s: not answered\n", m.Method, m.Id)
+		case m.Method == publishDiagnostics:
+			_, _ = fmt.Fprintf(writer, "  %s %s: %d missing, %d unexpected diagnostics\n", m.Method, m.Id, m.Missing,
+				m.Unexpected)
+		default:
+			_, _ = fmt.Fprintf(writer, "  %s id=%s:\n", m.Method, m.Id)
+			for _, path := range m.Paths {
+				if path == "" {
+					path = "(whole message)"
+				}
+				_, _ = fmt.Fprintf(writer, "      %s\n", path)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareResponses(t *testing.T) {
+	recorded := []byte(`{"jsonrpc":"2.0","id":1,"result":{"items":[{"label":"a","data":{"id":7}},{"label":"b/c"}],"x~":1}}`)
+	replayed := []byte(`{"jsonrpc":"2.0","id":5,"result":{"items":[{"label":"a","data":{"id":9}}],"x~":2,"more":true}}`)
+	assert.Equal(t, []string{"/result/items/0/data/id", "/result/items/1", "/result/more", "/result/x~0"},
+		compareResponses(recorded, replayed, nil))
+	assert.Equal(t, []string{"/result/items/1", "/result/more", "/result/x~0"},
+		compareResponses(recorded, replayed, []string{"*/data/*"}))
+	assert.Equal(t, []string{"/result/more", "/result/x~0"},
+		compareResponses(recorded, replayed, []string{"*/data/*", "/result/items/1"}))
+	assert.Empty(t, compareResponses(recorded, recorded, nil))
+	assert.Equal(t, []string{"/result"}, compareResponses(recorded, []byte(`{"id":1,"result":null}`), nil))
+	assert.Equal(t, []string{""}, compareResponses(recorded, []byte(`Content-Length`), nil))
+}
+
+func TestCompareDiagnostics(t *testing.T) {
+	a := json.RawMessage(`[{"message":"x","range":{"start":1}},{"message":"y","data":1},{"message":"y","data":1}]`)
+	b := json.RawMessage(`[{"message":"y","data":2},{"range":{"start":1},"message":"x"},{"message":"z"}]`)
+	missing, unexpected := compareDiagnostics(a, b, nil)
+	assert.Equal(t, []int{2, 2}, []int{missing, unexpected})
+	missing, unexpected = compareDiagnostics(a, b, []string{"*/data"})
+	assert.Equal(t, []int{1, 1}, []int{missing, unexpected}) // duplicated y and z
+	missing, unexpected = compareDiagnostics(a, nil, nil)
+	assert.Equal(t, []int{3, 0}, []int{missing, unexpected})
+}
+
+var replayCompareTestLog = newTestLog(
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{}}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a","diagnostics":[{"message":"old"}]}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a","diagnostics":[{"message":"x"},{"message":"y"}]}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":2,"result":{"contents":"a","data":{"session":1}}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"result":null}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":4,"method":"textDocument/hover"}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"exit"}`)},
+)
+
+// compareServer answers hover with different contents and data, and publishes diagnostics of file:///a in
+// different order and diagnostics of file:///b
+func compareServer(in io.Reader, out io.WriteCloser) {
+	defer func() {
+		_ = out.Close()
+	}()
+	send := func(msg string) {
+		_, _ = fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	_ = readFrames(in, func(payload []byte) error {
+		e, err := ParseEnvelope(payload)
+		if err != nil {
+			return err
+		}
+		switch e.Method {
+		case "initialize":
+			send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"capabilities":{}}}`, e.Id))
+		case "textDocument/hover":
+			send(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a","diagnostics":[{"message":"y"},{"message":"x"}]}}`)
+			send(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///b","diagnostics":[{"message":"new"}]}}`)
+			send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"contents":"b","data":{"session":2}}}`, e.Id))
+		case "shutdown":
+			send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":null}`, e.Id))
+		}
+		return nil
+	}, nil)
+}
+
+func TestReplayCompare(t *testing.T) {
+	r, err := NewReplayer(strings.NewReader(replayCompareTestLog), io.Discard)
+	require.NoError(t, err)
+	r.WaitTimeout = 5 * time.Second
+	r.Compare = true
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	go compareServer(serverIn, serverOut)
+	received := make(chan struct{})
+	go func() {
+		_ = r.Receive(clientIn)
+		close(received)
+	}()
+	require.NoError(t, r.Replay(clientOut))
+	require.NoError(t, clientOut.Close())
+	<-received
+
+	c := r.CompareRecording([]string{"*/data/*"})
+	assert.Equal(t, 3, c.Requests) // hover of id=4 is not answered in recorded session
+	assert.Equal(t, 2, c.Documents)
+	assert.Equal(t, []ReplayMismatch{
+		{Method: "textDocument/hover", Id: "2", Paths: []string{"/result/contents"}},
+		{Method: publishDiagnostics, Id: "file:///b", Unexpected: 1},
+	}, c.Mismatches)
+
+	out := bytes.Buffer{}
+	c.Format(&out)
+	assert.Equal(t, "comparison with recording: 3 requests, 2 documents, 2 mismatches\n"+
+		"  textDocument/hover id=2:\n"+
+		"      /result/contents\n"+
+		"  textDocument/publishDiagnostics file:///b: 0 missing, 1 unexpected diagnostics\n", out.String())
+
+	c = r.CompareRecording(nil)
+	assert.Equal(t, []string{"/result/contents", "/result/data/session"}, c.Mismatches[0].Paths)
+}
+
+func TestReplayCompareUnanswered(t *testing.T) {
+	r, err := NewReplayer(strings.NewReader(replayCompareTestLog), io.Discard)
+	require.NoError(t, err)
+	r.WaitTimeout = 10 * time.Millisecond
+	r.Compare = true
+	serverIn, clientOut := io.Pipe()
+	go func() {
+		_ = readFrames(serverIn, func([]byte) error { return nil }, nil) // never answer
+	}()
+	require.NoError(t, r.Replay(clientOut))
+	c := r.CompareRecording(nil)
+	require.Len(t, c.Mismatches, 4)
+	assert.Equal(t, ReplayMismatch{Method: "initialize", Id: "1", Unanswered: true}, c.Mismatches[0])
+	assert.Equal(t, ReplayMismatch{Method: publishDiagnostics, Id: "file:///a", Missing: 2}, c.Mismatches[3])
+}
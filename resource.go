@@ -0,0 +1,73 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ResourceSample is resource usage of Language Server process (payload of RESOURCES record).
+// Fields not available on the platform are omitted
+type ResourceSample struct {
+	Pid int           `json:"pid"`
+	Rss int64         `json:"rss_bytes,omitempty"` // resident set size
+	Cpu time.Duration `json:"cpu_ns,omitempty"`    // user and system CPU time since start
+	Fds int           `json:"fds,omitempty"`       // number of open file descriptors (handles on Windows)
+}
+
+// ParseResourceSample parses payload of RESOURCES record
+func ParseResourceSample(payload []byte) (*ResourceSample, error) {
+	s := &ResourceSample{}
+	if err := json.Unmarshal(payload, s); err != nil {
+		return nil, fmt.Errorf("broken resource sample: %w", err)
+	}
+	return s, nil
+}
+
+// String returns sample like "rss 120.5MB, cpu 3.2s, fds 42"
+func (s *ResourceSample) String() string {
+	var fields []string
+	if s.Rss > 0 {
+		fields = append(fields, "rss "+formatSize(int(s.Rss)))
+	}
+	if s.Cpu > 0 {
+		fields = append(fields, "cpu "+s.Cpu.Round(10*time.Millisecond).String())
+	}
+	if s.Fds > 0 {
+		fields = append(fields, fmt.Sprintf("fds %d", s.Fds))
+	}
+	if len(fields) == 0 {
+		return "no data"
+	}
+	return strings.Join(fields, ", ")
+}
+
+// resourceNote is note of RESOURCES record in print
+const resourceNote = "resources"
+
+// sampleResources records resource usage of process every interval until stop is closed.
+// Samples which cannot be read (e.g. process is exiting) are skipped. If the platform is not supported,
+// it is reported once and sampling stops
+func sampleResources(pid int, interval time.Duration, stop <-chan struct{}, ch chan<- LogData) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		sample, err := readResourceSample(pid)
+		if errors.Is(err, errors.ErrUnsupported) {
+			sendMessage(STDERR, fmt.Sprintf("warning: resource sampling stopped: %v", err), ch)
+			return
+		}
+		if err != nil {
+			continue
+		}
+		payload, _ := json.Marshal(sample)
+		ch <- LogData{timestamp: time.Now(), streamType: STDERR, payloadType: RESOURCES, payload: payload}
+	}
+}
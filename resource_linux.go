@@ -0,0 +1,39 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, unit of CPU times in /proc/<pid>/stat (100 on all supported architectures)
+const clockTicks = 100
+
+// readResourceSample reads RSS and CPU time from /proc/<pid>/stat and counts entries of /proc/<pid>/fd
+func readResourceSample(pid int) (*ResourceSample, error) {
+	dir := fmt.Sprintf("/proc/%d", pid)
+	stat, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	// skip pid and comm, since comm may contain spaces and parentheses. fields[0] is the 3rd field (state)
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("broken %s/stat", dir)
+	}
+	utime, err1 := strconv.ParseInt(fields[11], 10, 64)
+	stime, err2 := strconv.ParseInt(fields[12], 10, 64)
+	rss, err3 := strconv.ParseInt(fields[21], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, fmt.Errorf("broken %s/stat", dir)
+	}
+	sample := &ResourceSample{Pid: pid, Rss: rss * int64(os.Getpagesize()),
+		Cpu: time.Duration(utime+stime) * time.Second / clockTicks}
+	if entries, err := os.ReadDir(dir + "/fd"); err == nil {
+		sample.Fds = len(entries)
+	}
+	return sample, nil
+}
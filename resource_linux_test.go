@@ -0,0 +1,19 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestReadResourceSample(t *testing.T) {
+	sample, err := readResourceSample(os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), sample.Pid)
+	assert.Greater(t, sample.Rss, int64(1024*1024))
+	assert.Greater(t, sample.Fds, 2) // at least stdio
+
+	_, err = readResourceSample(-1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+//go:build !linux && !windows
+
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readResourceSample reads RSS and CPU time of process by ps (open fds are not sampled)
+func readResourceSample(pid int) (*ResourceSample, error) {
+	out, err := exec.Command("ps", "-o", "rss=", "-o", "time=", "-p", strconv.Itoa(pid)).Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("ps is not found: %w", errors.ErrUnsupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("unexpected output of ps: %q", out)
+	}
+	rss, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected rss of ps: %q", fields[0])
+	}
+	cpu, err := parsePsTime(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceSample{Pid: pid, Rss: rss * 1024, Cpu: cpu}, nil
+}
+
+// parsePsTime parses cumulative CPU time of ps like "1-02:03:04", "02:03:04" or "3:04.56"
+func parsePsTime(s string) (time.Duration, error) {
+	var total time.Duration
+	days, rest, found := strings.Cut(s, "-")
+	if found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected time of ps: %q", s)
+		}
+		total = time.Duration(n) * 24 * time.Hour
+	} else {
+		rest = s
+	}
+	parts := strings.Split(rest, ":")
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || len(parts) > 3 {
+		return 0, fmt.Errorf("unexpected time of ps: %q", s)
+	}
+	total += time.Duration(seconds * float64(time.Second))
+	unit := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, fmt.Errorf("unexpected time of ps: %q", s)
+		}
+		total += time.Duration(n) * unit
+		unit *= 60
+	}
+	return total, nil
+}
@@ -0,0 +1,52 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResourceSampleRecords(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RESOURCES, payload: []byte(`{"pid":42,"rss_bytes":52428800,"cpu_ns":1234000000,"fds":17}`)},
+		LogData{streamType: STDERR, payloadType: RESOURCES, payload: []byte(`{"pid":42,"rss_bytes":104857600,"cpu_ns":2500000000}`)},
+		LogData{streamType: STDERR, payloadType: RESOURCES, payload: []byte(`{"pid":42,"rss_bytes":73400320}`)},
+		LogData{streamType: STDERR, payloadType: RESOURCES, payload: []byte(`{"pid":`)}, // broken
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Equal(t, `2024-05-01T10:00:00Z <stderr> (resources) rss 50.0MB, cpu 1.23s, fds 17
+2024-05-01T10:00:01Z <stderr> (resources) rss 100.0MB, cpu 2.5s
+2024-05-01T10:00:02Z <stderr> (resources) rss 70.0MB
+2024-05-01T10:00:03Z <stderr> {"pid":
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{Color: true, Head: 1}))
+	assert.Contains(t, out.String(), "(resources) "+ansiDim+"rss 50.0MB, cpu 1.23s, fds 17"+ansiReset+"\n")
+
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Samples)
+	assert.Equal(t, int64(100*1024*1024), stats.PeakRss.Rss)
+	out.Reset()
+	stats.Format(&out)
+	assert.Contains(t, out.String(), "peak rss: 100.0MB at 2024-05-01T10:00:01Z (3 samples)\n")
+}
+
+func TestRunSampleResources(t *testing.T) {
+	buf := &syncBuffer{}
+	status, err := Run("sh", []string{"-c", "cat > /dev/null; sleep 0.3"}, NewLogger(buf), RunOptions{NoEnv: true,
+		SampleResources: 50 * time.Millisecond, ClientIn: strings.NewReader(""), ClientOut: &bytes.Buffer{}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Code)
+	assert.Contains(t, buf.String(), `"type":"resources"`)
+
+	stats, err := CollectMessageStats(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	require.NotNil(t, stats.PeakRss)
+	assert.Greater(t, stats.PeakRss.Rss, int64(0))
+}
@@ -0,0 +1,64 @@
+package recorder
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	procGetProcessMemoryInfo  = syscall.NewLazyDLL("psapi.dll").NewProc("GetProcessMemoryInfo")
+	procGetProcessHandleCount = syscall.NewLazyDLL("kernel32.dll").NewProc("GetProcessHandleCount")
+)
+
+// processQueryLimitedInformation is PROCESS_QUERY_LIMITED_INFORMATION access right
+const processQueryLimitedInformation = 0x1000
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// filetimeDuration converts FILETIME of GetProcessTimes (in 100ns) to duration
+func filetimeDuration(t syscall.Filetime) time.Duration {
+	return time.Duration(int64(t.HighDateTime)<<32|int64(t.LowDateTime)) * 100
+}
+
+// readResourceSample reads CPU times, working set (as RSS) and handle count (as fds) of process
+func readResourceSample(pid int) (*ResourceSample, error) {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return nil, err
+	}
+	defer func(h syscall.Handle) {
+		_ = syscall.CloseHandle(h)
+	}(h)
+	sample := &ResourceSample{Pid: pid}
+	var creation, exit, kernel, user syscall.Filetime
+	if syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user) == nil {
+		sample.Cpu = filetimeDuration(kernel) + filetimeDuration(user)
+	}
+	if procGetProcessMemoryInfo.Find() == nil {
+		counters := processMemoryCounters{}
+		counters.Cb = uint32(unsafe.Sizeof(counters))
+		if r, _, _ := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&counters)), uintptr(counters.Cb)); r != 0 {
+			sample.Rss = int64(counters.WorkingSetSize)
+		}
+	}
+	if procGetProcessHandleCount.Find() == nil {
+		var handles uint32
+		if r, _, _ := procGetProcessHandleCount.Call(uintptr(h), uintptr(unsafe.Pointer(&handles))); r != 0 {
+			sample.Fds = int(handles)
+		}
+	}
+	return sample, nil
+}
@@ -0,0 +1,414 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// restartPrefix is prefix of stderr message of server respawned by recorder (see RunOptions.MaxRestarts),
+// which is the first record of new server session
+const restartPrefix = "restart: "
+
+// maxRestartBackoff is upper bound of delay before server is respawned
+const maxRestartBackoff = time.Minute
+
+// restartBackoff returns delay before n-th (1-based) restart, which is doubled from base for each restart
+func restartBackoff(base time.Duration, n int) time.Duration {
+	backoff := base
+	for i := 1; i < n && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRestartBackoff)
+}
+
+// restartHandshakeTimeout bounds replay of initialize handshake to respawned server. Server not answering
+// initialize within it is killed (and respawned again unless restarts are exhausted)
+var restartHandshakeTimeout = time.Minute
+
+// requestFailedCode is error code of response to client request which exited server never answers
+// (RequestFailed of LSP)
+const requestFailedCode = -32803
+
+// clientRequest is request of client not answered by server yet
+type clientRequest struct {
+	id     json.RawMessage
+	method string
+	order  int // order of sending
+}
+
+// openDocument is document opened by client. Its text follows didChange, so that only one didOpen is replayed
+// however long it has been edited
+type openDocument struct {
+	languageId string
+	version    json.RawMessage
+	text       string
+}
+
+// clientState keeps state of client which is replayed to respawned server: initialize request, initialized
+// notification and open documents. It also tracks requests in flight, which are answered with error when server
+// exited, since respawned server never answers them
+type clientState struct {
+	mutex       sync.Mutex
+	ids         *IdRemapper // id of replayed initialize never collides with ids of client
+	initialize  []byte      // the first initialize request (with original id)
+	initialized []byte
+	encoding    string                   // position encoding negotiated by initialize (utf-16 if empty)
+	documents   map[string]*openDocument // open documents by uri
+	uris        []string                 // open documents in order of didOpen
+	pending     map[string]clientRequest
+	sent        int // number of requests sent by client
+}
+
+func newClientState() *clientState {
+	return &clientState{ids: NewIdRemapper(), documents: map[string]*openDocument{}, pending: map[string]clientRequest{}}
+}
+
+// textDocumentParams is params of textDocument/didOpen, didChange and didClose
+type textDocumentParams struct {
+	TextDocument struct {
+		URI        string          `json:"uri"`
+		LanguageId string          `json:"languageId"`
+		Version    json.RawMessage `json:"version"`
+		Text       string          `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Range *struct {
+			Start lspPosition `json:"start"`
+			End   lspPosition `json:"end"`
+		} `json:"range"`
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// lspPosition is zero-based line and character offset in code units of position encoding
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// offsetOf returns byte offset of position in text. Character is counted in code units of encoding (utf-8,
+// utf-16 or utf-32, utf-16 if empty). Position beyond end of line (or text) is clamped to it
+func offsetOf(text string, pos lspPosition, encoding string) int {
+	offset := 0
+	for i := 0; i < pos.Line; i++ {
+		n := strings.IndexByte(text[offset:], '\n')
+		if n < 0 {
+			return len(text)
+		}
+		offset += n + 1
+	}
+	for units := 0; offset < len(text) && units < pos.Character; {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		if r == '\n' || r == '\r' {
+			break
+		}
+		switch {
+		case encoding == "utf-8":
+			units += size
+		case encoding == "utf-32" || r < 0x10000:
+			units++
+		default: // surrogate pair
+			units += 2
+		}
+		offset += size
+	}
+	return offset
+}
+
+// observe keeps message of client. Does nothing if s is nil (server is not respawned).
+// Returns error if open document cannot be followed, which is not replayed any more
+func (s *clientState) observe(payload []byte, e *Envelope) error {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if e.IsRequest() {
+		_, _ = s.ids.Incoming(payload) // reserve id of client
+		s.sent++
+		s.pending[idKey(e.Id)] = clientRequest{id: e.Id, method: e.Method, order: s.sent}
+		if e.Method == "initialize" && s.initialize == nil {
+			s.initialize = append([]byte(nil), payload...)
+		}
+		return nil
+	}
+	if !e.IsNotification() {
+		return nil
+	}
+	if e.Method == "initialized" && s.initialized == nil {
+		s.initialized = append([]byte(nil), payload...)
+		return nil
+	}
+	var m struct {
+		Params textDocumentParams `json:"params"`
+	}
+	if !strings.HasPrefix(e.Method, "textDocument/did") {
+		return nil
+	}
+	err := json.Unmarshal(payload, &m)
+	uri := m.Params.TextDocument.URI
+	switch {
+	case err != nil:
+		if _, ok := s.documents[uri]; ok && e.Method == "textDocument/didChange" {
+			s.forget(uri)
+			return fmt.Errorf("%s is not replayed to respawned server since its change is broken: %v", uri, err)
+		}
+	case e.Method == "textDocument/didOpen":
+		if _, ok := s.documents[uri]; !ok {
+			s.uris = append(s.uris, uri)
+		}
+		doc := m.Params.TextDocument
+		s.documents[uri] = &openDocument{languageId: doc.LanguageId, version: doc.Version, text: doc.Text}
+	case e.Method == "textDocument/didChange":
+		doc, ok := s.documents[uri]
+		if !ok {
+			return nil
+		}
+		for _, change := range m.Params.ContentChanges {
+			if change.Range == nil { // full text
+				doc.text = change.Text
+				continue
+			}
+			start := offsetOf(doc.text, change.Range.Start, s.encoding)
+			end := max(offsetOf(doc.text, change.Range.End, s.encoding), start)
+			doc.text = doc.text[:start] + change.Text + doc.text[end:]
+		}
+		doc.version = m.Params.TextDocument.Version
+	case e.Method == "textDocument/didClose":
+		s.forget(uri)
+	}
+	return nil
+}
+
+// forget forgets open document
+func (s *clientState) forget(uri string) {
+	delete(s.documents, uri)
+	s.uris = slices.DeleteFunc(s.uris, func(u string) bool { return u == uri })
+}
+
+// didOpen returns didOpen notification having current text and version of document
+func (d *openDocument) didOpen(uri string) ([]byte, error) {
+	return json.Marshal(map[string]any{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": d.languageId, "version": d.version, "text": d.text}}})
+}
+
+// answered forgets client request answered by server, and keeps position encoding negotiated by response of
+// initialize. Does nothing if s is nil
+func (s *clientState) answered(payload []byte, e *Envelope) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := idKey(e.Id)
+	if s.pending[key].method == "initialize" {
+		var m struct {
+			Result struct {
+				Capabilities struct {
+					PositionEncoding string `json:"positionEncoding"`
+				} `json:"capabilities"`
+			} `json:"result"`
+		}
+		if json.Unmarshal(payload, &m) == nil {
+			s.encoding = m.Result.Capabilities.PositionEncoding
+		}
+	}
+	delete(s.pending, key)
+}
+
+// failPending returns error responses to client requests in flight (in order of sending) and forgets them
+func (s *clientState) failPending() [][]byte {
+	s.mutex.Lock()
+	requests := make([]clientRequest, 0, len(s.pending))
+	for _, req := range s.pending {
+		requests = append(requests, req)
+	}
+	clear(s.pending)
+	s.mutex.Unlock()
+	slices.SortFunc(requests, func(a, b clientRequest) int { return a.order - b.order })
+	responses := make([][]byte, 0, len(requests))
+	for _, req := range requests {
+		response, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": req.id, "error": map[string]any{
+			"code": requestFailedCode, "message": "lsp-recorder: Language Server exited before responding to " + req.method}})
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+// replayRequest returns initialize request whose id is rewritten so that it never collides with ids of client,
+// and rewritten id (nil if server has not been initialized by client)
+func (s *clientState) replayRequest() ([]byte, json.RawMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.initialize == nil {
+		return nil, nil, nil
+	}
+	request, err := s.ids.Outgoing(s.initialize)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := ParseEnvelope(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	return request, e.Id, nil
+}
+
+// replayed returns initialized notification and messages of open documents to be replayed after initialize, and
+// forgets rewritten id of initialize (its response may never come if server exited)
+func (s *clientState) replayed(id json.RawMessage) (initialized []byte, documents [][]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ids.release(id)
+	for _, uri := range s.uris {
+		if open, err := s.documents[uri].didOpen(uri); err == nil {
+			documents = append(documents, open)
+		}
+	}
+	return s.initialized, documents
+}
+
+// serverSwitch is stdin of current server. While server is respawned, Write blocks until new server is
+// attached. Messages written to exited server are lost with it, so its write error is reported (once per server)
+// instead of ending pass-through of client. Requests among them are answered with error (see answerPending)
+type serverSwitch struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	writer io.WriteCloser // nil while server is respawned
+	closed bool
+	lost   bool          // whether write error of current server is reported
+	done   chan struct{} // closed by Close (client disconnected)
+	ch     chan<- LogData
+}
+
+func newServerSwitch(writer io.WriteCloser, ch chan<- LogData) *serverSwitch {
+	s := &serverSwitch{writer: writer, done: make(chan struct{}), ch: ch}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+func (s *serverSwitch) Write(buf []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.writer == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if _, err := s.writer.Write(buf); err != nil { // server is exiting, and will be respawned unless client disconnected
+		if !s.lost {
+			s.lost = true
+			sendMessage(STDERR, fmt.Sprintf("warning: messages of client are lost since server is exiting: %v", err), s.ch)
+		}
+	}
+	return len(buf), nil
+}
+
+// Close closes stdin of current server (notifies client disconnection), so that server is not respawned any more
+func (s *serverSwitch) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.cond.Broadcast()
+	if s.writer != nil {
+		return s.writer.Close()
+	}
+	return nil
+}
+
+// detach makes Write wait for new server
+func (s *serverSwitch) detach() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writer = nil
+}
+
+// attach switches stdin to that of new server. Returns false (and stdin is closed) if client has disconnected
+func (s *serverSwitch) attach(writer io.WriteCloser) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		_ = writer.Close()
+		return false
+	}
+	s.writer = writer
+	s.lost = false
+	s.cond.Broadcast()
+	return true
+}
+
+// answerPending answers client requests in flight with error, since exited server never answers them. The responses
+// are recorded as synthetic ones
+func answerPending(s *clientState, toClient io.Writer, ch chan<- LogData) {
+	for _, response := range s.failPending() {
+		if _, err := fmt.Fprintf(toClient, "Content-Length: %d\r\n\r\n%s", len(response), response); err != nil {
+			return // client is gone
+		}
+		ch <- LogData{timestamp: time.Now(), streamType: STDOUT, payloadType: JSON, payload: response, synthetic: true,
+			size: len(response)}
+	}
+}
+
+// replayHandshake sends initialize and initialized of client to respawned server, and reads its messages until
+// response of initialize. Then didOpen (and following didChange) of documents opened by client are sent. The replayed
+// messages and the response are recorded as synthetic ones. Other messages of server (e.g. window/logMessage) are
+// recorded and passed through to client
+func replayHandshake(s *clientState, in io.Writer, out *bufio.Reader, toClient io.Writer, ch chan<- LogData) error {
+	initialize, id, err := s.replayRequest()
+	if err != nil || initialize == nil {
+		return err // server has not been initialized by client if nil
+	}
+	initialized, documents := s.replayed(id)
+	send := func(payload []byte) error {
+		if _, err := fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(payload), payload); err != nil {
+			return err
+		}
+		ch <- LogData{timestamp: time.Now(), streamType: STDIN, payloadType: JSON, payload: payload, synthetic: true}
+		return nil
+	}
+	if err := send(initialize); err != nil {
+		return err
+	}
+	for {
+		payload, err := readFramedMessage(out)
+		if err != nil {
+			return err
+		}
+		d := LogData{timestamp: time.Now(), streamType: STDOUT, payloadType: JSON, payload: payload}
+		if !json.Valid(payload) {
+			d.payloadType, d.declaredSize = INVALID, len(payload)
+		}
+		if e, err := ParseEnvelope(payload); err == nil && e.IsResponse() && idKey(e.Id) == idKey(id) {
+			d.synthetic = true
+			ch <- d
+			break
+		}
+		ch <- d
+		if _, err := fmt.Fprintf(toClient, "Content-Length: %d\r\n\r\n%s", len(payload), payload); err != nil {
+			return err
+		}
+	}
+	if initialized != nil {
+		if err := send(initialized); err != nil {
+			return err
+		}
+	}
+	for _, payload := range documents {
+		if err := send(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, restartBackoff(time.Second, 1))
+	assert.Equal(t, 4*time.Second, restartBackoff(time.Second, 3))
+	assert.Equal(t, maxRestartBackoff, restartBackoff(time.Second, 100))
+	assert.Equal(t, time.Duration(0), restartBackoff(0, 3))
+}
+
+func observeClient(t *testing.T, s *clientState, messages ...string) {
+	for _, msg := range messages {
+		e, err := ParseEnvelope([]byte(msg))
+		require.NoError(t, err)
+		s.observe([]byte(msg), e)
+	}
+}
+
+func observeServer(t *testing.T, s *clientState, responses ...string) {
+	for _, msg := range responses {
+		e, err := ParseEnvelope([]byte(msg))
+		require.NoError(t, err)
+		s.answered([]byte(msg), e)
+	}
+}
+
+func TestReplayHandshake(t *testing.T) {
+	s := newClientState()
+	initialized := `{"jsonrpc":"2.0","method":"initialized","params":{}}`
+	openA := `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a","languageId":"go","version":1,"text":"ab"}}}`
+	changeA := `{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a","version":2},"contentChanges":[{"range":{"start":{"line":0,"character":1},"end":{"line":0,"character":1}},"text":"x"}]}}`
+	observeClient(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"processId":1}}`,
+		initialized,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover"}`,
+		openA,
+		changeA,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///b","languageId":"go","version":1,"text":""}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///c","languageId":"c","version":1,"text":"old"}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didClose","params":{"textDocument":{"uri":"file:///b"}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///c","version":5},"contentChanges":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":0}},"text":"x"},{"text":"new"}]}}`,
+	)
+
+	frame := func(payload string) string {
+		return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(payload), payload)
+	}
+	logMessage := `{"jsonrpc":"2.0","method":"window/logMessage","params":{"type":3,"message":"started"}}`
+	response := `{"jsonrpc":"2.0","id":3,"result":{"capabilities":{}}}` // ids 1 and 2 are used by client
+	out := bufio.NewReader(strings.NewReader(frame(logMessage) + frame(response) + frame(`{"jsonrpc":"2.0","method":"next"}`)))
+	in := bytes.Buffer{}
+	toClient := bytes.Buffer{}
+	ch := make(chan LogData, 16)
+	require.NoError(t, replayHandshake(s, &in, out, &toClient, ch))
+	close(ch)
+
+	replayed := `{"id":3,"jsonrpc":"2.0","method":"initialize","params":{"processId":1}}`
+	reopenA := `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"languageId":"go","text":"axb","uri":"file:///a","version":2}}}`
+	reopenC := `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"languageId":"c","text":"new","uri":"file:///c","version":5}}}`
+	assert.Equal(t, frame(replayed)+frame(initialized)+frame(reopenA)+frame(reopenC), in.String())
+	assert.Equal(t, frame(logMessage), toClient.String()) // response is not for client
+	var records []string
+	for d := range ch {
+		records = append(records, fmt.Sprintf("%s %v %s", d.streamType, d.synthetic, d.payload))
+	}
+	assert.Equal(t, []string{
+		"stdin true " + replayed,
+		"stdout false " + logMessage,
+		"stdout true " + response,
+		"stdin true " + initialized,
+		"stdin true " + reopenA,
+		"stdin true " + reopenC,
+	}, records)
+	payload, err := readFramedMessage(out) // stdout after handshake is left to intercept
+	require.NoError(t, err)
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"next"}`, string(payload))
+
+	// initialize is replayed again to next server (response of previous one is not waited for)
+	in.Reset()
+	err = replayHandshake(s, &in, bufio.NewReader(strings.NewReader("")), &toClient, make(chan LogData, 16))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, frame(strings.Replace(replayed, `"id":3`, `"id":4`, 1)), in.String())
+
+	// server is not initialized by client
+	require.NoError(t, replayHandshake(newClientState(), &in, out, &toClient, ch))
+}
+
+func TestClientStateIncrementalChanges(t *testing.T) {
+	change := func(version int, line, start, end int, text string) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a","version":%d},`+
+			`"contentChanges":[{"range":{"start":{"line":%d,"character":%d},"end":{"line":%d,"character":%d}},"text":%q}]}}`,
+			version, line, start, line, end, text)
+	}
+	s := newClientState()
+	observeClient(t, s,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a","languageId":"go","version":1,"text":"a😀b\r\ncd\n"}}}`,
+		change(2, 0, 3, 4, "B"),    // after surrogate pair of utf-16
+		change(3, 1, 1, 9, "x\ny"), // end beyond line is clamped
+		change(4, 5, 0, 0, "!"),    // line beyond text is end of text
+	)
+	require.Len(t, s.documents, 1)
+	assert.Equal(t, "a😀B\r\ncx\ny\n!", s.documents["file:///a"].text)
+	assert.Equal(t, json.RawMessage("4"), s.documents["file:///a"].version)
+
+	// utf-8 is negotiated
+	observeClient(t, s, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	observeServer(t, s, `{"jsonrpc":"2.0","id":1,"result":{"capabilities":{"positionEncoding":"utf-8"}}}`)
+	observeClient(t, s, change(5, 0, 1, 5, ""))
+	assert.Equal(t, "aB\r\ncx\ny\n!", s.documents["file:///a"].text)
+
+	broken := []byte(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a"},"contentChanges":[{"range":1}]}}`)
+	e, err := ParseEnvelope(broken)
+	require.NoError(t, err)
+	assert.ErrorContains(t, s.observe(broken, e), "file:///a is not replayed to respawned server since its change is broken: ")
+	assert.Empty(t, s.documents)
+	assert.Empty(t, s.uris)
+}
+
+func TestClientStateFailPending(t *testing.T) {
+	s := newClientState()
+	observeClient(t, s,
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`,
+		`{"jsonrpc":"2.0","id":"x","method":"textDocument/definition"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`,
+	)
+	observeServer(t, s, `{"jsonrpc":"2.0","id":3,"result":null}`)
+	toClient := bytes.Buffer{}
+	ch := make(chan LogData, 4)
+	answerPending(s, &toClient, ch)
+	close(ch)
+	var responses []string
+	for d := range ch {
+		assert.True(t, d.synthetic)
+		responses = append(responses, string(d.payload))
+	}
+	assert.Equal(t, []string{
+		`{"error":{"code":-32803,"message":"lsp-recorder: Language Server exited before responding to textDocument/hover"},"id":1,"jsonrpc":"2.0"}`,
+		`{"error":{"code":-32803,"message":"lsp-recorder: Language Server exited before responding to textDocument/definition"},"id":"x","jsonrpc":"2.0"}`,
+	}, responses)
+	assert.Contains(t, toClient.String(), "Content-Length: ")
+	assert.Empty(t, s.failPending())
+}
+
+func TestRunRestartOnExit(t *testing.T) {
+	buf := &syncBuffer{}
+	clientIn, clientWriter := io.Pipe() // client keeps connection open
+	defer func() {
+		_ = clientWriter.Close()
+	}()
+	stderr := &syncBuffer{}
+	// server outlives immediateExitWindow, otherwise it is regarded as failure of start
+	status, err := Run("sh", []string{"-c", "sleep 0.2; exit 3"}, NewLogger(buf), RunOptions{NoEnv: true, MaxRestarts: 2,
+		RestartBackoff: 10 * time.Millisecond, ClientIn: clientIn, ClientOut: io.Discard, ErrOut: stderr})
+	require.NoError(t, err)
+	assert.Equal(t, 3, status.Code)
+	assert.Contains(t, stderr.String(), "warning: server is not respawned since it exited 3 times")
+	assert.Contains(t, buf.String(), restartPrefix+"server session 3 after 20ms")
+
+	trailer, err := ReadTrailer(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	require.Len(t, trailer.Summary.ServerSessions, 3)
+	for i, server := range trailer.Summary.ServerSessions {
+		assert.Equal(t, i+1, server.Id)
+		require.NotNil(t, server.Exit)
+		assert.Equal(t, 3, server.Exit.Code)
+	}
+	out := bytes.Buffer{}
+	trailer.Summary.ServerSessions[2].Format(&out, "")
+	assert.Regexp(t, `^server 3: .+, exit 3\n$`, out.String())
+
+	// every record is tagged with server session
+	reader := NewLogReader(strings.NewReader(buf.String()))
+	for {
+		d, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Positive(t, d.serverSession)
+	}
+}
+
+func TestRunRestartAfterClientExit(t *testing.T) {
+	buf := &syncBuffer{}
+	exit := `{"jsonrpc":"2.0","method":"exit"}`
+	clientIn, clientWriter := io.Pipe()
+	defer func() {
+		_ = clientWriter.Close()
+	}()
+	frame := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(exit), exit)
+	go func() {
+		_, _ = io.WriteString(clientWriter, frame)
+	}()
+	// server exits after exit notification, while client keeps connection open
+	status, err := Run("sh", []string{"-c", fmt.Sprintf("head -c %d > /dev/null; exit 0", len(frame))}, NewLogger(buf),
+		RunOptions{NoEnv: true, MaxRestarts: 2, ClientIn: clientIn, ClientOut: io.Discard})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Code)
+	assert.NotContains(t, buf.String(), restartPrefix)
+}
+
+func TestRunRestartHandshakeTimeout(t *testing.T) {
+	timeout := restartHandshakeTimeout
+	restartHandshakeTimeout = 100 * time.Millisecond
+	defer func() {
+		restartHandshakeTimeout = timeout
+	}()
+	buf := &syncBuffer{}
+	clientIn, clientWriter := io.Pipe() // client keeps connection open
+	defer func() {
+		_ = clientWriter.Close()
+	}()
+	initialize := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`
+	go func() {
+		_, _ = fmt.Fprintf(clientWriter, "Content-Length: %d\r\n\r\n%s", len(initialize), initialize)
+	}()
+	clientOut := &syncBuffer{}
+	// the first server exits without answering, and respawned one never answers replayed initialize
+	marker := filepath.Join(t.TempDir(), "started")
+	script := fmt.Sprintf("if [ -e %[1]s ]; then exec sleep 10; fi; touch %[1]s; sleep 0.2; exit 3", marker)
+	status, err := Run("sh", []string{"-c", script}, NewLogger(buf), RunOptions{NoEnv: true, MaxRestarts: 1,
+		RestartBackoff: time.Millisecond, ClientIn: clientIn, ClientOut: clientOut, ErrOut: io.Discard})
+	require.NoError(t, err)
+	require.NotNil(t, status.Signal) // respawned server is killed
+	assert.Contains(t, buf.String(), "failed to replay initialize handshake: no response within 100ms")
+	// id of replayed initialize is not used by client
+	assert.Regexp(t, `"method":"initialize","id":2,.*"synthetic":true`, buf.String())
+	// request in flight is answered with error when the first server exited
+	assert.Contains(t, clientOut.String(), `"id":1,`)
+	assert.Contains(t, clientOut.String(), fmt.Sprintf(`"code":%d`, requestFailedCode))
+}
+
+// recordServerSessionTestLog records a session whose server is respawned once
+func recordServerSessionTestLog(t *testing.T) string {
+	ch := make(chan LogData, 16)
+	buf := &syncBuffer{}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+	ch <- LogData{timestamp: at(0), streamType: STDERR, payloadType: SESSION_START, payload: []byte(`{"pid":1}`)}
+	ch <- LogData{timestamp: at(0), streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)}
+	ch <- LogData{timestamp: at(1), streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)}
+	ch <- LogData{timestamp: at(2), streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"window/workDoneProgress/create"}`)}
+	ch <- LogData{timestamp: at(3), streamType: STDERR, payloadType: RAW, payload: []byte(terminatedPrefix + "SIGSEGV")}
+	ch <- LogData{timestamp: at(3), streamType: STDERR, payloadType: RAW, payload: []byte(exitedPrefix + "139")}
+	ch <- LogData{timestamp: at(4), streamType: STDERR, payloadType: RAW, serverSession: 2,
+		payload: []byte(restartPrefix + "server session 2 after 1s")}
+	ch <- LogData{timestamp: at(5), streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"window/workDoneProgress/create"}`)}
+	ch <- LogData{timestamp: at(6), streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)}
+	ch <- LogData{timestamp: at(7), streamType: STDERR, payloadType: RAW, payload: []byte(exitedPrefix + "0")}
+	ch <- LogData{timestamp: at(7), streamType: STDERR, payloadType: TRAILER}
+	close(ch)
+	record(context.Background(), ch, NewLogger(buf), NewSession(nil), &RunOptions{MaxRestarts: 1})
+	return buf.String()
+}
+
+func TestServerSessionTrailer(t *testing.T) {
+	trailer, err := ReadTrailer(strings.NewReader(recordServerSessionTestLog(t)))
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	for _, server := range trailer.Summary.ServerSessions {
+		server.Format(&out, "  ")
+	}
+	assert.Equal(t, "  server 1: 3s, exit 139, SIGSEGV\n  server 2: 3s, exit 0\n", out.String())
+	assert.Equal(t, 0, trailer.Summary.Exit.Code)
+}
+
+func TestPrintServerSession(t *testing.T) {
+	log := recordServerSessionTestLog(t)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(), "<stderr> (server session 2) "+restartPrefix)
+	assert.Equal(t, 1, strings.Count(out.String(), "server session 2)"))
+	// request of respawned server is paired with response regardless of reused id
+	assert.Contains(t, out.String(), "(response to window/workDoneProgress/create id=1, 1s)")
+
+	index, err := BuildLogIndex(strings.NewReader(log), nil)
+	require.NoError(t, err)
+	indexed := bytes.Buffer{}
+	require.NoError(t, PrintIndex(index, &indexed, &PrintFilter{}))
+	assert.Equal(t, out.String(), indexed.String())
+}
+
+func TestStatsServerSession(t *testing.T) {
+	stats, err := CollectMessageStats(strings.NewReader(recordServerSessionTestLog(t)))
+	require.NoError(t, err)
+	require.Len(t, stats.Servers, 2)
+	assert.Equal(t, 1, stats.Servers[0].ClientRecords)
+	assert.Equal(t, 2, stats.Servers[0].ServerRecords)
+	assert.Equal(t, 2, stats.Servers[0].Requests)
+	assert.Equal(t, 139, stats.Servers[0].Exit.Code)
+	assert.Equal(t, 1, stats.Servers[1].Requests)
+	assert.Equal(t, 3*time.Second, stats.Servers[1].End.Sub(stats.Servers[1].Start))
+
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.Contains(t, out.String(), "server sessions:\n")
+	assert.Regexp(t, `\n2 +3s +1 +1 +1 +0 +0\n`, out.String())
+	doc := stats.Document()
+	require.Len(t, doc.Servers, 2)
+	assert.Equal(t, 2, doc.Servers[1].Id)
+}
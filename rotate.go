@@ -0,0 +1,488 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogOptions is how recorded log is written
+type LogOptions struct {
+	Format           string        // one of LogFormatText, LogFormatJSON, LogFormatJSONGzip, LogFormatJSONZstd
+	CompressionLevel int           // see NewFormatLogger
+	MaxSize          int64         // rotate log when its size exceeds this (no rotation if 0)
+	MaxFiles         int           // number of rotated old files to be kept
+	RotateEvery      time.Duration // start new log file every this (no time-based rotation if 0)
+	RotateDaily      bool          // start new log file at local midnight
+	Append           bool          // append to existing log instead of truncating it (see checkAppendFormat)
+
+	// FlushInterval is max delay before written records of json-gzip log reach file (DefaultFlushInterval
+	// if 0, not flushed until close if negative). Each flush costs a few bytes and resets compression
+	// block, so compression ratio is slightly lower, but log of killed recorder is readable
+	FlushInterval time.Duration
+
+	// Handler is level, AddSource and ReplaceAttr of handler writing records (info level if nil).
+	// Debug records of recorder (see RunOptions) are written if level is debug
+	Handler *slog.HandlerOptions
+}
+
+var byteSizePattern = regexp.MustCompile(`(?i)^(\d+)\s*([kmg]?)(i?b)?$`)
+
+// ParseByteSize parses size like 1024, 100K, 10MB or 2GiB (units are 1024 based)
+func ParseByteSize(s string) (int64, error) {
+	m := byteSizePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || (m[2] == "" && strings.EqualFold(m[3], "ib")) {
+		return 0, fmt.Errorf("invalid size: '%s'", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	shift := map[string]int{"": 0, "k": 10, "m": 20, "g": 30}[strings.ToLower(m[2])]
+	if err != nil || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size: '%s'", s)
+	}
+	return n << shift, nil
+}
+
+// ParseRotateEvery parses interval of time-based rotation, which is duration like 1h or midnight (local)
+func ParseRotateEvery(s string) (every time.Duration, daily bool, err error) {
+	if s == "midnight" {
+		return 0, true, nil
+	}
+	every, err = time.ParseDuration(s)
+	if err != nil || every < time.Second {
+		return 0, false, fmt.Errorf("invalid rotation interval: '%s' (duration of 1s or longer, or midnight)", s)
+	}
+	return every, false, nil
+}
+
+// RotatingLog writes log to file and rotates it when its size exceeds MaxSize.
+// Old files are renamed to <path>.1 (newest), <path>.2, ... and at most MaxFiles files are kept.
+// Since each write is a single record, compressed stream is finished at record boundary before rotation.
+// Size is that of file, so rotation of compressed log is delayed until compressor flushes its output
+// (zstd frame is flushed every zstdFrameInterval)
+// Log to stream (see IsLogStream) is never rotated
+//
+// If RotateEvery or RotateDaily is set, log is also rotated by timer, and old files are renamed to
+// <path>.<start time> (see rotationStamp) instead. Each file started by rotation begins with copy of META
+// record, so that it is readable alone. The copy is dropped when files are stitched by OpenLogs
+type RotatingLog struct {
+	mutex   sync.Mutex
+	path    string
+	options LogOptions
+	file    *os.File       // nil if log is written to stream
+	stream  io.Closer      // closer of stream (nil if log is written to file)
+	sink    io.WriteCloser // compressor of current file
+
+	started time.Time   // when current file is started (name of file after time-based rotation)
+	timer   *time.Timer // timer of time-based rotation (nil if not rotated by time)
+	header  *LogData    // META record repeated at top of file started by time-based rotation
+	err     error       // error of time-based rotation, which is returned by next Write
+	closed  bool
+}
+
+func openRotatingLog(path string, options LogOptions) (*RotatingLog, error) {
+	if err := CheckLogFormat(options.Format, options.CompressionLevel); err != nil {
+		return nil, err
+	}
+	r := &RotatingLog{path: path, options: options}
+	if IsLogStream(path) && (options.MaxSize > 0 || r.timeBased()) {
+		return nil, fmt.Errorf("log to %s cannot be rotated", path)
+	}
+	if options.Append && !IsLogStream(path) {
+		if err := checkAppendFormat(path, options.Format); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.open(options.Append); err != nil {
+		return nil, err
+	}
+	r.started = time.Now()
+	if r.timeBased() {
+		r.schedule()
+	}
+	return r, nil
+}
+
+// timeBased reports whether log is rotated by time
+func (r *RotatingLog) timeBased() bool {
+	return r.options.RotateEvery > 0 || r.options.RotateDaily
+}
+
+// schedule starts timer of rotation of current file
+func (r *RotatingLog) schedule() {
+	next := r.started.Add(r.options.RotateEvery)
+	if r.options.RotateDaily {
+		y, m, d := r.started.Date()
+		next = time.Date(y, m, d+1, 0, 0, 0, 0, r.started.Location())
+	}
+	r.timer = time.AfterFunc(time.Until(next), r.rotateOnTime)
+}
+
+// rotateOnTime rotates log by timer. Since writers wait for the lock, records written during rotation go
+// to the new file in order
+func (r *RotatingLog) rotateOnTime() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed || r.err != nil {
+		return
+	}
+	if err := r.rotate(); err != nil {
+		r.err = fmt.Errorf("cannot rotate log file: %s, caused by %s", r.path, err.Error())
+		return
+	}
+	r.schedule()
+}
+
+// checkAppendFormat checks that existing log (if any) has the same format as appended records.
+// Compressed records are appended as new gzip member (or zstd frame), so concatenated stream is still valid
+func checkAppendFormat(path string, format string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %s, caused by %s", path, err.Error())
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	reader := bufio.NewReader(file)
+	if _, err := reader.Peek(1); err != nil {
+		return nil // empty
+	}
+	if existing := DetectLogFormat(reader); existing != format {
+		return fmt.Errorf("cannot append %s log to existing %s log: %s", format, existing, path)
+	}
+	return nil
+}
+
+// open opens log file (or stream). Existing file is truncated unless appending
+func (r *RotatingLog) open(appending bool) error {
+	var out io.Writer
+	if IsLogStream(r.path) {
+		writer, closer, err := openLogStream(r.path, r.options.Format)
+		if err != nil {
+			return err
+		}
+		out, r.stream = writer, closer
+	} else {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if appending {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := os.OpenFile(r.path, flag, 0o666)
+		if err != nil {
+			return fmt.Errorf("cannot open log file: %s, caused by %s", r.path, err.Error())
+		}
+		out, r.file = file, file
+	}
+	sink, err := compressLog(out, r.options.Format, r.options.CompressionLevel)
+	if err != nil {
+		_ = r.closeOut()
+		return err
+	}
+	if gz, ok := sink.(*gzipLogWriter); ok {
+		gz.interval = r.options.FlushInterval
+		if gz.interval == 0 {
+			gz.interval = DefaultFlushInterval
+		}
+	}
+	r.sink = sink
+	return nil
+}
+
+// closeOut closes current file or stream
+func (r *RotatingLog) closeOut() error {
+	if r.stream != nil {
+		return r.stream.Close()
+	}
+	return r.file.Close()
+}
+
+// closeFile finishes compressed stream and closes current file
+func (r *RotatingLog) closeFile() error {
+	err := r.sink.Close()
+	if closeErr := r.closeOut(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// rotatedName returns name of index-th old file
+func rotatedName(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
+
+func (r *RotatingLog) rotate() error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	if r.timeBased() {
+		if r.options.MaxFiles > 0 {
+			if err := r.archive(); err != nil {
+				return err
+			}
+		}
+		if err := r.open(false); err != nil {
+			return err
+		}
+		r.started = time.Now()
+		r.writeHeader()
+		return nil
+	}
+	if r.options.MaxFiles > 0 {
+		for i := r.options.MaxFiles - 1; i > 0; i-- {
+			if err := os.Rename(rotatedName(r.path, i), rotatedName(r.path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(r.path, rotatedName(r.path, 1)); err != nil {
+			return err
+		}
+	}
+	return r.open(false)
+}
+
+// rotationStampLayout is layout of start time of file renamed by time-based rotation (local time)
+const rotationStampLayout = "20060102T150405"
+
+var rotationStampPattern = regexp.MustCompile(`^\.(\d{8}T\d{6})(?:-(\d+))?$`)
+
+// rotationStamp returns start time and sequence number (for files started at the same second) of file name
+// like session.log.20240501T100000 or session.log.20240501T100000-1 (empty if not renamed by time-based rotation)
+func rotationStamp(name string) (string, int) {
+	m := rotationStampPattern.FindStringSubmatch(filepath.Ext(name))
+	if m == nil {
+		return "", 0
+	}
+	n, _ := strconv.Atoi(m[2])
+	return m[1], n
+}
+
+// archive renames current file to <path>.<start time>, and removes the oldest ones exceeding MaxFiles
+func (r *RotatingLog) archive() error {
+	stamp := r.path + "." + r.started.Format(rotationStampLayout)
+	name := stamp
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", stamp, i)
+	}
+	if err := os.Rename(r.path, name); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(r.path) + "."
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) && len(entry.Name()) > len(prefix) {
+			if s, _ := rotationStamp(entry.Name()); s != "" && !strings.Contains(entry.Name()[len(prefix):], ".") {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return lessRotationStamp(names[i], names[j])
+	})
+	for len(names) > r.options.MaxFiles {
+		if err := os.Remove(filepath.Join(filepath.Dir(r.path), names[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// lessRotationStamp reports whether file x renamed by time-based rotation is older than y
+func lessRotationStamp(x, y string) bool {
+	sx, nx := rotationStamp(x)
+	sy, ny := rotationStamp(y)
+	if sx != sy {
+		return sx < sy
+	}
+	return nx < ny
+}
+
+// keepHeader keeps META record written to log, which is repeated at top of file started by time-based rotation
+func (r *RotatingLog) keepHeader(buf []byte) {
+	if !bytes.Contains(buf, []byte(META.String())) {
+		return
+	}
+	if d, err := NewLogReader(bytes.NewReader(buf)).Next(); err == nil && d.payloadType == META {
+		d.rotated = true
+		r.header = d
+	}
+}
+
+// writeHeader writes copy of META record (if any) to the beginning of current file
+func (r *RotatingLog) writeHeader() {
+	if r.header != nil {
+		writeLogData(newOptionsLogger(r.sink, r.options.Format, r.options.Handler), r.header)
+	}
+}
+
+func (r *RotatingLog) Write(buf []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.timeBased() && r.header == nil {
+		r.keepHeader(buf)
+	}
+	n, err := r.sink.Write(buf)
+	if err != nil {
+		return n, err
+	}
+	if r.options.MaxSize == 0 {
+		return n, nil
+	}
+	// size is taken from file, since compressor buffers data until block (or frame) is finished
+	if info, err := r.file.Stat(); err == nil && info.Size() >= r.options.MaxSize {
+		if err := r.rotate(); err != nil {
+			return n, fmt.Errorf("cannot rotate log file: %s, caused by %s", r.path, err.Error())
+		}
+	}
+	return n, nil
+}
+
+func (r *RotatingLog) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return r.closeFile()
+}
+
+// CreateLog creates log file and returns logger writing to it. Returned closer must be called
+// after the last record is written (compressed stream is finished and file is closed)
+func CreateLog(path string, options LogOptions) (*slog.Logger, io.Closer, error) {
+	r, err := openRotatingLog(path, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newOptionsLogger(r, options.Format, options.Handler), r, nil
+}
+
+// rotationIndex returns index of rotated file name like session.log.2 (0 if not rotated one)
+func rotationIndex(name string) int {
+	ext := filepath.Ext(name)
+	if index, err := strconv.Atoi(strings.TrimPrefix(ext, ".")); err == nil && index > 0 {
+		return index
+	}
+	return 0
+}
+
+// isLogSidecar reports whether file is written beside logs but is not log (index or spill file)
+func isLogSidecar(name string) bool {
+	return strings.HasSuffix(name, LogIndexSuffix) || strings.HasSuffix(name, LogIndexSuffix+".tmp") ||
+		strings.HasPrefix(filepath.Base(name), spillFilePrefix)
+}
+
+// ResolveLogFiles returns files of existing path or glob pattern (like session.log*). Index and spill files
+// matched by pattern are excluded. Rotated files are ordered from the oldest (largest index or earliest start time)
+// to the current one
+func ResolveLogFiles(pattern string) ([]string, error) {
+	if _, err := os.Stat(pattern); err == nil {
+		return []string{pattern}, nil
+	}
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %s", pattern)
+	}
+	names = slices.DeleteFunc(names, isLogSidecar)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no log file matches: %s", pattern)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		x, y := rotationIndex(names[i]), rotationIndex(names[j])
+		if x != y {
+			return x > y
+		}
+		sx, _ := rotationStamp(names[i])
+		sy, _ := rotationStamp(names[j])
+		if (sx == "") != (sy == "") {
+			return sx != "" // files renamed by time-based rotation precede current one
+		}
+		if sx != "" {
+			return lessRotationStamp(names[i], names[j])
+		}
+		return names[i] < names[j]
+	})
+	return names, nil
+}
+
+// skipRotatedHeader drops copy of META record at top of file started by time-based rotation, so that stitched
+// log has single META record
+func skipRotatedHeader(reader io.Reader) io.Reader {
+	buffered := bufio.NewReader(reader)
+	line, err := buffered.ReadBytes('\n')
+	if err == nil {
+		if d, err := NewLogReader(bytes.NewReader(line)).Next(); err == nil && d.rotated {
+			return buffered
+		}
+	}
+	return io.MultiReader(bytes.NewReader(line), buffered)
+}
+
+type multiLog struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiLog) Close() error {
+	for _, closer := range m.closers {
+		_ = closer.Close()
+	}
+	return nil
+}
+
+// OpenLogs opens log files of path or glob pattern (see ResolveLogFiles) and stitches them in order.
+// Single file is opened by OpenLog
+func OpenLogs(pattern string) (io.ReadCloser, error) {
+	names, err := ResolveLogFiles(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 1 {
+		return OpenLog(names[0])
+	}
+	m := &multiLog{}
+	var readers []io.Reader
+	for _, name := range names {
+		reader, err := OpenLog(name)
+		if err != nil {
+			_ = m.Close()
+			return nil, err
+		}
+		m.closers = append(m.closers, reader)
+		if len(readers) > 0 {
+			readers = append(readers, skipRotatedHeader(reader))
+		} else {
+			readers = append(readers, reader)
+		}
+	}
+	m.Reader = io.MultiReader(readers...)
+	return m, nil
+}
@@ -0,0 +1,314 @@
+package recorder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"1024": 1024, "100K": 100 << 10, "10MB": 10 << 20, "2GiB": 2 << 30, "5 m": 5 << 20, "3b": 3,
+	} {
+		size, err := ParseByteSize(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, size, s)
+	}
+	for _, s := range []string{"", "M", "-1M", "1.5G", "10T", "1iB", "99999999999G"} {
+		_, err := ParseByteSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+// writeRotatingLog writes records having random (incompressible) payload of size
+func writeRotatingLog(t *testing.T, path string, options LogOptions, records int, size int) {
+	logger, closer, err := CreateLog(path, options)
+	require.NoError(t, err)
+	for i := 1; i <= records; i++ {
+		buf := make([]byte, size/2)
+		_, _ = rand.Read(buf)
+		writeLogData(logger, &LogData{seq: i, timestamp: time.Now(), streamType: STDERR, payloadType: RAW, payload: []byte(hex.EncodeToString(buf))})
+	}
+	require.NoError(t, closer.Close())
+}
+
+// readSeqs reads sequence numbers of records
+func readSeqs(t *testing.T, reader io.Reader) []int {
+	var seqs []int
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return seqs
+		}
+		require.NoError(t, err)
+		seqs = append(seqs, d.seq)
+	}
+}
+
+func TestRotatingLog(t *testing.T) {
+	// json-zstd is not tested since frames reach file only every zstdFrameInterval
+	formats := map[string]int{LogFormatText: 1000, LogFormatJSON: 1000, LogFormatJSONGzip: 64 << 10}
+	for format, size := range formats {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "session.log")
+			// compressed records reach file after some delay, so size of each file varies
+			writeRotatingLog(t, path, LogOptions{Format: format, MaxSize: int64(size * 3), MaxFiles: 2}, 20, size)
+
+			names, err := ResolveLogFiles(path + "*")
+			require.NoError(t, err)
+			assert.Equal(t, []string{path + ".2", path + ".1", path}, names)
+			_, err = os.Stat(path + ".3")
+			assert.ErrorIs(t, err, os.ErrNotExist) // the oldest one is dropped
+
+			for _, name := range names { // each file is complete log (compressed stream is finished)
+				reader, err := OpenLog(name)
+				require.NoError(t, err)
+				assert.NotEmpty(t, readSeqs(t, reader), name)
+				_ = reader.Close()
+			}
+
+			reader, err := OpenLogs(path + "*")
+			require.NoError(t, err)
+			seqs := readSeqs(t, reader)
+			_ = reader.Close()
+			require.NotEmpty(t, seqs)
+			assert.Equal(t, 20, seqs[len(seqs)-1])
+			assert.Greater(t, seqs[0], 1)
+			for i := 1; i < len(seqs); i++ {
+				assert.Equal(t, seqs[i-1]+1, seqs[i], "records are stitched in order")
+			}
+		})
+	}
+}
+
+func TestRotatingLogNoRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.log")
+	writeRotatingLog(t, path, LogOptions{Format: LogFormatJSON}, 5, 1000)
+	names, err := ResolveLogFiles(path + "*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, names)
+
+	// single rotated file is also accepted
+	writeRotatingLog(t, path, LogOptions{Format: LogFormatJSON, MaxSize: 1, MaxFiles: 3}, 3, 100)
+	reader, err := OpenLogs(path + ".2")
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, readSeqs(t, reader))
+	_ = reader.Close()
+}
+
+func TestResolveLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "a.log.1", "a.log.10", "a.log.2", "a.log.gz"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	names, err := ResolveLogFiles(filepath.Join(dir, "a.log*"))
+	require.NoError(t, err)
+	var bases []string
+	for _, name := range names {
+		bases = append(bases, filepath.Base(name))
+	}
+	assert.Equal(t, []string{"a.log.10", "a.log.2", "a.log.1", "a.log", "a.log.gz"}, bases)
+
+	_, err = ResolveLogFiles(filepath.Join(dir, "b.log*"))
+	assert.ErrorContains(t, err, fmt.Sprintf("no log file matches: %s", filepath.Join(dir, "b.log*")))
+}
+
+func TestAppendLog(t *testing.T) {
+	for _, format := range []string{LogFormatText, LogFormatJSON, LogFormatJSONGzip, LogFormatJSONZstd} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.log")
+			writeRotatingLog(t, path, LogOptions{Format: format, Append: true}, 2, 10) // created
+			writeRotatingLog(t, path, LogOptions{Format: format, Append: true}, 3, 10)
+			reader, err := OpenLog(path) // concatenated gzip members (or zstd frames) are read
+			require.NoError(t, err)
+			assert.Equal(t, []int{1, 2, 1, 2, 3}, readSeqs(t, reader))
+			_ = reader.Close()
+
+			writeRotatingLog(t, path, LogOptions{Format: format}, 1, 10) // truncated
+			reader, err = OpenLog(path)
+			require.NoError(t, err)
+			assert.Equal(t, []int{1}, readSeqs(t, reader))
+			_ = reader.Close()
+		})
+	}
+}
+
+func TestAppendLogFormatMismatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "session.log")
+	writeRotatingLog(t, path, LogOptions{Format: LogFormatJSON}, 1, 10)
+	for _, format := range []string{LogFormatText, LogFormatJSONGzip} {
+		_, _, err := CreateLog(path, LogOptions{Format: format, Append: true})
+		assert.EqualError(t, err, fmt.Sprintf("cannot append %s log to existing json log: %s", format, path))
+	}
+
+	empty := filepath.Join(dir, "empty.log")
+	require.NoError(t, os.WriteFile(empty, nil, 0o644))
+	_, closer, err := CreateLog(empty, LogOptions{Format: LogFormatJSONGzip, Append: true})
+	require.NoError(t, err)
+	_ = closer.Close()
+}
+
+func TestGzipLogFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.log")
+	logger, closer, err := CreateLog(path, LogOptions{Format: LogFormatJSONGzip, FlushInterval: 20 * time.Millisecond})
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		writeLogData(logger, &LogData{seq: i, timestamp: time.Now(), streamType: STDIN, payloadType: JSON, payload: []byte(`{}`)})
+	}
+	time.Sleep(200 * time.Millisecond) // stream is not closed like killed recorder
+
+	reader, err := OpenLog(path)
+	require.NoError(t, err)
+	r := NewLogReader(reader)
+	_, err = r.Next()
+	require.NoError(t, err) // flushed
+	var warnings []string
+	r.SkipErrors(func(err error) {
+		warnings = append(warnings, err.Error())
+	})
+	seqs := []int{1}
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		seqs = append(seqs, d.seq)
+	}
+	_ = reader.Close()
+	assert.Equal(t, []int{1, 2, 3}, seqs)
+	assert.Equal(t, []string{"log is truncated after line 3: unexpected EOF"}, warnings)
+
+	require.NoError(t, closer.Close())
+	reader, err = OpenLog(path)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, readSeqs(t, reader))
+	_ = reader.Close()
+}
+
+func TestGzipLogNoFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.log")
+	logger, closer, err := CreateLog(path, LogOptions{Format: LogFormatJSONGzip, FlushInterval: -1})
+	require.NoError(t, err)
+	writeLogData(logger, &LogData{seq: 1, timestamp: time.Now(), streamType: STDIN, payloadType: JSON, payload: []byte(`{}`)})
+	time.Sleep(50 * time.Millisecond)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Less(t, info.Size(), int64(32)) // only header of gzip member
+	require.NoError(t, closer.Close())
+}
+
+func TestParseRotateEvery(t *testing.T) {
+	every, daily, err := ParseRotateEvery("1h")
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, every)
+	assert.False(t, daily)
+	_, daily, err = ParseRotateEvery("midnight")
+	require.NoError(t, err)
+	assert.True(t, daily)
+	for _, s := range []string{"", "hourly", "-1h", "10ms"} {
+		_, _, err := ParseRotateEvery(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRotatingLogEvery(t *testing.T) {
+	for _, format := range []string{LogFormatText, LogFormatJSON, LogFormatJSONGzip} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.log")
+			r, err := openRotatingLog(path, LogOptions{Format: format, RotateEvery: time.Hour, MaxFiles: 2})
+			require.NoError(t, err)
+			logger := newOptionsLogger(r, format, nil)
+			write := func(seq int, payloadType PayloadType, payload string) {
+				writeLogData(logger, &LogData{seq: seq, timestamp: time.Now(), streamType: STDERR, payloadType: payloadType,
+					payload: []byte(payload)})
+			}
+			write(1, SESSION_START, `{"pid":1}`)
+			write(2, META, `{"host":"example"}`)
+			write(3, RAW, "first")
+			for seq := 4; seq <= 6; seq++ {
+				r.rotateOnTime() // timer is not waited
+				write(seq, RAW, "next")
+			}
+			require.NoError(t, r.Close())
+
+			names, err := ResolveLogFiles(path + "*")
+			require.NoError(t, err)
+			require.Len(t, names, 3) // the oldest one is removed
+			assert.Equal(t, path, names[2])
+			for _, name := range names[:2] {
+				stamp, _ := rotationStamp(name)
+				assert.NotEmpty(t, stamp, name)
+			}
+
+			for _, name := range names { // each file begins with copy of META record
+				reader, err := OpenLog(name)
+				require.NoError(t, err)
+				d, err := NewLogReader(reader).Next()
+				require.NoError(t, err)
+				assert.Equal(t, META, d.payloadType)
+				assert.True(t, d.rotated)
+				_ = reader.Close()
+			}
+			reader, err := OpenLog(path)
+			require.NoError(t, err)
+			report := VerifyLog(reader)
+			_ = reader.Close()
+			assert.Empty(t, report.Findings) // file continues session of previous one
+
+			reader, err = OpenLogs(path + "*")
+			require.NoError(t, err)
+			assert.Equal(t, []int{2, 4, 5, 6}, readSeqs(t, reader)) // copies of META record are dropped except the first
+			_ = reader.Close()
+		})
+	}
+}
+
+func TestResolveLogFilesRotationStamp(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "a.log.20240501T110000", "a.log.20240501T100000-2", "a.log.20240501T100000",
+		"a.log.20240501T100000-10"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	names, err := ResolveLogFiles(filepath.Join(dir, "a.log*"))
+	require.NoError(t, err)
+	var bases []string
+	for _, name := range names {
+		bases = append(bases, filepath.Base(name))
+	}
+	assert.Equal(t, []string{"a.log.20240501T100000", "a.log.20240501T100000-2", "a.log.20240501T100000-10",
+		"a.log.20240501T110000", "a.log"}, bases)
+}
+
+func TestPrintLogsWithIndex(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "s.log")
+	writeRotatingLog(t, path, LogOptions{Format: LogFormatJSON}, 3, 10)
+	_, err := WriteLogIndex(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, spillFilePrefix+"1.json"), []byte("{}"), 0o644))
+
+	names, err := ResolveLogFiles(filepath.Join(dir, "*")) // index and spill file are not logs
+	require.NoError(t, err)
+	assert.Equal(t, []string{path}, names)
+	reader, err := OpenLogs(filepath.Join(dir, "s.log*"))
+	require.NoError(t, err)
+	defer func(reader io.ReadCloser) {
+		_ = reader.Close()
+	}(reader)
+	out := strings.Builder{}
+	require.NoError(t, Print(reader, &out, &PrintFilter{}))
+	assert.Equal(t, 3, strings.Count(out.String(), "<stderr>"))
+}
@@ -0,0 +1,236 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// mockEntry is a recorded client message and server messages following it in the original session
+type mockEntry struct {
+	params   string            // normalized params
+	tokens   map[string]string // recorded progress tokens (key is parameter name like workDoneToken)
+	outputs  []json.RawMessage // server messages (response and notifications) in recorded order
+	response int               // index of response in outputs (-1 if not answered)
+	used     bool
+}
+
+// MockServer answers client requests with responses recorded in log.
+// A request is matched with recorded one having the same method and normalized params,
+// or with the next (unused) recorded request of the same method if not found
+type MockServer struct {
+	entries map[string][]*mockEntry // key is method
+	diag    io.Writer
+}
+
+var progressTokenParams = []string{"workDoneToken", "partialResultToken"}
+
+// normalizeParams returns canonical JSON of params (object keys are sorted) without progress tokens
+func normalizeParams(params json.RawMessage) string {
+	var v any
+	if len(params) == 0 || json.Unmarshal(params, &v) != nil {
+		return string(params)
+	}
+	if m, ok := v.(map[string]any); ok {
+		for _, name := range progressTokenParams {
+			delete(m, name)
+		}
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func progressTokens(params json.RawMessage) map[string]string {
+	var p map[string]json.RawMessage
+	if json.Unmarshal(params, &p) != nil {
+		return nil
+	}
+	tokens := map[string]string{}
+	for _, name := range progressTokenParams {
+		if token, ok := p[name]; ok && !isNullOrEmpty(token) {
+			tokens[name] = idKey(token)
+		}
+	}
+	return tokens
+}
+
+// NewMockServer reads log and collects client messages with the following server notifications.
+// Server notifications are emitted after the client message preceding them in the original session
+func NewMockServer(reader io.Reader, diag io.Writer) (*MockServer, error) {
+	s := &MockServer{entries: map[string][]*mockEntry{}, diag: diag}
+	pending := map[string]*mockEntry{} // unanswered requests
+	var last *mockEntry
+	logReader := NewLogReader(reader)
+	for {
+		d, err := logReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.payloadType != JSON || d.streamType == STDERR {
+			continue
+		}
+		e, err := d.Envelope()
+		if err != nil {
+			continue
+		}
+		if d.streamType == STDIN {
+			if e.IsResponse() {
+				continue // response to server request
+			}
+			params := paramsOf(d.payload)
+			last = &mockEntry{params: normalizeParams(params), tokens: progressTokens(params), response: -1}
+			s.entries[e.Method] = append(s.entries[e.Method], last)
+			if e.IsRequest() {
+				pending[idKey(e.Id)] = last
+			}
+			continue
+		}
+		switch {
+		case e.IsResponse():
+			if entry, ok := pending[idKey(e.Id)]; ok {
+				delete(pending, idKey(e.Id))
+				entry.response = len(entry.outputs)
+				entry.outputs = append(entry.outputs, d.payload)
+			}
+		case e.IsNotification():
+			if last != nil {
+				last.outputs = append(last.outputs, d.payload)
+			}
+		}
+	}
+	return s, nil
+}
+
+func paramsOf(payload []byte) json.RawMessage {
+	var m struct {
+		Params json.RawMessage `json:"params"`
+	}
+	_ = json.Unmarshal(payload, &m)
+	return m.Params
+}
+
+// find returns recorded entry matching method and params (nil if method is not recorded)
+func (s *MockServer) find(method string, params json.RawMessage) *mockEntry {
+	entries := s.entries[method]
+	if len(entries) == 0 {
+		return nil
+	}
+	normalized := normalizeParams(params)
+	for _, entry := range entries {
+		if !entry.used && entry.params == normalized {
+			entry.used = true
+			return entry
+		}
+	}
+	for _, entry := range entries {
+		if !entry.used {
+			entry.used = true
+			return entry
+		}
+	}
+	return entries[len(entries)-1] // all are used, so reuse the last one
+}
+
+func (s *MockServer) report(format string, args ...any) {
+	_, _ = fmt.Fprintf(s.diag, "serve: "+format+"\n", args...)
+}
+
+// rewrite replaces id of response and progress tokens of notification with live ones
+func rewrite(msg json.RawMessage, id json.RawMessage, tokens map[string]string) json.RawMessage {
+	m, err := decodeMessage(msg)
+	if err != nil {
+		return msg
+	}
+	if id != nil {
+		m["id"] = id
+	} else {
+		var p map[string]json.RawMessage
+		if json.Unmarshal(m["params"], &p) != nil || p == nil {
+			return msg
+		}
+		token, ok := tokens[idKey(p["token"])]
+		if !ok {
+			return msg
+		}
+		p["token"] = json.RawMessage(token)
+		if m["params"], err = json.Marshal(p); err != nil {
+			return msg
+		}
+	}
+	if v, err := json.Marshal(m); err == nil {
+		return v
+	}
+	return msg
+}
+
+// handle returns server messages answering the client message. exit is true if client sent exit
+func (s *MockServer) handle(payload []byte) (outputs []json.RawMessage, exit bool) {
+	e, err := ParseEnvelope(payload)
+	if err != nil || e.IsResponse() {
+		return nil, false // ignore responses (server requests are never sent)
+	}
+	if e.IsNotification() && e.Method == "exit" {
+		return nil, true
+	}
+	params := paramsOf(payload)
+	entry := s.find(e.Method, params)
+	if entry == nil || (e.IsRequest() && entry.response < 0) {
+		if e.IsRequest() {
+			s.report("no recorded response for %s", e.Method)
+			data, _ := json.Marshal(map[string]any{
+				"jsonrpc": "2.0",
+				"id":      e.Id,
+				"error":   map[string]any{"code": -32601, "message": fmt.Sprintf("method not found in log: %s", e.Method)},
+			})
+			return []json.RawMessage{data}, false
+		}
+		return nil, false
+	}
+	tokens := map[string]string{} // recorded token => live token
+	for name, token := range progressTokens(params) {
+		if recorded, ok := entry.tokens[name]; ok {
+			tokens[recorded] = token
+		}
+	}
+	for i, msg := range entry.outputs {
+		if i == entry.response {
+			if !e.IsRequest() {
+				continue
+			}
+			msg = rewrite(msg, e.Id, nil)
+		} else if len(tokens) > 0 {
+			msg = rewrite(msg, nil, tokens)
+		}
+		outputs = append(outputs, msg)
+	}
+	return outputs, false
+}
+
+var errExitNotification = errors.New("exit notification")
+
+// Serve reads client messages from reader and writes recorded server messages to writer
+// until client sends exit notification or closes stream
+func (s *MockServer) Serve(reader io.Reader, writer io.Writer) error {
+	err := readFrames(reader, func(payload []byte) error {
+		outputs, exit := s.handle(payload)
+		for _, msg := range outputs {
+			if _, err := fmt.Fprintf(writer, "Content-Length: %d\r\n\r\n%s", len(msg), msg); err != nil {
+				return err
+			}
+		}
+		if exit {
+			return errExitNotification
+		}
+		return nil
+	}, func(err error) {
+		s.report("skip broken message of client: %v", err)
+	})
+	if errors.Is(err, errExitNotification) {
+		return nil
+	}
+	return err
+}
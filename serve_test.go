@@ -0,0 +1,72 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+var serveTestLog = newTestLog(
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"rootUri":null}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{}}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a"}}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a","diagnostics":[]}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"position":{"line":1,"character":2}}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"method":"textDocument/hover","params":{"position":{"line":5,"character":0},"workDoneToken":"w1"}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"result":"first"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"w1","value":{"kind":"end"}}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"result":"second"}`)},
+)
+
+func serveMessages(t *testing.T, messages ...string) []string {
+	server, err := NewMockServer(strings.NewReader(serveTestLog), io.Discard)
+	require.NoError(t, err)
+	input := strings.Builder{}
+	for _, msg := range messages {
+		input.WriteString(frame(msg))
+	}
+	out := bytes.Buffer{}
+	require.NoError(t, server.Serve(strings.NewReader(input.String()), &out))
+	var outputs []string
+	require.NoError(t, readFrames(&out, func(payload []byte) error {
+		outputs = append(outputs, string(payload))
+		return nil
+	}, func(err error) { assert.NoError(t, err) }))
+	return outputs
+}
+
+func TestServe(t *testing.T) {
+	outputs := serveMessages(t,
+		`{"jsonrpc":"2.0","id":"a","method":"initialize","params":{"rootUri":null}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a"}}}`,
+		// params match the second one with key order and token differences
+		`{"jsonrpc":"2.0","id":10,"method":"textDocument/hover","params":{"workDoneToken":"live","position":{"character":0,"line":5}}}`,
+		// params do not match, so the next unused one is used
+		`{"jsonrpc":"2.0","id":11,"method":"textDocument/hover","params":{"position":{"line":9,"character":9}}}`,
+		`{"jsonrpc":"2.0","id":12,"method":"textDocument/definition"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+		`{"jsonrpc":"2.0","id":13,"method":"initialize"}`, // not read after exit
+	)
+	assert.Equal(t, []string{
+		`{"id":"a","jsonrpc":"2.0","result":{"capabilities":{}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a","diagnostics":[]}}`,
+		`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"live","value":{"kind":"end"}}}`,
+		`{"id":10,"jsonrpc":"2.0","result":"second"}`,
+		`{"id":11,"jsonrpc":"2.0","result":"first"}`,
+		`{"error":{"code":-32601,"message":"method not found in log: textDocument/definition"},"id":12,"jsonrpc":"2.0"}`,
+	}, outputs)
+}
+
+func TestServeReuseLast(t *testing.T) {
+	outputs := serveMessages(t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"initialize"}`,
+	)
+	assert.Equal(t, []string{
+		`{"id":1,"jsonrpc":"2.0","result":{"capabilities":{}}}`,
+		`{"id":2,"jsonrpc":"2.0","result":{"capabilities":{}}}`,
+	}, outputs)
+}
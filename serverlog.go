@@ -0,0 +1,86 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// methods of server logs, which are rendered as concise lines by print
+const (
+	logMessageMethod = "window/logMessage"
+	logTraceMethod   = "$/logTrace"
+)
+
+// serverLogLabels are labels of MessageType of window/logMessage
+var serverLogLabels = map[int]string{1: "Error", 2: "Warning", 3: "Info", 4: "Log", 5: "Debug"}
+
+// serverLog is log message which server sends to client as notification
+type serverLog struct {
+	Method  string
+	Label   string // MessageType of window/logMessage (like "Error"), or "Trace" for $/logTrace
+	Message string
+	Verbose string // additional information of $/logTrace
+}
+
+// isServerLog reports whether record is window/logMessage or $/logTrace notification from server
+func isServerLog(d *LogData, e *Envelope) bool {
+	return d.streamType == STDOUT && e != nil && e.IsNotification() &&
+		(e.Method == logMessageMethod || e.Method == logTraceMethod)
+}
+
+// parseServerLog returns server log of record (nil if record is not server log or its params are broken)
+func parseServerLog(d *LogData, e *Envelope) *serverLog {
+	if !isServerLog(d, e) || d.payloadType != JSON || d.originalSize > 0 {
+		return nil
+	}
+	var m struct {
+		Params *struct {
+			Type    int    `json:"type"`
+			Message string `json:"message"`
+			Verbose string `json:"verbose"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(d.payload, &m) != nil || m.Params == nil {
+		return nil
+	}
+	log := &serverLog{Method: e.Method, Message: m.Params.Message, Verbose: m.Params.Verbose}
+	if e.Method == logTraceMethod {
+		log.Label = "Trace"
+	} else if log.Label = serverLogLabels[m.Params.Type]; log.Label == "" {
+		log.Label = fmt.Sprintf("Type%d", m.Params.Type)
+	}
+	return log
+}
+
+// serverLogColor returns color of label (Error is red, Warning is yellow)
+func serverLogColor(label string) string {
+	switch label {
+	case "Error":
+		return ansiRed
+	case "Warning":
+		return ansiYellow
+	case "Info":
+		return ansiCyan
+	default:
+		return ansiDim
+	}
+}
+
+// formatServerLog writes server log in line like "2024-05-01T10:00:01Z <stdout> (window/logMessage) Warning: message".
+// Following lines of multi-line message (and verbose of $/logTrace) are indented
+func formatServerLog(writer io.Writer, d *LogData, stamp string, log *serverLog, color bool) {
+	label := log.Label + ":"
+	if color {
+		formatColorHeader(writer, d, stamp, log.Method)
+		label = serverLogColor(log.Label) + label + ansiReset
+	} else {
+		_, _ = fmt.Fprintf(writer, "%s %s (%s)", stamp, toString(d.streamType), withRecordNote(d, log.Method))
+	}
+	message := strings.TrimRight(log.Message, "\n")
+	if log.Verbose != "" {
+		message += "\n" + strings.TrimRight(log.Verbose, "\n")
+	}
+	_, _ = fmt.Fprintf(writer, " %s %s\n", label, strings.ReplaceAll(message, "\n", "\n    "))
+}
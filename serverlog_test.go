@@ -0,0 +1,53 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+var serverLogTestLog = newTestLog(
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"type":2,"message":"compile_commands.json is not found\n"}}`)},
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("I[10:00:02] indexing")},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"$/logTrace","params":{"message":"received initialize","verbose":"line1\nline2"}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{}}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"type":1,"message":"not from server"}}`)},
+)
+
+func TestPrintServerLogs(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(serverLogTestLog), &out, &PrintFilter{ServerLogsOnly: true}))
+	assert.Equal(t, `2024-05-01T10:00:01Z <stdout> (window/logMessage) Warning: compile_commands.json is not found
+2024-05-01T10:00:02Z <stderr> I[10:00:02] indexing
+2024-05-01T10:00:03Z <stdout> ($/logTrace) Trace: received initialize
+    line1
+    line2
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(serverLogTestLog), &out, &PrintFilter{}))
+	assert.Contains(t, out.String(), "2024-05-01T10:00:01Z <stdout> (window/logMessage) Warning: compile_commands.json is not found\n")
+	assert.Contains(t, out.String(), "\"message\": \"not from server\"") // notification from client is printed as is
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(serverLogTestLog), &out, &PrintFilter{ServerLogsOnly: true, Color: true}))
+	assert.Contains(t, out.String(), " (window/logMessage) "+ansiYellow+"Warning:"+ansiReset+" compile_commands.json")
+
+	out.Reset()
+	require.NoError(t, Print(strings.NewReader(serverLogTestLog), &out, &PrintFilter{ServerLogsOnly: true, Output: PrintOutputJSON}))
+	assert.Contains(t, out.String(), `"payload":{"jsonrpc":"2.0","method":"window/logMessage"`) // raw JSON is kept
+}
+
+func TestParseServerLog(t *testing.T) {
+	e := &Envelope{Method: logMessageMethod}
+	d := &LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"type":9,"message":"x"}}`)}
+	assert.Equal(t, &serverLog{Method: logMessageMethod, Label: "Type9", Message: "x"}, parseServerLog(d, e))
+
+	d.payload = []byte(`{"jsonrpc":"2.0","method":"window/logMessage"}`)
+	assert.Nil(t, parseServerLog(d, e))
+	d.payload, d.originalSize = []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"type":1,`), 100
+	assert.Nil(t, parseServerLog(d, e)) // truncated
+}
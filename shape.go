@@ -0,0 +1,190 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sekiguchi-nagisa/lsp-recorder/codec"
+	"io"
+	"slices"
+	"sort"
+	"text/tabwriter"
+)
+
+// JsonShape is structural metrics of JSON payload
+type JsonShape struct {
+	Nodes       int // number of values (objects, arrays, strings, numbers, literals)
+	MaxDepth    int // max nesting level of objects/arrays
+	MaxArrayLen int // length of the largest array
+	StringBytes int // bytes of string values (including quotes)
+	TotalBytes  int
+}
+
+// StructureBytes is bytes other than string values
+func (s JsonShape) StructureBytes() int {
+	return s.TotalBytes - s.StringBytes
+}
+
+// StringRatio is ratio of string bytes to structure bytes
+func (s JsonShape) StringRatio() float64 {
+	if s.StructureBytes() == 0 {
+		return 0
+	}
+	return float64(s.StringBytes) / float64(s.StructureBytes())
+}
+
+// MeasureJsonShape measures shape of JSON payload by scanning tokens (see JsonScanner)
+func MeasureJsonShape(payload []byte) (JsonShape, error) {
+	shape := JsonShape{TotalBytes: len(payload)}
+	var arrayLens []int // element count of each nesting level (-1 for object)
+	scanner := codec.NewJsonScanner(payload)
+	for {
+		token, err := scanner.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return shape, err
+		}
+		if token.Kind != codec.END_OBJECT && token.Kind != codec.END_ARRAY && token.Kind != codec.KEY {
+			shape.Nodes++
+			if n := len(arrayLens); n > 0 && arrayLens[n-1] >= 0 {
+				arrayLens[n-1]++
+			}
+		}
+		switch token.Kind {
+		case codec.BEGIN_OBJECT, codec.BEGIN_ARRAY:
+			if token.Kind == codec.BEGIN_OBJECT {
+				arrayLens = append(arrayLens, -1)
+			} else {
+				arrayLens = append(arrayLens, 0)
+			}
+			shape.MaxDepth = max(shape.MaxDepth, len(arrayLens))
+		case codec.END_OBJECT, codec.END_ARRAY:
+			if len(arrayLens) == 0 {
+				return shape, fmt.Errorf("unbalanced bracket at %d", token.Start)
+			}
+			shape.MaxArrayLen = max(shape.MaxArrayLen, arrayLens[len(arrayLens)-1])
+			arrayLens = arrayLens[:len(arrayLens)-1]
+		case codec.STRING:
+			shape.StringBytes += token.End - token.Start
+		default:
+		}
+	}
+	if len(arrayLens) > 0 {
+		return shape, errors.New("unbalanced bracket at end")
+	}
+	return shape, nil
+}
+
+// MessageShape is shape of single message (see MeasureJsonShape)
+type MessageShape struct {
+	Seq   int
+	Kind  string // request, response, partial or notification
+	Shape JsonShape
+}
+
+// MethodShapes is shapes of messages of method. Responses and partial results are attributed to method of
+// request
+type MethodShapes struct {
+	Method  string
+	From    StreamType // sender of request or notification
+	Count   int
+	Extreme []MessageShape // messages having the most nodes (at most ShapeStats.Top, the most one first)
+}
+
+// ShapeStats is per-method JSON shape metrics of messages
+type ShapeStats struct {
+	Methods []*MethodShapes // sorted by method and sender
+	Top     int             // number of the most extreme messages kept per method
+}
+
+// keep adds message to Extreme if it is one of the top most extreme messages
+func (m *MethodShapes) keep(message MessageShape, top int) {
+	i := sort.Search(len(m.Extreme), func(i int) bool { return m.Extreme[i].Shape.Nodes < message.Shape.Nodes })
+	if i >= top {
+		return
+	}
+	m.Extreme = slices.Insert(m.Extreme, i, message)
+	if len(m.Extreme) > top {
+		m.Extreme = m.Extreme[:top]
+	}
+}
+
+// CollectShapeStats reads log and measures shape of JSON messages without unmarshalling them, keeping the top most
+// extreme messages of each method
+func CollectShapeStats(reader io.Reader, top int) (*ShapeStats, error) {
+	stats := &ShapeStats{Top: top}
+	methods := map[string]*MethodShapes{}
+	tracker := NewRequestTracker()
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.payloadType != JSON {
+			continue
+		}
+		for _, d := range expandBatch(d) {
+			e, err := d.Envelope()
+			if err != nil {
+				continue
+			}
+			p := tracker.pair(d, e)
+			method, from, kind := e.Method, d.streamType, ""
+			switch {
+			case e.IsRequest():
+				kind = "request"
+			case p.partial:
+				method, from, kind = p.request.method, p.request.stream, "partial"
+			case e.IsNotification():
+				kind = "notification"
+			case e.IsResponse() && p.request != nil:
+				method, from, kind = p.request.method, p.request.stream, "response"
+			default:
+				continue // unmatched response
+			}
+			shape, err := MeasureJsonShape(d.payload)
+			if err != nil {
+				continue
+			}
+			key := fmt.Sprintf("%s:%s", from, method)
+			m, ok := methods[key]
+			if !ok {
+				m = &MethodShapes{Method: method, From: from}
+				methods[key] = m
+				stats.Methods = append(stats.Methods, m)
+			}
+			m.Count++
+			m.keep(MessageShape{Seq: d.seq, Kind: kind, Shape: shape}, top)
+		}
+	}
+	sort.SliceStable(stats.Methods, func(i, j int) bool {
+		if stats.Methods[i].Method != stats.Methods[j].Method {
+			return stats.Methods[i].Method < stats.Methods[j].Method
+		}
+		return stats.Methods[i].From < stats.Methods[j].From
+	})
+	return stats, nil
+}
+
+// Format writes the most extreme messages of each method
+func (s *ShapeStats) Format(writer io.Writer) {
+	for i, m := range s.Methods {
+		if i > 0 {
+			_, _ = fmt.Fprintln(writer)
+		}
+		_, _ = fmt.Fprintf(writer, "%s (%s, %d messages):\n", m.Method, senderOf(m.From), m.Count)
+		tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "  seq\tkind\tnodes\tdepth\tmax array\tstring bytes\tstructure bytes\tratio")
+		for _, e := range m.Extreme {
+			_, _ = fmt.Fprintf(tw, "  #%d\t%s\t%d\t%d\t%d\t%s\t%s\t%.2f\n", e.Seq, e.Kind, e.Shape.Nodes,
+				e.Shape.MaxDepth, e.Shape.MaxArrayLen, formatSize(e.Shape.StringBytes),
+				formatSize(e.Shape.StructureBytes()), e.Shape.StringRatio())
+		}
+		_ = tw.Flush()
+	}
+}
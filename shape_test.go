@@ -0,0 +1,61 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestMeasureJsonShape(t *testing.T) {
+	shape, err := MeasureJsonShape([]byte(`{"result":[{"a":1},{"a":2},{"a":[[]]}],"id":"xy"}`))
+	require.NoError(t, err)
+	assert.Equal(t, JsonShape{Nodes: 10, MaxDepth: 5, MaxArrayLen: 3, StringBytes: 4, TotalBytes: 49}, shape)
+	assert.Equal(t, 45, shape.StructureBytes())
+
+	shape, err = MeasureJsonShape([]byte(`[1,2,3,4,5]`))
+	require.NoError(t, err)
+	assert.Equal(t, 6, shape.Nodes)
+	assert.Equal(t, 5, shape.MaxArrayLen)
+
+	_, err = MeasureJsonShape([]byte(`[1,2`))
+	assert.Error(t, err)
+	_, err = MeasureJsonShape([]byte(`]`))
+	assert.Error(t, err)
+}
+
+func TestCollectShapeStats(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/completion"}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/completion"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":[{"label":"a"},{"label":"b"},{"label":"c"}]}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"result":[]}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{"message":"hello"}}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":9,"result":null}`)}, // unmatched
+	)
+	stats, err := CollectShapeStats(strings.NewReader(log), 2)
+	require.NoError(t, err)
+	require.Len(t, stats.Methods, 2)
+	completion := stats.Methods[0]
+	assert.Equal(t, "textDocument/completion", completion.Method)
+	assert.Equal(t, STDIN, completion.From)
+	assert.Equal(t, 4, completion.Count)
+	require.Len(t, completion.Extreme, 2)
+	assert.Equal(t, MessageShape{Seq: 3, Kind: "response", Shape: JsonShape{Nodes: 10, MaxDepth: 3, MaxArrayLen: 3,
+		StringBytes: 14, TotalBytes: 77}}, completion.Extreme[0])
+	assert.Equal(t, 1, completion.Extreme[1].Seq) // earlier one of the same nodes
+	assert.Equal(t, "window/logMessage", stats.Methods[1].Method)
+
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.Equal(t, `textDocument/completion (client, 4 messages):
+  seq  kind      nodes  depth  max array  string bytes  structure bytes  ratio
+  #3   response  10     3      3          14B           63B              0.22
+  #1   request   4      1      0          30B           29B              1.03
+
+window/logMessage (server, 1 messages):
+  seq  kind          nodes  depth  max array  string bytes  structure bytes  ratio
+  #5   notification  5      2      0          31B           44B              0.70
+`, out.String())
+}
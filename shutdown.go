@@ -0,0 +1,159 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultExitThreshold is how long server may live after exit notification
+const DefaultExitThreshold = 2 * time.Second
+
+const exitedPrefix = "command exited with: "
+
+// terminatedPrefix is prefix of message of signal which terminated Language Server (followed by exitedPrefix)
+const terminatedPrefix = "command terminated by signal: "
+
+// killPrefix is prefix of message of killing Language Server which did not exit within timeout
+const killPrefix = "kill process group after "
+
+// signalEndPrefix is prefix of end of stderr when recording is terminated by signal
+const signalEndPrefix = "shutdown by signal: "
+
+// cutShortPrefix is prefix of end of stderr when recording is cut short by cancellation (e.g. --duration)
+const cutShortPrefix = "session cut short: "
+
+// ShutdownAssessment is result of checking shutdown -> exit sequence of LSP lifecycle
+type ShutdownAssessment struct {
+	ShutdownSent       bool          `json:"shutdown_sent"`
+	ShutdownAnswered   bool          `json:"shutdown_answered"`
+	ExitSent           bool          `json:"exit_sent"`
+	ExitAfterShutdown  bool          `json:"exit_after_shutdown"`               // exit is sent after shutdown response
+	AutoShutdown       bool          `json:"auto_shutdown,omitempty"`           // recorder sent shutdown/exit after client disconnected
+	TerminateAfterExit time.Duration `json:"terminate_after_exit_ns,omitempty"` // from exit notification to server termination
+	ExitCode           *int          `json:"exit_code,omitempty"`               // nil if server process is not known
+	Violations         []string      `json:"violations,omitempty"`
+}
+
+// ShutdownTracker observes records and tracks shutdown -> exit sequence
+type ShutdownTracker struct {
+	Threshold time.Duration // max duration between exit notification and server termination
+
+	shutdownId       string
+	shutdownSent     bool
+	shutdownAnswered bool
+	exitSent         bool
+	exitAt           time.Time
+	exitAfter        bool
+	autoShutdown     bool // shutdown/exit synthesized by recorder are observed
+	exitCode         *int
+	exitedAt         time.Time
+}
+
+func (s *ShutdownTracker) Observe(d *LogData) {
+	switch {
+	case d.payloadType == JSON && d.streamType == STDIN && d.synthetic:
+		s.autoShutdown = true // not client traffic, so client is not assessed by them
+	case d.payloadType == JSON && d.streamType == STDIN:
+		e, err := ParseEnvelope(d.payload)
+		if err != nil {
+			return
+		}
+		if e.IsRequest() && e.Method == "shutdown" {
+			s.shutdownSent = true
+			s.shutdownId = idKey(e.Id)
+		} else if e.IsNotification() && e.Method == "exit" && !s.exitSent {
+			s.exitSent = true
+			s.exitAt = d.timestamp
+			s.exitAfter = s.shutdownAnswered
+		}
+	case d.payloadType == JSON && d.streamType == STDOUT:
+		if !s.shutdownSent || s.shutdownAnswered {
+			return
+		}
+		if e, err := ParseEnvelope(d.payload); err == nil && e.IsResponse() && idKey(e.Id) == s.shutdownId {
+			s.shutdownAnswered = true
+		}
+	case d.payloadType == RAW && d.streamType == STDERR:
+		if v, ok := strings.CutPrefix(string(d.payload), exitedPrefix); ok {
+			if code, err := strconv.Atoi(v); err == nil {
+				s.exitCode = &code
+				s.exitedAt = d.timestamp
+			}
+		}
+	}
+}
+
+func (s *ShutdownTracker) Assess() *ShutdownAssessment {
+	a := &ShutdownAssessment{
+		ShutdownSent:      s.shutdownSent,
+		ShutdownAnswered:  s.shutdownAnswered,
+		ExitSent:          s.exitSent,
+		ExitAfterShutdown: s.exitAfter,
+		AutoShutdown:      s.autoShutdown,
+		ExitCode:          s.exitCode,
+	}
+	threshold := s.Threshold
+	if threshold <= 0 {
+		threshold = DefaultExitThreshold
+	}
+	if s.exitSent && !s.shutdownSent {
+		a.Violations = append(a.Violations, "client: sent exit without shutdown")
+	} else if s.exitSent && !s.exitAfter {
+		a.Violations = append(a.Violations, "client: sent exit before shutdown response")
+	}
+	if s.exitCode == nil {
+		return a
+	}
+	if s.exitSent {
+		a.TerminateAfterExit = s.exitedAt.Sub(s.exitAt)
+		if a.TerminateAfterExit > threshold {
+			a.Violations = append(a.Violations,
+				fmt.Sprintf("server: terminated %s after exit (threshold %s)", a.TerminateAfterExit, threshold))
+		}
+	}
+	if s.exitAfter && *s.exitCode != 0 {
+		a.Violations = append(a.Violations,
+			fmt.Sprintf("server: exited with %d despite clean shutdown sequence", *s.exitCode))
+	} else if s.exitSent && !s.exitAfter && *s.exitCode == 0 {
+		a.Violations = append(a.Violations, "server: exited with 0 although exit did not follow shutdown (expected 1)")
+	} else if !s.exitSent && !s.autoShutdown {
+		a.Violations = append(a.Violations, fmt.Sprintf("server: exited with %d without exit notification", *s.exitCode))
+	}
+	return a
+}
+
+// Format writes human-readable assessment
+func (a *ShutdownAssessment) Format(writer io.Writer) {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	exitCode := "unknown"
+	if a.ExitCode != nil {
+		exitCode = strconv.Itoa(*a.ExitCode)
+	}
+	_, _ = fmt.Fprintf(writer, "%-22s%s\n", "shutdown sent:", yesNo(a.ShutdownSent))
+	_, _ = fmt.Fprintf(writer, "%-22s%s\n", "shutdown answered:", yesNo(a.ShutdownAnswered))
+	_, _ = fmt.Fprintf(writer, "%-22s%s\n", "exit sent:", yesNo(a.ExitSent))
+	_, _ = fmt.Fprintf(writer, "%-22s%s\n", "exit after shutdown:", yesNo(a.ExitAfterShutdown))
+	if a.AutoShutdown {
+		_, _ = fmt.Fprintf(writer, "%-22s%s\n", "auto shutdown:", "yes (client disconnected)")
+	}
+	_, _ = fmt.Fprintf(writer, "%-22s%s\n", "server exit code:", exitCode)
+	if a.ExitSent && a.ExitCode != nil {
+		_, _ = fmt.Fprintf(writer, "%-22s%s\n", "terminate after exit:", a.TerminateAfterExit)
+	}
+	if len(a.Violations) == 0 {
+		_, _ = fmt.Fprintln(writer, "no violation")
+		return
+	}
+	_, _ = fmt.Fprintln(writer, "violations:")
+	for _, v := range a.Violations {
+		_, _ = fmt.Fprintf(writer, "  %s\n", v)
+	}
+}
@@ -0,0 +1,139 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	shutdownRequest  = LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":9,"method":"shutdown"}`)}
+	shutdownResponse = LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":9,"result":null}`)}
+	exitNotification = LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"exit"}`)}
+)
+
+func exited(code string) LogData {
+	return LogData{streamType: STDERR, payloadType: RAW, payload: []byte(exitedPrefix + code)}
+}
+
+func assessShutdown(data ...LogData) *ShutdownAssessment {
+	tracker := &ShutdownTracker{Threshold: time.Second}
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := range data {
+		if data[i].timestamp.IsZero() {
+			data[i].timestamp = base.Add(time.Duration(i) * 100 * time.Millisecond)
+		}
+		tracker.Observe(&data[i])
+	}
+	return tracker.Assess()
+}
+
+func TestShutdownClean(t *testing.T) {
+	a := assessShutdown(shutdownRequest, shutdownResponse, exitNotification, exited("0"))
+	assert.True(t, a.ShutdownSent)
+	assert.True(t, a.ShutdownAnswered)
+	assert.True(t, a.ExitSent)
+	assert.True(t, a.ExitAfterShutdown)
+	assert.Equal(t, 100*time.Millisecond, a.TerminateAfterExit)
+	require.NotNil(t, a.ExitCode)
+	assert.Equal(t, 0, *a.ExitCode)
+	assert.Empty(t, a.Violations)
+}
+
+func TestShutdownViolations(t *testing.T) {
+	// client does not send shutdown, server exits with 0 instead of 1
+	a := assessShutdown(exitNotification, exited("0"))
+	assert.Equal(t, []string{
+		"client: sent exit without shutdown",
+		"server: exited with 0 although exit did not follow shutdown (expected 1)",
+	}, a.Violations)
+
+	// without shutdown, server exits with 1 as spec says
+	a = assessShutdown(exitNotification, exited("1"))
+	assert.Equal(t, []string{"client: sent exit without shutdown"}, a.Violations)
+
+	// client does not wait for shutdown response
+	a = assessShutdown(shutdownRequest, exitNotification, shutdownResponse, exited("1"))
+	assert.False(t, a.ExitAfterShutdown)
+	assert.Equal(t, []string{"client: sent exit before shutdown response"}, a.Violations)
+
+	// server exits abnormally despite clean sequence
+	a = assessShutdown(shutdownRequest, shutdownResponse, exitNotification, exited("143"))
+	assert.Equal(t, []string{"server: exited with 143 despite clean shutdown sequence"}, a.Violations)
+
+	// server outlives exit
+	late := exited("0")
+	late.timestamp = time.Date(2024, 5, 1, 10, 0, 5, 0, time.UTC)
+	a = assessShutdown(shutdownRequest, shutdownResponse, exitNotification, late)
+	assert.Equal(t, []string{"server: terminated 4.8s after exit (threshold 1s)"}, a.Violations)
+
+	// server crashes
+	a = assessShutdown(exited("2"))
+	assert.Equal(t, []string{"server: exited with 2 without exit notification"}, a.Violations)
+
+	// server process is unknown (e.g. connect to server)
+	a = assessShutdown(shutdownRequest, shutdownResponse, exitNotification)
+	assert.Nil(t, a.ExitCode)
+	assert.Empty(t, a.Violations)
+}
+
+func TestReadTrailer(t *testing.T) {
+	// re-derive from old log without trailer
+	log := newTestLog(shutdownRequest, shutdownResponse, exitNotification, exited("0"))
+	trailer, err := ReadTrailer(strings.NewReader(log))
+	require.NoError(t, err)
+	require.NotNil(t, trailer.Shutdown)
+	assert.True(t, trailer.Shutdown.ExitAfterShutdown)
+	assert.Empty(t, trailer.Shutdown.Violations)
+
+	// recorded trailer is preferred
+	log = newTestLog(exitNotification, exited("0"),
+		LogData{streamType: STDERR, payloadType: TRAILER, payload: []byte(`{"shutdown":{"violations":["recorded"]}}`)})
+	trailer, err = ReadTrailer(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"recorded"}, trailer.Shutdown.Violations)
+}
+
+func TestRecordTrailer(t *testing.T) {
+	buf := &syncBuffer{}
+	ch := make(chan LogData, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go record(ctx, ch, NewLogger(buf), NewSession(nil), &RunOptions{})
+	for _, d := range []LogData{exitNotification, exited("0"), {streamType: STDERR, payloadType: TRAILER}} {
+		d.timestamp = time.Now()
+		ch <- d
+	}
+	require.Eventually(t, func() bool { return strings.Contains(buf.String(), `"type":"trailer"`) },
+		time.Second, 10*time.Millisecond)
+
+	trailer, err := ReadTrailer(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"client: sent exit without shutdown",
+		"server: exited with 0 although exit did not follow shutdown (expected 1)",
+	}, trailer.Shutdown.Violations)
+}
+
+// syncBuffer is bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultSlowRequestWarning is default threshold of warning about client request without response
+const DefaultSlowRequestWarning = 30 * time.Second
+
+// prefixes of records written by slowRequestWatch
+const (
+	slowRequestPrefix  = "warning: no response to "
+	lateResponsePrefix = "response to "
+	lateResponseInfix  = " arrived after "
+)
+
+// slowRequestInterval is max interval of checking overdue requests
+const slowRequestInterval = time.Second
+
+// slowRequestMaxPending is max number of tracked requests. The oldest one is forgotten beyond it
+// (e.g. client which never waits for responses)
+const slowRequestMaxPending = 10000
+
+// watchedRequest is client request waiting for response
+type watchedRequest struct {
+	method    string
+	id        string
+	timestamp time.Time
+	warned    bool
+}
+
+// slowRequestWatch tracks client requests in record goroutine and warns about requests which have not been
+// answered within threshold (and about their responses arriving later). Since it runs in record goroutine,
+// it never blocks interception of traffic. Requests cancelled by client are no longer tracked
+type slowRequestWatch struct {
+	threshold time.Duration
+	pending   map[string]*watchedRequest // by id
+}
+
+func newSlowRequestWatch(threshold time.Duration) *slowRequestWatch {
+	return &slowRequestWatch{threshold: threshold, pending: map[string]*watchedRequest{}}
+}
+
+// interval returns interval of calling overdue
+func (w *slowRequestWatch) interval() time.Duration {
+	return min(w.threshold, slowRequestInterval)
+}
+
+// observe tracks record and returns record of late response if it answers request already warned (nil otherwise)
+func (w *slowRequestWatch) observe(d *LogData) *LogData {
+	if d.payloadType != JSON || d.streamType == STDERR {
+		return nil
+	}
+	e, err := ParseEnvelope(d.payload)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case d.streamType == STDIN && e.IsRequest():
+		id := idKey(e.Id)
+		if _, ok := w.pending[id]; !ok && len(w.pending) >= slowRequestMaxPending {
+			w.forgetOldest()
+		}
+		w.pending[id] = &watchedRequest{method: e.Method, id: id, timestamp: d.timestamp}
+	case d.streamType == STDIN && e.Method == "$/cancelRequest":
+		if id := cancelTarget(d.payload); id != nil {
+			delete(w.pending, idKey(id))
+		}
+	case d.streamType == STDOUT && e.IsResponse():
+		id := idKey(e.Id)
+		req, ok := w.pending[id]
+		if !ok {
+			return nil
+		}
+		delete(w.pending, id)
+		if req.warned {
+			return &LogData{timestamp: d.timestamp, streamType: STDERR, payloadType: RAW, recorder: true,
+				payload: []byte(fmt.Sprintf("%s%s id=%s%s%s", lateResponsePrefix, req.method, req.id,
+					lateResponseInfix, formatLatency(d.timestamp.Sub(req.timestamp))))}
+		}
+	}
+	return nil
+}
+
+// forgetOldest stops tracking the oldest request
+func (w *slowRequestWatch) forgetOldest() {
+	var oldest *watchedRequest
+	for _, req := range w.pending {
+		if oldest == nil || req.timestamp.Before(oldest.timestamp) {
+			oldest = req
+		}
+	}
+	if oldest != nil {
+		delete(w.pending, oldest.id)
+	}
+}
+
+// overdue returns warning records of requests which have exceeded threshold at now (each request is warned once)
+func (w *slowRequestWatch) overdue(now time.Time) []*LogData {
+	var requests []*watchedRequest
+	for _, req := range w.pending {
+		if !req.warned && now.Sub(req.timestamp) >= w.threshold {
+			req.warned = true
+			requests = append(requests, req)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].timestamp.Before(requests[j].timestamp)
+	})
+	records := make([]*LogData, 0, len(requests))
+	for _, req := range requests {
+		records = append(records, &LogData{timestamp: now, streamType: STDERR, payloadType: RAW, recorder: true,
+			payload: []byte(fmt.Sprintf("%s%s id=%s for %s", slowRequestPrefix, req.method, req.id,
+				formatLatency(now.Sub(req.timestamp))))})
+	}
+	return records
+}
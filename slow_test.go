@@ -0,0 +1,75 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowRequestWatch(t *testing.T) {
+	w := newSlowRequestWatch(30 * time.Second)
+	assert.Equal(t, time.Second, w.interval())
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	message := func(t StreamType, at time.Duration, payload string) *LogData {
+		return &LogData{timestamp: base.Add(at), streamType: t, payloadType: JSON, payload: []byte(payload)}
+	}
+	assert.Nil(t, w.observe(message(STDIN, 0, `{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`)))
+	assert.Nil(t, w.observe(message(STDIN, time.Second, `{"jsonrpc":"2.0","id":"a","method":"workspace/symbol"}`)))
+	assert.Nil(t, w.observe(message(STDOUT, 2*time.Second, `{"jsonrpc":"2.0","id":1,"method":"workspace/configuration"}`))) // server request
+	assert.Nil(t, w.observe(message(STDIN, 3*time.Second, `{"jsonrpc":"2.0","id":1,"result":[]}`)))
+	assert.Empty(t, w.overdue(base.Add(29*time.Second)))
+
+	warnings := w.overdue(base.Add(31 * time.Second))
+	require.Len(t, warnings, 2)
+	assert.Equal(t, "warning: no response to textDocument/hover id=1 for 31s", string(warnings[0].payload))
+	assert.Equal(t, `warning: no response to workspace/symbol id="a" for 30s`, string(warnings[1].payload))
+	assert.Empty(t, w.overdue(base.Add(60*time.Second))) // warned once
+
+	late := w.observe(message(STDOUT, 42*time.Second, `{"jsonrpc":"2.0","id":1,"result":null}`))
+	require.NotNil(t, late)
+	assert.Equal(t, STDERR, late.streamType)
+	assert.Equal(t, "response to textDocument/hover id=1 arrived after 42s", string(late.payload))
+	assert.Nil(t, w.observe(message(STDOUT, 43*time.Second, `{"jsonrpc":"2.0","id":1,"result":null}`)))
+
+	assert.Nil(t, w.observe(message(STDIN, 50*time.Second, `{"jsonrpc":"2.0","id":2,"method":"textDocument/hover"}`)))
+	assert.Nil(t, w.observe(message(STDIN, 51*time.Second, `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":2}}`)))
+	assert.NotContains(t, w.pending, "2") // cancelled
+}
+
+func TestSlowRequestWatchMaxPending(t *testing.T) {
+	w := newSlowRequestWatch(30 * time.Second)
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i <= slowRequestMaxPending; i++ {
+		w.observe(&LogData{timestamp: base.Add(time.Duration(i) * time.Millisecond), streamType: STDIN,
+			payloadType: JSON, payload: []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"a"}`, i))})
+	}
+	assert.Len(t, w.pending, slowRequestMaxPending)
+	assert.NotContains(t, w.pending, "0") // the oldest one is forgotten
+	assert.Contains(t, w.pending, "1")
+}
+
+func TestRunSlowRequestWarning(t *testing.T) {
+	buf := &syncBuffer{}
+	stdout := &bytes.Buffer{}
+	request := `{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`
+	response := `{"jsonrpc":"2.0","id":1,"result":null}`
+	script := fmt.Sprintf(`head -c %d > /dev/null; sleep 0.4; printf 'Content-Length: %d\r\n\r\n%s'`,
+		len(request)+len(fmt.Sprintf("Content-Length: %d\r\n\r\n", len(request))), len(response), response)
+	status, err := Run("sh", []string{"-c", script}, NewLogger(buf), RunOptions{NoEnv: true,
+		SlowRequestWarning: 100 * time.Millisecond, ClientIn: strings.NewReader(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(request), request)),
+		ClientOut: stdout})
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Code)
+
+	log := buf.String()
+	assert.Regexp(t, `"payload":"warning: no response to textDocument/hover id=1 for \d+ms"`, log)
+	assert.Regexp(t, `"payload":"response to textDocument/hover id=1 arrived after \d+ms"`, log)
+	warning := strings.Index(log, "warning: no response to")
+	answered := strings.Index(log, `"stream":"stdout","type":"json"`)
+	assert.Less(t, warning, answered)
+	assert.Less(t, answered, strings.Index(log, "arrived after"))
+}
@@ -0,0 +1,72 @@
+package recorder
+
+import (
+	"fmt"
+	"os"
+)
+
+// spillPreviewBytes is size of prefix of spilled payload kept in log (enough for envelope, see ParseEnvelope)
+const spillPreviewBytes = 4096
+
+// payloadBuffer accumulates payload of message whose length is declared by header. Payload is held in
+// single allocation of declared length, or written to temp file (only its prefix is held) if it is longer
+// than spill threshold, so that huge message is not copied several times in memory
+type payloadBuffer struct {
+	data []byte
+	size int      // received bytes
+	file *os.File // spill file (nil if held in memory)
+	err  error    // error of writing spill file
+}
+
+// spillFilePrefix is prefix of names of spill files
+const spillFilePrefix = "lsp-recorder-spill-"
+
+// newPayloadBuffer creates payloadBuffer of declared length. Payload longer than spillOver (0 if not spilled)
+// is written to temp file in spillDir (system temp directory if empty)
+func newPayloadBuffer(length int, spillOver int64, spillDir string) (payloadBuffer, error) {
+	if spillOver <= 0 || int64(length) <= spillOver {
+		return payloadBuffer{data: make([]byte, 0, length)}, nil
+	}
+	file, err := os.CreateTemp(spillDir, spillFilePrefix+"*.json")
+	if err != nil {
+		return payloadBuffer{data: make([]byte, 0, length)}, fmt.Errorf("failed to create spill file: %v", err)
+	}
+	return payloadBuffer{data: make([]byte, 0, min(spillPreviewBytes, spillOver)), file: file}, nil
+}
+
+func (p *payloadBuffer) Write(buf []byte) {
+	p.size += len(buf)
+	if p.file == nil {
+		p.data = append(p.data, buf...)
+		return
+	}
+	if n := min(len(buf), cap(p.data)-len(p.data)); n > 0 {
+		p.data = append(p.data, buf[:n]...)
+	}
+	if p.err == nil {
+		_, p.err = p.file.Write(buf)
+	}
+}
+
+func (p *payloadBuffer) Len() int {
+	return p.size
+}
+
+// finish fills payload of d. Spilled payload is recorded as its prefix with original size and path of spill file
+func (p *payloadBuffer) finish(d *LogData) error {
+	d.payload = p.data
+	if p.file == nil {
+		return nil
+	}
+	d.originalSize = p.size
+	err := p.file.Close()
+	if p.err != nil {
+		err = p.err
+	}
+	if err != nil {
+		_ = os.Remove(p.file.Name())
+		return fmt.Errorf("failed to spill payload: %v", err)
+	}
+	d.spill = p.file.Name()
+	return nil
+}
@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// keys of Split
+const (
+	SplitByStream = "stream" // stdin.log, stdout.log and stderr.log
+	SplitByMethod = "method" // one log per JSON-RPC method (response goes with method of its request)
+)
+
+// splitOtherName is name of log having records which are not attributed to any method. It has character
+// replaced in method name (see unsafeFileNameChars), so that it never collides with log of method like "other"
+const splitOtherName = "@other"
+
+// splitMaxOpenFiles is max number of output files kept open by Split. Least recently written one is closed
+// beyond it, and reopened in append mode (compressed log gets next gzip member or zstd frame)
+var splitMaxOpenFiles = 64
+
+// splitOutput is log file written by Split
+type splitOutput struct {
+	file   *os.File
+	writer *bufio.Writer
+	logger *slog.Logger
+	closer io.Closer // finishes compressed stream
+	used   int       // order of last write (for closing least recently written one)
+}
+
+func (o *splitOutput) Close() error {
+	err := o.closer.Close()
+	if flushErr := o.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// splitKey returns name of log which record belongs to
+func splitKey(d *LogData, e *Envelope, p pairing, by string) string {
+	if by == SplitByStream {
+		return d.streamType.String()
+	}
+	if e == nil || p.method == "" {
+		return splitOtherName
+	}
+	return unsafeFileNameChars.ReplaceAllString(p.method, "_")
+}
+
+// evictSplitOutput closes least recently written output
+func evictSplitOutput(outputs map[string]*splitOutput) error {
+	var oldest string
+	for key, output := range outputs {
+		if oldest == "" || output.used < outputs[oldest].used {
+			oldest = key
+		}
+	}
+	err := outputs[oldest].Close()
+	delete(outputs, oldest)
+	return err
+}
+
+// Split writes each record of log to <key>.log in dir (in format, see NewFormatLogger for level), where key is
+// stream type or method of record (see SplitByStream and SplitByMethod). Records keep their seqs, and are
+// written while log is read. At most splitMaxOpenFiles output files are kept open. Returns paths of written files
+func Split(reader io.Reader, dir string, by string, format string, level int) ([]string, error) {
+	outputs := map[string]*splitOutput{} // opened files
+	var files []string
+	created := map[string]bool{}
+	used := 0
+	var err error
+	scanErr := scanRecords(reader, &PrintFilter{}, func(d *LogData, e *Envelope, p pairing) bool {
+		key := splitKey(d, e, p, by)
+		output := outputs[key]
+		if output == nil {
+			if len(outputs) >= splitMaxOpenFiles {
+				if err = evictSplitOutput(outputs); err != nil {
+					return true
+				}
+			}
+			name := filepath.Join(dir, key+".log")
+			output = &splitOutput{}
+			if created[key] {
+				output.file, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+			} else {
+				output.file, err = os.Create(name)
+			}
+			if err != nil {
+				return true
+			}
+			output.writer = bufio.NewWriter(output.file)
+			if output.logger, output.closer, err = NewFormatLogger(output.writer, format, level); err != nil {
+				_ = output.file.Close()
+				return true
+			}
+			outputs[key] = output
+			if !created[key] {
+				created[key] = true
+				files = append(files, name)
+			}
+		}
+		used++
+		output.used = used
+		d.checksum = "" // digest in trailer does not match subset of records
+		writeLogData(output.logger, d)
+		return false
+	})
+	for _, output := range outputs {
+		err = errors.Join(err, output.Close())
+	}
+	return files, errors.Join(scanErr, err)
+}
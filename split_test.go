@@ -0,0 +1,81 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var splitTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte("invalid message header: 'hoge'")},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover"}`)},
+)
+
+// readSplitSeqs returns seqs of records in log file
+func readSplitSeqs(t *testing.T, name string) []int {
+	file, err := OpenLog(name) // may be compressed
+	require.NoError(t, err)
+	defer func(file io.ReadCloser) {
+		_ = file.Close()
+	}(file)
+	var seqs []int
+	require.NoError(t, scanRecords(file, &PrintFilter{}, func(d *LogData, e *Envelope, p pairing) bool {
+		seqs = append(seqs, d.seq)
+		return false
+	}))
+	return seqs
+}
+
+func TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	files, err := Split(strings.NewReader(splitTestLog), dir, SplitByStream, LogFormatJSON, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "stderr.log"), filepath.Join(dir, "stdin.log"), filepath.Join(dir, "stdout.log")}, files)
+	assert.Equal(t, []int{2, 5}, readSplitSeqs(t, filepath.Join(dir, "stdin.log")))
+	assert.Equal(t, []int{3, 4}, readSplitSeqs(t, filepath.Join(dir, "stdout.log")))
+
+	dir = t.TempDir()
+	files, err = Split(strings.NewReader(splitTestLog), dir, SplitByMethod, LogFormatJSONGzip, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "@other.log"), filepath.Join(dir, "initialize.log"),
+		filepath.Join(dir, "textDocument_hover.log")}, files)
+	assert.Equal(t, []int{1, 3}, readSplitSeqs(t, filepath.Join(dir, "@other.log")))
+
+	assert.Equal(t, []int{2, 4}, readSplitSeqs(t, filepath.Join(dir, "initialize.log")))
+}
+
+func TestSplitMaxOpenFiles(t *testing.T) {
+	defer func(n int) {
+		splitMaxOpenFiles = n
+	}(splitMaxOpenFiles)
+	splitMaxOpenFiles = 2
+	notification := func(method string) LogData {
+		return LogData{streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","method":"` + method + `"}`)}
+	}
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+		notification("other"), // not mixed with unattributed records
+		notification("a"),
+		notification("other"),
+		notification("b"),
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("server log")},
+		notification("a"),
+	)
+	for _, format := range []string{LogFormatJSON, LogFormatJSONGzip} {
+		dir := t.TempDir()
+		files, err := Split(strings.NewReader(log), dir, SplitByMethod, format, 0)
+		require.NoError(t, err)
+		assert.Len(t, files, 4)
+		assert.Equal(t, []int{1, 6}, readSplitSeqs(t, filepath.Join(dir, "@other.log")))
+		assert.Equal(t, []int{2, 4}, readSplitSeqs(t, filepath.Join(dir, "other.log")))
+		assert.Equal(t, []int{3, 7}, readSplitSeqs(t, filepath.Join(dir, "a.log"))) // reopened
+		assert.Equal(t, []int{5}, readSplitSeqs(t, filepath.Join(dir, "b.log")))
+	}
+}
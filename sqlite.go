@@ -0,0 +1,121 @@
+package recorder
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	_ "modernc.org/sqlite" // pure Go driver, so that cross compilation works
+	"os"
+	"time"
+)
+
+// messages are keyed by row (order in log), since seq restarts in each session appended to log (see LogOptions.Append).
+// source is log file which record is merged from (NULL unless merged, see MergeLogs)
+const sqliteSchema = `CREATE TABLE messages (
+  row INTEGER PRIMARY KEY,
+  session INTEGER NOT NULL,
+  seq INTEGER NOT NULL,
+  source TEXT,
+  timestamp TEXT NOT NULL,
+  time_ns INTEGER NOT NULL,
+  stream TEXT NOT NULL,
+  payload_type TEXT NOT NULL,
+  method TEXT,
+  id TEXT,
+  size INTEGER NOT NULL,
+  payload TEXT NOT NULL
+);
+`
+
+// requests view joins each request with the first following response of the same id on the peer stream
+// in the same session (and the same source)
+const sqliteViews = `CREATE INDEX messages_id ON messages(session, id);
+CREATE VIEW requests AS
+SELECT req.row AS row,
+  req.session AS session,
+  req.seq AS seq,
+  CASE req.stream WHEN 'stdin' THEN 'client' ELSE 'server' END AS sender,
+  req.method AS method,
+  req.id AS id,
+  req.timestamp AS timestamp,
+  req.size AS size,
+  res.seq AS response_seq,
+  res.size AS response_size,
+  (res.time_ns - req.time_ns) / 1000000.0 AS latency_ms
+FROM messages req LEFT JOIN messages res ON res.row = (
+  SELECT min(r.row) FROM messages r
+  WHERE r.session = req.session AND r.id = req.id AND r.row > req.row AND r.source IS req.source
+    AND r.method IS NULL AND r.payload_type = 'json'
+    AND r.stream = CASE req.stream WHEN 'stdin' THEN 'stdout' ELSE 'stdin' END)
+WHERE req.payload_type = 'json' AND req.method IS NOT NULL AND req.id IS NOT NULL;
+`
+
+// nullString returns NULL for empty string
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// ExportSQLite converts log into SQLite database file having messages table and requests view.
+// Existing file is replaced. Records are inserted one by one, so whole log is never loaded
+func ExportSQLite(reader io.Reader, path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot replace database: %s, caused by %s", path, err.Error())
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback() // nothing if committed
+	}(tx)
+	if _, err := tx.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare("INSERT INTO messages VALUES(NULL, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer func(insert *sql.Stmt) {
+		_ = insert.Close()
+	}(insert)
+	session := 0
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if d.payloadType == SESSION_START || session == 0 { // log of old version has no start of session
+			session++
+		}
+		method, id := "", ""
+		if d.payloadType == JSON {
+			if e, err := d.Envelope(); err == nil {
+				method = e.Method
+				if e.Id != nil {
+					id = idKey(e.Id)
+				}
+			}
+		}
+		if _, err := insert.Exec(session, d.seq, nullString(d.source), d.timestamp.Format(time.RFC3339Nano), d.timestamp.UnixNano(),
+			d.streamType.String(), d.payloadType.String(), nullString(method), nullString(id), len(d.payload),
+			string(d.payload)); err != nil {
+			return fmt.Errorf("cannot insert record %d of session %d, caused by %s", d.seq, session, err.Error())
+		}
+	}
+	if _, err := tx.Exec(sqliteViews); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,94 @@
+package recorder
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queryRows returns rows of query formatted like sqlite3 command (columns separated by |, NULL is empty)
+func queryRows(t *testing.T, db *sql.DB, query string) []string {
+	rows, err := db.Query(query)
+	require.NoError(t, err)
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	var lines []string
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		require.NoError(t, rows.Scan(pointers...))
+		fields := make([]string, len(values))
+		for i, v := range values {
+			if v != nil {
+				fields[i] = fmt.Sprint(v)
+			}
+		}
+		lines = append(lines, strings.Join(fields, "|"))
+	}
+	require.NoError(t, rows.Err())
+	return lines
+}
+
+func TestExportSQLite(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	log := newTestLog(
+		LogData{timestamp: base, streamType: STDERR, payloadType: RAW, payload: []byte("it's server\x00")},
+		LogData{timestamp: base.Add(time.Millisecond), streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`)},
+		LogData{timestamp: base.Add(2 * time.Millisecond), streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":"x","method":"workspace/configuration"}`)},
+		LogData{timestamp: base.Add(3 * time.Millisecond), streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":"x","result":[]}`)},
+		LogData{timestamp: base.Add(39500 * time.Microsecond), streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)},
+		LogData{timestamp: base.Add(40 * time.Millisecond), streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`)},
+	)
+	path := filepath.Join(t.TempDir(), "out.db")
+	require.NoError(t, os.WriteFile(path, []byte("previous"), 0o666)) // replaced
+	require.NoError(t, ExportSQLite(strings.NewReader(log), path))
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+	assert.Equal(t, []string{"6|3"}, queryRows(t, db, "SELECT count(*), sum(method IS NULL) FROM messages"))
+	assert.Equal(t, []string{"it's server\x00"}, queryRows(t, db, "SELECT payload FROM messages WHERE seq = 1"))
+	assert.Equal(t, []string{
+		"2|client|textDocument/hover|1|5|38.5",
+		`3|server|workspace/configuration|"x"|4|1`,
+		"6|client|shutdown|2||",
+	}, queryRows(t, db, "SELECT seq, sender, method, id, response_seq, latency_ms FROM requests ORDER BY seq"))
+}
+
+func TestExportSQLiteAppended(t *testing.T) {
+	session := func(method string) string {
+		return newTestLog(
+			LogData{streamType: STDERR, payloadType: SESSION_START, payload: []byte(`{"pid":1}`)},
+			LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"` + method + `"}`)},
+			LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)},
+		)
+	}
+	// seq and ids restart in appended session
+	log := session("initialize") + session("shutdown")
+	path := filepath.Join(t.TempDir(), "out.db")
+	require.NoError(t, ExportSQLite(strings.NewReader(log), path))
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+	assert.Equal(t, []string{"1|1|1", "2|1|2", "3|1|3", "4|2|1", "5|2|2", "6|2|3"},
+		queryRows(t, db, "SELECT row, session, seq FROM messages ORDER BY row"))
+	assert.Equal(t, []string{"2|1|2|initialize|3", "5|2|2|shutdown|3"},
+		queryRows(t, db, "SELECT row, session, seq, method, response_seq FROM requests ORDER BY row"))
+}
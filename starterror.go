@@ -0,0 +1,87 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"time"
+)
+
+// exit codes of Language Server executable which cannot be started (like shell)
+const (
+	exitCannotExecute   = 126
+	exitCommandNotFound = 127
+)
+
+// StartErrorKind is cause of StartError
+type StartErrorKind int
+
+const (
+	StartFailed            StartErrorKind = iota // executable cannot be started (e.g. invalid executable format)
+	StartNotFound                                // executable is not found
+	StartPermissionDenied                        // executable is not permitted to run
+	StartExitedImmediately                       // Language Server exited with failure immediately after start
+)
+
+func (k StartErrorKind) String() string {
+	switch k {
+	case StartNotFound:
+		return "not found"
+	case StartPermissionDenied:
+		return "permission denied"
+	case StartExitedImmediately:
+		return "exited immediately"
+	default:
+		return "failed"
+	}
+}
+
+// immediateExitWindow is period after start of Language Server within which its failure is regarded as failure
+// of start. Client is attached after the period, so editor is not left with dead server
+const immediateExitWindow = 100 * time.Millisecond
+
+// StartError is returned by Run if Language Server cannot be started, or exits with failure immediately after
+// start. Log still has session metadata, the error and trailer
+type StartError struct {
+	Kind   StartErrorKind
+	Name   string     // executable of Language Server
+	Err    error      // cause of failure (nil if exited immediately)
+	Status ExitStatus // exit status of Language Server exited immediately
+}
+
+// NewStartError classifies error of starting executable
+func NewStartError(name string, err error) *StartError {
+	kind := StartFailed
+	switch {
+	case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist):
+		kind = StartNotFound
+	case errors.Is(err, fs.ErrPermission):
+		kind = StartPermissionDenied
+	}
+	return &StartError{Kind: kind, Name: name, Err: err}
+}
+
+func (e *StartError) Error() string {
+	if e.Kind == StartExitedImmediately {
+		return fmt.Sprintf("failed to start command: %s exited with %d immediately after start", e.Name, e.Status.Code)
+	}
+	return fmt.Sprintf("failed to start command: %s (%s), caused by %v", e.Name, e.Kind, e.Err)
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns exit code of recorder like shell: 127 if not found, 126 if cannot be started, or exit code of
+// Language Server exited immediately
+func (e *StartError) ExitCode() int {
+	switch e.Kind {
+	case StartNotFound:
+		return exitCommandNotFound
+	case StartExitedImmediately:
+		return e.Status.Code
+	default:
+		return exitCannotExecute
+	}
+}
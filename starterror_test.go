@@ -0,0 +1,74 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startErrorRecords runs Language Server, and returns StartError and payload types and messages of log records
+func startErrorRecords(t *testing.T, name string, args ...string) (*StartError, []PayloadType, string) {
+	buf := &syncBuffer{}
+	clientIn, clientWriter := io.Pipe() // client keeps connection open
+	defer func() {
+		_ = clientWriter.Close()
+	}()
+	stderr := &syncBuffer{}
+	_, err := Run(name, args, NewLogger(buf), RunOptions{NoEnv: true, ClientIn: clientIn, ClientOut: io.Discard,
+		ErrOut: stderr})
+	var startErr *StartError
+	require.ErrorAs(t, err, &startErr)
+	assert.Contains(t, stderr.String(), err.Error()+"\n")
+
+	var types []PayloadType
+	messages := strings.Builder{}
+	reader := NewLogReader(strings.NewReader(buf.String()))
+	for {
+		d, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		types = append(types, d.payloadType)
+		if d.streamType == STDERR {
+			messages.Write(d.payload)
+			messages.WriteString("\n")
+		}
+	}
+	_, err = ReadTrailer(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	return startErr, types, messages.String()
+}
+
+func TestRunStartNotFound(t *testing.T) {
+	startErr, types, messages := startErrorRecords(t, "./no-such-server")
+	assert.Equal(t, StartNotFound, startErr.Kind)
+	assert.Equal(t, 127, startErr.ExitCode())
+	assert.Equal(t, SESSION_START, types[0])
+	assert.Equal(t, TRAILER, types[len(types)-1])
+	assert.Contains(t, messages, "failed to start command: ./no-such-server (not found)")
+}
+
+func TestRunStartPermissionDenied(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"), 0o644)) // not executable
+	startErr, _, messages := startErrorRecords(t, path)
+	assert.Equal(t, StartPermissionDenied, startErr.Kind)
+	assert.Equal(t, 126, startErr.ExitCode())
+	assert.Contains(t, messages, "(permission denied)")
+}
+
+func TestRunStartExitedImmediately(t *testing.T) {
+	startErr, types, messages := startErrorRecords(t, "sh", "-c", "echo 'unknown option' >&2; exit 2")
+	assert.Equal(t, StartExitedImmediately, startErr.Kind)
+	assert.Equal(t, 2, startErr.ExitCode())
+	assert.Equal(t, SESSION_START, types[0])
+	assert.Equal(t, TRAILER, types[len(types)-1])
+	assert.Contains(t, messages, "unknown option") // stderr of server precedes error
+	assert.Contains(t, messages, "failed to start command: sh exited with 2 immediately after start")
+	assert.Less(t, strings.Index(messages, "unknown option"), strings.Index(messages, "failed to start command"))
+}
@@ -0,0 +1,346 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DocumentState is text document opened by client
+type DocumentState struct {
+	URI        string
+	LanguageId string
+	Version    int
+	OpenedAt   time.Time
+}
+
+// ProgressState is work done progress begun and not ended yet
+type ProgressState struct {
+	Token      string
+	Title      string
+	Message    string
+	Percentage *int
+	Begin      time.Time
+}
+
+// ErrorResponse is error response of request
+type ErrorResponse struct {
+	Seq     int
+	Time    time.Time
+	Method  string // method of the corresponding request (empty if unknown)
+	Id      string
+	Code    int
+	Message string
+}
+
+// SessionState accumulates state of session (open documents, outstanding requests, progress,
+// diagnostics, last error response and stderr) by observing records in log order
+type SessionState struct {
+	StderrLines int // number of last stderr lines to be kept
+
+	Seq   int       // sequence number of last observed record
+	Start time.Time // time of first record
+	Time  time.Time // time of last observed record
+
+	requests    *RequestTracker
+	documents   map[string]*DocumentState
+	progress    map[string]*ProgressState // key is token
+	diagnostics map[string]int            // number of diagnostics of each uri
+	lastError   *ErrorResponse
+	stderr      []string
+}
+
+func NewSessionState(stderrLines int) *SessionState {
+	return &SessionState{
+		StderrLines: max(stderrLines, 0),
+		requests:    NewRequestTracker(),
+		documents:   map[string]*DocumentState{},
+		progress:    map[string]*ProgressState{},
+		diagnostics: map[string]int{},
+	}
+}
+
+// Observe updates state by record
+func (s *SessionState) Observe(d *LogData) {
+	if s.Start.IsZero() {
+		s.Start = d.timestamp
+	}
+	s.Seq = d.seq
+	s.Time = d.timestamp
+	switch d.payloadType {
+	case JSON:
+		if e, err := d.Envelope(); err == nil {
+			s.observeMessage(d, e)
+		}
+	case RAW:
+		if d.streamType == STDERR {
+			s.observeStderr(string(d.payload))
+		}
+	}
+}
+
+func (s *SessionState) observeStderr(text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		s.stderr = append(s.stderr, strings.TrimRight(line, "\r"))
+	}
+	if len(s.stderr) > s.StderrLines {
+		s.stderr = append(s.stderr[:0], s.stderr[len(s.stderr)-s.StderrLines:]...)
+	}
+}
+
+func (s *SessionState) observeMessage(d *LogData, e *Envelope) {
+	p := s.requests.pair(d, e)
+	if e.IsResponse() && !isNullOrEmpty(e.Error) {
+		var rpcError struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(e.Error, &rpcError)
+		s.lastError = &ErrorResponse{Seq: d.seq, Time: d.timestamp, Method: p.method, Id: idKey(e.Id),
+			Code: rpcError.Code, Message: rpcError.Message}
+		return
+	}
+	if !e.IsNotification() || p.partial {
+		return
+	}
+	var params struct {
+		TextDocument struct {
+			URI        string `json:"uri"`
+			LanguageId string `json:"languageId"`
+			Version    int    `json:"version"`
+		} `json:"textDocument"`
+		URI         string            `json:"uri"`
+		Diagnostics []json.RawMessage `json:"diagnostics"`
+		Token       json.RawMessage   `json:"token"`
+		Value       struct {
+			Kind       string `json:"kind"`
+			Title      string `json:"title"`
+			Message    string `json:"message"`
+			Percentage *int   `json:"percentage"`
+		} `json:"value"`
+	}
+	var m struct {
+		Params json.RawMessage `json:"params"`
+	}
+	if json.Unmarshal(d.payload, &m) != nil || json.Unmarshal(m.Params, &params) != nil {
+		return
+	}
+	doc := params.TextDocument
+	switch {
+	case d.streamType == STDIN && e.Method == "textDocument/didOpen":
+		s.documents[doc.URI] = &DocumentState{URI: doc.URI, LanguageId: doc.LanguageId, Version: doc.Version,
+			OpenedAt: d.timestamp}
+	case d.streamType == STDIN && e.Method == "textDocument/didChange":
+		if state, ok := s.documents[doc.URI]; ok {
+			state.Version = doc.Version
+		}
+	case d.streamType == STDIN && e.Method == "textDocument/didClose":
+		delete(s.documents, doc.URI)
+	case d.streamType == STDOUT && e.Method == "textDocument/publishDiagnostics":
+		if len(params.Diagnostics) == 0 {
+			delete(s.diagnostics, params.URI)
+		} else {
+			s.diagnostics[params.URI] = len(params.Diagnostics)
+		}
+	case e.Method == "$/progress" && !isNullOrEmpty(params.Token):
+		token := idKey(params.Token)
+		value := params.Value
+		switch value.Kind {
+		case "begin":
+			s.progress[token] = &ProgressState{Token: token, Title: value.Title, Message: value.Message,
+				Percentage: value.Percentage, Begin: d.timestamp}
+		case "report":
+			if state, ok := s.progress[token]; ok {
+				if value.Message != "" {
+					state.Message = value.Message
+				}
+				if value.Percentage != nil {
+					state.Percentage = value.Percentage
+				}
+			}
+		case "end":
+			delete(s.progress, token)
+		}
+	}
+}
+
+// Documents returns open documents sorted by uri
+func (s *SessionState) Documents() []*DocumentState {
+	var docs []*DocumentState
+	for _, doc := range s.documents {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].URI < docs[j].URI })
+	return docs
+}
+
+// OutstandingRequests returns requests not answered yet in order of sending
+func (s *SessionState) OutstandingRequests() []*pendingRequest {
+	var requests []*pendingRequest
+	for _, req := range s.requests.pending {
+		requests = append(requests, req)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].timestamp.Before(requests[j].timestamp) })
+	return requests
+}
+
+// Progress returns active work done progress in order of beginning
+func (s *SessionState) Progress() []*ProgressState {
+	var progress []*ProgressState
+	for _, p := range s.progress {
+		progress = append(progress, p)
+	}
+	sort.Slice(progress, func(i, j int) bool {
+		if !progress[i].Begin.Equal(progress[j].Begin) {
+			return progress[i].Begin.Before(progress[j].Begin)
+		}
+		return progress[i].Token < progress[j].Token
+	})
+	return progress
+}
+
+// Diagnostics returns number of diagnostics of each uri (uri having no diagnostics is omitted)
+func (s *SessionState) Diagnostics() map[string]int {
+	return s.diagnostics
+}
+
+func (s *SessionState) LastError() *ErrorResponse {
+	return s.lastError
+}
+
+// StderrTail returns last stderr lines
+func (s *SessionState) StderrTail() []string {
+	return s.stderr
+}
+
+// StateCursor is moment of session. State is taken after record of Seq (if Seq > 0) or last record until Time
+type StateCursor struct {
+	Seq  int
+	Time *TimeBound
+}
+
+// ParseStateCursor parses sequence number, RFC3339 timestamp or offset from start of log (like +5m)
+func ParseStateCursor(s string) (*StateCursor, error) {
+	if seq, err := strconv.Atoi(s); err == nil {
+		if seq <= 0 {
+			return nil, fmt.Errorf("sequence number must be positive: %d", seq)
+		}
+		return &StateCursor{Seq: seq}, nil
+	}
+	bound, err := ParseTimeBound(s)
+	if err != nil {
+		return nil, fmt.Errorf("cursor must be sequence number, RFC3339 timestamp or offset like +5m: '%s'", s)
+	}
+	return &StateCursor{Time: bound}, nil
+}
+
+// ReadSessionState reads log until cursor and returns state at that moment
+func ReadSessionState(reader io.Reader, cursor *StateCursor, stderrLines int) (*SessionState, error) {
+	state := NewSessionState(stderrLines)
+	var until time.Time
+	r := NewLogReader(reader)
+	for first := true; ; first = false {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && cursor.Time != nil {
+			until = cursor.Time.resolve(d.timestamp)
+		}
+		if cursor.Time != nil && d.timestamp.After(until) {
+			break
+		}
+		state.Observe(d)
+		if cursor.Seq > 0 && d.seq >= cursor.Seq {
+			break
+		}
+	}
+	if state.Seq == 0 {
+		return nil, errors.New("no record before cursor")
+	}
+	return state, nil
+}
+
+// Format writes snapshot of state. Ages are relative to time of last observed record
+func (s *SessionState) Format(writer io.Writer) {
+	_, _ = fmt.Fprintf(writer, "state at seq %d (%s, +%s from start)\n", s.Seq, s.Time.Format(time.RFC3339Nano),
+		formatLatency(s.Time.Sub(s.Start)))
+	section := func(title string, count int) *tabwriter.Writer {
+		_, _ = fmt.Fprintf(writer, "\n%s (%d):\n", title, count)
+		if count == 0 {
+			_, _ = fmt.Fprintln(writer, "  (none)")
+		}
+		return tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	}
+
+	docs := s.Documents()
+	tw := section("open documents", len(docs))
+	for _, doc := range docs {
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\tversion %d\n", doc.URI, doc.LanguageId, doc.Version)
+	}
+	_ = tw.Flush()
+
+	requests := s.OutstandingRequests()
+	tw = section("outstanding requests", len(requests))
+	for _, req := range requests {
+		cancelled := ""
+		if req.cancelled {
+			cancelled = " (cancelled)"
+		}
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\tid=%s\t%s%s\n", senderOf(req.stream), req.method, idKey(req.id),
+			formatLatency(s.Time.Sub(req.timestamp)), cancelled)
+	}
+	_ = tw.Flush()
+
+	progress := s.Progress()
+	tw = section("active progress", len(progress))
+	for _, p := range progress {
+		percentage := "-"
+		if p.Percentage != nil {
+			percentage = fmt.Sprintf("%d%%", *p.Percentage)
+		}
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", p.Token, p.Title, percentage, p.Message,
+			formatLatency(s.Time.Sub(p.Begin)))
+	}
+	_ = tw.Flush()
+
+	var uris []string
+	for uri := range s.diagnostics {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	tw = section("diagnostics", len(uris))
+	for _, uri := range uris {
+		_, _ = fmt.Fprintf(tw, "  %s\t%d\n", uri, s.diagnostics[uri])
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintln(writer, "\nlast error response:")
+	if e := s.lastError; e != nil {
+		method := e.Method
+		if method == "" {
+			method = "(unknown request)"
+		}
+		_, _ = fmt.Fprintf(writer, "  seq %d %s id=%s, %s ago: %d %s\n", e.Seq, method, e.Id,
+			formatLatency(s.Time.Sub(e.Time)), e.Code, e.Message)
+	} else {
+		_, _ = fmt.Fprintln(writer, "  (none)")
+	}
+
+	_, _ = fmt.Fprintf(writer, "\nstderr (last %d lines):\n", len(s.stderr))
+	if len(s.stderr) == 0 {
+		_, _ = fmt.Fprintln(writer, "  (none)")
+	}
+	for _, line := range s.stderr {
+		_, _ = fmt.Fprintf(writer, "  %s\n", line)
+	}
+}
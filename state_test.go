@@ -0,0 +1,162 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var stateTestLog = newTestLog(
+	/* 1 */ LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+	/* 2 */ LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)},
+	/* 3 */ LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+	/* 4 */ LogData{streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.go","languageId":"go","version":1,"text":""}}}`)},
+	/* 5 */ LogData{streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///b.go","languageId":"go","version":1,"text":""}}}`)},
+	/* 6 */ LogData{streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.go","version":4},"contentChanges":[]}}`)},
+	/* 7 */ LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"idx","value":{"kind":"begin","title":"Indexing","percentage":0}}}`)},
+	/* 8 */ LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.go","diagnostics":[{},{},{}]}}`)},
+	/* 9 */ LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{}}`)},
+	/* 10 */ LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"idx","value":{"kind":"report","message":"3/10 files","percentage":30}}}`)},
+	/* 11 */ LogData{streamType: STDERR, payloadType: RAW, payload: []byte("line 1\nline 2\n")},
+	/* 12 */ LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","id":2,"error":{"code":-32603,"message":"internal error"}}`)},
+	/* 13 */ LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"method":"textDocument/definition","params":{}}`)},
+	/* 14 */ LogData{streamType: STDERR, payloadType: RAW, payload: []byte("line 3\r\nline 4")},
+	/* 15 */ LogData{streamType: STDIN, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didClose","params":{"textDocument":{"uri":"file:///b.go"}}}`)},
+	/* 16 */ LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.go","diagnostics":[]}}`)},
+	/* 17 */ LogData{streamType: STDOUT, payloadType: JSON,
+		payload: []byte(`{"jsonrpc":"2.0","method":"$/progress","params":{"token":"idx","value":{"kind":"end"}}}`)},
+)
+
+func readState(t *testing.T, at string) *SessionState {
+	cursor, err := ParseStateCursor(at)
+	require.NoError(t, err)
+	state, err := ReadSessionState(strings.NewReader(stateTestLog), cursor, 3)
+	require.NoError(t, err)
+	return state
+}
+
+func TestParseStateCursor(t *testing.T) {
+	cursor, err := ParseStateCursor("42")
+	require.NoError(t, err)
+	assert.Equal(t, 42, cursor.Seq)
+	cursor, err = ParseStateCursor("+5m")
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, cursor.Time.Offset)
+	for _, s := range []string{"0", "-1", "hoge"} {
+		_, err = ParseStateCursor(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestSessionStateCursor(t *testing.T) {
+	state := readState(t, "12")
+	assert.Equal(t, 12, state.Seq)
+	state = readState(t, "+11500ms") // seq 12 is at +11s
+	assert.Equal(t, 12, state.Seq)
+	state = readState(t, "2024-05-01T10:00:11Z")
+	assert.Equal(t, 12, state.Seq)
+	state = readState(t, "100")
+	assert.Equal(t, 17, state.Seq)
+
+	cursor, _ := ParseStateCursor("2024-05-01T09:00:00Z")
+	_, err := ReadSessionState(strings.NewReader(stateTestLog), cursor, 3)
+	assert.Error(t, err)
+}
+
+func TestSessionStateDocuments(t *testing.T) {
+	docs := readState(t, "6").Documents()
+	require.Len(t, docs, 2)
+	assert.Equal(t, &DocumentState{URI: "file:///a.go", LanguageId: "go", Version: 4, OpenedAt: docs[0].OpenedAt}, docs[0])
+	assert.Equal(t, 1, docs[1].Version)
+
+	docs = readState(t, "15").Documents()
+	require.Len(t, docs, 1)
+	assert.Equal(t, "file:///a.go", docs[0].URI)
+}
+
+func TestSessionStateRequests(t *testing.T) {
+	requests := readState(t, "11").OutstandingRequests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "textDocument/hover", requests[0].method)
+
+	requests = readState(t, "13").OutstandingRequests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "textDocument/definition", requests[0].method)
+}
+
+func TestSessionStateProgress(t *testing.T) {
+	progress := readState(t, "10").Progress()
+	require.Len(t, progress, 1)
+	assert.Equal(t, "\"idx\"", progress[0].Token)
+	assert.Equal(t, "Indexing", progress[0].Title)
+	assert.Equal(t, "3/10 files", progress[0].Message)
+	assert.Equal(t, 30, *progress[0].Percentage)
+
+	assert.Empty(t, readState(t, "17").Progress())
+}
+
+func TestSessionStateDiagnostics(t *testing.T) {
+	assert.Empty(t, readState(t, "7").Diagnostics())
+	assert.Equal(t, map[string]int{"file:///a.go": 3}, readState(t, "8").Diagnostics())
+	assert.Empty(t, readState(t, "16").Diagnostics())
+}
+
+func TestSessionStateLastError(t *testing.T) {
+	assert.Nil(t, readState(t, "11").LastError())
+	e := readState(t, "13").LastError()
+	require.NotNil(t, e)
+	assert.Equal(t, 12, e.Seq)
+	assert.Equal(t, "textDocument/hover", e.Method)
+	assert.Equal(t, -32603, e.Code)
+	assert.Equal(t, "internal error", e.Message)
+}
+
+func TestSessionStateStderr(t *testing.T) {
+	assert.Equal(t, []string{"run: server []"}, readState(t, "10").StderrTail())
+	assert.Equal(t, []string{"line 2", "line 3", "line 4"}, readState(t, "14").StderrTail())
+}
+
+func TestSessionStateFormat(t *testing.T) {
+	out := bytes.Buffer{}
+	readState(t, "14").Format(&out)
+	assert.Equal(t, `state at seq 14 (2024-05-01T10:00:13Z, +13s from start)
+
+open documents (2):
+  file:///a.go  go  version 4
+  file:///b.go  go  version 1
+
+outstanding requests (1):
+  client  textDocument/definition  id=3  1s
+
+active progress (1):
+  "idx"  Indexing  30%  3/10 files  7s
+
+diagnostics (1):
+  file:///a.go  3
+
+last error response:
+  seq 12 textDocument/hover id=2, 2s ago: -32603 internal error
+
+stderr (last 3 lines):
+  line 2
+  line 3
+  line 4
+`, out.String())
+
+	out.Reset()
+	readState(t, "1").Format(&out)
+	assert.Contains(t, out.String(), "open documents (0):\n  (none)\n")
+	assert.Contains(t, out.String(), "last error response:\n  (none)\n")
+}
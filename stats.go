@@ -0,0 +1,594 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// percentile returns nearest-rank percentile of sorted durations or sizes (0 if empty)
+func percentile[T int | time.Duration](sorted []T, p float64) T {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func sortDurations(durations []time.Duration) []time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// PipelineRecord is timing of recorder pipeline (parse -> channel -> log) of single record
+type PipelineRecord struct {
+	Seq    int
+	Stream StreamType
+	Method string
+	Queue  time.Duration // channel residency
+	Write  time.Duration // log write time (-1 if unknown, e.g. last record)
+}
+
+func (r *PipelineRecord) total() time.Duration {
+	if r.Write < 0 {
+		return r.Queue
+	}
+	return r.Queue + r.Write
+}
+
+// PipelineStats is timing distribution of recorder pipeline
+type PipelineStats struct {
+	Meta    *SessionMeta     // metadata of session (nil if log has no META record)
+	Records []PipelineRecord // records having timing
+}
+
+// CollectPipelineStats reads log and collects pipeline timing.
+// Write time of each record is taken from the next record since it is known only after writing
+func CollectPipelineStats(reader io.Reader) (*PipelineStats, error) {
+	stats := &PipelineStats{}
+	r := NewLogReader(reader)
+	var prev *PipelineRecord
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.payloadType == META {
+			stats.Meta = mergeSessionMeta(stats.Meta, d.payload)
+		}
+		if prev != nil && d.prevWriteTime > 0 {
+			prev.Write = d.prevWriteTime
+		}
+		prev = nil
+		if d.queueTime <= 0 {
+			continue // not instrumented (e.g. upgraded legacy log)
+		}
+		rec := PipelineRecord{Seq: d.seq, Stream: d.streamType, Queue: d.queueTime, Write: -1}
+		if d.payloadType == JSON {
+			if e, err := d.Envelope(); err == nil {
+				rec.Method = e.Method
+			}
+		}
+		stats.Records = append(stats.Records, rec)
+		prev = &stats.Records[len(stats.Records)-1]
+	}
+	return stats, nil
+}
+
+// Worst returns at most n records ordered by total pipeline time
+func (s *PipelineStats) Worst(n int) []PipelineRecord {
+	sorted := append([]PipelineRecord(nil), s.Records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].total() > sorted[j].total() })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+func (s *PipelineStats) stages() ([]string, [][]time.Duration) {
+	var queue, write, total []time.Duration
+	for i := range s.Records {
+		r := &s.Records[i]
+		queue = append(queue, r.Queue)
+		if r.Write >= 0 {
+			write = append(write, r.Write)
+			total = append(total, r.total())
+		}
+	}
+	return []string{"queue", "write", "total"}, [][]time.Duration{queue, write, total}
+}
+
+// Format writes distribution of each stage and worst records
+func (s *PipelineStats) Format(writer io.Writer, worst int) {
+	formatStatsHeader(writer, s.Meta, nil)
+	if len(s.Records) == 0 {
+		_, _ = fmt.Fprintln(writer, "no pipeline timing in log")
+		return
+	}
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "stage\tcount\tp50\tp90\tp99\tmax\t")
+	names, stages := s.stages()
+	for i, name := range names {
+		sorted := sortDurations(stages[i])
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t\n", name, len(sorted), percentile(sorted, 50),
+			percentile(sorted, 90), percentile(sorted, 99), percentile(sorted, 100))
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintf(writer, "\nworst %d records:\n", worst)
+	tw = tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "seq\tstream\tmethod\tqueue\twrite")
+	for _, r := range s.Worst(worst) {
+		write := "-"
+		if r.Write >= 0 {
+			write = r.Write.String()
+		}
+		method := r.Method
+		if method == "" {
+			method = "-"
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", r.Seq, r.Stream, method, r.Queue, write)
+	}
+	_ = tw.Flush()
+}
+
+// MethodStats is statistics of single method sent by one side
+type MethodStats struct {
+	Method    string
+	From      StreamType // STDIN if sent by client, STDOUT if sent by server
+	Count     int
+	Errors    int             // error responses
+	Pending   int             // requests not answered until end of log
+	Bytes     int             // total message size of requests (notifications), partial results and responses
+	Chunks    int             // partial results ($/progress notifications linked by partialResultToken)
+	Latencies []time.Duration // latencies of answered requests (until response or last partial result)
+	Sizes     []int           // sizes of requests (notifications)
+	Responses []int           // sizes of responses (partial results are excluded)
+}
+
+// MessageSize is size of single message (element of batch) for report of largest messages
+type MessageSize struct {
+	Seq    int
+	From   StreamType
+	Method string // method of request if message is response or partial result (empty if unmatched response)
+	Kind   string // request, response, notification or partial
+	Size   int
+}
+
+// DefaultTopMessages is default number of largest messages reported by MessageStats
+const DefaultTopMessages = 10
+
+// UnansweredRequest is request not answered until end of log
+type UnansweredRequest struct {
+	Method    string
+	From      StreamType
+	Id        json.RawMessage
+	Timestamp time.Time
+}
+
+// requestCancelledCode is error code of response to cancelled request (RequestCancelled)
+const requestCancelledCode = -32800
+
+// CancelStats classifies requests of method targeted by $/cancelRequest
+type CancelStats struct {
+	Method     string
+	From       StreamType      // sender of request (and cancel)
+	Before     int             // answered before cancel arrived
+	Cancelled  int             // answered with RequestCancelled error after cancel
+	Ignored    int             // answered with result (or other error) after cancel
+	Unanswered int             // not answered until end of log
+	Latencies  []time.Duration // from cancel to response (Cancelled and Ignored)
+}
+
+// formatStatsHeader writes session metadata and exit of server as report header (nothing if both are nil)
+func formatStatsHeader(writer io.Writer, meta *SessionMeta, exit *ServerExit) {
+	if meta == nil && exit == nil {
+		return
+	}
+	_, _ = fmt.Fprintln(writer, "session:")
+	if meta != nil {
+		meta.Format(writer, "  ")
+	}
+	if exit != nil {
+		exit.Format(writer, "  ")
+	}
+	_, _ = fmt.Fprintln(writer)
+}
+
+// MessageStats is per-method statistics of requests and notifications
+type MessageStats struct {
+	Meta          *SessionMeta // metadata of session (nil if log has no META record)
+	Exit          *ServerExit  // exit of server of the last session (nil if not recorded in trailer)
+	Requests      []*MethodStats
+	Notifications []*MethodStats        // partial results are attributed to requests
+	Unmatched     int                   // responses without corresponding request
+	Unanswered    []*UnansweredRequest  // requests not answered until end of log (in order of request)
+	Batches       int                   // JSON-RPC batch records (their elements are counted as messages)
+	ClientBytes   int                   // total size of messages sent by client (including invalid ones)
+	ServerBytes   int                   // total size of messages sent by server (including invalid ones)
+	ClientRecords int                   // messages sent by client (including invalid ones, batch is one record)
+	ServerRecords int                   // messages sent by server (including invalid ones, batch is one record)
+	Violations    []ProtocolViolation   // see CheckProtocol
+	Cancels       []*CancelStats        // requests targeted by $/cancelRequest
+	PeakRss       *ResourceSample       // sample of peak RSS of Language Server (nil if not sampled)
+	PeakRssTime   time.Time             // time of PeakRss
+	Samples       int                   // number of resource samples (see RunOptions.SampleResources)
+	Servers       []*ServerSessionStats // lifetimes of server restarted on exit (empty if not restarted)
+	Messages      []MessageSize         // sizes of messages in log order
+	Top           int                   // number of largest messages reported by Format and Document
+}
+
+// Largest returns n largest messages (earlier one first if sizes are the same)
+func (s *MessageStats) Largest(n int) []MessageSize {
+	largest := append([]MessageSize(nil), s.Messages...)
+	sort.SliceStable(largest, func(i, j int) bool { return largest[i].Size > largest[j].Size })
+	if len(largest) > n {
+		largest = largest[:n]
+	}
+	return largest
+}
+
+// ServerSessionStats is totals of single lifetime of server restarted on exit (see RunOptions.MaxRestarts)
+type ServerSessionStats struct {
+	Id            int
+	Start         time.Time   // first record of server session
+	End           time.Time   // last record of server session
+	Requests      int         // requests sent by either side
+	Errors        int         // error responses
+	ClientRecords int         // messages sent by client (including invalid ones, batch is one record)
+	ServerRecords int         // messages sent by server (including invalid ones, batch is one record)
+	Exit          *ServerExit // nil if server has not exited (or trailer is not recorded)
+}
+
+// server returns stats of server session of record (nil if server is not restarted on exit)
+func (s *MessageStats) server(d *LogData) *ServerSessionStats {
+	if d.serverSession == 0 {
+		return nil
+	}
+	n := len(s.Servers)
+	if n == 0 || d.serverSession > s.Servers[n-1].Id {
+		s.Servers = append(s.Servers, &ServerSessionStats{Id: d.serverSession, Start: d.timestamp})
+		n++
+	}
+	server := s.Servers[n-1]
+	server.End = d.timestamp
+	return server
+}
+
+// CollectMessageStats reads log and pairs requests with responses by id
+func CollectMessageStats(reader io.Reader) (*MessageStats, error) {
+	stats := &MessageStats{Top: DefaultTopMessages}
+	requests := map[string]*MethodStats{}
+	notifications := map[string]*MethodStats{}
+	lookup := func(m map[string]*MethodStats, list *[]*MethodStats, method string, from StreamType) *MethodStats {
+		key := fmt.Sprintf("%s:%s", from, method)
+		s, ok := m[key]
+		if !ok {
+			s = &MethodStats{Method: method, From: from}
+			m[key] = s
+			*list = append(*list, s)
+		}
+		return s
+	}
+	tracker := NewRequestTracker()
+	checker := newProtocolChecker()
+	cancels := map[string]*CancelStats{}
+	lookupCancel := func(method string, from StreamType) *CancelStats {
+		key := fmt.Sprintf("%s:%s", from, method)
+		s, ok := cancels[key]
+		if !ok {
+			s = &CancelStats{Method: method, From: from}
+			cancels[key] = s
+			stats.Cancels = append(stats.Cancels, s)
+		}
+		return s
+	}
+	answered := map[string]string{} // method of answered request by requestKey (for cancel arriving late)
+
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.payloadType == META {
+			stats.Meta = mergeSessionMeta(stats.Meta, d.payload)
+		}
+		var server *ServerSessionStats
+		if summary := parseTrailerSummary(d); summary != nil {
+			if summary.Exit != nil {
+				stats.Exit = summary.Exit
+			}
+			for _, session := range summary.ServerSessions {
+				for _, server := range stats.Servers {
+					if server.Id == session.Id {
+						server.Exit = session.Exit
+					}
+				}
+			}
+		} else {
+			server = stats.server(d)
+		}
+		if d.payloadType == RESOURCES {
+			if sample, err := ParseResourceSample(d.payload); err == nil {
+				stats.Samples++
+				if stats.PeakRss == nil || sample.Rss > stats.PeakRss.Rss {
+					stats.PeakRss, stats.PeakRssTime = sample, d.timestamp
+				}
+			}
+		}
+		if d.payloadType == JSON || d.payloadType == INVALID || d.payloadType == INCOMPLETE || isRawChunk(d) {
+			switch d.streamType {
+			case STDIN:
+				stats.ClientBytes += d.messageSize()
+				if !isRawChunk(d) {
+					stats.ClientRecords++
+					if server != nil {
+						server.ClientRecords++
+					}
+				}
+			case STDOUT:
+				stats.ServerBytes += d.messageSize()
+				if !isRawChunk(d) {
+					stats.ServerRecords++
+					if server != nil {
+						server.ServerRecords++
+					}
+				}
+			}
+		}
+		if d.payloadType != JSON {
+			continue
+		}
+		if isBatch(d.payload) {
+			stats.Batches++
+		}
+		for _, d := range expandBatch(d) { // each element of batch is logical message
+			e, err := d.Envelope()
+			if err != nil {
+				continue
+			}
+			p := tracker.pair(d, e)
+			checker.observe(d, e)
+			size := d.messageSize()
+			addMessage := func(method string, kind string) {
+				stats.Messages = append(stats.Messages, MessageSize{Seq: d.seq, From: d.streamType, Method: method,
+					Kind: kind, Size: size})
+			}
+			switch {
+			case e.IsRequest():
+				delete(answered, pairKey(d, d.streamType, e.Id)) // id is reused
+				s := lookup(requests, &stats.Requests, e.Method, d.streamType)
+				s.Count++
+				s.Bytes += size
+				s.Sizes = append(s.Sizes, size)
+				addMessage(e.Method, "request")
+				if server != nil {
+					server.Requests++
+				}
+			case p.partial:
+				s := lookup(requests, &stats.Requests, p.request.method, p.request.stream)
+				s.Chunks++
+				s.Bytes += size
+				addMessage(p.request.method, "partial")
+			case e.IsNotification():
+				s := lookup(notifications, &stats.Notifications, e.Method, d.streamType)
+				s.Count++
+				s.Bytes += size
+				s.Sizes = append(s.Sizes, size)
+				addMessage(e.Method, "notification")
+				if e.Method == "$/cancelRequest" {
+					if id := cancelTarget(d.payload); id != nil {
+						key := pairKey(d, d.streamType, id)
+						if method, ok := answered[key]; ok {
+							lookupCancel(method, d.streamType).Before++
+							delete(answered, key) // count repeated cancel once
+						}
+					}
+				}
+			case e.IsResponse():
+				req := p.request
+				if req == nil {
+					stats.Unmatched++
+					addMessage("", "response")
+					continue
+				}
+				addMessage(req.method, "response")
+				answered[pairKey(d, req.stream, req.id)] = req.method
+				if req.cancelled {
+					c := lookupCancel(req.method, req.stream)
+					if responseErrorCode(e) == requestCancelledCode {
+						c.Cancelled++
+					} else {
+						c.Ignored++
+					}
+					c.Latencies = append(c.Latencies, d.recordTime().Sub(req.cancelAt))
+				}
+				s := lookup(requests, &stats.Requests, req.method, req.stream)
+				s.Bytes += size
+				s.Responses = append(s.Responses, size)
+				complete := d.recordTime()
+				if req.partials.Last.After(complete) {
+					complete = req.partials.Last
+				}
+				s.Latencies = append(s.Latencies, complete.Sub(req.at))
+				if e.Error != nil && !isNullOrEmpty(e.Error) {
+					s.Errors++
+					if server != nil {
+						server.Errors++
+					}
+				}
+			}
+		}
+	}
+	for _, req := range tracker.pending {
+		lookup(requests, &stats.Requests, req.method, req.stream).Pending++
+		stats.Unanswered = append(stats.Unanswered, &UnansweredRequest{Method: req.method, From: req.stream,
+			Id: req.id, Timestamp: req.timestamp})
+		if req.cancelled {
+			lookupCancel(req.method, req.stream).Unanswered++
+		}
+	}
+	stats.Violations = checker.finish()
+	sortMethodStats := func(list []*MethodStats) {
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].Method != list[j].Method {
+				return list[i].Method < list[j].Method
+			}
+			return list[i].From < list[j].From
+		})
+	}
+	sort.Slice(stats.Unanswered, func(i, j int) bool { // pending requests are not ordered
+		x, y := stats.Unanswered[i], stats.Unanswered[j]
+		if !x.Timestamp.Equal(y.Timestamp) {
+			return x.Timestamp.Before(y.Timestamp)
+		}
+		return requestKey(x.From, x.Id) < requestKey(y.From, y.Id)
+	})
+	sortMethodStats(stats.Requests)
+	sortMethodStats(stats.Notifications)
+	sort.SliceStable(stats.Cancels, func(i, j int) bool {
+		if stats.Cancels[i].Method != stats.Cancels[j].Method {
+			return stats.Cancels[i].Method < stats.Cancels[j].Method
+		}
+		return stats.Cancels[i].From < stats.Cancels[j].From
+	})
+	return stats, nil
+}
+
+// formatSizeTable writes size distribution of methods (methods without messages are omitted)
+func formatSizeTable(writer io.Writer, title string, list []*MethodStats, sizes func(m *MethodStats) []int) {
+	_, _ = fmt.Fprintf(writer, "\n%s:\n", title)
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "method\tfrom\tcount\tmin\tp50\tp99\tmax\ttotal")
+	for _, m := range list {
+		if size := summarizeSizes(sizes(m)); size != nil {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", m.Method, senderOf(m.From), len(sizes(m)),
+				formatSize(size.Min), formatSize(size.P50), formatSize(size.P99), formatSize(size.Max),
+				formatSize(size.Total))
+		}
+	}
+	_ = tw.Flush()
+}
+
+// formatSizes writes size distributions of requests, responses and notifications, and largest messages
+func (s *MessageStats) formatSizes(writer io.Writer) {
+	formatSizeTable(writer, "request sizes", s.Requests, func(m *MethodStats) []int { return m.Sizes })
+	formatSizeTable(writer, "response sizes", s.Requests, func(m *MethodStats) []int { return m.Responses })
+	formatSizeTable(writer, "notification sizes", s.Notifications, func(m *MethodStats) []int { return m.Sizes })
+	largest := s.Largest(s.Top)
+	if len(largest) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(writer, "\nlargest %d messages:\n", len(largest))
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "seq\tfrom\tmethod\tkind\tsize")
+	for _, m := range largest {
+		method := m.Method
+		if method == "" {
+			method = "-"
+		}
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", m.Seq, senderOf(m.From), method, m.Kind, formatSize(m.Size))
+	}
+	_ = tw.Flush()
+}
+
+func senderOf(t StreamType) string {
+	if t == STDIN {
+		return "client"
+	}
+	return "server"
+}
+
+// Format writes tables of requests and notifications
+func (s *MessageStats) Format(writer io.Writer) {
+	formatStatsHeader(writer, s.Meta, s.Exit)
+	_, _ = fmt.Fprintln(writer, "requests:")
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "method\tfrom\tcount\terrors\tpending\tbytes\tchunks\tp50\tp90\tp99\tmax")
+	for _, m := range s.Requests {
+		sorted := sortDurations(m.Latencies)
+		latencies := []string{"-", "-", "-", "-"}
+		if len(sorted) > 0 {
+			for i, p := range []float64{50, 90, 99, 100} {
+				latencies[i] = percentile(sorted, p).String()
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", m.Method, senderOf(m.From), m.Count,
+			m.Errors, m.Pending, m.Bytes, m.Chunks, latencies[0], latencies[1], latencies[2], latencies[3])
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintln(writer, "\nnotifications:")
+	tw = tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "method\tfrom\tcount\tbytes")
+	for _, m := range s.Notifications {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", m.Method, senderOf(m.From), m.Count, m.Bytes)
+	}
+	_ = tw.Flush()
+	s.formatSizes(writer)
+	if len(s.Cancels) > 0 {
+		_, _ = fmt.Fprintln(writer, "\ncancellations (latency from cancel to response):")
+		tw = tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "method\tfrom\tanswered-before\tcancelled\tignored\tunanswered\tp50\tmax")
+		for _, c := range s.Cancels {
+			sorted := sortDurations(c.Latencies)
+			p50, maximum := "-", "-"
+			if len(sorted) > 0 {
+				p50, maximum = percentile(sorted, 50).String(), percentile(sorted, 100).String()
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n", c.Method, senderOf(c.From), c.Before,
+				c.Cancelled, c.Ignored, c.Unanswered, p50, maximum)
+		}
+		_ = tw.Flush()
+	}
+	if len(s.Servers) > 0 {
+		_, _ = fmt.Fprintln(writer, "\nserver sessions:")
+		tw = tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "server\tduration\tclient\tserver\trequests\terrors\texit")
+		for _, server := range s.Servers {
+			exit := "-"
+			if server.Exit != nil {
+				exit = strconv.Itoa(server.Exit.Code)
+				if server.Exit.Signal != "" {
+					exit += " " + server.Exit.Signal
+				}
+			}
+			_, _ = fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%d\t%s\n", server.Id, server.End.Sub(server.Start),
+				server.ClientRecords, server.ServerRecords, server.Requests, server.Errors, exit)
+		}
+		_ = tw.Flush()
+	}
+	_, _ = fmt.Fprintf(writer, "\nbytes: client %d (%s), server %d (%s)\n", s.ClientBytes, formatSize(s.ClientBytes),
+		s.ServerBytes, formatSize(s.ServerBytes))
+	if s.PeakRss != nil {
+		_, _ = fmt.Fprintf(writer, "peak rss: %s at %s (%d samples)\n", formatSize(int(s.PeakRss.Rss)),
+			s.PeakRssTime.Format(time.RFC3339Nano), s.Samples)
+	}
+	if s.Batches > 0 {
+		_, _ = fmt.Fprintf(writer, "batches: %d\n", s.Batches)
+	}
+	if s.Unmatched > 0 {
+		_, _ = fmt.Fprintf(writer, "\n%d responses without corresponding request\n", s.Unmatched)
+	}
+	if len(s.Violations) > 0 {
+		_, _ = fmt.Fprintf(writer, "\nwarning: %d protocol violations (%s), see check subcommand\n",
+			len(s.Violations), countViolations(s.Violations))
+	}
+}
@@ -0,0 +1,257 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	assert.Equal(t, time.Duration(50), percentile(sorted, 50))
+	assert.Equal(t, time.Duration(99), percentile(sorted, 99))
+	assert.Equal(t, time.Duration(100), percentile(sorted, 100))
+	assert.Equal(t, time.Duration(1), percentile(sorted, 0))
+	assert.Equal(t, time.Duration(0), percentile([]time.Duration(nil), 50))
+}
+
+func TestPipelineStats(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("legacy"), queueTime: 0},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`),
+			queueTime: 3 * time.Millisecond},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`),
+			queueTime: time.Millisecond, prevWriteTime: 5 * time.Millisecond},
+		LogData{streamType: STDOUT, payloadType: RAW_END, payload: []byte("end of stream"),
+			queueTime: 2 * time.Millisecond, prevWriteTime: time.Millisecond},
+	)
+	stats, err := CollectPipelineStats(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, []PipelineRecord{
+		{Seq: 2, Stream: STDIN, Method: "initialize", Queue: 3 * time.Millisecond, Write: 5 * time.Millisecond},
+		{Seq: 3, Stream: STDOUT, Queue: time.Millisecond, Write: time.Millisecond},
+		{Seq: 4, Stream: STDOUT, Queue: 2 * time.Millisecond, Write: -1},
+	}, stats.Records)
+
+	worst := stats.Worst(2)
+	require.Len(t, worst, 2)
+	assert.Equal(t, 2, worst[0].Seq)
+	assert.Equal(t, 3, worst[1].Seq) // last record (write is unknown) is 2ms
+
+	out := bytes.Buffer{}
+	stats.Format(&out, 3)
+	assert.Contains(t, out.String(), "queue      3")
+	assert.Contains(t, out.String(), "worst 3 records:")
+	assert.Contains(t, out.String(), "initialize")
+}
+
+func TestMessageStats(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"workspace/configuration"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"error":{"code":-32603,"message":"x"}}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":[]}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage"}`)},
+		LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte(`{"id":`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"result":null}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`)},
+	)
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	require.Len(t, stats.Requests, 3)
+
+	assert.Equal(t, "shutdown", stats.Requests[0].Method)
+	assert.Equal(t, 1, stats.Requests[0].Pending)
+	assert.Empty(t, stats.Requests[0].Latencies)
+
+	hover := stats.Requests[1]
+	assert.Equal(t, "textDocument/hover", hover.Method)
+	assert.Equal(t, STDIN, hover.From)
+	assert.Equal(t, 2, hover.Count)
+	assert.Equal(t, 1, hover.Errors)
+	assert.Equal(t, 1, hover.Pending) // id 1 is not answered (response of id 1 in stdin is for server request)
+	assert.Equal(t, []time.Duration{2 * time.Second}, hover.Latencies)
+
+	config := stats.Requests[2]
+	assert.Equal(t, "workspace/configuration", config.Method)
+	assert.Equal(t, STDOUT, config.From)
+	assert.Equal(t, []time.Duration{2 * time.Second}, config.Latencies)
+
+	require.Len(t, stats.Notifications, 1)
+	assert.Equal(t, 1, stats.Notifications[0].Count)
+	assert.Equal(t, 1, stats.Unmatched)
+
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.Contains(t, out.String(), "textDocument/hover       client  2      1       1")
+	assert.Contains(t, out.String(), "1 responses without corresponding request")
+}
+
+func TestMessageStatsPartialResults(t *testing.T) {
+	stats, err := CollectMessageStats(strings.NewReader(partialResultTestLog))
+	require.NoError(t, err)
+	require.Len(t, stats.Requests, 1)
+	symbol := stats.Requests[0]
+	assert.Equal(t, 2, symbol.Chunks)
+	assert.Equal(t, []time.Duration{2 * time.Second}, symbol.Latencies)
+	assert.Equal(t, len(`{"jsonrpc":"2.0","id":5,"method":"workspace/symbol","params":{"query":"","workDoneToken":"w","partialResultToken":"p"}}`)+
+		2*85+len(`{"jsonrpc":"2.0","id":5,"result":[]}`), symbol.Bytes)
+
+	// progress of work done and after response are not partial results
+	require.Len(t, stats.Notifications, 1)
+	assert.Equal(t, "$/progress", stats.Notifications[0].Method)
+	assert.Equal(t, 2, stats.Notifications[0].Count)
+}
+
+func TestMessageStatsBytes(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"textDocument/didOpen"}`),
+			size: 2048}, // redacted
+		LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte(`{"id":`)},
+		LogData{streamType: STDERR, payloadType: RAW, payload: []byte("not counted")},
+	)
+	log += `{"time":"2024-05-01T10:00:03Z","level":"INFO","seq":4,"stream":"stdout","type":"json","payload":"{\"jsonrpc\":\"2.0\",\"method\":\"x\"}"}` + "\n" // old log without size
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, 2048, stats.ClientBytes)
+	assert.Equal(t, 6+30, stats.ServerBytes)
+	assert.Equal(t, 2048, stats.Notifications[0].Bytes)
+
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.Contains(t, out.String(), "\nbytes: client 2048 (2.0KB), server 36 (36B)\n")
+}
+
+func TestMessageStatsSizes(t *testing.T) {
+	tokens := func(id int, size int) LogData {
+		return LogData{streamType: STDOUT, payloadType: JSON, size: size,
+			payload: []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":{"data":[]}}`, id))}
+	}
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/semanticTokens/full"}`)},
+		tokens(1, 4*1024*1024), // size of redacted payload is recorded
+		LogData{streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/semanticTokens/full"}`)},
+		tokens(2, 2*1024*1024),
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage"}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`)},
+	)
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	require.Len(t, stats.Requests, 2)
+	tokensStats := stats.Requests[1]
+	assert.Equal(t, []int{68, 68}, tokensStats.Sizes)
+	assert.Equal(t, []int{4 * 1024 * 1024, 2 * 1024 * 1024}, tokensStats.Responses)
+	assert.Equal(t, []int{46}, stats.Notifications[0].Sizes)
+	assert.Empty(t, stats.Requests[0].Responses) // pending
+
+	stats.Top = 2
+	assert.Equal(t, []MessageSize{
+		{Seq: 2, From: STDOUT, Method: "textDocument/semanticTokens/full", Kind: "response", Size: 4 * 1024 * 1024},
+		{Seq: 4, From: STDOUT, Method: "textDocument/semanticTokens/full", Kind: "response", Size: 2 * 1024 * 1024},
+	}, stats.Largest(stats.Top))
+
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.Regexp(t, `\nrequest sizes:\nmethod +from +count +min +p50 +p99 +max +total\n`+
+		`shutdown +client +1 +44B +44B +44B +44B +44B\n`+
+		`textDocument/semanticTokens/full +client +2 +68B +68B +68B +68B +136B\n`, out.String())
+	assert.Regexp(t, `\nresponse sizes:\n.+\ntextDocument/semanticTokens/full +client +2 +2\.0MB +2\.0MB +4\.0MB +4\.0MB +6\.0MB\n\n`,
+		out.String()) // pending request is omitted
+	assert.Regexp(t, `\nnotification sizes:\n.+\nwindow/logMessage +server +1 +46B`, out.String())
+	assert.Regexp(t, `\nlargest 2 messages:\nseq +from +method +kind +size\n`+
+		`2 +server +textDocument/semanticTokens/full +response +4\.0MB\n4 +server`, out.String())
+}
+
+func TestMessageStatsCancels(t *testing.T) {
+	hover := func(id string) LogData {
+		return LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":` + id + `,"method":"textDocument/hover"}`)}
+	}
+	cancel := func(id string) LogData {
+		return LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":` + id + `}}`)}
+	}
+	response := func(id string, body string) LogData {
+		return LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":` + id + `,` + body + `}`)}
+	}
+	log := newTestLog(
+		hover(`1`), response(`1`, `"result":null`), cancel(`1`), // answered before cancel
+		hover(`"a"`), cancel(`"a"`), cancel(`"a"`), response(`"a"`, `"error":{"code":-32800,"message":"cancelled"}`),
+		hover(`3`), cancel(`3`), response(`3`, `"result":{"contents":"x"}`), // ignored
+		hover(`"4"`), cancel(`"4"`), // never answered
+		cancel(`1`), // already counted
+	)
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	require.Len(t, stats.Cancels, 1)
+	c := stats.Cancels[0]
+	assert.Equal(t, "textDocument/hover", c.Method)
+	assert.Equal(t, []int{1, 1, 1, 1}, []int{c.Before, c.Cancelled, c.Ignored, c.Unanswered})
+	assert.Equal(t, []time.Duration{2 * time.Second, time.Second}, c.Latencies)
+
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.Contains(t, out.String(), "\ncancellations (latency from cancel to response):\n")
+	assert.Regexp(t, `textDocument/hover +client +1 +1 +1 +1 +1s +2s\n`, out.String())
+}
+
+func TestMessageStatsElapsed(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	log := newTestLog(
+		LogData{timestamp: base, elapsed: time.Second, streamType: STDIN, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`)},
+		LogData{timestamp: base.Add(-time.Minute), elapsed: 3 * time.Second, streamType: STDOUT, payloadType: JSON,
+			payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)}, // clock is set back
+	)
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	require.Len(t, stats.Requests, 1)
+	assert.Equal(t, []time.Duration{2 * time.Second}, stats.Requests[0].Latencies)
+}
+
+func TestMessageStatsBatch(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`[{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"},` +
+			`{"jsonrpc":"2.0","method":"textDocument/didSave"}]`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`[{"jsonrpc":"2.0","id":1,"result":null}]`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`[]`)},
+	)
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Batches)
+	require.Len(t, stats.Requests, 1)
+	assert.Equal(t, "textDocument/hover", stats.Requests[0].Method)
+	assert.Equal(t, []time.Duration{time.Second}, stats.Requests[0].Latencies)
+	require.Len(t, stats.Notifications, 1)
+	assert.Equal(t, "textDocument/didSave", stats.Notifications[0].Method)
+	assert.Equal(t, 0, stats.Unmatched)
+
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.Contains(t, out.String(), "\nbatches: 3\n")
+}
+
+func TestMessageStatsServerExit(t *testing.T) {
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{streamType: STDERR, payloadType: TRAILER,
+			payload: []byte(`{"summary":{"exit":{"code":-1,"signal":"SIGSEGV","uptime_ns":2000000000,"stderr_tail":["panic"]}}}`)},
+	)
+	stats, err := CollectMessageStats(strings.NewReader(log))
+	require.NoError(t, err)
+	require.NotNil(t, stats.Exit)
+	assert.Equal(t, "SIGSEGV", stats.Exit.Signal)
+
+	out := bytes.Buffer{}
+	stats.Format(&out)
+	assert.True(t, strings.HasPrefix(out.String(), "session:\n  exit:     -1 after 2s, SIGSEGV\n  stderr tail:\n    panic\n\n"),
+		out.String())
+}
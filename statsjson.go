@@ -0,0 +1,151 @@
+package recorder
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"time"
+)
+
+// StatsDocument is machine-readable form of MessageStats (stats --output json). Field names are stable, durations
+// are in nanoseconds and sizes are in bytes
+type StatsDocument struct {
+	Session       *SessionMeta              `json:"session"` // null if log has no META record
+	Exit          *ServerExit               `json:"exit"`    // null if not recorded in trailer
+	Requests      []StatsMethod             `json:"requests"`
+	Notifications []StatsMethod             `json:"notifications"`
+	Directions    map[string]StatsDirection `json:"directions"` // totals of messages sent by client and server
+	Unanswered    []StatsUnanswered         `json:"unanswered"`
+	Unmatched     int                       `json:"unmatched"`                 // responses without corresponding request
+	Servers       []StatsServerSession      `json:"server_sessions,omitempty"` // lifetimes of server restarted on exit
+	Largest       []StatsMessage            `json:"largest"`                   // largest messages (see MessageStats.Top)
+}
+
+// StatsMessage is single message of largest messages
+type StatsMessage struct {
+	Seq    int    `json:"seq"`
+	From   string `json:"from"`
+	Method string `json:"method"` // empty if response without corresponding request
+	Kind   string `json:"kind"`   // request, response, notification or partial
+	Size   int    `json:"size"`
+}
+
+// StatsServerSession is totals of lifetime of server restarted on exit
+type StatsServerSession struct {
+	Id       int           `json:"id"`
+	Duration time.Duration `json:"duration_ns"` // from first to last record of server session
+	Client   int           `json:"client_messages"`
+	Server   int           `json:"server_messages"`
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	Exit     *ServerExit   `json:"exit"` // null if server has not exited
+}
+
+// StatsMethod is aggregate of method in StatsDocument
+type StatsMethod struct {
+	Method  string        `json:"method"`
+	From    string        `json:"from"` // client or server
+	Count   int           `json:"count"`
+	Errors  int           `json:"errors"`
+	Pending int           `json:"pending"`
+	Bytes   int           `json:"bytes"`
+	Chunks  int           `json:"chunks"`
+	Latency *StatsLatency `json:"latency"` // null if no request is answered (or notification)
+
+	Size         *StatsSize `json:"size"`          // sizes of requests (notifications)
+	ResponseSize *StatsSize `json:"response_size"` // null if no request is answered (or notification)
+}
+
+// StatsSize is size distribution of messages
+type StatsSize struct {
+	Min   int `json:"min"`
+	P50   int `json:"p50"`
+	P99   int `json:"p99"`
+	Max   int `json:"max"`
+	Total int `json:"total"`
+}
+
+// summarizeSizes returns distribution of sizes (nil if empty)
+func summarizeSizes(sizes []int) *StatsSize {
+	if len(sizes) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(sizes)
+	slices.Sort(sorted)
+	size := &StatsSize{Min: sorted[0], P50: percentile(sorted, 50), P99: percentile(sorted, 99),
+		Max: sorted[len(sorted)-1]}
+	for _, n := range sorted {
+		size.Total += n
+	}
+	return size
+}
+
+// StatsLatency is latency distribution of answered requests
+type StatsLatency struct {
+	Min time.Duration `json:"min_ns"`
+	P50 time.Duration `json:"p50_ns"`
+	P90 time.Duration `json:"p90_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	Max time.Duration `json:"max_ns"`
+}
+
+// StatsDirection is totals of messages sent by one side
+type StatsDirection struct {
+	Messages int `json:"messages"` // including invalid ones (batch is one message)
+	Bytes    int `json:"bytes"`
+}
+
+// StatsUnanswered is request not answered until end of log
+type StatsUnanswered struct {
+	Method    string          `json:"method"`
+	From      string          `json:"from"`
+	Id        json.RawMessage `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+func toStatsMethods(list []*MethodStats) []StatsMethod {
+	methods := make([]StatsMethod, 0, len(list))
+	for _, m := range list {
+		method := StatsMethod{Method: m.Method, From: senderOf(m.From), Count: m.Count, Errors: m.Errors,
+			Pending: m.Pending, Bytes: m.Bytes, Chunks: m.Chunks, Size: summarizeSizes(m.Sizes),
+			ResponseSize: summarizeSizes(m.Responses)}
+		if sorted := sortDurations(m.Latencies); len(sorted) > 0 {
+			method.Latency = &StatsLatency{Min: sorted[0], P50: percentile(sorted, 50), P90: percentile(sorted, 90),
+				P99: percentile(sorted, 99), Max: sorted[len(sorted)-1]}
+		}
+		methods = append(methods, method)
+	}
+	return methods
+}
+
+// Document converts stats into StatsDocument
+func (s *MessageStats) Document() *StatsDocument {
+	doc := &StatsDocument{Session: s.Meta, Exit: s.Exit, Requests: toStatsMethods(s.Requests),
+		Notifications: toStatsMethods(s.Notifications), Unanswered: []StatsUnanswered{}, Unmatched: s.Unmatched,
+		Largest: []StatsMessage{},
+		Directions: map[string]StatsDirection{
+			"client": {Messages: s.ClientRecords, Bytes: s.ClientBytes},
+			"server": {Messages: s.ServerRecords, Bytes: s.ServerBytes},
+		}}
+	for _, server := range s.Servers {
+		doc.Servers = append(doc.Servers, StatsServerSession{Id: server.Id, Duration: server.End.Sub(server.Start),
+			Client: server.ClientRecords, Server: server.ServerRecords, Requests: server.Requests, Errors: server.Errors,
+			Exit: server.Exit})
+	}
+	for _, m := range s.Largest(s.Top) {
+		doc.Largest = append(doc.Largest, StatsMessage{Seq: m.Seq, From: senderOf(m.From), Method: m.Method,
+			Kind: m.Kind, Size: m.Size})
+	}
+	for _, req := range s.Unanswered {
+		doc.Unanswered = append(doc.Unanswered, StatsUnanswered{Method: req.Method, From: senderOf(req.From),
+			Id: req.Id, Timestamp: req.Timestamp})
+	}
+	return doc
+}
+
+// FormatJSON writes stats as indented StatsDocument
+func (s *MessageStats) FormatJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s.Document())
+}
@@ -0,0 +1,76 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var statsJSONTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: META, payload: metaTestPayload},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover"}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"error":{"code":-32603,"message":"boom"}}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":"x","method":"shutdown"}`)},
+	LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte("invalid message header: 'hoge'")},
+)
+
+func TestMessageStatsJSON(t *testing.T) {
+	stats, err := CollectMessageStats(strings.NewReader(statsJSONTestLog))
+	require.NoError(t, err)
+	doc := stats.Document()
+	require.NotNil(t, doc.Session)
+	assert.Equal(t, 4321, doc.Session.Pid)
+	assert.Equal(t, []StatsMethod{
+		{Method: "shutdown", From: "client", Count: 1, Pending: 1, Bytes: 46,
+			Size: &StatsSize{Min: 46, P50: 46, P99: 46, Max: 46, Total: 46}},
+		{Method: "textDocument/hover", From: "client", Count: 2, Errors: 1, Bytes: 211,
+			Latency: &StatsLatency{Min: 2 * time.Second, P50: 2 * time.Second, P90: 2 * time.Second,
+				P99: 2 * time.Second, Max: 2 * time.Second},
+			Size:         &StatsSize{Min: 54, P50: 54, P99: 54, Max: 54, Total: 108},
+			ResponseSize: &StatsSize{Min: 38, P50: 38, P99: 65, Max: 65, Total: 103}},
+	}, doc.Requests)
+	assert.Equal(t, []StatsMethod{{Method: "window/logMessage", From: "server", Count: 1, Bytes: 58,
+		Size: &StatsSize{Min: 58, P50: 58, P99: 58, Max: 58, Total: 58}}}, doc.Notifications)
+	assert.Equal(t, map[string]StatsDirection{"client": {Messages: 3, Bytes: 154}, "server": {Messages: 4, Bytes: 191}},
+		doc.Directions)
+	assert.Equal(t, []StatsUnanswered{{Method: "shutdown", From: "client", Id: json.RawMessage(`"x"`),
+		Timestamp: time.Date(2024, 5, 1, 10, 0, 6, 0, time.UTC)}}, doc.Unanswered)
+	assert.Equal(t, []StatsMessage{
+		{Seq: 5, From: "server", Method: "textDocument/hover", Kind: "response", Size: 65},
+		{Seq: 6, From: "server", Method: "window/logMessage", Kind: "notification", Size: 58},
+		{Seq: 2, From: "client", Method: "textDocument/hover", Kind: "request", Size: 54},
+	}, doc.Largest[:3])
+
+	out := bytes.Buffer{}
+	require.NoError(t, stats.FormatJSON(&out))
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &m))
+	assert.ElementsMatch(t, []string{"session", "exit", "requests", "notifications", "directions", "unanswered",
+		"unmatched", "largest"}, keysOf(m))
+	assert.Contains(t, out.String(), `"p99_ns": 2000000000`)
+	assert.Contains(t, out.String(), `"exit": null`)
+
+	// log without metadata or unanswered requests
+	stats, err = CollectMessageStats(strings.NewReader(printTestLog))
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, stats.FormatJSON(&out))
+	assert.Contains(t, out.String(), `"session": null`)
+	assert.Contains(t, out.String(), `"unanswered": []`)
+	assert.Contains(t, out.String(), `"notifications": []`)
+}
+
+func keysOf(m map[string]any) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,174 @@
+package recorder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SuppressRule matches server->client notifications by method name glob (see MatchMethod).
+// If Limit is positive, only notifications exceeding Limit per Per are matched
+type SuppressRule struct {
+	Pattern string
+	Limit   int
+	Per     time.Duration
+
+	windowStart time.Time
+	windowCount int
+	suppressed  int
+}
+
+// ParseSuppressRule parses rule like "window/logMessage" or "window/logMessage>100/s"
+func ParseSuppressRule(s string) (*SuppressRule, error) {
+	pattern, rate, hasRate := strings.Cut(s, ">")
+	if pattern == "" {
+		return nil, fmt.Errorf("empty method pattern: '%s'", s)
+	}
+	if err := ValidateGlob(pattern); err != nil {
+		return nil, err
+	}
+	rule := &SuppressRule{Pattern: pattern}
+	if !hasRate {
+		return rule, nil
+	}
+	count, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return nil, fmt.Errorf("rate must be <count>/<unit> (e.g. 100/s): '%s'", rate)
+	}
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("rate count must be positive integer: '%s'", count)
+	}
+	if unit != "" && (unit[0] < '0' || unit[0] > '9') {
+		unit = "1" + unit // allow '100/s' as '100/1s'
+	}
+	per, err := time.ParseDuration(unit)
+	if err != nil || per <= 0 {
+		return nil, fmt.Errorf("invalid rate unit: '%s'", unit)
+	}
+	rule.Limit = limit
+	rule.Per = per
+	return rule, nil
+}
+
+func (r *SuppressRule) String() string {
+	if r.Limit > 0 {
+		return fmt.Sprintf("%s>%d/%s", r.Pattern, r.Limit, r.Per)
+	}
+	return r.Pattern
+}
+
+// exceed counts the notification and reports whether it exceeds the rate limit
+func (r *SuppressRule) exceed(now time.Time) bool {
+	if r.Limit <= 0 {
+		return true
+	}
+	if now.Sub(r.windowStart) >= r.Per {
+		r.windowStart = now
+		r.windowCount = 0
+	}
+	r.windowCount++
+	return r.windowCount > r.Limit
+}
+
+// ClientFilter drops matched server->client notifications from pass-through (they are still recorded).
+// Requests and responses are never dropped
+type ClientFilter struct {
+	mutex      sync.Mutex
+	rules      []*SuppressRule
+	lastReport time.Time
+	reported   int
+}
+
+func NewClientFilter(specs []string) (*ClientFilter, error) {
+	filter := &ClientFilter{}
+	for _, spec := range specs {
+		rule, err := ParseSuppressRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		filter.rules = append(filter.rules, rule)
+	}
+	return filter, nil
+}
+
+// Suppress reports whether the message should not be passed to the client.
+// The first rule matching the notification method decides
+func (f *ClientFilter) Suppress(payload []byte, now time.Time) bool {
+	if f == nil || len(f.rules) == 0 {
+		return false
+	}
+	e, err := ParseEnvelope(payload)
+	if err != nil || !e.IsNotification() {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, rule := range f.rules {
+		if matchGlob(rule.Pattern, e.Method) {
+			if rule.exceed(now) {
+				rule.suppressed++
+				return true
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// Suppressed returns the total number of suppressed notifications
+func (f *ClientFilter) Suppressed() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.total()
+}
+
+func (f *ClientFilter) total() int {
+	n := 0
+	for _, rule := range f.rules {
+		n += rule.suppressed
+	}
+	return n
+}
+
+// Report returns progress of suppression if there are newly suppressed notifications
+// and interval has passed since the last report
+func (f *ClientFilter) Report(now time.Time, interval time.Duration) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n := f.total()
+	if n == f.reported || now.Sub(f.lastReport) < interval {
+		return "", false
+	}
+	f.lastReport = now
+	f.reported = n
+	return fmt.Sprintf("suppressed to client: %s", f.summary()), true
+}
+
+// Counts returns suppressed counts of each rule
+func (f *ClientFilter) Counts() map[string]int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	counts := map[string]int{}
+	for _, rule := range f.rules {
+		counts[rule.String()] = rule.suppressed
+	}
+	return counts
+}
+
+// Summary returns suppressed counts of each rule (like "window/logMessage=12, $/progress>10/1s=3")
+func (f *ClientFilter) Summary() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.summary()
+}
+
+func (f *ClientFilter) summary() string {
+	var counts []string
+	for _, rule := range f.rules {
+		counts = append(counts, fmt.Sprintf("%s=%d", rule, rule.suppressed))
+	}
+	return strings.Join(counts, ", ")
+}
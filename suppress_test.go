@@ -0,0 +1,81 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseSuppressRule(t *testing.T) {
+	rule, err := ParseSuppressRule("window/logMessage")
+	require.NoError(t, err)
+	assert.Equal(t, "window/logMessage", rule.Pattern)
+	assert.Equal(t, 0, rule.Limit)
+
+	rule, err = ParseSuppressRule("window/*>100/s")
+	require.NoError(t, err)
+	assert.Equal(t, "window/*", rule.Pattern)
+	assert.Equal(t, 100, rule.Limit)
+	assert.Equal(t, time.Second, rule.Per)
+
+	rule, err = ParseSuppressRule("$/progress>5/500ms")
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, rule.Per)
+
+	for _, s := range []string{"", ">1/s", "a>1", "a>0/s", "a>x/s", "a>1/parsec", "a>1/0s", "window/[lo]*", "a\\*"} {
+		_, err = ParseSuppressRule(s)
+		assert.Error(t, err, "'%s'", s)
+	}
+}
+
+func TestClientFilterSuppress(t *testing.T) {
+	filter, err := NewClientFilter([]string{"window/logMessage", "$/*"})
+	require.NoError(t, err)
+	now := time.Now()
+	assert.True(t, filter.Suppress([]byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{}}`), now))
+	assert.True(t, filter.Suppress([]byte(`{"jsonrpc":"2.0","method":"$/progress","params":{}}`), now))
+	assert.False(t, filter.Suppress([]byte(`{"jsonrpc":"2.0","method":"window/showMessage","params":{}}`), now))
+
+	// requests and responses are never suppressed
+	assert.False(t, filter.Suppress([]byte(`{"jsonrpc":"2.0","id":1,"method":"window/logMessage"}`), now))
+	assert.False(t, filter.Suppress([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`), now))
+	assert.False(t, filter.Suppress([]byte(`{"method":`), now))
+
+	assert.Equal(t, 2, filter.Suppressed())
+	assert.Equal(t, "window/logMessage=1, $/*=1", filter.Summary())
+	assert.Equal(t, map[string]int{"window/logMessage": 1, "$/*": 1}, NewSession(filter).Trailer().SuppressedToClient)
+}
+
+func TestClientFilterRate(t *testing.T) {
+	filter, err := NewClientFilter([]string{"window/logMessage>2/s"})
+	require.NoError(t, err)
+	msg := []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{}}`)
+	now := time.Now()
+	assert.False(t, filter.Suppress(msg, now))
+	assert.False(t, filter.Suppress(msg, now.Add(100*time.Millisecond)))
+	assert.True(t, filter.Suppress(msg, now.Add(200*time.Millisecond)))
+	assert.True(t, filter.Suppress(msg, now.Add(900*time.Millisecond)))
+	assert.False(t, filter.Suppress(msg, now.Add(1100*time.Millisecond))) // next window
+	assert.Equal(t, 2, filter.Suppressed())
+	assert.Equal(t, "window/logMessage>2/1s=2", filter.Summary())
+}
+
+func TestClientFilterReport(t *testing.T) {
+	filter, err := NewClientFilter([]string{"window/logMessage"})
+	require.NoError(t, err)
+	msg := []byte(`{"jsonrpc":"2.0","method":"window/logMessage","params":{}}`)
+	now := time.Now()
+	_, ok := filter.Report(now, time.Second)
+	assert.False(t, ok) // nothing suppressed
+	filter.Suppress(msg, now)
+	report, ok := filter.Report(now, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "suppressed to client: window/logMessage=1", report)
+	filter.Suppress(msg, now)
+	_, ok = filter.Report(now.Add(500*time.Millisecond), time.Second)
+	assert.False(t, ok) // too early
+	report, ok = filter.Report(now.Add(time.Second), time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "suppressed to client: window/logMessage=2", report)
+}
@@ -0,0 +1,156 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+// LogDestination is path and options of recorded log
+type LogDestination struct {
+	Path    string
+	Options LogOptions
+	Writer  io.WriteCloser // written instead of file of Path if not nil (e.g. HTTPSink, Path is its name)
+}
+
+// teeQueueSize is number of records buffered for each destination of tee
+const teeQueueSize = 1024
+
+type teeEntry struct {
+	handler slog.Handler
+	record  slog.Record
+}
+
+// teeDestination writes queued records in its own goroutine, so slow destination does not block others
+type teeDestination struct {
+	path    string
+	closer  io.Closer
+	queue   chan teeEntry
+	done    chan struct{}
+	dropped atomic.Int64 // records dropped since queue is full
+
+	failed  int // records failed to write (accessed by writer goroutine until done)
+	lastErr error
+}
+
+func (d *teeDestination) run() {
+	defer close(d.done)
+	for e := range d.queue {
+		if err := e.handler.Handle(context.Background(), e.record); err != nil {
+			d.failed++
+			d.lastErr = err
+		}
+	}
+}
+
+// teeHandler fans out each record to all destinations without blocking caller.
+// If queue of destination is full, record is dropped for that destination. The first drop of each destination is
+// warned immediately, and the number of dropped records is reported at close
+type teeHandler struct {
+	destinations []*teeDestination
+	handlers     []slog.Handler // handler of each destination
+	warn         io.Writer      // nil if not warned (e.g. test)
+}
+
+// Enabled reports whether any destination is enabled at level
+func (h *teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	r = r.Clone() // shared by writer goroutines (read only)
+	for i, d := range h.destinations {
+		if !h.handlers[i].Enabled(ctx, r.Level) {
+			continue
+		}
+		select {
+		case d.queue <- teeEntry{handler: h.handlers[i], record: r}:
+		default:
+			if d.dropped.Add(1) == 1 && h.warn != nil {
+				_, _ = fmt.Fprintf(h.warn, "[lsp-recorder] warning: log %s: writing is too slow, records are dropped "+
+					"(number of them is reported at exit)\n", d.path)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *teeHandler) with(fn func(handler slog.Handler) slog.Handler) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = fn(handler)
+	}
+	return &teeHandler{destinations: h.destinations, handlers: handlers, warn: h.warn}
+}
+
+func (h *teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *teeHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+// Close writes remaining records, closes all destinations and reports failure of each destination
+func (h *teeHandler) Close() error {
+	for _, d := range h.destinations {
+		close(d.queue)
+	}
+	var errs []error
+	for _, d := range h.destinations {
+		<-d.done
+		if n := d.dropped.Load(); n > 0 {
+			errs = append(errs, fmt.Errorf("log %s: %d records dropped since writing is too slow", d.path, n))
+		}
+		if d.failed > 0 {
+			errs = append(errs, fmt.Errorf("log %s: %d records failed to write, caused by %s", d.path, d.failed,
+				d.lastErr.Error()))
+		}
+		if err := d.closer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("log %s: %s", d.path, err.Error()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CreateLogs creates logs of destinations and returns logger writing each record to all of them (see teeHandler).
+// Single destination is created by CreateLog and written synchronously as before
+func CreateLogs(destinations []LogDestination) (*slog.Logger, io.Closer, error) {
+	if len(destinations) == 1 && destinations[0].Writer != nil {
+		dest := destinations[0]
+		return newOptionsLogger(dest.Writer, dest.Options.Format, dest.Options.Handler), dest.Writer, nil
+	}
+	if len(destinations) == 1 {
+		return CreateLog(destinations[0].Path, destinations[0].Options)
+	}
+	for _, dest := range destinations { // before any log is truncated
+		if err := CheckLogFormat(dest.Options.Format, dest.Options.CompressionLevel); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", dest.Path, err)
+		}
+	}
+	tee := &teeHandler{warn: StderrWriter}
+	for _, dest := range destinations {
+		var r io.WriteCloser = dest.Writer
+		if r == nil {
+			log, err := openRotatingLog(dest.Path, dest.Options)
+			if err != nil {
+				_ = tee.Close()
+				return nil, nil, err
+			}
+			r = log
+		}
+		d := &teeDestination{path: dest.Path, closer: r, queue: make(chan teeEntry, teeQueueSize), done: make(chan struct{})}
+		go d.run()
+		tee.destinations = append(tee.destinations, d)
+		tee.handlers = append(tee.handlers, newOptionsLogger(r, dest.Options.Format, dest.Options.Handler).Handler())
+	}
+	return slog.New(tee), tee, nil
+}
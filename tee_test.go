@@ -0,0 +1,117 @@
+package recorder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var teeTestTime = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+func TestCreateLogs(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "session.log.gz")
+	text := filepath.Join(dir, "session.log")
+	logger, closer, err := CreateLogs([]LogDestination{
+		{Path: archive, Options: LogOptions{Format: LogFormatJSONGzip}},
+		{Path: text, Options: LogOptions{Format: LogFormatText}},
+	})
+	require.NoError(t, err)
+	for i := 1; i <= 100; i++ {
+		writeLogData(logger, &LogData{seq: i, timestamp: teeTestTime, streamType: STDERR, payloadType: RAW, payload: []byte("x")})
+	}
+	require.NoError(t, closer.Close())
+
+	for name, format := range map[string]string{archive: LogFormatJSONGzip, text: LogFormatText} {
+		reader, err := OpenLog(name)
+		require.NoError(t, err)
+		seqs := readSeqs(t, reader)
+		_ = reader.Close()
+		assert.Len(t, seqs, 100, format)
+		assert.Equal(t, 100, seqs[len(seqs)-1], format)
+	}
+}
+
+// blockingWriter blocks writes until released, or fails if err is set
+type blockingWriter struct {
+	release chan struct{}
+	err     error
+}
+
+func (w *blockingWriter) Write(buf []byte) (int, error) {
+	if w.release != nil {
+		<-w.release
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(buf), nil
+}
+
+func newTestTee(writers ...io.Writer) *teeHandler {
+	tee := &teeHandler{}
+	for i, writer := range writers {
+		d := &teeDestination{path: fmt.Sprintf("test%d", i+1), closer: nopWriteCloser{}, queue: make(chan teeEntry, teeQueueSize),
+			done: make(chan struct{})}
+		go d.run()
+		tee.destinations = append(tee.destinations, d)
+		tee.handlers = append(tee.handlers, NewLogger(writer).Handler())
+	}
+	return tee
+}
+
+func TestTeeSlowDestination(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	fast := &blockingWriter{}
+	tee := newTestTee(slow, fast)
+	warn := bytes.Buffer{}
+	tee.warn = &warn
+	logger := slog.New(tee)
+	for i := 0; i < teeQueueSize+10; i++ { // not blocked by slow destination
+		writeLogData(logger, &LogData{seq: i + 1, timestamp: teeTestTime, streamType: STDERR, payloadType: RAW})
+	}
+	assert.Equal(t, 1, strings.Count(warn.String(), "[lsp-recorder] warning: log test1: writing is too slow, "+
+		"records are dropped (number of them is reported at exit)\n")) // only once
+	close(slow.release)
+	err := tee.Close()
+	require.Error(t, err)
+	assert.Regexp(t, `^log test1: \d+ records dropped since writing is too slow`, err.Error())
+	assert.GreaterOrEqual(t, tee.destinations[0].dropped.Load(), int64(9)) // only one record is taken by writer
+}
+
+func TestTeeFailingDestination(t *testing.T) {
+	tee := newTestTee(&blockingWriter{err: errors.New("no space left on device")}, &blockingWriter{})
+	logger := slog.New(tee)
+	for i := 0; i < 3; i++ {
+		writeLogData(logger, &LogData{seq: i + 1, timestamp: teeTestTime, streamType: STDERR, payloadType: RAW})
+	}
+	assert.EqualError(t, tee.Close(), "log test1: 3 records failed to write, caused by no space left on device")
+}
+
+func TestCreateLogsUnknownFormat(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.log")
+	require.NoError(t, os.WriteFile(first, []byte("previous session\n"), 0o666))
+	_, _, err := CreateLogs([]LogDestination{
+		{Path: first, Options: LogOptions{Format: LogFormatJSON}},
+		{Path: filepath.Join(dir, "b.log"), Options: LogOptions{Format: "yaml"}},
+	})
+	assert.EqualError(t, err, filepath.Join(dir, "b.log")+": unsupported log format: yaml")
+	content, err := os.ReadFile(first)
+	require.NoError(t, err)
+	assert.Equal(t, "previous session\n", string(content))
+
+	_, _, err = CreateLog(first, LogOptions{Format: LogFormatJSONGzip, CompressionLevel: 42})
+	assert.Error(t, err)
+	content, _ = os.ReadFile(first)
+	assert.Equal(t, "previous session\n", string(content))
+}
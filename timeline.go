@@ -0,0 +1,165 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// formats of timeline
+const (
+	TimelineFormatText = "text" // aligned columns
+	TimelineFormatTSV  = "tsv"  // tab-separated values with header (seconds and milliseconds as plain numbers)
+)
+
+// timelineMaxPending is max number of rows held until their responses arrive. Beyond it, the oldest row is written
+// without latency (and its response gets own row), so that unanswered request does not hold whole session
+const timelineMaxPending = 4096
+
+// timelineArrows are directions of streams in timeline (client is on the left)
+var timelineArrows = map[StreamType]string{
+	STDIN:  "→",
+	STDOUT: "←",
+}
+
+// timelineRow is request, notification or unmatched response. Response is folded onto row of its request
+type timelineRow struct {
+	elapsed  time.Duration // since start of session
+	stream   StreamType
+	kind     string // req, notif or resp
+	method   string
+	id       string // empty if notification
+	size     int
+	latency  time.Duration
+	key      string // requestKey of request (empty if not request)
+	answered bool   // response of request has arrived (row is complete)
+}
+
+// timelineWriter writes rows in order of request, holding rows until head of them is complete
+type timelineWriter struct {
+	writer  io.Writer
+	format  string
+	rows    []*timelineRow          // rows not written yet (head is the oldest)
+	pending map[string]*timelineRow // request rows waiting for response by requestKey
+}
+
+func (w *timelineWriter) header() {
+	if w.format == TimelineFormatTSV {
+		_, _ = io.WriteString(w.writer, "elapsed_s\tdirection\tkind\tmethod\tid\tsize\tlatency_ms\n")
+	} else {
+		_, _ = fmt.Fprintf(w.writer, "%12s %s %-5s %-40s %-10s %8s %s\n", "elapsed", " ", "kind", "method", "id",
+			"size", "latency")
+	}
+}
+
+func (w *timelineWriter) add(row *timelineRow) {
+	w.rows = append(w.rows, row)
+	if row.key != "" {
+		w.pending[row.key] = row
+	}
+	if len(w.rows) > timelineMaxPending {
+		w.writeRow(w.rows[0])
+		w.rows = w.rows[1:]
+	}
+	w.flush(false)
+}
+
+// flush writes complete rows at head (all rows at end of log)
+func (w *timelineWriter) flush(all bool) {
+	n := 0
+	for ; n < len(w.rows) && (all || w.rows[n].kind != "req" || w.rows[n].answered); n++ {
+		w.writeRow(w.rows[n])
+	}
+	w.rows = w.rows[n:]
+}
+
+func (w *timelineWriter) writeRow(row *timelineRow) {
+	if row.key != "" && !row.answered && w.pending[row.key] == row {
+		delete(w.pending, row.key) // response gets own row
+	}
+	if w.format == TimelineFormatTSV {
+		latency := ""
+		if row.answered {
+			latency = strconv.FormatFloat(float64(row.latency)/float64(time.Millisecond), 'f', 3, 64)
+		}
+		_, _ = fmt.Fprintf(w.writer, "%.6f\t%s\t%s\t%s\t%s\t%d\t%s\n", row.elapsed.Seconds(), timelineArrows[row.stream],
+			row.kind, row.method, row.id, row.size, latency)
+		return
+	}
+	latency := ""
+	if row.answered {
+		latency = formatLatency(row.latency)
+	} else if row.kind == "req" {
+		latency = "-"
+	}
+	_, _ = fmt.Fprintf(w.writer, "%12s %s %-5s %-40s %-10s %8s %s\n", fmt.Sprintf("%+.6fs", row.elapsed.Seconds()),
+		timelineArrows[row.stream], row.kind, row.method, row.id, formatSize(row.size), latency)
+}
+
+// PrintTimeline writes one line per exchange of JSON-RPC messages: elapsed time since start of session, direction,
+// kind, method, id, size and latency. Response is folded onto row of its request, and response to unknown request has own row.
+// Only envelope of payloads is parsed. Records are selected by filter like Print (payload query is not supported)
+func PrintTimeline(reader io.Reader, writer io.Writer, filter *PrintFilter, format string) error {
+	w := &timelineWriter{writer: writer, format: format, pending: map[string]*timelineRow{}}
+	w.header()
+	r := NewLogReader(reader)
+	r.SkipErrors(filter.SkipErrors)
+	var start recordTime
+	for n := 1; ; n++ {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if n == 1 {
+			start = d.recordTime()
+			if err := filter.begin(d.timestamp); err != nil {
+				return err
+			}
+		}
+		if d.payloadType == SESSION_START { // ids and elapsed time of appended session are independent of previous one
+			w.flush(true)
+			clear(w.pending)
+			start = d.recordTime()
+		}
+		for _, d := range expandBatch(d) {
+			if d.payloadType != JSON {
+				continue
+			}
+			e, err := d.Envelope()
+			if err != nil {
+				continue
+			}
+			elapsed := d.recordTime().Sub(start)
+			if e.IsResponse() {
+				key := pairKey(d, peerStream(d.streamType), e.Id)
+				if req, ok := w.pending[key]; ok {
+					delete(w.pending, key)
+					req.answered, req.latency = true, elapsed-req.elapsed
+					w.flush(false)
+					continue
+				}
+				if filter.match(d, e, "") {
+					w.add(&timelineRow{elapsed: elapsed, stream: d.streamType, kind: "resp", method: "-",
+						id: idKey(e.Id), size: d.messageSize()})
+				}
+				continue
+			}
+			if !filter.match(d, e, e.Method) {
+				continue
+			}
+			row := &timelineRow{elapsed: elapsed, stream: d.streamType, kind: "notif", method: e.Method,
+				size: d.messageSize()}
+			if e.IsRequest() {
+				row.kind, row.id, row.key = "req", idKey(e.Id), pairKey(d, d.streamType, e.Id)
+			}
+			w.add(row)
+		}
+	}
+	w.flush(true)
+	return nil
+}
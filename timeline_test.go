@@ -0,0 +1,44 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+var timelineTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"initialized"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`)},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":9,"result":null}`)},
+)
+
+func TestPrintTimeline(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, PrintTimeline(strings.NewReader(timelineTestLog), &out, &PrintFilter{}, TimelineFormatText))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 5)
+	assert.Regexp(t, `^ +elapsed   kind +method +id +size latency$`, lines[0])
+	assert.Regexp(t, `^ +\+1\.000000s → req +initialize +1 +46B 2s$`, lines[1])
+	assert.Regexp(t, `^ +\+2\.000000s → notif initialized +40B $`, lines[2])
+	assert.Regexp(t, `^ +\+4\.000000s → req +shutdown +2 +44B -$`, lines[3]) // never answered
+	assert.Regexp(t, `^ +\+5\.000000s ← resp +- +9 +38B $`, lines[4])
+
+	out.Reset()
+	require.NoError(t, PrintTimeline(strings.NewReader(timelineTestLog), &out, &PrintFilter{}, TimelineFormatTSV))
+	assert.Equal(t, "elapsed_s\tdirection\tkind\tmethod\tid\tsize\tlatency_ms\n"+
+		"1.000000\t→\treq\tinitialize\t1\t46\t2000.000\n"+
+		"2.000000\t→\tnotif\tinitialized\t\t40\t\n"+
+		"4.000000\t→\treq\tshutdown\t2\t44\t\n"+
+		"5.000000\t←\tresp\t-\t9\t38\t\n", out.String())
+
+	out.Reset()
+	require.NoError(t, PrintTimeline(strings.NewReader(timelineTestLog), &out, &PrintFilter{Methods: []string{"initialize"}},
+		TimelineFormatTSV))
+	assert.Equal(t, "elapsed_s\tdirection\tkind\tmethod\tid\tsize\tlatency_ms\n"+
+		"1.000000\t→\treq\tinitialize\t1\t46\t2000.000\n", out.String())
+}
@@ -0,0 +1,379 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trailer is summary of session recorded as the last record of log
+type Trailer struct {
+	Summary            *SessionSummary     `json:"summary,omitempty"`
+	Shutdown           *ShutdownAssessment `json:"shutdown,omitempty"`
+	SuppressedToClient map[string]int      `json:"suppressed_to_client,omitempty"`
+	Digest             *LogDigest          `json:"digest,omitempty"` // digest of records before trailer (if recorded with checksums)
+}
+
+// SessionSummary is totals of session
+type SessionSummary struct {
+	Duration         time.Duration     `json:"duration_ns"` // from first to last record
+	Messages         map[string]int    `json:"messages"`    // number of messages (json, invalid and incomplete payloads) of each stream
+	Bytes            map[string]int64  `json:"bytes"`       // total size of messages of each stream (before truncation)
+	Json             int               `json:"json"`
+	Invalid          int               `json:"invalid"`
+	Incomplete       int               `json:"incomplete,omitempty"`         // messages cut by end of stream
+	ExitCode         *int              `json:"exit_code,omitempty"`          // nil if server process is not known
+	Signal           string            `json:"signal,omitempty"`             // signal which terminated session
+	CutShort         string            `json:"cut_short,omitempty"`          // cause of cancellation which cut session short
+	Dropped          int64             `json:"dropped,omitempty"`            // records dropped since channel to log was full
+	BufferSize       int               `json:"buffer_size,omitempty"`        // capacity of channel of records (see RunOptions.BufferSize)
+	BufferPeak       int               `json:"buffer_peak,omitempty"`        // high-water mark of records queued in channel
+	Blocked          time.Duration     `json:"blocked_ns,omitempty"`         // total time producers of traffic were blocked by full channel
+	SinkDropped      int64             `json:"sink_dropped,omitempty"`       // batches dropped by HTTP sink until trailer
+	MaxContentLength int64             `json:"max_content_length,omitempty"` // limit of Content-Length (0 if unlimited)
+	Oversized        int               `json:"oversized,omitempty"`          // messages whose Content-Length exceeded limit
+	Unframed         map[string]int64  `json:"unframed_bytes,omitempty"`     // bytes of each stream passed through outside valid framing
+	Resyncs          map[string]int    `json:"resyncs,omitempty"`            // resynchronizations of each stream at next header after framing failure
+	Ends             map[string]string `json:"ends,omitempty"`               // reason of end of each stream
+	Outcome          string            `json:"outcome,omitempty"`            // how session ended (see Session.outcome)
+	Exit             *ServerExit       `json:"exit,omitempty"`               // nil if server process is not known
+	ServerSessions   []*ServerSession  `json:"server_sessions,omitempty"`    // lifetimes of server restarted on exit
+}
+
+// ServerSession is lifetime of server restarted on exit (see RunOptions.MaxRestarts)
+type ServerSession struct {
+	Id       int           `json:"id"`
+	Duration time.Duration `json:"duration_ns"`    // from the first record of server session to exit of server (or last record)
+	Exit     *ServerExit   `json:"exit,omitempty"` // nil if server has not exited
+}
+
+// Format writes server session like "server 2: 1.5s, exit 139, SIGSEGV (segmentation fault)"
+func (s *ServerSession) Format(writer io.Writer, indent string) {
+	_, _ = fmt.Fprintf(writer, "%sserver %d: %s", indent, s.Id, s.Duration)
+	if s.Exit == nil {
+		_, _ = fmt.Fprintf(writer, ", not exited\n")
+		return
+	}
+	_, _ = fmt.Fprintf(writer, ", exit %d", s.Exit.Code)
+	if s.Exit.Signal != "" {
+		_, _ = fmt.Fprintf(writer, ", %s", s.Exit.Signal)
+	}
+	_, _ = writer.Write([]byte("\n"))
+}
+
+// stderrTailLines is max number of lines of ServerExit.StderrTail
+const stderrTailLines = 20
+
+// ServerExit is how spawned Language Server exited, so that context of crash is in trailer
+type ServerExit struct {
+	Code       int           `json:"code"`
+	Signal     string        `json:"signal,omitempty"`      // signal which terminated server (e.g. SIGSEGV (segmentation fault))
+	Uptime     time.Duration `json:"uptime_ns"`             // wall-clock time from start of session to exit of server
+	StderrTail []string      `json:"stderr_tail,omitempty"` // last lines of stderr if server exited abnormally
+}
+
+// parseTrailerSummary returns summary recorded in TRAILER record (nil if not recorded)
+func parseTrailerSummary(d *LogData) *SessionSummary {
+	t := &Trailer{}
+	if d.payloadType != TRAILER || json.Unmarshal(d.payload, t) != nil {
+		return nil
+	}
+	return t.Summary
+}
+
+// parseServerExit returns exit of server recorded in TRAILER record (nil if not recorded)
+func parseServerExit(d *LogData) *ServerExit {
+	if summary := parseTrailerSummary(d); summary != nil {
+		return summary.Exit
+	}
+	return nil
+}
+
+// Format writes exit of server like "exit:     139 after 1.5s, SIGSEGV (segmentation fault)" and tail of stderr
+func (e *ServerExit) Format(writer io.Writer, indent string) {
+	_, _ = fmt.Fprintf(writer, "%sexit:     %d after %s", indent, e.Code, e.Uptime)
+	if e.Signal != "" {
+		_, _ = fmt.Fprintf(writer, ", %s", e.Signal)
+	}
+	_, _ = writer.Write([]byte("\n"))
+	if len(e.StderrTail) > 0 {
+		_, _ = fmt.Fprintf(writer, "%sstderr tail:\n", indent)
+		for _, line := range e.StderrTail {
+			_, _ = fmt.Fprintf(writer, "%s  %s\n", indent, line)
+		}
+	}
+}
+
+// Format writes summary as block
+func (s *SessionSummary) Format(writer io.Writer, indent string) {
+	if s.Outcome != "" {
+		_, _ = fmt.Fprintf(writer, "%soutcome:  %s\n", indent, s.Outcome)
+	}
+	_, _ = fmt.Fprintf(writer, "%sduration: %s\n", indent, s.Duration)
+	for _, t := range []StreamType{STDIN, STDOUT} {
+		_, _ = fmt.Fprintf(writer, "%s%-9s %d messages, %s\n", indent, t.String()+":", s.Messages[t.String()],
+			formatSize(int(s.Bytes[t.String()])))
+	}
+	_, _ = fmt.Fprintf(writer, "%spayloads: %d json, %d invalid", indent, s.Json, s.Invalid)
+	if s.Incomplete > 0 {
+		_, _ = fmt.Fprintf(writer, ", %d incomplete", s.Incomplete)
+	}
+	_, _ = writer.Write([]byte("\n"))
+	if s.Exit != nil {
+		s.Exit.Format(writer, indent)
+	} else if s.ExitCode != nil { // trailer of older version
+		_, _ = fmt.Fprintf(writer, "%sexit:     %d\n", indent, *s.ExitCode)
+	}
+	for _, server := range s.ServerSessions {
+		server.Format(writer, indent)
+	}
+	if s.Signal != "" {
+		_, _ = fmt.Fprintf(writer, "%ssignal:   %s\n", indent, s.Signal)
+	}
+	if s.CutShort != "" {
+		_, _ = fmt.Fprintf(writer, "%scut:      %s\n", indent, s.CutShort)
+	}
+	if s.Oversized > 0 {
+		_, _ = fmt.Fprintf(writer, "%soversize: %d messages over %s\n", indent, s.Oversized,
+			formatSize(int(s.MaxContentLength)))
+	}
+	for _, t := range []StreamType{STDIN, STDOUT} {
+		if n := s.Unframed[t.String()]; n > 0 {
+			_, _ = fmt.Fprintf(writer, "%sunframed: %s %s, %d resyncs\n", indent, t, formatSize(int(n)),
+				s.Resyncs[t.String()])
+		}
+	}
+	if s.BufferSize > 0 {
+		_, _ = fmt.Fprintf(writer, "%sbuffer:   peak %d of %d records, blocked %s\n", indent, s.BufferPeak, s.BufferSize,
+			s.Blocked)
+	}
+	if s.Dropped > 0 {
+		_, _ = fmt.Fprintf(writer, "%sdropped:  %d records\n", indent, s.Dropped)
+	}
+	if s.SinkDropped > 0 {
+		_, _ = fmt.Fprintf(writer, "%ssink:     %d batches dropped\n", indent, s.SinkDropped)
+	}
+}
+
+// SessionStart is recorded as the first record of each recording session,
+// so sessions appended to the same log can be told apart
+type SessionStart struct {
+	Version string   `json:"version"`
+	Pid     int      `json:"pid"`              // pid of recorder
+	Append  bool     `json:"append,omitempty"` // session is appended to existing log
+	Cwd     string   `json:"cwd,omitempty"`    // working directory of spawned Language Server
+	Env     []string `json:"env,omitempty"`    // KEY=VALUE added to (or KEY removed from) its environment
+}
+
+// Format writes summary, shutdown violations and suppressed notifications as block
+func (t *Trailer) Format(writer io.Writer, indent string) {
+	if t.Summary != nil {
+		t.Summary.Format(writer, indent)
+	}
+	if t.Shutdown != nil {
+		for _, v := range t.Shutdown.Violations {
+			_, _ = fmt.Fprintf(writer, "%sviolation: %s\n", indent, v)
+		}
+	}
+	methods := make([]string, 0, len(t.SuppressedToClient))
+	for method := range t.SuppressedToClient {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		_, _ = fmt.Fprintf(writer, "%ssuppressed: %s=%d\n", indent, method, t.SuppressedToClient[method])
+	}
+}
+
+// Session observes records in log order and builds Trailer
+type Session struct {
+	shutdown ShutdownTracker
+	filter   *ClientFilter
+	summary  SessionSummary
+	first    time.Time
+	signal   string   // signal which terminated server (see terminatedPrefix)
+	tail     []string // last lines of stderr until exit of server
+	exited   bool
+	server   time.Time // first record of current server session (see ServerSession)
+}
+
+func NewSession(filter *ClientFilter) *Session {
+	return &Session{filter: filter,
+		summary: SessionSummary{Messages: map[string]int{}, Bytes: map[string]int64{}}}
+}
+
+func (s *Session) Observe(d *LogData) {
+	s.shutdown.Observe(d)
+	if s.first.IsZero() {
+		s.first = d.timestamp
+	}
+	s.summary.Duration = d.timestamp.Sub(s.first)
+	if d.serverSession > 0 {
+		s.observeServer(d)
+	}
+	switch {
+	case d.payloadType == INVALID && bytes.HasPrefix(d.payload, []byte(contentLengthLimitPrefix)):
+		s.summary.Oversized++ // body is not recorded, so not counted as message
+	case d.payloadType == JSON || d.payloadType == INVALID || d.payloadType == INCOMPLETE:
+		switch d.payloadType {
+		case JSON:
+			s.summary.Json++
+		case INVALID:
+			s.summary.Invalid++
+		default:
+			s.summary.Incomplete++
+		}
+		size := len(d.payload)
+		if d.originalSize > 0 {
+			size = d.originalSize
+		}
+		s.summary.Messages[d.streamType.String()]++
+		s.summary.Bytes[d.streamType.String()] += int64(size)
+	case d.payloadType == RAW && d.streamType == STDERR:
+		if v, ok := strings.CutPrefix(string(d.payload), exitedPrefix); ok {
+			if code, err := strconv.Atoi(v); err == nil {
+				s.summary.ExitCode = &code
+				s.exit(code, d.timestamp)
+			}
+		} else if v, ok := strings.CutPrefix(string(d.payload), terminatedPrefix); ok {
+			s.signal = v
+		} else if stream, event, n, ok := parseFraming(string(d.payload)); ok {
+			s.observeFraming(stream, event, n)
+		} else if !s.exited && d.level >= slog.LevelInfo { // not debug record
+			s.observeStderr(d.payload)
+		}
+	case d.payloadType == RAW_END:
+		if v, ok := strings.CutPrefix(string(d.payload), signalEndPrefix); ok && d.streamType == STDERR {
+			s.summary.Signal = v
+		} else if v, ok := strings.CutPrefix(string(d.payload), cutShortPrefix); ok && d.streamType == STDERR {
+			s.summary.CutShort = v
+		} else if _, ok := s.summary.Ends[d.streamType.String()]; !ok {
+			if s.summary.Ends == nil {
+				s.summary.Ends = map[string]string{}
+			}
+			s.summary.Ends[d.streamType.String()] = string(d.payload)
+		}
+	}
+}
+
+// observeFraming counts bytes passed through outside valid framing and resynchronizations (see sendFraming)
+func (s *Session) observeFraming(stream string, event string, n int) {
+	if s.summary.Unframed == nil {
+		s.summary.Unframed, s.summary.Resyncs = map[string]int64{}, map[string]int{}
+	}
+	s.summary.Unframed[stream] += int64(n)
+	if event == framingResynchronized {
+		s.summary.Resyncs[stream]++
+	}
+}
+
+// observeStderr keeps last lines of stderr (records may be chunks having several lines)
+func (s *Session) observeStderr(payload []byte) {
+	text := strings.TrimRight(string(payload), "\r\n")
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		s.tail = append(s.tail, strings.TrimRight(line, "\r"))
+	}
+	if n := len(s.tail) - stderrTailLines; n > 0 {
+		s.tail = append(s.tail[:0], s.tail[n:]...)
+	}
+}
+
+// observeServer starts new server session if record is the first one of respawned server. Otherwise extends
+// duration of current one until its exit
+func (s *Session) observeServer(d *LogData) {
+	n := len(s.summary.ServerSessions)
+	if n == 0 || d.serverSession > s.summary.ServerSessions[n-1].Id {
+		s.summary.ServerSessions = append(s.summary.ServerSessions, &ServerSession{Id: d.serverSession})
+		s.server = d.timestamp
+		s.exited, s.signal, s.tail = false, "", nil
+		return
+	}
+	if current := s.summary.ServerSessions[n-1]; current.Exit == nil {
+		current.Duration = d.timestamp.Sub(s.server)
+	}
+}
+
+// exit records exit of server. Tail of stderr is kept only if server exited abnormally
+func (s *Session) exit(code int, timestamp time.Time) {
+	s.exited = true
+	exit := &ServerExit{Code: code, Signal: s.signal, Uptime: timestamp.Sub(s.first)}
+	if code != 0 {
+		exit.StderrTail = s.tail
+	}
+	s.tail = nil
+	s.summary.Exit = exit
+	if n := len(s.summary.ServerSessions); n > 0 {
+		current := s.summary.ServerSessions[n-1]
+		current.Duration, current.Exit = timestamp.Sub(s.server), exit
+	}
+}
+
+// outcome describes how session ended: cut short by cancellation, unexpected end of client/server stream, clean exit of server after
+// shutdown/exit, or exit of server without clean shutdown. Empty if unknown (e.g. server is not spawned)
+func (s *Session) outcome(a *ShutdownAssessment) string {
+	if s.summary.CutShort != "" {
+		return cutShortPrefix + s.summary.CutShort
+	}
+	for _, t := range []StreamType{STDOUT, STDIN} {
+		if reason, ok := s.summary.Ends[t.String()]; ok && reason != endOfStream {
+			return fmt.Sprintf("%s ended unexpectedly: %s", t, reason)
+		}
+	}
+	switch {
+	case a.ExitCode == nil:
+		return ""
+	case *a.ExitCode == 0 && a.ExitAfterShutdown && len(a.Violations) == 0:
+		return "server exited cleanly after shutdown/exit"
+	case a.AutoShutdown && !a.ExitSent:
+		return fmt.Sprintf("server exited with %d after auto shutdown on client disconnection", *a.ExitCode)
+	default:
+		return fmt.Sprintf("server exited with %d without clean shutdown/exit", *a.ExitCode)
+	}
+}
+
+func (s *Session) Trailer() *Trailer {
+	summary := s.summary
+	t := &Trailer{Summary: &summary, Shutdown: s.shutdown.Assess()}
+	summary.Outcome = s.outcome(t.Shutdown)
+	if s.filter != nil {
+		t.SuppressedToClient = s.filter.Counts()
+	}
+	return t
+}
+
+// ReadTrailer reads log and returns its trailer. If log has no trailer (e.g. old log),
+// trailer is re-derived from records
+func ReadTrailer(reader io.Reader) (*Trailer, error) {
+	session := NewSession(nil)
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return session.Trailer(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.payloadType == TRAILER {
+			t := &Trailer{}
+			if err := json.Unmarshal(d.payload, t); err != nil {
+				return nil, err
+			}
+			if t.Summary == nil { // trailer of older version
+				t.Summary = session.Trailer().Summary
+			}
+			return t, nil
+		}
+		session.Observe(d)
+	}
+}
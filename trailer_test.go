@@ -0,0 +1,142 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var summaryTestLog = newTestLog(
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte("run: server []")},
+	LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+	LogData{streamType: STDOUT, payloadType: INVALID, payload: []byte("invalid message header: 'hoge'")},
+	LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`), originalSize: 2000},
+	LogData{streamType: STDERR, payloadType: RAW, payload: []byte(exitedPrefix + "143")},
+	LogData{streamType: STDERR, payloadType: RAW_END, payload: []byte(signalEndPrefix + "terminated")},
+)
+
+func TestSessionSummary(t *testing.T) {
+	trailer, err := ReadTrailer(strings.NewReader(summaryTestLog))
+	require.NoError(t, err)
+	code := 143
+	assert.Equal(t, &SessionSummary{
+		Duration: 5 * time.Second,
+		Messages: map[string]int{"stdin": 1, "stdout": 2},
+		Bytes:    map[string]int64{"stdin": 46, "stdout": 2030},
+		Json:     2,
+		Invalid:  1,
+		ExitCode: &code,
+		Signal:   "terminated",
+		Outcome:  "server exited with 143 without clean shutdown/exit",
+		Exit:     &ServerExit{Code: 143, Uptime: 4 * time.Second, StderrTail: []string{"run: server []"}},
+	}, trailer.Summary)
+
+	out := bytes.Buffer{}
+	trailer.Summary.Format(&out, "")
+	assert.Equal(t, `outcome:  server exited with 143 without clean shutdown/exit
+duration: 5s
+stdin:    1 messages, 46B
+stdout:   2 messages, 2.0KB
+payloads: 2 json, 1 invalid
+exit:     143 after 4s
+stderr tail:
+  run: server []
+signal:   terminated
+`, out.String())
+}
+
+func TestSessionServerExit(t *testing.T) {
+	exit := func(records ...LogData) *ServerExit {
+		session := NewSession(nil)
+		for i := range records {
+			records[i].timestamp = time.Date(2024, 5, 1, 10, 0, i, 0, time.UTC)
+			session.Observe(&records[i])
+		}
+		return session.Trailer().Summary.Exit
+	}
+	stderr := func(s string) LogData {
+		return LogData{streamType: STDERR, payloadType: RAW, payload: []byte(s)}
+	}
+	assert.Nil(t, exit(stderr("log")))
+	assert.Equal(t, &ServerExit{Code: 0, Uptime: time.Second}, exit(stderr("log\n"), stderr(exitedPrefix+"0")))
+
+	var records []LogData
+	for i := 0; i < stderrTailLines; i++ {
+		records = append(records, stderr(fmt.Sprintf("line %d\n", i)))
+	}
+	records = append(records, stderr("panic: x\r\n\ngoroutine 1:\n"),
+		stderr(terminatedPrefix+"SIGSEGV (segmentation fault, core dumped)"), stderr(exitedPrefix+"139"),
+		stderr("after exit"))
+	e := exit(records...)
+	assert.Equal(t, 139, e.Code)
+	assert.Equal(t, "SIGSEGV (segmentation fault, core dumped)", e.Signal)
+	assert.Equal(t, time.Duration(stderrTailLines+2)*time.Second, e.Uptime)
+	require.Len(t, e.StderrTail, stderrTailLines)
+	assert.Equal(t, []string{"line 17", "line 18", "line 19", "panic: x", "", "goroutine 1:"}, e.StderrTail[stderrTailLines-6:])
+
+	out := bytes.Buffer{}
+	e.Format(&out, "  ")
+	assert.True(t, strings.HasPrefix(out.String(), "  exit:     139 after 22s, SIGSEGV (segmentation fault, core dumped)\n"+
+		"  stderr tail:\n  "), out.String())
+}
+
+func TestSessionOutcome(t *testing.T) {
+	outcome := func(records ...LogData) string {
+		session := NewSession(nil)
+		for i := range records {
+			session.Observe(&records[i])
+		}
+		return session.Trailer().Summary.Outcome
+	}
+	end := func(t StreamType, reason string) LogData {
+		return LogData{streamType: t, payloadType: RAW_END, payload: []byte(reason)}
+	}
+	assert.Equal(t, "server exited cleanly after shutdown/exit",
+		outcome(shutdownRequest, shutdownResponse, exitNotification, end(STDOUT, endOfStream), exited("0")))
+	assert.Equal(t, "stdout ended unexpectedly: end of stream (88 of 100 bytes of payload missing)",
+		outcome(shutdownRequest, end(STDOUT, "end of stream (88 of 100 bytes of payload missing)"), exited("0")))
+	assert.Equal(t, "server exited with 1 without clean shutdown/exit", outcome(exited("1")))
+	assert.Equal(t, "", outcome(end(STDIN, endOfStream))) // server is not spawned
+}
+
+func TestReadTrailerSummary(t *testing.T) {
+	// trailer of older version has no summary, so it is re-derived
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"exit"}`)},
+		LogData{streamType: STDERR, payloadType: TRAILER, payload: []byte(`{"shutdown":{"violations":["recorded"]}}`)},
+	)
+	trailer, err := ReadTrailer(strings.NewReader(log))
+	require.NoError(t, err)
+	require.NotNil(t, trailer.Summary)
+	assert.Equal(t, 1, trailer.Summary.Json)
+	assert.Equal(t, []string{"recorded"}, trailer.Shutdown.Violations)
+}
+
+func TestPrintTrailer(t *testing.T) {
+	summary := &SessionSummary{Duration: time.Second, Messages: map[string]int{"stdin": 3},
+		Bytes: map[string]int64{"stdin": 100}, Json: 3}
+	payload, err := json.Marshal(&Trailer{Summary: summary,
+		Shutdown:           &ShutdownAssessment{Violations: []string{"client: sent exit without shutdown"}},
+		SuppressedToClient: map[string]int{"window/logMessage": 2}})
+	require.NoError(t, err)
+	log := newTestLog(
+		LogData{streamType: STDERR, payloadType: TRAILER, payload: payload},
+		LogData{streamType: STDERR, payloadType: TRAILER, payload: []byte(`{"shutdown":{}}`)}, // older version
+	)
+	out := bytes.Buffer{}
+	require.NoError(t, Print(strings.NewReader(log), &out, &PrintFilter{}))
+	assert.Equal(t, `2024-05-01T10:00:00Z <stderr> (session summary)
+  duration: 1s
+  stdin:    3 messages, 100B
+  stdout:   0 messages, 0B
+  payloads: 3 json, 0 invalid
+  violation: client: sent exit without shutdown
+  suppressed: window/logMessage=2
+2024-05-01T10:00:01Z <stderr> {"shutdown":{}}
+`, out.String())
+}
@@ -0,0 +1,65 @@
+package recorder
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const dialRetryInterval = 100 * time.Millisecond
+
+// acceptConn listens on the address and accepts a single connection
+func acceptConn(network string, address string, ch chan<- LogData) (net.Conn, error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen %s: %v", address, err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	sendMessage(STDERR, fmt.Sprintf("listen on: %s", listener.Addr()), ch)
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept client: %v", err)
+	}
+	sendMessage(STDERR, fmt.Sprintf("accept client: %s", conn.RemoteAddr()), ch)
+	return conn, nil
+}
+
+// dialConn connects to the address. Retries until timeout since spawned server may not listen yet
+func dialConn(network string, address string, timeout time.Duration, ch chan<- LogData) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial(network, address)
+		if err == nil {
+			sendMessage(STDERR, fmt.Sprintf("connect to server: %s", address), ch)
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to connect %s: %v", address, err)
+		}
+		time.Sleep(dialRetryInterval)
+	}
+}
+
+// acceptClient accepts a single client connection.
+// network is "tcp" or "pipe" (unix domain socket, or named pipe on Windows)
+func acceptClient(network string, address string, ch chan<- LogData) (io.ReadWriteCloser, error) {
+	if network == "pipe" {
+		return acceptPipe(address, ch)
+	}
+	return acceptConn(network, address, ch)
+}
+
+// dialServer connects to the server.
+// network is "tcp", "pipe" (unix domain socket, or named pipe on Windows) or "ws" (WebSocket URL)
+func dialServer(network string, address string, timeout time.Duration, ch chan<- LogData) (io.ReadWriteCloser, error) {
+	switch network {
+	case "pipe":
+		return dialPipe(address, timeout, ch)
+	case "ws":
+		return dialWebSocket(address, timeout, ch)
+	}
+	return dialConn(network, address, timeout, ch)
+}
@@ -0,0 +1,46 @@
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialServerRetry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	_ = listener.Close() // not listen yet
+
+	ch := make(chan LogData, 32)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = l.Close()
+		}()
+		conn, err := l.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+	conn, err := dialServer("tcp", address, 5*time.Second, ch)
+	require.NoError(t, err)
+	_ = conn.Close()
+	assert.Equal(t, "connect to server: "+address, string((<-ch).payload))
+}
+
+func TestDialServerTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	_, err = dialServer("tcp", address, 200*time.Millisecond, make(chan LogData, 32))
+	assert.Error(t, err)
+}
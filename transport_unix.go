@@ -0,0 +1,40 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// removeStaleSocket removes unix domain socket file left by previous session
+func removeStaleSocket(path string) {
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == os.ModeSocket {
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close() // still used by someone
+			return
+		}
+		_ = os.Remove(path)
+	}
+}
+
+// acceptPipe accepts a single client on unix domain socket.
+// Socket file is removed after accept
+func acceptPipe(path string, ch chan<- LogData) (io.ReadWriteCloser, error) {
+	removeStaleSocket(path)
+	return acceptConn("unix", path, ch)
+}
+
+// dialPipe connects to unix domain socket. Waits for socket file to appear until timeout
+func dialPipe(path string, timeout time.Duration, ch chan<- LogData) (io.ReadWriteCloser, error) {
+	return dialConn("unix", path, timeout, ch)
+}
+
+// listenStream listens on unix domain socket for viewers of live stream.
+// Socket file is removed when listener is closed
+func listenStream(path string) (net.Listener, error) {
+	removeStaleSocket(path)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package recorder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcceptClientUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = listener.Close() // stale socket file
+
+	go func() {
+		for i := 0; i < 50; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if conn, err := net.Dial("unix", path); err == nil {
+				_ = conn.Close()
+				return
+			}
+		}
+	}()
+	conn, err := acceptClient("pipe", path, make(chan LogData, 32))
+	require.NoError(t, err)
+	_ = conn.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "socket file must be removed")
+}
@@ -0,0 +1,173 @@
+//go:build windows
+
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = modkernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = modkernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW        = modkernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = modkernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	pipeAccessDuplex          = 0x00000003
+	fileFlagFirstPipeInstance = 0x00080000
+	fileFlagOverlapped        = 0x40000000
+	pipeTypeByte              = 0x00000000
+	pipeRejectRemoteClients   = 0x00000008
+	pipeBufferSize            = 64 * 1024
+
+	errorPipeBusy         syscall.Errno = 231
+	errorNoData           syscall.Errno = 232
+	errorPipeNotConnected syscall.Errno = 233
+	errorPipeConnected    syscall.Errno = 535
+)
+
+// pipeConn is a connection of named pipe opened with overlapped I/O,
+// so that read and write can be performed concurrently
+type pipeConn struct {
+	handle    syscall.Handle
+	closeOnce sync.Once
+}
+
+// do performs overlapped I/O operation and waits for its completion
+func (p *pipeConn) do(op func(o *syscall.Overlapped) error) (int, error) {
+	h, _, e := procCreateEventW.Call(0, 1, 0, 0) // manual reset, non-signaled
+	if h == 0 {
+		return 0, e
+	}
+	event := syscall.Handle(h)
+	defer func() {
+		_ = syscall.CloseHandle(event)
+	}()
+	o := syscall.Overlapped{HEvent: event}
+	if err := op(&o); err != nil && !errors.Is(err, syscall.ERROR_IO_PENDING) {
+		return 0, err
+	}
+	var n uint32
+	if r, _, e := procGetOverlappedResult.Call(uintptr(p.handle), uintptr(unsafe.Pointer(&o)),
+		uintptr(unsafe.Pointer(&n)), 1); r == 0 {
+		return int(n), e
+	}
+	return int(n), nil
+}
+
+func isPipeClosed(err error) bool {
+	return errors.Is(err, syscall.ERROR_BROKEN_PIPE) || errors.Is(err, errorPipeNotConnected) ||
+		errors.Is(err, errorNoData) || errors.Is(err, syscall.ERROR_OPERATION_ABORTED)
+}
+
+func (p *pipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := p.do(func(o *syscall.Overlapped) error {
+		return syscall.ReadFile(p.handle, b, nil, o)
+	})
+	if err != nil && isPipeClosed(err) {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (p *pipeConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n, err := p.do(func(o *syscall.Overlapped) error {
+			return syscall.WriteFile(p.handle, b[written:], nil, o)
+		})
+		written += n
+		if err != nil {
+			if isPipeClosed(err) {
+				return written, io.ErrClosedPipe
+			}
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// CloseWrite returns errors.ErrUnsupported since byte mode named pipe cannot be half-closed
+func (p *pipeConn) CloseWrite() error {
+	return fmt.Errorf("named pipe cannot be half-closed: %w", errors.ErrUnsupported)
+}
+
+func (p *pipeConn) Close() error {
+	err := os.ErrClosed
+	p.closeOnce.Do(func() {
+		_ = syscall.CancelIoEx(p.handle, nil) // abort pending read
+		err = syscall.CloseHandle(p.handle)
+	})
+	return err
+}
+
+// acceptPipe creates named pipe (like \\.\pipe\lsp-xxxx) and waits for a single client
+func acceptPipe(path string, ch chan<- LogData) (io.ReadWriteCloser, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, _, e := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name)),
+		pipeAccessDuplex|fileFlagFirstPipeInstance|fileFlagOverlapped,
+		pipeTypeByte|pipeRejectRemoteClients,
+		1, pipeBufferSize, pipeBufferSize, 0, 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return nil, fmt.Errorf("failed to listen %s: %v", path, e)
+	}
+	conn := &pipeConn{handle: syscall.Handle(h)}
+	sendMessage(STDERR, fmt.Sprintf("listen on: %s", path), ch)
+	_, err = conn.do(func(o *syscall.Overlapped) error {
+		if r, _, e := procConnectNamedPipe.Call(h, uintptr(unsafe.Pointer(o))); r == 0 {
+			return e
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errorPipeConnected) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to accept client: %v", err)
+	}
+	sendMessage(STDERR, fmt.Sprintf("accept client: %s", path), ch)
+	return conn, nil
+}
+
+// dialPipe connects to named pipe. Waits for named pipe to appear until timeout
+func dialPipe(path string, timeout time.Duration, ch chan<- LogData) (io.ReadWriteCloser, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+			syscall.OPEN_EXISTING, fileFlagOverlapped, 0)
+		if err == nil {
+			sendMessage(STDERR, fmt.Sprintf("connect to server: %s", path), ch)
+			return &pipeConn{handle: h}, nil
+		}
+		if !errors.Is(err, syscall.ERROR_FILE_NOT_FOUND) && !errors.Is(err, errorPipeBusy) ||
+			time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to connect %s: %v", path, err)
+		}
+		time.Sleep(dialRetryInterval)
+	}
+}
+
+// listenStream listens on TCP address (like 127.0.0.1:7000) for viewers of live stream,
+// since named pipe accepts only a single client at a time
+func listenStream(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
@@ -0,0 +1,167 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// legacyHeader matches header line of legacy text log like "2024-05-01T10:32:11.123Z <stdin>"
+var legacyHeader = regexp.MustCompile(`^(\d{4}-\d\d-\d\dT\S+) <(stdin|stdout|stderr)>(.*)$`)
+
+var legacyEndPrefixes = []string{"end of stream", "read error: ", "shutdown by signal: "}
+
+type legacyEntry struct {
+	timestamp  time.Time
+	streamType StreamType
+	rest       string // remain of header line
+	lines      []string
+}
+
+type UpgradeReport struct {
+	Records         int
+	Legacy          bool // input is legacy text log
+	CompactedJson   int  // JSON payloads whose original formatting is lost
+	GuessedType     int  // non-JSON records whose payload type is guessed
+	InvalidJsonBody int  // JSON records whose payload cannot be parsed
+}
+
+// LegacyLogReader reads LogData from legacy text log (human-readable format of old recorder)
+type LegacyLogReader struct {
+	reader *bufio.Reader
+	line   int
+	cur    *legacyEntry
+	report *UpgradeReport
+}
+
+func (r *LegacyLogReader) toLogData(e *legacyEntry) *LogData {
+	d := &LogData{timestamp: e.timestamp, streamType: e.streamType}
+	switch {
+	case e.rest == "": // indented JSON
+		payload := strings.Join(e.lines, "\n")
+		buf := bytes.Buffer{}
+		if err := json.Compact(&buf, []byte(payload)); err == nil {
+			r.report.CompactedJson++
+			d.payloadType = JSON
+			d.payload = buf.Bytes()
+		} else {
+			r.report.InvalidJsonBody++
+			d.payloadType = INVALID
+			d.payload = []byte(payload)
+		}
+	case e.rest == "invalid json payload":
+		r.report.InvalidJsonBody++
+		d.payloadType = INVALID
+		d.payload = []byte(strings.Join(e.lines, "\n"))
+	default:
+		payload := strings.TrimPrefix(e.rest, " ")
+		if len(e.lines) > 0 {
+			payload += "\n" + strings.Join(e.lines, "\n")
+		}
+		r.report.GuessedType++
+		d.payload = []byte(payload)
+		d.payloadType = INVALID
+		if e.streamType == STDERR {
+			d.payloadType = RAW
+		}
+		for _, prefix := range legacyEndPrefixes {
+			if strings.HasPrefix(payload, prefix) {
+				d.payloadType = RAW_END
+				break
+			}
+		}
+	}
+	return d
+}
+
+// Next returns next LogData. Returns io.EOF at end of log
+func (r *LegacyLogReader) Next() (*LogData, error) {
+	for {
+		line, err := r.reader.ReadString('\n')
+		if len(line) == 0 && err != nil {
+			if errors.Is(err, io.EOF) && r.cur != nil {
+				e := r.cur
+				r.cur = nil
+				return r.toLogData(e), nil
+			}
+			return nil, err
+		}
+		r.line++
+		line = strings.TrimSuffix(line, "\n")
+		if m := legacyHeader.FindStringSubmatch(line); m != nil {
+			if ts, e := time.Parse(time.RFC3339Nano, m[1]); e == nil {
+				streamType, _ := parseStreamType(m[2])
+				prev := r.cur
+				r.cur = &legacyEntry{timestamp: ts, streamType: streamType, rest: m[3]}
+				if prev != nil {
+					return r.toLogData(prev), nil
+				}
+				continue
+			}
+		}
+		if r.cur == nil {
+			return nil, fmt.Errorf("broken legacy log at line %d: missing header", r.line)
+		}
+		r.cur.lines = append(r.cur.lines, line)
+	}
+}
+
+// Upgrade reads log (legacy text log or current log) and writes it as current log with derived fields
+func Upgrade(reader io.Reader, logger *slog.Logger) (*UpgradeReport, error) {
+	report := &UpgradeReport{}
+	br := bufio.NewReader(reader)
+	var next func() (*LogData, error)
+	if head, _ := br.Peek(len(slog.TimeKey) + 1); bytes.HasPrefix(head, []byte("{")) ||
+		bytes.HasPrefix(head, []byte(slog.TimeKey+"=")) { // JSON lines or text format
+		next = NewLogReader(br).Next
+	} else {
+		report.Legacy = true
+		next = (&LegacyLogReader{reader: br, report: report}).Next
+	}
+	for {
+		d, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Records++
+		if d.seq == 0 {
+			d.seq = report.Records
+		}
+		writeLogData(logger, d)
+	}
+	return report, nil
+}
+
+func (r *UpgradeReport) String() string {
+	sb := strings.Builder{}
+	if r.Legacy {
+		_, _ = fmt.Fprintf(&sb, "upgrade %d records from legacy text log\n", r.Records)
+	} else {
+		_, _ = fmt.Fprintf(&sb, "upgrade %d records (already current log)\n", r.Records)
+	}
+	if r.CompactedJson+r.GuessedType+r.InvalidJsonBody > 0 {
+		sb.WriteString("could not derive:\n")
+	}
+	if r.CompactedJson > 0 {
+		_, _ = fmt.Fprintf(&sb, "  original formatting of %d JSON payloads (payload and size are compacted JSON)\n",
+			r.CompactedJson)
+	}
+	if r.InvalidJsonBody > 0 {
+		_, _ = fmt.Fprintf(&sb, "  method/id of %d broken JSON payloads (recorded as invalid)\n", r.InvalidJsonBody)
+	}
+	if r.GuessedType > 0 {
+		_, _ = fmt.Fprintf(&sb, "  exact payload type of %d non-JSON records (guessed from stream and content)\n",
+			r.GuessedType)
+	}
+	return sb.String()
+}
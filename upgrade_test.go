@@ -0,0 +1,90 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+const legacyLog = `2024-05-01T10:32:11.123456789+09:00 <stderr> run: arshd []
+2024-05-01T10:32:11.123456790+09:00 <stderr> HOME=/home/user
+PATH=/usr/bin
+2024-05-01T10:32:11.2+09:00 <stdin>
+{
+  "jsonrpc": "2.0",
+  "id": 1,
+  "method": "initialize"
+}
+2024-05-01T10:32:11.3+09:00 <stdout>invalid json payload
+{"id":
+2024-05-01T10:32:11.4+09:00 <stdout> invalid message header: 'hoge'
+2024-05-01T10:32:11.5+09:00 <stderr> server log
+
+2024-05-01T10:32:11.6+09:00 <stderr> end of stream
+`
+
+func readAllLogData(t *testing.T, reader io.Reader) []*LogData {
+	var ret []*LogData
+	r := NewLogReader(reader)
+	for {
+		d, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+func TestUpgradeLegacy(t *testing.T) {
+	out := bytes.Buffer{}
+	report, err := Upgrade(strings.NewReader(legacyLog), NewLogger(&out))
+	require.NoError(t, err)
+	assert.Equal(t, &UpgradeReport{Records: 7, Legacy: true, CompactedJson: 1, GuessedType: 5, InvalidJsonBody: 1}, report)
+	assert.Contains(t, out.String(), `"method":"initialize","id":1,"size":46,`)
+
+	data := readAllLogData(t, &out)
+	require.Len(t, data, 7)
+	types := []PayloadType{RAW, RAW, JSON, INVALID, INVALID, RAW, RAW_END}
+	streams := []StreamType{STDERR, STDERR, STDIN, STDOUT, STDOUT, STDERR, STDERR}
+	for i, d := range data {
+		assert.Equal(t, i+1, d.seq)
+		assert.Equal(t, types[i], d.payloadType, "at %d", i)
+		assert.Equal(t, streams[i], d.streamType, "at %d", i)
+	}
+	assert.Equal(t, "HOME=/home/user\nPATH=/usr/bin", string(data[1].payload))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"method":"initialize"}`, string(data[2].payload))
+	assert.Equal(t, `{"id":`, string(data[3].payload))
+	assert.Equal(t, "server log\n", string(data[5].payload))
+	assert.Equal(t, int64(1714527131200000000), data[2].timestamp.UnixNano())
+}
+
+func TestUpgradeIdempotent(t *testing.T) {
+	first := bytes.Buffer{}
+	_, err := Upgrade(strings.NewReader(legacyLog), NewLogger(&first))
+	require.NoError(t, err)
+
+	second := bytes.Buffer{}
+	report, err := Upgrade(bytes.NewReader(first.Bytes()), NewLogger(&second))
+	require.NoError(t, err)
+	assert.Equal(t, &UpgradeReport{Records: 7}, report)
+	assert.Equal(t, first.String(), second.String())
+
+	// text format is not legacy log
+	second.Reset()
+	report, err = Upgrade(strings.NewReader(convertLog(t, first.String(), LogFormatJSON, LogFormatText)), NewLogger(&second))
+	require.NoError(t, err)
+	assert.False(t, report.Legacy)
+	assert.Equal(t, first.String(), second.String())
+}
+
+func TestUpgradeBroken(t *testing.T) {
+	_, err := Upgrade(strings.NewReader("hello\n"), NewLogger(io.Discard))
+	assert.ErrorContains(t, err, "line 1")
+	_, err = Upgrade(strings.NewReader("{\"time\":\n"), NewLogger(io.Discard))
+	assert.ErrorContains(t, err, "line 1")
+}
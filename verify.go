@@ -0,0 +1,218 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// severities of VerifyFinding
+const (
+	SeverityWarning = "warning" // log is readable, but may be incomplete (e.g. interrupted recording)
+	SeverityCorrupt = "corrupt" // part of log is lost or broken
+)
+
+// kinds of VerifyFinding
+const (
+	FindingBrokenLine     = "broken-line"     // line which cannot be decoded as record (corrupt)
+	FindingUnknownType    = "unknown-type"    // record with unknown stream or payload type (warning)
+	FindingTimeBackwards  = "time-backwards"  // timestamp earlier than previous record of the same stream (warning)
+	FindingInvalidPayload = "invalid-payload" // payload of JSON record which does not parse (corrupt)
+	FindingMissingStart   = "missing-start"   // records without preceding session start (warning)
+	FindingMissingEnd     = "missing-end"     // session without trailer (warning)
+	FindingTruncated      = "truncated"       // compressed stream ends abruptly (corrupt)
+	FindingReadError      = "read-error"      // log cannot be read any more (corrupt)
+	FindingBadChecksum    = "bad-checksum"    // payload which does not match its checksum (corrupt)
+	FindingBadDigest      = "bad-digest"      // records which do not match digest in trailer (corrupt)
+)
+
+// maxVerifyFindings is max number of findings kept in VerifyReport (the rest are only counted)
+const maxVerifyFindings = 1000
+
+// VerifyFinding is problem of log found by VerifyLog
+type VerifyFinding struct {
+	Line     int    `json:"line"` // line number of log (after decompression)
+	Severity string `json:"severity"`
+	Kind     string `json:"kind"`
+	Message  string `json:"message"`
+}
+
+// String returns description of finding like "line 12: corrupt: broken-line: ..."
+func (f *VerifyFinding) String() string {
+	return fmt.Sprintf("line %d: %s: %s: %s", f.Line, f.Severity, f.Kind, f.Message)
+}
+
+// VerifyReport is result of VerifyLog
+type VerifyReport struct {
+	Records   int             `json:"records"`             // decoded records
+	Sessions  int             `json:"sessions"`            // session start records
+	Checksums int             `json:"checksums,omitempty"` // records whose checksum is verified
+	Digests   int             `json:"digests,omitempty"`   // trailers whose digest is verified
+	Findings  []VerifyFinding `json:"findings"`
+	Omitted   int             `json:"omitted,omitempty"` // findings not kept (see maxVerifyFindings)
+	Verdict   string          `json:"verdict"`           // clean, warning or corrupt
+}
+
+func (r *VerifyReport) add(line int, severity string, kind string, format string, args ...any) {
+	if severity == SeverityCorrupt || r.Verdict == "clean" {
+		r.Verdict = severity
+	}
+	if len(r.Findings) >= maxVerifyFindings {
+		r.Omitted++
+		return
+	}
+	r.Findings = append(r.Findings, VerifyFinding{Line: line, Severity: severity, Kind: kind,
+		Message: fmt.Sprintf(format, args...)})
+}
+
+// ExitCode returns exit code of verdict (0 if clean, 1 if warning, 2 if corrupt)
+func (r *VerifyReport) ExitCode() int {
+	switch r.Verdict {
+	case SeverityCorrupt:
+		return 2
+	case SeverityWarning:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Format writes findings and verdict
+func (r *VerifyReport) Format(writer io.Writer) {
+	for i := range r.Findings {
+		_, _ = fmt.Fprintln(writer, r.Findings[i].String())
+	}
+	if r.Omitted > 0 {
+		_, _ = fmt.Fprintf(writer, "... %d more findings\n", r.Omitted)
+	}
+	_, _ = fmt.Fprintf(writer, "%s: %d records, %d sessions", r.Verdict, r.Records, r.Sessions)
+	if r.Checksums > 0 {
+		_, _ = fmt.Fprintf(writer, ", %d checksums, %d digests", r.Checksums, r.Digests)
+	}
+	_, _ = fmt.Fprintf(writer, ", %d findings\n", len(r.Findings)+r.Omitted)
+}
+
+// VerifyLog walks log record by record (without loading whole log) and reports broken lines, unknown types,
+// timestamps going backwards, unparsable JSON payloads, sessions missing start or trailer, and truncated
+// compressed stream. Checksums of records and digest in trailer (see RunOptions.Checksum) are recomputed and
+// compared if recorded. Reader should be decompressed by OpenLog (or OpenLogStream)
+func VerifyLog(reader io.Reader) *VerifyReport {
+	report := &VerifyReport{Findings: []VerifyFinding{}, Verdict: "clean"}
+	logReader := NewLogReader(reader)
+	logReader.SkipErrors(func(err error) {
+		var unknown *unknownTypeError
+		switch {
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			report.add(logReader.line, SeverityCorrupt, FindingTruncated, "%v", err)
+		case errors.As(err, &unknown):
+			report.add(logReader.line, SeverityWarning, FindingUnknownType, "%v", err)
+		default:
+			report.add(logReader.line, SeverityCorrupt, FindingBrokenLine, "%v", err)
+		}
+	})
+	last := map[StreamType]time.Time{} // timestamp of last record of stdin and stdout
+	inSession := false                 // whether session start is found and its trailer is not yet
+	warnedStart := false               // missing start is reported once for consecutive records
+	var digest *logDigest              // digest of records of session (nil if session start is not found or some records have no checksum)
+	for {
+		d, err := logReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			report.add(logReader.line, SeverityCorrupt, FindingReadError, "%v", err)
+			break
+		}
+		line := logReader.line
+		report.Records++
+
+		if d.checksum != "" {
+			report.Checksums++
+			if sum := payloadChecksum(d.payload); sum != d.checksum {
+				report.add(line, SeverityCorrupt, FindingBadChecksum, "payload of %s record #%d has checksum %s, but recorded %s",
+					d.payloadType, d.seq, sum, d.checksum)
+			}
+		}
+
+		switch d.payloadType {
+		case SESSION_START:
+			if inSession {
+				report.add(line, SeverityWarning, FindingMissingEnd, "previous session has no trailer")
+			}
+			report.Sessions++
+			inSession, warnedStart = true, false
+			digest = nil
+			if d.checksum != "" {
+				digest = newLogDigest()
+				digest.observe(d)
+			}
+		case TRAILER:
+			if !inSession && !warnedStart {
+				report.add(line, SeverityWarning, FindingMissingStart, "trailer without session start")
+			}
+			verifyDigest(report, line, d, digest)
+			inSession, warnedStart = false, false
+			digest = nil
+		default:
+			if !inSession && !warnedStart {
+				warnedStart = true
+				if !d.rotated { // file started by time-based rotation continues session of previous file
+					report.add(line, SeverityWarning, FindingMissingStart, "record #%d without session start", d.seq)
+				}
+			}
+			if digest != nil && d.checksum == "" && d.level >= slog.LevelInfo {
+				digest = nil // e.g. redacted log (see RedactLog)
+			}
+			if digest != nil {
+				digest.observe(d)
+			}
+		}
+
+		// messages of stdin/stdout are sent in order by single goroutine of each stream, but stderr records,
+		// synthetic messages and ends of streams are also written by other goroutines of recorder
+		if d.streamType != STDERR && !d.synthetic && d.payloadType != RAW_END {
+			if prev, ok := last[d.streamType]; ok && d.timestamp.Before(prev) {
+				report.add(line, SeverityWarning, FindingTimeBackwards, "record #%d of %s is %s earlier than previous one",
+					d.seq, d.streamType, prev.Sub(d.timestamp))
+			}
+			last[d.streamType] = d.timestamp
+		}
+
+		switch d.payloadType {
+		case JSON, SESSION_START, META, TRAILER, RESOURCES:
+			if d.originalSize > 0 || d.spill != "" {
+				break // payload is prefix
+			}
+			if !json.Valid(d.payload) {
+				report.add(line, SeverityCorrupt, FindingInvalidPayload, "payload of %s record #%d is not valid JSON",
+					d.payloadType, d.seq)
+			}
+		}
+	}
+	if inSession {
+		report.add(logReader.line, SeverityWarning, FindingMissingEnd, "session has no trailer (interrupted recording?)")
+	}
+	return report
+}
+
+// verifyDigest compares digest recorded in trailer with that of preceding records of session (nil if unknown)
+func verifyDigest(report *VerifyReport, line int, d *LogData, digest *logDigest) {
+	t := &Trailer{}
+	if digest == nil || json.Unmarshal(d.payload, t) != nil || t.Digest == nil {
+		return
+	}
+	if t.Digest.Algorithm != DigestAlgorithm {
+		report.add(line, SeverityWarning, FindingUnknownType, "unknown digest algorithm: %s", t.Digest.Algorithm)
+		return
+	}
+	report.Digests++
+	if actual := digest.digest(); actual.Records != t.Digest.Records {
+		report.add(line, SeverityCorrupt, FindingBadDigest, "session has %d records, but recorded %d", actual.Records,
+			t.Digest.Records)
+	} else if actual.Value != t.Digest.Value {
+		report.add(line, SeverityCorrupt, FindingBadDigest, "records of session do not match digest (reordered or replaced?)")
+	}
+}
@@ -0,0 +1,97 @@
+package recorder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func verifyTestSession(records ...LogData) []LogData {
+	start := LogData{streamType: STDERR, payloadType: SESSION_START, payload: []byte(`{"pid":1}`)}
+	trailer := LogData{streamType: STDERR, payloadType: TRAILER, payload: []byte(`{"summary":{}}`)}
+	return append(append([]LogData{start}, records...), trailer)
+}
+
+func TestVerifyLogClean(t *testing.T) {
+	log := newTestLog(verifyTestSession(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":1,"result":{}}`)},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","id":2,"res`), originalSize: 100},
+	)...)
+	report := VerifyLog(strings.NewReader(log))
+	assert.Equal(t, "clean", report.Verdict)
+	assert.Empty(t, report.Findings)
+	assert.Equal(t, 5, report.Records)
+	assert.Equal(t, 1, report.Sessions)
+	assert.Equal(t, 0, report.ExitCode())
+
+	out := bytes.Buffer{}
+	report.Format(&out)
+	assert.Equal(t, "clean: 5 records, 1 sessions, 0 findings\n", out.String())
+}
+
+func TestVerifyLogWarnings(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	log := newTestLog(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"a"}`)}, // before session start
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"b"}`)},
+		LogData{streamType: STDERR, payloadType: SESSION_START, payload: []byte(`{}`)},
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"c"}`), timestamp: base},
+		LogData{streamType: STDOUT, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"d"}`), timestamp: base},
+		LogData{streamType: STDERR, payloadType: SESSION_START, payload: []byte(`{}`)}, // previous one has no trailer
+	)
+	log += `{"time":"2024-05-01T10:00:07Z","level":"INFO","seq":7,"stream":"stdout","type":"future","payload":"x"}` + "\n"
+	report := VerifyLog(strings.NewReader(log))
+	assert.Equal(t, SeverityWarning, report.Verdict)
+	assert.Equal(t, 1, report.ExitCode())
+	assert.Equal(t, 6, report.Records)
+	assert.Equal(t, 2, report.Sessions)
+	var kinds []string
+	for _, f := range report.Findings {
+		assert.Equal(t, SeverityWarning, f.Severity)
+		kinds = append(kinds, f.Kind)
+	}
+	assert.Equal(t, []string{FindingMissingStart, FindingTimeBackwards, FindingMissingEnd, FindingUnknownType, FindingMissingEnd}, kinds)
+	assert.Equal(t, "line 4: warning: time-backwards: record #4 of stdin is 1s earlier than previous one",
+		report.Findings[1].String())
+	assert.Equal(t, 7, report.Findings[3].Line)
+}
+
+func TestVerifyLogCorrupt(t *testing.T) {
+	log := newTestLog(verifyTestSession(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":`)},
+	)...)
+	lines := strings.SplitAfter(log, "\n")
+	log = lines[0] + `{"time":"2024-05-01T10:00:01Z","seq":` + "\n" + strings.Join(lines[1:], "")
+	report := VerifyLog(strings.NewReader(log))
+	assert.Equal(t, SeverityCorrupt, report.Verdict)
+	assert.Equal(t, 2, report.ExitCode())
+	require.Len(t, report.Findings, 2)
+	assert.Equal(t, FindingBrokenLine, report.Findings[0].Kind)
+	assert.Equal(t, 2, report.Findings[0].Line)
+	assert.Equal(t, FindingInvalidPayload, report.Findings[1].Kind)
+	assert.Equal(t, "line 3: corrupt: invalid-payload: payload of json record #2 is not valid JSON", report.Findings[1].String())
+}
+
+func TestVerifyLogTruncatedGzip(t *testing.T) {
+	log := newTestLog(verifyTestSession(
+		LogData{streamType: STDIN, payloadType: JSON, payload: []byte(`{"jsonrpc":"2.0","method":"initialized"}`)},
+	)...)
+	compressed := bytes.Buffer{}
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write([]byte(log))
+	require.NoError(t, gz.Close())
+	input, err := OpenLogStream(bytes.NewReader(compressed.Bytes()[:compressed.Len()-20]))
+	require.NoError(t, err)
+	report := VerifyLog(input)
+	assert.Equal(t, SeverityCorrupt, report.Verdict)
+	var kinds []string
+	for _, f := range report.Findings {
+		kinds = append(kinds, f.Kind)
+	}
+	assert.Contains(t, kinds, FindingTruncated)
+}
@@ -0,0 +1,303 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// old trace line like "[Trace - 10:32:11 AM] Sending request 'initialize - (0)'."
+	vscodeTraceHeader = regexp.MustCompile(`^\[(Trace|Info|Warn|Error|Debug) +- +([0-9:]+(?: [AP]M)?)\] (.*)$`)
+
+	// log output channel line like "2024-05-01 10:32:11.123 [trace] Sending request 'initialize - (0)'."
+	vscodeLogHeader = regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d(?:\.\d+)?) \[(trace|debug|info|warning|error)\] (.*)$`)
+
+	// section header of concatenated log files like "==> exthost/output_logging_x/1-Rust Analyzer.log <=="
+	vscodeSectionHeader = regexp.MustCompile(`^==> (.+) <==$`)
+
+	// channel prefix of each line like "[Rust Analyzer] [Trace - 10:32:11 AM] ..."
+	vscodeChannelPrefix = regexp.MustCompile(`^\[([^\]]+)\] ?(.*)$`)
+
+	vscodeMessage = regexp.MustCompile(
+		`^(Sending|Received) (request|notification|response) '(.+?)(?: - \((.+)\))?'\.?(.*)$`)
+	vscodeRequestFailed = regexp.MustCompile(`Request failed: (.*) \((-?\d+)\)\.?$`)
+)
+
+const (
+	defaultVscodeSession  = "default"   // session of traces without channel
+	catchAllVscodeSession = "catch-all" // session of lines belonging to no trace
+)
+
+// VscodeSession is traffic of single language client found in VS Code log
+type VscodeSession struct {
+	Channel string
+	Records []*LogData
+}
+
+type vscodeEntry struct {
+	channel   string
+	timestamp time.Time
+	level     string
+	message   string
+	body      []string
+}
+
+// VscodeTraceReader splits VS Code log (LSP trace of output channels) into sessions of each channel.
+// Date is used for old trace format having only time of day
+type VscodeTraceReader struct {
+	date     time.Time
+	sessions []*VscodeSession
+	index    map[string]*VscodeSession
+	channel  string                  // channel of current section
+	prefixes map[string]bool         // channels found in line prefix
+	last     time.Time               // timestamp of last entry (used for lines without timestamp)
+	cur      map[string]*vscodeEntry // current entry of each channel (entries of channels may interleave)
+}
+
+func NewVscodeTraceReader(date time.Time) *VscodeTraceReader {
+	return &VscodeTraceReader{
+		date:     date,
+		index:    map[string]*VscodeSession{},
+		prefixes: map[string]bool{},
+		last:     date,
+		cur:      map[string]*vscodeEntry{},
+	}
+}
+
+func (r *VscodeTraceReader) session(channel string) *VscodeSession {
+	s, ok := r.index[channel]
+	if !ok {
+		s = &VscodeSession{Channel: channel}
+		r.index[channel] = s
+		r.sessions = append(r.sessions, s)
+	}
+	return s
+}
+
+// channelOfSection returns channel name from path like "1-Rust Analyzer.log"
+func channelOfSection(path string) string {
+	name := strings.TrimSuffix(filepath.Base(filepath.ToSlash(path)), filepath.Ext(path))
+	if i := strings.Index(name, "-"); i > 0 {
+		if _, err := strconv.Atoi(name[:i]); err == nil {
+			name = name[i+1:]
+		}
+	}
+	return name
+}
+
+func (r *VscodeTraceReader) parseHeader(line string) *vscodeEntry {
+	if m := vscodeTraceHeader.FindStringSubmatch(line); m != nil {
+		for _, layout := range []string{"3:04:05 PM", "15:04:05"} {
+			if t, err := time.Parse(layout, m[2]); err == nil {
+				y, mo, d := r.date.Date()
+				ts := time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), 0, r.date.Location())
+				return &vscodeEntry{timestamp: ts, level: strings.ToLower(m[1]), message: m[3]}
+			}
+		}
+	}
+	if m := vscodeLogHeader.FindStringSubmatch(line); m != nil {
+		if ts, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", m[1], r.date.Location()); err == nil {
+			return &vscodeEntry{timestamp: ts, level: m[2], message: m[3]}
+		}
+	}
+	return nil
+}
+
+func (r *VscodeTraceReader) flush(channel string) {
+	e := r.cur[channel]
+	if e == nil {
+		return
+	}
+	delete(r.cur, channel)
+	d := e.toLogData()
+	if channel == "" {
+		channel = defaultVscodeSession
+		if d.payloadType != JSON {
+			channel = catchAllVscodeSession // not a part of trace
+		}
+	}
+	s := r.session(channel)
+	s.Records = append(s.Records, d)
+}
+
+func (r *VscodeTraceReader) flushAll() {
+	channels := make([]string, 0, len(r.cur))
+	for channel := range r.cur {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels) // for stable order of sessions
+	for _, channel := range channels {
+		r.flush(channel)
+	}
+}
+
+// readLine processes single line of log
+func (r *VscodeTraceReader) readLine(line string) {
+	if m := vscodeSectionHeader.FindStringSubmatch(line); m != nil {
+		r.flushAll()
+		r.channel = channelOfSection(m[1])
+		return
+	}
+	channel := r.channel
+	if m := vscodeChannelPrefix.FindStringSubmatch(line); m != nil && !vscodeTraceHeader.MatchString(line) {
+		if r.parseHeader(m[2]) != nil {
+			r.prefixes[m[1]] = true
+		}
+		if r.prefixes[m[1]] {
+			channel = m[1]
+			line = m[2]
+		}
+	}
+	if e := r.parseHeader(line); e != nil {
+		r.flush(channel)
+		r.cur[channel] = e
+		r.last = e.timestamp
+		return
+	}
+	if e := r.cur[channel]; e != nil {
+		e.body = append(e.body, line)
+		return
+	}
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if channel == "" {
+		channel = catchAllVscodeSession
+	}
+	s := r.session(channel)
+	s.Records = append(s.Records, &LogData{
+		timestamp:   r.last,
+		streamType:  STDERR,
+		payloadType: RAW,
+		payload:     []byte(line),
+	})
+}
+
+// Read reads whole log and returns sessions in order of appearance
+func (r *VscodeTraceReader) Read(reader io.Reader) ([]*VscodeSession, error) {
+	br := bufio.NewReader(reader)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			r.readLine(strings.TrimRight(line, "\r\n"))
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.flushAll()
+	return r.sessions, nil
+}
+
+// bodyJson returns JSON value following label like "Params: " (nil if not found)
+func (e *vscodeEntry) bodyJson(label string) json.RawMessage {
+	text := strings.TrimSpace(strings.Join(e.body, "\n"))
+	if i := strings.Index(text, label); i >= 0 {
+		text = strings.TrimSpace(text[i+len(label):])
+		if json.Valid([]byte(text)) {
+			return json.RawMessage(text)
+		}
+	}
+	return nil
+}
+
+func toJsonRpcId(id string) json.RawMessage {
+	if _, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return json.RawMessage(id)
+	}
+	v, _ := json.Marshal(id)
+	return v
+}
+
+// toLogData converts trace entry to JSON-RPC message (other log entries are converted to stderr)
+func (e *vscodeEntry) toLogData() *LogData {
+	d := &LogData{timestamp: e.timestamp, streamType: STDERR, payloadType: RAW}
+	m := vscodeMessage.FindStringSubmatch(e.message)
+	if e.level != "trace" || m == nil {
+		d.payload = []byte(strings.TrimRight(strings.Join(append([]string{e.message}, e.body...), "\n"), "\n"))
+		return d
+	}
+	direction, kind, method, id, rest := m[1], m[2], m[3], m[4], m[5]
+	msg := map[string]json.RawMessage{"jsonrpc": json.RawMessage(`"2.0"`)}
+	if id != "" {
+		msg["id"] = toJsonRpcId(id)
+	}
+	switch kind {
+	case "request", "notification":
+		msg["method"], _ = json.Marshal(method)
+		if params := e.bodyJson("Params:"); params != nil {
+			msg["params"] = params
+		}
+	case "response":
+		if f := vscodeRequestFailed.FindStringSubmatch(rest); f != nil {
+			code, _ := strconv.Atoi(f[2])
+			errObj := map[string]any{"code": code, "message": f[1]}
+			if data := e.bodyJson("Error data:"); data != nil {
+				errObj["data"] = data
+			}
+			msg["error"], _ = json.Marshal(errObj)
+		} else if result := e.bodyJson("Result:"); result != nil {
+			msg["result"] = result
+		} else {
+			msg["result"] = json.RawMessage("null")
+		}
+	}
+	d.streamType = STDOUT
+	if direction == "Sending" {
+		d.streamType = STDIN
+	}
+	d.payloadType = JSON
+	d.payload, _ = json.Marshal(orderedMessage(msg))
+	return d
+}
+
+// orderedMessage keeps field order of JSON-RPC message (jsonrpc, id, method, params, result, error)
+type orderedMessage map[string]json.RawMessage
+
+func (m orderedMessage) MarshalJSON() ([]byte, error) {
+	sb := strings.Builder{}
+	sb.WriteByte('{')
+	first := true
+	for _, key := range []string{"jsonrpc", "id", "method", "params", "result", "error"} {
+		v, ok := m[key]
+		if !ok {
+			continue
+		}
+		if !first {
+			sb.WriteByte(',')
+		}
+		first = false
+		_, _ = fmt.Fprintf(&sb, "%q:", key)
+		sb.Write(v)
+	}
+	sb.WriteByte('}')
+	return []byte(sb.String()), nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// FileName returns log file name of session
+func (s *VscodeSession) FileName() string {
+	return unsafeFileChars.ReplaceAllString(s.Channel, "_") + ".log"
+}
+
+// WriteTo writes records of session as log
+func (s *VscodeSession) WriteTo(logger *slog.Logger) {
+	for i, d := range s.Records {
+		d.seq = i + 1
+		writeLogData(logger, d)
+	}
+}
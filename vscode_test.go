@@ -0,0 +1,94 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func readVscodeFixture(t *testing.T, name string) []*VscodeSession {
+	input, err := os.Open("testdata/vscode/" + name)
+	require.NoError(t, err)
+	defer func() {
+		_ = input.Close()
+	}()
+	sessions, err := NewVscodeTraceReader(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)).Read(input)
+	require.NoError(t, err)
+	return sessions
+}
+
+func sessionChannels(sessions []*VscodeSession) []string {
+	var channels []string
+	for _, s := range sessions {
+		channels = append(channels, s.Channel)
+	}
+	return channels
+}
+
+func TestVscodeTraceSections(t *testing.T) {
+	sessions := readVscodeFixture(t, "sections.log")
+	require.Equal(t, []string{"catch-all", "Rust Analyzer Language Server", "Python Language Server"},
+		sessionChannels(sessions))
+
+	assert.Equal(t, "Extension host log (sanitized)", string(sessions[0].Records[0].payload))
+
+	rust := sessions[1].Records
+	require.Len(t, rust, 6)
+	assert.Equal(t, RAW, rust[0].payloadType)
+	assert.Equal(t, "rust-analyzer server started", string(rust[0].payload))
+	assert.Equal(t, STDIN, rust[1].streamType)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"processId":1234,"rootUri":"file:///home/user/project","capabilities":{}}}`,
+		string(rust[1].payload))
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 32, 11, 0, time.UTC), rust[1].timestamp)
+	assert.Equal(t, STDOUT, rust[2].streamType)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":0,"result":{"capabilities":{"hoverProvider":true}}}`, string(rust[2].payload))
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"initialized","params":{}}`, string(rust[3].payload))
+	assert.Equal(t, STDOUT, rust[4].streamType) // server request
+	assert.Equal(t, STDIN, rust[5].streamType)  // client response
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":[null]}`, string(rust[5].payload))
+
+	python := sessions[2].Records
+	require.Len(t, python, 3)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":7,"error":{"code":-32603,"data":"stack trace","message":"Internal error"}}`,
+		string(python[1].payload))
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 32, 15, 0, time.UTC), python[2].timestamp)
+}
+
+func TestVscodeTracePrefixed(t *testing.T) {
+	sessions := readVscodeFixture(t, "prefixed.log")
+	require.Equal(t, []string{"gopls", "catch-all", "clangd"}, sessionChannels(sessions))
+
+	gopls := sessions[0].Records
+	require.Len(t, gopls, 3)
+	assert.Equal(t, `{"jsonrpc":"2.0","id":42,"method":"shutdown"}`, string(gopls[0].payload))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":42,"result":null}`, string(gopls[1].payload))
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"exit"}`, string(gopls[2].payload))
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 40, 1, 400000000, time.UTC), gopls[2].timestamp)
+
+	// lines belonging to no trace
+	require.Len(t, sessions[1].Records, 1)
+	assert.Equal(t, "Extension host started\nrandom noise without channel", string(sessions[1].Records[0].payload))
+
+	clangd := sessions[2].Records // interleaved with gopls
+	require.Len(t, clangd, 1)
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"$/progress","params":{"token":"idx","value":{"kind":"end"}}}`,
+		string(clangd[0].payload))
+}
+
+func TestVscodeSessionWrite(t *testing.T) {
+	sessions := readVscodeFixture(t, "sections.log")
+	assert.Equal(t, "Rust_Analyzer_Language_Server.log", sessions[1].FileName())
+
+	buf := bytes.Buffer{}
+	sessions[1].WriteTo(NewLogger(&buf))
+	data := readAllLogData(t, bytes.NewReader(buf.Bytes()))
+	require.Len(t, data, 6)
+	assert.Equal(t, 6, data[5].seq)
+
+	stats, err := CollectMessageStats(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second}, stats.Requests[0].Latencies)
+}
@@ -0,0 +1,366 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// opcodes of WebSocket frame (RFC 6455)
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// close codes of WebSocket which end session normally
+const (
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+	wsCloseNoStatus  = 1005 // close frame without code
+)
+
+// wsGUID is appended to key of handshake (RFC 6455 section 1.3)
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessageSize is max size of message received from server
+const wsMaxMessageSize = 1 << 30
+
+// wsPingInterval is interval of keepalive ping. Connection is closed if nothing is received for two intervals
+var wsPingInterval = 30 * time.Second
+
+// wsConn bridges Language Server speaking LSP over WebSocket (one message per text frame) to recorder.
+// Read returns received messages with Content-Length header, and Write sends each message framed by
+// Content-Length header as a text frame, so that the connection is intercepted like stdio of server
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	ch     chan<- LogData
+
+	received bytes.Buffer // framed messages not read yet (accessed by reader)
+
+	writeMutex sync.Mutex // guards frames written by writer, reader (pong, close) and pinger
+	sending    bytes.Buffer
+	parser     *ContentHeaderParser
+	length     int // Content-Length of message being sent (-1 while parsing header)
+	closeSent  bool
+
+	pingInterval time.Duration
+	lastReceived atomic.Int64 // unix nano of last received frame
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+// dialWebSocket connects to ws:// or wss:// URL and performs opening handshake.
+// Retries until timeout since spawned server may not listen yet
+func dialWebSocket(address string, timeout time.Duration, ch chan<- LogData) (io.ReadWriteCloser, error) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return nil, fmt.Errorf("websocket URL must be ws://HOST[:PORT]/PATH or wss://...: %s", address)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), map[string]string{"ws": "80", "wss": "443"}[u.Scheme])
+	}
+	deadline := time.Now().Add(timeout)
+	var conn net.Conn
+	for {
+		if u.Scheme == "wss" {
+			conn, err = tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+		} else {
+			conn, err = net.Dial("tcp", host)
+		}
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to connect %s: %v", address, err)
+		}
+		time.Sleep(dialRetryInterval)
+	}
+	_ = conn.SetDeadline(deadline)
+	reader, err := wsHandshake(conn, u)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket handshake with %s failed: %v", address, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	sendMessage(STDERR, fmt.Sprintf("connect to server: %s", address), ch)
+	c := &wsConn{conn: conn, reader: reader, ch: ch, parser: NewContentHeaderParser(), length: -1,
+		pingInterval: wsPingInterval, stop: make(chan struct{})}
+	c.lastReceived.Store(time.Now().UnixNano())
+	go c.keepalive()
+	return c, nil
+}
+
+// wsAcceptKey returns Sec-WebSocket-Accept of key
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsHandshake sends upgrade request and checks response. Returns reader of following frames
+func wsHandshake(conn net.Conn, u *url.URL) (*bufio.Reader, error) {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: http.Header{
+		"Upgrade":               {"websocket"},
+		"Connection":            {"Upgrade"},
+		"Sec-WebSocket-Key":     {key},
+		"Sec-WebSocket-Version": {"13"},
+	}}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("server responded %s instead of 101 Switching Protocols", res.Status)
+	}
+	if res.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return nil, errors.New("invalid Sec-WebSocket-Accept")
+	}
+	return reader, nil
+}
+
+// writeFrame writes a single masked frame (writeMutex must be held)
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode) // FIN
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	var mask [4]byte
+	_, _ = rand.Read(mask[:])
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := writeFull(c.conn, frame)
+	return err
+}
+
+// sendClose sends close frame once (writeMutex must be held)
+func (c *wsConn) sendClose(code int) error {
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	return c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, uint16(code)))
+}
+
+// Write splits data framed by Content-Length header into messages and sends each of them as text frame
+func (c *wsConn) Write(data []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closeSent {
+		return 0, net.ErrClosed
+	}
+	c.sending.Write(data)
+	for {
+		if c.length < 0 {
+			n, err := c.parser.Parse(&c.sending)
+			if errors.Is(err, io.EOF) {
+				return len(data), nil // header is not completed yet
+			}
+			if err != nil {
+				return 0, fmt.Errorf("cannot send to websocket: %v", err)
+			}
+			c.length = n
+		}
+		if c.sending.Len() < c.length {
+			return len(data), nil
+		}
+		if err := c.writeFrame(wsText, c.sending.Next(c.length)); err != nil {
+			return 0, err
+		}
+		c.length = -1
+	}
+}
+
+// Read returns received messages with Content-Length header. Ping is answered by pong, and close frame
+// ends stream (io.EOF if closed normally, error having close code otherwise)
+func (c *wsConn) Read(buf []byte) (int, error) {
+	for c.received.Len() == 0 {
+		message, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		if len(message) == 0 { // cannot be framed by Content-Length
+			continue
+		}
+		c.received.WriteString(contentLengthHeader + strconv.Itoa(len(message)) + "\r\n\r\n")
+		c.received.Write(message)
+	}
+	return c.received.Read(buf)
+}
+
+// readMessage reads frames until data message is completed
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		opcode, fin, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		c.lastReceived.Store(time.Now().UnixNano())
+		switch opcode {
+		case wsPing:
+			c.writeMutex.Lock()
+			err = c.writeFrame(wsPong, payload)
+			c.writeMutex.Unlock()
+			if err != nil {
+				return nil, err
+			}
+		case wsPong:
+		case wsClose:
+			code := wsCloseNoStatus
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			reply := code // echo close code
+			if code == wsCloseNoStatus {
+				reply = wsCloseNormal
+			}
+			c.writeMutex.Lock()
+			_ = c.sendClose(reply)
+			c.writeMutex.Unlock()
+			if code == wsCloseNormal || code == wsCloseGoingAway || code == wsCloseNoStatus {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("websocket closed by server with code %d: %s", code, payload[2:])
+		case wsText, wsBinary, wsContinuation:
+			if len(message)+len(payload) > wsMaxMessageSize {
+				return nil, fmt.Errorf("websocket message exceeds %s", formatSize(wsMaxMessageSize))
+			}
+			message = append(message, payload...)
+			if fin {
+				return message, nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown websocket opcode: %d", opcode)
+		}
+	}
+}
+
+// readFrame reads a single frame. Connection closed without close frame is error
+func (c *wsConn) readFrame() (byte, bool, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return 0, false, nil, c.lost(err)
+	}
+	fin, opcode, masked := head[0]&0x80 != 0, head[0]&0x0F, head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, false, nil, c.lost(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, false, nil, c.lost(err)
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessageSize {
+		return 0, false, nil, fmt.Errorf("websocket frame exceeds %s", formatSize(wsMaxMessageSize))
+	}
+	var mask [4]byte
+	if masked { // server must not mask, but accept it
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return 0, false, nil, c.lost(err)
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, false, nil, c.lost(err)
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, fin, payload, nil
+}
+
+// lost returns error of connection closed without close handshake
+func (c *wsConn) lost(err error) error {
+	if errors.Is(err, net.ErrClosed) {
+		return err // closed by recorder
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.New("websocket connection lost without close frame")
+	}
+	return err
+}
+
+// keepalive sends ping every pingInterval, and closes connection if server does not respond
+func (c *wsConn) keepalive() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, c.lastReceived.Load())) > 2*c.pingInterval {
+				sendMessage(STDERR, fmt.Sprintf("websocket: no response from server for %s, close connection",
+					2*c.pingInterval), c.ch)
+				_ = c.conn.Close()
+				return
+			}
+			c.writeMutex.Lock()
+			if !c.closeSent {
+				_ = c.writeFrame(wsPing, nil)
+			}
+			c.writeMutex.Unlock()
+		}
+	}
+}
+
+// CloseWrite starts close handshake (client disconnected). Server is expected to close connection
+func (c *wsConn) CloseWrite() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.sendClose(wsCloseNormal)
+}
+
+func (c *wsConn) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.writeMutex.Lock()
+	_ = c.sendClose(wsCloseGoingAway)
+	c.writeMutex.Unlock()
+	return c.conn.Close()
+}
@@ -0,0 +1,161 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestWsServer starts WebSocket server calling serve with server side of each connection
+// (frames are read and written by wsConn, whose masking is accepted by recorder)
+func newTestWsServer(t *testing.T, serve func(peer *wsConn)) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		_, _ = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\n\r\n", wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+		_ = rw.Flush()
+		serve(&wsConn{conn: conn, reader: rw.Reader})
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/lsp"
+}
+
+func wsCloseFrame(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+func TestWebSocketConn(t *testing.T) {
+	received := make(chan string, 4)
+	url := newTestWsServer(t, func(peer *wsConn) {
+		_, _, payload, err := peer.readFrame()
+		if !assert.NoError(t, err) {
+			return
+		}
+		received <- string(payload)
+		_ = peer.writeFrame(wsPing, []byte("keepalive"))
+		opcode, _, payload, _ := peer.readFrame()
+		received <- fmt.Sprintf("%d %s", opcode, payload)
+		// fragmented message
+		_, _ = peer.conn.Write([]byte{wsText, 3, '{', '"', 'a'})
+		_, _ = peer.conn.Write([]byte{0x80 | wsContinuation, 5, '"', ':', '1', '}', ' '})
+		_ = peer.writeFrame(wsClose, wsCloseFrame(wsCloseNormal, ""))
+		opcode, _, payload, _ = peer.readFrame()
+		received <- fmt.Sprintf("%d %x", opcode, payload)
+	})
+	ch := make(chan LogData, 8)
+	conn, err := dialWebSocket(url, time.Second, ch)
+	require.NoError(t, err)
+	defer func(conn io.ReadWriteCloser) {
+		_ = conn.Close()
+	}(conn)
+	assert.Equal(t, "connect to server: "+url, string((<-ch).payload))
+
+	message := `{"jsonrpc":"2.0","method":"initialized"}`
+	framed := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(message), message)
+	_, err = conn.Write([]byte(framed[:10])) // split in the middle of header
+	require.NoError(t, err)
+	_, err = conn.Write([]byte(framed[10:]))
+	require.NoError(t, err)
+	assert.Equal(t, message, <-received)
+
+	content, err := io.ReadAll(conn) // until close frame
+	require.NoError(t, err)
+	assert.Equal(t, "Content-Length: 8\r\n\r\n{\"a\":1} ", string(content))
+	assert.Equal(t, "10 keepalive", <-received) // pong
+	assert.Equal(t, "8 03e8", <-received)       // close is echoed
+	_, err = conn.Write([]byte(framed))
+	assert.Error(t, err)
+}
+
+func TestWebSocketAbnormalClose(t *testing.T) {
+	url := newTestWsServer(t, func(peer *wsConn) {
+		_ = peer.writeFrame(wsClose, wsCloseFrame(1011, "internal error"))
+		_, _, _, _ = peer.readFrame()
+	})
+	conn, err := dialWebSocket(url, time.Second, make(chan LogData, 8))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 16))
+	assert.EqualError(t, err, "websocket closed by server with code 1011: internal error")
+	_ = conn.Close()
+
+	url = newTestWsServer(t, func(peer *wsConn) {}) // dropped without close frame
+	conn, err = dialWebSocket(url, time.Second, make(chan LogData, 8))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 16))
+	assert.EqualError(t, err, "websocket connection lost without close frame")
+	_ = conn.Close()
+}
+
+func TestWebSocketDialError(t *testing.T) {
+	_, err := dialWebSocket("http://localhost/lsp", time.Second, make(chan LogData, 8))
+	assert.EqualError(t, err, "websocket URL must be ws://HOST[:PORT]/PATH or wss://...: http://localhost/lsp")
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	_, err = dialWebSocket(url, time.Second, make(chan LogData, 8))
+	assert.EqualError(t, err, "websocket handshake with "+url+" failed: server responded 404 Not Found instead of 101 Switching Protocols")
+}
+
+func TestRunWebSocket(t *testing.T) {
+	request := `{"jsonrpc":"2.0","id":1,"method":"shutdown"}`
+	response := `{"jsonrpc":"2.0","id":1,"result":null}`
+	url := newTestWsServer(t, func(peer *wsConn) {
+		if _, _, payload, err := peer.readFrame(); err != nil || string(payload) != request {
+			return
+		}
+		_ = peer.writeFrame(wsText, []byte(response))
+		_, _, _, _ = peer.readFrame() // close by client disconnection
+		_ = peer.writeFrame(wsClose, wsCloseFrame(wsCloseNormal, ""))
+	})
+	buf := &syncBuffer{}
+	clientOut := &syncBuffer{}
+	_, err := Run("", nil, NewLogger(buf), RunOptions{NoEnv: true, WsConnect: url, ConnectTimeout: time.Second,
+		ClientIn:  strings.NewReader(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(request), request)),
+		ClientOut: clientOut})
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(response), response), clientOut.String())
+	log := buf.String()
+	assert.Contains(t, log, `"stream":"stdin","type":"json"`)
+	assert.Contains(t, log, `"stream":"stdout","type":"json"`)
+	assert.Regexp(t, `"stream":"stdout","type":"raw_end",.*"payload":"end of stream"`, log)
+}
+
+func TestWebSocketKeepalive(t *testing.T) {
+	defer func(interval time.Duration) {
+		wsPingInterval = interval
+	}(wsPingInterval)
+	wsPingInterval = 50 * time.Millisecond
+	stuck := make(chan struct{})
+	defer close(stuck)
+	url := newTestWsServer(t, func(peer *wsConn) {
+		<-stuck // never answers ping
+	})
+	ch := make(chan LogData, 8)
+	conn, err := dialWebSocket(url, time.Second, ch)
+	require.NoError(t, err)
+	defer func(conn io.ReadWriteCloser) {
+		_ = conn.Close()
+	}(conn)
+	_, err = conn.Read(make([]byte, 16))
+	assert.Error(t, err)
+	<-ch // connect
+	assert.Equal(t, "websocket: no response from server for 100ms, close connection", string((<-ch).payload))
+}
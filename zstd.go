@@ -0,0 +1,124 @@
+package recorder
+
+import (
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultZstdLevel is compression level of json-zstd format if not specified
+const DefaultZstdLevel = 3
+
+// zstdFrameInterval is max lifetime of zstd frame. Since concatenated frames are valid zstd stream,
+// log written before crash of recorder (or machine) is readable except the last frame
+const zstdFrameInterval = time.Second
+
+// zstdWriter compresses written data to zstd frames. Each frame is finished within zstdFrameInterval
+// even if no more data is written
+type zstdWriter struct {
+	mutex    sync.Mutex
+	level    int
+	writer   io.Writer
+	interval time.Duration // max lifetime of frame
+
+	encoder *zstd.Encoder // created at the first write, and reused for following frames
+	open    bool          // whether frame is in progress
+	frame   int           // number of started frames (identifies frame finished by timer)
+	timer   *time.Timer
+	err     error // error of finished frame (reported by next write or close)
+}
+
+func newZstdWriter(writer io.Writer, level int) (*zstdWriter, error) {
+	if level == 0 {
+		level = DefaultZstdLevel
+	}
+	if level < 1 || level > 19 {
+		return nil, fmt.Errorf("compression level of zstd must be 1-19: %d", level)
+	}
+	return &zstdWriter{level: level, writer: writer, interval: zstdFrameInterval}, nil
+}
+
+func (w *zstdWriter) start() error {
+	if w.encoder == nil {
+		encoder, err := zstd.NewWriter(w.writer, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(w.level)),
+			zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		w.encoder = encoder
+	} else {
+		w.encoder.Reset(w.writer)
+	}
+	w.open = true
+	w.frame++
+	frame := w.frame
+	w.timer = time.AfterFunc(w.interval, func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if w.open && w.frame == frame {
+			w.err = w.finish()
+		}
+	})
+	return nil
+}
+
+// finish finishes current frame
+func (w *zstdWriter) finish() error {
+	w.timer.Stop()
+	w.open = false
+	if err := w.encoder.Close(); err != nil {
+		return fmt.Errorf("zstd failed: %w", err)
+	}
+	return nil
+}
+
+func (w *zstdWriter) Write(buf []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if !w.open {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	return w.encoder.Write(buf)
+}
+
+func (w *zstdWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.open {
+		w.err = w.finish()
+	}
+	return w.err
+}
+
+// zstdReader decompresses concatenated zstd frames
+type zstdReader struct {
+	decoder *zstd.Decoder
+}
+
+func newZstdReader(reader io.Reader) (*zstdReader, error) {
+	decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{decoder: decoder}, nil
+}
+
+func (r *zstdReader) Read(buf []byte) (int, error) {
+	n, err := r.decoder.Read(buf)
+	if err != nil && err != io.EOF {
+		return n, fmt.Errorf("zstd failed: %w", err)
+	}
+	return n, err
+}
+
+func (r *zstdReader) Close() error {
+	r.decoder.Close()
+	return nil
+}
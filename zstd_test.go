@@ -0,0 +1,60 @@
+package recorder
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decompressZstd(t *testing.T, data []byte) string {
+	r, err := newZstdReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer func(r *zstdReader) {
+		_ = r.Close()
+	}(r)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestZstdWriterFrames(t *testing.T) {
+	buf := bytes.Buffer{}
+	w, err := newZstdWriter(&buf, 0)
+	require.NoError(t, err)
+	w.interval = 50 * time.Millisecond
+
+	_, err = w.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond) // first frame is finished without further write
+	w.mutex.Lock()
+	assert.Equal(t, "hello\n", decompressZstd(t, buf.Bytes()))
+	w.mutex.Unlock()
+
+	_, err = w.Write([]byte("world\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "hello\nworld\n", decompressZstd(t, buf.Bytes())) // concatenated frames
+}
+
+func TestZstdLevel(t *testing.T) {
+	_, err := newZstdWriter(io.Discard, 20)
+	assert.EqualError(t, err, "compression level of zstd must be 1-19: 20")
+	_, _, err = NewFormatLogger(io.Discard, LogFormatJSONGzip, 10)
+	assert.EqualError(t, err, "compression level of gzip must be 1-9: 10")
+}
+
+func TestZstdReaderBroken(t *testing.T) {
+	r, err := newZstdReader(strings.NewReader("garbage"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "zstd failed")
+}
+
+func TestConvertLogZstd(t *testing.T) {
+	zst := convertLog(t, formatTestLog, LogFormatJSON, LogFormatJSONZstd)
+	assert.Equal(t, formatTestLog, convertLog(t, zst, LogFormatJSONZstd, LogFormatJSON))
+}